@@ -0,0 +1,553 @@
+// Package client calls the wowee-link HTTP API.
+//
+//	c := client.New("https://wowee.link", client.WithAPIKey(key))
+//	shortened, err := c.Shorten(ctx, client.ShortenRequest{URL: "https://example.com"})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiPrefix = "/api/v1"
+
+	defaultTimeout    = 10 * time.Second
+	defaultMaxRetries = 3
+	defaultBackoff    = 200 * time.Millisecond
+	maxBackoff        = 5 * time.Second
+	maxErrorBodyBytes = 4 << 10
+)
+
+// Client is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	apiKey     string
+	domain     string
+	httpClient *http.Client
+	maxRetries int
+	backoff    time.Duration
+}
+
+type Option func(*Client)
+
+// WithAPIKey authenticates requests with an organization or admin key.
+// Without one, requests act in the shared namespace.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithDomain makes requests act in the code space of a verified custom
+// domain of the organization: links are shortened under it and codes are
+// looked up among its links.
+func WithDomain(hostname string) Option {
+	return func(c *Client) {
+		c.domain = hostname
+	}
+}
+
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how many times a failed request is retried, 3 by
+// default. The wait between attempts starts at backoff and doubles.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.backoff = backoff
+	}
+}
+
+// New returns a client for the server at baseURL, such as
+// https://wowee.link.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		maxRetries: defaultMaxRetries,
+		backoff:    defaultBackoff,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// ShortURL returns the address a code redirects from.
+func (c *Client) ShortURL(code string) string {
+	return c.baseURL + "/" + url.PathEscape(code)
+}
+
+// APIError is a response the server answered with an error status. Code
+// is the error code of the response, such as not_found or alias_taken,
+// and Details what the server added about it, such as the invalid field.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Details    map[string]interface{}
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("wowee-link: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("wowee-link: %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from the server.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Shorten creates a link, or returns the existing link of a URL that the
+// client's API key already shortened unless request.ForceNew is set.
+func (c *Client) Shorten(ctx context.Context, request ShortenRequest) (ShortenResponse, error) {
+	var response ShortenResponse
+	err := c.do(ctx, http.MethodPost, "/shorten", nil, request, &response)
+	return response, err
+}
+
+// ValidateRoutingRules checks a set of routing rules without storing them
+// and returns them as a link would store them. An invalid rule yields an
+// *APIError naming its field in Details.
+func (c *Client) ValidateRoutingRules(ctx context.Context, rules []RoutingRule) ([]RoutingRule, error) {
+	var response routingRulesBody
+	err := c.do(ctx, http.MethodPost, "/routing-rules/validate", nil, routingRulesBody{RoutingRules: rules}, &response)
+	return response.RoutingRules, err
+}
+
+// AliasAvailable checks whether Shorten would accept alias, without
+// creating a link.
+func (c *Client) AliasAvailable(ctx context.Context, alias string) (AliasAvailability, error) {
+	var availability AliasAvailability
+	err := c.do(ctx, http.MethodGet, "/alias-available", url.Values{"alias": {alias}}, nil, &availability)
+	return availability, err
+}
+
+// Stats returns a link with its counts.
+func (c *Client) Stats(ctx context.Context, code string) (Link, error) {
+	var link Link
+	err := c.do(ctx, http.MethodGet, "/stats/"+url.PathEscape(code), nil, nil, &link)
+	return link, err
+}
+
+// Resolve returns the destination of a code. Like a visit, it counts a
+// click.
+func (c *Client) Resolve(ctx context.Context, code string) (string, error) {
+	var response getURLResponse
+	err := c.do(ctx, http.MethodGet, "/get-link/"+url.PathEscape(code), nil, nil, &response)
+	return response.URL, err
+}
+
+// List returns a page of links.
+func (c *Client) List(ctx context.Context, opts ListOptions) (ListLinksResponse, error) {
+	query := url.Values{}
+	if opts.Sort != "" {
+		query.Set("sort", opts.Sort)
+	}
+	if opts.Descending != nil {
+		if *opts.Descending {
+			query.Set("order", "desc")
+		} else {
+			query.Set("order", "asc")
+		}
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		query.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	if opts.CreatedFrom != nil {
+		query.Set("created_from", opts.CreatedFrom.Format(time.RFC3339))
+	}
+	if opts.CreatedTo != nil {
+		query.Set("created_to", opts.CreatedTo.Format(time.RFC3339))
+	}
+	if opts.MinClicks > 0 {
+		query.Set("min_clicks", strconv.Itoa(opts.MinClicks))
+	}
+	if opts.Tag != "" {
+		query.Set("tag", opts.Tag)
+	}
+	if opts.FolderID > 0 {
+		query.Set("folder_id", strconv.Itoa(opts.FolderID))
+		if opts.Subfolders {
+			query.Set("subfolders", "true")
+		}
+	}
+	if opts.Archived {
+		query.Set("archived", "true")
+	}
+	if opts.Deleted {
+		query.Set("deleted", "true")
+	}
+
+	path := "/links"
+	if opts.Broken {
+		path = "/links/broken"
+	}
+
+	var response ListLinksResponse
+	err := c.do(ctx, http.MethodGet, path, query, nil, &response)
+	return response, err
+}
+
+// Search returns a page of the links whose code, URL, title, description,
+// notes or tags contain every word of query, best matches first. A limit
+// or offset of 0 is left to the server's defaults.
+func (c *Client) Search(ctx context.Context, query string, limit int, offset int) (ListLinksResponse, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	if offset > 0 {
+		params.Set("offset", strconv.Itoa(offset))
+	}
+
+	var response ListLinksResponse
+	err := c.do(ctx, http.MethodGet, "/links/search", params, nil, &response)
+	return response, err
+}
+
+// Tags returns the tags of the namespace, most used first.
+func (c *Client) Tags(ctx context.Context) ([]TagCount, error) {
+	var response tagsResponse
+	err := c.do(ctx, http.MethodGet, "/tags", nil, nil, &response)
+	return response.Tags, err
+}
+
+// Folders returns the folders of the namespace, ordered by path.
+func (c *Client) Folders(ctx context.Context) ([]Folder, error) {
+	var response foldersResponse
+	err := c.do(ctx, http.MethodGet, "/folders", nil, nil, &response)
+	return response.Folders, err
+}
+
+// CreateFolder creates a folder, nested in parentID unless it is 0.
+func (c *Client) CreateFolder(ctx context.Context, name string, parentID int) (Folder, error) {
+	var folder Folder
+	err := c.do(ctx, http.MethodPost, "/folders", nil, createFolderRequest{Name: name, ParentID: parentID}, &folder)
+	return folder, err
+}
+
+// MoveLinks files the links of codes in a folder, or takes them out of
+// their folder when folderID is 0. It returns how many links were moved.
+func (c *Client) MoveLinks(ctx context.Context, codes []string, folderID int) (int64, error) {
+	var response moveLinksResponse
+	err := c.do(ctx, http.MethodPost, "/links/move", nil, moveLinksRequest{Codes: codes, FolderID: folderID}, &response)
+	return response.Moved, err
+}
+
+// Delete deletes a link. Its clicks are kept unless deleteClicks is set.
+func (c *Client) Delete(ctx context.Context, code string, deleteClicks bool) error {
+	query := url.Values{}
+	if deleteClicks {
+		query.Set("clicks", "delete")
+	}
+
+	return c.do(ctx, http.MethodDelete, "/links/"+url.PathEscape(code), query, nil, nil)
+}
+
+// History returns a page of the changes made to a link, newest first. A
+// limit or offset of 0 is left to the server's defaults.
+func (c *Client) History(ctx context.Context, code string, limit int, offset int) (LinkHistoryResponse, error) {
+	params := url.Values{}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	if offset > 0 {
+		params.Set("offset", strconv.Itoa(offset))
+	}
+
+	var response LinkHistoryResponse
+	err := c.do(ctx, http.MethodGet, "/links/"+url.PathEscape(code)+"/history", params, nil, &response)
+	return response, err
+}
+
+// Archive archives a link: it stops redirecting but keeps its stats.
+func (c *Client) Archive(ctx context.Context, code string) (Link, error) {
+	var link Link
+	err := c.do(ctx, http.MethodPost, "/links/"+url.PathEscape(code)+"/archive", nil, nil, &link)
+	return link, err
+}
+
+// Restore brings back an archived link, or a deleted one within the
+// server's restore window.
+func (c *Client) Restore(ctx context.Context, code string) (Link, error) {
+	var link Link
+	err := c.do(ctx, http.MethodPost, "/links/"+url.PathEscape(code)+"/restore", nil, nil, &link)
+	return link, err
+}
+
+// Aliases returns the aliases of a link, oldest first.
+func (c *Client) Aliases(ctx context.Context, code string) ([]LinkAlias, error) {
+	var response aliasesResponse
+	err := c.do(ctx, http.MethodGet, "/links/"+url.PathEscape(code)+"/aliases", nil, nil, &response)
+	return response.Aliases, err
+}
+
+// AddAlias makes a link answer to alias as well, counting its clicks
+// towards the link.
+func (c *Client) AddAlias(ctx context.Context, code string, alias string) (LinkAlias, error) {
+	var response LinkAlias
+	err := c.do(ctx, http.MethodPost, "/links/"+url.PathEscape(code)+"/aliases", nil, addAliasRequest{Alias: alias}, &response)
+	return response, err
+}
+
+// RemoveAlias removes an alias from a link.
+func (c *Client) RemoveAlias(ctx context.Context, code string, alias string) error {
+	return c.do(ctx, http.MethodDelete, "/links/"+url.PathEscape(code)+"/aliases/"+url.PathEscape(alias), nil, nil, nil)
+}
+
+// CodeClicks returns the clicks of a link per code it was visited under,
+// most clicked first.
+func (c *Client) CodeClicks(ctx context.Context, code string) ([]CodeCount, error) {
+	var response codesResponse
+	err := c.do(ctx, http.MethodGet, "/stats/"+url.PathEscape(code)+"/codes", nil, nil, &response)
+	return response.Codes, err
+}
+
+// Domains returns the custom domains of the organization by hostname.
+func (c *Client) Domains(ctx context.Context) ([]Domain, error) {
+	var response domainsResponse
+	err := c.do(ctx, http.MethodGet, "/org/domains", nil, nil, &response)
+	return response.Domains, err
+}
+
+// AddDomain registers a custom domain. Links are only served on it once
+// VerifyDomain succeeds.
+func (c *Client) AddDomain(ctx context.Context, hostname string) (Domain, error) {
+	var domain Domain
+	err := c.do(ctx, http.MethodPost, "/org/domains", nil, createDomainRequest{Hostname: hostname}, &domain)
+	return domain, err
+}
+
+// VerifyDomain checks the TXT record or HTTP token of a custom domain.
+func (c *Client) VerifyDomain(ctx context.Context, id int) (Domain, error) {
+	var domain Domain
+	err := c.do(ctx, http.MethodPost, "/org/domains/"+strconv.Itoa(id)+"/verify", nil, nil, &domain)
+	return domain, err
+}
+
+// RemoveDomain removes a custom domain no links were issued under.
+func (c *Client) RemoveDomain(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/org/domains/"+strconv.Itoa(id), nil, nil, nil)
+}
+
+// Export streams the links or the daily clicks export, as chosen by kind,
+// to w. Exports are not retried, since part of one may already have been
+// written.
+func (c *Client) Export(ctx context.Context, kind string, opts ExportOptions, w io.Writer) error {
+	if kind != ExportLinks && kind != ExportClicks {
+		return fmt.Errorf("wowee-link: unknown export %q", kind)
+	}
+
+	query := url.Values{}
+	if opts.Format != "" {
+		query.Set("format", opts.Format)
+	}
+	if opts.Code != "" {
+		query.Set("code", opts.Code)
+	}
+	if opts.From != "" {
+		query.Set("from", opts.From)
+	}
+	if opts.To != "" {
+		query.Set("to", opts.To)
+	}
+	if opts.CreatedFrom != nil {
+		query.Set("created_from", opts.CreatedFrom.Format(time.RFC3339))
+	}
+	if opts.CreatedTo != nil {
+		query.Set("created_to", opts.CreatedTo.Format(time.RFC3339))
+	}
+	if c.domain != "" {
+		query.Set("domain", c.domain)
+	}
+
+	target := c.baseURL + apiPrefix + "/export/" + kind
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+
+	// Exports stream for as long as they take, past the client timeout.
+	httpClient := *c.httpClient
+	httpClient.Timeout = 0
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return readAPIError(resp)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// do sends a request and decodes the JSON response into out. Requests that
+// failed in transit, 502, 503 and 504 responses are retried when the
+// method is GET; 429s are retried whenever the server sends Retry-After,
+// since it did not act on the request.
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, in interface{}, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.domain != "" {
+		if query == nil {
+			query = url.Values{}
+		}
+		query.Set("domain", c.domain)
+	}
+
+	target := c.baseURL + apiPrefix + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	wait := c.backoff
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := c.send(ctx, method, target, body, out)
+		if err == nil || attempt >= c.maxRetries || !retryable(method, err, retryAfter) {
+			return err
+		}
+
+		delay := wait
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		if delay > maxBackoff {
+			delay = maxBackoff
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		wait *= 2
+	}
+}
+
+// send makes one attempt. It returns the Retry-After of a 429 alongside
+// its error.
+func (c *Client) send(ctx context.Context, method string, target string, body []byte, out interface{}) (time.Duration, error) {
+	req, err := c.newRequest(ctx, method, target, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		if out == nil || resp.StatusCode == http.StatusNoContent {
+			return 0, nil
+		}
+		return 0, json.NewDecoder(resp.Body).Decode(out)
+	}
+
+	var retryAfter time.Duration
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return retryAfter, readAPIError(resp)
+}
+
+func (c *Client) newRequest(ctx context.Context, method string, target string, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	return req, nil
+}
+
+func readAPIError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+	apiErr := &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+
+	var response errorResponse
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && json.Unmarshal(data, &response) == nil {
+		apiErr.Code = response.Error.Code
+		apiErr.Message = response.Error.Message
+		apiErr.Details = response.Error.Details
+	}
+
+	return apiErr
+}
+
+func retryable(method string, err error, retryAfter time.Duration) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		// Context errors are final; other transport errors are retried for
+		// reads only, since a write may have reached the server.
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return false
+		}
+		return method == http.MethodGet
+	}
+
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests:
+		return retryAfter > 0
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return method == http.MethodGet
+	default:
+		return false
+	}
+}
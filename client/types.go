@@ -0,0 +1,350 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ShortenRequest mirrors the body of POST /api/v1/shorten. Zero fields are
+// left to the server's defaults.
+type ShortenRequest struct {
+	URL              string     `json:"url"`
+	Alias            string     `json:"alias,omitempty"`
+	TTLSeconds       int64      `json:"ttl_seconds,omitempty"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	RedirectStatus   int        `json:"redirect_status,omitempty"`
+	CodeLength       int        `json:"code_length,omitempty"`
+	MaxClicks        int        `json:"max_clicks,omitempty"`
+	TrackingDisabled bool       `json:"tracking_disabled,omitempty"`
+	ForwardQuery     bool       `json:"forward_query,omitempty"`
+	UTMSource        string     `json:"utm_source,omitempty"`
+	UTMMedium        string     `json:"utm_medium,omitempty"`
+	UTMCampaign      string     `json:"utm_campaign,omitempty"`
+	// Destinations split the visits of the link; URL may then be empty.
+	Destinations       []Destination  `json:"destinations,omitempty"`
+	StickyDestinations bool           `json:"sticky_destinations,omitempty"`
+	GeoTargets         []GeoTarget    `json:"geo_targets,omitempty"`
+	DeviceTargets      []DeviceTarget `json:"device_targets,omitempty"`
+	RoutingRules       []RoutingRule  `json:"routing_rules,omitempty"`
+	// Outside of ActiveFrom and ActiveUntil, once expired, over its click
+	// limit or broken, the link sends visitors to FallbackURL.
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+	FallbackURL string     `json:"fallback_url,omitempty"`
+	// Tags group the link with others. They are added to the tags of a
+	// URL that was already shortened.
+	Tags []string `json:"tags,omitempty"`
+	// FolderID files the link in a folder. A URL that was already
+	// shortened is moved to it.
+	FolderID int `json:"folder_id,omitempty"`
+	// Description says what the link is for and Notes keep internal
+	// context about it. They replace those of a URL that was already
+	// shortened.
+	Description string `json:"description,omitempty"`
+	Notes       string `json:"notes,omitempty"`
+	// ForceNew creates a new link even when the API key already shortened
+	// the URL.
+	ForceNew bool `json:"force_new,omitempty"`
+	// Domain issues the link under a verified custom domain of the
+	// organization.
+	Domain string `json:"domain,omitempty"`
+	// CaptchaToken is needed to shorten without an API key on servers
+	// that require a CAPTCHA.
+	CaptchaToken string `json:"captcha_token,omitempty"`
+}
+
+// Destination is one of the URLs a link splits its visits between, in
+// proportion to Weight. A weight of 0 pauses it. ID is assigned by the
+// server.
+type Destination struct {
+	ID     int    `json:"id,omitempty"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// GeoTarget sends the visitors from a country, or else a continent, to
+// URL. Country is an ISO 3166-1 alpha-2 code and Continent one of AF, AN,
+// AS, EU, NA, OC and SA; a target has one of them.
+type GeoTarget struct {
+	Country   string `json:"country,omitempty"`
+	Continent string `json:"continent,omitempty"`
+	URL       string `json:"url"`
+}
+
+// DeviceTarget sends the visitors on an operating system, or else a device
+// type, to URL, which may use the custom scheme of an app. OS is one of
+// ios, android, windows, macos, linux and chromeos, and Device one of
+// desktop, mobile and tablet; a target has one of them.
+type DeviceTarget struct {
+	OS     string `json:"os,omitempty"`
+	Device string `json:"device,omitempty"`
+	URL    string `json:"url"`
+}
+
+// RoutingRule sends the visits that meet every condition it sets to URL.
+// Rules are checked in order, before device and geo targets, and the
+// first one a visit meets wins. Within a condition any value matches.
+// Languages are tags such as pt or pt-br, Referrers are hosts, which match
+// their subdomains, or direct, and Weekdays are mon to sun. TimeFrom and
+// TimeTo are times of day as HH:MM in TimeZone, UTC by default.
+type RoutingRule struct {
+	Countries  []string `json:"countries,omitempty"`
+	Continents []string `json:"continents,omitempty"`
+	OS         []string `json:"os,omitempty"`
+	Devices    []string `json:"devices,omitempty"`
+	Languages  []string `json:"languages,omitempty"`
+	Referrers  []string `json:"referrers,omitempty"`
+	Weekdays   []string `json:"weekdays,omitempty"`
+	TimeFrom   string   `json:"time_from,omitempty"`
+	TimeTo     string   `json:"time_to,omitempty"`
+	TimeZone   string   `json:"time_zone,omitempty"`
+	URL        string   `json:"url"`
+}
+
+// ShortenResponse carries the code of the link and the full URL it
+// redirects from.
+type ShortenResponse struct {
+	Code        string     `json:"code"`
+	ShortURL    string     `json:"short_url"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	Tags        []string   `json:"tags"`
+	ElapsedTime int64      `json:"elapsed_time"`
+}
+
+// AliasAvailability tells whether an alias is free. Reason is
+// alias_invalid, alias_reserved or alias_taken when it is not, and
+// Suggestions lists similar aliases that are free.
+type AliasAvailability struct {
+	Alias       string   `json:"alias"`
+	Available   bool     `json:"available"`
+	Reason      string   `json:"reason,omitempty"`
+	Message     string   `json:"message,omitempty"`
+	Suggestions []string `json:"suggestions"`
+}
+
+type Link struct {
+	ID               int        `json:"id"`
+	Code             string     `json:"code"`
+	URL              string     `json:"url"`
+	CreatedAt        time.Time  `json:"created_at"`
+	AttemptCount     int        `json:"attempt_count"`
+	ClickCount       int        `json:"click_count"`
+	BotClicks        int        `json:"bot_clicks"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	RedirectStatus   int        `json:"redirect_status"`
+	DeletedAt        *time.Time `json:"deleted_at"`
+	ArchivedAt       *time.Time `json:"archived_at"`
+	UpdatedAt        *time.Time `json:"updated_at"`
+	MaxClicks        *int       `json:"max_clicks"`
+	Title            *string    `json:"title"`
+	TrackingDisabled bool       `json:"tracking_disabled"`
+	ForwardQuery     bool       `json:"forward_query"`
+	UTMSource        *string    `json:"utm_source"`
+	UTMMedium        *string    `json:"utm_medium"`
+	UTMCampaign      *string    `json:"utm_campaign"`
+	// Destinations is empty for links with a single destination.
+	Destinations       []Destination  `json:"destinations"`
+	StickyDestinations bool           `json:"sticky_destinations"`
+	GeoTargets         []GeoTarget    `json:"geo_targets"`
+	DeviceTargets      []DeviceTarget `json:"device_targets"`
+	RoutingRules       []RoutingRule  `json:"routing_rules"`
+	ActiveFrom         *time.Time     `json:"active_from"`
+	ActiveUntil        *time.Time     `json:"active_until"`
+	FallbackURL        *string        `json:"fallback_url"`
+	// CheckStatus is what the destination responded with when the health
+	// checker last checked it, nil when it did not respond. BrokenSince is
+	// set once it has failed several checks in a row.
+	CheckedAt     *time.Time `json:"checked_at"`
+	CheckStatus   *int       `json:"check_status"`
+	CheckFailures int        `json:"check_failures"`
+	BrokenSince   *time.Time `json:"broken_since"`
+	Tags          []string   `json:"tags"`
+	FolderID      *int       `json:"folder_id"`
+	Description   *string    `json:"description"`
+	Notes         *string    `json:"notes"`
+	DomainID      int        `json:"domain_id"`
+}
+
+// ListOptions filters and orders List. Zero fields are left to the
+// server's defaults. Broken only lists the links the health checker found
+// dead, Tag the links with the tag and FolderID the links filed in the
+// folder, or in the folders nested in it too with Subfolders. Archived
+// and Deleted list the archived or deleted links instead of the live ones.
+type ListOptions struct {
+	Sort        string
+	Descending  *bool
+	Limit       int
+	Offset      int
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	MinClicks   int
+	Broken      bool
+	Tag         string
+	FolderID    int
+	Subfolders  bool
+	Archived    bool
+	Deleted     bool
+}
+
+// TagCount is a tag with how many links have it and the clicks they
+// received.
+type TagCount struct {
+	Tag    string `json:"tag"`
+	Links  int64  `json:"links"`
+	Clicks int64  `json:"clicks"`
+}
+
+// Folder groups links. Path is the names of the folder and its parents,
+// separated by slashes.
+type Folder struct {
+	ID        int        `json:"id"`
+	ParentID  *int       `json:"parent_id"`
+	Name      string     `json:"name"`
+	Path      string     `json:"path"`
+	LinkCount int64      `json:"link_count"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+type ListLinksResponse struct {
+	Links  []Link `json:"links"`
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+// LinkEvent is a change made to a link. MemberID and KeyID are unset for
+// changes made without an organization API key.
+type LinkEvent struct {
+	ID        int64                 `json:"id"`
+	OrgID     int                   `json:"org_id"`
+	LinkID    int                   `json:"link_id"`
+	Code      string                `json:"code"`
+	Action    string                `json:"action"`
+	MemberID  *int                  `json:"member_id"`
+	KeyID     *int                  `json:"key_id"`
+	Changes   map[string]LinkChange `json:"changes"`
+	CreatedAt time.Time             `json:"created_at"`
+}
+
+// LinkChange holds the JSON values of a field before and after a change.
+type LinkChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+type LinkHistoryResponse struct {
+	Events []LinkEvent `json:"events"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+// LinkAlias is a further code a link answers to.
+type LinkAlias struct {
+	ID        int       `json:"id"`
+	Code      string    `json:"code"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CodeCount is the number of clicks a link received under one of its
+// codes.
+type CodeCount struct {
+	Code   string `json:"code"`
+	Clicks int64  `json:"clicks"`
+}
+
+// Domain is a custom domain of the organization. Until it is verified,
+// Verification tells how to verify it.
+type Domain struct {
+	ID           int                 `json:"id"`
+	Hostname     string              `json:"hostname"`
+	VerifiedAt   *time.Time          `json:"verified_at"`
+	CreatedAt    time.Time           `json:"created_at"`
+	Verification *DomainVerification `json:"verification"`
+}
+
+// DomainVerification is a TXT record named DNSName holding Token, or the
+// domain pointing at the server, which then answers HTTPURL with Token.
+type DomainVerification struct {
+	Token   string `json:"token"`
+	DNSName string `json:"dns_name"`
+	DNSType string `json:"dns_type"`
+	HTTPURL string `json:"http_url"`
+}
+
+const (
+	ExportLinks  = "links"
+	ExportClicks = "clicks"
+)
+
+// ExportOptions narrows an export. Format is csv or ndjson, csv by
+// default. Code, From and To apply to the clicks export, as YYYY-MM-DD
+// dates, and CreatedFrom and CreatedTo to the links export.
+type ExportOptions struct {
+	Format      string
+	Code        string
+	From        string
+	To          string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+}
+
+type getURLResponse struct {
+	URL string `json:"url"`
+}
+
+type routingRulesBody struct {
+	RoutingRules []RoutingRule `json:"routing_rules"`
+}
+
+type tagsResponse struct {
+	Tags []TagCount `json:"tags"`
+}
+
+type foldersResponse struct {
+	Folders []Folder `json:"folders"`
+}
+
+type aliasesResponse struct {
+	Aliases []LinkAlias `json:"aliases"`
+}
+
+type addAliasRequest struct {
+	Alias string `json:"alias"`
+}
+
+type codesResponse struct {
+	Codes []CodeCount `json:"codes"`
+}
+
+type domainsResponse struct {
+	Domains []Domain `json:"domains"`
+}
+
+type createDomainRequest struct {
+	Hostname string `json:"hostname"`
+}
+
+type createFolderRequest struct {
+	Name     string `json:"name"`
+	ParentID int    `json:"parent_id,omitempty"`
+}
+
+type moveLinksRequest struct {
+	Codes    []string `json:"codes"`
+	FolderID int      `json:"folder_id"`
+}
+
+type moveLinksResponse struct {
+	Moved int64 `json:"moved"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Code    string                 `json:"code"`
+		Message string                 `json:"message"`
+		Details map[string]interface{} `json:"details"`
+	} `json:"error"`
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// adminDisableReason is the quarantine reason of links an admin
+	// disabled without giving one.
+	adminDisableReason = "disabled by an admin"
+	// bannedDomainReason is the quarantine reason of the links to a
+	// banned domain.
+	bannedDomainReason = "destination domain banned"
+
+	defaultKeyActivityWindow = 24 * time.Hour
+)
+
+// AdminLinkFilter selects a page of the live links of every organization
+// for ListAllLinks, newest first.
+type AdminLinkFilter struct {
+	// OrgID keeps the links of one organization, 0 being the shared
+	// namespace.
+	OrgID *int
+	// Domain keeps the links whose URL is on the domain or a subdomain.
+	Domain string
+	// Flagged keeps the links that are quarantined or have open reports.
+	Flagged     bool
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	Limit       int
+	Offset      int
+}
+
+// KeyActivity counts the links an API key created over a period, deleted
+// ones included, and the clicks they got.
+type KeyActivity struct {
+	KeyID     int        `db:"key_id" json:"key_id"`
+	OrgID     int        `db:"org_id" json:"org_id"`
+	OrgSlug   string     `db:"org_slug" json:"org_slug"`
+	Name      string     `db:"name" json:"name"`
+	Prefix    string     `db:"prefix" json:"prefix"`
+	RevokedAt *time.Time `db:"revoked_at" json:"revoked_at"`
+	Links     int        `db:"links" json:"links"`
+	Clicks    int        `db:"clicks" json:"clicks"`
+}
+
+// AdminLink is a link as the admin sees it, with who owns it.
+type AdminLink struct {
+	Link
+	OrgID int `json:"org_id"`
+	KeyID int `json:"key_id,omitempty"`
+}
+
+type AdminLinksResponse struct {
+	Links       []AdminLink `json:"links"`
+	Total       int         `json:"total"`
+	Limit       int         `json:"limit"`
+	Offset      int         `json:"offset"`
+	ElapsedTime int64       `json:"elapsed_time"`
+}
+
+type DisableLinkRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+type BanDomainRequest struct {
+	Domain string `json:"domain"`
+}
+
+// BanDomainResponse is the block rule of a banned domain with the number
+// of links to it that were disabled.
+type BanDomainResponse struct {
+	Rule          DomainRule `json:"rule"`
+	DisabledLinks int        `json:"disabled_links"`
+	ElapsedTime   int64      `json:"elapsed_time"`
+}
+
+type KeyActivityResponse struct {
+	From        time.Time     `json:"from"`
+	To          time.Time     `json:"to"`
+	Keys        []KeyActivity `json:"keys"`
+	ElapsedTime int64         `json:"elapsed_time"`
+}
+
+// adminLinkConditions matches the links of filter. like is the
+// case-insensitive LIKE of the dialect, and bind adds a value to the
+// arguments of the query and returns its placeholder.
+func adminLinkConditions(filter AdminLinkFilter, like string, bind func(value interface{}) string) string {
+	conditions := []string{"deleted_at IS NULL"}
+
+	if filter.OrgID != nil {
+		conditions = append(conditions, "org_id = "+bind(*filter.OrgID))
+	}
+
+	if filter.Domain != "" {
+		conditions = append(conditions, destinationDomainCondition(filter.Domain, like, bind))
+	}
+
+	if filter.Flagged {
+		conditions = append(conditions, "(quarantined_at IS NOT NULL OR id IN (SELECT link_id FROM link_reports WHERE resolved_at IS NULL))")
+	}
+
+	if filter.CreatedFrom != nil {
+		conditions = append(conditions, "created_at >= "+bind(*filter.CreatedFrom))
+	}
+
+	if filter.CreatedTo != nil {
+		conditions = append(conditions, "created_at < "+bind(*filter.CreatedTo))
+	}
+
+	return strings.Join(conditions, " AND ")
+}
+
+// destinationDomainCondition matches the http and https URLs on domain or
+// one of its subdomains. Normalized domains hold no LIKE wildcards. A
+// subdomain pattern may also match a path ending in the domain, which is
+// why banning a domain checks the host of every match again.
+func destinationDomainCondition(domain string, like string, bind func(value interface{}) string) string {
+	var patterns []string
+	for _, scheme := range []string{"http://", "https://"} {
+		for _, host := range []string{domain, "%." + domain} {
+			for _, rest := range []string{"", "/%", "?%", "#%", ":%"} {
+				patterns = append(patterns, "url "+like+" "+bind(scheme+host+rest))
+			}
+		}
+	}
+
+	return "(" + strings.Join(patterns, " OR ") + ")"
+}
+
+func adminLinks(links []Link) []AdminLink {
+	page := make([]AdminLink, len(links))
+	for i, link := range links {
+		page[i] = AdminLink{Link: link, OrgID: link.OrgID, KeyID: link.KeyID}
+	}
+
+	return page
+}
+
+// disableLink quarantines a link for good and announces it.
+func disableLink(ctx context.Context, admin AdminStore, cache LinkCache, webhooks *WebhookDispatcher, linkID int, reason string) (Link, error) {
+	link, err := admin.DisableLink(ctx, linkID, reason)
+	if err != nil {
+		return link, err
+	}
+
+	cache.Delete(ctx, link.OrgID, link.DomainID, link.Code)
+
+	data := newWebhookEventData(link)
+	data.Reason = &reason
+	webhooks.Emit(link.OrgID, webhookLinkQuarantined, data)
+
+	return link, nil
+}
+
+// disableLinksToDomain disables the live links whose URL is on domain,
+// leaving those already disabled, and returns how many it disabled.
+func disableLinksToDomain(ctx context.Context, admin AdminStore, cache LinkCache, webhooks *WebhookDispatcher, domain string) (int, error) {
+	filter := AdminLinkFilter{Domain: domain, Limit: maxListLimit}
+
+	disabled := 0
+	for {
+		page, _, err := admin.ListAllLinks(ctx, filter)
+		if err != nil {
+			return disabled, err
+		}
+
+		for _, link := range page {
+			if link.QuarantineReviewedAt != nil || !matchesDomain(urlHost(link.URL), []string{domain}) {
+				continue
+			}
+
+			_, err := disableLink(ctx, admin, cache, webhooks, link.ID, bannedDomainReason)
+			if err == ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return disabled, err
+			}
+			disabled++
+		}
+
+		// Disabled links stay listed, so the offsets do not shift.
+		if len(page) < filter.Limit {
+			return disabled, nil
+		}
+		filter.Offset += filter.Limit
+	}
+}
+
+// ListAllLinksHandler lists the links of every organization for the
+// admin, filtered by ?org (a slug, or an empty one for the shared
+// namespace), the ?destination domain, ?flagged=true and the
+// ?created_from and ?created_to range. ?domain is left to pick the custom
+// domain of requests, as everywhere in the API.
+func ListAllLinksHandler(admin AdminStore, orgs OrgStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+		params := r.URL.Query()
+
+		filter := AdminLinkFilter{Flagged: params.Get("flagged") == "true"}
+
+		var err error
+		filter.Limit, err = parseIntParam(params.Get("limit"), defaultListLimit)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxListLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+
+		filter.Offset, err = parseIntParam(params.Get("offset"), 0)
+		if err != nil || filter.Offset < 0 {
+			writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+
+		if _, ok := params["org"]; ok {
+			orgID := 0
+			if slug := params.Get("org"); slug != "" {
+				org, err := orgs.GetOrganizationBySlug(r.Context(), slug)
+				if err != nil {
+					if err == ErrOrgNotFound {
+						writeError(w, http.StatusNotFound, "Organization not found")
+					} else {
+						slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+						writeError(w, http.StatusInternalServerError, "Internal Server Error")
+					}
+					return
+				}
+				orgID = org.ID
+			}
+			filter.OrgID = &orgID
+		}
+
+		if value := params.Get("destination"); value != "" {
+			domain, ok := normalizeDomain(value)
+			if !ok {
+				writeError(w, http.StatusBadRequest, "Invalid destination domain")
+				return
+			}
+			filter.Domain = domain
+		}
+
+		if value := params.Get("created_from"); value != "" {
+			createdFrom, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "created_from must be an RFC 3339 timestamp")
+				return
+			}
+			filter.CreatedFrom = &createdFrom
+		}
+
+		if value := params.Get("created_to"); value != "" {
+			createdTo, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "created_to must be an RFC 3339 timestamp")
+				return
+			}
+			filter.CreatedTo = &createdTo
+		}
+
+		page, total, err := admin.ListAllLinks(r.Context(), filter)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := AdminLinksResponse{
+			Links:       adminLinks(page),
+			Total:       total,
+			Limit:       filter.Limit,
+			Offset:      filter.Offset,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// DisableLinkHandler quarantines the link with the ID in the path for
+// good, whoever owns it. The body may give a reason, shown on the warning
+// page.
+func DisableLinkHandler(admin AdminStore, cache LinkCache, webhooks *WebhookDispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Link not found")
+			return
+		}
+
+		var request DisableLinkRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				writeInvalidBody(w, err)
+				return
+			}
+		}
+
+		reason := strings.TrimSpace(request.Reason)
+		if reason == "" {
+			reason = adminDisableReason
+		}
+		if len(reason) > maxDescriptionLength {
+			writeValidationError(w, &fieldError{Field: "reason", Message: "reason must be at most " + strconv.Itoa(maxDescriptionLength) + " characters"})
+			return
+		}
+
+		link, err := disableLink(r.Context(), admin, cache, webhooks, id, reason)
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		link.ElapsedTime = time.Since(startTime).Milliseconds()
+		jsonResponse, err := json.Marshal(link)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// BanDomainHandler puts a destination domain on the blocklist, like
+// POST /domain-rules does, and also disables the existing links to it.
+// Banning a blocked domain again disables the links created since; an
+// allowed domain has to have its rule removed first.
+func BanDomainHandler(admin AdminStore, rules DomainRuleStore, cache LinkCache, webhooks *WebhookDispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		var request BanDomainRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeInvalidBody(w, err)
+			return
+		}
+
+		domain, ok := normalizeDomain(request.Domain)
+		if !ok {
+			writeValidationError(w, &fieldError{Field: "domain", Message: "Invalid domain"})
+			return
+		}
+
+		rule := DomainRule{Domain: domain, List: domainBlock}
+		err := rules.CreateDomainRule(r.Context(), &rule)
+		if err == ErrDomainRuleExists {
+			rule, err = findDomainRule(r.Context(), rules, domain)
+			if err == nil && rule.List != domainBlock {
+				writeConflict(w, "domain_rule_exists", "Domain is on the allowlist")
+				return
+			}
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error creating domain rule", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		disabled, err := disableLinksToDomain(r.Context(), admin, cache, webhooks, domain)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error disabling links", "error", err, "domain", domain, "disabled", disabled)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		slog.InfoContext(r.Context(), "Domain banned", "domain", domain, "disabled_links", disabled)
+
+		response := BanDomainResponse{
+			Rule:          rule,
+			DisabledLinks: disabled,
+			ElapsedTime:   time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+func findDomainRule(ctx context.Context, rules DomainRuleStore, domain string) (DomainRule, error) {
+	stored, err := rules.ListDomainRules(ctx)
+	if err != nil {
+		return DomainRule{}, err
+	}
+
+	for _, rule := range stored {
+		if rule.Domain == domain {
+			return rule, nil
+		}
+	}
+
+	return DomainRule{}, ErrDomainRuleNotFound
+}
+
+// KeyActivityHandler ranks the API keys by the links they created between
+// ?from and ?to, the last day by default.
+func KeyActivityHandler(admin AdminStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+		params := r.URL.Query()
+
+		to := startTime
+		if value := params.Get("to"); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "to must be an RFC 3339 timestamp")
+				return
+			}
+			to = parsed
+		}
+
+		from := to.Add(-defaultKeyActivityWindow)
+		if value := params.Get("from"); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "from must be an RFC 3339 timestamp")
+				return
+			}
+			from = parsed
+		}
+
+		if !from.Before(to) {
+			writeError(w, http.StatusBadRequest, "from must be before to")
+			return
+		}
+
+		limit, err := parseIntParam(params.Get("limit"), defaultListLimit)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+
+		keys, err := admin.KeyActivity(r.Context(), from, to, limit)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := KeyActivityResponse{
+			From:        from,
+			To:          to,
+			Keys:        keys,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
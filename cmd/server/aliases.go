@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxLinkAliases bounds the aliases of one link.
+	maxLinkAliases = 20
+
+	maxAliasSuggestions = 5
+	// maxAliasCandidates bounds the lookups an availability check makes
+	// while looking for suggestions.
+	maxAliasCandidates = 20
+)
+
+var ErrTooManyAliases = errors.New("link has too many aliases")
+
+// LinkAlias is a further code a link answers to, such as a branded code
+// next to the generated one. Its clicks count towards the link and are
+// also attributed to the alias.
+type LinkAlias struct {
+	ID        int       `db:"id" json:"id"`
+	OrgID     int       `db:"org_id" json:"-"`
+	DomainID  int       `db:"domain_id" json:"-"`
+	LinkID    int       `db:"link_id" json:"-"`
+	Code      string    `db:"code" json:"code"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+type AddLinkAliasRequest struct {
+	Alias string `json:"alias"`
+}
+
+type LinkAliasesResponse struct {
+	Code        string      `json:"code"`
+	Aliases     []LinkAlias `json:"aliases"`
+	ElapsedTime int64       `json:"elapsed_time"`
+}
+
+// AliasAvailabilityResponse tells whether an alias is free for /shorten.
+// Reason is alias_invalid, alias_reserved or alias_taken when it is not,
+// and Suggestions lists similar aliases that are free.
+type AliasAvailabilityResponse struct {
+	Alias       string   `json:"alias"`
+	Available   bool     `json:"available"`
+	Reason      string   `json:"reason,omitempty"`
+	Message     string   `json:"message,omitempty"`
+	Suggestions []string `json:"suggestions"`
+	ElapsedTime int64    `json:"elapsed_time"`
+}
+
+// CodesResponse breaks the clicks of a link down by the code they came
+// in under. Every current code is listed; codes of removed aliases are
+// listed while they have clicks.
+type CodesResponse struct {
+	Code        string      `json:"code"`
+	Codes       []CodeCount `json:"codes"`
+	ElapsedTime int64       `json:"elapsed_time"`
+}
+
+// addLinkAlias makes link answer to code as well.
+func addLinkAlias(ctx context.Context, aliases AliasStore, link Link, code string) (LinkAlias, error) {
+	existing, err := aliases.LinkAliases(ctx, link.ID)
+	if err != nil {
+		return LinkAlias{}, err
+	}
+	if len(existing) >= maxLinkAliases {
+		return LinkAlias{}, ErrTooManyAliases
+	}
+
+	alias := LinkAlias{OrgID: link.OrgID, DomainID: link.DomainID, LinkID: link.ID, Code: code}
+	err = aliases.AddLinkAlias(ctx, &alias)
+
+	return alias, err
+}
+
+// upsertAliasedLink finds the link of a URL that is already shortened, the
+// way UpsertLink does for generated codes, and adds the requested alias to
+// it. Should that link be gone by then, the alias becomes the code of a
+// new one.
+func upsertAliasedLink(ctx context.Context, links LinkStore, aliases AliasStore, request ShortenRequest) (Link, error) {
+	link := Link{
+		OrgID:            orgIDFromContext(ctx),
+		Code:             request.Alias,
+		URL:              request.URL,
+		RedirectStatus:   request.RedirectStatus,
+		TrackingDisabled: request.TrackingDisabled,
+		ForwardQuery:     request.ForwardQuery,
+		IdempotencyKey:   request.IdempotencyKey,
+		IdempotencyHash:  nonEmpty(request.IdempotencyHash),
+		KeyID:            keyIDFromContext(ctx),
+		MemberID:         sessionMemberIDFromContext(ctx),
+		DomainID:         request.DomainID,
+	}
+
+	if err := links.UpsertLink(ctx, &link); err != nil {
+		return Link{}, err
+	}
+	if link.Code == request.Alias {
+		return link, nil
+	}
+
+	_, err := addLinkAlias(ctx, aliases, link, request.Alias)
+
+	return link, err
+}
+
+// validateAlias checks a requested alias the way POST /shorten does,
+// writing the error when it is not acceptable.
+func validateAlias(w http.ResponseWriter, alias string, charset string) bool {
+	if !isValidAlias(alias, charset) {
+		writeValidationError(w, &fieldError{Field: "alias", Message: "Invalid alias"})
+		return false
+	}
+
+	if isReservedCode(alias) {
+		writeConflict(w, "alias_reserved", "Alias \""+alias+"\" is reserved")
+		return false
+	}
+
+	return true
+}
+
+// aliasUnavailable checks alias the way POST /shorten does and returns the
+// error code and message it would fail with, or "" when the alias is free
+// in the namespace of orgID and domainID.
+func aliasUnavailable(ctx context.Context, links LinkStore, orgID int, domainID int, alias string, charset string) (string, string, error) {
+	if !isValidAlias(alias, charset) {
+		return "alias_invalid", "Invalid alias", nil
+	}
+
+	if isReservedCode(alias) {
+		return "alias_reserved", "Alias \"" + alias + "\" is reserved", nil
+	}
+
+	exists, err := links.CodeExists(ctx, orgID, domainID, alias)
+	if err != nil {
+		return "", "", err
+	}
+	if exists {
+		return "alias_taken", "Alias \"" + alias + "\" is already in use", nil
+	}
+
+	return "", "", nil
+}
+
+// nearbyAliases lists the candidates suggested in place of alias: alias
+// without the characters the charset lacks, then alias followed by a
+// number, or by a character of the charset when it has no digits. alias
+// is shortened to leave room for the suffix.
+func nearbyAliases(alias string, charset string) []string {
+	var base strings.Builder
+	for i := 0; i < len(alias) && base.Len() < maxAliasLength-2; i++ {
+		if strings.IndexByte(charset, alias[i]) >= 0 {
+			base.WriteByte(alias[i])
+		}
+	}
+
+	var candidates []string
+	if base.String() != alias {
+		candidates = append(candidates, base.String())
+	}
+
+	digits := true
+	for c := '0'; c <= '9'; c++ {
+		digits = digits && strings.ContainsRune(charset, c)
+	}
+
+	if digits {
+		for n := 2; len(candidates) < maxAliasCandidates; n++ {
+			candidates = append(candidates, base.String()+strconv.Itoa(n))
+		}
+	} else {
+		for i := 0; i < len(charset) && len(candidates) < maxAliasCandidates; i++ {
+			candidates = append(candidates, base.String()+charset[i:i+1])
+		}
+	}
+
+	return candidates
+}
+
+// findAliasedLink looks up the link of the code in the request path, which
+// may itself be an alias, writing the error when there is none.
+func findAliasedLink(w http.ResponseWriter, r *http.Request, links LinkStore) (Link, bool) {
+	link, err := links.GetLink(r.Context(), orgIDFromContext(r.Context()), domainIDFromContext(r.Context()), mux.Vars(r)["code"])
+	if err != nil {
+		if err == ErrNotFound {
+			writeError(w, http.StatusNotFound, "Link not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return link, false
+	}
+
+	return link, true
+}
+
+// AliasAvailableHandler tells whether an alias can be requested from
+// /shorten, in the code space of the domain parameter, so that forms can
+// check it as it is typed. An alias that is not available comes with up to
+// maxAliasSuggestions similar ones that are.
+func AliasAvailableHandler(links LinkStore, charset string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		alias := r.URL.Query().Get("alias")
+		if alias == "" {
+			writeValidationError(w, &fieldError{Field: "alias", Message: "alias is required"})
+			return
+		}
+
+		ctx := r.Context()
+		orgID := orgIDFromContext(ctx)
+		domainID := domainIDFromContext(ctx)
+
+		reason, message, err := aliasUnavailable(ctx, links, orgID, domainID, alias, charset)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := AliasAvailabilityResponse{
+			Alias:       alias,
+			Available:   reason == "",
+			Reason:      reason,
+			Message:     message,
+			Suggestions: []string{},
+		}
+
+		if !response.Available {
+			for _, candidate := range nearbyAliases(alias, charset) {
+				unavailable, _, err := aliasUnavailable(ctx, links, orgID, domainID, candidate, charset)
+				if err != nil {
+					slog.ErrorContext(ctx, "Error querying database", "error", err)
+					writeError(w, http.StatusInternalServerError, "Internal Server Error")
+					return
+				}
+				if unavailable != "" {
+					continue
+				}
+
+				response.Suggestions = append(response.Suggestions, candidate)
+				if len(response.Suggestions) == maxAliasSuggestions {
+					break
+				}
+			}
+		}
+
+		response.ElapsedTime = time.Since(startTime).Milliseconds()
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// ListLinkAliasesHandler lists the aliases of a link, oldest first.
+func ListLinkAliasesHandler(links LinkStore, aliases AliasStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		link, ok := findAliasedLink(w, r, links)
+		if !ok {
+			return
+		}
+
+		list, err := aliases.LinkAliases(r.Context(), link.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := LinkAliasesResponse{
+			Code:        link.Code,
+			Aliases:     list,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// AddLinkAliasHandler adds an alias to a link. The alias must be free in
+// the namespace, both as a code and as an alias of another link.
+func AddLinkAliasHandler(links LinkStore, aliases AliasStore, charset string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request AddLinkAliasRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeInvalidBody(w, err)
+			return
+		}
+
+		if !validateAlias(w, request.Alias, charset) {
+			return
+		}
+
+		link, ok := findAliasedLink(w, r, links)
+		if !ok {
+			return
+		}
+
+		if link.DeletedAt != nil {
+			writeErrorCode(w, http.StatusGone, "link_deleted", "Link has been deleted", nil)
+			return
+		}
+		if link.ArchivedAt != nil {
+			writeErrorCode(w, http.StatusGone, "link_archived", "Link has been archived", nil)
+			return
+		}
+
+		alias, err := addLinkAlias(r.Context(), aliases, link, request.Alias)
+		if err != nil {
+			switch err {
+			case ErrCodeTaken:
+				writeConflict(w, "alias_taken", "Alias \""+request.Alias+"\" is already in use")
+			case ErrTooManyAliases:
+				writeValidationError(w, &fieldError{Field: "alias", Message: fmt.Sprintf("A link can have at most %d aliases", maxLinkAliases)})
+			default:
+				slog.ErrorContext(r.Context(), "Error adding alias", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		jsonResponse, err := json.Marshal(alias)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(jsonResponse)
+	}
+}
+
+// DeleteLinkAliasHandler removes an alias from a link. The clicks the
+// alias received stay with the link. Aliased lookups are never cached, so
+// the alias stops resolving at once.
+func DeleteLinkAliasHandler(links LinkStore, aliases AliasStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		link, ok := findAliasedLink(w, r, links)
+		if !ok {
+			return
+		}
+
+		err := aliases.DeleteLinkAlias(r.Context(), link.ID, mux.Vars(r)["alias"])
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Alias not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error deleting alias", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetURLCodesHandler returns the clicks of a link per code it was visited
+// under: its own code and each of its aliases.
+func GetURLCodesHandler(links LinkStore, clicks ClickStore, aliases AliasStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		link, ok := findAliasedLink(w, r, links)
+		if !ok {
+			return
+		}
+
+		counts, err := clicks.CodeClicks(r.Context(), link.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		list, err := aliases.LinkAliases(r.Context(), link.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := CodesResponse{
+			Code:  link.Code,
+			Codes: linkCodeClicks(link, list, counts),
+		}
+
+		response.ElapsedTime = time.Since(startTime).Milliseconds()
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// linkCodeClicks lists the clicks of the code and aliases of link, with
+// the codes that have none at 0, most clicked first.
+func linkCodeClicks(link Link, aliases []LinkAlias, counts []CodeCount) []CodeCount {
+	byCode := make(map[string]int64, len(counts))
+	for _, count := range counts {
+		byCode[count.Code] = count.Clicks
+	}
+
+	codes := []CodeCount{{LinkID: link.ID, Code: link.Code, Clicks: byCode[link.Code]}}
+	delete(byCode, link.Code)
+	for _, alias := range aliases {
+		codes = append(codes, CodeCount{LinkID: link.ID, Code: alias.Code, Clicks: byCode[alias.Code]})
+		delete(byCode, alias.Code)
+	}
+	removed := make([]string, 0, len(byCode))
+	for code := range byCode {
+		removed = append(removed, code)
+	}
+	sort.Strings(removed)
+	for _, code := range removed {
+		codes = append(codes, CodeCount{LinkID: link.ID, Code: code, Clicks: byCode[code]})
+	}
+
+	sort.SliceStable(codes, func(i, j int) bool {
+		return codes[i].Clicks > codes[j].Clicks
+	})
+
+	return codes
+}
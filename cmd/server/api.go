@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// apiPrefix is where the JSON endpoints live. Breaking changes go to a new
+// version next to it, while redirects stay at the root.
+const apiPrefix = "/api/v1"
+
+// registerLegacyAPIRoutes mounts every path of api at the root as well,
+// where it redirects to the versioned path. It must run before the
+// redirect routes, which would otherwise take the paths as codes.
+func registerLegacyAPIRoutes(r *mux.Router, api *mux.Router) {
+	registered := make(map[string]bool)
+
+	api.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+
+		legacy := strings.TrimPrefix(path, apiPrefix)
+		if registered[legacy] {
+			return nil
+		}
+		registered[legacy] = true
+
+		r.HandleFunc(legacy, redirectToVersionedAPI)
+
+		return nil
+	})
+}
+
+// redirectToVersionedAPI answers 308 so that clients repeat the request,
+// method and body included, against the versioned path.
+func redirectToVersionedAPI(w http.ResponseWriter, r *http.Request) {
+	target := apiPrefix + r.URL.Path
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	w.Header().Set("Deprecation", "true")
+	http.Redirect(w, r, target, http.StatusPermanentRedirect)
+}
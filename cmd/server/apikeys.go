@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Scopes of API keys. links:write creates, changes and deletes
+	// links, stats:read reads their clicks and admin manages the
+	// organization. Listing and reading links needs no scope.
+	scopeLinksWrite = "links:write"
+	scopeStatsRead  = "stats:read"
+	scopeAdmin      = "admin"
+
+	// apiKeyTouchInterval is how stale last_used_at may get, so that a
+	// busy key is not written to on every request.
+	apiKeyTouchInterval = time.Minute
+
+	// maxAPIKeyGrace bounds how long a rotated key keeps working.
+	defaultAPIKeyGrace = 24 * time.Hour
+	maxAPIKeyGrace     = 30 * 24 * time.Hour
+)
+
+var apiKeyScopeNames = []string{scopeLinksWrite, scopeStatsRead, scopeAdmin}
+
+var errAPIKeyExpired = errors.New("api key expired")
+
+// authenticationFailure is how a request whose credentials were rejected
+// is answered.
+type authenticationFailure struct {
+	Code    string
+	Message string
+}
+
+// authenticationFailures are the errors authenticateCaller rejects
+// credentials with.
+var authenticationFailures = map[error]authenticationFailure{
+	ErrAPIKeyNotFound: {Code: "unauthorized", Message: "Invalid API key"},
+	errAPIKeyExpired:  {Code: "api_key_expired", Message: "API key expired"},
+	errSessionInvalid: {Code: "unauthorized", Message: "Invalid session token"},
+	errSessionExpired: {Code: "session_expired", Message: "Session expired"},
+
+	errSignatureInvalid:  {Code: "unauthorized", Message: "Invalid request signature"},
+	errSignatureExpired:  {Code: "signature_expired", Message: "Request signature timestamp is too far from now"},
+	errSignatureReplayed: {Code: "signature_replayed", Message: "Request signature nonce was used already"},
+
+	errShareInvalid: {Code: "unauthorized", Message: "Invalid share token"},
+	errShareExpired: {Code: "share_expired", Message: "Share link expired"},
+}
+
+// apiKeyScopes is stored as a JSON array. Keys stored without scopes were
+// issued before there were any and have every scope.
+type apiKeyScopes []string
+
+func (s apiKeyScopes) Value() (driver.Value, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	return jsonColumnValue([]string(s))
+}
+
+func (s *apiKeyScopes) Scan(src interface{}) error {
+	if src == nil {
+		*s = append(apiKeyScopes(nil), apiKeyScopeNames...)
+		return nil
+	}
+
+	*s = nil
+	return scanJSONColumn(src, (*[]string)(s))
+}
+
+func (s apiKeyScopes) has(scope string) bool {
+	for _, granted := range s {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseAPIKeyScopes checks the scopes requested for a key and orders them.
+// No scopes at all, as opposed to an empty list, means every scope.
+func parseAPIKeyScopes(requested []string) (apiKeyScopes, error) {
+	if requested == nil {
+		return append(apiKeyScopes(nil), apiKeyScopeNames...), nil
+	}
+
+	scopes := apiKeyScopes{}
+	for _, scope := range requested {
+		if !apiKeyScopes(apiKeyScopeNames).has(scope) {
+			return nil, &fieldError{Field: "scopes", Message: "scopes must be among " + strings.Join(apiKeyScopeNames, ", ")}
+		}
+	}
+	for _, scope := range apiKeyScopeNames {
+		if apiKeyScopes(requested).has(scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes, nil
+}
+
+func (k APIKey) expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !k.ExpiresAt.After(now)
+}
+
+// authenticateCaller resolves an organization API key, or a session token
+// when sessions is not nil, to the member it was issued to, for
+// APIKeyMiddleware and the gRPC server. Rejected credentials fail with one
+// of authenticationFailures.
+func authenticateCaller(ctx context.Context, orgs OrgStore, sessions *SessionIssuer, key string) (caller, error) {
+	if sessions != nil && isSessionToken(key) {
+		return authenticateSession(ctx, orgs, sessions, key)
+	}
+
+	apiKey, member, err := orgs.AuthenticateAPIKey(ctx, hashAPIKey(key))
+	if err != nil {
+		return caller{}, err
+	}
+
+	return keyCaller(ctx, orgs, apiKey, member)
+}
+
+// keyCaller is the caller acting with apiKey, which is recorded as used.
+// Rotated keys act as the key that replaced them during their grace period,
+// so that both count against one quota and share their links. It fails
+// with errAPIKeyExpired for expired keys.
+func keyCaller(ctx context.Context, orgs OrgStore, apiKey APIKey, member Member) (caller, error) {
+	now := time.Now()
+	if apiKey.expired(now) {
+		return caller{}, errAPIKeyExpired
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyTouchInterval {
+		if err := orgs.TouchAPIKey(ctx, apiKey.ID); err != nil {
+			slog.WarnContext(ctx, "Error recording API key use", "error", err, "key_id", apiKey.ID)
+		}
+	}
+
+	keyID := apiKey.ID
+	if apiKey.ReplacedBy != nil {
+		keyID = *apiKey.ReplacedBy
+	}
+
+	return caller{
+		OrgID:    apiKey.OrgID,
+		MemberID: member.ID,
+		KeyID:    keyID,
+		Role:     member.Role,
+		Scopes:   apiKey.Scopes,
+	}, nil
+}
+
+// checkScope fails when the organization key of ctx lacks scope. Requests
+// without one, such as those with the admin key, are not restricted by
+// scopes.
+func checkScope(ctx context.Context, scope string) error {
+	c, ok := callerFromContext(ctx)
+	if !ok || c.Scopes.has(scope) {
+		return nil
+	}
+
+	return &serviceError{Status: http.StatusForbidden, Code: "insufficient_scope", Message: "API key lacks the " + scope + " scope"}
+}
+
+// requireScope answers 403 to the organization keys that lack scope.
+func requireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := checkScope(r.Context(), scope); err != nil {
+			failure := err.(*serviceError)
+			writeErrorCode(w, failure.Status, failure.Code, failure.Message, map[string]interface{}{"scope": scope})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RotateAPIKeyHandler replaces the key with the ID in the path by a new
+// one with the same name, member, scopes and lifetime, but no signing
+// secret. The old key keeps working for grace_seconds,
+// API_KEY_ROTATION_GRACE by default, so that clients can switch over; zero
+// ends it at once.
+func RotateAPIKeyHandler(orgs OrgStore, grace time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		old, ok := manageableAPIKey(w, r, orgs)
+		if !ok {
+			return
+		}
+		if old.ReplacedBy != nil {
+			writeError(w, http.StatusNotFound, "API key not found")
+			return
+		}
+
+		var request RotateAPIKeyRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				writeInvalidBody(w, err)
+				return
+			}
+		}
+
+		if request.GraceSeconds != nil {
+			if *request.GraceSeconds < 0 || time.Duration(*request.GraceSeconds)*time.Second > maxAPIKeyGrace {
+				writeValidationError(w, &fieldError{Field: "grace_seconds", Message: "grace_seconds must be between 0 and " + strconv.Itoa(int(maxAPIKeyGrace.Seconds()))})
+				return
+			}
+			grace = time.Duration(*request.GraceSeconds) * time.Second
+		}
+
+		secret, key, err := newAPIKey(old.Name)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error generating API key", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		key.OrgID = old.OrgID
+		key.MemberID = old.MemberID
+		key.Scopes = old.Scopes
+		if old.ExpiresAt != nil {
+			expiresAt := startTime.Add(old.ExpiresAt.Sub(old.CreatedAt))
+			key.ExpiresAt = &expiresAt
+		}
+
+		graceUntil := startTime.Add(grace)
+		if old.ExpiresAt != nil && old.ExpiresAt.Before(graceUntil) {
+			graceUntil = *old.ExpiresAt
+		}
+
+		err = orgs.RotateAPIKey(r.Context(), &old, &key, hashAPIKey(secret), graceUntil)
+		if err != nil {
+			if err == ErrAPIKeyNotFound {
+				writeError(w, http.StatusNotFound, "API key not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error rotating API key", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		response := RotateAPIKeyResponse{
+			APIKey:      key,
+			Key:         secret,
+			Previous:    old,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(jsonResponse)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseAPIKeyScopes(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested []string
+		want      apiKeyScopes
+		wantErr   bool
+	}{
+		{name: "omitted", requested: nil, want: apiKeyScopes{scopeLinksWrite, scopeStatsRead, scopeAdmin}},
+		{name: "empty", requested: []string{}, want: apiKeyScopes{}},
+		{name: "one", requested: []string{scopeStatsRead}, want: apiKeyScopes{scopeStatsRead}},
+		{name: "ordered", requested: []string{scopeAdmin, scopeLinksWrite}, want: apiKeyScopes{scopeLinksWrite, scopeAdmin}},
+		{name: "duplicates", requested: []string{scopeStatsRead, scopeStatsRead}, want: apiKeyScopes{scopeStatsRead}},
+		{name: "all", requested: []string{scopeStatsRead, scopeAdmin, scopeLinksWrite}, want: apiKeyScopes{scopeLinksWrite, scopeStatsRead, scopeAdmin}},
+		{name: "unknown", requested: []string{"links:read"}, wantErr: true},
+		{name: "unknown among known", requested: []string{scopeLinksWrite, "everything"}, wantErr: true},
+		{name: "case", requested: []string{"Links:Write"}, wantErr: true},
+		{name: "blank", requested: []string{""}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAPIKeyScopes(tt.requested)
+			if tt.wantErr {
+				var fieldErr *fieldError
+				if !errors.As(err, &fieldErr) || fieldErr.Field != "scopes" {
+					t.Fatalf("parseAPIKeyScopes(%q) error = %v, want a scopes field error", tt.requested, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAPIKeyScopes(%q) error = %v", tt.requested, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAPIKeyScopes(%q) = %#v, want %#v", tt.requested, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		scope   string
+		wantErr bool
+	}{
+		{"without a caller", context.Background(), scopeAdmin, false},
+		{"admin key", context.WithValue(context.Background(), adminKey, true), scopeAdmin, false},
+		{"granted", context.WithValue(context.Background(), callerKey, caller{Scopes: apiKeyScopes{scopeStatsRead}}), scopeStatsRead, false},
+		{"not granted", context.WithValue(context.Background(), callerKey, caller{Scopes: apiKeyScopes{scopeStatsRead}}), scopeLinksWrite, true},
+		{"no scopes", context.WithValue(context.Background(), callerKey, caller{Scopes: apiKeyScopes{}}), scopeStatsRead, true},
+		{"nil scopes", context.WithValue(context.Background(), callerKey, caller{}), scopeStatsRead, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkScope(tt.ctx, tt.scope)
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("checkScope error = %v, want none", err)
+				}
+				return
+			}
+
+			var failure *serviceError
+			if !errors.As(err, &failure) || failure.Status != http.StatusForbidden || failure.Code != "insufficient_scope" {
+				t.Errorf("checkScope error = %v, want a 403 insufficient_scope", err)
+			}
+		})
+	}
+}
+
+func TestAPIKeyScopesScan(t *testing.T) {
+	tests := []struct {
+		name string
+		src  interface{}
+		want apiKeyScopes
+	}{
+		{"stored before scopes", nil, apiKeyScopes{scopeLinksWrite, scopeStatsRead, scopeAdmin}},
+		{"empty", "[]", apiKeyScopes{}},
+		{"some", `["stats:read"]`, apiKeyScopes{scopeStatsRead}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got apiKeyScopes
+			if err := got.Scan(tt.src); err != nil {
+				t.Fatalf("Scan(%v) error = %v", tt.src, err)
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("Scan(%v) = %#v, want %#v", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireScopeOnProtectedRoute(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	sessions := &SessionIssuer{secret: []byte(testSessionSecret), ttl: time.Hour}
+
+	defer func(key string) { adminAPIKey = key }(adminAPIKey)
+	adminAPIKey = "test-admin-key"
+
+	_, _, owner := newTestOrg(t, store, "acme")
+
+	newKey := func(scopes apiKeyScopes) string {
+		t.Helper()
+
+		secret, key, err := newAPIKey("test")
+		if err != nil {
+			t.Fatalf("generating API key: %v", err)
+		}
+		key.OrgID, key.MemberID, key.Scopes = owner.OrgID, owner.ID, scopes
+		if err := store.CreateAPIKey(ctx, &key, hashAPIKey(secret)); err != nil {
+			t.Fatalf("creating API key: %v", err)
+		}
+
+		return secret
+	}
+
+	session, _, err := sessions.Issue(owner)
+	if err != nil {
+		t.Fatalf("issuing session: %v", err)
+	}
+
+	protected := APIKeyMiddleware(store, sessions, nil, requireScope(scopeStatsRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	tests := []struct {
+		name       string
+		key        string
+		wantStatus int
+	}{
+		{"scope granted", newKey(apiKeyScopes{scopeStatsRead}), http.StatusOK},
+		{"among other scopes", newKey(apiKeyScopes{scopeLinksWrite, scopeStatsRead}), http.StatusOK},
+		{"other scopes", newKey(apiKeyScopes{scopeLinksWrite, scopeAdmin}), http.StatusForbidden},
+		{"empty scopes", newKey(apiKeyScopes{}), http.StatusForbidden},
+		{"stored before scopes", newKey(nil), http.StatusOK},
+		{"session", session, http.StatusOK},
+		{"admin key", adminAPIKey, http.StatusOK},
+		{"unknown key", apiKeyPrefix + "0000", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/stats", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.key)
+			w := httptest.NewRecorder()
+
+			protected.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code != http.StatusForbidden {
+				return
+			}
+
+			var response ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("decoding error response: %v", err)
+			}
+			if response.Error.Code != "insufficient_scope" || response.Error.Details["scope"] != scopeStatsRead {
+				t.Errorf("error = %+v, want insufficient_scope for %s", response.Error, scopeStatsRead)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultRestoreWindow is how long a deleted link can be restored.
+const defaultRestoreWindow = 30 * 24 * time.Hour
+
+var (
+	ErrLinkArchived        = errors.New("link is archived")
+	ErrLinkActive          = errors.New("link is neither deleted nor archived")
+	ErrRestoreWindowPassed = errors.New("link was deleted too long ago to be restored")
+)
+
+// archiveLink archives a link so that it stops redirecting while keeping
+// its stats.
+func archiveLink(ctx context.Context, links LinkStore, orgID int, domainID int, code string) (Link, error) {
+	return links.UpdateLink(ctx, orgID, domainID, code, func(link *Link) error {
+		if link.DeletedAt != nil {
+			return ErrLinkDeleted
+		}
+		if link.ArchivedAt != nil {
+			return ErrLinkArchived
+		}
+
+		archivedAt := time.Now()
+		link.ArchivedAt = &archivedAt
+
+		return nil
+	})
+}
+
+// restoreLink undoes the deletion or archiving of a link. A link deleted
+// more than window ago, when window is not 0, stays deleted.
+func restoreLink(ctx context.Context, links LinkStore, orgID int, domainID int, code string, window time.Duration) (Link, error) {
+	return links.UpdateLink(ctx, orgID, domainID, code, func(link *Link) error {
+		if link.DeletedAt != nil {
+			if window > 0 && time.Since(*link.DeletedAt) > window {
+				return ErrRestoreWindowPassed
+			}
+		} else if link.ArchivedAt == nil {
+			return ErrLinkActive
+		}
+
+		link.DeletedAt = nil
+		link.ArchivedAt = nil
+
+		return nil
+	})
+}
+
+// ArchiveLinkHandler archives a link. Its code answers 410 Gone until the
+// link is restored, and its stats stay available.
+func ArchiveLinkHandler(links LinkStore, cache LinkCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+		code := mux.Vars(r)["code"]
+		orgID := orgIDFromContext(r.Context())
+		domainID := domainIDFromContext(r.Context())
+
+		link, err := archiveLink(r.Context(), links, orgID, domainID, code)
+		if err != nil {
+			switch err {
+			case ErrNotFound:
+				writeError(w, http.StatusNotFound, "Link not found")
+			case ErrLinkDeleted:
+				writeErrorCode(w, http.StatusGone, "link_deleted", "Link has been deleted", nil)
+			case ErrLinkArchived:
+				writeConflict(w, "link_archived", "Link is already archived")
+			default:
+				slog.ErrorContext(r.Context(), "Error archiving link", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		cache.Delete(r.Context(), orgID, domainID, code)
+
+		writeLinkWithTags(w, r, links, link, startTime)
+	}
+}
+
+// RestoreLinkHandler brings back an archived link, or a deleted one within
+// window of its deletion, with the clicks it retained.
+func RestoreLinkHandler(links LinkStore, cache LinkCache, window time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+		code := mux.Vars(r)["code"]
+		orgID := orgIDFromContext(r.Context())
+		domainID := domainIDFromContext(r.Context())
+
+		link, err := restoreLink(r.Context(), links, orgID, domainID, code, window)
+		if err != nil {
+			switch err {
+			case ErrNotFound:
+				writeError(w, http.StatusNotFound, "Link not found")
+			case ErrRestoreWindowPassed:
+				writeErrorCode(w, http.StatusGone, "restore_window_passed", "Link was deleted too long ago to be restored", nil)
+			case ErrLinkActive:
+				writeConflict(w, "link_active", "Link is neither deleted nor archived")
+			case ErrURLTaken:
+				writeConflict(w, "url_already_shortened", "URL is already shortened under another code")
+			default:
+				slog.ErrorContext(r.Context(), "Error restoring link", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		cache.Delete(r.Context(), orgID, domainID, code)
+
+		writeLinkWithTags(w, r, links, link, startTime)
+	}
+}
+
+// writeLinkWithTags answers with link and its tags.
+func writeLinkWithTags(w http.ResponseWriter, r *http.Request, links LinkStore, link Link, startTime time.Time) {
+	page := []Link{link}
+	if err := loadLinkTags(r.Context(), links, page); err != nil {
+		slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	link = page[0]
+
+	link.ElapsedTime = time.Since(startTime).Milliseconds()
+
+	jsonResponse, err := json.Marshal(link)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
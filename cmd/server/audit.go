@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Actions of link events.
+const (
+	linkEventUpdated  = "updated"
+	linkEventMoved    = "moved"
+	linkEventArchived = "archived"
+	linkEventRestored = "restored"
+	linkEventDeleted  = "deleted"
+	// Quarantined links were flagged by a scanner, disabled ones had
+	// their quarantine confirmed or imposed by an admin, and released ones
+	// had it lifted.
+	linkEventQuarantined = "quarantined"
+	linkEventDisabled    = "disabled"
+	linkEventReleased    = "released"
+	// Alias events record the alias in the changes of an alias field.
+	linkEventAliasAdded   = "alias_added"
+	linkEventAliasRemoved = "alias_removed"
+)
+
+var linkEventActions = map[string]bool{
+	linkEventUpdated:      true,
+	linkEventMoved:        true,
+	linkEventArchived:     true,
+	linkEventRestored:     true,
+	linkEventDeleted:      true,
+	linkEventQuarantined:  true,
+	linkEventDisabled:     true,
+	linkEventReleased:     true,
+	linkEventAliasAdded:   true,
+	linkEventAliasRemoved: true,
+}
+
+// LinkEvent is a change made to a link. MemberID and KeyID are the member
+// and API key that made it; they are unset for changes made without an
+// organization API key. Admin is set for changes made with the admin key.
+type LinkEvent struct {
+	ID        int64       `db:"id" json:"id"`
+	OrgID     int         `db:"org_id" json:"org_id"`
+	LinkID    int         `db:"link_id" json:"link_id"`
+	Code      string      `db:"code" json:"code"`
+	Action    string      `db:"action" json:"action"`
+	MemberID  *int        `db:"member_id" json:"member_id"`
+	KeyID     *int        `db:"key_id" json:"key_id"`
+	Admin     bool        `db:"admin" json:"admin"`
+	Changes   LinkChanges `db:"changes" json:"changes"`
+	CreatedAt time.Time   `db:"created_at" json:"created_at"`
+}
+
+// LinkChange is the value of a field of a link before and after a change.
+type LinkChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// LinkChanges are the changed fields of a link event by name, stored as
+// JSON.
+type LinkChanges map[string]LinkChange
+
+func (c LinkChanges) Value() (driver.Value, error) {
+	value, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(value), nil
+}
+
+func (c *LinkChanges) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(src), c)
+	case []byte:
+		return json.Unmarshal(src, c)
+	default:
+		return fmt.Errorf("cannot scan %T into link changes", src)
+	}
+}
+
+// LinkEventFilter selects the events of ListLinkEvents. A nil OrgID lists
+// the events of every namespace; an empty Action those of every action.
+type LinkEventFilter struct {
+	OrgID  *int
+	Action string
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+	Offset int
+}
+
+type LinkHistoryResponse struct {
+	Events      []LinkEvent `json:"events"`
+	Total       int         `json:"total"`
+	Limit       int         `json:"limit"`
+	Offset      int         `json:"offset"`
+	ElapsedTime int64       `json:"elapsed_time"`
+}
+
+// auditedFields are the fields of a link whose changes are recorded, with
+// the names they have in the API.
+var auditedFields = []struct {
+	name  string
+	value func(link Link) interface{}
+}{
+	{"url", func(link Link) interface{} { return link.URL }},
+	{"expires_at", func(link Link) interface{} { return link.ExpiresAt }},
+	{"redirect_status", func(link Link) interface{} { return link.RedirectStatus }},
+	{"max_clicks", func(link Link) interface{} { return link.MaxClicks }},
+	{"tracking_disabled", func(link Link) interface{} { return link.TrackingDisabled }},
+	{"forward_query", func(link Link) interface{} { return link.ForwardQuery }},
+	{"utm_source", func(link Link) interface{} { return link.UTMSource }},
+	{"utm_medium", func(link Link) interface{} { return link.UTMMedium }},
+	{"utm_campaign", func(link Link) interface{} { return link.UTMCampaign }},
+	{"destinations", func(link Link) interface{} { return link.Destinations }},
+	{"sticky_destinations", func(link Link) interface{} { return link.StickyDestinations }},
+	{"geo_targets", func(link Link) interface{} { return link.GeoTargets }},
+	{"device_targets", func(link Link) interface{} { return link.DeviceTargets }},
+	{"routing_rules", func(link Link) interface{} { return link.RoutingRules }},
+	{"active_from", func(link Link) interface{} { return link.ActiveFrom }},
+	{"active_until", func(link Link) interface{} { return link.ActiveUntil }},
+	{"fallback_url", func(link Link) interface{} { return link.FallbackURL }},
+	{"folder_id", func(link Link) interface{} { return link.FolderID }},
+	{"description", func(link Link) interface{} { return link.Description }},
+	{"notes", func(link Link) interface{} { return link.Notes }},
+	{"deleted_at", func(link Link) interface{} { return link.DeletedAt }},
+	{"archived_at", func(link Link) interface{} { return link.ArchivedAt }},
+	{"quarantined_at", func(link Link) interface{} { return link.QuarantinedAt }},
+	{"quarantine_reason", func(link Link) interface{} { return link.QuarantineReason }},
+	{"quarantine_reviewed_at", func(link Link) interface{} { return link.QuarantineReviewedAt }},
+}
+
+// linkChanges compares the audited fields of a link before and after a
+// change.
+func linkChanges(before Link, after Link) (LinkChanges, error) {
+	changes := make(LinkChanges)
+	for _, field := range auditedFields {
+		beforeValue, err := json.Marshal(field.value(before))
+		if err != nil {
+			return nil, err
+		}
+		afterValue, err := json.Marshal(field.value(after))
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(beforeValue, afterValue) {
+			changes[field.name] = LinkChange{Before: beforeValue, After: afterValue}
+		}
+	}
+
+	return changes, nil
+}
+
+// newLinkEvent describes the change of a link from before to after made
+// by the caller of ctx. It reports false when no audited field changed.
+func newLinkEvent(ctx context.Context, before Link, after Link) (LinkEvent, bool, error) {
+	changes, err := linkChanges(before, after)
+	if err != nil || len(changes) == 0 {
+		return LinkEvent{}, false, err
+	}
+
+	action := linkEventUpdated
+	switch {
+	case after.DeletedAt != nil && before.DeletedAt == nil:
+		action = linkEventDeleted
+	case after.DeletedAt == nil && before.DeletedAt != nil, after.ArchivedAt == nil && before.ArchivedAt != nil:
+		action = linkEventRestored
+	case after.ArchivedAt != nil && before.ArchivedAt == nil:
+		action = linkEventArchived
+	case after.QuarantineReviewedAt != nil && before.QuarantineReviewedAt == nil:
+		action = linkEventDisabled
+	case after.QuarantinedAt != nil && before.QuarantinedAt == nil:
+		action = linkEventQuarantined
+	case after.QuarantinedAt == nil && before.QuarantinedAt != nil:
+		action = linkEventReleased
+	case len(changes) == 1 && changes["folder_id"].Before != nil:
+		action = linkEventMoved
+	}
+
+	return linkEvent(ctx, after, action, changes), true, nil
+}
+
+// newAliasEvent describes the addition of alias to link by the caller of
+// ctx, or its removal when removed is set.
+func newAliasEvent(ctx context.Context, link Link, alias string, removed bool) (LinkEvent, error) {
+	code, err := json.Marshal(alias)
+	if err != nil {
+		return LinkEvent{}, err
+	}
+
+	action, change := linkEventAliasAdded, LinkChange{Before: json.RawMessage("null"), After: code}
+	if removed {
+		action, change = linkEventAliasRemoved, LinkChange{Before: code, After: json.RawMessage("null")}
+	}
+
+	return linkEvent(ctx, link, action, LinkChanges{"alias": change}), nil
+}
+
+// linkEvent is an event of link made by the caller of ctx.
+func linkEvent(ctx context.Context, link Link, action string, changes LinkChanges) LinkEvent {
+	event := LinkEvent{
+		OrgID:     link.OrgID,
+		LinkID:    link.ID,
+		Code:      link.Code,
+		Action:    action,
+		Admin:     adminFromContext(ctx),
+		Changes:   changes,
+		CreatedAt: time.Now(),
+	}
+	if c, ok := callerFromContext(ctx); ok {
+		event.MemberID, event.KeyID = nonZero(c.MemberID), nonZero(c.KeyID)
+	}
+
+	return event
+}
+
+// LinkHistoryHandler lists the changes made to a link, newest first. The
+// history of a deleted link stays available.
+func LinkHistoryHandler(links LinkStore, audit AuditStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+		params := r.URL.Query()
+
+		limit, err := parseIntParam(params.Get("limit"), defaultListLimit)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+
+		offset, err := parseIntParam(params.Get("offset"), 0)
+		if err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+
+		link, err := links.GetLink(r.Context(), orgIDFromContext(r.Context()), domainIDFromContext(r.Context()), mux.Vars(r)["code"])
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		events, total, err := audit.LinkHistory(r.Context(), link.ID, limit, offset)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		writeLinkEvents(w, r, events, total, limit, offset, startTime)
+	}
+}
+
+// AuditLogHandler lists the changes made to the links of every namespace,
+// newest first, filtered by org_id, action, from and to. It requires the
+// admin key.
+func AuditLogHandler(audit AuditStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+		params := r.URL.Query()
+
+		if !isAdminAPIKey(apiKeyFromRequest(r)) {
+			writeError(w, http.StatusForbidden, "Admin API key required")
+			return
+		}
+
+		filter := LinkEventFilter{Action: params.Get("action")}
+		if filter.Action != "" && !linkEventActions[filter.Action] {
+			writeError(w, http.StatusBadRequest, "action must be updated, moved, archived, restored, deleted, quarantined, disabled, released, alias_added or alias_removed")
+			return
+		}
+
+		if value := params.Get("org_id"); value != "" {
+			orgID, err := strconv.Atoi(value)
+			if err != nil || orgID < 0 {
+				writeError(w, http.StatusBadRequest, "org_id must be a non-negative integer")
+				return
+			}
+			filter.OrgID = &orgID
+		}
+
+		if value := params.Get("from"); value != "" {
+			from, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "from must be an RFC 3339 timestamp")
+				return
+			}
+			filter.From = &from
+		}
+
+		if value := params.Get("to"); value != "" {
+			to, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "to must be an RFC 3339 timestamp")
+				return
+			}
+			filter.To = &to
+		}
+
+		var err error
+		filter.Limit, err = parseIntParam(params.Get("limit"), defaultListLimit)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxListLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+
+		filter.Offset, err = parseIntParam(params.Get("offset"), 0)
+		if err != nil || filter.Offset < 0 {
+			writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+
+		events, total, err := audit.ListLinkEvents(r.Context(), filter)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		writeLinkEvents(w, r, events, total, filter.Limit, filter.Offset, startTime)
+	}
+}
+
+func writeLinkEvents(w http.ResponseWriter, r *http.Request, events []LinkEvent, total int, limit int, offset int, startTime time.Time) {
+	if events == nil {
+		events = []LinkEvent{}
+	}
+
+	response := LinkHistoryResponse{
+		Events:      events,
+		Total:       total,
+		Limit:       limit,
+		Offset:      offset,
+		ElapsedTime: time.Since(startTime).Milliseconds(),
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+)
+
+// defaultBotRanges are published address ranges of crawlers and link
+// preview bots, which do not always send a recognizable User-Agent.
+var defaultBotRanges = []string{
+	// Googlebot
+	"66.249.64.0/19",
+	// Bingbot
+	"40.77.167.0/24",
+	"157.55.39.0/24",
+	"207.46.13.0/24",
+	// Twitterbot
+	"199.16.156.0/22",
+	"199.59.148.0/22",
+	// Facebook crawler
+	"31.13.24.0/21",
+	"66.220.144.0/20",
+	"69.63.176.0/20",
+	"173.252.64.0/18",
+}
+
+// botNetworks are the ranges clicks are counted as bot clicks from, set
+// from BOT_IP_RANGES.
+var botNetworks = mustParseNetworks(defaultBotRanges)
+
+func loadBotNetworks(cfg *config.Config) error {
+	networks, err := parseNetworks(cfg.List("BOT_IP_RANGES"))
+	if err != nil {
+		return fmt.Errorf("BOT_IP_RANGES: %w", err)
+	}
+	botNetworks = networks
+
+	return nil
+}
+
+func parseNetworks(ranges []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+
+	for _, value := range ranges {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q", value)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func mustParseNetworks(ranges []string) []*net.IPNet {
+	networks, err := parseNetworks(ranges)
+	if err != nil {
+		panic(err)
+	}
+
+	return networks
+}
+
+func isBotIP(value string) bool {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range botNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// classifyClick classifies the device of a click. Clicks from bot
+// addresses count as bots whatever their User-Agent claims.
+func classifyClick(click Click) Device {
+	device := classifyUserAgent(click.UserAgent)
+
+	if device.Type != deviceBot && isBotIP(click.IP) {
+		device = Device{Type: deviceBot, Browser: otherAgent, OS: otherAgent}
+	}
+
+	return device
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultCacheTTL        = 5 * time.Minute
+	defaultCacheMaxEntries = 10000
+	cacheKeyPrefix         = "link:"
+)
+
+// LinkCache keeps code lookups out of Postgres for popular codes. Entries
+// are keyed by organization, domain and code, mirroring the link
+// namespaces. Cache
+// failures are never fatal: callers fall back to the database.
+type LinkCache interface {
+	Get(ctx context.Context, orgID int, domainID int, code string) (Link, bool)
+	Set(ctx context.Context, link Link)
+	Delete(ctx context.Context, orgID int, domainID int, code string)
+}
+
+// NewLinkCache picks the cache from CACHE_STORE: redis, memory or none.
+// Unset, links are cached in Redis when a Redis client is configured and
+// not at all otherwise. CACHE_TTL controls how long an entry lives.
+func NewLinkCache(cfg *config.Config, redisClient *redis.Client) (LinkCache, error) {
+	ttl := cfg.Duration("CACHE_TTL")
+
+	switch cfg.String("CACHE_STORE") {
+	case "":
+		if redisClient == nil {
+			return noopLinkCache{}, nil
+		}
+		return &RedisLinkCache{client: redisClient, ttl: ttl}, nil
+	case "redis":
+		if redisClient == nil {
+			return nil, errors.New("CACHE_STORE=redis requires REDIS_URL")
+		}
+		return &RedisLinkCache{client: redisClient, ttl: ttl}, nil
+	case "memory":
+		maxEntries := cfg.Int("CACHE_MAX_ENTRIES")
+		if maxEntries < 1 {
+			return nil, errors.New("CACHE_MAX_ENTRIES must be positive")
+		}
+		return NewMemoryLinkCache(maxEntries, ttl), nil
+	case "none":
+		return noopLinkCache{}, nil
+	default:
+		return nil, errors.New("CACHE_STORE must be redis, memory or none")
+	}
+}
+
+type noopLinkCache struct{}
+
+func (noopLinkCache) Get(ctx context.Context, orgID int, domainID int, code string) (Link, bool) {
+	return Link{}, false
+}
+func (noopLinkCache) Set(ctx context.Context, link Link)                               {}
+func (noopLinkCache) Delete(ctx context.Context, orgID int, domainID int, code string) {}
+
+type RedisLinkCache struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func (c *RedisLinkCache) Get(ctx context.Context, orgID int, domainID int, code string) (Link, bool) {
+	var link Link
+
+	data, err := c.client.Get(ctx, cacheKey(orgID, domainID, code)).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			slog.ErrorContext(ctx, "Error reading link from cache", "error", err)
+		}
+		return link, false
+	}
+
+	if err = json.Unmarshal(data, &link); err != nil {
+		slog.ErrorContext(ctx, "Error decoding cached link", "error", err)
+		return link, false
+	}
+	link.OrgID = orgID
+	link.DomainID = domainID
+
+	return link, true
+}
+
+func (c *RedisLinkCache) Set(ctx context.Context, link Link) {
+	data, err := json.Marshal(link)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error encoding link for cache", "error", err)
+		return
+	}
+
+	if err = c.client.Set(ctx, cacheKey(link.OrgID, link.DomainID, link.Code), data, c.ttl).Err(); err != nil {
+		slog.ErrorContext(ctx, "Error writing link to cache", "error", err)
+	}
+}
+
+func (c *RedisLinkCache) Delete(ctx context.Context, orgID int, domainID int, code string) {
+	if err := c.client.Del(ctx, cacheKey(orgID, domainID, code)).Err(); err != nil {
+		slog.ErrorContext(ctx, "Error deleting link from cache", "error", err)
+	}
+}
+
+// MemoryLinkCache keeps up to maxEntries links in process memory and
+// evicts the least recently used one to make room. It suits single
+// instance deployments: other instances do not see its invalidations, so
+// with several of them a changed link may be served stale for up to the
+// TTL.
+type MemoryLinkCache struct {
+	maxEntries int
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key       string
+	link      Link
+	expiresAt time.Time
+}
+
+func NewMemoryLinkCache(maxEntries int, ttl time.Duration) *MemoryLinkCache {
+	return &MemoryLinkCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (c *MemoryLinkCache) Get(ctx context.Context, orgID int, domainID int, code string) (Link, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[cacheKey(orgID, domainID, code)]
+	if !ok {
+		return Link{}, false
+	}
+
+	entry := element.Value.(*memoryCacheEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		c.remove(element)
+		return Link{}, false
+	}
+
+	c.order.MoveToFront(element)
+
+	return entry.link, true
+}
+
+func (c *MemoryLinkCache) Set(ctx context.Context, link Link) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey(link.OrgID, link.DomainID, link.Code)
+	expiresAt := time.Now().Add(c.ttl)
+
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*memoryCacheEntry)
+		entry.link = link
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&memoryCacheEntry{key: key, link: link, expiresAt: expiresAt})
+
+	if c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *MemoryLinkCache) Delete(ctx context.Context, orgID int, domainID int, code string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[cacheKey(orgID, domainID, code)]; ok {
+		c.remove(element)
+	}
+}
+
+func (c *MemoryLinkCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*memoryCacheEntry).key)
+}
+
+func cacheKey(orgID int, domainID int, code string) string {
+	return cacheKeyPrefix + strconv.Itoa(orgID) + ":" + strconv.Itoa(domainID) + ":" + code
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+)
+
+const (
+	hCaptchaVerifyEndpoint  = "https://api.hcaptcha.com/siteverify"
+	reCaptchaVerifyEndpoint = "https://www.google.com/recaptcha/api/siteverify"
+	captchaTimeout          = 5 * time.Second
+
+	// minCaptchaScore is the lowest reCAPTCHA v3 score taken for a human.
+	// Checkbox CAPTCHAs answer without a score.
+	minCaptchaScore = 0.5
+)
+
+var errServiceCaptchaRequired = &serviceError{Status: http.StatusUnauthorized, Code: "captcha_required", Message: "An organization API key is required to shorten without a CAPTCHA"}
+
+// CaptchaVerifier checks the CAPTCHA token sent along with an anonymous
+// request.
+type CaptchaVerifier interface {
+	Verify(ctx context.Context, token string, remoteIP string) (bool, error)
+}
+
+// NewCaptchaVerifier returns the verifier of CAPTCHA_PROVIDER, or nil
+// when anonymous shortens need no CAPTCHA.
+func NewCaptchaVerifier(cfg *config.Config) (CaptchaVerifier, error) {
+	var endpoint string
+	switch cfg.String("CAPTCHA_PROVIDER") {
+	case "":
+		return nil, nil
+	case "hcaptcha":
+		endpoint = hCaptchaVerifyEndpoint
+	case "recaptcha":
+		endpoint = reCaptchaVerifyEndpoint
+	default:
+		return nil, errors.New("CAPTCHA_PROVIDER must be hcaptcha or recaptcha")
+	}
+
+	secret := cfg.String("CAPTCHA_SECRET")
+	if secret == "" {
+		return nil, errors.New("CAPTCHA_PROVIDER requires CAPTCHA_SECRET")
+	}
+
+	return &SiteVerifyCaptcha{
+		endpoint: endpoint,
+		secret:   secret,
+		client:   &http.Client{Timeout: captchaTimeout, Transport: tracedTransport(nil)},
+	}, nil
+}
+
+// SiteVerifyCaptcha verifies tokens with the siteverify API that
+// hCaptcha and reCAPTCHA share.
+type SiteVerifyCaptcha struct {
+	endpoint string
+	secret   string
+	client   *http.Client
+}
+
+type siteVerifyResponse struct {
+	Success    bool     `json:"success"`
+	Score      *float64 `json:"score"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+func (c *SiteVerifyCaptcha) Verify(ctx context.Context, token string, remoteIP string) (bool, error) {
+	form := url.Values{"secret": {c.secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("CAPTCHA verification answered %s", resp.Status)
+	}
+
+	var result siteVerifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+
+	if !result.Success {
+		slog.DebugContext(ctx, "CAPTCHA rejected", "error_codes", result.ErrorCodes)
+		return false, nil
+	}
+
+	return result.Score == nil || *result.Score >= minCaptchaScore, nil
+}
+
+// checkCaptcha makes anonymous requests prove they come from a person
+// when a CAPTCHA is configured, answering the request otherwise.
+func checkCaptcha(w http.ResponseWriter, r *http.Request, captcha CaptchaVerifier, token string) bool {
+	if captcha == nil || authenticatedRequest(r) {
+		return true
+	}
+
+	if token == "" {
+		writeErrorCode(w, http.StatusBadRequest, "captcha_required", "captcha_token is required without an API key", map[string]interface{}{"field": "captcha_token"})
+		return false
+	}
+
+	ok, err := captcha.Verify(r.Context(), token, clientIP(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error verifying CAPTCHA", "error", err)
+		writeError(w, http.StatusServiceUnavailable, "CAPTCHA could not be verified")
+		return false
+	}
+	if !ok {
+		writeErrorCode(w, http.StatusBadRequest, "captcha_invalid", "CAPTCHA verification failed", map[string]interface{}{"field": "captcha_token"})
+		return false
+	}
+
+	return true
+}
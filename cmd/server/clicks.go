@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	clickBufferSize    = 10000
+	clickBatchSize     = 500
+	clickFlushInterval = time.Second
+)
+
+// privacyMode turns off click tracking for every link, set from
+// PRIVACY_MODE, for deployments that must not record visits at all.
+var privacyMode bool
+
+// isTracked reports whether visits of link are recorded. Neither privacy
+// mode nor tracking_disabled lifts max_clicks, which is still enforced.
+func isTracked(link Link) bool {
+	return !privacyMode && !link.TrackingDisabled
+}
+
+// Click is a single visit of a short link.
+type Click struct {
+	LinkID int
+	// Referrer is the host of the referring page, empty for direct visits.
+	Referrer string
+	// IP is the client address. It is used to resolve the country and only
+	// its hash is stored.
+	IP string
+	// UserAgent is classified into a Device; the header itself is not
+	// stored.
+	UserAgent string
+	// Language is the language the visitor prefers, which routing rules
+	// match on. It is not stored.
+	Language string
+	// DestinationID is the destination the visit was sent to, 0 for links
+	// with a single destination.
+	DestinationID int
+	// Code is the code or alias the link was visited under.
+	Code string
+}
+
+type clickKey struct {
+	LinkID int
+	Date   string
+}
+
+type referrerKey struct {
+	LinkID   int
+	Referrer string
+}
+
+type countryKey struct {
+	LinkID  int
+	Country string
+}
+
+type destinationKey struct {
+	LinkID        int
+	DestinationID int
+}
+
+type codeKey struct {
+	LinkID int
+	Code   string
+}
+
+type deviceKey struct {
+	LinkID int
+	Device
+}
+
+// pendingClicks accumulates clicks between flushes.
+type pendingClicks struct {
+	daily        map[clickKey]int
+	referrers    map[referrerKey]int
+	countries    map[countryKey]int
+	devices      map[deviceKey]int
+	destinations map[destinationKey]int
+	codes        map[codeKey]int
+	bots         map[int]int
+	events       []ClickEvent
+	queued       int
+}
+
+func newPendingClicks() *pendingClicks {
+	return &pendingClicks{
+		daily:        make(map[clickKey]int),
+		referrers:    make(map[referrerKey]int),
+		countries:    make(map[countryKey]int),
+		devices:      make(map[deviceKey]int),
+		destinations: make(map[destinationKey]int),
+		codes:        make(map[codeKey]int),
+		bots:         make(map[int]int),
+	}
+}
+
+// add folds a click into the counters. Bot clicks only count towards the
+// bot clicks and devices of their link, so that they do not inflate the
+// click count, referrers and countries.
+func (p *pendingClicks) add(click queuedClick, country string) {
+	device := classifyClick(click.Click)
+
+	if device.Type == deviceBot {
+		p.bots[click.LinkID]++
+	} else {
+		p.daily[clickKey{LinkID: click.LinkID, Date: click.Date}]++
+		p.referrers[referrerKey{LinkID: click.LinkID, Referrer: click.Referrer}]++
+		p.countries[countryKey{LinkID: click.LinkID, Country: country}]++
+		if click.DestinationID != 0 {
+			p.destinations[destinationKey{LinkID: click.LinkID, DestinationID: click.DestinationID}]++
+		}
+		if click.Code != "" {
+			p.codes[codeKey{LinkID: click.LinkID, Code: click.Code}]++
+		}
+	}
+	p.devices[deviceKey{LinkID: click.LinkID, Device: device}]++
+	p.events = append(p.events, ClickEvent{
+		LinkID:       click.LinkID,
+		ClickedAt:    click.At,
+		Country:      country,
+		ReferrerHash: hashReferrer(click.Referrer),
+		IPHash:       hashIP(click.IP),
+		DeviceType:   device.Type,
+	})
+	p.queued++
+}
+
+// hashReferrer keeps the referrer host out of the click events while still
+// telling referrers apart.
+func hashReferrer(referrer string) *string {
+	if referrer == "" {
+		return nil
+	}
+
+	sum := sha256.Sum256([]byte(referrer))
+	hash := hex.EncodeToString(sum[:])
+
+	return &hash
+}
+
+// hashIP is how click events refer to the client address. The address is
+// put in canonical form first, so that erasure requests match however the
+// address is written.
+func hashIP(ip string) *string {
+	if ip == "" {
+		return nil
+	}
+
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+
+	sum := sha256.Sum256([]byte(ip))
+	hash := hex.EncodeToString(sum[:])
+
+	return &hash
+}
+
+func (p *pendingClicks) batch() ClickBatch {
+	batch := ClickBatch{
+		Daily:        make([]ClickCount, 0, len(p.daily)),
+		Referrers:    make([]ReferrerCount, 0, len(p.referrers)),
+		Countries:    make([]CountryCount, 0, len(p.countries)),
+		Devices:      make([]DeviceCount, 0, len(p.devices)),
+		Destinations: make([]DestinationCount, 0, len(p.destinations)),
+		Codes:        make([]CodeCount, 0, len(p.codes)),
+		Bots:         make([]BotCount, 0, len(p.bots)),
+		Events:       p.events,
+	}
+
+	for key, count := range p.daily {
+		batch.Daily = append(batch.Daily, ClickCount{LinkID: key.LinkID, Date: key.Date, Clicks: int64(count)})
+	}
+
+	for key, count := range p.referrers {
+		batch.Referrers = append(batch.Referrers, ReferrerCount{LinkID: key.LinkID, Referrer: key.Referrer, Clicks: int64(count)})
+	}
+
+	for key, count := range p.countries {
+		batch.Countries = append(batch.Countries, CountryCount{LinkID: key.LinkID, Country: key.Country, Clicks: int64(count)})
+	}
+
+	for key, count := range p.destinations {
+		batch.Destinations = append(batch.Destinations, DestinationCount{LinkID: key.LinkID, DestinationID: key.DestinationID, Clicks: int64(count)})
+	}
+
+	for key, count := range p.codes {
+		batch.Codes = append(batch.Codes, CodeCount{LinkID: key.LinkID, Code: key.Code, Clicks: int64(count)})
+	}
+
+	for linkID, count := range p.bots {
+		batch.Bots = append(batch.Bots, BotCount{LinkID: linkID, Clicks: int64(count)})
+	}
+
+	for key, count := range p.devices {
+		batch.Devices = append(batch.Devices, DeviceCount{
+			LinkID:     key.LinkID,
+			DeviceType: key.Type,
+			Browser:    key.Browser,
+			OS:         key.OS,
+			Clicks:     int64(count),
+		})
+	}
+
+	return batch
+}
+
+// queuedClick is a click with the time it happened, and its UTC date.
+type queuedClick struct {
+	Click
+	At   time.Time
+	Date string
+}
+
+// ClickRecorder takes click counting off the request path. Clicks are
+// queued on a buffered channel and a single worker resolves their country
+// and device and folds them into per-link counters by day, referrer,
+// country and device that are written in batches together with the
+// individual events. Each click is also published on the bus as soon as
+// it is taken in.
+type ClickRecorder struct {
+	store     ClickStore
+	countries CountryLookup
+	bus       *ClickBus
+	events    chan queuedClick
+	done      chan struct{}
+	once      sync.Once
+}
+
+func NewClickRecorder(store ClickStore, countries CountryLookup, bus *ClickBus) *ClickRecorder {
+	recorder := &ClickRecorder{
+		store:     store,
+		countries: countries,
+		bus:       bus,
+		events:    make(chan queuedClick, clickBufferSize),
+		done:      make(chan struct{}),
+	}
+
+	go recorder.run()
+
+	return recorder
+}
+
+// Record queues a click. It never blocks: when the buffer is full the
+// click is dropped and logged rather than slowing the redirect.
+func (c *ClickRecorder) Record(click Click) {
+	now := time.Now().UTC()
+	event := queuedClick{Click: click, At: now, Date: now.Format("2006-01-02")}
+
+	select {
+	case c.events <- event:
+	default:
+		slog.Warn("Click buffer is full, dropping click", "link_id", click.LinkID)
+	}
+}
+
+// Close stops accepting clicks and blocks until everything already queued
+// has been written.
+func (c *ClickRecorder) Close() {
+	c.once.Do(func() {
+		close(c.events)
+	})
+	<-c.done
+}
+
+func (c *ClickRecorder) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(clickFlushInterval)
+	defer ticker.Stop()
+
+	pending := newPendingClicks()
+
+	for {
+		select {
+		case event, ok := <-c.events:
+			if !ok {
+				c.flush(pending)
+				return
+			}
+
+			pending.add(event, c.countries.Country(event.IP))
+			c.bus.Publish(pending.events[len(pending.events)-1])
+			if pending.queued >= clickBatchSize {
+				c.flush(pending)
+				pending = newPendingClicks()
+			}
+		case <-ticker.C:
+			if pending.queued > 0 {
+				c.flush(pending)
+				pending = newPendingClicks()
+			}
+		}
+	}
+}
+
+func (c *ClickRecorder) flush(pending *pendingClicks) {
+	if pending.queued == 0 {
+		return
+	}
+
+	batch := pending.batch()
+	if err := c.store.AddClicks(context.Background(), batch); err != nil {
+		slog.Error("Error recording clicks", "error", err, "links", len(batch.Daily), "bot_links", len(batch.Bots))
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+)
+
+// reservedCodes are the words that name routes, or could name routes
+// added later, so no code may use them. They are compared in lowercase.
+// RESERVED_CODES adds to them.
+var reservedCodes = map[string]bool{
+	"account":         true,
+	"admin":           true,
+	"alias-available": true,
+	"api":             true,
+	"app":             true,
+	"assets":          true,
+	"dashboard":       true,
+	"docs":            true,
+	"domain-rules":    true,
+	"export":          true,
+	"favicon":         true,
+	"get-link":        true,
+	"graphql":         true,
+	"health":          true,
+	"healthz":         true,
+	"help":            true,
+	"import":          true,
+	"links":           true,
+	"login":           true,
+	"logout":          true,
+	"metrics":         true,
+	"o":               true,
+	"org":             true,
+	"orgs":            true,
+	"preview":         true,
+	"readyz":          true,
+	"robots":          true,
+	"settings":        true,
+	"shorten":         true,
+	"signup":          true,
+	"static":          true,
+	"stats":           true,
+	"status":          true,
+	"usage":           true,
+	"webhooks":        true,
+}
+
+func isReservedCode(code string) bool {
+	return reservedCodes[strings.ToLower(code)]
+}
+
+const (
+	codeStrategyRandom     = "random"
+	codeStrategySequential = "sequential"
+
+	defaultCodeSequenceBlock = 100
+)
+
+// CodeGenerator produces short codes of at least the given length. It is
+// an interface so handlers can be given a deterministic generator in
+// tests.
+type CodeGenerator interface {
+	Generate(ctx context.Context, length int) (string, error)
+}
+
+// NewCodeGenerator picks the generator from CODE_STRATEGY: random codes,
+// or sequential ones scrambled with CODE_SEQUENCE_SECRET when it is set
+// and reserved CODE_SEQUENCE_BLOCK values at a time.
+func NewCodeGenerator(cfg *config.Config, links LinkStore, charset string) (CodeGenerator, error) {
+	switch cfg.String("CODE_STRATEGY") {
+	case codeStrategyRandom:
+		return NewRandomCodeGenerator(charset), nil
+	case codeStrategySequential:
+		blockSize := cfg.Int("CODE_SEQUENCE_BLOCK")
+		if blockSize < 1 {
+			return nil, errors.New("CODE_SEQUENCE_BLOCK must be positive")
+		}
+		return NewSequenceCodeGenerator(links, charset, cfg.String("CODE_SEQUENCE_SECRET"), int64(blockSize)), nil
+	default:
+		return nil, errors.New("CODE_STRATEGY must be random or sequential")
+	}
+}
+
+// RandomCodeGenerator draws codes from a charset using crypto/rand, so
+// codes are unpredictable and safe to generate concurrently.
+type RandomCodeGenerator struct {
+	charset string
+}
+
+func NewRandomCodeGenerator(charset string) *RandomCodeGenerator {
+	return &RandomCodeGenerator{charset: charset}
+}
+
+func (g *RandomCodeGenerator) Generate(ctx context.Context, length int) (string, error) {
+	max := big.NewInt(int64(len(g.charset)))
+
+	code := make([]byte, length)
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = g.charset[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// SequenceCodeGenerator encodes the values of the code sequence in the
+// base of the charset, base62 for a full alphanumeric one. Codes are
+// padded to the requested length with the first character of the charset
+// and grow longer only once the sequence outgrows it. As every value is
+// handed out once, generated codes never collide with each other; they
+// only may with custom aliases or codes generated by another strategy.
+//
+// Values are reserved from the database in blocks and handed out from
+// memory, so instances sharing a database mint codes without talking to
+// each other or to the database for every link. Codes of concurrent
+// instances interleave rather than follow each other, and the rest of a
+// block is skipped when an instance stops.
+//
+// Plain sequential codes tell how many links were shortened and let
+// anyone enumerate them. With a secret, the codes of each length are
+// permuted by a keyed affine map, so consecutive links get unrelated
+// codes. That hides the order from casual visitors but is not encryption:
+// a handful of known codes is enough to undo it.
+type SequenceCodeGenerator struct {
+	links     LinkStore
+	charset   string
+	secret    []byte
+	blockSize int64
+
+	mu sync.Mutex
+	// next and last bound the values left in the reserved block.
+	next int64
+	last int64
+}
+
+func NewSequenceCodeGenerator(links LinkStore, charset string, secret string, blockSize int64) *SequenceCodeGenerator {
+	g := &SequenceCodeGenerator{links: links, charset: charset, blockSize: blockSize, next: 1}
+	if secret != "" {
+		g.secret = []byte(secret)
+	}
+
+	return g
+}
+
+func (g *SequenceCodeGenerator) Generate(ctx context.Context, length int) (string, error) {
+	n, err := g.nextValue(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	base := big.NewInt(int64(len(g.charset)))
+	value := big.NewInt(n)
+
+	space := new(big.Int).Exp(base, big.NewInt(int64(length)), nil)
+	for value.Cmp(space) >= 0 {
+		space.Mul(space, base)
+		length++
+	}
+
+	if g.secret != nil {
+		value = g.permute(value, length, base, space)
+	}
+
+	code := make([]byte, length)
+	digit := new(big.Int)
+	for i := length - 1; i >= 0; i-- {
+		value.DivMod(value, base, digit)
+		code[i] = g.charset[digit.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// nextValue hands out the next value of the reserved block, reserving a
+// new block once it is used up.
+func (g *SequenceCodeGenerator) nextValue(ctx context.Context) (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.next > g.last {
+		last, err := g.links.ReserveCodeSequence(ctx, g.blockSize)
+		if err != nil {
+			return 0, err
+		}
+		g.next, g.last = last-g.blockSize+1, last
+	}
+
+	n := g.next
+	g.next++
+
+	return n, nil
+}
+
+// permute maps value onto (a*value + b) mod space, where space holds the
+// codes of the given length and a and b are derived from the secret and
+// that length. a is coprime to the base, and so to space, which makes the
+// map a bijection.
+func (g *SequenceCodeGenerator) permute(value *big.Int, length int, base, space *big.Int) *big.Int {
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write([]byte(strconv.Itoa(length)))
+	sum := mac.Sum(nil)
+
+	one := big.NewInt(1)
+	a := new(big.Int).SetBytes(sum[:16])
+	a.Mod(a, space)
+	for new(big.Int).GCD(nil, nil, a, base).Cmp(one) != 0 {
+		a.Add(a, one)
+	}
+
+	b := new(big.Int).SetBytes(sum[16:])
+	b.Mod(b, space)
+
+	result := new(big.Int).Mul(a, value)
+	result.Add(result, b)
+
+	return result.Mod(result, space)
+}
+
+// CodeConfig holds the server-wide limits for generated codes.
+type CodeConfig struct {
+	Length    int
+	MaxLength int
+	Charset   string
+}
+
+// loadCodeConfig validates CODE_LENGTH, CODE_MAX_LENGTH and CODE_CHARSET
+// and adds RESERVED_CODES to the reserved words.
+func loadCodeConfig(cfg *config.Config) (CodeConfig, error) {
+	config := CodeConfig{
+		Length:    cfg.Int("CODE_LENGTH"),
+		MaxLength: cfg.Int("CODE_MAX_LENGTH"),
+		Charset:   cfg.String("CODE_CHARSET"),
+	}
+
+	for _, word := range cfg.List("RESERVED_CODES") {
+		reservedCodes[strings.ToLower(word)] = true
+	}
+
+	if config.Length < 1 {
+		return config, errors.New("CODE_LENGTH must be positive")
+	}
+	if config.MaxLength < config.Length {
+		return config, errors.New("CODE_MAX_LENGTH must not be smaller than CODE_LENGTH")
+	}
+	if len(config.Charset) < 2 {
+		return config, errors.New("CODE_CHARSET must contain at least two characters")
+	}
+	for i := 0; i < len(config.Charset); i++ {
+		if strings.IndexByte(config.Charset[i+1:], config.Charset[i]) >= 0 {
+			return config, fmt.Errorf("CODE_CHARSET contains %q more than once", config.Charset[i])
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+)
+
+const (
+	defaultCORSMethods = "GET, POST, PATCH, DELETE"
+	defaultCORSHeaders = "Authorization, Content-Type, Idempotency-Key, X-API-Key, X-Request-ID"
+	defaultCORSMaxAge  = 10 * time.Minute
+
+	// corsExposedHeaders are the response headers browsers may read besides
+	// the CORS-safelisted ones.
+	corsExposedHeaders = "X-Request-ID, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, X-Quota-Limit, X-Quota-Remaining, X-Quota-Reset, Retry-After, Deprecation, Idempotent-Replayed"
+)
+
+// CORSPolicy lets browsers on the origins in CORS_ALLOWED_ORIGINS call the
+// API. "*" allows every origin. CORS_ALLOWED_METHODS and
+// CORS_ALLOWED_HEADERS answer preflight requests, which browsers may cache
+// for CORS_MAX_AGE. With no allowed origins, cross-origin calls stay
+// blocked.
+type CORSPolicy struct {
+	anyOrigin bool
+	origins   map[string]bool
+	methods   string
+	headers   string
+	maxAge    string
+}
+
+func NewCORSPolicy(cfg *config.Config) (*CORSPolicy, error) {
+	policy := &CORSPolicy{
+		origins: make(map[string]bool),
+		methods: strings.Join(cfg.List("CORS_ALLOWED_METHODS"), ", "),
+		headers: strings.Join(cfg.List("CORS_ALLOWED_HEADERS"), ", "),
+		maxAge:  strconv.Itoa(int(cfg.Duration("CORS_MAX_AGE").Seconds())),
+	}
+
+	for _, field := range cfg.List("CORS_ALLOWED_ORIGINS") {
+		if field == "*" {
+			policy.anyOrigin = true
+			continue
+		}
+
+		origin, ok := normalizeOrigin(field)
+		if !ok {
+			return nil, fmt.Errorf("CORS_ALLOWED_ORIGINS contains an invalid origin %q", field)
+		}
+		policy.origins[origin] = true
+	}
+
+	return policy, nil
+}
+
+// Middleware sets the CORS headers of requests from allowed origins and
+// answers their preflight requests itself, before authentication, since
+// browsers send preflights without credentials.
+func (p *CORSPolicy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+
+		if !p.allowsOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if p.anyOrigin {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", p.methods)
+			w.Header().Set("Access-Control-Allow-Headers", p.headers)
+			w.Header().Set("Access-Control-Max-Age", p.maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+
+	normalized, ok := normalizeOrigin(origin)
+	return ok && p.origins[normalized]
+}
+
+// normalizeOrigin lowercases a scheme://host[:port] origin. Origins with a
+// path, query or credentials are rejected.
+func normalizeOrigin(origin string) (string, bool) {
+	u, err := url.Parse(strings.TrimSuffix(origin, "/"))
+	if err != nil || u.Host == "" || u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", false
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", false
+	}
+
+	return strings.ToLower(u.Scheme + "://" + u.Host), true
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// domainVerificationRecord is prefixed to a hostname to name the TXT
+	// record that verifies it.
+	domainVerificationRecord = "_wowee-link-verification"
+	// domainVerificationPath is where a domain pointed at this server
+	// answers with its token.
+	domainVerificationPath = "/.well-known/wowee-link-verification"
+
+	domainCacheTTL   = time.Minute
+	maxCachedDomains = 1000
+
+	domainIDKey contextKey = "domain_id"
+)
+
+var errDomainNotVerified = errors.New("domain is not verified")
+
+// CustomDomain is a hostname an organization serves its links on besides
+// SHORT_DOMAINS. It serves them once verified, which takes a TXT record
+// or the server answering on the domain with the verification token.
+type CustomDomain struct {
+	ID                int        `db:"id" json:"id"`
+	OrgID             int        `db:"org_id" json:"-"`
+	Hostname          string     `db:"hostname" json:"hostname"`
+	VerificationToken string     `db:"verification_token" json:"-"`
+	VerifiedAt        *time.Time `db:"verified_at" json:"verified_at"`
+	CreatedAt         time.Time  `db:"created_at" json:"created_at"`
+	// Verification is shown while the domain is not verified yet.
+	Verification *DomainVerification `db:"-" json:"verification,omitempty"`
+}
+
+// DomainVerification tells how to verify a domain: either a TXT record
+// named DNSName holding Token, or the domain pointing at this server,
+// which then answers HTTPURL with Token.
+type DomainVerification struct {
+	Token   string `json:"token"`
+	DNSName string `json:"dns_name"`
+	DNSType string `json:"dns_type"`
+	HTTPURL string `json:"http_url"`
+}
+
+// withVerification fills in how to verify domain when it is not verified.
+func (domain CustomDomain) withVerification() CustomDomain {
+	if domain.VerifiedAt == nil {
+		domain.Verification = &DomainVerification{
+			Token:   domain.VerificationToken,
+			DNSName: domainVerificationRecord + "." + domain.Hostname,
+			DNSType: "TXT",
+			HTTPURL: "http://" + domain.Hostname + domainVerificationPath,
+		}
+	}
+
+	return domain
+}
+
+// DomainResolver maps the Host of redirects to the custom domain they
+// came in on. Lookups are cached for domainCacheTTL, so other instances
+// pick up a change within that time.
+type DomainResolver struct {
+	orgs OrgStore
+
+	mu      sync.Mutex
+	entries map[string]resolvedDomain
+}
+
+type resolvedDomain struct {
+	domain    CustomDomain
+	found     bool
+	expiresAt time.Time
+}
+
+func NewDomainResolver(orgs OrgStore) *DomainResolver {
+	return &DomainResolver{orgs: orgs, entries: make(map[string]resolvedDomain)}
+}
+
+// Resolve returns the verified custom domain hostname is, and false for
+// SHORT_DOMAINS and every other host.
+func (d *DomainResolver) Resolve(ctx context.Context, hostname string) (CustomDomain, bool, error) {
+	if hostname == "" || matchesDomain(hostname, shortDomains) {
+		return CustomDomain{}, false, nil
+	}
+
+	d.mu.Lock()
+	entry, ok := d.entries[hostname]
+	d.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.domain, entry.found, nil
+	}
+
+	domain, err := d.orgs.GetDomainByHostname(ctx, hostname)
+	if err != nil && err != ErrDomainNotFound {
+		return CustomDomain{}, false, err
+	}
+	found := err == nil && domain.VerifiedAt != nil
+
+	d.mu.Lock()
+	// Hosts are picked by clients, so unknown ones must not grow the
+	// cache without bound.
+	if len(d.entries) >= maxCachedDomains {
+		d.entries = make(map[string]resolvedDomain)
+	}
+	d.entries[hostname] = resolvedDomain{domain: domain, found: found, expiresAt: time.Now().Add(domainCacheTTL)}
+	d.mu.Unlock()
+
+	return domain, found, nil
+}
+
+// Forget drops the cached lookup of hostname after its domain changed.
+func (d *DomainResolver) Forget(hostname string) {
+	d.mu.Lock()
+	delete(d.entries, hostname)
+	d.mu.Unlock()
+}
+
+// resolveDomainID checks that the custom domain links are to be issued
+// under is a verified domain of the organization of orgID. It returns 0
+// for an empty hostname, SHORT_DOMAINS.
+func resolveDomainID(ctx context.Context, orgs OrgStore, orgID int, hostname string) (int, error) {
+	if hostname == "" {
+		return 0, nil
+	}
+
+	hostname, ok := normalizeDomain(hostname)
+	if !ok {
+		return 0, ErrDomainNotFound
+	}
+
+	domains, err := orgs.ListDomainsByHostname(ctx, hostname)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, domain := range domains {
+		if domain.OrgID != orgID {
+			continue
+		}
+		if domain.VerifiedAt == nil {
+			return 0, errDomainNotVerified
+		}
+		return domain.ID, nil
+	}
+
+	return 0, ErrDomainNotFound
+}
+
+// DomainMiddleware resolves the domain query parameter of API requests, so
+// that the codes in their path are looked up in the code space of that
+// domain instead of the one of SHORT_DOMAINS.
+func DomainMiddleware(orgs OrgStore) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hostname := r.URL.Query().Get("domain")
+			if hostname == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			domainID, ok := lookupDomainID(w, r, orgs, hostname)
+			if !ok {
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), domainIDKey, domainID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// domainIDFromContext returns the domain whose code space the request
+// acts in, or 0 for the one of SHORT_DOMAINS.
+func domainIDFromContext(ctx context.Context) int {
+	domainID, _ := ctx.Value(domainIDKey).(int)
+	return domainID
+}
+
+// lookupDomainID is resolveDomainID for handlers. It writes a validation
+// error for a domain links cannot be issued under. Without a hostname,
+// the domain of the domain parameter applies.
+func lookupDomainID(w http.ResponseWriter, r *http.Request, orgs OrgStore, hostname string) (int, bool) {
+	if hostname == "" {
+		return domainIDFromContext(r.Context()), true
+	}
+
+	domainID, err := resolveDomainID(r.Context(), orgs, orgIDFromContext(r.Context()), hostname)
+	switch err {
+	case nil:
+		return domainID, true
+	case ErrDomainNotFound:
+		writeValidationError(w, &fieldError{Field: "domain", Message: "Domain not found"})
+	case errDomainNotVerified:
+		writeValidationError(w, &fieldError{Field: "domain", Message: "Domain is not verified"})
+	default:
+		slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	return 0, false
+}
+
+// linkShortURL returns the address of link, on its custom domain when it
+// was issued under one.
+func linkShortURL(r *http.Request, orgs OrgStore, link Link, orgSlug string) (string, error) {
+	if link.DomainID == 0 {
+		return shortURL(r, orgSlug, link.Code), nil
+	}
+
+	domain, err := orgs.GetDomain(r.Context(), link.OrgID, link.DomainID)
+	if err != nil {
+		return "", err
+	}
+
+	return domainShortURL(r, domain.Hostname, link.Code), nil
+}
+
+// verifyDomainOwnership looks for the token of domain in its TXT record
+// first and on the domain itself second.
+func verifyDomainOwnership(ctx context.Context, domain CustomDomain) bool {
+	records, err := net.DefaultResolver.LookupTXT(ctx, domainVerificationRecord+"."+domain.Hostname)
+	if err == nil {
+		for _, record := range records {
+			if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(record)), []byte(domain.VerificationToken)) == 1 {
+				return true
+			}
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+domain.Hostname+domainVerificationPath, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("User-Agent", "wowee-link-verify")
+
+	resp, err := pageClient.Do(req)
+	if err != nil {
+		slog.InfoContext(ctx, "Error fetching domain verification token", "error", err, "hostname", domain.Hostname)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(string(body))), []byte(domain.VerificationToken)) == 1
+}
+
+func newDomainVerificationToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return "wowee-link-verification=" + hex.EncodeToString(token), nil
+}
+
+// ListDomainsHandler lists the custom domains of the caller's
+// organization by hostname.
+func ListDomainsHandler(orgs OrgStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := requireRole(w, r, roleMember)
+		if !ok {
+			return
+		}
+
+		domains, err := orgs.ListDomains(r.Context(), c.OrgID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		for i := range domains {
+			domains[i] = domains[i].withVerification()
+		}
+
+		jsonResponse, err := json.Marshal(DomainsResponse{Domains: domains})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// CreateDomainHandler registers a custom domain for the caller's
+// organization. It answers with how to verify it; links are only served
+// on it once verified.
+func CreateDomainHandler(orgs OrgStore, resolver *DomainResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := requireRole(w, r, roleAdmin)
+		if !ok {
+			return
+		}
+
+		var request CreateDomainRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeInvalidBody(w, err)
+			return
+		}
+
+		hostname, ok := normalizeDomain(request.Hostname)
+		if !ok || strings.Contains(request.Hostname, "*") || !strings.Contains(hostname, ".") || net.ParseIP(hostname) != nil {
+			writeValidationError(w, &fieldError{Field: "hostname", Message: "Invalid hostname"})
+			return
+		}
+
+		if matchesDomain(hostname, shortDomains) {
+			writeValidationError(w, &fieldError{Field: "hostname", Message: "Hostname is served by this shortener already"})
+			return
+		}
+
+		token, err := newDomainVerificationToken()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error generating verification token", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		domain := CustomDomain{OrgID: c.OrgID, Hostname: hostname, VerificationToken: token}
+		err = orgs.CreateDomain(r.Context(), &domain)
+		if err != nil {
+			if err == ErrDomainTaken {
+				writeConflict(w, "domain_taken", "Domain is already registered")
+			} else {
+				slog.ErrorContext(r.Context(), "Error creating domain", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		resolver.Forget(hostname)
+
+		writeDomain(w, r, domain, http.StatusCreated)
+	}
+}
+
+func GetDomainHandler(orgs OrgStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := requireRole(w, r, roleMember)
+		if !ok {
+			return
+		}
+
+		domain, ok := findDomain(w, r, orgs, c.OrgID)
+		if !ok {
+			return
+		}
+
+		writeDomain(w, r, domain, http.StatusOK)
+	}
+}
+
+// VerifyDomainHandler checks that the caller's organization controls a
+// domain, through its TXT record or through the domain answering with its
+// token, and starts serving links on it if so.
+func VerifyDomainHandler(orgs OrgStore, resolver *DomainResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := requireRole(w, r, roleAdmin)
+		if !ok {
+			return
+		}
+
+		domain, ok := findDomain(w, r, orgs, c.OrgID)
+		if !ok {
+			return
+		}
+
+		if domain.VerifiedAt == nil {
+			if !verifyDomainOwnership(r.Context(), domain) {
+				writeConflict(w, "domain_unverified", "Neither the TXT record nor the HTTP token of the domain was found")
+				return
+			}
+
+			if err := orgs.VerifyDomain(r.Context(), &domain); err != nil {
+				if err == ErrDomainTaken {
+					writeConflict(w, "domain_taken", "Domain is verified by another organization")
+				} else {
+					slog.ErrorContext(r.Context(), "Error verifying domain", "error", err)
+					writeError(w, http.StatusInternalServerError, "Internal Server Error")
+				}
+				return
+			}
+
+			resolver.Forget(domain.Hostname)
+		}
+
+		writeDomain(w, r, domain, http.StatusOK)
+	}
+}
+
+// DeleteDomainHandler removes a custom domain. Domains that links were
+// issued under are kept, as their links would lose their address.
+func DeleteDomainHandler(orgs OrgStore, resolver *DomainResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := requireRole(w, r, roleAdmin)
+		if !ok {
+			return
+		}
+
+		domain, ok := findDomain(w, r, orgs, c.OrgID)
+		if !ok {
+			return
+		}
+
+		err := orgs.DeleteDomain(r.Context(), c.OrgID, domain.ID)
+		if err != nil {
+			switch err {
+			case ErrDomainNotFound:
+				writeError(w, http.StatusNotFound, "Domain not found")
+			case ErrDomainInUse:
+				writeConflict(w, "domain_in_use", "Links were issued under the domain")
+			default:
+				slog.ErrorContext(r.Context(), "Error deleting domain", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		resolver.Forget(domain.Hostname)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DomainVerificationHandler answers domainVerificationPath with the token
+// of the custom domain the request came in on, so pointing a domain at
+// this server is enough to verify it. Pointing it here does not show which
+// of several organizations claiming the domain controls it, so those have
+// to verify through the TXT record instead.
+func DomainVerificationHandler(orgs OrgStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		domains, err := orgs.ListDomainsByHostname(r.Context(), requestHost(r))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		if len(domains) != 1 {
+			http.NotFound(w, r)
+			return
+		}
+		domain := domains[0]
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, domain.VerificationToken)
+	}
+}
+
+// findDomain looks up the domain of the id in the request path within
+// orgID, writing the error when there is none.
+func findDomain(w http.ResponseWriter, r *http.Request, orgs OrgStore, orgID int) (CustomDomain, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Domain not found")
+		return CustomDomain{}, false
+	}
+
+	domain, err := orgs.GetDomain(r.Context(), orgID, id)
+	if err != nil {
+		if err == ErrDomainNotFound {
+			writeError(w, http.StatusNotFound, "Domain not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return domain, false
+	}
+
+	return domain, true
+}
+
+func writeDomain(w http.ResponseWriter, r *http.Request, domain CustomDomain, status int) {
+	jsonResponse, err := json.Marshal(domain.withVerification())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonResponse)
+}
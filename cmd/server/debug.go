@@ -0,0 +1,34 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+)
+
+// DebugHandler serves the runtime profiles of net/http/pprof under
+// /debug/pprof/ and the expvar variables, panics_recovered among them, on
+// /debug/vars.
+func DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	return mux
+}
+
+// requireAdminAPIKey answers 403 to requests without the admin API key.
+func requireAdminAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdminAPIKey(apiKeyFromRequest(r)) {
+			writeError(w, http.StatusForbidden, "Admin API key required")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
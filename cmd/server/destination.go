@@ -0,0 +1,501 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ErrLinkHasDestinations is returned when an update changes the URL of a
+// link with destinations without changing the destinations.
+var ErrLinkHasDestinations = errors.New("url of a link with destinations is changed through its destinations")
+
+const (
+	maxLinkDestinations      = 10
+	maxDestinationWeight     = 1000
+	defaultDestinationWeight = 1
+)
+
+// destinationURL is where a visit of link is sent. The query of the short
+// URL, when the link forwards it, and the UTM parameters of the link are
+// appended to its URL. Parameters the URL already has are never replaced,
+// so that a destination can pin its own values, such as an affiliate tag,
+// and forwarded parameters take precedence over the UTM defaults. query
+// is a raw query string, empty outside of redirects. A URL that does not
+// parse is returned unchanged.
+func destinationURL(link Link, query string) string {
+	if !link.ForwardQuery {
+		query = ""
+	}
+
+	if query == "" && link.UTMParams.empty() {
+		return link.URL
+	}
+
+	u, err := url.Parse(link.URL)
+	if err != nil {
+		return link.URL
+	}
+
+	pinned := u.Query()
+	forwarded := make(map[string]bool)
+	var added []string
+
+	// Forwarded pairs are kept as the client wrote them rather than
+	// re-encoded, and so is the existing query.
+	for _, pair := range strings.Split(query, "&") {
+		name, _, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(name)
+		if pair == "" || err != nil || pinned.Has(name) {
+			continue
+		}
+
+		forwarded[name] = true
+		added = append(added, pair)
+	}
+
+	for _, field := range link.UTMParams.fields() {
+		if field.value != nil && !pinned.Has(field.name) && !forwarded[field.name] {
+			added = append(added, field.name+"="+url.QueryEscape(*field.value))
+		}
+	}
+
+	if len(added) == 0 {
+		return link.URL
+	}
+
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += strings.Join(added, "&")
+
+	return u.String()
+}
+
+// forwardedQuery is the raw query of a visit without the preview
+// parameter, which asks for the interstitial and is not meant for the
+// destination.
+func forwardedQuery(r *http.Request) string {
+	var pairs []string
+	for _, pair := range strings.Split(r.URL.RawQuery, "&") {
+		name, _, _ := strings.Cut(pair, "=")
+		if pair != "" && name != "preview" {
+			pairs = append(pairs, pair)
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// LinkDestination is one of the URLs a link splits its visits between.
+// Visits are sent to a destination in proportion to its weight; a weight
+// of 0 pauses it. ID is derived from the URL, so that the clicks of a
+// destination keep adding up however the others change, and a URL that is
+// removed and added back resumes its count.
+type LinkDestination struct {
+	ID     int    `json:"id"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// linkDestinations is stored as a JSON array, and as NULL when the link
+// has a single destination.
+type linkDestinations []LinkDestination
+
+func (d linkDestinations) Value() (driver.Value, error) {
+	if len(d) == 0 {
+		return nil, nil
+	}
+
+	return jsonColumnValue([]LinkDestination(d))
+}
+
+func (d *linkDestinations) Scan(src interface{}) error {
+	*d = nil
+	return scanJSONColumn(src, (*[]LinkDestination)(d))
+}
+
+// jsonColumnValue encodes v for a TEXT column holding JSON.
+func jsonColumnValue(v interface{}) (driver.Value, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// scanJSONColumn decodes a TEXT column holding JSON into dst, which is
+// left alone when the column is NULL.
+func scanJSONColumn(src interface{}, dst interface{}) error {
+	var data []byte
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case string:
+		data = []byte(src)
+	case []byte:
+		data = src
+	default:
+		return fmt.Errorf("cannot scan %T into %T", src, dst)
+	}
+
+	return json.Unmarshal(data, dst)
+}
+
+// DestinationRequest is a destination as clients send it. Weight defaults
+// to 1.
+type DestinationRequest struct {
+	URL    string `json:"url"`
+	Weight *int   `json:"weight,omitempty"`
+}
+
+// validateDestinations returns a *fieldError for the first problem with
+// the destinations of a request, which need at least two distinct URLs and
+// one positive weight.
+func validateDestinations(destinations []DestinationRequest) error {
+	if len(destinations) < 2 || len(destinations) > maxLinkDestinations {
+		return &fieldError{Field: "destinations", Message: fmt.Sprintf("destinations must list between 2 and %d URLs", maxLinkDestinations)}
+	}
+
+	seen := make(map[string]bool)
+	total := 0
+	for i, destination := range destinations {
+		field := fmt.Sprintf("destinations[%d]", i)
+
+		if destination.URL == "" {
+			return &fieldError{Field: field + ".url", Message: "URL is required"}
+		}
+
+		if seen[destination.URL] {
+			return &fieldError{Field: field + ".url", Message: "URL is already one of the destinations"}
+		}
+		seen[destination.URL] = true
+
+		if destination.Weight != nil {
+			if *destination.Weight < 0 || *destination.Weight > maxDestinationWeight {
+				return &fieldError{Field: field + ".weight", Message: fmt.Sprintf("weight must be between 0 and %d", maxDestinationWeight)}
+			}
+			total += *destination.Weight
+		} else {
+			total += defaultDestinationWeight
+		}
+	}
+
+	if total == 0 {
+		return &fieldError{Field: "destinations", Message: "At least one destination needs a positive weight"}
+	}
+
+	return nil
+}
+
+// useDestinations validates and unwraps the destinations of the request
+// and makes the first one its URL, which the request may repeat.
+func (r *ShortenRequest) useDestinations(ctx context.Context) error {
+	if err := validateDestinations(r.Destinations); err != nil {
+		return err
+	}
+
+	if r.URL != "" && r.URL != r.Destinations[0].URL {
+		return &fieldError{Field: "url", Message: "url must be the first destination"}
+	}
+
+	if err := unwrapDestinations(ctx, r.Destinations); err != nil {
+		return err
+	}
+	r.URL = r.Destinations[0].URL
+
+	return nil
+}
+
+// validateDestinationsUpdate is useDestinations for an update. An empty
+// list, which removes the destinations, needs no checks.
+func validateDestinationsUpdate(ctx context.Context, request UpdateLinkRequest) error {
+	if request.Destinations == nil || len(*request.Destinations) == 0 {
+		return nil
+	}
+
+	destinations := *request.Destinations
+	if err := validateDestinations(destinations); err != nil {
+		return err
+	}
+
+	if request.URL != nil && *request.URL != destinations[0].URL {
+		return &fieldError{Field: "url", Message: "url must be the first destination"}
+	}
+
+	return unwrapDestinations(ctx, destinations)
+}
+
+// applyDestinationsUpdate sets the destinations of link that are present
+// in request. A link with destinations has its URL changed through them
+// only.
+func applyDestinationsUpdate(link *Link, request UpdateLinkRequest) error {
+	if request.Destinations != nil {
+		link.Destinations = newLinkDestinations(*request.Destinations)
+		if len(link.Destinations) > 0 {
+			link.URL = link.Destinations[0].URL
+		}
+	} else if request.URL != nil && len(link.Destinations) > 0 && *request.URL != link.URL {
+		return ErrLinkHasDestinations
+	}
+
+	if request.StickyDestinations != nil {
+		link.StickyDestinations = *request.StickyDestinations
+	}
+
+	return nil
+}
+
+// unwrapDestinations unwraps the destination URLs in place.
+func unwrapDestinations(ctx context.Context, destinations []DestinationRequest) error {
+	for i := range destinations {
+		unwrapped, err := unwrapURL(ctx, destinations[i].URL)
+		if err != nil {
+			return &fieldError{Field: fmt.Sprintf("destinations[%d].url", i), Message: err.Error()}
+		}
+		destinations[i].URL = unwrapped
+	}
+
+	return nil
+}
+
+// checkURLs applies the domain rules and the URL checker to every URL a
+// link may send visits to. It writes the response and returns false when
+// one is refused.
+func checkURLs(w http.ResponseWriter, r *http.Request, domains *DomainPolicy, checker URLChecker, urls []string) bool {
+	for _, rawURL := range urls {
+		if !domains.checkDomain(w, r, rawURL) {
+			return false
+		}
+
+		if verdict, unsafe := isUnsafeURL(r.Context(), checker, rawURL); unsafe {
+			writeUnsafeURL(w, verdict)
+			return false
+		}
+	}
+
+	return true
+}
+
+// routedURLs are the URLs a link created by the request may send visits
+// to, its fallback URL included.
+func (r ShortenRequest) routedURLs() []string {
+	urls := []string{r.URL}
+	if len(r.Destinations) > 0 {
+		urls = nil
+	}
+
+	for _, destination := range r.Destinations {
+		urls = append(urls, destination.URL)
+	}
+	for _, target := range r.GeoTargets {
+		urls = append(urls, target.URL)
+	}
+	for _, target := range r.DeviceTargets {
+		urls = append(urls, target.URL)
+	}
+	for _, rule := range r.RoutingRules {
+		urls = append(urls, rule.URL)
+	}
+	if r.FallbackURL != "" {
+		urls = append(urls, r.FallbackURL)
+	}
+
+	return urls
+}
+
+// routedURLs are the URLs of the destinations, targets and fallback the
+// update sets.
+func (r UpdateLinkRequest) routedURLs() []string {
+	var urls []string
+	if r.Destinations != nil {
+		for _, destination := range *r.Destinations {
+			urls = append(urls, destination.URL)
+		}
+	}
+	if r.GeoTargets != nil {
+		for _, target := range *r.GeoTargets {
+			urls = append(urls, target.URL)
+		}
+	}
+	if r.DeviceTargets != nil {
+		for _, target := range *r.DeviceTargets {
+			urls = append(urls, target.URL)
+		}
+	}
+	if r.RoutingRules != nil {
+		for _, rule := range *r.RoutingRules {
+			urls = append(urls, rule.URL)
+		}
+	}
+	if r.FallbackURL != nil && *r.FallbackURL != "" {
+		urls = append(urls, *r.FallbackURL)
+	}
+
+	return urls
+}
+
+// newLinkDestinations gives the requested destinations their IDs and
+// default weights.
+func newLinkDestinations(requested []DestinationRequest) linkDestinations {
+	if len(requested) == 0 {
+		return nil
+	}
+
+	destinations := make(linkDestinations, len(requested))
+	for i, destination := range requested {
+		weight := defaultDestinationWeight
+		if destination.Weight != nil {
+			weight = *destination.Weight
+		}
+
+		destinations[i] = LinkDestination{ID: destinationID(destination.URL), URL: destination.URL, Weight: weight}
+	}
+
+	return destinations
+}
+
+// destinationID is the ID of the destination of a URL: 31 bits of its
+// hash, to fit the INTEGER column of every database.
+func destinationID(rawURL string) int {
+	sum := sha256.Sum256([]byte(rawURL))
+	return int(binary.BigEndian.Uint32(sum[:4]) &^ (1 << 31))
+}
+
+// routeClick points link at the URL a visit is sent to and returns the ID
+// of the destination it picked, if any. The first routing rule the visit
+// meets takes precedence over a device target matching the visitor's
+// device, which takes precedence over a geo target matching their
+// location, which takes precedence over the destinations; visits sent to
+// a rule or target are not counted towards a destination.
+func routeClick(link *Link, countries CountryLookup, click Click) int {
+	if len(link.RoutingRules) > 0 {
+		if rule, ok := link.RoutingRules.match(newRuleVisit(countries, click)); ok {
+			link.URL = rule.URL
+			return 0
+		}
+	}
+
+	if len(link.DeviceTargets) > 0 {
+		if target, ok := link.DeviceTargets.match(classifyUserAgent(click.UserAgent)); ok {
+			link.URL = target.URL
+			return 0
+		}
+	}
+
+	if len(link.GeoTargets) > 0 {
+		if target, ok := link.GeoTargets.match(countries.Locate(click.IP)); ok {
+			link.URL = target.URL
+			return 0
+		}
+	}
+
+	return chooseDestination(link, click.IP)
+}
+
+// chooseDestination points link at the destination picked for a visit
+// from ip and returns its ID, or 0 for links with a single destination.
+// Destinations are picked at random in proportion to their weights.
+// Sticky links pick by the hash of the visitor's address instead, so that
+// a visitor keeps seeing the same destination while the weights stay.
+func chooseDestination(link *Link, ip string) int {
+	total := 0
+	for _, destination := range link.Destinations {
+		total += destination.Weight
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	var point int
+	if link.StickyDestinations {
+		sum := sha256.Sum256([]byte(strconv.Itoa(link.ID) + ":" + stringValue(hashIP(ip))))
+		point = int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	} else {
+		point = rand.Intn(total)
+	}
+
+	for _, destination := range link.Destinations {
+		if point < destination.Weight {
+			link.URL = destination.URL
+			return destination.ID
+		}
+		point -= destination.Weight
+	}
+
+	return 0
+}
+
+// GetURLDestinationsHandler returns the clicks of every destination of a
+// link. Clicks of destinations that have been removed are not listed.
+func GetURLDestinationsHandler(links LinkStore, clicks ClickStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		code := vars["code"]
+		var startTime = time.Now()
+
+		link, err := links.GetLink(r.Context(), orgIDFromContext(r.Context()), domainIDFromContext(r.Context()), code)
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		counts, err := clicks.DestinationClicks(r.Context(), link.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		byID := make(map[int]int64, len(counts))
+		for _, count := range counts {
+			byID[count.DestinationID] = count.Clicks
+		}
+
+		response := DestinationsResponse{
+			Code:               link.Code,
+			StickyDestinations: link.StickyDestinations,
+			Destinations:       make([]DestinationClicks, 0, len(link.Destinations)),
+		}
+
+		for _, destination := range link.Destinations {
+			response.Destinations = append(response.Destinations, DestinationClicks{
+				LinkDestination: destination,
+				Clicks:          byID[destination.ID],
+			})
+		}
+
+		response.ElapsedTime = time.Since(startTime).Milliseconds()
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+const maxDeviceTargets = 20
+
+// targetOperatingSystems maps the operating systems device targets may
+// name to the names classifyUserAgent reports.
+var targetOperatingSystems = map[string]string{
+	"ios":      "iOS",
+	"android":  "Android",
+	"windows":  "Windows",
+	"macos":    "macOS",
+	"linux":    "Linux",
+	"chromeos": "ChromeOS",
+}
+
+var targetDeviceTypes = map[string]bool{
+	deviceDesktop: true,
+	deviceMobile:  true,
+	deviceTablet:  true,
+}
+
+// DeviceTarget sends the visitors of a link on one operating system or
+// device type to URL instead of the default destination, such as an app
+// store page or a custom scheme that opens an app. OS takes precedence
+// over a matching device type. Bots never match, so that link previews
+// show the default destination.
+type DeviceTarget struct {
+	OS     string `json:"os,omitempty"`
+	Device string `json:"device,omitempty"`
+	URL    string `json:"url"`
+}
+
+// linkDeviceTargets is stored as a JSON array, and as NULL when the link
+// has none.
+type linkDeviceTargets []DeviceTarget
+
+func (t linkDeviceTargets) Value() (driver.Value, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+
+	return jsonColumnValue([]DeviceTarget(t))
+}
+
+func (t *linkDeviceTargets) Scan(src interface{}) error {
+	*t = nil
+	return scanJSONColumn(src, (*[]DeviceTarget)(t))
+}
+
+// match returns the target of the operating system of device, or else the
+// one of its type.
+func (t linkDeviceTargets) match(device Device) (DeviceTarget, bool) {
+	if device.Type == deviceBot || device.Type == deviceUnknown {
+		return DeviceTarget{}, false
+	}
+
+	var byType *DeviceTarget
+	for i, target := range t {
+		if target.OS != "" && targetOperatingSystems[target.OS] == device.OS {
+			return target, true
+		}
+		if byType == nil && target.Device == device.Type {
+			byType = &t[i]
+		}
+	}
+
+	if byType != nil {
+		return *byType, true
+	}
+
+	return DeviceTarget{}, false
+}
+
+// prepareDeviceTargets lower-cases the names of the targets, returns a
+// *fieldError for the first invalid one and unwraps their URLs in place.
+// Every target names either an operating system or a device type, and no
+// two name the same.
+func prepareDeviceTargets(ctx context.Context, targets []DeviceTarget) error {
+	if len(targets) > maxDeviceTargets {
+		return &fieldError{Field: "device_targets", Message: fmt.Sprintf("A link is limited to %d device targets", maxDeviceTargets)}
+	}
+
+	seen := make(map[DeviceTarget]bool)
+	for i := range targets {
+		target := &targets[i]
+		field := fmt.Sprintf("device_targets[%d]", i)
+
+		target.OS = strings.ToLower(target.OS)
+		target.Device = strings.ToLower(target.Device)
+
+		switch {
+		case (target.OS == "") == (target.Device == ""):
+			return &fieldError{Field: field, Message: "Device target needs either an os or a device"}
+		case target.OS != "" && targetOperatingSystems[target.OS] == "":
+			return &fieldError{Field: field + ".os", Message: "os must be ios, android, windows, macos, linux or chromeos"}
+		case target.Device != "" && !targetDeviceTypes[target.Device]:
+			return &fieldError{Field: field + ".device", Message: "device must be desktop, mobile or tablet"}
+		}
+
+		key := DeviceTarget{OS: target.OS, Device: target.Device}
+		if seen[key] {
+			return &fieldError{Field: field, Message: "Device target repeats the os or device of another target"}
+		}
+		seen[key] = true
+
+		if target.URL == "" {
+			return &fieldError{Field: field + ".url", Message: "URL is required"}
+		}
+
+		unwrapped, err := unwrapURL(ctx, target.URL)
+		if err != nil {
+			return &fieldError{Field: field + ".url", Message: err.Error()}
+		}
+		target.URL = unwrapped
+	}
+
+	return nil
+}
+
+// applyDeviceTargetsUpdate sets the device targets of link when request
+// has them. An empty list removes them.
+func applyDeviceTargetsUpdate(link *Link, request UpdateLinkRequest) {
+	if request.DeviceTargets != nil {
+		link.DeviceTargets = newLinkDeviceTargets(*request.DeviceTargets)
+	}
+}
+
+func newLinkDeviceTargets(targets []DeviceTarget) linkDeviceTargets {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	return linkDeviceTargets(targets)
+}
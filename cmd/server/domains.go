@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+	"github.com/gorilla/mux"
+)
+
+const (
+	domainBlock = "block"
+	domainAllow = "allow"
+
+	maxDomainLength = 253
+)
+
+type DomainRule struct {
+	ID        int       `db:"id" json:"id"`
+	Domain    string    `db:"domain" json:"domain"`
+	List      string    `db:"list" json:"list"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// DomainPolicy decides which destination domains links may point to. Its
+// rules come from DOMAIN_BLOCKLIST and DOMAIN_ALLOWLIST together with
+// those stored through the /domain-rules endpoints. A rule covers the
+// domain and its subdomains.
+type DomainPolicy struct {
+	rules   DomainRuleStore
+	blocked []string
+	allowed []string
+}
+
+func NewDomainPolicy(cfg *config.Config, rules DomainRuleStore) (*DomainPolicy, error) {
+	blocked, err := configDomains(cfg, "DOMAIN_BLOCKLIST")
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, err := configDomains(cfg, "DOMAIN_ALLOWLIST")
+	if err != nil {
+		return nil, err
+	}
+
+	return &DomainPolicy{rules: rules, blocked: blocked, allowed: allowed}, nil
+}
+
+// domainRules is a snapshot of a policy, so that requests that check many
+// URLs read the stored rules once.
+type domainRules struct {
+	blocked []string
+	allowed []string
+}
+
+func (p *DomainPolicy) load(ctx context.Context) (domainRules, error) {
+	stored, err := p.rules.ListDomainRules(ctx)
+	if err != nil {
+		return domainRules{}, err
+	}
+
+	rules := domainRules{
+		blocked: append([]string(nil), p.blocked...),
+		allowed: append([]string(nil), p.allowed...),
+	}
+
+	for _, rule := range stored {
+		if rule.List == domainBlock {
+			rules.blocked = append(rules.blocked, rule.Domain)
+		} else {
+			rules.allowed = append(rules.allowed, rule.Domain)
+		}
+	}
+
+	return rules, nil
+}
+
+// check returns why rawURL may not be linked to, or an empty string. A
+// blocked domain is refused even when it is also allowed, and once any
+// domain is allowed every other domain is refused.
+func (rules domainRules) check(rawURL string) string {
+	host := urlHost(rawURL)
+
+	if host != "" && matchesDomain(host, rules.blocked) {
+		return "URL domain is blocked"
+	}
+
+	if len(rules.allowed) > 0 && (host == "" || !matchesDomain(host, rules.allowed)) {
+		return "URL domain is not allowed"
+	}
+
+	return ""
+}
+
+func matchesDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkDomain answers 400 and returns false when the policy refuses
+// rawURL.
+func (p *DomainPolicy) checkDomain(w http.ResponseWriter, r *http.Request, rawURL string) bool {
+	rules, err := p.load(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return false
+	}
+
+	if message := rules.check(rawURL); message != "" {
+		writeErrorCode(w, http.StatusBadRequest, "domain_not_allowed", message, map[string]interface{}{"field": "url"})
+		return false
+	}
+
+	return true
+}
+
+// ListDomainRulesHandler returns the stored domain rules. Rules configured
+// through the environment are not included.
+func ListDomainRulesHandler(rules DomainRuleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdminAPIKey(apiKeyFromRequest(r)) {
+			writeError(w, http.StatusForbidden, "Admin API key required")
+			return
+		}
+
+		stored, err := rules.ListDomainRules(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		jsonResponse, err := json.Marshal(DomainRulesResponse{Rules: stored})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// CreateDomainRuleHandler puts a domain on the blocklist or the allowlist.
+// Existing links are not affected.
+func CreateDomainRuleHandler(rules DomainRuleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdminAPIKey(apiKeyFromRequest(r)) {
+			writeError(w, http.StatusForbidden, "Admin API key required")
+			return
+		}
+
+		var request CreateDomainRuleRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeInvalidBody(w, err)
+			return
+		}
+
+		domain, ok := normalizeDomain(request.Domain)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Invalid domain")
+			return
+		}
+
+		if request.List != domainBlock && request.List != domainAllow {
+			writeError(w, http.StatusBadRequest, "list must be block or allow")
+			return
+		}
+
+		rule := DomainRule{Domain: domain, List: request.List}
+
+		err := rules.CreateDomainRule(r.Context(), &rule)
+		if err != nil {
+			if err == ErrDomainRuleExists {
+				writeConflict(w, "domain_rule_exists", "Domain already has a rule")
+			} else {
+				slog.ErrorContext(r.Context(), "Error creating domain rule", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		jsonResponse, err := json.Marshal(rule)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(jsonResponse)
+	}
+}
+
+func DeleteDomainRuleHandler(rules DomainRuleStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdminAPIKey(apiKeyFromRequest(r)) {
+			writeError(w, http.StatusForbidden, "Admin API key required")
+			return
+		}
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Domain rule not found")
+			return
+		}
+
+		err = rules.DeleteDomainRule(r.Context(), id)
+		if err != nil {
+			if err == ErrDomainRuleNotFound {
+				writeError(w, http.StatusNotFound, "Domain rule not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error deleting domain rule", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// configDomains reads a list setting of domains.
+func configDomains(cfg *config.Config, name string) ([]string, error) {
+	var domains []string
+
+	for _, field := range cfg.List(name) {
+		domain, ok := normalizeDomain(field)
+		if !ok {
+			return nil, fmt.Errorf("%s contains an invalid domain %q", name, field)
+		}
+		domains = append(domains, domain)
+	}
+
+	return domains, nil
+}
+
+// normalizeDomain lowercases domain and strips a leading wildcard, since
+// rules cover subdomains anyway.
+func normalizeDomain(domain string) (string, bool) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	domain = strings.TrimPrefix(domain, "*.")
+	domain = strings.TrimSuffix(domain, ".")
+
+	if domain == "" || len(domain) > maxDomainLength {
+		return "", false
+	}
+
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", false
+		}
+
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return "", false
+			}
+		}
+	}
+
+	return domain, true
+}
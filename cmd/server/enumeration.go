@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// codeEnumeration counts the unknown codes asked for by anonymous
+// clients ("misses"), the bans they earned ("bans") and the requests
+// refused while banned ("blocked"). It is published through expvar as
+// code_enumeration.
+var codeEnumeration = expvar.NewMap("code_enumeration")
+
+// BanStore keeps the addresses banned from resolving codes.
+type BanStore interface {
+	// BannedFor returns how long key stays banned, or zero.
+	BannedFor(ctx context.Context, key string) (time.Duration, error)
+	// Ban bans key for base, doubled for every ban key earned within
+	// longest of its previous one, and at most for longest.
+	Ban(ctx context.Context, key string, base, longest time.Duration) (time.Duration, error)
+}
+
+// NewBanStore keeps bans where RATE_LIMIT_STORE counts rate limits.
+func NewBanStore(cfg *config.Config, redisClient *redis.Client) (BanStore, error) {
+	switch cfg.String("RATE_LIMIT_STORE") {
+	case "memory":
+		return NewMemoryBanStore(), nil
+	case "redis":
+		if redisClient == nil {
+			return nil, errors.New("RATE_LIMIT_STORE=redis requires REDIS_URL")
+		}
+		return &RedisBanStore{client: redisClient}, nil
+	default:
+		return nil, errors.New("RATE_LIMIT_STORE must be memory or redis")
+	}
+}
+
+// EnumerationGuard bans the anonymous clients that ask for more unknown
+// codes than ENUMERATION_MISSES_PER_MINUTE, which is what scanning for
+// private links looks like. Bans start at ENUMERATION_BAN and double for
+// repeat offenders up to ENUMERATION_MAX_BAN.
+type EnumerationGuard struct {
+	limits RateLimitStore
+	bans   BanStore
+	misses RateLimit
+	ban    time.Duration
+	maxBan time.Duration
+}
+
+// NewEnumerationGuard reads the guard settings. A zero
+// ENUMERATION_MISSES_PER_MINUTE disables the guard.
+func NewEnumerationGuard(cfg *config.Config, limits RateLimitStore, bans BanStore) *EnumerationGuard {
+	return &EnumerationGuard{
+		limits: limits,
+		bans:   bans,
+		misses: RateLimit{PerMinute: cfg.Int("ENUMERATION_MISSES_PER_MINUTE")},
+		ban:    cfg.Duration("ENUMERATION_BAN"),
+		maxBan: cfg.Duration("ENUMERATION_MAX_BAN"),
+	}
+}
+
+// Middleware guards a handler resolving codes. A response of 404 counts
+// as a miss of the client address. Requests with an API key are left
+// alone, since their misses are the caller's own business.
+func (g *EnumerationGuard) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.misses.PerMinute <= 0 || authenticatedRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := clientIP(r)
+		key := "enumeration:ip:" + ip
+
+		// Store errors fail open, as they do for rate limits.
+		bannedFor, err := g.bans.BannedFor(r.Context(), key)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error checking enumeration ban", "error", err)
+		} else if bannedFor > 0 {
+			codeEnumeration.Add("blocked", 1)
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(bannedFor.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "Too Many Requests")
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status != http.StatusNotFound {
+			return
+		}
+		codeEnumeration.Add("misses", 1)
+
+		result, err := g.limits.Take(r.Context(), key, g.misses)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error counting unknown codes", "error", err)
+			return
+		}
+		if result.Allowed {
+			return
+		}
+
+		ban, err := g.bans.Ban(r.Context(), key, g.ban, g.maxBan)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error banning address", "error", err)
+			return
+		}
+		codeEnumeration.Add("bans", 1)
+		slog.WarnContext(r.Context(), "Banned address scanning for codes", "ip", ip, "ban", ban.String())
+	})
+}
+
+// banLength doubles base for every strike after the first, up to longest.
+func banLength(strikes int, base, longest time.Duration) time.Duration {
+	ban := base
+	for i := 1; i < strikes && ban < longest; i++ {
+		ban *= 2
+	}
+
+	return min(ban, longest)
+}
+
+type memoryBan struct {
+	until   time.Time
+	strikes int
+}
+
+// MemoryBanStore keeps bans in process memory. Bans whose strikes have
+// been forgotten are dropped by a periodic sweep.
+type MemoryBanStore struct {
+	mu   sync.Mutex
+	bans map[string]*memoryBan
+	// forgetAfter is the largest longest passed to Ban, for the sweep.
+	forgetAfter time.Duration
+}
+
+func NewMemoryBanStore() *MemoryBanStore {
+	store := &MemoryBanStore{bans: make(map[string]*memoryBan)}
+
+	go store.sweep(time.Minute)
+
+	return store
+}
+
+func (s *MemoryBanStore) BannedFor(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ban, ok := s.bans[key]
+	if !ok {
+		return 0, nil
+	}
+
+	return max(time.Until(ban.until), 0), nil
+}
+
+func (s *MemoryBanStore) Ban(ctx context.Context, key string, base, longest time.Duration) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.forgetAfter = max(s.forgetAfter, longest)
+
+	ban, ok := s.bans[key]
+	if !ok || now.Sub(ban.until) > longest {
+		ban = &memoryBan{}
+		s.bans[key] = ban
+	}
+
+	ban.strikes++
+	length := banLength(ban.strikes, base, longest)
+	ban.until = now.Add(length)
+
+	return length, nil
+}
+
+func (s *MemoryBanStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		for key, ban := range s.bans {
+			if time.Since(ban.until) > s.forgetAfter {
+				delete(s.bans, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// banScript counts a strike against a key and bans it for base doubled
+// per earlier strike, up to longest. Strikes are forgotten longest after
+// the ban they led to ends.
+var banScript = redis.NewScript(`
+local base = tonumber(ARGV[1])
+local longest = tonumber(ARGV[2])
+
+local strikes = redis.call("INCR", KEYS[2])
+local ban = math.min(base * 2 ^ (strikes - 1), longest)
+
+redis.call("SET", KEYS[1], "1", "PX", math.ceil(ban))
+redis.call("PEXPIRE", KEYS[2], math.ceil(ban + longest))
+
+return tostring(math.ceil(ban))
+`)
+
+// RedisBanStore shares bans between instances through Redis.
+type RedisBanStore struct {
+	client *redis.Client
+}
+
+func (s *RedisBanStore) BannedFor(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, "ban:"+key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// PTTL answers negative durations for missing keys.
+	return max(ttl, 0), nil
+}
+
+func (s *RedisBanStore) Ban(ctx context.Context, key string, base, longest time.Duration) (time.Duration, error) {
+	args := []interface{}{base.Milliseconds(), longest.Milliseconds()}
+
+	value, err := banScript.Run(ctx, s.client, []string{"ban:" + key, "ban:strikes:" + key}, args...).Text()
+	if err != nil {
+		return 0, err
+	}
+
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(ms) * time.Millisecond, nil
+}
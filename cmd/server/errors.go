@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+// errorCodes are the error codes of statuses answered without a more
+// specific one.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "invalid_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusNotAcceptable:       "not_acceptable",
+	http.StatusConflict:            "conflict",
+	http.StatusGone:                "gone",
+	http.StatusTooManyRequests:     "rate_limited",
+	http.StatusInternalServerError: "internal_error",
+	http.StatusServiceUnavailable:  "unavailable",
+}
+
+// fieldError is a validation error of one field of a request body.
+type fieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *fieldError) Error() string {
+	return e.Message
+}
+
+// writeError answers with an ErrorResponse carrying the generic code of
+// the status.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeErrorCode(w, status, errorCodes[status], message, nil)
+}
+
+func writeErrorCode(w http.ResponseWriter, status int, code string, message string, details map[string]interface{}) {
+	if code == "" {
+		code = "error"
+	}
+
+	response := ErrorResponse{
+		Error: ErrorDetail{
+			Code:    code,
+			Message: message,
+			Details: details,
+		},
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(jsonResponse)
+}
+
+func writeConflict(w http.ResponseWriter, errorCode string, message string) {
+	writeErrorCode(w, http.StatusConflict, errorCode, message, nil)
+}
+
+// writeValidationError answers a 400 for err, naming the offending field
+// in the details when err is a fieldError.
+func writeValidationError(w http.ResponseWriter, err error) {
+	var details map[string]interface{}
+
+	var fieldErr *fieldError
+	if errors.As(err, &fieldErr) {
+		details = map[string]interface{}{"field": fieldErr.Field}
+	}
+
+	writeErrorCode(w, http.StatusBadRequest, errorCodes[http.StatusBadRequest], err.Error(), details)
+}
+
+// writeServiceError answers with the ErrorResponse of a linkService
+// failure, which is a 500 for errors that are not a *serviceError.
+func writeServiceError(w http.ResponseWriter, err error) {
+	var serviceErr *serviceError
+	if !errors.As(err, &serviceErr) {
+		serviceErr = errServiceInternal
+	}
+
+	code := serviceErr.Code
+	if code == "" {
+		code = errorCodes[serviceErr.Status]
+	}
+
+	writeErrorCode(w, serviceErr.Status, code, serviceErr.Message, serviceErr.Details)
+}
+
+// writeInvalidBody answers a 400 for a request body that could not be
+// decoded, with where decoding failed in the details.
+func writeInvalidBody(w http.ResponseWriter, err error) {
+	writeErrorCode(w, http.StatusBadRequest, errorCodes[http.StatusBadRequest], "Invalid request body", bodyErrorDetails(err))
+}
+
+func bodyErrorDetails(err error) map[string]interface{} {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return map[string]interface{}{"reason": "body is empty"}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return map[string]interface{}{"reason": "malformed JSON"}
+	case errors.As(err, &syntaxErr):
+		return map[string]interface{}{"reason": "malformed JSON", "offset": syntaxErr.Offset}
+	case errors.As(err, &typeErr):
+		details := map[string]interface{}{"reason": "wrong type", "expected": jsonType(typeErr.Type)}
+		if typeErr.Field != "" {
+			details["field"] = typeErr.Field
+		}
+		return details
+	}
+
+	return map[string]interface{}{"reason": err.Error()}
+}
+
+// jsonType names the JSON type a Go type is decoded from.
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+
+	return "object"
+}
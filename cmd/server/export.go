@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+
+	// exportFlushRows is how many rows are written between flushes so that
+	// clients see progress on long exports.
+	exportFlushRows = 500
+)
+
+var linkExportHeader = []string{"code", "url", "created_at", "expires_at", "updated_at", "redirect_status", "max_clicks", "attempt_count", "click_count", "bot_clicks", "tracking_disabled", "forward_query", "utm_source", "utm_medium", "utm_campaign"}
+
+var clickExportHeader = []string{"code", "date", "clicks"}
+
+type linkExportRow struct {
+	Code             string     `json:"code"`
+	URL              string     `json:"url"`
+	CreatedAt        time.Time  `json:"created_at"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	UpdatedAt        *time.Time `json:"updated_at"`
+	RedirectStatus   int        `json:"redirect_status"`
+	MaxClicks        *int       `json:"max_clicks"`
+	AttemptCount     int        `json:"attempt_count"`
+	ClickCount       int        `json:"click_count"`
+	BotClicks        int        `json:"bot_clicks"`
+	TrackingDisabled bool       `json:"tracking_disabled"`
+	ForwardQuery     bool       `json:"forward_query"`
+	UTMParams
+}
+
+type clickExportRow struct {
+	Code   string `json:"code"`
+	Date   string `json:"date"`
+	Clicks int64  `json:"clicks"`
+}
+
+// ExportLinksHandler streams every link that is not deleted, optionally
+// limited to a created_from/created_to range, as CSV or NDJSON.
+func ExportLinksHandler(links LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		format, ok := negotiateExportFormat(r)
+		if !ok {
+			writeError(w, http.StatusNotAcceptable, "format must be csv or ndjson")
+			return
+		}
+
+		filter := LinkFilter{OrgID: orgIDFromContext(r.Context())}
+
+		if value := params.Get("created_from"); value != "" {
+			createdFrom, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "created_from must be an RFC 3339 timestamp")
+				return
+			}
+			filter.CreatedFrom = &createdFrom
+		}
+
+		if value := params.Get("created_to"); value != "" {
+			createdTo, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "created_to must be an RFC 3339 timestamp")
+				return
+			}
+			filter.CreatedTo = &createdTo
+		}
+
+		export := newExportWriter(w, format, "links", linkExportHeader)
+
+		err := links.ExportLinks(r.Context(), filter, func(link Link) error {
+			row := linkExportRow{
+				Code:             link.Code,
+				URL:              link.URL,
+				CreatedAt:        link.CreatedAt,
+				ExpiresAt:        link.ExpiresAt,
+				UpdatedAt:        link.UpdatedAt,
+				RedirectStatus:   link.RedirectStatus,
+				MaxClicks:        link.MaxClicks,
+				AttemptCount:     link.AttemptCount,
+				ClickCount:       link.ClickCount,
+				BotClicks:        link.BotClicks,
+				TrackingDisabled: link.TrackingDisabled,
+				ForwardQuery:     link.ForwardQuery,
+				UTMParams:        link.UTMParams,
+			}
+
+			return export.write(row, []string{
+				link.Code,
+				link.URL,
+				link.CreatedAt.UTC().Format(time.RFC3339),
+				formatOptionalTime(link.ExpiresAt),
+				formatOptionalTime(link.UpdatedAt),
+				strconv.Itoa(link.RedirectStatus),
+				formatOptionalInt(link.MaxClicks),
+				strconv.Itoa(link.AttemptCount),
+				strconv.Itoa(link.ClickCount),
+				strconv.Itoa(link.BotClicks),
+				strconv.FormatBool(link.TrackingDisabled),
+				strconv.FormatBool(link.ForwardQuery),
+				stringValue(link.UTMSource),
+				stringValue(link.UTMMedium),
+				stringValue(link.UTMCampaign),
+			})
+		})
+
+		export.finish(r, err)
+	}
+}
+
+// ExportClicksHandler streams daily click counts as CSV or NDJSON, for one
+// link when code is given and for every link that is not deleted
+// otherwise.
+func ExportClicksHandler(links LinkStore, clicks ClickStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		format, ok := negotiateExportFormat(r)
+		if !ok {
+			writeError(w, http.StatusNotAcceptable, "format must be csv or ndjson")
+			return
+		}
+
+		filter := ClickExportFilter{
+			OrgID: orgIDFromContext(r.Context()),
+			From:  params.Get("from"),
+			To:    params.Get("to"),
+		}
+
+		if !isValidDate(filter.From) {
+			writeError(w, http.StatusBadRequest, "from must be a date such as 2006-01-02")
+			return
+		}
+
+		if !isValidDate(filter.To) {
+			writeError(w, http.StatusBadRequest, "to must be a date such as 2006-01-02")
+			return
+		}
+
+		if code := params.Get("code"); code != "" {
+			link, err := links.GetLink(r.Context(), filter.OrgID, domainIDFromContext(r.Context()), code)
+			if err != nil {
+				if err == ErrNotFound {
+					writeError(w, http.StatusNotFound, "Link not found")
+				} else {
+					slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+					writeError(w, http.StatusInternalServerError, "Internal Server Error")
+				}
+				return
+			}
+			filter.LinkID = link.ID
+		}
+
+		export := newExportWriter(w, format, "clicks", clickExportHeader)
+
+		err := clicks.ExportClicks(r.Context(), filter, func(click ClickExport) error {
+			row := clickExportRow{
+				Code:   click.Code,
+				Date:   click.Date,
+				Clicks: click.Clicks,
+			}
+
+			return export.write(row, []string{click.Code, click.Date, strconv.FormatInt(click.Clicks, 10)})
+		})
+
+		export.finish(r, err)
+	}
+}
+
+// negotiateExportFormat picks the export format from the format query
+// parameter, falling back to the Accept header and then to CSV.
+func negotiateExportFormat(r *http.Request) (string, bool) {
+	switch format := r.URL.Query().Get("format"); format {
+	case exportFormatCSV, exportFormatNDJSON:
+		return format, true
+	case "":
+	default:
+		return "", false
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "application/x-ndjson") || strings.Contains(accept, "application/ndjson") {
+		return exportFormatNDJSON, true
+	}
+
+	return exportFormatCSV, true
+}
+
+// exportWriter writes export rows in one format and flushes them to the
+// client as it goes. Exports can outlast the server's write timeout, so
+// the deadline is lifted for the response.
+type exportWriter struct {
+	controller *http.ResponseController
+	csv        *csv.Writer
+	json       *json.Encoder
+	rows       int
+}
+
+func newExportWriter(w http.ResponseWriter, format string, name string, header []string) *exportWriter {
+	export := &exportWriter{
+		controller: http.NewResponseController(w),
+	}
+	export.controller.SetWriteDeadline(time.Time{})
+
+	if format == exportFormatNDJSON {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		export.json = json.NewEncoder(w)
+	} else {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		export.csv = csv.NewWriter(w)
+		export.csv.Write(header)
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.`+format+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	return export
+}
+
+func (e *exportWriter) write(row interface{}, record []string) error {
+	var err error
+	if e.json != nil {
+		err = e.json.Encode(row)
+	} else {
+		err = e.csv.Write(record)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.rows++
+	if e.rows%exportFlushRows == 0 {
+		return e.flush()
+	}
+
+	return nil
+}
+
+func (e *exportWriter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+
+	return e.controller.Flush()
+}
+
+// finish flushes what is left. The status has already been sent, so an
+// export that fails halfway can only be logged and cut short.
+func (e *exportWriter) finish(r *http.Request, err error) {
+	if err == nil {
+		err = e.flush()
+	}
+
+	if err != nil && r.Context().Err() == nil {
+		slog.ErrorContext(r.Context(), "Error exporting data", "error", err, "rows", e.rows)
+	}
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatOptionalInt(n *int) string {
+	if n == nil {
+		return ""
+	}
+
+	return strconv.Itoa(*n)
+}
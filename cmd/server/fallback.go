@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// prepareFallbackURL unwraps a fallback URL in place. An empty one is left
+// as is.
+func prepareFallbackURL(ctx context.Context, fallbackURL *string) error {
+	if *fallbackURL == "" {
+		return nil
+	}
+
+	unwrapped, err := unwrapURL(ctx, *fallbackURL)
+	if err != nil {
+		return &fieldError{Field: "fallback_url", Message: err.Error()}
+	}
+	*fallbackURL = unwrapped
+
+	return nil
+}
+
+// applyFallbackUpdate sets the fallback URL of link when request has one.
+// An empty fallback URL removes it.
+func applyFallbackUpdate(link *Link, request UpdateLinkRequest) {
+	if request.FallbackURL != nil {
+		link.FallbackURL = nonEmpty(*request.FallbackURL)
+	}
+}
+
+// fallback is the link as it resolves when it cannot be followed: to its
+// fallback URL as is, without UTM parameters or a forwarded query.
+func (l Link) fallback() Link {
+	l.URL = *l.FallbackURL
+	l.UTMParams = UTMParams{}
+	l.ForwardQuery = false
+
+	return l
+}
+
+// redirectToFallback answers a visit of a link that cannot be followed
+// with a temporary redirect to its fallback URL, or with failure when it
+// has none.
+func redirectToFallback(w http.ResponseWriter, r *http.Request, link Link, failure *serviceError) {
+	if link.FallbackURL == nil {
+		writeErrorCode(w, failure.Status, failure.Code, failure.Message, nil)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, *link.FallbackURL, http.StatusFound)
+}
+
+// writeFallbackURL is redirectToFallback for GET /get-link.
+func writeFallbackURL(w http.ResponseWriter, r *http.Request, link Link, failure *serviceError, startTime time.Time) {
+	if link.FallbackURL == nil {
+		writeErrorCode(w, failure.Status, failure.Code, failure.Message, nil)
+		return
+	}
+
+	writeGetURLResponse(w, r, link.fallback(), startTime)
+}
+
+// resolveFallback is redirectToFallback for linkService.Resolve.
+func resolveFallback(link Link, failure *serviceError) (Link, error) {
+	if link.FallbackURL == nil {
+		return Link{}, failure
+	}
+
+	return link.fallback(), nil
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxFolderNameLength = 64
+	maxFolderDepth      = 10
+	maxMovedLinks       = 100
+)
+
+// Folder organizes the links of a namespace. Folders nest under ParentID,
+// nil for a top-level folder. Path joins the names from the top level
+// down with slashes, and LinkCount counts the links filed directly in the
+// folder; both are only filled in by listings.
+type Folder struct {
+	ID        int        `db:"id" json:"id"`
+	OrgID     int        `db:"org_id" json:"-"`
+	ParentID  *int       `db:"parent_id" json:"parent_id"`
+	Name      string     `db:"name" json:"name"`
+	Path      string     `db:"-" json:"path,omitempty"`
+	LinkCount int        `db:"link_count" json:"link_count"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt *time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// folderTree indexes the folders of a namespace by ID.
+type folderTree map[int]Folder
+
+func newFolderTree(folders []Folder) folderTree {
+	tree := make(folderTree, len(folders))
+	for _, folder := range folders {
+		tree[folder.ID] = folder
+	}
+
+	return tree
+}
+
+// lineage returns the folder followed by its parent, the parent's parent
+// and so on up to the top level.
+func (t folderTree) lineage(id int) []Folder {
+	var lineage []Folder
+	for folder, ok := t[id]; ok && len(lineage) <= maxFolderDepth; folder, ok = t[derefInt(folder.ParentID)] {
+		lineage = append(lineage, folder)
+	}
+
+	return lineage
+}
+
+// path returns the names of the folder and its ancestors joined with
+// slashes, from the top level down.
+func (t folderTree) path(id int) string {
+	lineage := t.lineage(id)
+	names := make([]string, len(lineage))
+	for i, folder := range lineage {
+		names[len(lineage)-1-i] = folder.Name
+	}
+
+	return strings.Join(names, "/")
+}
+
+// depth returns how many folders deep id is, 1 for a top-level folder.
+func (t folderTree) depth(id int) int {
+	return len(t.lineage(id))
+}
+
+// within reports whether id is ancestor or one of its subfolders.
+func (t folderTree) within(id int, ancestor int) bool {
+	for _, folder := range t.lineage(id) {
+		if folder.ID == ancestor {
+			return true
+		}
+	}
+
+	return false
+}
+
+// height returns how many levels of subfolders id has below it.
+func (t folderTree) height(id int) int {
+	height := 0
+	for _, folder := range t {
+		if folder.ID != id && t.within(folder.ID, id) {
+			if d := t.depth(folder.ID) - t.depth(id); d > height {
+				height = d
+			}
+		}
+	}
+
+	return height
+}
+
+// subtree returns id and the IDs of all of its subfolders.
+func (t folderTree) subtree(id int) []int {
+	ids := []int{id}
+	for _, folder := range t {
+		if folder.ID != id && t.within(folder.ID, id) {
+			ids = append(ids, folder.ID)
+		}
+	}
+
+	return ids
+}
+
+// nameTaken reports whether another folder under parentID has the name.
+func (t folderTree) nameTaken(parentID *int, name string, id int) bool {
+	for _, folder := range t {
+		if folder.ID != id && derefInt(folder.ParentID) == derefInt(parentID) && strings.EqualFold(folder.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func derefInt(value *int) int {
+	if value == nil {
+		return 0
+	}
+
+	return *value
+}
+
+// validateFolderName returns why a trimmed folder name cannot be used, or
+// an empty string.
+func validateFolderName(name string) string {
+	if name == "" {
+		return "name is required"
+	}
+
+	if len(name) > maxFolderNameLength {
+		return fmt.Sprintf("name must be at most %d characters", maxFolderNameLength)
+	}
+
+	if strings.ContainsFunc(name, func(r rune) bool { return r == '/' || unicode.IsControl(r) }) {
+		return "name must not contain slashes or control characters"
+	}
+
+	return ""
+}
+
+// placeFolder checks that folder can be stored under its name and parent
+// among the other folders of its namespace, and writes the error when it
+// cannot.
+func placeFolder(w http.ResponseWriter, tree folderTree, folder Folder) bool {
+	if message := validateFolderName(folder.Name); message != "" {
+		writeValidationError(w, &fieldError{Field: "name", Message: message})
+		return false
+	}
+
+	if folder.ParentID != nil {
+		if _, ok := tree[*folder.ParentID]; !ok {
+			writeValidationError(w, &fieldError{Field: "parent_id", Message: "Parent folder not found"})
+			return false
+		}
+
+		if folder.ID != 0 && tree.within(*folder.ParentID, folder.ID) {
+			writeValidationError(w, &fieldError{Field: "parent_id", Message: "A folder cannot be moved into itself or one of its subfolders"})
+			return false
+		}
+
+		if tree.depth(*folder.ParentID)+1+tree.height(folder.ID) > maxFolderDepth {
+			writeValidationError(w, &fieldError{Field: "parent_id", Message: fmt.Sprintf("Folders nest at most %d deep", maxFolderDepth)})
+			return false
+		}
+	}
+
+	if tree.nameTaken(folder.ParentID, folder.Name, folder.ID) {
+		writeConflict(w, "folder_name_taken", "A folder named \""+folder.Name+"\" already exists there")
+		return false
+	}
+
+	return true
+}
+
+// ListFoldersHandler lists every folder of the caller's namespace with
+// its path and the number of links filed in it, ordered by path so that
+// subfolders follow their parent.
+func ListFoldersHandler(folders FolderStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		list, err := folders.ListFolders(r.Context(), orgIDFromContext(r.Context()))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		tree := newFolderTree(list)
+		for i := range list {
+			list[i].Path = tree.path(list[i].ID)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+
+		response := FoldersResponse{
+			Folders:     list,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+func CreateFolderHandler(folders FolderStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request CreateFolderRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeInvalidBody(w, err)
+			return
+		}
+
+		orgID := orgIDFromContext(r.Context())
+		folder := Folder{
+			OrgID: orgID,
+			Name:  strings.TrimSpace(request.Name),
+		}
+		if request.ParentID != 0 {
+			folder.ParentID = &request.ParentID
+		}
+
+		tree, ok := loadFolderTree(w, r, folders, orgID)
+		if !ok || !placeFolder(w, tree, folder) {
+			return
+		}
+
+		if err := folders.CreateFolder(r.Context(), &folder); err != nil {
+			slog.ErrorContext(r.Context(), "Error creating folder", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		tree[folder.ID] = folder
+		folder.Path = tree.path(folder.ID)
+
+		writeFolder(w, r, folder, http.StatusCreated)
+	}
+}
+
+// UpdateFolderHandler renames a folder or moves it under another parent.
+// A parent_id of 0 moves it to the top level.
+func UpdateFolderHandler(folders FolderStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request UpdateFolderRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeInvalidBody(w, err)
+			return
+		}
+
+		orgID := orgIDFromContext(r.Context())
+
+		tree, ok := loadFolderTree(w, r, folders, orgID)
+		if !ok {
+			return
+		}
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		folder, found := tree[id]
+		if err != nil || !found {
+			writeError(w, http.StatusNotFound, "Folder not found")
+			return
+		}
+
+		if request.Name != nil {
+			folder.Name = strings.TrimSpace(*request.Name)
+		}
+		if request.ParentID != nil {
+			folder.ParentID = request.ParentID
+			if *request.ParentID == 0 {
+				folder.ParentID = nil
+			}
+		}
+
+		if !placeFolder(w, tree, folder) {
+			return
+		}
+
+		linkCount := folder.LinkCount
+		err = folders.UpdateFolder(r.Context(), &folder)
+		if err == ErrFolderNotFound {
+			writeError(w, http.StatusNotFound, "Folder not found")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error updating folder", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		folder.LinkCount = linkCount
+		tree[folder.ID] = folder
+		folder.Path = tree.path(folder.ID)
+
+		writeFolder(w, r, folder, http.StatusOK)
+	}
+}
+
+// DeleteFolderHandler removes an empty folder. Folders that still hold
+// links or subfolders are a conflict, so that nothing is unfiled by
+// accident.
+func DeleteFolderHandler(folders FolderStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Folder not found")
+			return
+		}
+
+		err = folders.DeleteFolder(r.Context(), orgIDFromContext(r.Context()), id)
+		if err != nil {
+			switch err {
+			case ErrFolderNotFound:
+				writeError(w, http.StatusNotFound, "Folder not found")
+			case ErrFolderNotEmpty:
+				writeConflict(w, "folder_not_empty", "Folder still holds links or folders")
+			default:
+				slog.ErrorContext(r.Context(), "Error deleting folder", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// MoveLinksHandler files links in a folder, or takes them out of their
+// folder when folder_id is 0. Codes that do not exist or are deleted are
+// left out of the count.
+func MoveLinksHandler(folders FolderStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		var request MoveLinksRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeInvalidBody(w, err)
+			return
+		}
+
+		if len(request.Codes) == 0 {
+			writeValidationError(w, &fieldError{Field: "codes", Message: "codes is required"})
+			return
+		}
+
+		if len(request.Codes) > maxMovedLinks {
+			writeValidationError(w, &fieldError{Field: "codes", Message: fmt.Sprintf("At most %d links can be moved at once", maxMovedLinks)})
+			return
+		}
+
+		orgID := orgIDFromContext(r.Context())
+
+		folderID, ok := lookupFolderID(w, r, folders, orgID, request.FolderID)
+		if !ok {
+			return
+		}
+
+		moved, err := folders.MoveLinks(r.Context(), orgID, domainIDFromContext(r.Context()), request.Codes, folderID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error moving links", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := MoveLinksResponse{
+			Moved:       moved,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// resolveFolderID checks that the folder links are to be filed in
+// belongs to the namespace of orgID. It returns nil for 0, no folder.
+func resolveFolderID(ctx context.Context, folders FolderStore, orgID int, id int) (*int, error) {
+	if id == 0 {
+		return nil, nil
+	}
+
+	folder, err := folders.GetFolder(ctx, orgID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &folder.ID, nil
+}
+
+// lookupFolderID is resolveFolderID for handlers. It writes a validation
+// error for a folder that does not exist.
+func lookupFolderID(w http.ResponseWriter, r *http.Request, folders FolderStore, orgID int, id int) (*int, bool) {
+	folderID, err := resolveFolderID(r.Context(), folders, orgID, id)
+	if err == ErrFolderNotFound {
+		writeValidationError(w, &fieldError{Field: "folder_id", Message: "Folder not found"})
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return nil, false
+	}
+
+	return folderID, true
+}
+
+// fileLink moves a link to the folder a shorten request asked for, which
+// only differs from its own when the URL was already shortened.
+func fileLink(ctx context.Context, folders FolderStore, link *Link, folderID int) error {
+	if folderID == 0 || derefInt(link.FolderID) == folderID {
+		return nil
+	}
+
+	if _, err := folders.MoveLinks(ctx, link.OrgID, link.DomainID, []string{link.Code}, &folderID); err != nil {
+		return err
+	}
+	link.FolderID = &folderID
+
+	return nil
+}
+
+func nonZero(value int) *int {
+	if value == 0 {
+		return nil
+	}
+
+	return &value
+}
+
+func loadFolderTree(w http.ResponseWriter, r *http.Request, folders FolderStore, orgID int) (folderTree, bool) {
+	list, err := folders.ListFolders(r.Context(), orgID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return nil, false
+	}
+
+	return newFolderTree(list), true
+}
+
+func writeFolder(w http.ResponseWriter, r *http.Request, folder Folder, status int) {
+	jsonResponse, err := json.Marshal(folder)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonResponse)
+}
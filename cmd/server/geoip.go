@@ -0,0 +1,146 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+	"github.com/oschwald/geoip2-golang"
+)
+
+const defaultGeoIPReloadInterval = time.Hour
+
+// CountryLookup resolves client addresses to ISO 3166-1 country codes.
+// Unknown addresses resolve to an empty string.
+type CountryLookup interface {
+	Country(ip string) string
+	// Locate resolves the continent of the address as well.
+	Locate(ip string) Location
+	Close() error
+}
+
+// Location is where an address is. Continent is one of the two-letter
+// codes MaxMind uses: AF, AN, AS, EU, NA, OC and SA.
+type Location struct {
+	Country   string
+	Continent string
+}
+
+// NewCountryLookup opens the MaxMind GeoLite2 or GeoIP2 country database
+// at GEOIP_DB_PATH and returns a lookup that resolves nothing when the
+// variable is unset. The file is reopened whenever its modification time
+// changes, checked every GEOIP_RELOAD_INTERVAL, so tools such as
+// geoipupdate can replace it without a restart.
+func NewCountryLookup(cfg *config.Config) (CountryLookup, error) {
+	path := cfg.String("GEOIP_DB_PATH")
+	if path == "" {
+		return noopCountryLookup{}, nil
+	}
+
+	lookup := &GeoIPCountryLookup{path: path, done: make(chan struct{})}
+	if err := lookup.reload(); err != nil {
+		return nil, err
+	}
+
+	go lookup.watch(cfg.Duration("GEOIP_RELOAD_INTERVAL"))
+
+	return lookup, nil
+}
+
+type noopCountryLookup struct{}
+
+func (noopCountryLookup) Country(ip string) string  { return "" }
+func (noopCountryLookup) Locate(ip string) Location { return Location{} }
+func (noopCountryLookup) Close() error              { return nil }
+
+type GeoIPCountryLookup struct {
+	path string
+	done chan struct{}
+	once sync.Once
+
+	mu      sync.RWMutex
+	reader  *geoip2.Reader
+	modTime time.Time
+}
+
+func (g *GeoIPCountryLookup) Country(ip string) string {
+	return g.Locate(ip).Country
+}
+
+func (g *GeoIPCountryLookup) Locate(ip string) Location {
+	address := net.ParseIP(ip)
+	if address == nil {
+		return Location{}
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	record, err := g.reader.Country(address)
+	if err != nil {
+		return Location{}
+	}
+
+	return Location{Country: record.Country.IsoCode, Continent: record.Continent.Code}
+}
+
+func (g *GeoIPCountryLookup) Close() error {
+	g.once.Do(func() {
+		close(g.done)
+	})
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.reader.Close()
+}
+
+// reload opens the database again if the file changed since it was last
+// opened. The previous reader stays in use when the new file is invalid.
+func (g *GeoIPCountryLookup) reload() error {
+	info, err := os.Stat(g.path)
+	if err != nil {
+		return err
+	}
+
+	if info.ModTime().Equal(g.modTime) {
+		return nil
+	}
+
+	reader, err := geoip2.Open(g.path)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	previous := g.reader
+	g.reader = reader
+	g.modTime = info.ModTime()
+	g.mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+		slog.Info("Reloaded GeoIP database", "path", g.path)
+	}
+
+	return nil
+}
+
+func (g *GeoIPCountryLookup) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-ticker.C:
+			if err := g.reload(); err != nil {
+				slog.Error("Error reloading GeoIP database", "error", err, "path", g.path)
+			}
+		}
+	}
+}
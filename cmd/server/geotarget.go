@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+const maxGeoTargets = 50
+
+// continentCodes are the continents geo targets may name, as the GeoIP
+// database codes them.
+var continentCodes = map[string]bool{
+	"AF": true,
+	"AN": true,
+	"AS": true,
+	"EU": true,
+	"NA": true,
+	"OC": true,
+	"SA": true,
+}
+
+// GeoTarget sends the visitors of a link from one country or continent to
+// URL instead of the default destination. Country is an ISO 3166-1 alpha-2
+// code and takes precedence over a matching continent.
+type GeoTarget struct {
+	Country   string `json:"country,omitempty"`
+	Continent string `json:"continent,omitempty"`
+	URL       string `json:"url"`
+}
+
+// linkGeoTargets is stored as a JSON array, and as NULL when the link has
+// none.
+type linkGeoTargets []GeoTarget
+
+func (t linkGeoTargets) Value() (driver.Value, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+
+	return jsonColumnValue([]GeoTarget(t))
+}
+
+func (t *linkGeoTargets) Scan(src interface{}) error {
+	*t = nil
+	return scanJSONColumn(src, (*[]GeoTarget)(t))
+}
+
+// match returns the target of the country of location, or else the one of
+// its continent.
+func (t linkGeoTargets) match(location Location) (GeoTarget, bool) {
+	var continent *GeoTarget
+	for i, target := range t {
+		if location.Country != "" && target.Country == location.Country {
+			return target, true
+		}
+		if continent == nil && location.Continent != "" && target.Continent == location.Continent {
+			continent = &t[i]
+		}
+	}
+
+	if continent != nil {
+		return *continent, true
+	}
+
+	return GeoTarget{}, false
+}
+
+// validateGeoTargets upper-cases the codes of the targets in place and
+// returns a *fieldError for the first invalid one. Every target names
+// either a country or a continent, and no two name the same.
+func validateGeoTargets(targets []GeoTarget) error {
+	if len(targets) > maxGeoTargets {
+		return &fieldError{Field: "geo_targets", Message: fmt.Sprintf("A link is limited to %d geo targets", maxGeoTargets)}
+	}
+
+	seen := make(map[GeoTarget]bool)
+	for i := range targets {
+		target := &targets[i]
+		field := fmt.Sprintf("geo_targets[%d]", i)
+
+		target.Country = strings.ToUpper(target.Country)
+		target.Continent = strings.ToUpper(target.Continent)
+
+		switch {
+		case (target.Country == "") == (target.Continent == ""):
+			return &fieldError{Field: field, Message: "Geo target needs either a country or a continent"}
+		case target.Country != "" && !isCountryCode(target.Country):
+			return &fieldError{Field: field + ".country", Message: "country must be an ISO 3166-1 alpha-2 code"}
+		case target.Continent != "" && !continentCodes[target.Continent]:
+			return &fieldError{Field: field + ".continent", Message: "continent must be AF, AN, AS, EU, NA, OC or SA"}
+		}
+
+		key := GeoTarget{Country: target.Country, Continent: target.Continent}
+		if seen[key] {
+			return &fieldError{Field: field, Message: "Geo target repeats the country or continent of another target"}
+		}
+		seen[key] = true
+
+		if target.URL == "" {
+			return &fieldError{Field: field + ".url", Message: "URL is required"}
+		}
+	}
+
+	return nil
+}
+
+func isCountryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// prepareGeoTargets validates the geo targets of a request and unwraps
+// their URLs in place.
+func prepareGeoTargets(ctx context.Context, targets []GeoTarget) error {
+	if err := validateGeoTargets(targets); err != nil {
+		return err
+	}
+
+	for i := range targets {
+		unwrapped, err := unwrapURL(ctx, targets[i].URL)
+		if err != nil {
+			return &fieldError{Field: fmt.Sprintf("geo_targets[%d].url", i), Message: err.Error()}
+		}
+		targets[i].URL = unwrapped
+	}
+
+	return nil
+}
+
+// applyGeoTargetsUpdate sets the geo targets of link when request has
+// them. An empty list removes them.
+func applyGeoTargetsUpdate(link *Link, request UpdateLinkRequest) {
+	if request.GeoTargets != nil {
+		link.GeoTargets = newLinkGeoTargets(*request.GeoTargets)
+	}
+}
+
+func newLinkGeoTargets(targets []GeoTarget) linkGeoTargets {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	return linkGeoTargets(targets)
+}
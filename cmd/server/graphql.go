@@ -0,0 +1,754 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+//go:embed graphql/schema.graphql
+var graphqlSchema string
+
+// graphqlMaxDepth bounds how deeply queries may nest. The schema has no
+// cycles, so legitimate queries stay well within it.
+const graphqlMaxDepth = 8
+
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// GraphQLHandler executes GraphQL queries against the links and click
+// time series of the caller's namespace. Errors are reported in the
+// response body with a 200, as GraphQL clients expect.
+func GraphQLHandler(service *linkService) http.HandlerFunc {
+	schema := graphql.MustParseSchema(graphqlSchema, &graphqlResolver{service: service}, graphql.MaxDepth(graphqlMaxDepth))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request GraphQLRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeInvalidBody(w, err)
+			return
+		}
+
+		if request.Query == "" {
+			writeError(w, http.StatusBadRequest, "query is required")
+			return
+		}
+
+		response := schema.Exec(r.Context(), request.Query, request.OperationName, request.Variables)
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// Extensions exposes the HTTP status and error code of a service error to
+// GraphQL clients.
+func (e *serviceError) Extensions() map[string]interface{} {
+	code := e.Code
+	if code == "" {
+		code = errorCodes[e.Status]
+	}
+	return map[string]interface{}{"status": e.Status, "code": code}
+}
+
+type graphqlResolver struct {
+	service *linkService
+}
+
+func (r *graphqlResolver) Link(ctx context.Context, args struct{ Code string }) (*linkResolver, error) {
+	link, err := r.service.GetLink(ctx, args.Code)
+	if err == errServiceNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &linkResolver{link: link, service: r.service}, nil
+}
+
+func (r *graphqlResolver) Links(ctx context.Context, args struct {
+	Sort        *string
+	Ascending   *bool
+	Limit       *int32
+	Offset      *int32
+	CreatedFrom *graphql.Time
+	CreatedTo   *graphql.Time
+	MinClicks   *int32
+	Broken      *bool
+	Tag         *string
+	FolderID    *int32
+	Archived    *bool
+	Deleted     *bool
+}) (*linkPageResolver, error) {
+	filter := LinkFilter{
+		Sort:       stringValue(args.Sort),
+		Descending: args.Ascending == nil || !*args.Ascending,
+		Limit:      int(int32Value(args.Limit)),
+		Offset:     int(int32Value(args.Offset)),
+		MinClicks:  int(int32Value(args.MinClicks)),
+		Broken:     args.Broken != nil && *args.Broken,
+		Tag:        stringValue(args.Tag),
+		Archived:   args.Archived != nil && *args.Archived,
+		Deleted:    args.Deleted != nil && *args.Deleted,
+	}
+	if args.FolderID != nil {
+		filter.FolderIDs = []int{int(*args.FolderID)}
+	}
+	if args.CreatedFrom != nil {
+		filter.CreatedFrom = &args.CreatedFrom.Time
+	}
+	if args.CreatedTo != nil {
+		filter.CreatedTo = &args.CreatedTo.Time
+	}
+
+	page, err := r.service.ListLinks(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &linkPageResolver{page: page, service: r.service}, nil
+}
+
+func (r *graphqlResolver) Search(ctx context.Context, args struct {
+	Q      string
+	Limit  *int32
+	Offset *int32
+}) (*linkPageResolver, error) {
+	filter := LinkFilter{
+		Limit:  int(int32Value(args.Limit)),
+		Offset: int(int32Value(args.Offset)),
+	}
+
+	page, err := r.service.SearchLinks(ctx, args.Q, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &linkPageResolver{page: page, service: r.service}, nil
+}
+
+type shortenInput struct {
+	URL              string
+	Alias            *string
+	TTLSeconds       *int32
+	ExpiresAt        *graphql.Time
+	RedirectStatus   *int32
+	CodeLength       *int32
+	MaxClicks        *int32
+	TrackingDisabled *bool
+	ForwardQuery     *bool
+	UTMSource        *string
+	UTMMedium        *string
+	UTMCampaign      *string
+	ActiveFrom       *graphql.Time
+	ActiveUntil      *graphql.Time
+	FallbackURL      *string
+	Tags             *[]string
+	FolderID         *int32
+	Description      *string
+	Notes            *string
+	ForceNew         *bool
+	Domain           *string
+}
+
+func (r *graphqlResolver) Shorten(ctx context.Context, args struct{ Input shortenInput }) (*linkResolver, error) {
+	input := args.Input
+
+	request := ShortenRequest{
+		URL:              input.URL,
+		Alias:            stringValue(input.Alias),
+		TTLSeconds:       int64(int32Value(input.TTLSeconds)),
+		RedirectStatus:   int(int32Value(input.RedirectStatus)),
+		CodeLength:       int(int32Value(input.CodeLength)),
+		MaxClicks:        int(int32Value(input.MaxClicks)),
+		TrackingDisabled: input.TrackingDisabled != nil && *input.TrackingDisabled,
+		ForwardQuery:     input.ForwardQuery != nil && *input.ForwardQuery,
+		UTMSource:        stringValue(input.UTMSource),
+		UTMMedium:        stringValue(input.UTMMedium),
+		UTMCampaign:      stringValue(input.UTMCampaign),
+		FallbackURL:      stringValue(input.FallbackURL),
+		FolderID:         int(int32Value(input.FolderID)),
+		Description:      stringValue(input.Description),
+		Notes:            stringValue(input.Notes),
+		ForceNew:         input.ForceNew != nil && *input.ForceNew,
+		Domain:           stringValue(input.Domain),
+	}
+	if input.Tags != nil {
+		request.Tags = *input.Tags
+	}
+	if input.ExpiresAt != nil {
+		request.ExpiresAt = &input.ExpiresAt.Time
+	}
+	if input.ActiveFrom != nil {
+		request.ActiveFrom = &input.ActiveFrom.Time
+	}
+	if input.ActiveUntil != nil {
+		request.ActiveUntil = &input.ActiveUntil.Time
+	}
+
+	link, err := r.service.Shorten(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return &linkResolver{link: link, service: r.service}, nil
+}
+
+type updateLinkInput struct {
+	URL              *string
+	ExpiresAt        *graphql.Time
+	RedirectStatus   *int32
+	TrackingDisabled *bool
+	ForwardQuery     *bool
+	UTMSource        *string
+	UTMMedium        *string
+	UTMCampaign      *string
+	ActiveFrom       *graphql.Time
+	ActiveUntil      *graphql.Time
+	FallbackURL      *string
+	Tags             *[]string
+	FolderID         *int32
+	Description      *string
+	Notes            *string
+}
+
+func (r *graphqlResolver) UpdateLink(ctx context.Context, args struct {
+	Code  string
+	Input updateLinkInput
+}) (*linkResolver, error) {
+	request := UpdateLinkRequest{
+		URL:              args.Input.URL,
+		TrackingDisabled: args.Input.TrackingDisabled,
+		ForwardQuery:     args.Input.ForwardQuery,
+		UTMSource:        args.Input.UTMSource,
+		UTMMedium:        args.Input.UTMMedium,
+		UTMCampaign:      args.Input.UTMCampaign,
+		FallbackURL:      args.Input.FallbackURL,
+		Tags:             args.Input.Tags,
+		Description:      args.Input.Description,
+		Notes:            args.Input.Notes,
+	}
+	if args.Input.FolderID != nil {
+		folderID := int(*args.Input.FolderID)
+		request.FolderID = &folderID
+	}
+	if args.Input.ExpiresAt != nil {
+		request.ExpiresAt = &args.Input.ExpiresAt.Time
+	}
+	if args.Input.ActiveFrom != nil {
+		request.ActiveFrom = &args.Input.ActiveFrom.Time
+	}
+	if args.Input.ActiveUntil != nil {
+		request.ActiveUntil = &args.Input.ActiveUntil.Time
+	}
+	if args.Input.RedirectStatus != nil {
+		redirectStatus := int(*args.Input.RedirectStatus)
+		request.RedirectStatus = &redirectStatus
+	}
+
+	link, err := r.service.UpdateLink(ctx, args.Code, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return &linkResolver{link: link, service: r.service}, nil
+}
+
+func (r *graphqlResolver) DeleteLink(ctx context.Context, args struct {
+	Code         string
+	DeleteClicks *bool
+}) (bool, error) {
+	err := r.service.DeleteLink(ctx, args.Code, args.DeleteClicks != nil && *args.DeleteClicks)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *graphqlResolver) ArchiveLink(ctx context.Context, args struct{ Code string }) (*linkResolver, error) {
+	link, err := r.service.ArchiveLink(ctx, args.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	return &linkResolver{link: link, service: r.service}, nil
+}
+
+func (r *graphqlResolver) RestoreLink(ctx context.Context, args struct{ Code string }) (*linkResolver, error) {
+	link, err := r.service.RestoreLink(ctx, args.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	return &linkResolver{link: link, service: r.service}, nil
+}
+
+type linkResolver struct {
+	link    Link
+	service *linkService
+}
+
+func (r *linkResolver) ID() graphql.ID {
+	return graphql.ID(strconv.Itoa(r.link.ID))
+}
+
+func (r *linkResolver) Code() string {
+	return r.link.Code
+}
+
+func (r *linkResolver) URL() string {
+	return r.link.URL
+}
+
+func (r *linkResolver) Title() *string {
+	return r.link.Title
+}
+
+func (r *linkResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.link.CreatedAt}
+}
+
+func (r *linkResolver) UpdatedAt() *graphql.Time {
+	return graphqlTime(r.link.UpdatedAt)
+}
+
+func (r *linkResolver) ExpiresAt() *graphql.Time {
+	return graphqlTime(r.link.ExpiresAt)
+}
+
+func (r *linkResolver) ActiveFrom() *graphql.Time {
+	return graphqlTime(r.link.ActiveFrom)
+}
+
+func (r *linkResolver) ActiveUntil() *graphql.Time {
+	return graphqlTime(r.link.ActiveUntil)
+}
+
+func (r *linkResolver) FallbackURL() *string {
+	return r.link.FallbackURL
+}
+
+func (r *linkResolver) CheckedAt() *graphql.Time {
+	return graphqlTime(r.link.CheckedAt)
+}
+
+func (r *linkResolver) CheckStatus() *int32 {
+	if r.link.CheckStatus == nil {
+		return nil
+	}
+	checkStatus := int32(*r.link.CheckStatus)
+	return &checkStatus
+}
+
+func (r *linkResolver) BrokenSince() *graphql.Time {
+	return graphqlTime(r.link.BrokenSince)
+}
+
+func (r *linkResolver) Tags() []string {
+	return nonNilStrings(r.link.Tags)
+}
+
+func (r *linkResolver) FolderID() *int32 {
+	return int32Pointer(r.link.FolderID)
+}
+
+func (r *linkResolver) Description() *string {
+	return r.link.Description
+}
+
+func (r *linkResolver) Notes() *string {
+	return r.link.Notes
+}
+
+func (r *linkResolver) DeletedAt() *graphql.Time {
+	return graphqlTime(r.link.DeletedAt)
+}
+
+func (r *linkResolver) ArchivedAt() *graphql.Time {
+	return graphqlTime(r.link.ArchivedAt)
+}
+
+func (r *linkResolver) AttemptCount() int32 {
+	return int32(r.link.AttemptCount)
+}
+
+func (r *linkResolver) ClickCount() int32 {
+	return int32(r.link.ClickCount)
+}
+
+func (r *linkResolver) BotClicks() int32 {
+	return int32(r.link.BotClicks)
+}
+
+func (r *linkResolver) MaxClicks() *int32 {
+	if r.link.MaxClicks == nil {
+		return nil
+	}
+	maxClicks := int32(*r.link.MaxClicks)
+	return &maxClicks
+}
+
+func (r *linkResolver) RedirectStatus() int32 {
+	return int32(r.link.RedirectStatus)
+}
+
+func (r *linkResolver) TrackingDisabled() bool {
+	return r.link.TrackingDisabled
+}
+
+func (r *linkResolver) ForwardQuery() bool {
+	return r.link.ForwardQuery
+}
+
+func (r *linkResolver) UTMSource() *string {
+	return r.link.UTMSource
+}
+
+func (r *linkResolver) UTMMedium() *string {
+	return r.link.UTMMedium
+}
+
+func (r *linkResolver) UTMCampaign() *string {
+	return r.link.UTMCampaign
+}
+
+func (r *linkResolver) Destinations() []*destinationResolver {
+	destinations := make([]*destinationResolver, 0, len(r.link.Destinations))
+	for _, destination := range r.link.Destinations {
+		destinations = append(destinations, &destinationResolver{destination: destination})
+	}
+
+	return destinations
+}
+
+func (r *linkResolver) StickyDestinations() bool {
+	return r.link.StickyDestinations
+}
+
+func (r *linkResolver) GeoTargets() []*geoTargetResolver {
+	targets := make([]*geoTargetResolver, 0, len(r.link.GeoTargets))
+	for _, target := range r.link.GeoTargets {
+		targets = append(targets, &geoTargetResolver{target: target})
+	}
+
+	return targets
+}
+
+func (r *linkResolver) DeviceTargets() []*deviceTargetResolver {
+	targets := make([]*deviceTargetResolver, 0, len(r.link.DeviceTargets))
+	for _, target := range r.link.DeviceTargets {
+		targets = append(targets, &deviceTargetResolver{target: target})
+	}
+
+	return targets
+}
+
+func (r *linkResolver) RoutingRules() []*routingRuleResolver {
+	rules := make([]*routingRuleResolver, 0, len(r.link.RoutingRules))
+	for _, rule := range r.link.RoutingRules {
+		rules = append(rules, &routingRuleResolver{rule: rule})
+	}
+
+	return rules
+}
+
+func (r *linkResolver) Timeseries(ctx context.Context, args struct {
+	Granularity *string
+	From        *string
+	To          *string
+}) ([]*clickBucketResolver, error) {
+	series, err := r.service.ClickTimeSeries(ctx, r.link, ClickSeriesFilter{
+		Granularity: stringValue(args.Granularity),
+		From:        stringValue(args.From),
+		To:          stringValue(args.To),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]*clickBucketResolver, 0, len(series))
+	for _, bucket := range series {
+		buckets = append(buckets, &clickBucketResolver{bucket: bucket})
+	}
+
+	return buckets, nil
+}
+
+func (r *linkResolver) History(ctx context.Context, args struct {
+	Limit  *int32
+	Offset *int32
+}) ([]*linkEventResolver, error) {
+	events, err := r.service.LinkHistory(ctx, r.link, int(int32Value(args.Limit)), int(int32Value(args.Offset)))
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*linkEventResolver, 0, len(events))
+	for _, event := range events {
+		resolvers = append(resolvers, &linkEventResolver{event: event})
+	}
+
+	return resolvers, nil
+}
+
+func (r *linkResolver) Aliases(ctx context.Context) ([]string, error) {
+	aliases, err := r.service.LinkAliases(ctx, r.link)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, len(aliases))
+	for _, alias := range aliases {
+		codes = append(codes, alias.Code)
+	}
+
+	return codes, nil
+}
+
+type destinationResolver struct {
+	destination LinkDestination
+}
+
+func (r *destinationResolver) ID() int32 {
+	return int32(r.destination.ID)
+}
+
+func (r *destinationResolver) URL() string {
+	return r.destination.URL
+}
+
+func (r *destinationResolver) Weight() int32 {
+	return int32(r.destination.Weight)
+}
+
+type geoTargetResolver struct {
+	target GeoTarget
+}
+
+func (r *geoTargetResolver) Country() *string {
+	return nonEmpty(r.target.Country)
+}
+
+func (r *geoTargetResolver) Continent() *string {
+	return nonEmpty(r.target.Continent)
+}
+
+func (r *geoTargetResolver) URL() string {
+	return r.target.URL
+}
+
+type deviceTargetResolver struct {
+	target DeviceTarget
+}
+
+func (r *deviceTargetResolver) OS() *string {
+	return nonEmpty(r.target.OS)
+}
+
+func (r *deviceTargetResolver) Device() *string {
+	return nonEmpty(r.target.Device)
+}
+
+func (r *deviceTargetResolver) URL() string {
+	return r.target.URL
+}
+
+type routingRuleResolver struct {
+	rule RoutingRule
+}
+
+func (r *routingRuleResolver) Countries() []string {
+	return nonNilStrings(r.rule.Countries)
+}
+
+func (r *routingRuleResolver) Continents() []string {
+	return nonNilStrings(r.rule.Continents)
+}
+
+func (r *routingRuleResolver) OS() []string {
+	return nonNilStrings(r.rule.OS)
+}
+
+func (r *routingRuleResolver) Devices() []string {
+	return nonNilStrings(r.rule.Devices)
+}
+
+func (r *routingRuleResolver) Languages() []string {
+	return nonNilStrings(r.rule.Languages)
+}
+
+func (r *routingRuleResolver) Referrers() []string {
+	return nonNilStrings(r.rule.Referrers)
+}
+
+func (r *routingRuleResolver) Weekdays() []string {
+	return nonNilStrings(r.rule.Weekdays)
+}
+
+func (r *routingRuleResolver) TimeFrom() *string {
+	return nonEmpty(r.rule.TimeFrom)
+}
+
+func (r *routingRuleResolver) TimeTo() *string {
+	return nonEmpty(r.rule.TimeTo)
+}
+
+func (r *routingRuleResolver) TimeZone() *string {
+	return nonEmpty(r.rule.TimeZone)
+}
+
+func (r *routingRuleResolver) URL() string {
+	return r.rule.URL
+}
+
+// nonNilStrings returns values, or an empty list for a non-null GraphQL
+// list when it is nil.
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+
+	return values
+}
+
+type clickBucketResolver struct {
+	bucket ClickBucket
+}
+
+func (r *clickBucketResolver) Date() string {
+	return r.bucket.Date
+}
+
+func (r *clickBucketResolver) Clicks() int32 {
+	return int32(r.bucket.Clicks)
+}
+
+type linkEventResolver struct {
+	event LinkEvent
+}
+
+func (r *linkEventResolver) ID() graphql.ID {
+	return graphql.ID(strconv.FormatInt(r.event.ID, 10))
+}
+
+func (r *linkEventResolver) Action() string {
+	return r.event.Action
+}
+
+func (r *linkEventResolver) MemberID() *int32 {
+	return int32Pointer(r.event.MemberID)
+}
+
+func (r *linkEventResolver) KeyID() *int32 {
+	return int32Pointer(r.event.KeyID)
+}
+
+func (r *linkEventResolver) Changes() []*linkChangeResolver {
+	fields := make([]string, 0, len(r.event.Changes))
+	for field := range r.event.Changes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	changes := make([]*linkChangeResolver, 0, len(fields))
+	for _, field := range fields {
+		changes = append(changes, &linkChangeResolver{field: field, change: r.event.Changes[field]})
+	}
+	return changes
+}
+
+func (r *linkEventResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.event.CreatedAt}
+}
+
+type linkChangeResolver struct {
+	field  string
+	change LinkChange
+}
+
+func (r *linkChangeResolver) Field() string {
+	return r.field
+}
+
+func (r *linkChangeResolver) Before() string {
+	return string(r.change.Before)
+}
+
+func (r *linkChangeResolver) After() string {
+	return string(r.change.After)
+}
+
+type linkPageResolver struct {
+	page    ListLinksResponse
+	service *linkService
+}
+
+func (r *linkPageResolver) Links() []*linkResolver {
+	links := make([]*linkResolver, 0, len(r.page.Links))
+	for _, link := range r.page.Links {
+		links = append(links, &linkResolver{link: link, service: r.service})
+	}
+	return links
+}
+
+func (r *linkPageResolver) Total() int32 {
+	return int32(r.page.Total)
+}
+
+func (r *linkPageResolver) Limit() int32 {
+	return int32(r.page.Limit)
+}
+
+func (r *linkPageResolver) Offset() int32 {
+	return int32(r.page.Offset)
+}
+
+func graphqlTime(t *time.Time) *graphql.Time {
+	if t == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *t}
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func int32Pointer(n *int) *int32 {
+	if n == nil {
+		return nil
+	}
+	value := int32(*n)
+	return &value
+}
+
+func int32Value(n *int32) int32 {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	woweev1 "github.com/boleknowak/wowee-link-api/proto/wowee/v1"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// errorInfoDomain is the domain of the ErrorInfo attached to service errors
+// with a specific code.
+const errorInfoDomain = "wowee.link"
+
+var errGRPCInternal = status.Error(codes.Internal, "Internal Server Error")
+
+// grpcLinkService serves woweev1.LinkService through linkService. Calls
+// are not rate limited; the service is meant for internal consumers.
+type grpcLinkService struct {
+	woweev1.UnimplementedLinkServiceServer
+
+	service *linkService
+}
+
+// NewGRPCServer returns a gRPC server with the link service registered.
+// API keys and session tokens are resolved by the same rules as
+// APIKeyMiddleware.
+func NewGRPCServer(orgs OrgStore, sessions *SessionIssuer, service *linkService) *grpc.Server {
+	server := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(grpcUnaryInterceptor(orgs, sessions), grpcRecoveryInterceptor),
+	)
+	woweev1.RegisterLinkServiceServer(server, &grpcLinkService{service: service})
+
+	return server
+}
+
+// grpcUnaryInterceptor is the gRPC counterpart of RequestIDMiddleware and
+// APIKeyMiddleware: it tags the call with a request ID, resolves its API
+// key to the calling member and logs the outcome.
+func grpcUnaryInterceptor(orgs OrgStore, sessions *SessionIssuer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		startTime := time.Now()
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		requestID := firstMetadataValue(md, strings.ToLower(requestIDHeader))
+		if !isValidRequestID(requestID) {
+			requestID = newRequestID()
+		}
+
+		grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(requestIDHeader), requestID))
+		ctx = context.WithValue(ctx, requestIDKey, requestID)
+
+		ctx, err := authenticateGRPC(ctx, orgs, sessions, md)
+
+		var resp interface{}
+		if err == nil {
+			resp, err = handler(ctx, req)
+		}
+
+		slog.InfoContext(ctx, "Request completed",
+			"method", info.FullMethod,
+			"status", status.Code(err).String(),
+			"duration_ms", time.Since(startTime).Milliseconds(),
+			"remote_ip", peerIP(ctx),
+		)
+
+		return resp, err
+	}
+}
+
+func authenticateGRPC(ctx context.Context, orgs OrgStore, sessions *SessionIssuer, md metadata.MD) (context.Context, error) {
+	key := apiKeyFromMetadata(md)
+	if key == "" {
+		return ctx, nil
+	}
+	if isAdminAPIKey(key) {
+		return context.WithValue(ctx, adminKey, true), nil
+	}
+
+	c, err := authenticateCaller(ctx, orgs, sessions, key)
+	if err != nil {
+		if failure, ok := authenticationFailures[err]; ok {
+			return ctx, status.Error(codes.Unauthenticated, failure.Message)
+		}
+		slog.ErrorContext(ctx, "Error querying database", "error", err)
+		return ctx, errGRPCInternal
+	}
+
+	return context.WithValue(ctx, callerKey, c), nil
+}
+
+// apiKeyFromMetadata is apiKeyFromRequest for gRPC metadata.
+func apiKeyFromMetadata(md metadata.MD) string {
+	if key := firstMetadataValue(md, "x-api-key"); key != "" {
+		return key
+	}
+
+	authorization := firstMetadataValue(md, "authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+
+	return ""
+}
+
+func firstMetadataValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// peerIP returns the address of the calling service. Proxy headers are
+// not consulted; gRPC consumers are expected to connect directly.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
+
+func (s *grpcLinkService) Shorten(ctx context.Context, req *woweev1.ShortenRequest) (*woweev1.ShortenResponse, error) {
+	request := ShortenRequest{
+		URL:              req.GetUrl(),
+		Alias:            req.GetAlias(),
+		TTLSeconds:       req.GetTtlSeconds(),
+		RedirectStatus:   int(req.GetRedirectStatus()),
+		CodeLength:       int(req.GetCodeLength()),
+		MaxClicks:        int(req.GetMaxClicks()),
+		TrackingDisabled: req.GetTrackingDisabled(),
+	}
+	if req.ExpiresAt != nil {
+		expiresAt := req.ExpiresAt.AsTime()
+		request.ExpiresAt = &expiresAt
+	}
+
+	link, err := s.service.Shorten(ctx, request)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &woweev1.ShortenResponse{Code: link.Code}, nil
+}
+
+func (s *grpcLinkService) Resolve(ctx context.Context, req *woweev1.ResolveRequest) (*woweev1.ResolveResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	link, err := s.service.Resolve(ctx, req.GetCode(), Click{IP: peerIP(ctx), UserAgent: firstMetadataValue(md, "user-agent"), Language: preferredLanguage(firstMetadataValue(md, "accept-language"))})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &woweev1.ResolveResponse{Url: destinationURL(link, "")}, nil
+}
+
+func (s *grpcLinkService) GetStats(ctx context.Context, req *woweev1.GetStatsRequest) (*woweev1.Link, error) {
+	if err := checkScope(ctx, scopeStatsRead); err != nil {
+		return nil, grpcError(err)
+	}
+
+	link, err := s.service.GetLink(ctx, req.GetCode())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return linkToProto(link), nil
+}
+
+func (s *grpcLinkService) ListLinks(ctx context.Context, req *woweev1.ListLinksRequest) (*woweev1.ListLinksResponse, error) {
+	filter := LinkFilter{
+		Sort:       req.GetSort(),
+		Descending: !req.GetAscending(),
+		Limit:      int(req.GetLimit()),
+		Offset:     int(req.GetOffset()),
+		MinClicks:  int(req.GetMinClicks()),
+	}
+	if req.CreatedFrom != nil {
+		createdFrom := req.CreatedFrom.AsTime()
+		filter.CreatedFrom = &createdFrom
+	}
+	if req.CreatedTo != nil {
+		createdTo := req.CreatedTo.AsTime()
+		filter.CreatedTo = &createdTo
+	}
+
+	page, err := s.service.ListLinks(ctx, filter)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	response := &woweev1.ListLinksResponse{
+		Links:  make([]*woweev1.Link, 0, len(page.Links)),
+		Total:  int32(page.Total),
+		Limit:  int32(page.Limit),
+		Offset: int32(page.Offset),
+	}
+	for _, link := range page.Links {
+		response.Links = append(response.Links, linkToProto(link))
+	}
+
+	return response, nil
+}
+
+// grpcStatusCodes maps the HTTP statuses of service errors to gRPC codes.
+// Gone links are NOT_FOUND, as gRPC has no counterpart of 410.
+var grpcStatusCodes = map[int]codes.Code{
+	http.StatusBadRequest:      codes.InvalidArgument,
+	http.StatusForbidden:       codes.PermissionDenied,
+	http.StatusNotFound:        codes.NotFound,
+	http.StatusConflict:        codes.AlreadyExists,
+	http.StatusGone:            codes.NotFound,
+	http.StatusTooManyRequests: codes.ResourceExhausted,
+}
+
+// grpcError converts a service error to a gRPC status. A specific error
+// code travels as the reason of an ErrorInfo detail.
+func grpcError(err error) error {
+	serviceErr, ok := err.(*serviceError)
+	if !ok {
+		return errGRPCInternal
+	}
+
+	code, ok := grpcStatusCodes[serviceErr.Status]
+	if !ok {
+		return errGRPCInternal
+	}
+
+	st := status.New(code, serviceErr.Message)
+	if serviceErr.Code != "" {
+		if detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: serviceErr.Code, Domain: errorInfoDomain}); err == nil {
+			st = detailed
+		}
+	}
+
+	return st.Err()
+}
+
+func linkToProto(link Link) *woweev1.Link {
+	response := &woweev1.Link{
+		Id:               int64(link.ID),
+		Code:             link.Code,
+		Url:              link.URL,
+		CreatedAt:        timestamppb.New(link.CreatedAt),
+		AttemptCount:     int32(link.AttemptCount),
+		ClickCount:       int64(link.ClickCount),
+		BotClicks:        int64(link.BotClicks),
+		ExpiresAt:        protoTimestamp(link.ExpiresAt),
+		RedirectStatus:   int32(link.RedirectStatus),
+		DeletedAt:        protoTimestamp(link.DeletedAt),
+		UpdatedAt:        protoTimestamp(link.UpdatedAt),
+		Title:            link.Title,
+		TrackingDisabled: link.TrackingDisabled,
+	}
+
+	if link.MaxClicks != nil {
+		maxClicks := int32(*link.MaxClicks)
+		response.MaxClicks = &maxClicks
+	}
+
+	return response
+}
+
+func protoTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+// stopGRPCServer lets in-flight calls finish until ctx is done and then
+// cuts the remaining ones off.
+func stopGRPCServer(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+	}
+}
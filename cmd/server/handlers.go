@@ -0,0 +1,1625 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// redirectCacheControl is the Cache-Control header of redirects, set from
+// REDIRECT_CACHE_CONTROL. Browsers otherwise keep a 301 for good, hiding
+// later destination edits and every click after the first.
+var redirectCacheControl = defaultRedirectCacheControl
+
+// countHeadClicks makes HEAD requests of redirects count as clicks, set
+// from REDIRECT_HEAD_CLICKS. Link previews of messaging apps send them
+// before, or instead of, a visitor following the link.
+var countHeadClicks bool
+
+func IndexURLHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := IndexResponse{
+			Status: "OK",
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+// ShortenURLHandler takes the ShortenRequest as JSON or form-encoded. GET
+// takes it from the query string instead, for bookmarklets and curl, and
+// requires an API key so that other sites cannot create links through
+// their visitors' browsers.
+//
+// A request with an Idempotency-Key header that the same API key, or
+// session member, already used gets the link of the first request back
+// unchanged, marked with Idempotent-Replayed. Reusing the key for a
+// different request is refused with 422.
+func ShortenURLHandler(service *linkService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		if r.Method == http.MethodGet {
+			if !authenticatedRequest(r) {
+				writeError(w, http.StatusUnauthorized, "API key required")
+				return
+			}
+			w.Header().Set("Cache-Control", "no-store")
+		}
+
+		request, err := decodeShortenRequest(r)
+		if err != nil {
+			var fieldErr *fieldError
+			if errors.As(err, &fieldErr) {
+				writeValidationError(w, err)
+			} else {
+				writeInvalidBody(w, err)
+			}
+			return
+		}
+
+		if !checkCaptcha(w, r, service.captcha, request.CaptchaToken) {
+			return
+		}
+
+		if key := r.Header.Get(idempotencyKeyHeader); key != "" {
+			if len(key) > maxIdempotencyKeyLength {
+				message := fmt.Sprintf("%s must be at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength)
+				writeErrorCode(w, http.StatusBadRequest, "invalid_request", message, map[string]interface{}{"header": idempotencyKeyHeader})
+				return
+			}
+			request.IdempotencyKey = &key
+		}
+
+		// The route checks the scope itself, and charges the quota with
+		// the quota headers Shorten cannot send.
+		link, replayed, err := service.shorten(r.Context(), request)
+		if err == errServiceQuotaExceeded {
+			service.shortens.writeHeaders(w, service.shortens.Limit)
+		}
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+
+		if createdLink(link, replayed) {
+			service.shortens.charge(w, r, 1)
+		}
+
+		if replayed {
+			w.Header().Set(idempotentReplayedHeader, "true")
+		}
+
+		writeShortenResponse(w, r, service.orgs, link, startTime)
+	}
+}
+
+// GetStatsHandler summarizes the links of the caller's organization. The
+// admin key gets the totals of every namespace instead. ?tag= narrows the
+// summary to the links with the tag, such as those of one campaign.
+func GetStatsHandler(stats StatsStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		filter := StatsFilter{
+			Tag:      normalizeTag(r.URL.Query().Get("tag")),
+			Today:    utcToday(),
+			TopLimit: statsTopLinks,
+		}
+
+		scope := "global"
+		if isAdminAPIKey(apiKeyFromRequest(r)) {
+			filter.AllOrgs = true
+		} else {
+			c, ok := requireRole(w, r, roleMember)
+			if !ok {
+				return
+			}
+			filter.OrgID = c.OrgID
+			scope = "organization"
+		}
+
+		result, err := stats.Stats(r.Context(), filter)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := StatsResponse{
+			Scope: scope,
+			Tag:   filter.Tag,
+			Links: result.Links,
+			Clicks: PeriodCounts{
+				Today:      result.ClicksToday,
+				Last7Days:  result.Clicks7Days,
+				Last30Days: result.Clicks30Days,
+			},
+			Created: PeriodCounts{
+				Today:      result.CreatedToday,
+				Last7Days:  result.Created7Days,
+				Last30Days: result.Created30Days,
+			},
+			CreatedPerDay: float64(result.Created30Days) / 30,
+			TopLinks:      result.TopLinks,
+			ElapsedTime:   time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+func GetURLStatsHandler(links LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		code := vars["code"]
+		var startTime = time.Now()
+
+		link, err := links.GetLink(r.Context(), orgIDFromContext(r.Context()), domainIDFromContext(r.Context()), code)
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		tags, err := links.LinkTags(r.Context(), []int{link.ID})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := Link{
+			ID:                 link.ID,
+			Code:               link.Code,
+			URL:                link.URL,
+			CreatedAt:          link.CreatedAt,
+			AttemptCount:       link.AttemptCount,
+			ClickCount:         link.ClickCount,
+			BotClicks:          link.BotClicks,
+			ExpiresAt:          link.ExpiresAt,
+			RedirectStatus:     link.RedirectStatus,
+			DeletedAt:          link.DeletedAt,
+			UpdatedAt:          link.UpdatedAt,
+			MaxClicks:          link.MaxClicks,
+			Title:              link.Title,
+			TrackingDisabled:   link.TrackingDisabled,
+			ForwardQuery:       link.ForwardQuery,
+			UTMParams:          link.UTMParams,
+			Destinations:       link.Destinations,
+			StickyDestinations: link.StickyDestinations,
+			GeoTargets:         link.GeoTargets,
+			DeviceTargets:      link.DeviceTargets,
+			RoutingRules:       link.RoutingRules,
+			ActiveFrom:         link.ActiveFrom,
+			ActiveUntil:        link.ActiveUntil,
+			FallbackURL:        link.FallbackURL,
+			LinkHealth:         link.LinkHealth,
+			FolderID:           link.FolderID,
+			Description:        link.Description,
+			Notes:              link.Notes,
+			Tags:               tags[link.ID],
+			ElapsedTime:        time.Since(startTime).Milliseconds(),
+		}
+		if c, _ := callerFromContext(r.Context()); c.Share {
+			response = sharedLinkStats(response)
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+func GetURLTimeSeriesHandler(links LinkStore, clicks ClickStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		code := vars["code"]
+		var startTime = time.Now()
+		params := r.URL.Query()
+
+		filter := ClickSeriesFilter{
+			From:        params.Get("from"),
+			To:          params.Get("to"),
+			Granularity: params.Get("granularity"),
+		}
+
+		if filter.Granularity == "" {
+			filter.Granularity = "day"
+		}
+		if !clickGranularities[filter.Granularity] {
+			writeError(w, http.StatusBadRequest, "granularity must be day, week or month")
+			return
+		}
+
+		if !isValidDate(filter.From) {
+			writeError(w, http.StatusBadRequest, "from must be a date such as 2006-01-02")
+			return
+		}
+
+		if !isValidDate(filter.To) {
+			writeError(w, http.StatusBadRequest, "to must be a date such as 2006-01-02")
+			return
+		}
+
+		if filter.From != "" && filter.To != "" && filter.From > filter.To {
+			writeError(w, http.StatusBadRequest, "from must not be after to")
+			return
+		}
+
+		link, err := links.GetLink(r.Context(), orgIDFromContext(r.Context()), domainIDFromContext(r.Context()), code)
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		series, err := clicks.ClickTimeSeries(r.Context(), link.ID, filter)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := ClickTimeSeriesResponse{
+			Code:        link.Code,
+			Granularity: filter.Granularity,
+			Series:      series,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+func GetURLReferrersHandler(links LinkStore, clicks ClickStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		code := vars["code"]
+		var startTime = time.Now()
+
+		limit, err := parseIntParam(r.URL.Query().Get("limit"), defaultReferrerLimit)
+		if err != nil || limit < 1 || limit > maxReferrerLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+
+		link, err := links.GetLink(r.Context(), orgIDFromContext(r.Context()), domainIDFromContext(r.Context()), code)
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		referrers, err := clicks.TopReferrers(r.Context(), link.ID, limit)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := ReferrersResponse{
+			Code:        link.Code,
+			Referrers:   referrers,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+func GetURLCountriesHandler(links LinkStore, clicks ClickStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		code := vars["code"]
+		var startTime = time.Now()
+
+		link, err := links.GetLink(r.Context(), orgIDFromContext(r.Context()), domainIDFromContext(r.Context()), code)
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		countries, err := clicks.CountryClicks(r.Context(), link.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := CountriesResponse{
+			Code:        link.Code,
+			Countries:   countries,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+func GetURLDevicesHandler(links LinkStore, clicks ClickStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		code := vars["code"]
+		var startTime = time.Now()
+
+		link, err := links.GetLink(r.Context(), orgIDFromContext(r.Context()), domainIDFromContext(r.Context()), code)
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		devices, err := clicks.DeviceClicks(r.Context(), link.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := breakDownDevices(devices)
+		response.Code = link.Code
+		response.ElapsedTime = time.Since(startTime).Milliseconds()
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// GetURLClickEventsHandler pages through the individual clicks of a link,
+// newest first. The from and to dates are inclusive, and next_cursor is
+// passed as cursor to fetch the following page.
+func GetURLClickEventsHandler(links LinkStore, clicks ClickStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		code := vars["code"]
+		var startTime = time.Now()
+		params := r.URL.Query()
+
+		var filter ClickEventFilter
+		var err error
+
+		from, to := params.Get("from"), params.Get("to")
+
+		if !isValidDate(from) {
+			writeError(w, http.StatusBadRequest, "from must be a date such as 2006-01-02")
+			return
+		}
+
+		if !isValidDate(to) {
+			writeError(w, http.StatusBadRequest, "to must be a date such as 2006-01-02")
+			return
+		}
+
+		if from != "" && to != "" && from > to {
+			writeError(w, http.StatusBadRequest, "from must not be after to")
+			return
+		}
+
+		if from != "" {
+			filter.From, _ = time.Parse("2006-01-02", from)
+		}
+		if to != "" {
+			day, _ := time.Parse("2006-01-02", to)
+			filter.To = day.AddDate(0, 0, 1)
+		}
+
+		if cursor := params.Get("cursor"); cursor != "" {
+			filter.Before, err = strconv.ParseInt(cursor, 10, 64)
+			if err != nil || filter.Before < 1 {
+				writeError(w, http.StatusBadRequest, "Invalid cursor")
+				return
+			}
+		}
+
+		filter.Limit, err = parseIntParam(params.Get("limit"), defaultClickEventLimit)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxClickEventLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+
+		link, err := links.GetLink(r.Context(), orgIDFromContext(r.Context()), domainIDFromContext(r.Context()), code)
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		events, err := clicks.ClickEvents(r.Context(), link.ID, filter)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := ClickEventsResponse{
+			Code:        link.Code,
+			Events:      events,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		if len(events) == filter.Limit {
+			response.NextCursor = strconv.FormatInt(events[len(events)-1].ID, 10)
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+func GetURLHandler(links LinkStore, cache LinkCache, checker URLChecker, countries CountryLookup, clicks *ClickRecorder, webhooks *WebhookDispatcher, quota *Quota) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		code := vars["code"]
+		var startTime = time.Now()
+
+		link, err := lookupLink(r.Context(), links, cache, orgIDFromContext(r.Context()), domainIDFromContext(r.Context()), code)
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		if link.DeletedAt != nil {
+			writeErrorCode(w, http.StatusGone, "link_deleted", "Link has been deleted", nil)
+			return
+		}
+
+		if link.ArchivedAt != nil {
+			writeErrorCode(w, http.StatusGone, "link_archived", "Link has been archived", nil)
+			return
+		}
+
+		if link.quarantined() {
+			writeErrorCode(w, http.StatusForbidden, "link_quarantined", "Link is quarantined", nil)
+			return
+		}
+
+		if link.ExpiresAt != nil && !link.ExpiresAt.After(time.Now()) {
+			writeFallbackURL(w, r, link, errServiceLinkExpired, startTime)
+			return
+		}
+
+		if !link.isActive(time.Now()) {
+			writeFallbackURL(w, r, link, errServiceLinkInactive, startTime)
+			return
+		}
+
+		if link.broken() && link.FallbackURL != nil {
+			writeFallbackURL(w, r, link, nil, startTime)
+			return
+		}
+
+		destinationID := routeClick(&link, countries, Click{Referrer: referrerHost(r), IP: clientIP(r), UserAgent: r.UserAgent(), Language: preferredLanguage(r.Header.Get("Accept-Language"))})
+
+		if checkURLsOnRedirect {
+			if _, unsafe := isUnsafeURL(r.Context(), checker, link.URL); unsafe {
+				writeErrorCode(w, http.StatusForbidden, "unsafe_url", "Link destination is flagged as unsafe", nil)
+				return
+			}
+		}
+
+		if !quota.visit(r.Context(), link) {
+			writeErrorCode(w, http.StatusTooManyRequests, "quota_exceeded", "Monthly quota exceeded", nil)
+			return
+		}
+
+		if link.MaxClicks != nil {
+			allowed, err := links.ConsumeClick(r.Context(), link.ID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+
+			if !allowed {
+				writeFallbackURL(w, r, link, errServiceClickLimitReached, startTime)
+				return
+			}
+		}
+
+		if isTracked(link) {
+			referrer := referrerHost(r)
+			clicks.Record(Click{LinkID: link.ID, Referrer: referrer, IP: clientIP(r), UserAgent: r.UserAgent(), DestinationID: destinationID, Code: code})
+
+			data := newWebhookEventData(link)
+			data.Referrer = &referrer
+			webhooks.Emit(link.OrgID, webhookLinkClicked, data)
+		}
+
+		writeGetURLResponse(w, r, link, startTime)
+	}
+}
+
+func writeGetURLResponse(w http.ResponseWriter, r *http.Request, link Link, startTime time.Time) {
+	response := GetURLResponse{
+		URL:         destinationURL(link, ""),
+		ElapsedTime: time.Since(startTime).Milliseconds(),
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// RedirectHandler serves codes of the shared namespace, of the
+// organization named by the org path variable when the route has one, and
+// of the code space of the verified custom domain the request came in on
+// otherwise.
+// With SAFE_BROWSING_ON_REDIRECT, destinations flagged since the link was
+// created are refused. A trailing + on the code or ?preview=1 shows an
+// interstitial instead of redirecting. Visits of links that are not
+// tracked are neither recorded nor sent to webhooks, and neither are
+// visits sent to the fallback URL of a link that is expired, outside of
+// its schedule, over its click limit or broken.
+// Visits count against the redirect quota of the organization owning the
+// link. HEAD requests are answered like GET ones, but neither count a
+// click nor use up a click limit or the quota unless REDIRECT_HEAD_CLICKS
+// is set. Only redirects
+// that may be cached are sent with REDIRECT_CACHE_CONTROL; errors are
+// not stored, so that a code just created or restored works at once.
+func RedirectHandler(links LinkStore, orgs OrgStore, resolver *DomainResolver, cache LinkCache, checker URLChecker, countries CountryLookup, clicks *ClickRecorder, webhooks *WebhookDispatcher, quota *Quota) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code := mux.Vars(r)["code"]
+		counted := r.Method != http.MethodHead || countHeadClicks
+
+		w.Header().Set("Cache-Control", "no-store")
+
+		orgID, domainID, ok := resolveLinkNamespace(w, r, orgs, resolver)
+		if !ok {
+			return
+		}
+
+		link, err := lookupLink(r.Context(), links, cache, orgID, domainID, code)
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		if link.DeletedAt != nil {
+			writeErrorCode(w, http.StatusGone, "link_deleted", "Link has been deleted", nil)
+			return
+		}
+
+		if link.ArchivedAt != nil {
+			writeErrorCode(w, http.StatusGone, "link_archived", "Link has been archived", nil)
+			return
+		}
+
+		if link.quarantined() {
+			renderQuarantine(w, r, link)
+			return
+		}
+
+		if link.ExpiresAt != nil && !link.ExpiresAt.After(time.Now()) {
+			redirectToFallback(w, r, link, errServiceLinkExpired)
+			return
+		}
+
+		if !link.isActive(time.Now()) {
+			redirectToFallback(w, r, link, errServiceLinkInactive)
+			return
+		}
+
+		if link.broken() && link.FallbackURL != nil {
+			redirectToFallback(w, r, link, nil)
+			return
+		}
+
+		if wantsInterstitial(r) {
+			renderInterstitial(w, r, link, checker)
+			return
+		}
+
+		destinationID := routeClick(&link, countries, Click{Referrer: referrerHost(r), IP: clientIP(r), UserAgent: r.UserAgent(), Language: preferredLanguage(r.Header.Get("Accept-Language"))})
+
+		if checkURLsOnRedirect {
+			if _, unsafe := isUnsafeURL(r.Context(), checker, link.URL); unsafe {
+				writeErrorCode(w, http.StatusForbidden, "unsafe_url", "Link destination is flagged as unsafe", nil)
+				return
+			}
+		}
+
+		if counted && !quota.visit(r.Context(), link) {
+			writeErrorCode(w, http.StatusTooManyRequests, "quota_exceeded", "Monthly quota exceeded", nil)
+			return
+		}
+
+		if link.MaxClicks != nil && counted {
+			allowed, err := links.ConsumeClick(r.Context(), link.ID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+
+			if !allowed {
+				redirectToFallback(w, r, link, errServiceClickLimitReached)
+				return
+			}
+		}
+
+		if isTracked(link) && counted {
+			referrer := referrerHost(r)
+			clicks.Record(Click{LinkID: link.ID, Referrer: referrer, IP: clientIP(r), UserAgent: r.UserAgent(), DestinationID: destinationID, Code: code})
+
+			data := newWebhookEventData(link)
+			data.Referrer = &referrer
+			webhooks.Emit(link.OrgID, webhookLinkClicked, data)
+		}
+
+		status := link.RedirectStatus
+		if !isValidRedirectStatus(status) {
+			status = defaultRedirectStatus
+		}
+
+		// A cached redirect of a limited link would skip ConsumeClick, one
+		// of a link with destinations would skip picking one, one of a
+		// link with routing rules would skip matching them against the next
+		// request, and one of a scheduled link could outlive its schedule.
+		if link.MaxClicks == nil && link.Destinations == nil && link.RoutingRules == nil && link.ActiveUntil == nil {
+			w.Header().Set("Cache-Control", redirectCacheControl)
+		}
+
+		http.Redirect(w, r, destinationURL(link, forwardedQuery(r)), status)
+	}
+}
+
+// ListLinksHandler returns a page of links ordered by the sort and order
+// parameters and filtered by created_from, created_to, min_clicks, tag and
+// folder_id. With subfolders=true the links of the folders nested in
+// folder_id are listed too, and archived=true or deleted=true list the
+// archived or deleted links instead of the live ones.
+func ListLinksHandler(links LinkStore, folders FolderStore) http.HandlerFunc {
+	return listLinksHandler(links, folders, false)
+}
+
+// BrokenLinksHandler is ListLinksHandler for the links the health checker
+// found dead.
+func BrokenLinksHandler(links LinkStore, folders FolderStore) http.HandlerFunc {
+	return listLinksHandler(links, folders, true)
+}
+
+func listLinksHandler(links LinkStore, folders FolderStore, broken bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+		params := r.URL.Query()
+
+		filter := LinkFilter{
+			OrgID:      orgIDFromContext(r.Context()),
+			Tag:        normalizeTag(params.Get("tag")),
+			Sort:       params.Get("sort"),
+			Descending: true,
+			Broken:     broken,
+			Deleted:    params.Get("deleted") == "true",
+			Archived:   params.Get("archived") == "true",
+		}
+
+		var err error
+		filter.Limit, err = parseIntParam(params.Get("limit"), defaultListLimit)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxListLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+
+		filter.Offset, err = parseIntParam(params.Get("offset"), 0)
+		if err != nil || filter.Offset < 0 {
+			writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+
+		if filter.Sort == "" {
+			filter.Sort = "created_at"
+		}
+		if !linkSortFields[filter.Sort] {
+			writeError(w, http.StatusBadRequest, "Invalid sort column")
+			return
+		}
+
+		switch strings.ToLower(params.Get("order")) {
+		case "", "desc":
+		case "asc":
+			filter.Descending = false
+		default:
+			writeError(w, http.StatusBadRequest, "order must be asc or desc")
+			return
+		}
+
+		if value := params.Get("created_from"); value != "" {
+			createdFrom, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "created_from must be an RFC 3339 timestamp")
+				return
+			}
+			filter.CreatedFrom = &createdFrom
+		}
+
+		if value := params.Get("created_to"); value != "" {
+			createdTo, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "created_to must be an RFC 3339 timestamp")
+				return
+			}
+			filter.CreatedTo = &createdTo
+		}
+
+		filter.MinClicks, err = parseIntParam(params.Get("min_clicks"), 0)
+		if err != nil || filter.MinClicks < 0 {
+			writeError(w, http.StatusBadRequest, "min_clicks must be a non-negative integer")
+			return
+		}
+
+		folderID, err := parseIntParam(params.Get("folder_id"), 0)
+		if err != nil || folderID < 0 {
+			writeError(w, http.StatusBadRequest, "folder_id must be a positive integer")
+			return
+		}
+
+		if folderID > 0 {
+			tree, ok := loadFolderTree(w, r, folders, filter.OrgID)
+			if !ok {
+				return
+			}
+
+			if _, ok := tree[folderID]; !ok {
+				writeError(w, http.StatusNotFound, "Folder not found")
+				return
+			}
+
+			filter.FolderIDs = []int{folderID}
+			if params.Get("subfolders") == "true" {
+				filter.FolderIDs = tree.subtree(folderID)
+			}
+		}
+
+		page, total, err := links.ListLinks(r.Context(), filter)
+		if err == nil {
+			err = loadLinkTags(r.Context(), links, page)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := ListLinksResponse{
+			Links:       page,
+			Total:       total,
+			Limit:       filter.Limit,
+			Offset:      filter.Offset,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// TrendingLinksHandler ranks links by their clicks within the window
+// parameter. Clicks are counted per UTC day, so 24h covers today, and 7d
+// and 30d the days ending today.
+func TrendingLinksHandler(stats StatsStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+		params := r.URL.Query()
+
+		window := params.Get("window")
+		if window == "" {
+			window = "24h"
+		}
+
+		days, ok := trendingWindows[window]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "window must be 24h, 7d or 30d")
+			return
+		}
+
+		filter := TrendingFilter{
+			OrgID: orgIDFromContext(r.Context()),
+			From:  utcToday().AddDate(0, 0, 1-days).Format("2006-01-02"),
+		}
+
+		var err error
+		filter.Limit, err = parseIntParam(params.Get("limit"), defaultListLimit)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxListLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+
+		filter.Offset, err = parseIntParam(params.Get("offset"), 0)
+		if err != nil || filter.Offset < 0 {
+			writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+
+		page, total, err := stats.TrendingLinks(r.Context(), filter)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := TrendingLinksResponse{
+			Window:      window,
+			Links:       page,
+			Total:       total,
+			Limit:       filter.Limit,
+			Offset:      filter.Offset,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// UpdateLinkHandler changes the destination and settings of an existing
+// code in place. Fields omitted from the request body are left untouched.
+func UpdateLinkHandler(links LinkStore, folders FolderStore, cache LinkCache, domains *DomainPolicy, checker URLChecker, titles *TitleFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		code := vars["code"]
+		var startTime = time.Now()
+
+		var request UpdateLinkRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeInvalidBody(w, err)
+			return
+		}
+
+		if err := validateDestinationsUpdate(r.Context(), request); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		if request.GeoTargets != nil {
+			if err := prepareGeoTargets(r.Context(), *request.GeoTargets); err != nil {
+				writeValidationError(w, err)
+				return
+			}
+		}
+
+		if request.DeviceTargets != nil {
+			if err := prepareDeviceTargets(r.Context(), *request.DeviceTargets); err != nil {
+				writeValidationError(w, err)
+				return
+			}
+		}
+
+		if request.RoutingRules != nil {
+			if err := prepareRoutingRules(r.Context(), *request.RoutingRules); err != nil {
+				writeValidationError(w, err)
+				return
+			}
+		}
+
+		if err := validateSchedule(request.ActiveFrom, request.ActiveUntil); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		if request.FallbackURL != nil {
+			if err := prepareFallbackURL(r.Context(), request.FallbackURL); err != nil {
+				writeValidationError(w, err)
+				return
+			}
+		}
+
+		if err := prepareNotes(request.Description, request.Notes); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		if request.Tags != nil {
+			tags, err := normalizeTags(*request.Tags)
+			if err != nil {
+				writeValidationError(w, err)
+				return
+			}
+			request.Tags = &tags
+		}
+
+		if !checkURLs(w, r, domains, checker, request.routedURLs()) {
+			return
+		}
+
+		if request.URL != nil {
+			if *request.URL == "" {
+				writeValidationError(w, &fieldError{Field: "url", Message: "URL is required"})
+				return
+			}
+
+			unwrapped, err := unwrapURL(r.Context(), *request.URL)
+			if err != nil {
+				writeValidationError(w, &fieldError{Field: "url", Message: err.Error()})
+				return
+			}
+			request.URL = &unwrapped
+
+			if !domains.checkDomain(w, r, *request.URL) {
+				return
+			}
+
+			if verdict, unsafe := isUnsafeURL(r.Context(), checker, *request.URL); unsafe {
+				writeUnsafeURL(w, verdict)
+				return
+			}
+		}
+
+		if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+			writeValidationError(w, &fieldError{Field: "expires_at", Message: "expires_at must be in the future"})
+			return
+		}
+
+		if request.RedirectStatus != nil && !isValidRedirectStatus(*request.RedirectStatus) {
+			writeValidationError(w, &fieldError{Field: "redirect_status", Message: "redirect_status must be 301, 302 or 307"})
+			return
+		}
+
+		if err := validateUTMUpdate(request); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		orgID := orgIDFromContext(r.Context())
+		domainID := domainIDFromContext(r.Context())
+
+		var folderID *int
+		if request.FolderID != nil {
+			var ok bool
+			if folderID, ok = lookupFolderID(w, r, folders, orgID, *request.FolderID); !ok {
+				return
+			}
+		}
+
+		link, err := links.UpdateLink(r.Context(), orgID, domainID, code, func(link *Link) error {
+			if link.DeletedAt != nil {
+				return ErrLinkDeleted
+			}
+
+			if err := applyDestinationsUpdate(link, request); err != nil {
+				return err
+			}
+			applyGeoTargetsUpdate(link, request)
+			applyDeviceTargetsUpdate(link, request)
+			applyRoutingRulesUpdate(link, request)
+			if err := applyScheduleUpdate(link, request); err != nil {
+				return err
+			}
+			applyFallbackUpdate(link, request)
+			if request.URL != nil {
+				link.URL = *request.URL
+			}
+			if request.ExpiresAt != nil {
+				link.ExpiresAt = request.ExpiresAt
+			}
+			if request.RedirectStatus != nil {
+				link.RedirectStatus = *request.RedirectStatus
+			}
+			if request.TrackingDisabled != nil {
+				link.TrackingDisabled = *request.TrackingDisabled
+			}
+			if request.ForwardQuery != nil {
+				link.ForwardQuery = *request.ForwardQuery
+			}
+			applyUTMUpdate(link, request)
+			applyNotesUpdate(link, request)
+			if request.FolderID != nil {
+				link.FolderID = folderID
+			}
+
+			return nil
+		})
+		if err != nil {
+			switch err {
+			case ErrNotFound:
+				writeError(w, http.StatusNotFound, "Link not found")
+			case ErrLinkDeleted:
+				writeErrorCode(w, http.StatusGone, "link_deleted", "Link has been deleted", nil)
+			case ErrURLTaken:
+				writeConflict(w, "url_already_shortened", "URL is already shortened under another code")
+			case ErrLinkHasDestinations:
+				writeValidationError(w, &fieldError{Field: "url", Message: ErrLinkHasDestinations.Error()})
+			case ErrInvalidSchedule:
+				writeValidationError(w, &fieldError{Field: "active_until", Message: ErrInvalidSchedule.Error()})
+			default:
+				slog.ErrorContext(r.Context(), "Error updating link", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		cache.Delete(r.Context(), orgID, domainID, code)
+
+		if request.URL != nil {
+			titles.Fetch(link)
+		}
+
+		if err := updateLinkTags(r.Context(), links, &link, request); err != nil {
+			slog.ErrorContext(r.Context(), "Error tagging link", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		link.ElapsedTime = time.Since(startTime).Milliseconds()
+
+		jsonResponse, err := json.Marshal(link)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// DeleteLinkHandler marks a link as deleted so its code keeps answering
+// 410 Gone and is never handed out again, unless the link is restored
+// within RESTORE_WINDOW. The clicks history is retained unless the request
+// asks for it to be removed with ?clicks=delete.
+func DeleteLinkHandler(links LinkStore, cache LinkCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		code := vars["code"]
+
+		deleteClicks := false
+		switch r.URL.Query().Get("clicks") {
+		case "", "retain":
+		case "delete":
+			deleteClicks = true
+		default:
+			writeError(w, http.StatusBadRequest, "clicks must be retain or delete")
+			return
+		}
+
+		orgID := orgIDFromContext(r.Context())
+		domainID := domainIDFromContext(r.Context())
+
+		err := links.DeleteLink(r.Context(), orgID, domainID, code, deleteClicks)
+		if err != nil {
+			switch err {
+			case ErrNotFound:
+				writeError(w, http.StatusNotFound, "Link not found")
+			case ErrLinkDeleted:
+				writeErrorCode(w, http.StatusGone, "link_deleted", "Link has been deleted", nil)
+			default:
+				slog.ErrorContext(r.Context(), "Error deleting link", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		cache.Delete(r.Context(), orgID, domainID, code)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// resolveLinkNamespace finds the organization and custom domain a public
+// path resolves codes in: the organization of the {org} path variable, or
+// the custom domain the request came in on. Unless it could tell, it
+// answers the request itself and returns false.
+func resolveLinkNamespace(w http.ResponseWriter, r *http.Request, orgs OrgStore, resolver *DomainResolver) (int, int, bool) {
+	if slug, ok := mux.Vars(r)["org"]; ok {
+		org, err := orgs.GetOrganizationBySlug(r.Context(), slug)
+		if err != nil {
+			if err == ErrOrgNotFound {
+				writeError(w, http.StatusNotFound, "Link not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return 0, 0, false
+		}
+		return org.ID, 0, true
+	}
+
+	domain, found, err := resolver.Resolve(r.Context(), requestHost(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return 0, 0, false
+	}
+	if !found {
+		return 0, 0, true
+	}
+
+	return domain.OrgID, domain.ID, true
+}
+
+// lookupLink resolves a code in the namespace of orgID and domainID to the
+// fields needed to serve it, consulting the cache before the database.
+func lookupLink(ctx context.Context, links LinkStore, cache LinkCache, orgID int, domainID int, code string) (Link, error) {
+	ctx, span := tracer.Start(ctx, "lookupLink")
+	defer span.End()
+
+	link, ok := cache.Get(ctx, orgID, domainID, code)
+	span.SetAttributes(attribute.Bool("cache.hit", ok))
+	if ok {
+		return link, nil
+	}
+
+	link, err := links.GetLink(ctx, orgID, domainID, code)
+	if err != nil {
+		return link, err
+	}
+
+	cache.Set(ctx, link)
+
+	return link, nil
+}
+
+func parseIntParam(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+
+	return strconv.Atoi(value)
+}
+
+// isValidDate reports whether value is empty or a YYYY-MM-DD date.
+// utcToday returns the start of the current UTC day.
+func utcToday() time.Time {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func isValidDate(value string) bool {
+	if value == "" {
+		return true
+	}
+
+	_, err := time.Parse(time.DateOnly, value)
+	return err == nil
+}
+
+// breakDownDevices sums device counts per device type, browser, operating
+// system and bot. Bot clicks are left out of the browser and operating
+// system totals.
+func breakDownDevices(devices []DeviceCount) DevicesResponse {
+	types := make(map[string]int64)
+	browsers := make(map[string]int64)
+	operatingSystems := make(map[string]int64)
+	bots := make(map[string]int64)
+
+	for _, device := range devices {
+		types[device.DeviceType] += device.Clicks
+		if device.DeviceType == deviceBot {
+			bots[device.Browser] += device.Clicks
+			continue
+		}
+		browsers[device.Browser] += device.Clicks
+		operatingSystems[device.OS] += device.Clicks
+	}
+
+	return DevicesResponse{
+		DeviceTypes:      sortedBreakdown(types),
+		Browsers:         sortedBreakdown(browsers),
+		OperatingSystems: sortedBreakdown(operatingSystems),
+		Bots:             sortedBreakdown(bots),
+	}
+}
+
+func sortedBreakdown(totals map[string]int64) []DeviceBreakdown {
+	breakdown := make([]DeviceBreakdown, 0, len(totals))
+	for name, clicks := range totals {
+		breakdown = append(breakdown, DeviceBreakdown{Name: name, Clicks: clicks})
+	}
+
+	sort.Slice(breakdown, func(i, j int) bool {
+		if breakdown[i].Clicks != breakdown[j].Clicks {
+			return breakdown[i].Clicks > breakdown[j].Clicks
+		}
+		return breakdown[i].Name < breakdown[j].Name
+	})
+
+	return breakdown
+}
+
+func isValidRedirectStatus(status int) bool {
+	return status == http.StatusMovedPermanently || status == http.StatusFound || status == http.StatusTemporaryRedirect
+}
+
+func writeShortenResponse(w http.ResponseWriter, r *http.Request, orgs OrgStore, link Link, startTime time.Time) {
+	slug := ""
+	if link.OrgID != 0 {
+		org, err := orgs.GetOrganization(r.Context(), link.OrgID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		slug = org.Slug
+	}
+
+	short, err := linkShortURL(r, orgs, link, slug)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	response := ShortenResponse{
+		Code:        link.Code,
+		ShortURL:    short,
+		CreatedAt:   link.CreatedAt,
+		ExpiresAt:   link.ExpiresAt,
+		Tags:        link.Tags,
+		ElapsedTime: time.Since(startTime).Milliseconds(),
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+func decodeShortenRequest(r *http.Request) (ShortenRequest, error) {
+	if r.Method == http.MethodGet {
+		return shortenRequestFromValues(r.URL.Query())
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" {
+		if err := r.ParseForm(); err != nil {
+			return ShortenRequest{}, err
+		}
+		return shortenRequestFromValues(r.PostForm)
+	}
+
+	var request ShortenRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	return request, err
+}
+
+// shortenRequestFromValues reads a ShortenRequest from form or query
+// values named like its JSON fields. expires_at, active_from and
+// active_until are RFC 3339 timestamps, and tags are separated by commas.
+func shortenRequestFromValues(values url.Values) (ShortenRequest, error) {
+	request := ShortenRequest{
+		URL:         values.Get("url"),
+		Alias:       values.Get("alias"),
+		UTMSource:   values.Get("utm_source"),
+		UTMMedium:   values.Get("utm_medium"),
+		UTMCampaign: values.Get("utm_campaign"),
+		FallbackURL: values.Get("fallback_url"),
+		Description: values.Get("description"),
+		Notes:       values.Get("notes"),
+		Domain:      values.Get("domain"),
+	}
+
+	// HTML forms carry the token under the name of the CAPTCHA widget.
+	for _, name := range []string{"captcha_token", "h-captcha-response", "g-recaptcha-response"} {
+		if request.CaptchaToken == "" {
+			request.CaptchaToken = values.Get(name)
+		}
+	}
+
+	for _, value := range values["tags"] {
+		request.Tags = append(request.Tags, strings.FieldsFunc(value, func(r rune) bool { return r == ',' })...)
+	}
+
+	var err error
+	if request.TTLSeconds, err = formInt(values, "ttl_seconds"); err != nil {
+		return request, err
+	}
+
+	for _, field := range []struct {
+		name  string
+		value **time.Time
+	}{
+		{"expires_at", &request.ExpiresAt},
+		{"active_from", &request.ActiveFrom},
+		{"active_until", &request.ActiveUntil},
+	} {
+		if value := values.Get(field.name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return request, &fieldError{Field: field.name, Message: field.name + " must be an RFC 3339 timestamp"}
+			}
+			*field.value = &t
+		}
+	}
+
+	for _, field := range []struct {
+		name  string
+		value *int
+	}{
+		{"redirect_status", &request.RedirectStatus},
+		{"code_length", &request.CodeLength},
+		{"max_clicks", &request.MaxClicks},
+		{"folder_id", &request.FolderID},
+	} {
+		n, err := formInt(values, field.name)
+		if err != nil {
+			return request, err
+		}
+		*field.value = int(n)
+	}
+
+	for _, field := range []struct {
+		name  string
+		value *bool
+	}{
+		{"tracking_disabled", &request.TrackingDisabled},
+		{"forward_query", &request.ForwardQuery},
+		{"force_new", &request.ForceNew},
+	} {
+		if value := values.Get(field.name); value != "" {
+			*field.value, err = strconv.ParseBool(value)
+			if err != nil {
+				return request, &fieldError{Field: field.name, Message: field.name + " must be true or false"}
+			}
+		}
+	}
+
+	return request, nil
+}
+
+func formInt(values url.Values, name string) (int64, error) {
+	value := values.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, &fieldError{Field: name, Message: name + " must be an integer"}
+	}
+
+	return n, nil
+}
+
+func resolveExpiration(request ShortenRequest) (*time.Time, error) {
+	if request.TTLSeconds != 0 && request.ExpiresAt != nil {
+		return nil, &fieldError{Field: "ttl_seconds", Message: "ttl_seconds and expires_at are mutually exclusive"}
+	}
+
+	if request.TTLSeconds < 0 {
+		return nil, &fieldError{Field: "ttl_seconds", Message: "ttl_seconds must be positive"}
+	}
+
+	if request.TTLSeconds > 0 {
+		expiresAt := time.Now().Add(time.Duration(request.TTLSeconds) * time.Second)
+		return &expiresAt, nil
+	}
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		return nil, &fieldError{Field: "expires_at", Message: "expires_at must be in the future"}
+	}
+
+	return request.ExpiresAt, nil
+}
+
+func isValidAlias(alias string, charset string) bool {
+	if len(alias) < minAliasLength || len(alias) > maxAliasLength {
+		return false
+	}
+
+	for i := 0; i < len(alias); i++ {
+		if !strings.ContainsRune(charset, rune(alias[i])) {
+			return false
+		}
+	}
+
+	return true
+}
+
+var errCodeSpaceExhausted = errors.New("could not generate a unique code")
+
+// insertLinkWithGeneratedCode stores the link under a freshly generated
+// code that is not reserved and returns the stored link. Permanent links
+// are upserted, so a URL that the caller's API key already shortened keeps
+// its existing code, unless request.ForceNew is set.
+func insertLinkWithGeneratedCode(ctx context.Context, links LinkStore, codes CodeGenerator, request ShortenRequest, expiresAt *time.Time, maxClicks *int) (Link, error) {
+	for attempt := 0; attempt < maxCodeAttempts; attempt++ {
+		code, err := codes.Generate(ctx, request.CodeLength+attempt/2)
+		if err != nil {
+			return Link{}, err
+		}
+
+		if isReservedCode(code) {
+			continue
+		}
+
+		link := Link{
+			OrgID:              orgIDFromContext(ctx),
+			Code:               code,
+			URL:                request.URL,
+			ExpiresAt:          expiresAt,
+			RedirectStatus:     request.RedirectStatus,
+			MaxClicks:          maxClicks,
+			TrackingDisabled:   request.TrackingDisabled,
+			ForwardQuery:       request.ForwardQuery,
+			UTMParams:          request.utm(),
+			IdempotencyKey:     request.IdempotencyKey,
+			IdempotencyHash:    nonEmpty(request.IdempotencyHash),
+			Destinations:       newLinkDestinations(request.Destinations),
+			StickyDestinations: request.StickyDestinations,
+			GeoTargets:         newLinkGeoTargets(request.GeoTargets),
+			DeviceTargets:      newLinkDeviceTargets(request.DeviceTargets),
+			RoutingRules:       newLinkRoutingRules(request.RoutingRules),
+			ActiveFrom:         request.ActiveFrom,
+			ActiveUntil:        request.ActiveUntil,
+			FallbackURL:        nonEmpty(request.FallbackURL),
+			FolderID:           nonZero(request.FolderID),
+			Description:        nonEmpty(request.Description),
+			Notes:              nonEmpty(request.Notes),
+			KeyID:              keyIDFromContext(ctx),
+			MemberID:           sessionMemberIDFromContext(ctx),
+			ForceNew:           request.ForceNew,
+			DomainID:           request.DomainID,
+		}
+
+		if !link.ForceNew && expiresAt == nil && maxClicks == nil && link.UTMParams.empty() && link.Destinations == nil && link.GeoTargets == nil && link.DeviceTargets == nil &&
+			link.RoutingRules == nil && link.ActiveFrom == nil && link.ActiveUntil == nil && link.FallbackURL == nil {
+			err = links.UpsertLink(ctx, &link)
+		} else {
+			err = links.CreateLink(ctx, &link)
+		}
+		if err == nil {
+			return link, nil
+		}
+
+		if err != ErrCodeTaken {
+			return Link{}, err
+		}
+
+		slog.WarnContext(ctx, "Generated code already exists, retrying", "code", code)
+	}
+
+	return Link{}, errCodeSpaceExhausted
+}
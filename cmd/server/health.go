@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+// shuttingDown is set once the server starts draining so /readyz takes the
+// instance out of rotation before connections are refused.
+var shuttingDown atomic.Bool
+
+type ComponentStatus struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+type HealthResponse struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+// HealthzHandler reports the state of every dependency but always answers
+// 200, so a database outage never gets the process restarted. replica is
+// nil without a read replica and analytics without ClickHouse.
+func HealthzHandler(db Pinger, replica Pinger, analytics Pinger, redisClient *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := checkHealth(r.Context(), db, replica, analytics, redisClient)
+		writeHealth(r.Context(), w, response, http.StatusOK)
+	}
+}
+
+// ReadyzHandler answers 503 while any dependency is down or the server is
+// shutting down, so load balancers stop sending traffic.
+func ReadyzHandler(db Pinger, replica Pinger, analytics Pinger, redisClient *redis.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := checkHealth(r.Context(), db, replica, analytics, redisClient)
+		if shuttingDown.Load() {
+			response.Status = "shutting_down"
+		}
+
+		status := http.StatusOK
+		if response.Status != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+
+		writeHealth(r.Context(), w, response, status)
+	}
+}
+
+func checkHealth(ctx context.Context, db Pinger, replica Pinger, analytics Pinger, redisClient *redis.Client) HealthResponse {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	response := HealthResponse{
+		Status:     "ok",
+		Components: make(map[string]ComponentStatus),
+	}
+
+	response.Components["database"] = checkComponent(func() error {
+		return db.Ping(ctx)
+	})
+
+	if replica != nil {
+		response.Components["database_replica"] = checkComponent(func() error {
+			return replica.Ping(ctx)
+		})
+	}
+
+	if analytics != nil {
+		response.Components["clickhouse"] = checkComponent(func() error {
+			return analytics.Ping(ctx)
+		})
+	}
+
+	if redisClient != nil {
+		response.Components["redis"] = checkComponent(func() error {
+			return redisClient.Ping(ctx).Err()
+		})
+	}
+
+	for _, component := range response.Components {
+		if component.Status != "ok" {
+			response.Status = "degraded"
+		}
+	}
+
+	return response
+}
+
+func checkComponent(ping func() error) ComponentStatus {
+	startTime := time.Now()
+	err := ping()
+
+	status := ComponentStatus{
+		Status:    "ok",
+		LatencyMs: time.Since(startTime).Milliseconds(),
+	}
+	if err != nil {
+		status.Status = "down"
+		status.Error = err.Error()
+	}
+
+	return status
+}
+
+func writeHealth(ctx context.Context, w http.ResponseWriter, response HealthResponse, status int) {
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error marshaling JSON response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(jsonResponse)
+}
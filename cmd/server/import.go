@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	importSkip      = "skip"
+	importOverwrite = "overwrite"
+	importError     = "error"
+
+	maxImportRows       = 10000
+	maxImportBodyBytes  = 10 << 20
+	maxImportCodeLength = 64
+)
+
+// importColumns maps the CSV header names understood by POST /import,
+// including those used by common shortener exports, to ImportRow fields.
+var importColumns = map[string]string{
+	"code":              "code",
+	"keyword":           "code",
+	"alias":             "code",
+	"url":               "url",
+	"long_url":          "url",
+	"target":            "url",
+	"expires_at":        "expires_at",
+	"redirect_status":   "redirect_status",
+	"tracking_disabled": "tracking_disabled",
+	"forward_query":     "forward_query",
+	"utm_source":        "utm_source",
+	"utm_medium":        "utm_medium",
+	"utm_campaign":      "utm_campaign",
+}
+
+var errTooManyImportRows = fmt.Errorf("an import is limited to %d rows", maxImportRows)
+
+// ImportRow is one existing code to URL mapping to import.
+type ImportRow struct {
+	Code             string     `json:"code"`
+	URL              string     `json:"url"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	RedirectStatus   int        `json:"redirect_status,omitempty"`
+	TrackingDisabled bool       `json:"tracking_disabled,omitempty"`
+	ForwardQuery     bool       `json:"forward_query,omitempty"`
+	UTMSource        string     `json:"utm_source,omitempty"`
+	UTMMedium        string     `json:"utm_medium,omitempty"`
+	UTMCampaign      string     `json:"utm_campaign,omitempty"`
+}
+
+func (row ImportRow) utm() UTMParams {
+	return newUTMParams(row.UTMSource, row.UTMMedium, row.UTMCampaign)
+}
+
+// ImportLinksHandler imports code to URL mappings from a CSV (text/csv)
+// or JSON array body. on_conflict decides what happens to codes that are
+// already in use: skip them, overwrite their destination, or, with error,
+// abort the whole import before anything is written. Every row counts
+// against the monthly shorten quota, whatever its outcome. Rows whose
+// domain the policy refuses or whose URL the checker flags fail. Created links fire link.created, and created and
+// overwritten links have their titles fetched. With a domain parameter,
+// the codes are imported into the code space of that domain.
+func ImportLinksHandler(links LinkStore, cache LinkCache, quota *Quota, domains *DomainPolicy, checker URLChecker, webhooks *WebhookDispatcher, titles *TitleFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		policy := r.URL.Query().Get("on_conflict")
+		if policy == "" {
+			policy = importSkip
+		}
+		if policy != importSkip && policy != importOverwrite && policy != importError {
+			writeError(w, http.StatusBadRequest, "on_conflict must be skip, overwrite or error")
+			return
+		}
+
+		body := http.MaxBytesReader(w, r.Body, maxImportBodyBytes)
+
+		var rows []ImportRow
+		var err error
+		if strings.Contains(r.Header.Get("Content-Type"), "csv") {
+			rows, err = parseImportCSV(body)
+		} else {
+			rows, err = parseImportJSON(body)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		response := ImportResponse{
+			Status: "completed",
+			Total:  len(rows),
+			Rows:   make([]ImportRowResult, 0, len(rows)),
+		}
+		status := http.StatusOK
+
+		if policy == importError {
+			conflicts, err := findImportConflicts(r.Context(), links, rows)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+
+			if len(conflicts) > 0 {
+				response.Status = "aborted"
+				response.Failed = len(conflicts)
+				response.Rows = conflicts
+				status = http.StatusConflict
+			}
+		}
+
+		if response.Status == "completed" {
+			rules, err := domains.load(r.Context())
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+
+			if !quota.consume(w, r, len(rows)) {
+				return
+			}
+
+			for i, row := range rows {
+				result, err := importLink(r.Context(), links, cache, rules, checker, webhooks, titles, row, policy)
+				if err != nil {
+					slog.ErrorContext(r.Context(), "Error importing link", "error", err, "row", i+1)
+					writeError(w, http.StatusInternalServerError, "Internal Server Error")
+					return
+				}
+
+				result.Row = i + 1
+				result.Code = row.Code
+				response.Rows = append(response.Rows, result)
+
+				switch result.Status {
+				case "created":
+					response.Created++
+				case "overwritten":
+					response.Overwritten++
+				case "skipped":
+					response.Skipped++
+				default:
+					response.Failed++
+				}
+			}
+		}
+
+		response.ElapsedTime = time.Since(startTime).Milliseconds()
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(jsonResponse)
+	}
+}
+
+// importLink imports one row. Problems with the row itself are reported
+// in the result; the error is only set when the store fails.
+func importLink(ctx context.Context, links LinkStore, cache LinkCache, rules domainRules, checker URLChecker, webhooks *WebhookDispatcher, titles *TitleFetcher, row ImportRow, policy string) (ImportRowResult, error) {
+	if message := validateImportRow(&row); message != "" {
+		return ImportRowResult{Status: "failed", Error: message}, nil
+	}
+
+	unwrapped, err := unwrapURL(ctx, row.URL)
+	if err != nil {
+		return ImportRowResult{Status: "failed", Error: err.Error()}, nil
+	}
+	row.URL = unwrapped
+
+	if message := rules.check(row.URL); message != "" {
+		return ImportRowResult{Status: "failed", Error: message}, nil
+	}
+
+	if verdict, unsafe := isUnsafeURL(ctx, checker, row.URL); unsafe {
+		return ImportRowResult{Status: "failed", Error: unsafeURLMessage(verdict)}, nil
+	}
+
+	orgID := orgIDFromContext(ctx)
+	domainID := domainIDFromContext(ctx)
+
+	link := Link{
+		OrgID:            orgID,
+		DomainID:         domainID,
+		Code:             row.Code,
+		URL:              row.URL,
+		ExpiresAt:        row.ExpiresAt,
+		RedirectStatus:   row.RedirectStatus,
+		TrackingDisabled: row.TrackingDisabled,
+		ForwardQuery:     row.ForwardQuery,
+		UTMParams:        row.utm(),
+		KeyID:            keyIDFromContext(ctx),
+		MemberID:         sessionMemberIDFromContext(ctx),
+	}
+
+	err = links.CreateLink(ctx, &link)
+	switch {
+	case err == nil:
+		webhooks.Emit(orgID, webhookLinkCreated, newWebhookEventData(link))
+		titles.Fetch(link)
+		return ImportRowResult{Status: "created"}, nil
+	case err == ErrURLTaken:
+		return ImportRowResult{Status: "failed", Error: "URL is already shortened under another code"}, nil
+	case err != ErrCodeTaken:
+		return ImportRowResult{}, err
+	}
+
+	switch policy {
+	case importSkip:
+		return ImportRowResult{Status: "skipped"}, nil
+	case importError:
+		// The code was taken after the conflict check ran.
+		return ImportRowResult{Status: "failed", Error: "Code is already in use"}, nil
+	}
+
+	updated, err := links.UpdateLink(ctx, orgID, domainID, row.Code, func(link *Link) error {
+		if link.DeletedAt != nil {
+			return ErrLinkDeleted
+		}
+
+		link.URL = row.URL
+		link.ExpiresAt = row.ExpiresAt
+		link.RedirectStatus = row.RedirectStatus
+		link.TrackingDisabled = row.TrackingDisabled
+		link.ForwardQuery = row.ForwardQuery
+		link.UTMParams = row.utm()
+		link.Destinations = nil
+		link.GeoTargets = nil
+		link.DeviceTargets = nil
+		link.RoutingRules = nil
+		link.ActiveFrom = nil
+		link.ActiveUntil = nil
+		link.FallbackURL = nil
+
+		return nil
+	})
+	switch err {
+	case nil:
+		cache.Delete(ctx, orgID, domainID, row.Code)
+		titles.Fetch(updated)
+		return ImportRowResult{Status: "overwritten"}, nil
+	case ErrLinkDeleted:
+		return ImportRowResult{Status: "failed", Error: "Code belongs to a deleted link"}, nil
+	case ErrURLTaken:
+		return ImportRowResult{Status: "failed", Error: "URL is already shortened under another code"}, nil
+	default:
+		return ImportRowResult{}, err
+	}
+}
+
+// validateImportRow applies defaults to row and returns why it cannot be
+// imported, or an empty string.
+func validateImportRow(row *ImportRow) string {
+	if !isValidImportCode(row.Code) {
+		return "Invalid code"
+	}
+
+	if isReservedCode(row.Code) {
+		return "Code is reserved"
+	}
+
+	if row.URL == "" {
+		return "URL is required"
+	}
+
+	if row.RedirectStatus == 0 {
+		row.RedirectStatus = defaultRedirectStatus
+	}
+
+	if !isValidRedirectStatus(row.RedirectStatus) {
+		return "redirect_status must be 301, 302 or 307"
+	}
+
+	if row.ExpiresAt != nil && !row.ExpiresAt.After(time.Now()) {
+		return "expires_at must be in the future"
+	}
+
+	if err := row.utm().validate(); err != nil {
+		return err.Error()
+	}
+
+	return ""
+}
+
+// isValidImportCode is looser than isValidAlias: imported codes were
+// issued by another shortener and need not fit the configured charset.
+func isValidImportCode(code string) bool {
+	if code == "" || len(code) > maxImportCodeLength {
+		return false
+	}
+
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func findImportConflicts(ctx context.Context, links LinkStore, rows []ImportRow) ([]ImportRowResult, error) {
+	var conflicts []ImportRowResult
+
+	for i, row := range rows {
+		exists, err := links.CodeExists(ctx, orgIDFromContext(ctx), domainIDFromContext(ctx), row.Code)
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			conflicts = append(conflicts, ImportRowResult{Row: i + 1, Code: row.Code, Status: "conflict", Error: "Code is already in use"})
+		}
+	}
+
+	return conflicts, nil
+}
+
+func parseImportJSON(body io.Reader) ([]ImportRow, error) {
+	var rows []ImportRow
+	if err := json.NewDecoder(body).Decode(&rows); err != nil {
+		return nil, errors.New("Invalid request body")
+	}
+
+	if len(rows) > maxImportRows {
+		return nil, errTooManyImportRows
+	}
+
+	return rows, nil
+}
+
+// parseImportCSV reads a CSV body whose header names the columns. Columns
+// that are not in importColumns are ignored.
+func parseImportCSV(body io.Reader) ([]ImportRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("CSV body must start with a header row")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		if field, ok := importColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			columns[field] = i
+		}
+	}
+
+	if _, ok := columns["code"]; !ok {
+		return nil, errors.New("CSV header must include a code column")
+	}
+	if _, ok := columns["url"]; !ok {
+		return nil, errors.New("CSV header must include a url column")
+	}
+
+	var rows []ImportRow
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CSV on line %d", line)
+		}
+
+		if len(rows) == maxImportRows {
+			return nil, errTooManyImportRows
+		}
+
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		row := ImportRow{
+			Code:        field("code"),
+			URL:         field("url"),
+			UTMSource:   field("utm_source"),
+			UTMMedium:   field("utm_medium"),
+			UTMCampaign: field("utm_campaign"),
+		}
+
+		if value := field("expires_at"); value != "" {
+			expiresAt, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("expires_at on line %d must be an RFC 3339 timestamp", line)
+			}
+			row.ExpiresAt = &expiresAt
+		}
+
+		if value := field("redirect_status"); value != "" {
+			row.RedirectStatus, err = strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("redirect_status on line %d must be an integer", line)
+			}
+		}
+
+		if value := field("tracking_disabled"); value != "" {
+			row.TrackingDisabled, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("tracking_disabled on line %d must be true or false", line)
+			}
+		}
+
+		if value := field("forward_query"); value != "" {
+			row.ForwardQuery, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("forward_query on line %d must be true or false", line)
+			}
+		}
+
+		rows = append(rows, row)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+//go:embed templates/interstitial.html
+var templatesFS embed.FS
+
+var interstitialTemplate = template.Must(template.ParseFS(templatesFS, "templates/interstitial.html"))
+
+// interstitialData is what the interstitial shows. Verdict is safe, unsafe
+// or unchecked, the latter when no checker is configured or the check
+// failed.
+type interstitialData struct {
+	Code        string
+	URL         string
+	Title       string
+	Verdict     string
+	Threat      string
+	ContinueURL string
+}
+
+// wantsInterstitial reports whether the request asks for the interstitial
+// instead of the redirect, with a trailing + on the code or ?preview=1.
+func wantsInterstitial(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, "+") || r.URL.Query().Get("preview") == "1"
+}
+
+// renderInterstitial shows where link leads and whether it is safe, with a
+// button that continues to the redirect. Unsafe destinations get no
+// button. No click is counted until the visitor continues.
+func renderInterstitial(w http.ResponseWriter, r *http.Request, link Link, checker URLChecker) {
+	data := interstitialData{
+		Code:        link.Code,
+		URL:         destinationURL(link, forwardedQuery(r)),
+		Verdict:     "unchecked",
+		ContinueURL: strings.TrimSuffix(r.URL.Path, "+"),
+	}
+
+	// The query that would be forwarded must survive the detour.
+	if query := forwardedQuery(r); link.ForwardQuery && query != "" {
+		data.ContinueURL += "?" + query
+	}
+
+	if link.Title != nil {
+		data.Title = *link.Title
+	}
+
+	if _, ok := checker.(noopURLChecker); !ok {
+		verdict, err := checker.Check(r.Context(), link.URL)
+		switch {
+		case err != nil:
+			slog.WarnContext(r.Context(), "Error checking URL", "error", err)
+		case verdict.Unsafe:
+			data.Verdict = "unsafe"
+			data.Threat = strings.ToLower(verdict.Threat)
+		default:
+			data.Verdict = "safe"
+		}
+	}
+
+	renderInterstitialPage(w, r, data, http.StatusOK)
+}
+
+// renderInterstitialPage answers status with the interstitial showing
+// data.
+func renderInterstitialPage(w http.ResponseWriter, r *http.Request, data interstitialData, status int) {
+	var page bytes.Buffer
+	if err := interstitialTemplate.Execute(&page, data); err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering interstitial", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(page.Bytes())
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	linkCheckPollInterval = time.Minute
+	linkCheckBatchSize    = 20
+	// A destination is broken once it has failed linkCheckFailureThreshold
+	// checks in a row, so that a short outage does not flag it.
+	linkCheckFailureThreshold = 3
+)
+
+var errNotHTTPDestination = errors.New("destination is not an http or https URL")
+
+// LinkHealth is what the health checker last found at the destination of
+// a link. CheckStatus is nil when the destination did not respond, and
+// BrokenSince is set while the link counts as broken.
+type LinkHealth struct {
+	CheckedAt     *time.Time `db:"checked_at" json:"checked_at"`
+	CheckStatus   *int       `db:"check_status" json:"check_status"`
+	CheckFailures int        `db:"check_failures" json:"check_failures"`
+	BrokenSince   *time.Time `db:"broken_since" json:"broken_since"`
+}
+
+func (h LinkHealth) broken() bool {
+	return h.BrokenSince != nil
+}
+
+// checkLinks checks the destination of every live link once per interval.
+// Links are claimed in batches, so instances share the checks, and each
+// batch is checked in parallel. link.broken is fired when a link becomes
+// broken.
+func checkLinks(links LinkStore, cache LinkCache, webhooks *WebhookDispatcher, interval time.Duration) {
+	ticker := time.NewTicker(linkCheckPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			claimed, err := links.ClaimLinkChecks(context.Background(), time.Now().Add(-interval), linkCheckBatchSize)
+			if err != nil {
+				slog.Error("Error claiming link checks", "error", err)
+				break
+			}
+
+			var wg sync.WaitGroup
+			for _, link := range claimed {
+				wg.Add(1)
+				go func(link Link) {
+					defer wg.Done()
+					checkLink(links, cache, webhooks, link)
+				}(link)
+			}
+			wg.Wait()
+
+			if len(claimed) < linkCheckBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// checkLink records the outcome of checking one claimed link. Links with
+// a destination that cannot be fetched, such as an app deep link, are
+// left as they are.
+func checkLink(links LinkStore, cache LinkCache, webhooks *WebhookDispatcher, link Link) {
+	ctx := context.Background()
+
+	status, err := checkDestination(ctx, link.URL)
+	if err == errNotHTTPDestination {
+		return
+	}
+
+	wasBroken := link.broken()
+	now := time.Now()
+	link.CheckedAt = &now
+	link.CheckStatus = status
+
+	if err == nil && *status < http.StatusBadRequest {
+		link.CheckFailures = 0
+		link.BrokenSince = nil
+	} else {
+		link.CheckFailures++
+		if link.BrokenSince == nil && link.CheckFailures >= linkCheckFailureThreshold {
+			link.BrokenSince = &now
+		}
+	}
+
+	err = links.SetLinkHealth(ctx, link)
+	if err == ErrNotFound {
+		// The link was pointed at another URL during the check.
+		return
+	}
+	if err != nil {
+		slog.Error("Error storing link health", "error", err, "link_id", link.ID)
+		return
+	}
+
+	if link.broken() == wasBroken {
+		return
+	}
+
+	// Redirects of broken links go to their fallback URL.
+	cache.Delete(ctx, link.OrgID, link.DomainID, link.Code)
+
+	if link.broken() {
+		slog.Info("Link destination is broken", "link_id", link.ID, "status", status)
+
+		data := newWebhookEventData(link)
+		data.Status = status
+		webhooks.Emit(link.OrgID, webhookLinkBroken, data)
+	}
+}
+
+// checkDestination returns the status the destination responds with,
+// with a HEAD request unless the server does not allow them. Redirects are
+// followed.
+func checkDestination(ctx context.Context, destination string) (*int, error) {
+	status, err := requestDestination(ctx, http.MethodHead, destination)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = requestDestination(ctx, http.MethodGet, destination)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+func requestDestination(ctx context.Context, method string, destination string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, destination, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return 0, errNotHTTPDestination
+	}
+
+	req.Header.Set("User-Agent", "wowee-link-checker")
+
+	resp, err := pageClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxPageBytes))
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	defaultListenAddr       = ":3001"
+	defaultAutocertCacheDir = "autocert-cache"
+
+	// unixSocketPrefix marks a LISTEN_ADDR that is a Unix socket path.
+	unixSocketPrefix = "unix:"
+)
+
+// ListenConfig is where the HTTP server accepts connections. LISTEN_ADDR
+// is a host:port, or unix:/path for a Unix socket behind a reverse proxy.
+//
+// TLS is served with the certificate in TLS_CERT_FILE and TLS_KEY_FILE,
+// or with certificates Let's Encrypt issues for TLS_AUTOCERT_DOMAINS and
+// the verified custom domains of organizations. Autocert answers the
+// TLS-ALPN-01 challenge, so LISTEN_ADDR must be reachable on port 443;
+// certificates are kept in TLS_AUTOCERT_CACHE_DIR.
+type ListenConfig struct {
+	network  string
+	address  string
+	certFile string
+	keyFile  string
+	autocert *autocert.Manager
+}
+
+func NewListenConfig(cfg *config.Config, customDomains *DomainResolver) (*ListenConfig, error) {
+	l := &ListenConfig{
+		network:  "tcp",
+		address:  cfg.String("LISTEN_ADDR"),
+		certFile: cfg.String("TLS_CERT_FILE"),
+		keyFile:  cfg.String("TLS_KEY_FILE"),
+	}
+
+	if strings.HasPrefix(l.address, unixSocketPrefix) {
+		l.network = "unix"
+		l.address = strings.TrimPrefix(l.address, unixSocketPrefix)
+		if l.address == "" {
+			return nil, errors.New("LISTEN_ADDR names no socket path")
+		}
+	}
+
+	if (l.certFile == "") != (l.keyFile == "") {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	var domains []string
+	for _, domain := range cfg.List("TLS_AUTOCERT_DOMAINS") {
+		domains = append(domains, strings.ToLower(domain))
+	}
+
+	if len(domains) > 0 {
+		if l.certFile != "" {
+			return nil, errors.New("TLS_AUTOCERT_DOMAINS cannot be combined with TLS_CERT_FILE")
+		}
+
+		l.autocert = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocertHostPolicy(domains, customDomains),
+			Cache:      autocert.DirCache(cfg.String("TLS_AUTOCERT_CACHE_DIR")),
+			Email:      cfg.String("TLS_AUTOCERT_EMAIL"),
+		}
+	}
+
+	return l, nil
+}
+
+// autocertHostPolicy accepts the hosts of domains and those customDomains
+// reports as verified, so that certificates are issued for custom domains
+// once their organization verified them, and for no host clients make up.
+func autocertHostPolicy(domains []string, customDomains *DomainResolver) autocert.HostPolicy {
+	whitelist := autocert.HostWhitelist(domains...)
+
+	return func(ctx context.Context, host string) error {
+		if whitelist(ctx, host) == nil {
+			return nil
+		}
+
+		_, found, err := customDomains.Resolve(ctx, strings.ToLower(host))
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errors.New("acme/autocert: host " + host + " is not a verified domain")
+		}
+
+		return nil
+	}
+}
+
+// TLS reports whether connections are served over TLS.
+func (l *ListenConfig) TLS() bool {
+	return l.certFile != "" || l.autocert != nil
+}
+
+// String returns the address in the form of LISTEN_ADDR.
+func (l *ListenConfig) String() string {
+	if l.network == "unix" {
+		return unixSocketPrefix + l.address
+	}
+	return l.address
+}
+
+// Serve accepts connections for server until it is shut down. A socket
+// file left behind by an earlier run is replaced.
+func (l *ListenConfig) Serve(server *http.Server) error {
+	if l.network == "unix" {
+		if err := os.Remove(l.address); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	listener, err := net.Listen(l.network, l.address)
+	if err != nil {
+		return err
+	}
+
+	if !l.TLS() {
+		return server.Serve(listener)
+	}
+
+	if l.autocert != nil {
+		server.TLSConfig = &tls.Config{
+			GetCertificate: l.autocert.GetCertificate,
+			NextProtos:     []string{"h2", "http/1.1", acme.ALPNProto},
+		}
+	}
+
+	return server.ServeTLS(listener, l.certFile, l.keyFile)
+}
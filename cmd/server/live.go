@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// liveClickBuffer is how many clicks a subscriber may fall behind by
+	// before further clicks are dropped for it.
+	liveClickBuffer = 64
+	// liveKeepAlive is how often an idle stream sends a comment, so that
+	// proxies do not close the connection.
+	liveKeepAlive = 15 * time.Second
+	// liveRetry is how long browsers wait before reconnecting.
+	liveRetry = 5 * time.Second
+)
+
+// LiveClick is a click as it is streamed to subscribers. It has no ID yet,
+// as it is sent before the click is written.
+type LiveClick struct {
+	ClickedAt    time.Time `json:"clicked_at"`
+	Country      string    `json:"country"`
+	ReferrerHash *string   `json:"referrer_hash"`
+	DeviceType   string    `json:"device_type"`
+}
+
+// ClickBus hands the clicks the recorder takes in to the subscribers of
+// their link. It is in-process: a stream only sees the clicks of the
+// instance it is connected to.
+type ClickBus struct {
+	mu          sync.Mutex
+	subscribers map[int]map[chan LiveClick]struct{}
+	closed      bool
+}
+
+func NewClickBus() *ClickBus {
+	return &ClickBus{subscribers: make(map[int]map[chan LiveClick]struct{})}
+}
+
+// Subscribe returns a channel that receives the clicks of the link and a
+// function that ends the subscription. The channel is closed when the
+// subscription ends or the bus is closed.
+func (b *ClickBus) Subscribe(linkID int) (<-chan LiveClick, func()) {
+	ch := make(chan LiveClick, liveClickBuffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	if b.subscribers[linkID] == nil {
+		b.subscribers[linkID] = make(map[chan LiveClick]struct{})
+	}
+	b.subscribers[linkID][ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[linkID][ch]; !ok {
+			return
+		}
+
+		delete(b.subscribers[linkID], ch)
+		if len(b.subscribers[linkID]) == 0 {
+			delete(b.subscribers, linkID)
+		}
+		close(ch)
+	}
+}
+
+// Publish sends event to the subscribers of its link. It never blocks: a
+// subscriber that is not keeping up misses the click.
+func (b *ClickBus) Publish(event ClickEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	click := LiveClick{
+		ClickedAt:    event.ClickedAt,
+		Country:      event.Country,
+		ReferrerHash: event.ReferrerHash,
+		DeviceType:   event.DeviceType,
+	}
+
+	for ch := range b.subscribers[event.LinkID] {
+		select {
+		case ch <- click:
+		default:
+		}
+	}
+}
+
+// Close ends every subscription, so that open streams finish and do not
+// hold up the shutdown.
+func (b *ClickBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, subscribers := range b.subscribers {
+		for ch := range subscribers {
+			close(ch)
+		}
+	}
+	b.subscribers = make(map[int]map[chan LiveClick]struct{})
+}
+
+// LiveClicksHandler streams the clicks of a link as server-sent events
+// while they happen, a click event per click and a comment every
+// liveKeepAlive otherwise. The write deadline is lifted for the stream.
+func LiveClicksHandler(links LinkStore, bus *ClickBus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code := mux.Vars(r)["code"]
+
+		link, err := links.GetLink(r.Context(), orgIDFromContext(r.Context()), domainIDFromContext(r.Context()), code)
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		controller := http.NewResponseController(w)
+		controller.SetWriteDeadline(time.Time{})
+
+		clicks, unsubscribe := bus.Subscribe(link.ID)
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// nginx buffers responses unless told otherwise.
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		fmt.Fprintf(w, "retry: %d\n\n", liveRetry.Milliseconds())
+		if err := controller.Flush(); err != nil {
+			return
+		}
+
+		keepAlive := time.NewTicker(liveKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case click, ok := <-clicks:
+				if !ok {
+					return
+				}
+
+				data, err := json.Marshal(click)
+				if err != nil {
+					slog.ErrorContext(r.Context(), "Error encoding click", "error", err)
+					return
+				}
+
+				fmt.Fprintf(w, "event: click\ndata: %s\n\n", data)
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			}
+
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,1112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/extra/redisotel/v9"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"google.golang.org/grpc"
+)
+
+type IndexResponse struct {
+	Status string `json:"status"`
+}
+
+type ShortenRequest struct {
+	URL              string     `json:"url"`
+	Alias            string     `json:"alias,omitempty"`
+	TTLSeconds       int64      `json:"ttl_seconds,omitempty"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	RedirectStatus   int        `json:"redirect_status,omitempty"`
+	CodeLength       int        `json:"code_length,omitempty"`
+	MaxClicks        int        `json:"max_clicks,omitempty"`
+	TrackingDisabled bool       `json:"tracking_disabled,omitempty"`
+	ForwardQuery     bool       `json:"forward_query,omitempty"`
+	UTMSource        string     `json:"utm_source,omitempty"`
+	UTMMedium        string     `json:"utm_medium,omitempty"`
+	UTMCampaign      string     `json:"utm_campaign,omitempty"`
+	// Destinations split the visits of the link; URL may then be left out.
+	Destinations       []DestinationRequest `json:"destinations,omitempty"`
+	StickyDestinations bool                 `json:"sticky_destinations,omitempty"`
+	GeoTargets         []GeoTarget          `json:"geo_targets,omitempty"`
+	DeviceTargets      []DeviceTarget       `json:"device_targets,omitempty"`
+	RoutingRules       []RoutingRule        `json:"routing_rules,omitempty"`
+	// Outside of active_from and active_until the link sends visitors to
+	// fallback_url, or answers 404 without one. Expired links and links
+	// over their click limit send visitors to it too.
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+	FallbackURL string     `json:"fallback_url,omitempty"`
+	// Tags group the link with others, such as those of a campaign. They
+	// are added to the tags of a URL that was already shortened.
+	Tags []string `json:"tags,omitempty"`
+	// FolderID files the link in a folder. A URL that was already
+	// shortened is moved to it.
+	FolderID int `json:"folder_id,omitempty"`
+	// ForceNew always creates a link, even for a URL the caller's API key
+	// already shortened. The new link is never deduplicated against.
+	ForceNew bool `json:"force_new,omitempty"`
+	// Domain issues the link under a verified custom domain of the
+	// caller's organization instead of SHORT_DOMAINS. DomainID is its ID,
+	// looked up from the hostname.
+	Domain   string `json:"domain,omitempty"`
+	DomainID int    `json:"-"`
+	// Description says what the link is for and Notes keep internal
+	// context about it. Given for a URL that was already shortened, they
+	// replace its own.
+	Description string `json:"description,omitempty"`
+	Notes       string `json:"notes,omitempty"`
+	// CaptchaToken is the CAPTCHA_PROVIDER token anonymous requests need
+	// when a CAPTCHA is configured.
+	CaptchaToken   string  `json:"captcha_token,omitempty"`
+	IdempotencyKey *string `json:"-"`
+	// IdempotencyHash fingerprints the request as it was received, set
+	// along with IdempotencyKey.
+	IdempotencyHash string `json:"-"`
+}
+
+type UpdateLinkRequest struct {
+	URL              *string    `json:"url"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	RedirectStatus   *int       `json:"redirect_status"`
+	TrackingDisabled *bool      `json:"tracking_disabled"`
+	ForwardQuery     *bool      `json:"forward_query"`
+	// An empty UTM parameter removes it from the link.
+	UTMSource   *string `json:"utm_source"`
+	UTMMedium   *string `json:"utm_medium"`
+	UTMCampaign *string `json:"utm_campaign"`
+	// An empty list of destinations leaves the link with the first one.
+	Destinations       *[]DestinationRequest `json:"destinations"`
+	StickyDestinations *bool                 `json:"sticky_destinations"`
+	// An empty list of geo targets, device targets or routing rules
+	// removes them.
+	GeoTargets    *[]GeoTarget    `json:"geo_targets"`
+	DeviceTargets *[]DeviceTarget `json:"device_targets"`
+	RoutingRules  *[]RoutingRule  `json:"routing_rules"`
+	ActiveFrom    *time.Time      `json:"active_from"`
+	ActiveUntil   *time.Time      `json:"active_until"`
+	// An empty fallback URL removes it.
+	FallbackURL *string `json:"fallback_url"`
+	// Tags replace the tags of the link; an empty list removes them.
+	Tags *[]string `json:"tags"`
+	// A folder_id of 0 takes the link out of its folder.
+	FolderID *int `json:"folder_id"`
+	// An empty description or notes removes them.
+	Description *string `json:"description"`
+	Notes       *string `json:"notes"`
+}
+
+// CampaignRequest creates a link per variant of one destination. The
+// settings besides the variants apply to every link.
+type CampaignRequest struct {
+	URL              string            `json:"url"`
+	UTMCampaign      string            `json:"utm_campaign,omitempty"`
+	ExpiresAt        *time.Time        `json:"expires_at,omitempty"`
+	RedirectStatus   int               `json:"redirect_status,omitempty"`
+	TrackingDisabled bool              `json:"tracking_disabled,omitempty"`
+	ForwardQuery     bool              `json:"forward_query,omitempty"`
+	Tags             []string          `json:"tags,omitempty"`
+	Variants         []CampaignVariant `json:"variants"`
+}
+
+// CampaignVariant is one link of a campaign. UTMCampaign overrides the
+// campaign of the request.
+type CampaignVariant struct {
+	Alias       string `json:"alias,omitempty"`
+	UTMSource   string `json:"utm_source"`
+	UTMMedium   string `json:"utm_medium,omitempty"`
+	UTMCampaign string `json:"utm_campaign,omitempty"`
+}
+
+// CampaignResponse lists the links of a campaign in the order of its
+// variants.
+type CampaignResponse struct {
+	URL         string         `json:"url"`
+	Links       []CampaignLink `json:"links"`
+	ElapsedTime int64          `json:"elapsed_time"`
+}
+
+// CampaignLink is a link of a campaign with the URL its visits are sent
+// to.
+type CampaignLink struct {
+	Code           string `json:"code"`
+	ShortURL       string `json:"short_url"`
+	DestinationURL string `json:"destination_url"`
+	UTMParams
+}
+
+type ShortenResponse struct {
+	Code        string     `json:"code"`
+	ShortURL    string     `json:"short_url"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	Tags        []string   `json:"tags,omitempty"`
+	ElapsedTime int64      `json:"elapsed_time"`
+}
+
+type GetURLResponse struct {
+	URL         string `json:"url"`
+	ElapsedTime int64  `json:"elapsed_time"`
+}
+
+// PreviewResponse is a link's destination with the metadata of the page.
+// Metadata fields are empty when the page could not be fetched.
+type PreviewResponse struct {
+	Code string `json:"code"`
+	URL  string `json:"url"`
+	PageMetadata
+	ElapsedTime int64 `json:"elapsed_time"`
+}
+
+type ListLinksResponse struct {
+	Links       []Link `json:"links"`
+	Total       int    `json:"total"`
+	Limit       int    `json:"limit"`
+	Offset      int    `json:"offset"`
+	ElapsedTime int64  `json:"elapsed_time"`
+}
+
+// PeriodCounts counts something over the current UTC day and the 7 and 30
+// days ending on it.
+type PeriodCounts struct {
+	Today      int64 `json:"today"`
+	Last7Days  int64 `json:"last_7_days"`
+	Last30Days int64 `json:"last_30_days"`
+}
+
+// StatsResponse summarizes the links of the caller's organization, or of
+// every namespace for the admin key. CreatedPerDay averages the links
+// created over the last 30 days.
+type StatsResponse struct {
+	Scope         string       `json:"scope"`
+	Tag           string       `json:"tag,omitempty"`
+	Links         int64        `json:"links"`
+	Clicks        PeriodCounts `json:"clicks"`
+	Created       PeriodCounts `json:"created"`
+	CreatedPerDay float64      `json:"created_per_day"`
+	TopLinks      []Link       `json:"top_links"`
+	ElapsedTime   int64        `json:"elapsed_time"`
+}
+
+type TrendingLinksResponse struct {
+	Window      string         `json:"window"`
+	Links       []TrendingLink `json:"links"`
+	Total       int            `json:"total"`
+	Limit       int            `json:"limit"`
+	Offset      int            `json:"offset"`
+	ElapsedTime int64          `json:"elapsed_time"`
+}
+
+// ClickEventsResponse is a page of click events. NextCursor is empty on
+// the last page.
+type ClickEventsResponse struct {
+	Code        string       `json:"code"`
+	Events      []ClickEvent `json:"events"`
+	NextCursor  string       `json:"next_cursor,omitempty"`
+	ElapsedTime int64        `json:"elapsed_time"`
+}
+
+// AnalyticsResponse is the clicks and visitors of a link per hour or day,
+// from ClickHouse.
+type AnalyticsResponse struct {
+	Code        string            `json:"code"`
+	Interval    string            `json:"interval"`
+	From        time.Time         `json:"from"`
+	To          time.Time         `json:"to"`
+	Series      []AnalyticsBucket `json:"series"`
+	ElapsedTime int64             `json:"elapsed_time"`
+}
+
+// AnalyticsBreakdownResponse is the clicks and visitors of a link per
+// country or device type, from ClickHouse.
+type AnalyticsBreakdownResponse struct {
+	Code        string               `json:"code"`
+	Dimension   string               `json:"dimension"`
+	From        time.Time            `json:"from"`
+	To          time.Time            `json:"to"`
+	Breakdown   []AnalyticsBreakdown `json:"breakdown"`
+	ElapsedTime int64                `json:"elapsed_time"`
+}
+
+type ClickTimeSeriesResponse struct {
+	Code        string        `json:"code"`
+	Granularity string        `json:"granularity"`
+	Series      []ClickBucket `json:"series"`
+	ElapsedTime int64         `json:"elapsed_time"`
+}
+
+// ReferrersResponse lists the top referrer hosts of a link. Direct visits
+// are counted under an empty referrer.
+type ReferrersResponse struct {
+	Code        string          `json:"code"`
+	Referrers   []ReferrerCount `json:"referrers"`
+	ElapsedTime int64           `json:"elapsed_time"`
+}
+
+// CountriesResponse breaks the clicks of a link down by country. Clicks
+// whose country could not be resolved are counted under an empty country.
+type CountriesResponse struct {
+	Code        string         `json:"code"`
+	Countries   []CountryCount `json:"countries"`
+	ElapsedTime int64          `json:"elapsed_time"`
+}
+
+// DestinationsResponse breaks the clicks of a link down by destination.
+// Only the current destinations are listed.
+type DestinationsResponse struct {
+	Code               string              `json:"code"`
+	StickyDestinations bool                `json:"sticky_destinations"`
+	Destinations       []DestinationClicks `json:"destinations"`
+	ElapsedTime        int64               `json:"elapsed_time"`
+}
+
+type DestinationClicks struct {
+	LinkDestination
+	Clicks int64 `json:"clicks"`
+}
+
+type DeviceBreakdown struct {
+	Name   string `json:"name"`
+	Clicks int64  `json:"clicks"`
+}
+
+// DevicesResponse breaks the clicks of a link down by device type,
+// browser and operating system. Clicks by known bots are listed under Bots
+// and only counted in the bot device type.
+type DevicesResponse struct {
+	Code             string            `json:"code"`
+	DeviceTypes      []DeviceBreakdown `json:"device_types"`
+	Browsers         []DeviceBreakdown `json:"browsers"`
+	OperatingSystems []DeviceBreakdown `json:"operating_systems"`
+	Bots             []DeviceBreakdown `json:"bots"`
+	ElapsedTime      int64             `json:"elapsed_time"`
+}
+
+type ValidateRoutingRulesRequest struct {
+	RoutingRules []RoutingRule `json:"routing_rules"`
+}
+
+// ValidateRoutingRulesResponse is a valid set of routing rules as a link
+// stores them, with their values normalized and their URLs unwrapped.
+type ValidateRoutingRulesResponse struct {
+	RoutingRules []RoutingRule `json:"routing_rules"`
+	ElapsedTime  int64         `json:"elapsed_time"`
+}
+
+// ImportRowResult reports the outcome of one imported row: created,
+// overwritten, skipped, failed, or conflict when the import was aborted.
+type ImportRowResult struct {
+	Row    int    `json:"row"`
+	Code   string `json:"code"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ImportResponse struct {
+	Status      string            `json:"status"`
+	Total       int               `json:"total"`
+	Created     int               `json:"created"`
+	Overwritten int               `json:"overwritten"`
+	Skipped     int               `json:"skipped"`
+	Failed      int               `json:"failed"`
+	Rows        []ImportRowResult `json:"rows"`
+	ElapsedTime int64             `json:"elapsed_time"`
+}
+
+type CreateOrganizationRequest struct {
+	Slug       string `json:"slug"`
+	Name       string `json:"name"`
+	OwnerEmail string `json:"owner_email"`
+}
+
+// CreateOrganizationResponse carries the owner's first API key. Key is
+// the only copy of the secret.
+type CreateOrganizationResponse struct {
+	Organization Organization `json:"organization"`
+	Owner        Member       `json:"owner"`
+	APIKey       APIKey       `json:"api_key"`
+	Key          string       `json:"key"`
+	ElapsedTime  int64        `json:"elapsed_time"`
+}
+
+type AddMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type MembersResponse struct {
+	Members []Member `json:"members"`
+}
+
+// CreateAPIKeyRequest issues a key with every scope unless Scopes lists
+// them, and one that never expires unless ExpiresAt is set.
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	MemberID  int        `json:"member_id,omitempty"`
+	Scopes    []string   `json:"scopes,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// CreateAPIKeyResponse carries a new API key. Key is the only copy of the
+// secret.
+type CreateAPIKeyResponse struct {
+	APIKey      APIKey `json:"api_key"`
+	Key         string `json:"key"`
+	ElapsedTime int64  `json:"elapsed_time"`
+}
+
+// RotateAPIKeyRequest overrides API_KEY_ROTATION_GRACE, how long the
+// rotated key keeps working.
+type RotateAPIKeyRequest struct {
+	GraceSeconds *int64 `json:"grace_seconds,omitempty"`
+}
+
+// RotateAPIKeyResponse carries the new key, whose secret Key is, and the
+// rotated one with the end of its grace period as ExpiresAt.
+type RotateAPIKeyResponse struct {
+	APIKey      APIKey `json:"api_key"`
+	Key         string `json:"key"`
+	Previous    APIKey `json:"previous"`
+	ElapsedTime int64  `json:"elapsed_time"`
+}
+
+// StatsShareRequest sets how long a stats share link works.
+type StatsShareRequest struct {
+	TTLSeconds *int64 `json:"ttl_seconds,omitempty"`
+}
+
+type APIKeysResponse struct {
+	APIKeys []APIKey `json:"api_keys"`
+}
+
+type CreateDomainRequest struct {
+	Hostname string `json:"hostname"`
+}
+
+type DomainsResponse struct {
+	Domains []CustomDomain `json:"domains"`
+}
+
+// QuotaUsage is the use of one monthly quota. A zero Limit means the
+// metric is not limited, and Remaining is then null.
+type QuotaUsage struct {
+	Used      int  `json:"used"`
+	Limit     int  `json:"limit"`
+	Remaining *int `json:"remaining"`
+}
+
+type UsageResponse struct {
+	Month       string     `json:"month"`
+	Shortens    QuotaUsage `json:"shortens"`
+	Redirects   QuotaUsage `json:"redirects"`
+	ResetsAt    time.Time  `json:"resets_at"`
+	ElapsedTime int64      `json:"elapsed_time"`
+}
+
+// CreateFolderRequest creates a folder under parent_id, or at the top
+// level without one.
+type CreateFolderRequest struct {
+	Name     string `json:"name"`
+	ParentID int    `json:"parent_id,omitempty"`
+}
+
+// UpdateFolderRequest renames or moves a folder. A parent_id of 0 moves it
+// to the top level.
+type UpdateFolderRequest struct {
+	Name     *string `json:"name"`
+	ParentID *int    `json:"parent_id"`
+}
+
+type FoldersResponse struct {
+	Folders     []Folder `json:"folders"`
+	ElapsedTime int64    `json:"elapsed_time"`
+}
+
+// MoveLinksRequest files the links of codes in a folder, or in none when
+// folder_id is 0.
+type MoveLinksRequest struct {
+	Codes    []string `json:"codes"`
+	FolderID int      `json:"folder_id"`
+}
+
+type MoveLinksResponse struct {
+	Moved       int64 `json:"moved"`
+	ElapsedTime int64 `json:"elapsed_time"`
+}
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+type UpdateWebhookRequest struct {
+	URL    *string   `json:"url"`
+	Events *[]string `json:"events"`
+	Active *bool     `json:"active"`
+}
+
+// CreateWebhookResponse carries the secret deliveries to the webhook are
+// signed with. Secret is only returned here.
+type CreateWebhookResponse struct {
+	Webhook     Webhook `json:"webhook"`
+	Secret      string  `json:"secret"`
+	ElapsedTime int64   `json:"elapsed_time"`
+}
+
+type WebhooksResponse struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+type CreateDomainRuleRequest struct {
+	Domain string `json:"domain"`
+	List   string `json:"list"`
+}
+
+type DomainRulesResponse struct {
+	Rules []DomainRule `json:"rules"`
+}
+
+// EraseAnalyticsRequest names either a link, by code, the slug of its
+// organization and its custom domain, or a visitor, by IP address or its
+// hash.
+type EraseAnalyticsRequest struct {
+	Org    string `json:"org,omitempty"`
+	Domain string `json:"domain,omitempty"`
+	Code   string `json:"code,omitempty"`
+	IP     string `json:"ip,omitempty"`
+	IPHash string `json:"ip_hash,omitempty"`
+}
+
+type EraseAnalyticsResponse struct {
+	ErasedEvents int64 `json:"erased_events"`
+	ElapsedTime  int64 `json:"elapsed_time"`
+}
+
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+type Link struct {
+	ID               int        `db:"id" json:"id"`
+	OrgID            int        `db:"org_id" json:"-"`
+	Code             string     `db:"code" json:"code"`
+	URL              string     `db:"url" json:"url"`
+	CreatedAt        time.Time  `db:"created_at" json:"created_at"`
+	AttemptCount     int        `db:"attempt_count" json:"attempt_count"`
+	ClickCount       int        `db:"click_count" json:"click_count"`
+	BotClicks        int        `db:"bot_clicks" json:"bot_clicks"`
+	ExpiresAt        *time.Time `db:"expires_at" json:"expires_at"`
+	RedirectStatus   int        `db:"redirect_status" json:"redirect_status"`
+	DeletedAt        *time.Time `db:"deleted_at" json:"deleted_at"`
+	ArchivedAt       *time.Time `db:"archived_at" json:"archived_at"`
+	UpdatedAt        *time.Time `db:"updated_at" json:"updated_at"`
+	MaxClicks        *int       `db:"max_clicks" json:"max_clicks"`
+	Title            *string    `db:"title" json:"title"`
+	TrackingDisabled bool       `db:"tracking_disabled" json:"tracking_disabled"`
+	ForwardQuery     bool       `db:"forward_query" json:"forward_query"`
+	// Destinations, when set, are the URLs visits are split between; URL
+	// is the first of them.
+	Destinations       linkDestinations  `db:"destinations" json:"destinations"`
+	StickyDestinations bool              `db:"sticky_destinations" json:"sticky_destinations"`
+	GeoTargets         linkGeoTargets    `db:"geo_targets" json:"geo_targets"`
+	DeviceTargets      linkDeviceTargets `db:"device_targets" json:"device_targets"`
+	RoutingRules       linkRoutingRules  `db:"routing_rules" json:"routing_rules"`
+	ActiveFrom         *time.Time        `db:"active_from" json:"active_from"`
+	ActiveUntil        *time.Time        `db:"active_until" json:"active_until"`
+	FallbackURL        *string           `db:"fallback_url" json:"fallback_url"`
+	FolderID           *int              `db:"folder_id" json:"folder_id"`
+	// Description and Notes are for the team managing the link and never
+	// shown to visitors.
+	Description    *string `db:"description" json:"description"`
+	Notes          *string `db:"notes" json:"notes"`
+	IdempotencyKey *string `db:"idempotency_key" json:"-"`
+	// KeyID is the API key that created the link, 0 when none did. Only
+	// shortens with the same key are deduplicated against the link, and
+	// never against a ForceNew one.
+	KeyID    int  `db:"key_id" json:"-"`
+	ForceNew bool `db:"force_new" json:"-"`
+	// MemberID is the member whose session created the link, 0 when an
+	// API key or no caller did. Idempotency keys are unique per KeyID and
+	// MemberID, and IdempotencyHash fingerprints the request that used
+	// one. Only GetLinkByIdempotencyKey reads them back.
+	MemberID        int     `db:"member_id" json:"-"`
+	IdempotencyHash *string `db:"idempotency_hash" json:"-"`
+	// DomainID is the custom domain the link was issued under, 0 for
+	// SHORT_DOMAINS.
+	DomainID int `db:"domain_id" json:"domain_id,omitempty"`
+	// Tags are stored apart from the link and only loaded where it is
+	// listed or shown with its stats.
+	Tags        []string `db:"-" json:"tags"`
+	ElapsedTime int64    `json:"elapsed_time"`
+	UTMParams
+	LinkHealth
+	LinkQuarantine
+}
+
+const (
+	defaultCodeLength    = 6
+	defaultMaxCodeLength = 16
+	defaultCharset       = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNOPQRSTUVWXYZ0123456789"
+	minAliasLength       = 3
+	maxAliasLength       = 32
+	purgeInterval        = 10 * time.Minute
+
+	// maxCodeAttempts bounds how many generated codes are tried before
+	// giving up. Every second collision grows the code by one character.
+	maxCodeAttempts = 6
+
+	defaultRedirectStatus       = http.StatusFound
+	defaultRedirectCacheControl = "private, max-age=90"
+
+	defaultListLimit = 20
+	maxListLimit     = 100
+
+	defaultReferrerLimit = 10
+	maxReferrerLimit     = 100
+
+	defaultClickEventLimit = 100
+	maxClickEventLimit     = 1000
+
+	statsTopLinks = 10
+
+	readTimeout       = 10 * time.Second
+	readHeaderTimeout = 5 * time.Second
+	writeTimeout      = 15 * time.Second
+	idleTimeout       = 60 * time.Second
+	shutdownTimeout   = 30 * time.Second
+)
+
+func main() {
+	cfg, err := config.Load(os.Args[0], settings, os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+
+	setupLogger(cfg)
+
+	shutdownTracing, err := setupTracing(cfg)
+	if err != nil {
+		fatal("Invalid tracing configuration", err)
+	}
+
+	driver, err := lookupDatabaseDriver(cfg)
+	if err != nil {
+		fatal("Invalid database configuration", err)
+	}
+
+	db, err := driver.open(cfg.String("DATABASE_URL"))
+	if err != nil {
+		fatal("Error connecting to database", err)
+	}
+
+	driver.configurePool(cfg, db)
+
+	if args := cfg.Args(); len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrateCommand(db, driver, args[1:]); err != nil {
+			fatal("Error running migrations", err)
+		}
+		return
+	}
+
+	// AUTO_MIGRATE=false leaves schema changes to an explicit
+	// "migrate up", e.g. when several instances start at once.
+	if cfg.Bool("AUTO_MIGRATE") {
+		if err := runMigrations(db, driver); err != nil {
+			fatal("Error running migrations", err)
+		}
+	}
+
+	store, err := driver.newStore(db)
+	if err != nil {
+		fatal("Error preparing database statements", err)
+	}
+
+	// DATABASE_REPLICA_URL moves redirect lookups and stats queries to a
+	// read replica; writes stay on the primary.
+	var replica Store
+	reads := Store(store)
+	if replicaURL := cfg.String("DATABASE_REPLICA_URL"); replicaURL != "" {
+		replicaDB, err := driver.open(replicaURL)
+		if err != nil {
+			fatal("Error connecting to database replica", err)
+		}
+
+		driver.configurePool(cfg, replicaDB)
+
+		if replica, err = driver.newStore(replicaDB); err != nil {
+			fatal("Error preparing database statements", err)
+		}
+		reads = NewReplicaStore(store, replica)
+	}
+
+	// CLICKHOUSE_URL moves the click events to ClickHouse and adds the
+	// analytics endpoints that query them; links and counters stay in the
+	// database.
+	var clickhouse *ClickHouseClient
+	var analytics Pinger
+	if clickhouseURL := cfg.String("CLICKHOUSE_URL"); clickhouseURL != "" {
+		clickhouse, err = NewClickHouseClient(clickhouseURL)
+		if err != nil {
+			fatal("Invalid ClickHouse configuration", err)
+		}
+
+		if err := clickhouse.CreateTables(context.Background()); err != nil {
+			fatal("Error creating ClickHouse tables", err)
+		}
+
+		analytics = clickhouse
+		store = NewClickHouseStore(store, clickhouse)
+		reads = NewClickHouseStore(reads, clickhouse)
+	}
+
+	webhooks := NewWebhookDispatcher(store)
+
+	go purgeExpiredLinks(store, webhooks, purgeInterval)
+
+	// CLICK_EVENT_RETENTION bounds how long click events are kept; unset,
+	// they are kept until their link is purged.
+	if eventRetention := cfg.Duration("CLICK_EVENT_RETENTION"); eventRetention > 0 {
+		go purgeClickEvents(store, eventRetention, purgeInterval)
+	}
+
+	rollupInterval := cfg.Duration("CLICK_ROLLUP_INTERVAL")
+	if rollupInterval <= 0 {
+		fatal("Invalid click rollup configuration", errors.New("CLICK_ROLLUP_INTERVAL must be positive"))
+	}
+	go maintainClicks(store, rollupInterval)
+
+	codeConfig, err := loadCodeConfig(cfg)
+	if err != nil {
+		fatal("Invalid code configuration", err)
+	}
+
+	codes, err := NewCodeGenerator(cfg, store, codeConfig.Charset)
+	if err != nil {
+		fatal("Invalid code configuration", err)
+	}
+
+	var redisClient *redis.Client
+	if redisURL := cfg.String("REDIS_URL"); redisURL != "" {
+		options, err := redis.ParseURL(redisURL)
+		if err != nil {
+			fatal("Invalid REDIS_URL", err)
+		}
+		redisClient = redis.NewClient(options)
+
+		if err := redisotel.InstrumentTracing(redisClient); err != nil {
+			fatal("Error instrumenting Redis client", err)
+		}
+	}
+
+	cache, err := NewLinkCache(cfg, redisClient)
+	if err != nil {
+		fatal("Error configuring cache", err)
+	}
+
+	// HEALTH_CHECK_INTERVAL is how often destinations are checked; unset,
+	// they are not.
+	if interval := cfg.Duration("HEALTH_CHECK_INTERVAL"); interval > 0 {
+		go checkLinks(store, cache, webhooks, interval)
+	}
+
+	if err := loadBotNetworks(cfg); err != nil {
+		fatal("Invalid bot configuration", err)
+	}
+
+	countries, err := NewCountryLookup(cfg)
+	if err != nil {
+		fatal("Error loading GeoIP database", err)
+	}
+
+	liveClicks := NewClickBus()
+	clicks := NewClickRecorder(store, countries, liveClicks)
+	scanner := NewLinkScanner(store, cache, webhooks, NewDestinationScanners(cfg))
+	titles := NewTitleFetcher(store, cache, scanner)
+
+	checker := NewURLChecker(cfg)
+
+	captcha, err := NewCaptchaVerifier(cfg)
+	if err != nil {
+		fatal("Error configuring CAPTCHA", err)
+	}
+
+	sessions, err := NewSessionIssuer(cfg)
+	if err != nil {
+		fatal("Error configuring sessions", err)
+	}
+
+	identityProviders, err := NewIdentityProviders(cfg)
+	if err != nil {
+		fatal("Error configuring identity providers", err)
+	}
+	if len(identityProviders) > 0 && sessions == nil {
+		fatal("Error configuring identity providers", errors.New("logging in requires SESSION_SECRET"))
+	}
+
+	domains, err := NewDomainPolicy(cfg, store)
+	if err != nil {
+		fatal("Error configuring domain rules", err)
+	}
+
+	trustProxyHeaders = cfg.Bool("TRUST_PROXY_HEADERS")
+	adminAPIKey = cfg.String("ADMIN_API_KEY")
+	checkURLsOnRedirect = cfg.Bool("SAFE_BROWSING_ON_REDIRECT")
+	privacyMode = cfg.Bool("PRIVACY_MODE")
+	redirectCacheControl = cfg.String("REDIRECT_CACHE_CONTROL")
+	countHeadClicks = cfg.Bool("REDIRECT_HEAD_CLICKS")
+
+	baseURL, err = parseBaseURL(cfg.String("BASE_URL"))
+	if err != nil {
+		fatal("Invalid BASE_URL configuration", err)
+	}
+
+	cors, err := NewCORSPolicy(cfg)
+	if err != nil {
+		fatal("Invalid CORS configuration", err)
+	}
+
+	if err := loadShortenerConfig(cfg); err != nil {
+		fatal("Invalid shortener configuration", err)
+	}
+	loadURLNormConfig(cfg)
+
+	rateLimitStore, err := NewRateLimitStore(cfg, redisClient)
+	if err != nil {
+		fatal("Error configuring rate limiting", err)
+	}
+
+	shortenLimiter := NewRateLimiter(cfg, rateLimitStore, "SHORTEN")
+	redirectLimiter := NewRateLimiter(cfg, rateLimitStore, "REDIRECT")
+	previewLimiter := NewRateLimiter(cfg, rateLimitStore, "PREVIEW")
+	graphqlLimiter := NewRateLimiter(cfg, rateLimitStore, "GRAPHQL")
+	aliasLimiter := NewRateLimiter(cfg, rateLimitStore, "ALIAS")
+	reportLimiter := NewRateLimiter(cfg, rateLimitStore, "REPORT")
+
+	nonceStore, err := NewNonceStore(cfg, redisClient)
+	if err != nil {
+		fatal("Error configuring request signing", err)
+	}
+
+	banStore, err := NewBanStore(cfg, redisClient)
+	if err != nil {
+		fatal("Error configuring enumeration bans", err)
+	}
+	enumerationGuard := NewEnumerationGuard(cfg, rateLimitStore, banStore)
+
+	shortenQuota := NewQuota(cfg, store, "shortens", "SHORTEN")
+	redirectQuota := NewQuota(cfg, store, "redirects", "REDIRECT")
+
+	restoreWindow := cfg.Duration("RESTORE_WINDOW")
+	apiKeyGrace := cfg.Duration("API_KEY_ROTATION_GRACE")
+
+	customDomains := NewDomainResolver(store)
+
+	// service backs the gRPC and GraphQL APIs and link creation of the
+	// JSON API.
+	service := &linkService{
+		links:      store,
+		clickStore: store,
+		cache:      cache,
+		codes:      codes,
+		codeConfig: codeConfig,
+		domains:    domains,
+		checker:    checker,
+		captcha:    captcha,
+		clicks:     clicks,
+		countries:  countries,
+		webhooks:   webhooks,
+		titles:     titles,
+		folders:    store,
+		audit:      store,
+		aliases:    store,
+		orgs:       store,
+		shortens:   shortenQuota,
+		redirects:  redirectQuota,
+
+		restoreWindow: restoreWindow,
+	}
+
+	r := mux.NewRouter()
+	r.Use(otelmux.Middleware(cfg.String("OTEL_SERVICE_NAME")))
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "Not Found")
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+	})
+
+	r.HandleFunc("/", IndexURLHandler()).Methods("GET")
+	r.HandleFunc("/healthz", HealthzHandler(store, replica, analytics, redisClient)).Methods("GET")
+	r.HandleFunc("/readyz", ReadyzHandler(store, replica, analytics, redisClient)).Methods("GET")
+	r.Handle("/graphql", graphqlLimiter.Middleware(DomainMiddleware(store)(GraphQLHandler(service)))).Methods("POST")
+	if cfg.Bool("SWAGGER_UI") {
+		r.HandleFunc("/docs", SwaggerUIHandler()).Methods("GET")
+	}
+
+	// DEBUG_ADDR serves the profiles and expvar variables on an internal
+	// address without authentication; unset, they are served here to the
+	// admin API key.
+	debugAddr := cfg.String("DEBUG_ADDR")
+	if debugAddr == "" {
+		r.PathPrefix("/debug/").Handler(requireAdminAPIKey(DebugHandler()))
+	}
+
+	api := r.PathPrefix(apiPrefix).Subrouter()
+	api.Use(DomainMiddleware(store))
+	api.HandleFunc("/openapi.json", OpenAPIHandler()).Methods("GET")
+	api.Handle("/shorten", requireScope(scopeLinksWrite, shortenLimiter.Middleware(ShortenURLHandler(service)))).Methods("GET", "POST")
+	api.Handle("/alias-available", aliasLimiter.Middleware(AliasAvailableHandler(store, codeConfig.Charset))).Methods("GET")
+	api.Handle("/stats", requireScope(scopeStatsRead, GetStatsHandler(reads))).Methods("GET")
+	shared := StatsShareMiddleware(reads, sessions)
+	if sessions != nil {
+		api.Handle("/stats/{code}/share", requireScope(scopeStatsRead, ShareStatsHandler(store, sessions))).Methods("POST")
+	}
+	api.Handle("/stats/{code}", shared(requireScope(scopeStatsRead, GetURLStatsHandler(reads)))).Methods("GET")
+	api.Handle("/stats/{code}/timeseries", shared(requireScope(scopeStatsRead, GetURLTimeSeriesHandler(reads, reads)))).Methods("GET")
+	api.Handle("/stats/{code}/referrers", shared(requireScope(scopeStatsRead, GetURLReferrersHandler(reads, reads)))).Methods("GET")
+	api.Handle("/stats/{code}/countries", shared(requireScope(scopeStatsRead, GetURLCountriesHandler(reads, reads)))).Methods("GET")
+	api.Handle("/stats/{code}/devices", shared(requireScope(scopeStatsRead, GetURLDevicesHandler(reads, reads)))).Methods("GET")
+	api.Handle("/stats/{code}/destinations", shared(requireScope(scopeStatsRead, GetURLDestinationsHandler(reads, reads)))).Methods("GET")
+	api.Handle("/stats/{code}/codes", shared(requireScope(scopeStatsRead, GetURLCodesHandler(reads, reads, reads)))).Methods("GET")
+	api.Handle("/stats/{code}/events", shared(requireScope(scopeStatsRead, GetURLClickEventsHandler(reads, reads)))).Methods("GET")
+	api.Handle("/stats/{code}/live", shared(requireScope(scopeStatsRead, LiveClicksHandler(reads, liveClicks)))).Methods("GET")
+	if clickhouse != nil {
+		api.Handle("/stats/{code}/analytics", shared(requireScope(scopeStatsRead, AnalyticsHandler(reads, clickhouse)))).Methods("GET")
+		api.Handle("/stats/{code}/analytics/{dimension}", shared(requireScope(scopeStatsRead, AnalyticsBreakdownHandler(reads, clickhouse)))).Methods("GET")
+	}
+	api.Handle("/get-link/{code}", enumerationGuard.Middleware(redirectLimiter.Middleware(GetURLHandler(reads, cache, checker, countries, clicks, webhooks, redirectQuota)))).Methods("GET")
+	api.Handle("/preview/{code}", enumerationGuard.Middleware(previewLimiter.Middleware(PreviewLinkHandler(store, cache)))).Methods("GET")
+	api.HandleFunc("/links", ListLinksHandler(store, store)).Methods("GET")
+	api.Handle("/links/top", requireScope(scopeStatsRead, TrendingLinksHandler(reads))).Methods("GET")
+	api.HandleFunc("/links/broken", BrokenLinksHandler(store, store)).Methods("GET")
+	api.HandleFunc("/links/search", SearchLinksHandler(store)).Methods("GET")
+	api.Handle("/links/move", requireScope(scopeLinksWrite, MoveLinksHandler(store))).Methods("POST")
+	api.HandleFunc("/tags", ListTagsHandler(reads)).Methods("GET")
+	api.HandleFunc("/folders", ListFoldersHandler(store)).Methods("GET")
+	api.Handle("/folders", requireScope(scopeLinksWrite, CreateFolderHandler(store))).Methods("POST")
+	api.Handle("/folders/{id}", requireScope(scopeLinksWrite, UpdateFolderHandler(store))).Methods("PATCH")
+	api.Handle("/folders/{id}", requireScope(scopeLinksWrite, DeleteFolderHandler(store))).Methods("DELETE")
+	api.Handle("/campaigns", requireScope(scopeLinksWrite, shortenLimiter.Middleware(CreateCampaignHandler(store, store, codes, codeConfig, shortenQuota, domains, checker, webhooks, titles)))).Methods("POST")
+	api.Handle("/routing-rules/validate", shortenLimiter.Middleware(ValidateRoutingRulesHandler(domains, checker))).Methods("POST")
+	api.Handle("/import", requireScope(scopeLinksWrite, shortenLimiter.Middleware(ImportLinksHandler(store, cache, shortenQuota, domains, checker, webhooks, titles)))).Methods("POST")
+	api.HandleFunc("/export/links", ExportLinksHandler(store)).Methods("GET")
+	api.Handle("/export/clicks", requireScope(scopeStatsRead, ExportClicksHandler(store, store))).Methods("GET")
+	api.Handle("/links/{code}", requireScope(scopeLinksWrite, UpdateLinkHandler(store, store, cache, domains, checker, titles))).Methods("PATCH")
+	api.Handle("/links/{code}", requireScope(scopeLinksWrite, DeleteLinkHandler(store, cache))).Methods("DELETE")
+	api.Handle("/links/{code}/archive", requireScope(scopeLinksWrite, ArchiveLinkHandler(store, cache))).Methods("POST")
+	api.Handle("/links/{code}/restore", requireScope(scopeLinksWrite, RestoreLinkHandler(store, cache, restoreWindow))).Methods("POST")
+	api.HandleFunc("/links/{code}/history", LinkHistoryHandler(store, store)).Methods("GET")
+	api.HandleFunc("/links/{code}/aliases", ListLinkAliasesHandler(store, store)).Methods("GET")
+	api.Handle("/links/{code}/aliases", requireScope(scopeLinksWrite, AddLinkAliasHandler(store, store, codeConfig.Charset))).Methods("POST")
+	api.Handle("/links/{code}/aliases/{alias}", requireScope(scopeLinksWrite, DeleteLinkAliasHandler(store, store))).Methods("DELETE")
+	api.HandleFunc("/audit", AuditLogHandler(store)).Methods("GET")
+	if len(identityProviders) > 0 {
+		api.HandleFunc("/auth/providers", IdentityProvidersHandler(identityProviders)).Methods("GET")
+		api.HandleFunc("/auth/{provider}/login", LoginHandler(identityProviders, store, sessions)).Methods("GET")
+		api.HandleFunc("/auth/{provider}/callback", LoginCallbackHandler(identityProviders, store, sessions)).Methods("GET")
+	}
+	api.HandleFunc("/orgs", CreateOrganizationHandler(store)).Methods("POST")
+	api.HandleFunc("/org", GetOrganizationHandler(store)).Methods("GET")
+	api.HandleFunc("/org/members", ListMembersHandler(store)).Methods("GET")
+	api.HandleFunc("/org/members", AddMemberHandler(store)).Methods("POST")
+	api.HandleFunc("/org/members/{id}", RemoveMemberHandler(store)).Methods("DELETE")
+	api.HandleFunc("/org/keys", ListAPIKeysHandler(store)).Methods("GET")
+	api.HandleFunc("/org/keys", CreateAPIKeyHandler(store)).Methods("POST")
+	api.HandleFunc("/org/keys/{id}", RevokeAPIKeyHandler(store)).Methods("DELETE")
+	api.HandleFunc("/org/keys/{id}/rotate", RotateAPIKeyHandler(store, apiKeyGrace)).Methods("POST")
+	api.HandleFunc("/org/keys/{id}/signing-secret", CreateSigningSecretHandler(store)).Methods("POST")
+	api.HandleFunc("/org/keys/{id}/signing-secret", DeleteSigningSecretHandler(store)).Methods("DELETE")
+	api.HandleFunc("/org/domains", ListDomainsHandler(store)).Methods("GET")
+	api.HandleFunc("/org/domains", CreateDomainHandler(store, customDomains)).Methods("POST")
+	api.HandleFunc("/org/domains/{id}", GetDomainHandler(store)).Methods("GET")
+	api.HandleFunc("/org/domains/{id}", DeleteDomainHandler(store, customDomains)).Methods("DELETE")
+	api.HandleFunc("/org/domains/{id}/verify", VerifyDomainHandler(store, customDomains)).Methods("POST")
+	api.HandleFunc("/usage", UsageHandler(store, shortenQuota, redirectQuota)).Methods("GET")
+	api.HandleFunc("/webhooks", ListWebhooksHandler(store)).Methods("GET")
+	api.HandleFunc("/webhooks", CreateWebhookHandler(store)).Methods("POST")
+	api.HandleFunc("/webhooks/{id}", GetWebhookHandler(store)).Methods("GET")
+	api.HandleFunc("/webhooks/{id}", UpdateWebhookHandler(store)).Methods("PATCH")
+	api.HandleFunc("/webhooks/{id}", DeleteWebhookHandler(store)).Methods("DELETE")
+	api.HandleFunc("/domain-rules", ListDomainRulesHandler(store)).Methods("GET")
+	api.HandleFunc("/domain-rules", CreateDomainRuleHandler(store)).Methods("POST")
+	api.HandleFunc("/domain-rules/{id}", DeleteDomainRuleHandler(store)).Methods("DELETE")
+	api.HandleFunc("/analytics/erase", EraseAnalyticsHandler(store, store, store)).Methods("POST")
+	api.Handle("/admin/quarantine", requireAdminAPIKey(ListQuarantinedLinksHandler(store))).Methods("GET")
+	api.Handle("/admin/quarantine/{id}/release", requireAdminAPIKey(ReleaseLinkHandler(store, cache))).Methods("POST")
+	api.Handle("/admin/quarantine/{id}/confirm", requireAdminAPIKey(ConfirmQuarantineHandler(store, cache))).Methods("POST")
+	api.Handle("/admin/links", requireAdminAPIKey(ListAllLinksHandler(store, store))).Methods("GET")
+	api.Handle("/admin/links/{id}/disable", requireAdminAPIKey(DisableLinkHandler(store, cache, webhooks))).Methods("POST")
+	api.Handle("/admin/banned-domains", requireAdminAPIKey(BanDomainHandler(store, store, cache, webhooks))).Methods("POST")
+	api.Handle("/admin/keys/activity", requireAdminAPIKey(KeyActivityHandler(store))).Methods("GET")
+	api.Handle("/admin/reports", requireAdminAPIKey(ListReportedLinksHandler(store))).Methods("GET")
+	api.Handle("/admin/reports/{id}", requireAdminAPIKey(LinkReportsHandler(store))).Methods("GET")
+	api.Handle("/admin/reports/{id}/dismiss", requireAdminAPIKey(ResolveLinkReportsHandler(store, cache, webhooks, reportDismissed))).Methods("POST")
+	api.Handle("/admin/reports/{id}/disable", requireAdminAPIKey(ResolveLinkReportsHandler(store, cache, webhooks, reportDisabled))).Methods("POST")
+	api.Handle("/admin/reports/{id}/whitelist", requireAdminAPIKey(ResolveLinkReportsHandler(store, cache, webhooks, reportWhitelisted))).Methods("POST")
+
+	// Integrations written before the API was versioned keep working: the
+	// unversioned paths redirect permanently to their /api/v1 counterparts.
+	registerLegacyAPIRoutes(r, api)
+
+	r.HandleFunc(domainVerificationPath, DomainVerificationHandler(store)).Methods("GET")
+
+	r.Handle("/o/{org}/report/{code}", enumerationGuard.Middleware(reportLimiter.Middleware(ReportLinkHandler(reads, store, store, customDomains, cache)))).Methods("POST")
+	r.Handle("/report/{code}", enumerationGuard.Middleware(reportLimiter.Middleware(ReportLinkHandler(reads, store, store, customDomains, cache)))).Methods("POST")
+	r.Handle("/o/{org}/{code}+", enumerationGuard.Middleware(redirectLimiter.Middleware(RedirectHandler(reads, store, customDomains, cache, checker, countries, clicks, webhooks, redirectQuota)))).Methods("GET", "HEAD")
+	r.Handle("/o/{org}/{code}", enumerationGuard.Middleware(redirectLimiter.Middleware(RedirectHandler(reads, store, customDomains, cache, checker, countries, clicks, webhooks, redirectQuota)))).Methods("GET", "HEAD")
+	r.Handle("/{code}+", enumerationGuard.Middleware(redirectLimiter.Middleware(RedirectHandler(reads, store, customDomains, cache, checker, countries, clicks, webhooks, redirectQuota)))).Methods("GET", "HEAD")
+	r.Handle("/{code}", enumerationGuard.Middleware(redirectLimiter.Middleware(RedirectHandler(reads, store, customDomains, cache, checker, countries, clicks, webhooks, redirectQuota)))).Methods("GET", "HEAD")
+
+	checkOpenAPIRoutes(r)
+
+	listen, err := NewListenConfig(cfg, customDomains)
+	if err != nil {
+		fatal("Invalid listen configuration", err)
+	}
+
+	server := &http.Server{
+		Handler:           RequestIDMiddleware(RecoveryMiddleware(cors.Middleware(APIKeyMiddleware(store, sessions, NewRequestVerifier(cfg, store, nonceStore), r)))),
+		ReadTimeout:       readTimeout,
+		ReadHeaderTimeout: readHeaderTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	// Live streams never finish on their own, so they are ended as soon as
+	// the drain starts.
+	server.RegisterOnShutdown(liveClicks.Close)
+
+	var debugServer *http.Server
+	if debugAddr != "" {
+		debugServer = &http.Server{
+			Addr:              debugAddr,
+			Handler:           DebugHandler(),
+			ReadHeaderTimeout: readHeaderTimeout,
+		}
+
+		go func() {
+			slog.Info("Debug server started", "addr", debugAddr)
+			if err := debugServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal("Error starting debug server", err)
+			}
+		}()
+	}
+
+	// GRPC_PORT serves the link service over gRPC as well; unset, only
+	// HTTP is served.
+	var grpcServer *grpc.Server
+	if grpcPort := cfg.String("GRPC_PORT"); grpcPort != "" {
+		listener, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			fatal("Error starting gRPC server", err)
+		}
+
+		grpcServer = NewGRPCServer(store, sessions, service)
+
+		go func() {
+			slog.Info("gRPC server started", "addr", listener.Addr().String())
+			if err := grpcServer.Serve(listener); err != nil {
+				fatal("Error starting gRPC server", err)
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		slog.Info("Server started", "addr", listen.String(), "tls", listen.TLS())
+		if err := listen.Serve(server); err != nil && err != http.ErrServerClosed {
+			fatal("Error starting server", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	shuttingDown.Store(true)
+	slog.Info("Shutting down, draining in-flight requests")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error shutting down server", "error", err)
+	}
+
+	if grpcServer != nil {
+		stopGRPCServer(shutdownCtx, grpcServer)
+	}
+
+	// Profiles may run for a while and are not worth waiting for.
+	if debugServer != nil {
+		debugServer.Close()
+	}
+
+	// Handlers may have queued clicks right up to the end of the drain, so
+	// the recorder is flushed only once no more requests can arrive.
+	clicks.Close()
+	// Scans may still emit link.quarantined.
+	scanner.Close()
+	webhooks.Close()
+	titles.Close()
+	countries.Close()
+
+	if redisClient != nil {
+		redisClient.Close()
+	}
+	if replica != nil {
+		replica.Close()
+	}
+	store.Close()
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+
+	slog.Info("Server stopped")
+}
+
+// purgeExpiredLinks fires link.expired for every purged link that had not
+// been deleted.
+func purgeExpiredLinks(links LinkStore, webhooks *WebhookDispatcher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purged, err := links.PurgeExpiredLinks(context.Background())
+		if err != nil {
+			slog.Error("Error purging expired links", "error", err)
+			continue
+		}
+
+		for _, link := range purged {
+			if link.DeletedAt == nil {
+				webhooks.Emit(link.OrgID, webhookLinkExpired, newWebhookEventData(link))
+			}
+		}
+
+		if len(purged) > 0 {
+			slog.Info("Purged expired links", "count", len(purged))
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pressly/goose/v3"
+)
+
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql migrations/mysql/*.sql
+var migrationsFS embed.FS
+
+// goMigration is a migration that SQL cannot express, such as filling in
+// a column with values computed in Go. name is the file name it takes
+// among the SQL migrations of its dialect, which sets its version there.
+type goMigration struct {
+	name string
+	up   goose.GoMigrationNoTxContext
+}
+
+// runMigrations brings the schema up to the latest embedded migration.
+func runMigrations(db *sqlx.DB, driver databaseDriver) error {
+	if err := setUpGoose(driver); err != nil {
+		return err
+	}
+
+	return goose.Up(db.DB, driver.migrationsDir)
+}
+
+// runMigrateCommand implements the migrate subcommand:
+//
+//	main migrate [up|down|status|version]
+func runMigrateCommand(db *sqlx.DB, driver databaseDriver, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	if err := setUpGoose(driver); err != nil {
+		return err
+	}
+
+	migrationsDir := driver.migrationsDir
+
+	switch command {
+	case "up":
+		return goose.Up(db.DB, migrationsDir)
+	case "down":
+		return goose.Down(db.DB, migrationsDir)
+	case "status":
+		return goose.Status(db.DB, migrationsDir)
+	case "version":
+		return goose.Version(db.DB, migrationsDir)
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down, status or version", command)
+	}
+}
+
+// setUpGoose points goose at the embedded migrations of driver. The Go
+// migrations are registered globally, so those of the dialect replace
+// any registered before.
+func setUpGoose(driver databaseDriver) error {
+	goose.SetBaseFS(migrationsFS)
+	if err := goose.SetDialect(driver.dialect); err != nil {
+		return err
+	}
+
+	goose.ResetGlobalMigrations()
+	for _, migration := range driver.goMigrations {
+		goose.AddNamedMigrationNoTxContext(migration.name, migration.up, nil)
+	}
+
+	return nil
+}
+
+// backfillLinkURLKeys fills in url_key for the links created before it
+// existed. It runs outside a transaction so that a link whose key is
+// already taken by an equivalent older link, which isURLTaken reports,
+// can be left without one: it redirects as before but is not
+// deduplicated against.
+func backfillLinkURLKeys(bindType int, isURLTaken func(error) bool) goose.GoMigrationNoTxContext {
+	return func(ctx context.Context, db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, `SELECT id, url FROM links WHERE url_key IS NULL ORDER BY id`)
+		if err != nil {
+			return err
+		}
+
+		type link struct {
+			id  int
+			url string
+		}
+
+		var links []link
+		for rows.Next() {
+			var l link
+			if err := rows.Scan(&l.id, &l.url); err != nil {
+				rows.Close()
+				return err
+			}
+			links = append(links, l)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		query := sqlx.Rebind(bindType, `UPDATE links SET url_key = ? WHERE id = ?`)
+		for _, l := range links {
+			_, err := db.ExecContext(ctx, query, canonicalURL(l.url), l.id)
+			if err != nil && !isURLTaken(err) {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,33 @@
+-- +goose Up
+-- MySQL databases start from the current schema, so the Postgres history is
+-- folded into this single migration. The binary collation keeps codes and
+-- URLs case sensitive.
+CREATE TABLE links (
+    id              INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    code            VARCHAR(64) NOT NULL,
+    url             TEXT NOT NULL,
+    created_at      DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    attempt_count   INT NOT NULL DEFAULT 0,
+    click_count     INT NOT NULL DEFAULT 0,
+    expires_at      DATETIME(6) NULL,
+    redirect_status SMALLINT NOT NULL DEFAULT 302,
+    deleted_at      DATETIME(6) NULL,
+    updated_at      DATETIME(6) NULL,
+    -- Stands in for the partial unique index on url used by Postgres.
+    url_hash        BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL, UNHEX(SHA2(url, 256)), NULL)) STORED,
+    UNIQUE KEY links_code_key (code),
+    UNIQUE KEY links_url_active_key (url_hash),
+    KEY links_expires_at_idx (expires_at)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE clicks (
+    link_id INT NOT NULL,
+    clicks  INT NOT NULL DEFAULT 0,
+    date    DATE NOT NULL,
+    PRIMARY KEY (link_id, date),
+    CONSTRAINT clicks_link_id_fkey FOREIGN KEY (link_id) REFERENCES links (id)
+);
+
+-- +goose Down
+DROP TABLE clicks;
+DROP TABLE links;
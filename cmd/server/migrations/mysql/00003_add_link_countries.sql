@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE link_countries (
+    link_id INT NOT NULL,
+    country VARCHAR(2) NOT NULL,
+    clicks  BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (link_id, country),
+    CONSTRAINT link_countries_link_id_fkey FOREIGN KEY (link_id) REFERENCES links (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +goose Down
+DROP TABLE link_countries;
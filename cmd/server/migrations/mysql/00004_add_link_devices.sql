@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE link_devices (
+    link_id     INT NOT NULL,
+    device_type VARCHAR(16) NOT NULL,
+    browser     VARCHAR(32) NOT NULL,
+    os          VARCHAR(32) NOT NULL,
+    clicks      BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (link_id, device_type, browser, os),
+    CONSTRAINT link_devices_link_id_fkey FOREIGN KEY (link_id) REFERENCES links (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +goose Down
+DROP TABLE link_devices;
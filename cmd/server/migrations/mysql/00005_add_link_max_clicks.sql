@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE links
+    ADD COLUMN max_clicks      INT NULL,
+    ADD COLUMN consumed_clicks INT NOT NULL DEFAULT 0;
+
+-- Links with a click limit are never deduplicated.
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL, UNHEX(SHA2(url, 256)), NULL)) STORED;
+
+-- +goose Down
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL, UNHEX(SHA2(url, 256)), NULL)) STORED;
+
+ALTER TABLE links
+    DROP COLUMN consumed_clicks,
+    DROP COLUMN max_clicks;
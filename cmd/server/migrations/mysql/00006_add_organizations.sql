@@ -0,0 +1,62 @@
+-- +goose Up
+CREATE TABLE organizations (
+    id         INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    slug       VARCHAR(32) NOT NULL,
+    name       VARCHAR(255) NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    UNIQUE KEY organizations_slug_key (slug)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+CREATE TABLE organization_members (
+    id         INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    org_id     INT NOT NULL,
+    email      VARCHAR(255) NOT NULL,
+    role       VARCHAR(16) NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    UNIQUE KEY organization_members_org_id_email_key (org_id, email),
+    CONSTRAINT organization_members_org_id_fkey FOREIGN KEY (org_id) REFERENCES organizations (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- Only a SHA-256 hash of each key is kept; prefix lets members tell their
+-- keys apart.
+CREATE TABLE api_keys (
+    id         INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    org_id     INT NOT NULL,
+    member_id  INT NOT NULL,
+    name       VARCHAR(64) NOT NULL,
+    prefix     VARCHAR(16) NOT NULL,
+    key_hash   CHAR(64) NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    revoked_at DATETIME(6) NULL,
+    UNIQUE KEY api_keys_key_hash_key (key_hash),
+    CONSTRAINT api_keys_org_id_fkey FOREIGN KEY (org_id) REFERENCES organizations (id),
+    CONSTRAINT api_keys_member_id_fkey FOREIGN KEY (member_id) REFERENCES organization_members (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- Links created without an organization keep org_id 0, the shared
+-- namespace. Codes and deduplicated URLs are unique per namespace.
+ALTER TABLE links
+    ADD COLUMN org_id INT NOT NULL DEFAULT 0,
+    DROP INDEX links_code_key,
+    DROP INDEX links_url_active_key,
+    ADD UNIQUE KEY links_code_key (org_id, code),
+    ADD UNIQUE KEY links_url_active_key (org_id, url_hash);
+
+-- +goose Down
+-- Codes only stay unique once organization links are gone.
+DELETE FROM clicks WHERE link_id IN (SELECT id FROM links WHERE org_id <> 0);
+DELETE FROM link_referrers WHERE link_id IN (SELECT id FROM links WHERE org_id <> 0);
+DELETE FROM link_countries WHERE link_id IN (SELECT id FROM links WHERE org_id <> 0);
+DELETE FROM link_devices WHERE link_id IN (SELECT id FROM links WHERE org_id <> 0);
+DELETE FROM links WHERE org_id <> 0;
+
+ALTER TABLE links
+    DROP INDEX links_url_active_key,
+    DROP INDEX links_code_key,
+    ADD UNIQUE KEY links_code_key (code),
+    ADD UNIQUE KEY links_url_active_key (url_hash),
+    DROP COLUMN org_id;
+
+DROP TABLE api_keys;
+DROP TABLE organization_members;
+DROP TABLE organizations;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- month is YYYY-MM in UTC.
+CREATE TABLE api_key_usage (
+    api_key_id INT NOT NULL,
+    month      CHAR(7) NOT NULL,
+    shortens   INT NOT NULL DEFAULT 0,
+    redirects  INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (api_key_id, month),
+    CONSTRAINT api_key_usage_api_key_id_fkey FOREIGN KEY (api_key_id) REFERENCES api_keys (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +goose Down
+DROP TABLE api_key_usage;
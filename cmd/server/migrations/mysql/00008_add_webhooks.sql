@@ -0,0 +1,36 @@
+-- +goose Up
+-- events is a comma separated list of event types.
+CREATE TABLE webhooks (
+    id         INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    org_id     INT NOT NULL,
+    url        TEXT NOT NULL,
+    events     VARCHAR(255) NOT NULL,
+    secret     VARCHAR(64) NOT NULL,
+    active     BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at DATETIME(6) NULL,
+    CONSTRAINT webhooks_org_id_fkey FOREIGN KEY (org_id) REFERENCES organizations (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- Deliveries are due while next_attempt_at is set. A worker claims a due
+-- delivery by setting claim_token and pushing next_attempt_at past the
+-- lease, so a crashed worker's deliveries are retried.
+CREATE TABLE webhook_deliveries (
+    id              BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    webhook_id      INT NOT NULL,
+    event           VARCHAR(32) NOT NULL,
+    payload         MEDIUMTEXT NOT NULL,
+    attempts        INT NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME(6) NULL,
+    claim_token     VARCHAR(32) NULL,
+    delivered_at    DATETIME(6) NULL,
+    last_error      TEXT NULL,
+    created_at      DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    KEY webhook_deliveries_next_attempt_at_idx (next_attempt_at),
+    KEY webhook_deliveries_claim_token_idx (claim_token),
+    CONSTRAINT webhook_deliveries_webhook_id_fkey FOREIGN KEY (webhook_id) REFERENCES webhooks (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +goose Down
+DROP TABLE webhook_deliveries;
+DROP TABLE webhooks;
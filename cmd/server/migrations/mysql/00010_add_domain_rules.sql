@@ -0,0 +1,12 @@
+-- +goose Up
+-- list is block or allow. A rule covers the domain and its subdomains.
+CREATE TABLE domain_rules (
+    id         INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    domain     VARCHAR(253) NOT NULL,
+    list       VARCHAR(5) NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    UNIQUE KEY domain_rules_domain_key (domain)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +goose Down
+DROP TABLE domain_rules;
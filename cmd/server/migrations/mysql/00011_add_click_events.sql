@@ -0,0 +1,16 @@
+-- +goose Up
+-- One row per click, alongside the daily counters. referrer_hash is the
+-- hex SHA-256 of the referrer host and is NULL for direct visits.
+CREATE TABLE click_events (
+    id            BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    link_id       INT NOT NULL,
+    clicked_at    DATETIME(6) NOT NULL,
+    country       VARCHAR(2) NOT NULL,
+    referrer_hash CHAR(64) NULL,
+    device_type   VARCHAR(16) NOT NULL,
+    KEY click_events_link_id_idx (link_id, id),
+    CONSTRAINT click_events_link_id_fkey FOREIGN KEY (link_id) REFERENCES links (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +goose Down
+DROP TABLE click_events;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- Links whose visits are not recorded in any analytics.
+ALTER TABLE links ADD COLUMN tracking_disabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE links DROP COLUMN tracking_disabled;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- ip_hash is the hex SHA-256 of the client address, so that the events of
+-- one visitor can be erased on request. clicked_at is indexed for the
+-- retention purge.
+ALTER TABLE click_events
+    ADD COLUMN ip_hash CHAR(64) NULL,
+    ADD KEY click_events_ip_hash_idx (ip_hash),
+    ADD KEY click_events_clicked_at_idx (clicked_at);
+
+-- +goose Down
+ALTER TABLE click_events
+    DROP KEY click_events_clicked_at_idx,
+    DROP KEY click_events_ip_hash_idx,
+    DROP COLUMN ip_hash;
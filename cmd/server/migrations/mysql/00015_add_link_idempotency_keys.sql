@@ -0,0 +1,11 @@
+-- +goose Up
+-- The Idempotency-Key a link was created with, so that retried shorten
+-- requests get the same link back.
+ALTER TABLE links
+    ADD COLUMN idempotency_key VARCHAR(255) NULL,
+    ADD UNIQUE KEY links_idempotency_key (org_id, idempotency_key);
+
+-- +goose Down
+ALTER TABLE links
+    DROP KEY links_idempotency_key,
+    DROP COLUMN idempotency_key;
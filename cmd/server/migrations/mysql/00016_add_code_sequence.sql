@@ -0,0 +1,11 @@
+-- +goose Up
+-- The counter sequential codes are encoded from. It holds a single row
+-- with the last value handed out.
+CREATE TABLE code_sequence (
+    value BIGINT NOT NULL
+);
+
+INSERT INTO code_sequence (value) VALUES (0);
+
+-- +goose Down
+DROP TABLE code_sequence;
@@ -0,0 +1,31 @@
+-- +goose Up
+-- Weekly and monthly sums of the daily clicks, dated by the first day of
+-- the week, a Monday, or of the month. click_rollups records the day up to
+-- which each has been computed; later clicks are summed from clicks.
+CREATE TABLE clicks_weekly (
+    link_id INT NOT NULL,
+    date    DATE NOT NULL,
+    clicks  BIGINT NOT NULL,
+    PRIMARY KEY (link_id, date),
+    CONSTRAINT clicks_weekly_link_id_fkey FOREIGN KEY (link_id) REFERENCES links (id)
+);
+
+CREATE TABLE clicks_monthly (
+    link_id INT NOT NULL,
+    date    DATE NOT NULL,
+    clicks  BIGINT NOT NULL,
+    PRIMARY KEY (link_id, date),
+    CONSTRAINT clicks_monthly_link_id_fkey FOREIGN KEY (link_id) REFERENCES links (id)
+);
+
+CREATE TABLE click_rollups (
+    granularity  VARCHAR(8) NOT NULL PRIMARY KEY,
+    rolled_up_to DATE NULL
+);
+
+INSERT INTO click_rollups (granularity) VALUES ('week'), ('month');
+
+-- +goose Down
+DROP TABLE click_rollups;
+DROP TABLE clicks_monthly;
+DROP TABLE clicks_weekly;
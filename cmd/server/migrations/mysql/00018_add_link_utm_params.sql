@@ -0,0 +1,21 @@
+-- +goose Up
+-- UTM parameters appended to the destination of a link on redirect.
+ALTER TABLE links
+    ADD COLUMN utm_source   VARCHAR(255) NULL,
+    ADD COLUMN utm_medium   VARCHAR(255) NULL,
+    ADD COLUMN utm_campaign VARCHAR(255) NULL;
+
+-- Links with UTM parameters are never deduplicated, so that a destination
+-- can have a link per campaign variant.
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL, UNHEX(SHA2(url, 256)), NULL)) STORED;
+
+-- +goose Down
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL, UNHEX(SHA2(url, 256)), NULL)) STORED;
+
+ALTER TABLE links
+    DROP COLUMN utm_campaign,
+    DROP COLUMN utm_medium,
+    DROP COLUMN utm_source;
@@ -0,0 +1,31 @@
+-- +goose Up
+-- Weighted destinations a link splits its visits between, as a JSON
+-- array. Sticky links send a visitor to the same destination every time.
+ALTER TABLE links
+    ADD COLUMN destinations        TEXT NULL,
+    ADD COLUMN sticky_destinations BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Links with destinations are never deduplicated.
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL, UNHEX(SHA2(url, 256)), NULL)) STORED;
+
+CREATE TABLE link_destination_clicks (
+    link_id        INT NOT NULL,
+    destination_id INT NOT NULL,
+    clicks         BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (link_id, destination_id),
+    CONSTRAINT link_destination_clicks_link_id_fkey FOREIGN KEY (link_id) REFERENCES links (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +goose Down
+DROP TABLE link_destination_clicks;
+
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL, UNHEX(SHA2(url, 256)), NULL)) STORED;
+
+ALTER TABLE links
+    DROP COLUMN sticky_destinations,
+    DROP COLUMN destinations;
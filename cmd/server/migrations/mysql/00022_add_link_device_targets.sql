@@ -0,0 +1,18 @@
+-- +goose Up
+-- Per-device and per-operating-system destinations of a link, as a JSON
+-- array.
+ALTER TABLE links ADD COLUMN device_targets TEXT NULL;
+
+-- Links with device targets are never deduplicated.
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL, UNHEX(SHA2(url, 256)), NULL)) STORED;
+
+-- +goose Down
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL, UNHEX(SHA2(url, 256)), NULL)) STORED;
+
+ALTER TABLE links DROP COLUMN device_targets;
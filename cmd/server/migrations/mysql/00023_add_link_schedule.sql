@@ -0,0 +1,25 @@
+-- +goose Up
+-- The window a link redirects within, and where it sends visitors outside
+-- of it.
+ALTER TABLE links
+    ADD COLUMN active_from DATETIME(6) NULL,
+    ADD COLUMN active_until DATETIME(6) NULL,
+    ADD COLUMN fallback_url TEXT NULL;
+
+-- Scheduled links are never deduplicated.
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL
+        AND active_from IS NULL AND active_until IS NULL AND fallback_url IS NULL, UNHEX(SHA2(url, 256)), NULL)) STORED;
+
+-- +goose Down
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL, UNHEX(SHA2(url, 256)), NULL)) STORED;
+
+ALTER TABLE links
+    DROP COLUMN active_from,
+    DROP COLUMN active_until,
+    DROP COLUMN fallback_url;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- What the health checker last found at the destination of a link.
+-- check_status is NULL when the destination did not respond, and
+-- broken_since is set once it has failed enough checks in a row.
+ALTER TABLE links
+    ADD COLUMN checked_at DATETIME(6) NULL,
+    ADD COLUMN check_status INT NULL,
+    ADD COLUMN check_failures INT NOT NULL DEFAULT 0,
+    ADD COLUMN broken_since DATETIME(6) NULL,
+    ADD KEY links_checked_at_idx (checked_at);
+
+-- +goose Down
+ALTER TABLE links
+    DROP KEY links_checked_at_idx,
+    DROP COLUMN checked_at,
+    DROP COLUMN check_status,
+    DROP COLUMN check_failures,
+    DROP COLUMN broken_since;
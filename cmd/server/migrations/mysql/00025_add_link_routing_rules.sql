@@ -0,0 +1,20 @@
+-- +goose Up
+-- An ordered list of rules that send matching visits elsewhere, as JSON.
+ALTER TABLE links ADD COLUMN routing_rules TEXT NULL;
+
+-- Links with routing rules are never deduplicated.
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL
+        AND active_from IS NULL AND active_until IS NULL AND fallback_url IS NULL
+        AND routing_rules IS NULL, UNHEX(SHA2(url, 256)), NULL)) STORED;
+
+-- +goose Down
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL
+        AND active_from IS NULL AND active_until IS NULL AND fallback_url IS NULL, UNHEX(SHA2(url, 256)), NULL)) STORED;
+
+ALTER TABLE links DROP COLUMN routing_rules;
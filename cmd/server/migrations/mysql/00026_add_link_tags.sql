@@ -0,0 +1,12 @@
+-- +goose Up
+-- Tags group the links of a campaign that spans many of them.
+CREATE TABLE link_tags (
+    link_id INT NOT NULL,
+    tag     VARCHAR(64) NOT NULL,
+    PRIMARY KEY (link_id, tag),
+    KEY link_tags_tag_idx (tag, link_id),
+    CONSTRAINT link_tags_link_id_fkey FOREIGN KEY (link_id) REFERENCES links (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +goose Down
+DROP TABLE link_tags;
@@ -0,0 +1,26 @@
+-- +goose Up
+-- Folders nest under parent_id within a namespace, org_id 0 being the
+-- shared one. A link is filed in at most one folder.
+CREATE TABLE folders (
+    id         INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    org_id     INT NOT NULL,
+    parent_id  INT NULL,
+    name       VARCHAR(64) NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at DATETIME(6) NULL,
+    KEY folders_org_id_parent_id_idx (org_id, parent_id),
+    CONSTRAINT folders_parent_id_fkey FOREIGN KEY (parent_id) REFERENCES folders (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+ALTER TABLE links
+    ADD COLUMN folder_id INT NULL,
+    ADD KEY links_folder_id_idx (folder_id),
+    ADD CONSTRAINT links_folder_id_fkey FOREIGN KEY (folder_id) REFERENCES folders (id);
+
+-- +goose Down
+ALTER TABLE links
+    DROP FOREIGN KEY links_folder_id_fkey,
+    DROP KEY links_folder_id_idx,
+    DROP COLUMN folder_id;
+
+DROP TABLE folders;
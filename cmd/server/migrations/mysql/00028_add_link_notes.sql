@@ -0,0 +1,9 @@
+-- +goose Up
+-- What a link is for, and notes the team keeps about it. Neither is shown
+-- to visitors.
+ALTER TABLE links ADD COLUMN description TEXT NULL;
+ALTER TABLE links ADD COLUMN notes TEXT NULL;
+
+-- +goose Down
+ALTER TABLE links DROP COLUMN notes;
+ALTER TABLE links DROP COLUMN description;
@@ -0,0 +1,22 @@
+-- +goose Up
+-- Archived links stop redirecting but keep their stats until restored.
+ALTER TABLE links ADD COLUMN archived_at DATETIME(6) NULL;
+
+-- Archived links are never deduplicated, so their URL can be shortened
+-- anew.
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL
+        AND active_from IS NULL AND active_until IS NULL AND fallback_url IS NULL
+        AND routing_rules IS NULL AND archived_at IS NULL, UNHEX(SHA2(url, 256)), NULL)) STORED;
+
+-- +goose Down
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL
+        AND active_from IS NULL AND active_until IS NULL AND fallback_url IS NULL
+        AND routing_rules IS NULL, UNHEX(SHA2(url, 256)), NULL)) STORED;
+
+ALTER TABLE links DROP COLUMN archived_at;
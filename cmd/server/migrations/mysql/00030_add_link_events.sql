@@ -0,0 +1,19 @@
+-- +goose Up
+-- Every change made to a link, with the audited fields it changed. Events
+-- outlive the link they describe, so link_id has no foreign key.
+CREATE TABLE link_events (
+    id         BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    org_id     INT NOT NULL,
+    link_id    INT NOT NULL,
+    code       VARCHAR(64) NOT NULL,
+    action     VARCHAR(16) NOT NULL,
+    member_id  INT NULL,
+    key_id     INT NULL,
+    changes    MEDIUMTEXT NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    KEY link_events_link_id_idx (link_id, id),
+    KEY link_events_created_at_idx (created_at)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +goose Down
+DROP TABLE link_events;
@@ -0,0 +1,27 @@
+-- +goose Up
+-- Further codes a link answers to besides its own. Codes are unique
+-- across links and aliases within a namespace.
+CREATE TABLE link_aliases (
+    id         INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    org_id     INT NOT NULL,
+    link_id    INT NOT NULL,
+    code       VARCHAR(64) NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    UNIQUE KEY link_aliases_org_id_code_key (org_id, code),
+    KEY link_aliases_link_id_idx (link_id),
+    CONSTRAINT link_aliases_link_id_fkey FOREIGN KEY (link_id) REFERENCES links (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- Clicks of a link per code it was visited under.
+CREATE TABLE link_code_clicks (
+    link_id INT NOT NULL,
+    code    VARCHAR(64) NOT NULL,
+    clicks  BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (link_id, code),
+    CONSTRAINT link_code_clicks_link_id_fkey FOREIGN KEY (link_id) REFERENCES links (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +goose Down
+DROP TABLE link_code_clicks;
+
+DROP TABLE link_aliases;
@@ -0,0 +1,32 @@
+-- +goose Up
+-- The API key that created a link, 0 for links created without one. A URL
+-- is only deduplicated against the links of the same key, and never for
+-- links minted with force_new.
+ALTER TABLE links
+    ADD COLUMN key_id INT NOT NULL DEFAULT 0,
+    ADD COLUMN force_new BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL
+        AND active_from IS NULL AND active_until IS NULL AND fallback_url IS NULL
+        AND routing_rules IS NULL AND archived_at IS NULL AND NOT force_new, UNHEX(SHA2(url, 256)), NULL)) STORED,
+    DROP INDEX links_url_active_key,
+    ADD UNIQUE KEY links_url_active_key (org_id, key_id, url_hash);
+
+-- +goose Down
+ALTER TABLE links
+    DROP INDEX links_url_active_key,
+    ADD UNIQUE KEY links_url_active_key (org_id, url_hash);
+
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL
+        AND active_from IS NULL AND active_until IS NULL AND fallback_url IS NULL
+        AND routing_rules IS NULL AND archived_at IS NULL, UNHEX(SHA2(url, 256)), NULL)) STORED;
+
+ALTER TABLE links
+    DROP COLUMN force_new,
+    DROP COLUMN key_id;
@@ -0,0 +1,30 @@
+-- +goose Up
+-- Hostnames organizations serve their links on besides SHORT_DOMAINS. A
+-- domain only serves links once verified_at shows the organization
+-- controls it.
+CREATE TABLE domains (
+    id                 INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    org_id             INT NOT NULL,
+    hostname           VARCHAR(253) NOT NULL,
+    verification_token VARCHAR(64) NOT NULL,
+    verified_at        DATETIME(6) NULL,
+    created_at         DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    UNIQUE KEY domains_hostname_key (hostname),
+    KEY domains_org_id_idx (org_id),
+    CONSTRAINT domains_org_id_fkey FOREIGN KEY (org_id) REFERENCES organizations (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- The domain a link was issued under, 0 for SHORT_DOMAINS. A URL is only
+-- deduplicated against the links issued under the same domain.
+ALTER TABLE links
+    ADD COLUMN domain_id INT NOT NULL DEFAULT 0,
+    DROP INDEX links_url_active_key,
+    ADD UNIQUE KEY links_url_active_key (org_id, domain_id, key_id, url_hash);
+
+-- +goose Down
+ALTER TABLE links
+    DROP INDEX links_url_active_key,
+    ADD UNIQUE KEY links_url_active_key (org_id, key_id, url_hash),
+    DROP COLUMN domain_id;
+
+DROP TABLE domains;
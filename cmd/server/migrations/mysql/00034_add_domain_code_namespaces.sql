@@ -0,0 +1,28 @@
+-- +goose Up
+-- Every domain of an organization has a code space of its own, so a code
+-- taken on one domain is still free on the others. Aliases live in the
+-- code space of the domain of their link.
+ALTER TABLE link_aliases
+    ADD COLUMN domain_id INT NOT NULL DEFAULT 0 AFTER org_id;
+
+UPDATE link_aliases a
+JOIN links l ON l.id = a.link_id
+SET a.domain_id = l.domain_id;
+
+ALTER TABLE link_aliases
+    DROP INDEX link_aliases_org_id_code_key,
+    ADD UNIQUE KEY link_aliases_org_id_domain_id_code_key (org_id, domain_id, code);
+
+ALTER TABLE links
+    DROP INDEX links_code_key,
+    ADD UNIQUE KEY links_code_key (org_id, domain_id, code);
+
+-- +goose Down
+ALTER TABLE links
+    DROP INDEX links_code_key,
+    ADD UNIQUE KEY links_code_key (org_id, code);
+
+ALTER TABLE link_aliases
+    DROP INDEX link_aliases_org_id_domain_id_code_key,
+    ADD UNIQUE KEY link_aliases_org_id_code_key (org_id, code),
+    DROP COLUMN domain_id;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- Links a destination scanner flagged stay quarantined until an admin
+-- releases them. quarantine_reviewed_at is set when an admin confirms the
+-- quarantine instead, which takes the link off the review queue.
+ALTER TABLE links
+    ADD COLUMN quarantined_at DATETIME(6) NULL,
+    ADD COLUMN quarantine_reason TEXT NULL,
+    ADD COLUMN quarantine_reviewed_at DATETIME(6) NULL,
+    ADD KEY links_quarantined_at_idx (quarantined_at);
+
+-- +goose Down
+ALTER TABLE links
+    DROP KEY links_quarantined_at_idx,
+    DROP COLUMN quarantined_at,
+    DROP COLUMN quarantine_reason,
+    DROP COLUMN quarantine_reviewed_at;
@@ -0,0 +1,26 @@
+-- +goose Up
+-- Reports of links visitors consider malicious. resolution is set to
+-- dismissed, disabled or whitelisted once an admin dealt with a report.
+-- reporter_hash is the hashed address of the reporter, so that a visitor
+-- counts once per link. Links whose reports were whitelisted collect no
+-- further reports.
+CREATE TABLE link_reports (
+    id            INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    link_id       INT NOT NULL,
+    reason        VARCHAR(32) NOT NULL,
+    details       TEXT NULL,
+    email         VARCHAR(320) NULL,
+    reporter_hash VARCHAR(64) NULL,
+    created_at    DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    resolved_at   DATETIME(6) NULL,
+    resolution    VARCHAR(32) NULL,
+    KEY link_reports_link_id_idx (link_id, resolved_at),
+    CONSTRAINT link_reports_link_id_fkey FOREIGN KEY (link_id) REFERENCES links (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+ALTER TABLE links ADD COLUMN reports_whitelisted_at DATETIME(6) NULL;
+
+-- +goose Down
+ALTER TABLE links DROP COLUMN reports_whitelisted_at;
+
+DROP TABLE link_reports;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- scopes is a JSON array of what a key may do; keys issued before scopes
+-- existed have none stored and may do everything. A rotated key stays
+-- valid until its expires_at and names the key that replaced it.
+ALTER TABLE api_keys
+    ADD COLUMN scopes TEXT NULL,
+    ADD COLUMN expires_at DATETIME(6) NULL,
+    ADD COLUMN last_used_at DATETIME(6) NULL,
+    ADD COLUMN replaced_by INT NULL;
+
+-- +goose Down
+ALTER TABLE api_keys
+    DROP COLUMN scopes,
+    DROP COLUMN expires_at,
+    DROP COLUMN last_used_at,
+    DROP COLUMN replaced_by;
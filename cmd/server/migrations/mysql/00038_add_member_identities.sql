@@ -0,0 +1,18 @@
+-- +goose Up
+-- Accounts of external identity providers members logged in with. subject
+-- is the identifier the provider gives the account; a member has at most
+-- one account per provider.
+CREATE TABLE member_identities (
+    id            INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    member_id     INT NOT NULL,
+    provider      VARCHAR(32) NOT NULL,
+    subject       VARCHAR(255) NOT NULL,
+    created_at    DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    last_login_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    UNIQUE KEY member_identities_member_id_key (member_id, provider),
+    KEY member_identities_subject_idx (provider, subject),
+    CONSTRAINT member_identities_member_id_fkey FOREIGN KEY (member_id) REFERENCES organization_members (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +goose Down
+DROP TABLE member_identities;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- Usage of members acting with a session token, which has no API key to
+-- count against. month is YYYY-MM in UTC.
+CREATE TABLE member_usage (
+    member_id INT NOT NULL,
+    month     CHAR(7) NOT NULL,
+    shortens  INT NOT NULL DEFAULT 0,
+    redirects INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (member_id, month),
+    CONSTRAINT member_usage_member_id_fkey FOREIGN KEY (member_id) REFERENCES organization_members (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +goose Down
+DROP TABLE member_usage;
@@ -0,0 +1,22 @@
+-- +goose Up
+-- A hostname is only unique among verified domains, so that an
+-- organization claiming a domain it does not control cannot keep its
+-- owner from registering it. Every organization claims a hostname once.
+-- verified_hostname is NULL for pending claims, which the unique key
+-- does not compare.
+ALTER TABLE domains
+    ADD COLUMN verified_hostname VARCHAR(253) AS (IF(verified_at IS NULL, NULL, hostname)) STORED,
+    DROP INDEX domains_hostname_key,
+    ADD UNIQUE KEY domains_org_hostname_key (org_id, hostname),
+    ADD UNIQUE KEY domains_verified_hostname_key (verified_hostname);
+
+-- +goose Down
+DELETE d FROM domains d
+JOIN domains o ON o.hostname = d.hostname AND o.id <> d.id AND (o.verified_at IS NOT NULL OR o.id < d.id)
+WHERE d.verified_at IS NULL;
+
+ALTER TABLE domains
+    DROP INDEX domains_verified_hostname_key,
+    DROP INDEX domains_org_hostname_key,
+    DROP COLUMN verified_hostname,
+    ADD UNIQUE KEY domains_hostname_key (hostname);
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Whether a link event was made with the admin key, such as the
+-- moderation of a link, which no member or API key of its organization
+-- made.
+ALTER TABLE link_events ADD COLUMN admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE link_events DROP COLUMN admin;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- The canonical form of a link's URL, which links are deduplicated by
+-- while url keeps the destination as it was submitted. The keys of
+-- existing links are filled in by the next migration, which computes
+-- them in Go.
+ALTER TABLE links ADD COLUMN url_key TEXT NULL;
+
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL
+        AND active_from IS NULL AND active_until IS NULL AND fallback_url IS NULL
+        AND routing_rules IS NULL AND archived_at IS NULL AND NOT force_new, UNHEX(SHA2(url_key, 256)), NULL)) STORED;
+
+-- +goose Down
+ALTER TABLE links
+    MODIFY COLUMN url_hash BINARY(32) AS (IF(expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL
+        AND active_from IS NULL AND active_until IS NULL AND fallback_url IS NULL
+        AND routing_rules IS NULL AND archived_at IS NULL AND NOT force_new, UNHEX(SHA2(url, 256)), NULL)) STORED;
+
+ALTER TABLE links DROP COLUMN url_key;
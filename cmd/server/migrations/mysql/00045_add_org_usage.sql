@@ -0,0 +1,14 @@
+-- +goose Up
+-- Redirects of the links of an organization, whose visitors have no API
+-- key or session to count against. month is YYYY-MM in UTC.
+CREATE TABLE org_usage (
+    org_id    INT NOT NULL,
+    month     CHAR(7) NOT NULL,
+    shortens  INT NOT NULL DEFAULT 0,
+    redirects INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (org_id, month),
+    CONSTRAINT org_usage_org_id_fkey FOREIGN KEY (org_id) REFERENCES organizations (id)
+) DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_bin;
+
+-- +goose Down
+DROP TABLE org_usage;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- member_id is the member whose session created a link, 0 for links
+-- created with an API key or without a caller. Idempotency keys are unique
+-- per API key or member rather than per organization, and
+-- idempotency_hash fingerprints the request that used one, so that another
+-- request reusing the key is told apart from a retry.
+ALTER TABLE links
+    ADD COLUMN member_id INT NOT NULL DEFAULT 0,
+    ADD COLUMN idempotency_hash CHAR(64) NULL,
+    DROP KEY links_idempotency_key,
+    ADD UNIQUE KEY links_idempotency_key (org_id, key_id, member_id, idempotency_key);
+
+-- +goose Down
+ALTER TABLE links
+    DROP KEY links_idempotency_key,
+    ADD UNIQUE KEY links_idempotency_key (org_id, idempotency_key),
+    DROP COLUMN idempotency_hash,
+    DROP COLUMN member_id;
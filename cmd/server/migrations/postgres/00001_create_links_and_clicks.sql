@@ -0,0 +1,22 @@
+-- +goose Up
+-- The original tables predate migrations, so they are only created when an
+-- existing deployment does not have them yet.
+CREATE TABLE IF NOT EXISTS links (
+    id            SERIAL PRIMARY KEY,
+    code          VARCHAR(64) NOT NULL UNIQUE,
+    url           TEXT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    attempt_count INTEGER NOT NULL DEFAULT 0,
+    click_count   INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS clicks (
+    link_id INTEGER NOT NULL REFERENCES links (id),
+    clicks  INTEGER NOT NULL DEFAULT 0,
+    date    DATE NOT NULL,
+    PRIMARY KEY (link_id, date)
+);
+
+-- +goose Down
+DROP TABLE clicks;
+DROP TABLE links;
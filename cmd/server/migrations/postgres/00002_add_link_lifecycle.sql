@@ -0,0 +1,26 @@
+-- +goose Up
+ALTER TABLE links
+    ADD COLUMN IF NOT EXISTS expires_at      TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS redirect_status SMALLINT NOT NULL DEFAULT 302,
+    ADD COLUMN IF NOT EXISTS deleted_at      TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS updated_at      TIMESTAMPTZ;
+
+-- Shortening deduplicates permanent links against this index. Databases
+-- that already hold duplicate URLs must be cleaned up before it can build.
+CREATE UNIQUE INDEX IF NOT EXISTS links_url_active_key
+    ON links (url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS links_expires_at_idx
+    ON links (expires_at)
+    WHERE expires_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX links_expires_at_idx;
+DROP INDEX links_url_active_key;
+
+ALTER TABLE links
+    DROP COLUMN updated_at,
+    DROP COLUMN deleted_at,
+    DROP COLUMN redirect_status,
+    DROP COLUMN expires_at;
@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE link_devices (
+    link_id     INTEGER NOT NULL REFERENCES links (id),
+    device_type VARCHAR(16) NOT NULL,
+    browser     VARCHAR(32) NOT NULL,
+    os          VARCHAR(32) NOT NULL,
+    clicks      BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (link_id, device_type, browser, os)
+);
+
+-- +goose Down
+DROP TABLE link_devices;
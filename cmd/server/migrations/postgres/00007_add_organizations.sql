@@ -0,0 +1,63 @@
+-- +goose Up
+CREATE TABLE organizations (
+    id         SERIAL PRIMARY KEY,
+    slug       VARCHAR(32) NOT NULL UNIQUE,
+    name       VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE organization_members (
+    id         SERIAL PRIMARY KEY,
+    org_id     INTEGER NOT NULL REFERENCES organizations (id),
+    email      VARCHAR(255) NOT NULL,
+    role       VARCHAR(16) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (org_id, email)
+);
+
+-- Only a SHA-256 hash of each key is kept; prefix lets members tell their
+-- keys apart.
+CREATE TABLE api_keys (
+    id         SERIAL PRIMARY KEY,
+    org_id     INTEGER NOT NULL REFERENCES organizations (id),
+    member_id  INTEGER NOT NULL REFERENCES organization_members (id),
+    name       VARCHAR(64) NOT NULL,
+    prefix     VARCHAR(16) NOT NULL,
+    key_hash   CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMPTZ
+);
+
+-- Links created without an organization keep org_id 0, the shared
+-- namespace. Codes and deduplicated URLs are unique per namespace.
+ALTER TABLE links ADD COLUMN org_id INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE links DROP CONSTRAINT links_code_key;
+CREATE UNIQUE INDEX links_code_key ON links (org_id, code);
+
+DROP INDEX links_url_active_key;
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (org_id, url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL;
+
+-- +goose Down
+-- Codes only stay unique once organization links are gone.
+DELETE FROM clicks WHERE link_id IN (SELECT id FROM links WHERE org_id <> 0);
+DELETE FROM link_referrers WHERE link_id IN (SELECT id FROM links WHERE org_id <> 0);
+DELETE FROM link_countries WHERE link_id IN (SELECT id FROM links WHERE org_id <> 0);
+DELETE FROM link_devices WHERE link_id IN (SELECT id FROM links WHERE org_id <> 0);
+DELETE FROM links WHERE org_id <> 0;
+
+DROP INDEX links_url_active_key;
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL;
+
+DROP INDEX links_code_key;
+ALTER TABLE links ADD CONSTRAINT links_code_key UNIQUE (code);
+
+ALTER TABLE links DROP COLUMN org_id;
+
+DROP TABLE api_keys;
+DROP TABLE organization_members;
+DROP TABLE organizations;
@@ -0,0 +1,40 @@
+-- +goose Up
+-- events is a comma separated list of event types.
+CREATE TABLE webhooks (
+    id         SERIAL PRIMARY KEY,
+    org_id     INTEGER NOT NULL REFERENCES organizations (id),
+    url        TEXT NOT NULL,
+    events     VARCHAR(255) NOT NULL,
+    secret     VARCHAR(64) NOT NULL,
+    active     BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ
+);
+
+-- Deliveries are due while next_attempt_at is set. A worker claims a due
+-- delivery by setting claim_token and pushing next_attempt_at past the
+-- lease, so a crashed worker's deliveries are retried.
+CREATE TABLE webhook_deliveries (
+    id              BIGSERIAL PRIMARY KEY,
+    webhook_id      INTEGER NOT NULL REFERENCES webhooks (id),
+    event           VARCHAR(32) NOT NULL,
+    payload         TEXT NOT NULL,
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ,
+    claim_token     VARCHAR(32),
+    delivered_at    TIMESTAMPTZ,
+    last_error      TEXT,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX webhook_deliveries_next_attempt_at_idx
+    ON webhook_deliveries (next_attempt_at)
+    WHERE next_attempt_at IS NOT NULL;
+
+CREATE INDEX webhook_deliveries_claim_token_idx
+    ON webhook_deliveries (claim_token)
+    WHERE claim_token IS NOT NULL;
+
+-- +goose Down
+DROP TABLE webhook_deliveries;
+DROP TABLE webhooks;
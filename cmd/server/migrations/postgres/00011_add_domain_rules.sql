@@ -0,0 +1,11 @@
+-- +goose Up
+-- list is block or allow. A rule covers the domain and its subdomains.
+CREATE TABLE domain_rules (
+    id         SERIAL PRIMARY KEY,
+    domain     VARCHAR(253) NOT NULL UNIQUE,
+    list       VARCHAR(5) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE domain_rules;
@@ -0,0 +1,122 @@
+-- +goose Up
+-- Partitions the daily clicks and the click events by month (UTC), so
+-- that queries and purges of a time range only touch the months in it.
+-- Partitions are created here for the months that have clicks and by the
+-- server for the current and the next month; anything else lands in the
+-- default partitions. The rows are copied over, which holds a lock on the
+-- click tables until the migration is done.
+CREATE TABLE clicks_partitioned (
+    link_id INTEGER NOT NULL REFERENCES links (id),
+    clicks  INTEGER NOT NULL DEFAULT 0,
+    date    DATE NOT NULL,
+    PRIMARY KEY (link_id, date)
+) PARTITION BY RANGE (date);
+
+CREATE TABLE clicks_default PARTITION OF clicks_partitioned DEFAULT;
+
+-- The primary key has to include the partition key.
+CREATE TABLE click_events_partitioned (
+    id            BIGINT NOT NULL,
+    link_id       INTEGER NOT NULL REFERENCES links (id),
+    clicked_at    TIMESTAMPTZ NOT NULL,
+    country       VARCHAR(2) NOT NULL,
+    referrer_hash CHAR(64),
+    device_type   VARCHAR(16) NOT NULL,
+    ip_hash       CHAR(64),
+    PRIMARY KEY (id, clicked_at)
+) PARTITION BY RANGE (clicked_at);
+
+CREATE TABLE click_events_default PARTITION OF click_events_partitioned DEFAULT;
+
+-- +goose StatementBegin
+DO $$
+DECLARE
+    first_day DATE;
+BEGIN
+    FOR first_day IN
+        SELECT date_trunc('month', date)::date FROM clicks
+        UNION
+        SELECT date_trunc('month', clicked_at AT TIME ZONE 'UTC')::date FROM click_events
+        UNION
+        SELECT date_trunc('month', NOW() AT TIME ZONE 'UTC')::date
+        UNION
+        SELECT (date_trunc('month', NOW() AT TIME ZONE 'UTC') + INTERVAL '1 month')::date
+    LOOP
+        EXECUTE format(
+            'CREATE TABLE clicks_p%s PARTITION OF clicks_partitioned FOR VALUES FROM (%L) TO (%L)',
+            to_char(first_day, 'YYYYMM'), first_day, (first_day + INTERVAL '1 month')::date
+        );
+        EXECUTE format(
+            'CREATE TABLE click_events_p%s PARTITION OF click_events_partitioned FOR VALUES FROM (%L) TO (%L)',
+            to_char(first_day, 'YYYYMM'), first_day::timestamp AT TIME ZONE 'UTC', (first_day + INTERVAL '1 month') AT TIME ZONE 'UTC'
+        );
+    END LOOP;
+END
+$$;
+-- +goose StatementEnd
+
+INSERT INTO clicks_partitioned (link_id, clicks, date)
+SELECT link_id, clicks, date FROM clicks;
+
+INSERT INTO click_events_partitioned (id, link_id, clicked_at, country, referrer_hash, device_type, ip_hash)
+SELECT id, link_id, clicked_at, country, referrer_hash, device_type, ip_hash FROM click_events;
+
+-- The event ids keep counting from where they were.
+ALTER SEQUENCE click_events_id_seq OWNED BY click_events_partitioned.id;
+ALTER TABLE click_events_partitioned ALTER COLUMN id SET DEFAULT nextval('click_events_id_seq');
+
+DROP TABLE clicks;
+DROP TABLE click_events;
+
+ALTER TABLE clicks_partitioned RENAME TO clicks;
+ALTER TABLE clicks RENAME CONSTRAINT clicks_partitioned_pkey TO clicks_pkey;
+ALTER TABLE clicks RENAME CONSTRAINT clicks_partitioned_link_id_fkey TO clicks_link_id_fkey;
+
+ALTER TABLE click_events_partitioned RENAME TO click_events;
+ALTER TABLE click_events RENAME CONSTRAINT click_events_partitioned_pkey TO click_events_pkey;
+ALTER TABLE click_events RENAME CONSTRAINT click_events_partitioned_link_id_fkey TO click_events_link_id_fkey;
+
+CREATE INDEX click_events_link_id_idx ON click_events (link_id, id);
+CREATE INDEX click_events_ip_hash_idx ON click_events (ip_hash);
+CREATE INDEX click_events_clicked_at_idx ON click_events (clicked_at);
+
+-- +goose Down
+CREATE TABLE clicks_unpartitioned (
+    link_id INTEGER NOT NULL REFERENCES links (id),
+    clicks  INTEGER NOT NULL DEFAULT 0,
+    date    DATE NOT NULL,
+    PRIMARY KEY (link_id, date)
+);
+
+CREATE TABLE click_events_unpartitioned (
+    id            BIGINT PRIMARY KEY DEFAULT nextval('click_events_id_seq'),
+    link_id       INTEGER NOT NULL REFERENCES links (id),
+    clicked_at    TIMESTAMPTZ NOT NULL,
+    country       VARCHAR(2) NOT NULL,
+    referrer_hash CHAR(64),
+    device_type   VARCHAR(16) NOT NULL,
+    ip_hash       CHAR(64)
+);
+
+INSERT INTO clicks_unpartitioned (link_id, clicks, date)
+SELECT link_id, clicks, date FROM clicks;
+
+INSERT INTO click_events_unpartitioned (id, link_id, clicked_at, country, referrer_hash, device_type, ip_hash)
+SELECT id, link_id, clicked_at, country, referrer_hash, device_type, ip_hash FROM click_events;
+
+ALTER SEQUENCE click_events_id_seq OWNED BY click_events_unpartitioned.id;
+
+DROP TABLE clicks;
+DROP TABLE click_events;
+
+ALTER TABLE clicks_unpartitioned RENAME TO clicks;
+ALTER TABLE clicks RENAME CONSTRAINT clicks_unpartitioned_pkey TO clicks_pkey;
+ALTER TABLE clicks RENAME CONSTRAINT clicks_unpartitioned_link_id_fkey TO clicks_link_id_fkey;
+
+ALTER TABLE click_events_unpartitioned RENAME TO click_events;
+ALTER TABLE click_events RENAME CONSTRAINT click_events_unpartitioned_pkey TO click_events_pkey;
+ALTER TABLE click_events RENAME CONSTRAINT click_events_unpartitioned_link_id_fkey TO click_events_link_id_fkey;
+
+CREATE INDEX click_events_link_id_idx ON click_events (link_id, id);
+CREATE INDEX click_events_ip_hash_idx ON click_events (ip_hash);
+CREATE INDEX click_events_clicked_at_idx ON click_events (clicked_at);
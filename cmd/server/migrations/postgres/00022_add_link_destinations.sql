@@ -0,0 +1,34 @@
+-- +goose Up
+-- Weighted destinations a link splits its visits between, as a JSON
+-- array. Sticky links send a visitor to the same destination every time.
+ALTER TABLE links
+    ADD COLUMN destinations        TEXT,
+    ADD COLUMN sticky_destinations BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Links with destinations are never deduplicated.
+DROP INDEX links_url_active_key;
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (org_id, url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL;
+
+CREATE TABLE link_destination_clicks (
+    link_id        INTEGER NOT NULL REFERENCES links (id),
+    destination_id INTEGER NOT NULL,
+    clicks         BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (link_id, destination_id)
+);
+
+-- +goose Down
+DROP TABLE link_destination_clicks;
+
+DROP INDEX links_url_active_key;
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (org_id, url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL;
+
+ALTER TABLE links
+    DROP COLUMN sticky_destinations,
+    DROP COLUMN destinations;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- Per-country and per-continent destinations of a link, as a JSON array.
+ALTER TABLE links ADD COLUMN geo_targets TEXT;
+
+-- Links with geo targets are never deduplicated.
+DROP INDEX links_url_active_key;
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (org_id, url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL;
+
+-- +goose Down
+DROP INDEX links_url_active_key;
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (org_id, url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL;
+
+ALTER TABLE links DROP COLUMN geo_targets;
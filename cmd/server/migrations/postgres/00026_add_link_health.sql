@@ -0,0 +1,22 @@
+-- +goose Up
+-- What the health checker last found at the destination of a link.
+-- check_status is NULL when the destination did not respond, and
+-- broken_since is set once it has failed enough checks in a row.
+ALTER TABLE links
+    ADD COLUMN IF NOT EXISTS checked_at     TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS check_status   INTEGER,
+    ADD COLUMN IF NOT EXISTS check_failures INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS broken_since   TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS links_checked_at_idx
+    ON links (checked_at NULLS FIRST)
+    WHERE deleted_at IS NULL;
+
+-- +goose Down
+DROP INDEX links_checked_at_idx;
+
+ALTER TABLE links
+    DROP COLUMN checked_at,
+    DROP COLUMN check_status,
+    DROP COLUMN check_failures,
+    DROP COLUMN broken_since;
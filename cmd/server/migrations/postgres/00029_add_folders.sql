@@ -0,0 +1,24 @@
+-- +goose Up
+-- Folders nest under parent_id within a namespace, org_id 0 being the
+-- shared one. A link is filed in at most one folder.
+CREATE TABLE IF NOT EXISTS folders (
+    id         SERIAL PRIMARY KEY,
+    org_id     INTEGER NOT NULL,
+    parent_id  INTEGER REFERENCES folders (id),
+    name       VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS folders_org_id_parent_id_idx ON folders (org_id, parent_id);
+
+ALTER TABLE links ADD COLUMN IF NOT EXISTS folder_id INTEGER REFERENCES folders (id);
+
+CREATE INDEX IF NOT EXISTS links_folder_id_idx ON links (folder_id);
+
+-- +goose Down
+DROP INDEX links_folder_id_idx;
+
+ALTER TABLE links DROP COLUMN folder_id;
+
+DROP TABLE folders;
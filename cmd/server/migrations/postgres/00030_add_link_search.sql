@@ -0,0 +1,21 @@
+-- +goose Up
+-- Trigram indexes let link search match part of a code, URL, title or tag
+-- without scanning every link.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS links_code_trgm_idx ON links USING GIN (code gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS links_url_trgm_idx ON links USING GIN (url gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS links_title_trgm_idx ON links USING GIN (title gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS link_tags_tag_trgm_idx ON link_tags USING GIN (tag gin_trgm_ops);
+
+-- +goose Down
+DROP INDEX link_tags_tag_trgm_idx;
+
+DROP INDEX links_title_trgm_idx;
+
+DROP INDEX links_url_trgm_idx;
+
+DROP INDEX links_code_trgm_idx;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- What a link is for, and notes the team keeps about it. Neither is shown
+-- to visitors. Both are searched like titles.
+ALTER TABLE links ADD COLUMN IF NOT EXISTS description TEXT;
+ALTER TABLE links ADD COLUMN IF NOT EXISTS notes TEXT;
+
+CREATE INDEX IF NOT EXISTS links_description_trgm_idx ON links USING GIN (description gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS links_notes_trgm_idx ON links USING GIN (notes gin_trgm_ops);
+
+-- +goose Down
+DROP INDEX links_notes_trgm_idx;
+
+DROP INDEX links_description_trgm_idx;
+
+ALTER TABLE links DROP COLUMN notes;
+ALTER TABLE links DROP COLUMN description;
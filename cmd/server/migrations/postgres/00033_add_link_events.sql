@@ -0,0 +1,20 @@
+-- +goose Up
+-- Every change made to a link, with the audited fields it changed. Events
+-- outlive the link they describe, so link_id has no foreign key.
+CREATE TABLE IF NOT EXISTS link_events (
+    id         BIGSERIAL PRIMARY KEY,
+    org_id     INTEGER NOT NULL,
+    link_id    INTEGER NOT NULL,
+    code       VARCHAR(64) NOT NULL,
+    action     VARCHAR(16) NOT NULL,
+    member_id  INTEGER,
+    key_id     INTEGER,
+    changes    TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS link_events_link_id_idx ON link_events (link_id, id);
+CREATE INDEX IF NOT EXISTS link_events_created_at_idx ON link_events (created_at);
+
+-- +goose Down
+DROP TABLE link_events;
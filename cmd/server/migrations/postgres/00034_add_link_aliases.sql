@@ -0,0 +1,26 @@
+-- +goose Up
+-- Further codes a link answers to besides its own. Codes are unique
+-- across links and aliases within a namespace.
+CREATE TABLE IF NOT EXISTS link_aliases (
+    id         SERIAL PRIMARY KEY,
+    org_id     INTEGER NOT NULL,
+    link_id    INTEGER NOT NULL REFERENCES links (id),
+    code       VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (org_id, code)
+);
+
+CREATE INDEX IF NOT EXISTS link_aliases_link_id_idx ON link_aliases (link_id);
+
+-- Clicks of a link per code it was visited under.
+CREATE TABLE IF NOT EXISTS link_code_clicks (
+    link_id INTEGER NOT NULL REFERENCES links (id),
+    code    VARCHAR(64) NOT NULL,
+    clicks  BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (link_id, code)
+);
+
+-- +goose Down
+DROP TABLE link_code_clicks;
+
+DROP TABLE link_aliases;
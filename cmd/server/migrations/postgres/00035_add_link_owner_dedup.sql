@@ -0,0 +1,28 @@
+-- +goose Up
+-- The API key that created a link, 0 for links created without one. A URL
+-- is only deduplicated against the links of the same key, and never for
+-- links minted with force_new.
+ALTER TABLE links ADD COLUMN IF NOT EXISTS key_id INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE links ADD COLUMN IF NOT EXISTS force_new BOOLEAN NOT NULL DEFAULT FALSE;
+
+DROP INDEX links_url_active_key;
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (org_id, key_id, url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL
+        AND active_from IS NULL AND active_until IS NULL AND fallback_url IS NULL
+        AND routing_rules IS NULL AND archived_at IS NULL AND NOT force_new;
+
+-- +goose Down
+DROP INDEX links_url_active_key;
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (org_id, url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL
+        AND active_from IS NULL AND active_until IS NULL AND fallback_url IS NULL
+        AND routing_rules IS NULL AND archived_at IS NULL;
+
+ALTER TABLE links DROP COLUMN force_new;
+ALTER TABLE links DROP COLUMN key_id;
@@ -0,0 +1,41 @@
+-- +goose Up
+-- Hostnames organizations serve their links on besides SHORT_DOMAINS. A
+-- domain only serves links once verified_at shows the organization
+-- controls it.
+CREATE TABLE IF NOT EXISTS domains (
+    id                 SERIAL PRIMARY KEY,
+    org_id             INTEGER NOT NULL REFERENCES organizations (id),
+    hostname           VARCHAR(253) NOT NULL UNIQUE,
+    verification_token VARCHAR(64) NOT NULL,
+    verified_at        TIMESTAMPTZ,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS domains_org_id_idx ON domains (org_id);
+
+-- The domain a link was issued under, 0 for SHORT_DOMAINS. A URL is only
+-- deduplicated against the links issued under the same domain.
+ALTER TABLE links ADD COLUMN IF NOT EXISTS domain_id INTEGER NOT NULL DEFAULT 0;
+
+DROP INDEX links_url_active_key;
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (org_id, domain_id, key_id, url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL
+        AND active_from IS NULL AND active_until IS NULL AND fallback_url IS NULL
+        AND routing_rules IS NULL AND archived_at IS NULL AND NOT force_new;
+
+-- +goose Down
+DROP INDEX links_url_active_key;
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (org_id, key_id, url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL
+        AND active_from IS NULL AND active_until IS NULL AND fallback_url IS NULL
+        AND routing_rules IS NULL AND archived_at IS NULL AND NOT force_new;
+
+ALTER TABLE links DROP COLUMN domain_id;
+
+DROP TABLE domains;
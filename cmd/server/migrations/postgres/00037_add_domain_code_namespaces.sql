@@ -0,0 +1,24 @@
+-- +goose Up
+-- Every domain of an organization has a code space of its own, so a code
+-- taken on one domain is still free on the others. Aliases live in the
+-- code space of the domain of their link.
+ALTER TABLE link_aliases ADD COLUMN IF NOT EXISTS domain_id INTEGER NOT NULL DEFAULT 0;
+
+UPDATE link_aliases SET domain_id = links.domain_id
+FROM links
+WHERE links.id = link_aliases.link_id;
+
+ALTER TABLE link_aliases DROP CONSTRAINT link_aliases_org_id_code_key;
+CREATE UNIQUE INDEX link_aliases_org_id_domain_id_code_key ON link_aliases (org_id, domain_id, code);
+
+DROP INDEX links_code_key;
+CREATE UNIQUE INDEX links_code_key ON links (org_id, domain_id, code);
+
+-- +goose Down
+DROP INDEX links_code_key;
+CREATE UNIQUE INDEX links_code_key ON links (org_id, code);
+
+DROP INDEX link_aliases_org_id_domain_id_code_key;
+ALTER TABLE link_aliases ADD CONSTRAINT link_aliases_org_id_code_key UNIQUE (org_id, code);
+
+ALTER TABLE link_aliases DROP COLUMN domain_id;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- Links a destination scanner flagged stay quarantined until an admin
+-- releases them. quarantine_reviewed_at is set when an admin confirms the
+-- quarantine instead, which takes the link off the review queue.
+ALTER TABLE links
+    ADD COLUMN IF NOT EXISTS quarantined_at         TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS quarantine_reason      TEXT,
+    ADD COLUMN IF NOT EXISTS quarantine_reviewed_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS links_quarantined_at_idx
+    ON links (quarantined_at)
+    WHERE quarantined_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX links_quarantined_at_idx;
+
+ALTER TABLE links
+    DROP COLUMN quarantined_at,
+    DROP COLUMN quarantine_reason,
+    DROP COLUMN quarantine_reviewed_at;
@@ -0,0 +1,26 @@
+-- +goose Up
+-- Reports of links visitors consider malicious. resolution is set to
+-- dismissed, disabled or whitelisted once an admin dealt with a report.
+-- reporter_hash is the hashed address of the reporter, so that a visitor
+-- counts once per link. Links whose reports were whitelisted collect no
+-- further reports.
+CREATE TABLE IF NOT EXISTS link_reports (
+    id            SERIAL PRIMARY KEY,
+    link_id       INTEGER NOT NULL REFERENCES links (id),
+    reason        VARCHAR(32) NOT NULL,
+    details       TEXT,
+    email         VARCHAR(320),
+    reporter_hash VARCHAR(64),
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    resolved_at   TIMESTAMPTZ,
+    resolution    VARCHAR(32)
+);
+
+CREATE INDEX IF NOT EXISTS link_reports_link_id_idx ON link_reports (link_id, resolved_at);
+
+ALTER TABLE links ADD COLUMN IF NOT EXISTS reports_whitelisted_at TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE links DROP COLUMN reports_whitelisted_at;
+
+DROP TABLE link_reports;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- scopes is a JSON array of what a key may do; keys issued before scopes
+-- existed have none stored and may do everything. A rotated key stays
+-- valid until its expires_at and names the key that replaced it.
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS scopes       TEXT,
+    ADD COLUMN IF NOT EXISTS expires_at   TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS replaced_by  INTEGER;
+
+-- +goose Down
+ALTER TABLE api_keys
+    DROP COLUMN scopes,
+    DROP COLUMN expires_at,
+    DROP COLUMN last_used_at,
+    DROP COLUMN replaced_by;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- Accounts of external identity providers members logged in with. subject
+-- is the identifier the provider gives the account; a member has at most
+-- one account per provider.
+CREATE TABLE IF NOT EXISTS member_identities (
+    id            SERIAL PRIMARY KEY,
+    member_id     INTEGER NOT NULL REFERENCES organization_members (id),
+    provider      VARCHAR(32) NOT NULL,
+    subject       VARCHAR(255) NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (member_id, provider)
+);
+
+CREATE INDEX IF NOT EXISTS member_identities_subject_idx ON member_identities (provider, subject);
+
+-- +goose Down
+DROP TABLE member_identities;
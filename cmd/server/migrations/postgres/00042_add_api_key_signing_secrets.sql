@@ -0,0 +1,7 @@
+-- +goose Up
+-- signing_secret is what requests signed for a key are checked with. It
+-- is kept in the clear, as checking a signature takes the secret itself.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS signing_secret VARCHAR(64);
+
+-- +goose Down
+ALTER TABLE api_keys DROP COLUMN signing_secret;
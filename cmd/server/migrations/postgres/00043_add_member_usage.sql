@@ -0,0 +1,13 @@
+-- +goose Up
+-- Usage of members acting with a session token, which has no API key to
+-- count against. month is YYYY-MM in UTC.
+CREATE TABLE IF NOT EXISTS member_usage (
+    member_id INTEGER NOT NULL REFERENCES organization_members (id),
+    month     CHAR(7) NOT NULL,
+    shortens  INTEGER NOT NULL DEFAULT 0,
+    redirects INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (member_id, month)
+);
+
+-- +goose Down
+DROP TABLE member_usage;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- A hostname is only unique among verified domains, so that an
+-- organization claiming a domain it does not control cannot keep its
+-- owner from registering it. Every organization claims a hostname once.
+ALTER TABLE domains DROP CONSTRAINT IF EXISTS domains_hostname_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS domains_org_hostname_key ON domains (org_id, hostname);
+CREATE UNIQUE INDEX IF NOT EXISTS domains_verified_hostname_key ON domains (hostname) WHERE verified_at IS NOT NULL;
+
+-- +goose Down
+DELETE FROM domains d
+WHERE d.verified_at IS NULL AND EXISTS (
+    SELECT 1 FROM domains o
+    WHERE o.hostname = d.hostname AND o.id <> d.id AND (o.verified_at IS NOT NULL OR o.id < d.id)
+);
+
+DROP INDEX IF EXISTS domains_verified_hostname_key;
+DROP INDEX IF EXISTS domains_org_hostname_key;
+
+ALTER TABLE domains ADD CONSTRAINT domains_hostname_key UNIQUE (hostname);
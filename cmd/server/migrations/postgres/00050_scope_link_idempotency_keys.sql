@@ -0,0 +1,18 @@
+-- +goose Up
+-- member_id is the member whose session created a link, 0 for links
+-- created with an API key or without a caller. Idempotency keys are unique
+-- per API key or member rather than per organization, and
+-- idempotency_hash fingerprints the request that used one, so that another
+-- request reusing the key is told apart from a retry.
+ALTER TABLE links ADD COLUMN member_id INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE links ADD COLUMN idempotency_hash CHAR(64) NULL;
+
+DROP INDEX links_idempotency_key;
+CREATE UNIQUE INDEX links_idempotency_key ON links (org_id, key_id, member_id, idempotency_key);
+
+-- +goose Down
+DROP INDEX links_idempotency_key;
+CREATE UNIQUE INDEX links_idempotency_key ON links (org_id, idempotency_key);
+
+ALTER TABLE links DROP COLUMN idempotency_hash;
+ALTER TABLE links DROP COLUMN member_id;
@@ -0,0 +1,34 @@
+-- +goose Up
+-- SQLite databases start from the current schema, so the Postgres history
+-- is folded into this single migration.
+CREATE TABLE links (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    code            VARCHAR(64) NOT NULL UNIQUE,
+    url             TEXT NOT NULL,
+    created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    attempt_count   INTEGER NOT NULL DEFAULT 0,
+    click_count     INTEGER NOT NULL DEFAULT 0,
+    expires_at      TIMESTAMP,
+    redirect_status SMALLINT NOT NULL DEFAULT 302,
+    deleted_at      TIMESTAMP,
+    updated_at      TIMESTAMP
+);
+
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL;
+
+CREATE INDEX links_expires_at_idx
+    ON links (expires_at)
+    WHERE expires_at IS NOT NULL;
+
+CREATE TABLE clicks (
+    link_id INTEGER NOT NULL REFERENCES links (id),
+    clicks  INTEGER NOT NULL DEFAULT 0,
+    date    TEXT NOT NULL,
+    PRIMARY KEY (link_id, date)
+);
+
+-- +goose Down
+DROP TABLE clicks;
+DROP TABLE links;
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE link_referrers (
+    link_id  INTEGER NOT NULL REFERENCES links (id),
+    referrer VARCHAR(255) NOT NULL,
+    clicks   INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (link_id, referrer)
+);
+
+-- +goose Down
+DROP TABLE link_referrers;
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE link_countries (
+    link_id INTEGER NOT NULL REFERENCES links (id),
+    country VARCHAR(2) NOT NULL,
+    clicks  INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (link_id, country)
+);
+
+-- +goose Down
+DROP TABLE link_countries;
@@ -0,0 +1,18 @@
+-- +goose Up
+ALTER TABLE links ADD COLUMN max_clicks INTEGER;
+ALTER TABLE links ADD COLUMN consumed_clicks INTEGER NOT NULL DEFAULT 0;
+
+-- Links with a click limit are never deduplicated.
+DROP INDEX links_url_active_key;
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL;
+
+-- +goose Down
+DROP INDEX links_url_active_key;
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL;
+
+ALTER TABLE links DROP COLUMN consumed_clicks;
+ALTER TABLE links DROP COLUMN max_clicks;
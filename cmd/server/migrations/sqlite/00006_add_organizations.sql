@@ -0,0 +1,135 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+-- The UNIQUE constraint on links.code cannot be dropped in place, so the
+-- table is rebuilt. Foreign keys have to be off while the old table is
+-- dropped, which SQLite only allows outside a transaction.
+PRAGMA foreign_keys = OFF;
+
+BEGIN;
+
+CREATE TABLE organizations (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    slug       VARCHAR(32) NOT NULL UNIQUE,
+    name       VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE organization_members (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    org_id     INTEGER NOT NULL REFERENCES organizations (id),
+    email      VARCHAR(255) NOT NULL,
+    role       VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, email)
+);
+
+-- Only a SHA-256 hash of each key is kept; prefix lets members tell their
+-- keys apart.
+CREATE TABLE api_keys (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    org_id     INTEGER NOT NULL REFERENCES organizations (id),
+    member_id  INTEGER NOT NULL REFERENCES organization_members (id),
+    name       VARCHAR(64) NOT NULL,
+    prefix     VARCHAR(16) NOT NULL,
+    key_hash   CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+-- Links created without an organization keep org_id 0, the shared
+-- namespace. Codes and deduplicated URLs are unique per namespace.
+CREATE TABLE links_new (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    org_id          INTEGER NOT NULL DEFAULT 0,
+    code            VARCHAR(64) NOT NULL,
+    url             TEXT NOT NULL,
+    created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    attempt_count   INTEGER NOT NULL DEFAULT 0,
+    click_count     INTEGER NOT NULL DEFAULT 0,
+    expires_at      TIMESTAMP,
+    redirect_status SMALLINT NOT NULL DEFAULT 302,
+    deleted_at      TIMESTAMP,
+    updated_at      TIMESTAMP,
+    max_clicks      INTEGER,
+    consumed_clicks INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO links_new (id, code, url, created_at, attempt_count, click_count, expires_at, redirect_status, deleted_at, updated_at, max_clicks, consumed_clicks)
+SELECT id, code, url, created_at, attempt_count, click_count, expires_at, redirect_status, deleted_at, updated_at, max_clicks, consumed_clicks
+FROM links;
+
+-- Keep ids of purged links from being handed out again.
+UPDATE sqlite_sequence
+SET seq = (SELECT seq FROM sqlite_sequence WHERE name = 'links')
+WHERE name = 'links_new';
+
+DROP TABLE links;
+ALTER TABLE links_new RENAME TO links;
+
+CREATE UNIQUE INDEX links_code_key ON links (org_id, code);
+
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (org_id, url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL;
+
+CREATE INDEX links_expires_at_idx
+    ON links (expires_at)
+    WHERE expires_at IS NOT NULL;
+
+COMMIT;
+
+PRAGMA foreign_keys = ON;
+
+-- +goose Down
+PRAGMA foreign_keys = OFF;
+
+BEGIN;
+
+CREATE TABLE links_old (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    code            VARCHAR(64) NOT NULL UNIQUE,
+    url             TEXT NOT NULL,
+    created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    attempt_count   INTEGER NOT NULL DEFAULT 0,
+    click_count     INTEGER NOT NULL DEFAULT 0,
+    expires_at      TIMESTAMP,
+    redirect_status SMALLINT NOT NULL DEFAULT 302,
+    deleted_at      TIMESTAMP,
+    updated_at      TIMESTAMP,
+    max_clicks      INTEGER,
+    consumed_clicks INTEGER NOT NULL DEFAULT 0
+);
+
+-- Codes only stay unique once organization links are gone.
+DELETE FROM clicks WHERE link_id IN (SELECT id FROM links WHERE org_id <> 0);
+DELETE FROM link_referrers WHERE link_id IN (SELECT id FROM links WHERE org_id <> 0);
+DELETE FROM link_countries WHERE link_id IN (SELECT id FROM links WHERE org_id <> 0);
+DELETE FROM link_devices WHERE link_id IN (SELECT id FROM links WHERE org_id <> 0);
+
+INSERT INTO links_old (id, code, url, created_at, attempt_count, click_count, expires_at, redirect_status, deleted_at, updated_at, max_clicks, consumed_clicks)
+SELECT id, code, url, created_at, attempt_count, click_count, expires_at, redirect_status, deleted_at, updated_at, max_clicks, consumed_clicks
+FROM links
+WHERE org_id = 0;
+
+UPDATE sqlite_sequence
+SET seq = (SELECT seq FROM sqlite_sequence WHERE name = 'links')
+WHERE name = 'links_old';
+
+DROP TABLE links;
+ALTER TABLE links_old RENAME TO links;
+
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL;
+
+CREATE INDEX links_expires_at_idx
+    ON links (expires_at)
+    WHERE expires_at IS NOT NULL;
+
+DROP TABLE api_keys;
+DROP TABLE organization_members;
+DROP TABLE organizations;
+
+COMMIT;
+
+PRAGMA foreign_keys = ON;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- month is YYYY-MM in UTC.
+CREATE TABLE api_key_usage (
+    api_key_id INTEGER NOT NULL REFERENCES api_keys (id),
+    month      CHAR(7) NOT NULL,
+    shortens   INTEGER NOT NULL DEFAULT 0,
+    redirects  INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (api_key_id, month)
+);
+
+-- +goose Down
+DROP TABLE api_key_usage;
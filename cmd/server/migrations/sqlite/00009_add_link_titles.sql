@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE links ADD COLUMN title VARCHAR(300);
+
+-- +goose Down
+ALTER TABLE links DROP COLUMN title;
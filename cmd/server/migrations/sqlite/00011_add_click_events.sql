@@ -0,0 +1,16 @@
+-- +goose Up
+-- One row per click, alongside the daily counters. referrer_hash is the
+-- hex SHA-256 of the referrer host and is NULL for direct visits.
+CREATE TABLE click_events (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    link_id       INTEGER NOT NULL REFERENCES links (id),
+    clicked_at    TIMESTAMP NOT NULL,
+    country       VARCHAR(2) NOT NULL,
+    referrer_hash CHAR(64),
+    device_type   VARCHAR(16) NOT NULL
+);
+
+CREATE INDEX click_events_link_id_idx ON click_events (link_id, id);
+
+-- +goose Down
+DROP TABLE click_events;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- Clicks by crawlers and link preview bots, which click_count leaves out.
+ALTER TABLE links ADD COLUMN bot_clicks BIGINT NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE links DROP COLUMN bot_clicks;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- ip_hash is the hex SHA-256 of the client address, so that the events of
+-- one visitor can be erased on request. clicked_at is indexed for the
+-- retention purge.
+ALTER TABLE click_events ADD COLUMN ip_hash CHAR(64);
+
+CREATE INDEX click_events_ip_hash_idx ON click_events (ip_hash);
+CREATE INDEX click_events_clicked_at_idx ON click_events (clicked_at);
+
+-- +goose Down
+DROP INDEX click_events_clicked_at_idx;
+DROP INDEX click_events_ip_hash_idx;
+ALTER TABLE click_events DROP COLUMN ip_hash;
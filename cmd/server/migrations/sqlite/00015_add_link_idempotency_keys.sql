@@ -0,0 +1,10 @@
+-- +goose Up
+-- The Idempotency-Key a link was created with, so that retried shorten
+-- requests get the same link back.
+ALTER TABLE links ADD COLUMN idempotency_key VARCHAR(255) NULL;
+
+CREATE UNIQUE INDEX links_idempotency_key ON links (org_id, idempotency_key);
+
+-- +goose Down
+DROP INDEX links_idempotency_key;
+ALTER TABLE links DROP COLUMN idempotency_key;
@@ -0,0 +1,29 @@
+-- +goose Up
+-- Weekly and monthly sums of the daily clicks, dated by the first day of
+-- the week, a Monday, or of the month. click_rollups records the day up to
+-- which each has been computed; later clicks are summed from clicks.
+CREATE TABLE clicks_weekly (
+    link_id INTEGER NOT NULL REFERENCES links (id),
+    date    TEXT NOT NULL,
+    clicks  INTEGER NOT NULL,
+    PRIMARY KEY (link_id, date)
+);
+
+CREATE TABLE clicks_monthly (
+    link_id INTEGER NOT NULL REFERENCES links (id),
+    date    TEXT NOT NULL,
+    clicks  INTEGER NOT NULL,
+    PRIMARY KEY (link_id, date)
+);
+
+CREATE TABLE click_rollups (
+    granularity  VARCHAR(8) PRIMARY KEY,
+    rolled_up_to TEXT
+);
+
+INSERT INTO click_rollups (granularity) VALUES ('week'), ('month');
+
+-- +goose Down
+DROP TABLE click_rollups;
+DROP TABLE clicks_monthly;
+DROP TABLE clicks_weekly;
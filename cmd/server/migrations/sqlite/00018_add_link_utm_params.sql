@@ -0,0 +1,23 @@
+-- +goose Up
+-- UTM parameters appended to the destination of a link on redirect.
+ALTER TABLE links ADD COLUMN utm_source TEXT;
+ALTER TABLE links ADD COLUMN utm_medium TEXT;
+ALTER TABLE links ADD COLUMN utm_campaign TEXT;
+
+-- Links with UTM parameters are never deduplicated, so that a destination
+-- can have a link per campaign variant.
+DROP INDEX links_url_active_key;
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (org_id, url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL;
+
+-- +goose Down
+DROP INDEX links_url_active_key;
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (org_id, url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL;
+
+ALTER TABLE links DROP COLUMN utm_campaign;
+ALTER TABLE links DROP COLUMN utm_medium;
+ALTER TABLE links DROP COLUMN utm_source;
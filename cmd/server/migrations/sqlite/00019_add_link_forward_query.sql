@@ -0,0 +1,6 @@
+-- +goose Up
+-- Links that pass the query of the short URL on to their destination.
+ALTER TABLE links ADD COLUMN forward_query BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE links DROP COLUMN forward_query;
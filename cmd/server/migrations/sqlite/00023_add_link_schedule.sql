@@ -0,0 +1,27 @@
+-- +goose Up
+-- The window a link redirects within, and where it sends visitors outside
+-- of it.
+ALTER TABLE links ADD COLUMN active_from TIMESTAMP;
+ALTER TABLE links ADD COLUMN active_until TIMESTAMP;
+ALTER TABLE links ADD COLUMN fallback_url TEXT;
+
+-- Scheduled links are never deduplicated.
+DROP INDEX links_url_active_key;
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (org_id, url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL
+        AND active_from IS NULL AND active_until IS NULL AND fallback_url IS NULL;
+
+-- +goose Down
+DROP INDEX links_url_active_key;
+CREATE UNIQUE INDEX links_url_active_key
+    ON links (org_id, url)
+    WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+        AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+        AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL;
+
+ALTER TABLE links DROP COLUMN active_from;
+ALTER TABLE links DROP COLUMN active_until;
+ALTER TABLE links DROP COLUMN fallback_url;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- What the health checker last found at the destination of a link.
+-- check_status is NULL when the destination did not respond, and
+-- broken_since is set once it has failed enough checks in a row.
+ALTER TABLE links ADD COLUMN checked_at TIMESTAMP;
+ALTER TABLE links ADD COLUMN check_status INTEGER;
+ALTER TABLE links ADD COLUMN check_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE links ADD COLUMN broken_since TIMESTAMP;
+
+CREATE INDEX links_checked_at_idx
+    ON links (checked_at)
+    WHERE deleted_at IS NULL;
+
+-- +goose Down
+DROP INDEX links_checked_at_idx;
+
+ALTER TABLE links DROP COLUMN checked_at;
+ALTER TABLE links DROP COLUMN check_status;
+ALTER TABLE links DROP COLUMN check_failures;
+ALTER TABLE links DROP COLUMN broken_since;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- Tags group the links of a campaign that spans many of them.
+CREATE TABLE link_tags (
+    link_id INTEGER NOT NULL REFERENCES links (id),
+    tag     VARCHAR(64) NOT NULL,
+    PRIMARY KEY (link_id, tag)
+);
+
+CREATE INDEX link_tags_tag_idx ON link_tags (tag, link_id);
+
+-- +goose Down
+DROP TABLE link_tags;
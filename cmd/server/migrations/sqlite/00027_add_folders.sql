@@ -0,0 +1,24 @@
+-- +goose Up
+-- Folders nest under parent_id within a namespace, org_id 0 being the
+-- shared one. A link is filed in at most one folder.
+CREATE TABLE folders (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    org_id     INTEGER NOT NULL,
+    parent_id  INTEGER REFERENCES folders (id),
+    name       VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP
+);
+
+CREATE INDEX folders_org_id_parent_id_idx ON folders (org_id, parent_id);
+
+ALTER TABLE links ADD COLUMN folder_id INTEGER REFERENCES folders (id);
+
+CREATE INDEX links_folder_id_idx ON links (folder_id);
+
+-- +goose Down
+DROP INDEX links_folder_id_idx;
+
+ALTER TABLE links DROP COLUMN folder_id;
+
+DROP TABLE folders;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- Every change made to a link, with the audited fields it changed. Events
+-- outlive the link they describe, so link_id has no foreign key.
+CREATE TABLE link_events (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    org_id     INTEGER NOT NULL,
+    link_id    INTEGER NOT NULL,
+    code       VARCHAR(64) NOT NULL,
+    action     VARCHAR(16) NOT NULL,
+    member_id  INTEGER,
+    key_id     INTEGER,
+    changes    TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX link_events_link_id_idx ON link_events (link_id, id);
+CREATE INDEX link_events_created_at_idx ON link_events (created_at);
+
+-- +goose Down
+DROP TABLE link_events;
@@ -0,0 +1,26 @@
+-- +goose Up
+-- Further codes a link answers to besides its own. Codes are unique
+-- across links and aliases within a namespace.
+CREATE TABLE link_aliases (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    org_id     INTEGER NOT NULL,
+    link_id    INTEGER NOT NULL REFERENCES links (id),
+    code       VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, code)
+);
+
+CREATE INDEX link_aliases_link_id_idx ON link_aliases (link_id);
+
+-- Clicks of a link per code it was visited under.
+CREATE TABLE link_code_clicks (
+    link_id INTEGER NOT NULL REFERENCES links (id),
+    code    VARCHAR(64) NOT NULL,
+    clicks  INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (link_id, code)
+);
+
+-- +goose Down
+DROP TABLE link_code_clicks;
+
+DROP TABLE link_aliases;
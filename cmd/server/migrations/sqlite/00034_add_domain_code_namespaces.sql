@@ -0,0 +1,48 @@
+-- +goose Up
+-- Every domain of an organization has a code space of its own, so a code
+-- taken on one domain is still free on the others. Aliases live in the
+-- code space of the domain of their link. The UNIQUE constraint on
+-- link_aliases cannot be dropped in place, so the table is rebuilt.
+CREATE TABLE link_aliases_new (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    org_id     INTEGER NOT NULL,
+    domain_id  INTEGER NOT NULL DEFAULT 0,
+    link_id    INTEGER NOT NULL REFERENCES links (id),
+    code       VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, domain_id, code)
+);
+
+INSERT INTO link_aliases_new (id, org_id, domain_id, link_id, code, created_at)
+SELECT a.id, a.org_id, l.domain_id, a.link_id, a.code, a.created_at
+FROM link_aliases a
+JOIN links l ON l.id = a.link_id;
+
+DROP TABLE link_aliases;
+ALTER TABLE link_aliases_new RENAME TO link_aliases;
+
+CREATE INDEX link_aliases_link_id_idx ON link_aliases (link_id);
+
+DROP INDEX links_code_key;
+CREATE UNIQUE INDEX links_code_key ON links (org_id, domain_id, code);
+
+-- +goose Down
+DROP INDEX links_code_key;
+CREATE UNIQUE INDEX links_code_key ON links (org_id, code);
+
+CREATE TABLE link_aliases_old (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    org_id     INTEGER NOT NULL,
+    link_id    INTEGER NOT NULL REFERENCES links (id),
+    code       VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, code)
+);
+
+INSERT INTO link_aliases_old (id, org_id, link_id, code, created_at)
+SELECT id, org_id, link_id, code, created_at FROM link_aliases;
+
+DROP TABLE link_aliases;
+ALTER TABLE link_aliases_old RENAME TO link_aliases;
+
+CREATE INDEX link_aliases_link_id_idx ON link_aliases (link_id);
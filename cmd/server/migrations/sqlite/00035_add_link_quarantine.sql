@@ -0,0 +1,18 @@
+-- +goose Up
+-- Links a destination scanner flagged stay quarantined until an admin
+-- releases them. quarantine_reviewed_at is set when an admin confirms the
+-- quarantine instead, which takes the link off the review queue.
+ALTER TABLE links ADD COLUMN quarantined_at TIMESTAMP;
+ALTER TABLE links ADD COLUMN quarantine_reason TEXT;
+ALTER TABLE links ADD COLUMN quarantine_reviewed_at TIMESTAMP;
+
+CREATE INDEX links_quarantined_at_idx
+    ON links (quarantined_at)
+    WHERE quarantined_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX links_quarantined_at_idx;
+
+ALTER TABLE links DROP COLUMN quarantined_at;
+ALTER TABLE links DROP COLUMN quarantine_reason;
+ALTER TABLE links DROP COLUMN quarantine_reviewed_at;
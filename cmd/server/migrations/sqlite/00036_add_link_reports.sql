@@ -0,0 +1,26 @@
+-- +goose Up
+-- Reports of links visitors consider malicious. resolution is set to
+-- dismissed, disabled or whitelisted once an admin dealt with a report.
+-- reporter_hash is the hashed address of the reporter, so that a visitor
+-- counts once per link. Links whose reports were whitelisted collect no
+-- further reports.
+CREATE TABLE link_reports (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    link_id       INTEGER NOT NULL REFERENCES links (id),
+    reason        VARCHAR(32) NOT NULL,
+    details       TEXT,
+    email         VARCHAR(320),
+    reporter_hash VARCHAR(64),
+    created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    resolved_at   TIMESTAMP,
+    resolution    VARCHAR(32)
+);
+
+CREATE INDEX link_reports_link_id_idx ON link_reports (link_id, resolved_at);
+
+ALTER TABLE links ADD COLUMN reports_whitelisted_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE links DROP COLUMN reports_whitelisted_at;
+
+DROP TABLE link_reports;
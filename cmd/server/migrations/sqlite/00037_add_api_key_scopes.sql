@@ -0,0 +1,14 @@
+-- +goose Up
+-- scopes is a JSON array of what a key may do; keys issued before scopes
+-- existed have none stored and may do everything. A rotated key stays
+-- valid until its expires_at and names the key that replaced it.
+ALTER TABLE api_keys ADD COLUMN scopes TEXT;
+ALTER TABLE api_keys ADD COLUMN expires_at TIMESTAMP;
+ALTER TABLE api_keys ADD COLUMN last_used_at TIMESTAMP;
+ALTER TABLE api_keys ADD COLUMN replaced_by INTEGER;
+
+-- +goose Down
+ALTER TABLE api_keys DROP COLUMN replaced_by;
+ALTER TABLE api_keys DROP COLUMN last_used_at;
+ALTER TABLE api_keys DROP COLUMN expires_at;
+ALTER TABLE api_keys DROP COLUMN scopes;
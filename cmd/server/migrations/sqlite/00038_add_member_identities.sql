@@ -0,0 +1,18 @@
+-- +goose Up
+-- Accounts of external identity providers members logged in with. subject
+-- is the identifier the provider gives the account; a member has at most
+-- one account per provider.
+CREATE TABLE member_identities (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    member_id     INTEGER NOT NULL REFERENCES organization_members (id),
+    provider      VARCHAR(32) NOT NULL,
+    subject       VARCHAR(255) NOT NULL,
+    created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (member_id, provider)
+);
+
+CREATE INDEX member_identities_subject_idx ON member_identities (provider, subject);
+
+-- +goose Down
+DROP TABLE member_identities;
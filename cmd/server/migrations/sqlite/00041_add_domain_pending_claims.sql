@@ -0,0 +1,47 @@
+-- +goose Up
+-- A hostname is only unique among verified domains, so that an
+-- organization claiming a domain it does not control cannot keep its
+-- owner from registering it. Every organization claims a hostname once.
+-- The UNIQUE constraint on hostname cannot be dropped in place, so the
+-- table is rebuilt.
+CREATE TABLE domains_new (
+    id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+    org_id             INTEGER NOT NULL REFERENCES organizations (id),
+    hostname           VARCHAR(253) NOT NULL,
+    verification_token VARCHAR(64) NOT NULL,
+    verified_at        TIMESTAMP,
+    created_at         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, hostname)
+);
+
+INSERT INTO domains_new (id, org_id, hostname, verification_token, verified_at, created_at)
+SELECT id, org_id, hostname, verification_token, verified_at, created_at FROM domains;
+
+DROP TABLE domains;
+ALTER TABLE domains_new RENAME TO domains;
+
+CREATE INDEX domains_org_id_idx ON domains (org_id);
+CREATE UNIQUE INDEX domains_verified_hostname_key ON domains (hostname) WHERE verified_at IS NOT NULL;
+
+-- +goose Down
+CREATE TABLE domains_old (
+    id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+    org_id             INTEGER NOT NULL REFERENCES organizations (id),
+    hostname           VARCHAR(253) NOT NULL UNIQUE,
+    verification_token VARCHAR(64) NOT NULL,
+    verified_at        TIMESTAMP,
+    created_at         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO domains_old (id, org_id, hostname, verification_token, verified_at, created_at)
+SELECT d.id, d.org_id, d.hostname, d.verification_token, d.verified_at, d.created_at
+FROM domains d
+WHERE d.verified_at IS NOT NULL OR NOT EXISTS (
+    SELECT 1 FROM domains o
+    WHERE o.hostname = d.hostname AND o.id <> d.id AND (o.verified_at IS NOT NULL OR o.id < d.id)
+);
+
+DROP TABLE domains;
+ALTER TABLE domains_old RENAME TO domains;
+
+CREATE INDEX domains_org_id_idx ON domains (org_id);
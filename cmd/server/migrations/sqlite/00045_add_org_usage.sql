@@ -0,0 +1,13 @@
+-- +goose Up
+-- Redirects of the links of an organization, whose visitors have no API
+-- key or session to count against. month is YYYY-MM in UTC.
+CREATE TABLE org_usage (
+    org_id    INTEGER NOT NULL REFERENCES organizations (id),
+    month     CHAR(7) NOT NULL,
+    shortens  INTEGER NOT NULL DEFAULT 0,
+    redirects INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (org_id, month)
+);
+
+-- +goose Down
+DROP TABLE org_usage;
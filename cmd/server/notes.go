@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	maxDescriptionLength = 500
+	maxNotesLength       = 10000
+)
+
+// prepareNotes trims the description and notes of a link in place and
+// checks their length.
+func prepareNotes(description *string, notes *string) error {
+	if description != nil {
+		*description = strings.TrimSpace(*description)
+		if len(*description) > maxDescriptionLength {
+			return &fieldError{Field: "description", Message: fmt.Sprintf("description must be at most %d characters", maxDescriptionLength)}
+		}
+	}
+
+	if notes != nil {
+		*notes = strings.TrimSpace(*notes)
+		if len(*notes) > maxNotesLength {
+			return &fieldError{Field: "notes", Message: fmt.Sprintf("notes must be at most %d characters", maxNotesLength)}
+		}
+	}
+
+	return nil
+}
+
+// applyNotesUpdate sets the description and notes of link that request
+// has. Empty ones remove them.
+func applyNotesUpdate(link *Link, request UpdateLinkRequest) {
+	if request.Description != nil {
+		link.Description = nonEmpty(*request.Description)
+	}
+	if request.Notes != nil {
+		link.Notes = nonEmpty(*request.Notes)
+	}
+}
+
+// describeLink gives a URL that was already shortened the description and
+// notes of a shorten request that has them.
+func describeLink(ctx context.Context, links LinkStore, link *Link, request ShortenRequest) error {
+	description, notes := nonEmpty(request.Description), nonEmpty(request.Notes)
+	if (description == nil || equalStrings(description, link.Description)) && (notes == nil || equalStrings(notes, link.Notes)) {
+		return nil
+	}
+
+	updated, err := links.UpdateLink(ctx, link.OrgID, link.DomainID, link.Code, func(link *Link) error {
+		if description != nil {
+			link.Description = description
+		}
+		if notes != nil {
+			link.Notes = notes
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	link.Description, link.Notes, link.UpdatedAt = updated.Description, updated.Notes, updated.UpdatedAt
+
+	return nil
+}
+
+func equalStrings(a *string, b *string) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
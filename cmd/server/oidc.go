@@ -0,0 +1,643 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+	"github.com/gorilla/mux"
+)
+
+const (
+	googleIssuer            = "https://accounts.google.com"
+	gitHubAuthorizeEndpoint = "https://github.com/login/oauth/authorize"
+	gitHubTokenEndpoint     = "https://github.com/login/oauth/access_token"
+	gitHubAPI               = "https://api.github.com"
+	identityTimeout         = 10 * time.Second
+
+	// loginCookie carries the state of a login from its start to the
+	// callback of the provider, signed with the session secret.
+	loginCookie  = "wowee_login"
+	loginTimeout = 10 * time.Minute
+)
+
+var errLoginRejected = errors.New("identity provider rejected the login")
+
+// Identity is the account a visitor logged in with. Email is only set
+// when the provider verified it.
+type Identity struct {
+	Subject string
+	Email   string
+}
+
+// IdentityProvider logs visitors in with the authorization code flow of
+// OAuth 2.0, and with PKCE where the provider supports it.
+type IdentityProvider interface {
+	// AuthCodeURL is where visitors are sent to log in.
+	AuthCodeURL(ctx context.Context, redirectURI string, state string, nonce string, challenge string) (string, error)
+	// Exchange trades the code the provider sent the visitor back with for
+	// their identity. It fails with errLoginRejected when the provider
+	// vouches for no one.
+	Exchange(ctx context.Context, redirectURI string, code string, nonce string, verifier string) (Identity, error)
+}
+
+// NewIdentityProviders returns the providers whose settings are given, by
+// name: google with GOOGLE_CLIENT_ID, github with GITHUB_CLIENT_ID and oidc,
+// any OpenID Connect provider such as Keycloak, with OIDC_ISSUER_URL.
+func NewIdentityProviders(cfg *config.Config) (map[string]IdentityProvider, error) {
+	client := &http.Client{Timeout: identityTimeout, Transport: tracedTransport(nil)}
+	providers := map[string]IdentityProvider{}
+
+	if clientID := cfg.String("GOOGLE_CLIENT_ID"); clientID != "" {
+		providers["google"] = NewOIDCProvider(googleIssuer, clientID, cfg.String("GOOGLE_CLIENT_SECRET"), client)
+	}
+	if clientID := cfg.String("GITHUB_CLIENT_ID"); clientID != "" {
+		providers["github"] = &GitHubProvider{clientID: clientID, clientSecret: cfg.String("GITHUB_CLIENT_SECRET"), client: client}
+	}
+	if issuer := cfg.String("OIDC_ISSUER_URL"); issuer != "" {
+		clientID := cfg.String("OIDC_CLIENT_ID")
+		if clientID == "" {
+			return nil, errors.New("OIDC_ISSUER_URL requires OIDC_CLIENT_ID")
+		}
+		providers["oidc"] = NewOIDCProvider(strings.TrimSuffix(issuer, "/"), clientID, cfg.String("OIDC_CLIENT_SECRET"), client)
+	}
+
+	return providers, nil
+}
+
+// OIDCProvider logs in with an OpenID Connect provider, whose endpoints
+// are discovered from its issuer on first use. The ID token is taken from
+// the token endpoint over TLS, which OpenID Connect accepts in place of
+// checking its signature; its issuer, audience, expiration and nonce are
+// still checked.
+type OIDCProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+type oidcTokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+type oidcClaims struct {
+	Issuer        string          `json:"iss"`
+	Subject       string          `json:"sub"`
+	Audience      json.RawMessage `json:"aud"`
+	ExpiresAt     int64           `json:"exp"`
+	Nonce         string          `json:"nonce"`
+	Email         string          `json:"email"`
+	EmailVerified interface{}     `json:"email_verified"`
+}
+
+func NewOIDCProvider(issuer string, clientID string, clientSecret string, client *http.Client) *OIDCProvider {
+	return &OIDCProvider{issuer: issuer, clientID: clientID, clientSecret: clientSecret, client: client}
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OpenID Connect discovery answered %s", resp.Status)
+	}
+
+	var discovery oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != p.issuer || discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" {
+		return nil, fmt.Errorf("OpenID Connect discovery of %s is invalid", p.issuer)
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, redirectURI string, state string, nonce string, challenge string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"openid email"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	return discovery.AuthorizationEndpoint + "?" + params.Encode(), nil
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, redirectURI string, code string, nonce string, verifier string) (Identity, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.clientID},
+		"client_secret": {p.clientSecret},
+		"code_verifier": {verifier},
+	}
+
+	var token oidcTokenResponse
+	status, err := postForm(ctx, p.client, discovery.TokenEndpoint, form, &token)
+	if err != nil {
+		return Identity{}, err
+	}
+	if token.Error != "" || status == http.StatusBadRequest || status == http.StatusUnauthorized {
+		return Identity{}, errLoginRejected
+	}
+	if status != http.StatusOK {
+		return Identity{}, fmt.Errorf("token endpoint answered %d", status)
+	}
+
+	claims, err := parseIDToken(token.IDToken)
+	if err != nil {
+		return Identity{}, err
+	}
+	if claims.Issuer != p.issuer || !claims.hasAudience(p.clientID) || claims.Subject == "" {
+		return Identity{}, errLoginRejected
+	}
+	if time.Now().Unix() >= claims.ExpiresAt || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return Identity{}, errLoginRejected
+	}
+
+	identity := Identity{Subject: claims.Subject}
+	if verified, _ := strconv.ParseBool(fmt.Sprint(claims.EmailVerified)); verified {
+		identity.Email = claims.Email
+	}
+
+	return identity, nil
+}
+
+// parseIDToken decodes the claims of an ID token without checking its
+// signature.
+func parseIDToken(token string) (oidcClaims, error) {
+	var claims oidcClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("ID token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, err
+	}
+
+	return claims, json.Unmarshal(payload, &claims)
+}
+
+// hasAudience reports whether the aud claim, a string or an array of
+// them, names clientID.
+func (c oidcClaims) hasAudience(clientID string) bool {
+	var audiences []string
+	if err := json.Unmarshal(c.Audience, &audiences); err != nil {
+		var audience string
+		if json.Unmarshal(c.Audience, &audience) != nil {
+			return false
+		}
+		audiences = []string{audience}
+	}
+
+	for _, audience := range audiences {
+		if audience == clientID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GitHubProvider logs in with a GitHub OAuth app. GitHub speaks OAuth 2.0
+// but not OpenID Connect, so the account and its primary verified email
+// are read from the GitHub API.
+type GitHubProvider struct {
+	clientID     string
+	clientSecret string
+	client       *http.Client
+}
+
+type gitHubTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	Error       string `json:"error"`
+}
+
+type gitHubUser struct {
+	ID int64 `json:"id"`
+}
+
+type gitHubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+func (p *GitHubProvider) AuthCodeURL(ctx context.Context, redirectURI string, state string, nonce string, challenge string) (string, error) {
+	params := url.Values{
+		"client_id":             {p.clientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"read:user user:email"},
+		"state":                 {state},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	return gitHubAuthorizeEndpoint + "?" + params.Encode(), nil
+}
+
+func (p *GitHubProvider) Exchange(ctx context.Context, redirectURI string, code string, nonce string, verifier string) (Identity, error) {
+	form := url.Values{
+		"client_id":     {p.clientID},
+		"client_secret": {p.clientSecret},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+
+	var token gitHubTokenResponse
+	status, err := postForm(ctx, p.client, gitHubTokenEndpoint, form, &token)
+	if err != nil {
+		return Identity{}, err
+	}
+	if status != http.StatusOK {
+		return Identity{}, fmt.Errorf("GitHub answered %d", status)
+	}
+	if token.Error != "" || token.AccessToken == "" {
+		return Identity{}, errLoginRejected
+	}
+
+	var user gitHubUser
+	if err := p.get(ctx, token.AccessToken, "/user", &user); err != nil {
+		return Identity{}, err
+	}
+
+	var emails []gitHubEmail
+	if err := p.get(ctx, token.AccessToken, "/user/emails", &emails); err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{Subject: strconv.FormatInt(user.ID, 10)}
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			identity.Email = email.Email
+		}
+	}
+
+	return identity, nil
+}
+
+func (p *GitHubProvider) get(ctx context.Context, accessToken string, path string, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gitHubAPI+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GitHub answered %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+// postForm posts form to endpoint and decodes the JSON response into dst,
+// returning its status. Error responses are decoded too, as OAuth 2.0
+// describes errors in the body.
+func postForm(ctx context.Context, client *http.Client, endpoint string, form url.Values, dst interface{}) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(dst); err != nil && resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, err
+	}
+
+	return resp.StatusCode, nil
+}
+
+// loginState is what the login cookie remembers between the start of a
+// login and its callback.
+type loginState struct {
+	Provider  string `json:"provider"`
+	Org       string `json:"org,omitempty"`
+	State     string `json:"state"`
+	Nonce     string `json:"nonce"`
+	Verifier  string `json:"verifier"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type IdentityProvidersResponse struct {
+	Providers []string `json:"providers"`
+}
+
+// LoginResponse carries the session token of a member who logged in. It
+// is sent as a bearer token like an API key until ExpiresAt.
+type LoginResponse struct {
+	Token        string       `json:"token"`
+	ExpiresAt    time.Time    `json:"expires_at"`
+	Member       Member       `json:"member"`
+	Organization Organization `json:"organization"`
+	ElapsedTime  int64        `json:"elapsed_time"`
+}
+
+func randomToken() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// loginRedirectURI is the callback of provider, on BASE_URL or the host of
+// the request. It must be registered with the provider.
+func loginRedirectURI(r *http.Request, provider string) string {
+	base := baseURL
+	if base == "" {
+		base = requestScheme(r) + "://" + forwardedHost(r)
+	}
+
+	return base + apiPrefix + "/auth/" + provider + "/callback"
+}
+
+// IdentityProvidersHandler lists the providers members may log in with.
+func IdentityProvidersHandler(providers map[string]IdentityProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		names := []string{}
+		for name := range providers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		jsonResponse, err := json.Marshal(IdentityProvidersResponse{Providers: names})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// LoginHandler sends the visitor to the provider in the path to log in.
+// ?org= names the organization to log in to, which is only needed when
+// the account belongs to members of several.
+func LoginHandler(providers map[string]IdentityProvider, orgs OrgStore, sessions *SessionIssuer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["provider"]
+		provider, ok := providers[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Identity provider not found")
+			return
+		}
+
+		org := r.URL.Query().Get("org")
+		if org != "" {
+			if _, err := orgs.GetOrganizationBySlug(r.Context(), org); err != nil {
+				if err == ErrOrgNotFound {
+					writeError(w, http.StatusNotFound, "Organization not found")
+				} else {
+					slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+					writeError(w, http.StatusInternalServerError, "Internal Server Error")
+				}
+				return
+			}
+		}
+
+		state := loginState{Provider: name, Org: org, ExpiresAt: time.Now().Add(loginTimeout).Unix()}
+		var err error
+		for _, value := range []*string{&state.State, &state.Nonce, &state.Verifier} {
+			if *value, err = randomToken(); err != nil {
+				slog.ErrorContext(r.Context(), "Error generating login state", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+		}
+
+		challenge := sha256.Sum256([]byte(state.Verifier))
+		location, err := provider.AuthCodeURL(r.Context(), loginRedirectURI(r, name), state.State, state.Nonce, base64.RawURLEncoding.EncodeToString(challenge[:]))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error reaching identity provider", "error", err, "provider", name)
+			writeError(w, http.StatusBadGateway, "Identity provider could not be reached")
+			return
+		}
+
+		value, err := sessions.seal("login", state)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling login state", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     loginCookie,
+			Value:    value,
+			Path:     apiPrefix + "/auth/",
+			MaxAge:   int(loginTimeout.Seconds()),
+			HttpOnly: true,
+			Secure:   requestScheme(r) == "https",
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, location, http.StatusFound)
+	}
+}
+
+// readLoginState returns the state of the login cookie when it is signed,
+// unexpired and for provider.
+func readLoginState(r *http.Request, sessions *SessionIssuer, provider string) (loginState, bool) {
+	var state loginState
+
+	cookie, err := r.Cookie(loginCookie)
+	if err != nil {
+		return state, false
+	}
+
+	if !sessions.open("login", cookie.Value, &state) {
+		return state, false
+	}
+
+	return state, state.Provider == provider && time.Now().Unix() < state.ExpiresAt
+}
+
+// LoginCallbackHandler is where the provider in the path sends visitors
+// back. Their identity is matched to a member by the account they logged
+// in with before, or else by their verified email, which links the account
+// to the member. The response carries a session token of the member.
+func LoginCallbackHandler(providers map[string]IdentityProvider, orgs OrgStore, sessions *SessionIssuer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+		params := r.URL.Query()
+
+		name := mux.Vars(r)["provider"]
+		provider, ok := providers[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Identity provider not found")
+			return
+		}
+
+		state, ok := readLoginState(r, sessions, name)
+		if !ok || subtle.ConstantTimeCompare([]byte(params.Get("state")), []byte(state.State)) != 1 {
+			writeErrorCode(w, http.StatusBadRequest, "login_expired", "Login expired or was started elsewhere, start it again", nil)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: loginCookie, Path: apiPrefix + "/auth/", MaxAge: -1})
+
+		if reason := params.Get("error"); reason != "" || params.Get("code") == "" {
+			writeErrorCode(w, http.StatusUnauthorized, "login_failed", "Login with the identity provider failed", map[string]interface{}{"reason": reason})
+			return
+		}
+
+		identity, err := provider.Exchange(r.Context(), loginRedirectURI(r, name), params.Get("code"), state.Nonce, state.Verifier)
+		if err != nil {
+			if err == errLoginRejected {
+				writeErrorCode(w, http.StatusUnauthorized, "login_failed", "Login with the identity provider failed", nil)
+			} else {
+				slog.ErrorContext(r.Context(), "Error reaching identity provider", "error", err, "provider", name)
+				writeError(w, http.StatusBadGateway, "Identity provider could not be reached")
+			}
+			return
+		}
+
+		candidates, err := orgs.IdentityMembers(r.Context(), name, identity.Subject, identity.Email)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		members := []Member{}
+		organizations := []Organization{}
+		for _, candidate := range candidates {
+			org, err := orgs.GetOrganization(r.Context(), candidate.OrgID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+			if state.Org == "" || state.Org == org.Slug {
+				members = append(members, candidate)
+				organizations = append(organizations, org)
+			}
+		}
+
+		if len(members) == 0 {
+			writeErrorCode(w, http.StatusForbidden, "no_member", "No organization member matches this account", nil)
+			return
+		}
+		if len(members) > 1 {
+			slugs := []string{}
+			for _, org := range organizations {
+				slugs = append(slugs, org.Slug)
+			}
+			writeErrorCode(w, http.StatusConflict, "organization_required", "The account belongs to several organizations, log in again with ?org=", map[string]interface{}{"organizations": slugs})
+			return
+		}
+
+		err = orgs.LinkIdentity(r.Context(), members[0].ID, name, identity.Subject)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error linking identity", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		token, expiresAt, err := sessions.Issue(members[0])
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error issuing session", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		slog.InfoContext(r.Context(), "Member logged in", "provider", name, "org_id", members[0].OrgID, "member_id", members[0].ID)
+
+		response := LoginResponse{
+			Token:        token,
+			ExpiresAt:    expiresAt,
+			Member:       members[0],
+			Organization: organizations[0],
+			ElapsedTime:  time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
@@ -0,0 +1,612 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const openAPIVersion = "3.0.3"
+
+//go:embed templates/swagger.html
+var swaggerPage []byte
+
+// apiOperation documents one route. Request and Response are values of
+// the types the handler decodes and encodes; their schemas are derived
+// from the json tags, so the document follows the handlers as they
+// change. A nil Response with a ContentType documents a body that is not
+// JSON. Form marks a Request that is also accepted form-encoded.
+type apiOperation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Params      []apiParam
+	Request     interface{}
+	Response    interface{}
+	Status      int
+	ContentType string
+	Conflict    bool
+	Form        bool
+}
+
+type apiParam struct {
+	Name        string
+	Description string
+	Type        string
+	Enum        []string
+	Header      bool
+}
+
+func queryParam(name string, description string) apiParam {
+	return apiParam{Name: name, Description: description, Type: "string"}
+}
+
+func intQueryParam(name string, description string) apiParam {
+	return apiParam{Name: name, Description: description, Type: "integer"}
+}
+
+func boolQueryParam(name string, description string) apiParam {
+	return apiParam{Name: name, Description: description, Type: "boolean"}
+}
+
+func headerParam(name string, description string) apiParam {
+	return apiParam{Name: name, Description: description, Type: "string", Header: true}
+}
+
+func enumQueryParam(name string, description string, values []string) apiParam {
+	return apiParam{Name: name, Description: description, Type: "string", Enum: values}
+}
+
+const (
+	idempotencyKeyDescription = "A key unique to this link among those of the API key or session, so that retries of the same request return the link the first attempt created"
+	domainParamDescription    = "A verified custom domain of the organization whose code space the codes belong to"
+)
+
+// apiOperations lists every route the server registers, in the order of
+// main. checkOpenAPIRoutes warns about routes missing from it.
+var apiOperations = []apiOperation{
+	{Method: "GET", Path: "/", Summary: "Report that the API is up", Response: IndexResponse{}},
+	{Method: "GET", Path: "/healthz", Summary: "Report the state of every dependency", Response: HealthResponse{}},
+	{Method: "GET", Path: "/readyz", Summary: "Report whether the instance takes traffic, with 503 when it does not", Response: HealthResponse{}},
+	{Method: "GET", Path: apiPrefix + "/openapi.json", Summary: "Return this document", ContentType: "application/json"},
+	{Method: "POST", Path: "/graphql", Summary: "Run a GraphQL query or mutation on links and their click time series", Request: GraphQLRequest{}, Response: map[string]interface{}{}, Params: []apiParam{
+		queryParam("domain", domainParamDescription),
+	}},
+	{Method: "GET", Path: "/docs", Summary: "Browse this document with Swagger UI, when SWAGGER_UI is enabled", ContentType: "text/html"},
+	{Method: "GET", Path: apiPrefix + "/shorten", Summary: "Shorten a URL given in the query string, with an API key", Response: ShortenResponse{}, Conflict: true, Params: []apiParam{
+		queryParam("url", "The URL to shorten"),
+		queryParam("alias", "The code to shorten under instead of a generated one"),
+		intQueryParam("ttl_seconds", "Seconds until the link expires"),
+		queryParam("expires_at", "When the link expires, as an RFC 3339 timestamp"),
+		intQueryParam("redirect_status", "301, 302 or 307"),
+		intQueryParam("code_length", "The length of the generated code"),
+		intQueryParam("max_clicks", "Clicks after which the link stops redirecting"),
+		boolQueryParam("tracking_disabled", "Skip recording the clicks of the link"),
+		boolQueryParam("forward_query", "Pass the query of the short URL on to the destination"),
+		queryParam("utm_source", "utm_source appended to the destination on redirect"),
+		queryParam("utm_medium", "utm_medium appended to the destination on redirect"),
+		queryParam("utm_campaign", "utm_campaign appended to the destination on redirect"),
+		queryParam("active_from", "When the link starts redirecting, as an RFC 3339 timestamp"),
+		queryParam("active_until", "When the link stops redirecting, as an RFC 3339 timestamp"),
+		queryParam("fallback_url", "Where the link sends visitors once expired, over its click limit or outside of active_from and active_until"),
+		queryParam("tags", "Tags of the link, separated by commas"),
+		intQueryParam("folder_id", "The folder to file the link in"),
+		queryParam("description", "What the link is for"),
+		queryParam("notes", "Internal notes about the link"),
+		queryParam("domain", "A verified custom domain of the organization to issue the link under"),
+		headerParam(idempotencyKeyHeader, idempotencyKeyDescription),
+	}},
+	{Method: "POST", Path: apiPrefix + "/shorten", Summary: "Shorten a URL, under a generated code or an alias", Request: ShortenRequest{}, Response: ShortenResponse{}, Conflict: true, Form: true, Params: []apiParam{
+		headerParam(idempotencyKeyHeader, idempotencyKeyDescription),
+	}},
+	{Method: "GET", Path: apiPrefix + "/alias-available", Summary: "Tell whether an alias is free for /shorten, suggesting similar free ones when it is not", Response: AliasAvailabilityResponse{}, Params: []apiParam{
+		queryParam("alias", "The alias to check"),
+		queryParam("domain", domainParamDescription),
+	}},
+	{Method: "GET", Path: apiPrefix + "/stats", Summary: "Summarize the links of the caller's organization, or of every namespace for the admin key", Response: StatsResponse{}, Params: []apiParam{
+		queryParam("tag", "Only summarize the links with this tag"),
+	}},
+	{Method: "GET", Path: apiPrefix + "/stats/{code}", Summary: "Return a link with its counts", Response: Link{}, Params: []apiParam{
+		queryParam(shareParam, "Token of a share link, in place of an API key"),
+	}},
+	{Method: "POST", Path: apiPrefix + "/stats/{code}/share", Summary: "Issue a link that reads the stats of a link without an API key until it expires, when SESSION_SECRET is set", Request: StatsShareRequest{}, Response: StatsShareResponse{}, Status: http.StatusCreated},
+	{Method: "GET", Path: apiPrefix + "/stats/{code}/timeseries", Summary: "Return the clicks of a link over time", Response: ClickTimeSeriesResponse{}, Params: []apiParam{
+		queryParam("from", "First day, YYYY-MM-DD"),
+		queryParam("to", "Last day, YYYY-MM-DD"),
+		enumQueryParam("granularity", "Bucket size, day by default", mapKeys(clickGranularities)),
+	}},
+	{Method: "GET", Path: apiPrefix + "/stats/{code}/referrers", Summary: "Return the top referrers of a link", Response: ReferrersResponse{}, Params: []apiParam{
+		intQueryParam("limit", "Number of referrers, "+strconv.Itoa(defaultReferrerLimit)+" by default"),
+	}},
+	{Method: "GET", Path: apiPrefix + "/stats/{code}/countries", Summary: "Return the clicks of a link per country", Response: CountriesResponse{}},
+	{Method: "GET", Path: apiPrefix + "/stats/{code}/devices", Summary: "Return the clicks of a link per device type, browser and operating system", Response: DevicesResponse{}},
+	{Method: "GET", Path: apiPrefix + "/stats/{code}/destinations", Summary: "Return the clicks of a link per destination", Response: DestinationsResponse{}},
+	{Method: "GET", Path: apiPrefix + "/stats/{code}/codes", Summary: "Return the clicks of a link per code it was visited under, its own and its aliases", Response: CodesResponse{}},
+	{Method: "GET", Path: apiPrefix + "/stats/{code}/events", Summary: "Return a page of the click events of a link, newest first", Response: ClickEventsResponse{}, Params: []apiParam{
+		queryParam("from", "First day, YYYY-MM-DD"),
+		queryParam("to", "Last day, YYYY-MM-DD"),
+		queryParam("cursor", "next_cursor of the previous page"),
+		intQueryParam("limit", "Page size, at most "+strconv.Itoa(maxClickEventLimit)),
+	}},
+	{Method: "GET", Path: apiPrefix + "/stats/{code}/live", Summary: "Stream the clicks of a link as server-sent events while they happen", ContentType: "text/event-stream"},
+	{Method: "GET", Path: apiPrefix + "/stats/{code}/analytics", Summary: "Return the clicks and unique visitors of a link per hour or day, when ClickHouse is set up", Response: AnalyticsResponse{}, Params: []apiParam{
+		queryParam("from", "Start, RFC 3339 timestamp"),
+		queryParam("to", "End, RFC 3339 timestamp, now by default"),
+		enumQueryParam("interval", "Bucket size, hour by default", mapKeys(analyticsIntervals)),
+	}},
+	{Method: "GET", Path: apiPrefix + "/stats/{code}/analytics/{dimension}", Summary: "Return the clicks and unique visitors of a link per country or device type, when ClickHouse is set up", Response: AnalyticsBreakdownResponse{}, Params: []apiParam{
+		queryParam("from", "Start, RFC 3339 timestamp, 30 days before to by default"),
+		queryParam("to", "End, RFC 3339 timestamp, now by default"),
+		intQueryParam("limit", "Number of values, at most "+strconv.Itoa(maxBreakdownLimit)),
+	}},
+	{Method: "GET", Path: apiPrefix + "/get-link/{code}", Summary: "Return the destination of a link and count a click", Response: GetURLResponse{}},
+	{Method: "GET", Path: apiPrefix + "/preview/{code}", Summary: "Return the destination of a link with the metadata of the page, without counting a click", Response: PreviewResponse{}},
+	{Method: "GET", Path: apiPrefix + "/links", Summary: "List links", Response: ListLinksResponse{}, Params: []apiParam{
+		enumQueryParam("sort", "Field to order by, created_at by default", mapKeys(linkSortFields)),
+		enumQueryParam("order", "Direction, desc by default", []string{"asc", "desc"}),
+		intQueryParam("limit", "Page size, at most "+strconv.Itoa(maxListLimit)),
+		intQueryParam("offset", "Links to skip"),
+		queryParam("created_from", "RFC 3339 timestamp"),
+		queryParam("created_to", "RFC 3339 timestamp"),
+		intQueryParam("min_clicks", "Fewest clicks a listed link has"),
+		queryParam("tag", "Only list the links with this tag"),
+		intQueryParam("folder_id", "Only list the links filed in this folder"),
+		boolQueryParam("subfolders", "List the links of the folders nested in folder_id too"),
+		boolQueryParam("archived", "List the archived links instead of the live ones"),
+		boolQueryParam("deleted", "List the deleted links instead of the live ones"),
+	}},
+	{Method: "GET", Path: apiPrefix + "/links/top", Summary: "List the links with the most clicks in a window", Response: TrendingLinksResponse{}, Params: []apiParam{
+		enumQueryParam("window", "Window to rank by, 24h by default", mapKeys(trendingWindows)),
+		intQueryParam("limit", "Page size, at most "+strconv.Itoa(maxListLimit)),
+		intQueryParam("offset", "Links to skip"),
+	}},
+	{Method: "GET", Path: apiPrefix + "/links/broken", Summary: "List the links whose destination the health checker found dead", Response: ListLinksResponse{}, Params: []apiParam{
+		enumQueryParam("sort", "Field to order by, created_at by default", mapKeys(linkSortFields)),
+		enumQueryParam("order", "Direction, desc by default", []string{"asc", "desc"}),
+		intQueryParam("limit", "Page size, at most "+strconv.Itoa(maxListLimit)),
+		intQueryParam("offset", "Links to skip"),
+		queryParam("created_from", "RFC 3339 timestamp"),
+		queryParam("created_to", "RFC 3339 timestamp"),
+		intQueryParam("min_clicks", "Fewest clicks a listed link has"),
+		queryParam("tag", "Only list the links with this tag"),
+		intQueryParam("folder_id", "Only list the links filed in this folder"),
+		boolQueryParam("subfolders", "List the links of the folders nested in folder_id too"),
+		boolQueryParam("archived", "List the archived links instead of the live ones"),
+		boolQueryParam("deleted", "List the deleted links instead of the live ones"),
+	}},
+	{Method: "GET", Path: apiPrefix + "/links/search", Summary: "Find the links whose code, URL, title, description, notes or tags contain every word of a query, best matches first", Response: ListLinksResponse{}, Params: []apiParam{
+		queryParam("q", "Words to search for"),
+		intQueryParam("limit", "Page size, at most "+strconv.Itoa(maxListLimit)),
+		intQueryParam("offset", "Links to skip"),
+	}},
+	{Method: "POST", Path: apiPrefix + "/links/move", Summary: "Move links to a folder, or out of their folder with a folder_id of 0", Request: MoveLinksRequest{}, Response: MoveLinksResponse{}, Params: []apiParam{
+		queryParam("domain", domainParamDescription),
+	}},
+	{Method: "GET", Path: apiPrefix + "/tags", Summary: "List the tags of the caller's namespace with their link and click counts", Response: TagsResponse{}},
+	{Method: "GET", Path: apiPrefix + "/folders", Summary: "List the folders of the caller's namespace with their paths and link counts", Response: FoldersResponse{}},
+	{Method: "POST", Path: apiPrefix + "/folders", Summary: "Create a folder, nested in parent_id when set", Request: CreateFolderRequest{}, Response: Folder{}, Status: http.StatusCreated, Conflict: true},
+	{Method: "PATCH", Path: apiPrefix + "/folders/{id}", Summary: "Rename a folder or move it under another parent", Request: UpdateFolderRequest{}, Response: Folder{}, Conflict: true},
+	{Method: "DELETE", Path: apiPrefix + "/folders/{id}", Summary: "Delete a folder without links or subfolders", Status: http.StatusNoContent, Conflict: true},
+	{Method: "POST", Path: apiPrefix + "/campaigns", Summary: "Create a link with its own UTM parameters per variant of one destination", Request: CampaignRequest{}, Response: CampaignResponse{}, Status: http.StatusCreated, Conflict: true, Params: []apiParam{
+		queryParam("domain", domainParamDescription),
+	}},
+	{Method: "POST", Path: apiPrefix + "/routing-rules/validate", Summary: "Validate a set of routing rules and return them as a link would store them", Request: ValidateRoutingRulesRequest{}, Response: ValidateRoutingRulesResponse{}},
+	{Method: "POST", Path: apiPrefix + "/import", Summary: "Import code to URL mappings from a JSON array or a CSV body", Request: []ImportRow{}, Response: ImportResponse{}, Params: []apiParam{
+		enumQueryParam("on_conflict", "What happens to codes already in use, skip by default", []string{importSkip, importOverwrite, importError}),
+		queryParam("domain", domainParamDescription),
+	}},
+	{Method: "GET", Path: apiPrefix + "/export/links", Summary: "Stream links as CSV or NDJSON", ContentType: "text/csv", Params: []apiParam{
+		enumQueryParam("format", "Export format, negotiated from Accept when omitted", []string{exportFormatCSV, exportFormatNDJSON}),
+		queryParam("created_from", "RFC 3339 timestamp"),
+		queryParam("created_to", "RFC 3339 timestamp"),
+	}},
+	{Method: "GET", Path: apiPrefix + "/export/clicks", Summary: "Stream daily click counts as CSV or NDJSON", ContentType: "text/csv", Params: []apiParam{
+		enumQueryParam("format", "Export format, negotiated from Accept when omitted", []string{exportFormatCSV, exportFormatNDJSON}),
+		queryParam("code", "Only export the clicks of this link"),
+		queryParam("domain", domainParamDescription),
+		queryParam("from", "First day, YYYY-MM-DD"),
+		queryParam("to", "Last day, YYYY-MM-DD"),
+	}},
+	{Method: "PATCH", Path: apiPrefix + "/links/{code}", Summary: "Change the destination and settings of a link", Request: UpdateLinkRequest{}, Response: Link{}, Conflict: true},
+	{Method: "DELETE", Path: apiPrefix + "/links/{code}", Summary: "Delete a link", Status: http.StatusNoContent, Params: []apiParam{
+		enumQueryParam("clicks", "Whether the clicks are kept, retain by default", []string{"retain", "delete"}),
+	}},
+	{Method: "POST", Path: apiPrefix + "/links/{code}/archive", Summary: "Archive a link so that it stops redirecting while keeping its stats", Response: Link{}, Conflict: true},
+	{Method: "GET", Path: apiPrefix + "/links/{code}/history", Summary: "List the changes made to a link with who made them and the fields they changed, newest first", Response: LinkHistoryResponse{}, Params: []apiParam{
+		intQueryParam("limit", "Page size, at most "+strconv.Itoa(maxListLimit)),
+		intQueryParam("offset", "Events to skip"),
+	}},
+	{Method: "GET", Path: apiPrefix + "/links/{code}/aliases", Summary: "List the aliases of a link", Response: LinkAliasesResponse{}},
+	{Method: "POST", Path: apiPrefix + "/links/{code}/aliases", Summary: "Add an alias that redirects to the link and counts as its clicks", Request: AddLinkAliasRequest{}, Response: LinkAlias{}, Status: http.StatusCreated, Conflict: true},
+	{Method: "DELETE", Path: apiPrefix + "/links/{code}/aliases/{alias}", Summary: "Remove an alias from a link", Status: http.StatusNoContent},
+	{Method: "POST", Path: apiPrefix + "/links/{code}/restore", Summary: "Restore an archived link, or a deleted one within the restore window", Response: Link{}, Conflict: true},
+	{Method: "GET", Path: apiPrefix + "/auth/providers", Summary: "List the identity providers members may log in with", Response: IdentityProvidersResponse{}},
+	{Method: "GET", Path: apiPrefix + "/auth/{provider}/login", Summary: "Redirect to an identity provider to log in", Status: http.StatusFound, Params: []apiParam{
+		queryParam("org", "Slug of the organization to log in to, when the account belongs to several"),
+	}},
+	{Method: "GET", Path: apiPrefix + "/auth/{provider}/callback", Summary: "Finish logging in and issue a session token", Response: LoginResponse{}, Conflict: true, Params: []apiParam{
+		queryParam("code", "Authorization code from the identity provider"),
+		queryParam("state", "State the login was started with"),
+	}},
+	{Method: "POST", Path: apiPrefix + "/orgs", Summary: "Create an organization with its owner, with the admin key", Request: CreateOrganizationRequest{}, Response: CreateOrganizationResponse{}, Status: http.StatusCreated, Conflict: true},
+	{Method: "GET", Path: apiPrefix + "/org", Summary: "Return the caller's organization", Response: Organization{}},
+	{Method: "GET", Path: apiPrefix + "/org/members", Summary: "List the members of the caller's organization", Response: MembersResponse{}},
+	{Method: "POST", Path: apiPrefix + "/org/members", Summary: "Add a member to the caller's organization", Request: AddMemberRequest{}, Response: Member{}, Status: http.StatusCreated, Conflict: true},
+	{Method: "DELETE", Path: apiPrefix + "/org/members/{id}", Summary: "Remove a member", Status: http.StatusNoContent},
+	{Method: "GET", Path: apiPrefix + "/org/keys", Summary: "List the API keys of the caller's organization", Response: APIKeysResponse{}},
+	{Method: "POST", Path: apiPrefix + "/org/keys", Summary: "Issue an API key", Request: CreateAPIKeyRequest{}, Response: CreateAPIKeyResponse{}, Status: http.StatusCreated},
+	{Method: "DELETE", Path: apiPrefix + "/org/keys/{id}", Summary: "Revoke an API key", Status: http.StatusNoContent},
+	{Method: "POST", Path: apiPrefix + "/org/keys/{id}/signing-secret", Summary: "Issue a new secret to sign requests with an API key", Response: SigningSecretResponse{}, Status: http.StatusCreated},
+	{Method: "DELETE", Path: apiPrefix + "/org/keys/{id}/signing-secret", Summary: "Stop an API key from signing requests", Status: http.StatusNoContent},
+	{Method: "POST", Path: apiPrefix + "/org/keys/{id}/rotate", Summary: "Replace an API key, keeping the old one valid for a grace period", Request: RotateAPIKeyRequest{}, Response: RotateAPIKeyResponse{}, Status: http.StatusCreated},
+	{Method: "GET", Path: apiPrefix + "/org/domains", Summary: "List the custom domains of the caller's organization", Response: DomainsResponse{}},
+	{Method: "POST", Path: apiPrefix + "/org/domains", Summary: "Register a custom domain, answering with how to verify it", Request: CreateDomainRequest{}, Response: CustomDomain{}, Status: http.StatusCreated, Conflict: true},
+	{Method: "GET", Path: apiPrefix + "/org/domains/{id}", Summary: "Return a custom domain", Response: CustomDomain{}},
+	{Method: "DELETE", Path: apiPrefix + "/org/domains/{id}", Summary: "Remove a custom domain no links were issued under", Status: http.StatusNoContent, Conflict: true},
+	{Method: "POST", Path: apiPrefix + "/org/domains/{id}/verify", Summary: "Verify a custom domain through its TXT record or HTTP token", Response: CustomDomain{}, Conflict: true},
+	{Method: "GET", Path: apiPrefix + "/usage", Summary: "Report the caller's use of its monthly quotas", Response: UsageResponse{}, Params: []apiParam{
+		queryParam("month", "Month to report, YYYY-MM, the current one by default"),
+	}},
+	{Method: "GET", Path: apiPrefix + "/webhooks", Summary: "List webhooks", Response: WebhooksResponse{}},
+	{Method: "POST", Path: apiPrefix + "/webhooks", Summary: "Create a webhook", Request: CreateWebhookRequest{}, Response: CreateWebhookResponse{}, Status: http.StatusCreated},
+	{Method: "GET", Path: apiPrefix + "/webhooks/{id}", Summary: "Return a webhook", Response: Webhook{}},
+	{Method: "PATCH", Path: apiPrefix + "/webhooks/{id}", Summary: "Change a webhook", Request: UpdateWebhookRequest{}, Response: Webhook{}},
+	{Method: "DELETE", Path: apiPrefix + "/webhooks/{id}", Summary: "Delete a webhook", Status: http.StatusNoContent},
+	{Method: "GET", Path: apiPrefix + "/domain-rules", Summary: "List the stored domain rules, with the admin key", Response: DomainRulesResponse{}},
+	{Method: "POST", Path: apiPrefix + "/domain-rules", Summary: "Block or allow a domain, with the admin key", Request: CreateDomainRuleRequest{}, Response: DomainRule{}, Status: http.StatusCreated, Conflict: true},
+	{Method: "DELETE", Path: apiPrefix + "/domain-rules/{id}", Summary: "Delete a domain rule, with the admin key", Status: http.StatusNoContent},
+	{Method: "GET", Path: apiPrefix + "/audit", Summary: "List the changes made to the links of every namespace, newest first, with the admin key", Response: LinkHistoryResponse{}, Params: []apiParam{
+		intQueryParam("org_id", "Only list the changes in this namespace, 0 for the shared one"),
+		enumQueryParam("action", "Only list the changes of this kind", mapKeys(linkEventActions)),
+		queryParam("from", "RFC 3339 timestamp"),
+		queryParam("to", "RFC 3339 timestamp"),
+		intQueryParam("limit", "Page size, at most "+strconv.Itoa(maxListLimit)),
+		intQueryParam("offset", "Events to skip"),
+	}},
+	{Method: "POST", Path: apiPrefix + "/analytics/erase", Summary: "Erase the analytics of a link or a visitor, with the admin key", Request: EraseAnalyticsRequest{}, Response: EraseAnalyticsResponse{}},
+	{Method: "GET", Path: apiPrefix + "/admin/quarantine", Summary: "List the quarantined links awaiting review, with the admin key", Response: ListLinksResponse{}, Params: []apiParam{
+		queryParam("confirmed", "true for the links whose quarantine was confirmed"),
+		intQueryParam("limit", "Page size, at most "+strconv.Itoa(maxListLimit)),
+		intQueryParam("offset", "Links to skip"),
+	}},
+	{Method: "POST", Path: apiPrefix + "/admin/quarantine/{id}/release", Summary: "Lift the quarantine of a link, with the admin key", Response: Link{}},
+	{Method: "POST", Path: apiPrefix + "/admin/quarantine/{id}/confirm", Summary: "Keep a link in quarantine and take it off the review queue, with the admin key", Response: Link{}},
+	{Method: "GET", Path: apiPrefix + "/admin/links", Summary: "List the links of every organization, newest first, with the admin key", Response: AdminLinksResponse{}, Params: []apiParam{
+		queryParam("org", "Slug of the organization, empty for links created without one"),
+		queryParam("destination", "Destination domain, subdomains included"),
+		queryParam("flagged", "true for the quarantined links and those with open reports"),
+		queryParam("created_from", "RFC 3339 timestamp"),
+		queryParam("created_to", "RFC 3339 timestamp"),
+		intQueryParam("limit", "Page size, at most "+strconv.Itoa(maxListLimit)),
+		intQueryParam("offset", "Links to skip"),
+	}},
+	{Method: "POST", Path: apiPrefix + "/admin/links/{id}/disable", Summary: "Keep a link of any organization in quarantine, with the admin key", Request: DisableLinkRequest{}, Response: Link{}},
+	{Method: "POST", Path: apiPrefix + "/admin/banned-domains", Summary: "Block a destination domain and disable the links to it, with the admin key", Request: BanDomainRequest{}, Response: BanDomainResponse{}, Conflict: true},
+	{Method: "GET", Path: apiPrefix + "/admin/keys/activity", Summary: "Rank the API keys by the links they created, with the admin key", Response: KeyActivityResponse{}, Params: []apiParam{
+		queryParam("from", "RFC 3339 timestamp, a day before to by default"),
+		queryParam("to", "RFC 3339 timestamp, now by default"),
+		intQueryParam("limit", "Keys to return, at most "+strconv.Itoa(maxListLimit)),
+	}},
+	{Method: "GET", Path: apiPrefix + "/admin/reports", Summary: "List the links with reports awaiting review, most reported first, with the admin key", Response: ReportedLinksResponse{}, Params: []apiParam{
+		intQueryParam("limit", "Page size, at most "+strconv.Itoa(maxListLimit)),
+		intQueryParam("offset", "Links to skip"),
+	}},
+	{Method: "GET", Path: apiPrefix + "/admin/reports/{id}", Summary: "List the reports of a link, with the admin key", Response: LinkReportsResponse{}},
+	{Method: "POST", Path: apiPrefix + "/admin/reports/{id}/dismiss", Summary: "Close the open reports of a link without action, with the admin key", Response: Link{}},
+	{Method: "POST", Path: apiPrefix + "/admin/reports/{id}/disable", Summary: "Close the open reports of a link and keep it in quarantine, with the admin key", Response: Link{}},
+	{Method: "POST", Path: apiPrefix + "/admin/reports/{id}/whitelist", Summary: "Close the open reports of a link and take no further reports of it, with the admin key", Response: Link{}},
+	{Method: "GET", Path: domainVerificationPath, Summary: "Answer with the verification token of the custom domain the request came in on", ContentType: "text/plain"},
+	{Method: "POST", Path: "/o/{org}/report/{code}", Summary: "Report a link of an organization as malicious", Request: ReportLinkRequest{}, Response: ReportLinkResponse{}, Status: http.StatusAccepted, Form: true},
+	{Method: "POST", Path: "/report/{code}", Summary: "Report a link as malicious", Request: ReportLinkRequest{}, Response: ReportLinkResponse{}, Status: http.StatusAccepted, Form: true},
+	{Method: "GET", Path: "/o/{org}/{code}+", Summary: "Show where a link of an organization leads", ContentType: "text/html"},
+	{Method: "HEAD", Path: "/o/{org}/{code}+", Summary: "Check the page showing where a link of an organization leads", ContentType: "text/html"},
+	{Method: "GET", Path: "/o/{org}/{code}", Summary: "Redirect to the destination of a link of an organization", Status: http.StatusFound},
+	{Method: "HEAD", Path: "/o/{org}/{code}", Summary: "Answer with the destination of a link of an organization without counting a click", Status: http.StatusFound},
+	{Method: "GET", Path: "/{code}+", Summary: "Show where a link leads", ContentType: "text/html"},
+	{Method: "HEAD", Path: "/{code}+", Summary: "Check the page showing where a link leads", ContentType: "text/html"},
+	{Method: "GET", Path: "/{code}", Summary: "Redirect to the destination of a link", Status: http.StatusFound},
+	{Method: "HEAD", Path: "/{code}", Summary: "Answer with the destination of a link without counting a click", Status: http.StatusFound},
+}
+
+// newOpenAPIDocument builds the document served at /api/v1/openapi.json.
+func newOpenAPIDocument() map[string]interface{} {
+	schemas := &schemaRegistry{components: make(map[string]interface{})}
+	paths := make(map[string]map[string]interface{})
+
+	for _, op := range apiOperations {
+		if paths[op.Path] == nil {
+			paths[op.Path] = make(map[string]interface{})
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = op.document(schemas)
+	}
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":   "wowee-link API",
+			"version": "1.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+		// Requests without a key act in the shared namespace.
+		"security": []map[string][]string{{}, {"apiKey": {}}, {"bearer": {}}},
+	}
+}
+
+func (op apiOperation) document(schemas *schemaRegistry) map[string]interface{} {
+	var parameters []map[string]interface{}
+
+	for _, segment := range strings.Split(op.Path, "/") {
+		if strings.HasPrefix(segment, "{") {
+			name := strings.TrimSuffix(strings.TrimPrefix(segment, "{"), "}+")
+			name = strings.TrimSuffix(name, "}")
+
+			kind := "string"
+			if name == "id" {
+				kind = "integer"
+			}
+
+			parameters = append(parameters, map[string]interface{}{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": kind},
+			})
+
+			// Codes of the API are looked up in the code space of the
+			// domain parameter, which DomainMiddleware resolves.
+			if name == "code" && strings.HasPrefix(op.Path, apiPrefix) {
+				parameters = append(parameters, map[string]interface{}{
+					"name":        "domain",
+					"in":          "query",
+					"description": domainParamDescription,
+					"schema":      map[string]interface{}{"type": "string"},
+				})
+			}
+		}
+	}
+
+	for _, param := range op.Params {
+		schema := map[string]interface{}{"type": param.Type}
+		if len(param.Enum) > 0 {
+			schema["enum"] = param.Enum
+		}
+
+		in := "query"
+		if param.Header {
+			in = "header"
+		}
+
+		parameters = append(parameters, map[string]interface{}{
+			"name":        param.Name,
+			"in":          in,
+			"description": param.Description,
+			"schema":      schema,
+		})
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	switch {
+	case op.Response != nil:
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schemas.schema(reflect.TypeOf(op.Response))},
+		}
+	case op.ContentType != "":
+		success["content"] = map[string]interface{}{op.ContentType: map[string]interface{}{}}
+	}
+
+	responses := map[string]interface{}{
+		strconv.Itoa(status): success,
+		"default": map[string]interface{}{
+			"description": "The error",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schemas.schema(reflect.TypeOf(ErrorResponse{}))},
+			},
+		},
+	}
+
+	if op.Conflict {
+		responses[strconv.Itoa(http.StatusConflict)] = map[string]interface{}{
+			"description": "The request conflicts with an existing resource",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schemas.schema(reflect.TypeOf(ErrorResponse{}))},
+			},
+		}
+	}
+
+	operation := map[string]interface{}{
+		"summary":   op.Summary,
+		"responses": responses,
+	}
+
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+
+	if op.Request != nil {
+		schema := schemas.schema(reflect.TypeOf(op.Request))
+		content := map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schema},
+		}
+		if op.Form {
+			content["application/x-www-form-urlencoded"] = map[string]interface{}{"schema": schema}
+		}
+
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  content,
+		}
+	}
+
+	return operation
+}
+
+// schemaRegistry derives schemas from Go types. Named structs become
+// components referenced by name.
+type schemaRegistry struct {
+	components map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (s *schemaRegistry) schema(t reflect.Type) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := s.schema(t.Elem())
+		if _, ok := schema["$ref"]; ok {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+
+		if _, ok := s.components[t.Name()]; !ok {
+			// Placeholder first, so that recursive types terminate.
+			s.components[t.Name()] = nil
+			s.components[t.Name()] = s.object(t)
+		}
+
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func (s *schemaRegistry) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	s.addFields(t, properties)
+
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// addFields follows encoding/json: embedded structs without a tag have
+// their fields promoted, and fields tagged "-" are left out.
+func (s *schemaRegistry) addFields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			s.addFields(field.Type, properties)
+			continue
+		}
+
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = s.schema(field.Type)
+	}
+}
+
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// checkOpenAPIRoutes logs the routes of router that apiOperations does not
+// document, so that a route added without documentation shows up at
+// startup.
+func checkOpenAPIRoutes(router *mux.Router) {
+	documented := make(map[string]bool)
+	for _, op := range apiOperations {
+		documented[op.Method+" "+op.Path] = true
+	}
+
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		for _, method := range methods {
+			if !documented[method+" "+path] {
+				slog.Warn("Route is missing from the OpenAPI document", "method", method, "path", path)
+			}
+		}
+
+		return nil
+	})
+}
+
+// OpenAPIHandler serves the OpenAPI document, built once at startup.
+func OpenAPIHandler() http.HandlerFunc {
+	document, err := json.Marshal(newOpenAPIDocument())
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(document)
+	}
+}
+
+// SwaggerUIHandler serves Swagger UI for the OpenAPI document. The UI itself is
+// loaded from a CDN.
+func SwaggerUIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(swaggerPage)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	pageFetchTimeout = 5 * time.Second
+	pageDialTimeout  = 3 * time.Second
+	maxPageRedirects = 5
+	// maxPageBytes bounds how much of a page is read. Metadata lives in the
+	// head, which rarely comes close.
+	maxPageBytes = 512 << 10
+
+	maxPageTitleLength       = 300
+	maxPageDescriptionLength = 1000
+)
+
+var errPrivateAddress = errors.New("destination resolves to a non-public address")
+
+// pageClient fetches destination pages on behalf of users. It only
+// connects to public addresses, after DNS resolution and on every
+// redirect, so that a link cannot be used to reach the internal network.
+var pageClient = &http.Client{
+	Timeout: pageFetchTimeout,
+	Transport: tracedTransport(&http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: pageDialTimeout,
+			Control: dialPublicOnly,
+		}).DialContext,
+		TLSHandshakeTimeout:   pageDialTimeout,
+		ResponseHeaderTimeout: pageFetchTimeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}),
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxPageRedirects {
+			return errors.New("too many redirects")
+		}
+		return nil
+	},
+}
+
+var (
+	pageTitlePattern     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	pageTagPattern       = regexp.MustCompile(`(?is)<(meta|link)\s[^>]*>`)
+	pageAttributePattern = regexp.MustCompile(`(?s)([a-zA-Z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	pageSpacePattern     = regexp.MustCompile(`\s+`)
+)
+
+// PageMetadata is what a preview shows of a destination page. Image and
+// Favicon are absolute URLs.
+type PageMetadata struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	Favicon     string `json:"favicon"`
+}
+
+// PreviewLinkHandler returns the destination of a code together with the
+// metadata of the page it points to, without counting a click. When the
+// page cannot be fetched the metadata is left empty.
+func PreviewLinkHandler(links LinkStore, cache LinkCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		code := vars["code"]
+		var startTime = time.Now()
+
+		link, err := lookupLink(r.Context(), links, cache, orgIDFromContext(r.Context()), domainIDFromContext(r.Context()), code)
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		if link.DeletedAt != nil {
+			writeErrorCode(w, http.StatusGone, "link_deleted", "Link has been deleted", nil)
+			return
+		}
+
+		if link.ArchivedAt != nil {
+			writeErrorCode(w, http.StatusGone, "link_archived", "Link has been archived", nil)
+			return
+		}
+
+		if link.ExpiresAt != nil && !link.ExpiresAt.After(time.Now()) {
+			writeErrorCode(w, http.StatusGone, "link_expired", "Link has expired", nil)
+			return
+		}
+
+		metadata, err := fetchPageMetadata(r.Context(), link.URL)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Error fetching link preview", "error", err, "code", code)
+		}
+
+		response := PreviewResponse{
+			Code:         link.Code,
+			URL:          link.URL,
+			PageMetadata: metadata,
+			ElapsedTime:  time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// fetchPageMetadata downloads the head of an HTML page and extracts its
+// metadata. Pages that are not HTML have none.
+func fetchPageMetadata(ctx context.Context, pageURL string) (PageMetadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return PageMetadata{}, err
+	}
+
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return PageMetadata{}, errors.New("destination is not an http or https URL")
+	}
+
+	req.Header.Set("User-Agent", "wowee-link-preview")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := pageClient.Do(req)
+	if err != nil {
+		return PageMetadata{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return PageMetadata{}, errors.New("destination responded with " + resp.Status)
+	}
+
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return PageMetadata{}, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageBytes))
+	if err != nil {
+		return PageMetadata{}, err
+	}
+
+	// Relative URLs resolve against the page redirects ended on.
+	return parsePageMetadata(string(body), resp.Request.URL), nil
+}
+
+// parsePageMetadata prefers Open Graph and Twitter card tags over the
+// plain title and description, and falls back to /favicon.ico.
+func parsePageMetadata(page string, base *url.URL) PageMetadata {
+	var metadata PageMetadata
+	var title, description string
+
+	if match := pageTitlePattern.FindStringSubmatch(page); match != nil {
+		title = html.UnescapeString(match[1])
+	}
+
+	for _, tag := range pageTagPattern.FindAllStringSubmatch(page, -1) {
+		attributes := parsePageAttributes(tag[0])
+
+		if strings.EqualFold(tag[1], "link") {
+			rel := strings.Fields(strings.ToLower(attributes["rel"]))
+			for _, value := range rel {
+				if value == "icon" && metadata.Favicon == "" {
+					metadata.Favicon = resolvePageURL(base, attributes["href"])
+				}
+			}
+			continue
+		}
+
+		name := strings.ToLower(attributes["property"])
+		if name == "" {
+			name = strings.ToLower(attributes["name"])
+		}
+		content := attributes["content"]
+
+		switch name {
+		case "og:title", "twitter:title":
+			if metadata.Title == "" {
+				metadata.Title = content
+			}
+		case "og:description", "twitter:description":
+			if metadata.Description == "" {
+				metadata.Description = content
+			}
+		case "description":
+			description = content
+		case "og:image", "og:image:url", "twitter:image":
+			if metadata.Image == "" {
+				metadata.Image = resolvePageURL(base, content)
+			}
+		}
+	}
+
+	if metadata.Title == "" {
+		metadata.Title = title
+	}
+	if metadata.Description == "" {
+		metadata.Description = description
+	}
+	if metadata.Favicon == "" {
+		metadata.Favicon = resolvePageURL(base, "/favicon.ico")
+	}
+
+	metadata.Title = cleanPageText(metadata.Title, maxPageTitleLength)
+	metadata.Description = cleanPageText(metadata.Description, maxPageDescriptionLength)
+
+	return metadata
+}
+
+func parsePageAttributes(tag string) map[string]string {
+	attributes := make(map[string]string)
+
+	for _, match := range pageAttributePattern.FindAllStringSubmatch(tag, -1) {
+		name := strings.ToLower(match[1])
+		if _, ok := attributes[name]; !ok {
+			attributes[name] = html.UnescapeString(match[2] + match[3] + match[4])
+		}
+	}
+
+	return attributes
+}
+
+// resolvePageURL makes ref absolute and drops anything that is not http or
+// https, such as data: and javascript: URLs.
+func resolvePageURL(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+
+	return u.String()
+}
+
+// cleanPageText collapses the whitespace of text and cuts it to maxLength
+// runes.
+func cleanPageText(text string, maxLength int) string {
+	text = strings.TrimSpace(pageSpacePattern.ReplaceAllString(text, " "))
+
+	if runes := []rune(text); len(runes) > maxLength {
+		text = string(runes[:maxLength])
+	}
+
+	return text
+}
+
+// dialPublicOnly refuses connections to loopback, private, link-local and
+// other non-public addresses. It runs after resolution, so hostnames that
+// resolve to internal addresses are refused too.
+func dialPublicOnly(network string, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return errPrivateAddress
+	}
+
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which IsPrivate does
+// not cover.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// purgeClickEvents removes click events older than retention. The daily,
+// referrer, country and device counters hold no personal data and are
+// kept.
+func purgeClickEvents(clicks ClickStore, retention time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purged, err := clicks.PurgeClickEvents(context.Background(), time.Now().Add(-retention))
+		if err != nil {
+			slog.Error("Error purging click events", "error", err)
+			continue
+		}
+
+		if purged > 0 {
+			slog.Info("Purged click events", "count", purged)
+		}
+	}
+}
+
+// EraseAnalyticsHandler erases analytics on request. For a link every
+// click is removed and its counts are reset; for a visitor their click
+// events are removed, since the counters cannot be traced back to them.
+// It requires the admin key.
+func EraseAnalyticsHandler(links LinkStore, orgs OrgStore, clicks ClickStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		if !isAdminAPIKey(apiKeyFromRequest(r)) {
+			writeError(w, http.StatusForbidden, "Admin API key required")
+			return
+		}
+
+		var request EraseAnalyticsRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeInvalidBody(w, err)
+			return
+		}
+
+		byLink := request.Code != ""
+		byVisitor := request.IP != "" || request.IPHash != ""
+		if byLink == byVisitor || request.IP != "" && request.IPHash != "" {
+			writeError(w, http.StatusBadRequest, "Exactly one of code, ip or ip_hash is required")
+			return
+		}
+
+		var erased int64
+		var err error
+		if byLink {
+			orgID := 0
+			if request.Org != "" {
+				org, err := orgs.GetOrganizationBySlug(r.Context(), request.Org)
+				if err != nil {
+					if err == ErrOrgNotFound {
+						writeError(w, http.StatusNotFound, "Organization not found")
+					} else {
+						slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+						writeError(w, http.StatusInternalServerError, "Internal Server Error")
+					}
+					return
+				}
+				orgID = org.ID
+			}
+
+			var domainID int
+			domainID, err = resolveDomainID(r.Context(), orgs, orgID, request.Domain)
+			if err != nil {
+				if err == ErrDomainNotFound || err == errDomainNotVerified {
+					writeError(w, http.StatusNotFound, "Domain not found")
+				} else {
+					slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+					writeError(w, http.StatusInternalServerError, "Internal Server Error")
+				}
+				return
+			}
+
+			var link Link
+			link, err = links.GetLink(r.Context(), orgID, domainID, request.Code)
+			if err != nil {
+				if err == ErrNotFound {
+					writeError(w, http.StatusNotFound, "Link not found")
+				} else {
+					slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+					writeError(w, http.StatusInternalServerError, "Internal Server Error")
+				}
+				return
+			}
+
+			erased, err = clicks.EraseLinkClicks(r.Context(), link.ID)
+		} else {
+			ipHash := strings.ToLower(request.IPHash)
+			if request.IP != "" {
+				ipHash = *hashIP(request.IP)
+			} else if !isHexSHA256(ipHash) {
+				writeError(w, http.StatusBadRequest, "ip_hash must be a hex SHA-256")
+				return
+			}
+
+			erased, err = clicks.EraseVisitorClicks(r.Context(), ipHash)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error erasing analytics", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := EraseAnalyticsResponse{
+			ErasedEvents: erased,
+			ElapsedTime:  time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+func isHexSHA256(value string) bool {
+	decoded, err := hex.DecodeString(value)
+	return err == nil && len(decoded) == 32
+}
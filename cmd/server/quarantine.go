@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	scanBufferSize = 1000
+	scanWorkers    = 2
+	scanTimeout    = 30 * time.Second
+)
+
+// LinkQuarantine is set on the links a DestinationScanner flagged. While
+// QuarantinedAt is set the link shows a warning page instead of
+// redirecting. QuarantineReviewedAt is when an admin confirmed the
+// quarantine; released links have all three cleared.
+type LinkQuarantine struct {
+	QuarantinedAt        *time.Time `db:"quarantined_at" json:"quarantined_at,omitempty"`
+	QuarantineReason     *string    `db:"quarantine_reason" json:"quarantine_reason,omitempty"`
+	QuarantineReviewedAt *time.Time `db:"quarantine_reviewed_at" json:"quarantine_reviewed_at,omitempty"`
+}
+
+func (q LinkQuarantine) quarantined() bool {
+	return q.QuarantinedAt != nil
+}
+
+// DestinationScanner is a second opinion on a destination, asked after a
+// link is created as it may take a while. Unlike the URLChecker it never
+// holds up a request.
+type DestinationScanner interface {
+	Name() string
+	Scan(ctx context.Context, url string) (URLVerdict, error)
+}
+
+// LinkScanner runs the configured DestinationScanners over the
+// destinations of new and changed links in the background, and
+// quarantines the links one of them flags. Links are queued on a buffered
+// channel like titles are fetched.
+type LinkScanner struct {
+	links    QuarantineStore
+	cache    LinkCache
+	webhooks *WebhookDispatcher
+	scanners []DestinationScanner
+	jobs     chan Link
+	stop     chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewLinkScanner returns nil when no scanner is configured.
+func NewLinkScanner(links QuarantineStore, cache LinkCache, webhooks *WebhookDispatcher, scanners []DestinationScanner) *LinkScanner {
+	if len(scanners) == 0 {
+		return nil
+	}
+
+	scanner := &LinkScanner{
+		links:    links,
+		cache:    cache,
+		webhooks: webhooks,
+		scanners: scanners,
+		jobs:     make(chan Link, scanBufferSize),
+		stop:     make(chan struct{}),
+	}
+
+	scanner.wg.Add(scanWorkers)
+	for i := 0; i < scanWorkers; i++ {
+		go scanner.run()
+	}
+
+	return scanner
+}
+
+// Scan queues link to have its destinations scanned. It never blocks:
+// when the buffer is full the link goes unscanned. A nil LinkScanner
+// scans nothing.
+func (s *LinkScanner) Scan(link Link) {
+	if s == nil {
+		return
+	}
+
+	select {
+	case s.jobs <- link:
+	default:
+		slog.Warn("Scan buffer is full, skipping link", "link_id", link.ID)
+	}
+}
+
+// Close waits for the scans in flight and drops the queued ones.
+func (s *LinkScanner) Close() {
+	if s == nil {
+		return
+	}
+
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	s.wg.Wait()
+}
+
+func (s *LinkScanner) run() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case link := <-s.jobs:
+			s.scan(link)
+		}
+	}
+}
+
+func (s *LinkScanner) scan(link Link) {
+	ctx, cancel := context.WithTimeout(context.Background(), scanTimeout)
+	defer cancel()
+
+	for _, destination := range linkURLs(link) {
+		for _, scanner := range s.scanners {
+			verdict, err := scanner.Scan(ctx, destination)
+			if err != nil {
+				slog.Warn("Error scanning link destination", "error", err, "scanner", scanner.Name(), "link_id", link.ID)
+				continue
+			}
+			if !verdict.Unsafe {
+				continue
+			}
+
+			s.quarantine(ctx, link, scanner.Name()+": "+verdict.Threat)
+			return
+		}
+	}
+}
+
+func (s *LinkScanner) quarantine(ctx context.Context, link Link, reason string) {
+	err := s.links.QuarantineLink(ctx, link.ID, link.URL, reason)
+	if err == ErrNotFound {
+		return
+	}
+	if err != nil {
+		slog.Error("Error quarantining link", "error", err, "link_id", link.ID)
+		return
+	}
+
+	slog.Info("Link quarantined", "link_id", link.ID, "reason", reason)
+	s.cache.Delete(ctx, link.OrgID, link.DomainID, link.Code)
+
+	data := newWebhookEventData(link)
+	data.Reason = &reason
+	s.webhooks.Emit(link.OrgID, webhookLinkQuarantined, data)
+}
+
+// linkURLs are the URLs a stored link may send visits to.
+func linkURLs(link Link) []string {
+	urls := []string{link.URL}
+	for _, destination := range link.Destinations {
+		if destination.URL != link.URL {
+			urls = append(urls, destination.URL)
+		}
+	}
+	for _, target := range link.GeoTargets {
+		urls = append(urls, target.URL)
+	}
+	for _, target := range link.DeviceTargets {
+		urls = append(urls, target.URL)
+	}
+	for _, rule := range link.RoutingRules {
+		urls = append(urls, rule.URL)
+	}
+	if link.FallbackURL != nil {
+		urls = append(urls, *link.FallbackURL)
+	}
+
+	return urls
+}
+
+// renderQuarantine answers 403 with the warning page of a quarantined
+// link, which names the destination but offers no way to continue to it.
+func renderQuarantine(w http.ResponseWriter, r *http.Request, link Link) {
+	threat := "quarantined"
+	if link.QuarantineReason != nil {
+		threat = *link.QuarantineReason
+	}
+
+	renderInterstitialPage(w, r, interstitialData{
+		Code:    link.Code,
+		URL:     link.URL,
+		Verdict: "unsafe",
+		Threat:  threat,
+	}, http.StatusForbidden)
+}
+
+// ListQuarantinedLinksHandler is the review queue of the admin: the links
+// awaiting review, or with ?confirmed=true those kept in quarantine.
+func ListQuarantinedLinksHandler(links QuarantineStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+		params := r.URL.Query()
+
+		limit, err := parseIntParam(params.Get("limit"), defaultListLimit)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+
+		offset, err := parseIntParam(params.Get("offset"), 0)
+		if err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+
+		page, total, err := links.ListQuarantinedLinks(r.Context(), params.Get("confirmed") == "true", limit, offset)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := ListLinksResponse{
+			Links:       page,
+			Total:       total,
+			Limit:       limit,
+			Offset:      offset,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// ReleaseLinkHandler lifts the quarantine of the link with the ID in the
+// path, which redirects again.
+func ReleaseLinkHandler(links QuarantineStore, cache LinkCache) http.HandlerFunc {
+	return reviewQuarantineHandler(links.ReleaseLink, cache)
+}
+
+// ConfirmQuarantineHandler keeps the link with the ID in the path in
+// quarantine and takes it off the review queue.
+func ConfirmQuarantineHandler(links QuarantineStore, cache LinkCache) http.HandlerFunc {
+	return reviewQuarantineHandler(links.ConfirmQuarantine, cache)
+}
+
+func reviewQuarantineHandler(review func(ctx context.Context, linkID int) (Link, error), cache LinkCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Link not found")
+			return
+		}
+
+		link, err := review(r.Context(), id)
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link is not quarantined")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		cache.Delete(r.Context(), link.OrgID, link.DomainID, link.Code)
+
+		link.ElapsedTime = time.Since(startTime).Milliseconds()
+		jsonResponse, err := json.Marshal(link)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+)
+
+const usageMonthFormat = "2006-01"
+
+// Quota meters one usage metric per calendar month (UTC) and rejects
+// requests once Limit is used up. Shortens count against the organization
+// API key, or the member of the session token, that created the link, and
+// only once the link was actually created. Redirects count against the
+// organization owning the link, as its visitors have neither. A zero Limit
+// only meters. Shortens by callers without a key or session are not
+// metered, and neither are visits of links of the shared namespace; the
+// rate limiter covers them.
+type Quota struct {
+	usage  UsageStore
+	metric string
+	Limit  int
+}
+
+// NewQuota reads the monthly limit for scope from
+// QUOTA_<SCOPE>_PER_MONTH.
+func NewQuota(cfg *config.Config, usage UsageStore, metric string, scope string) *Quota {
+	return &Quota{usage: usage, metric: metric, Limit: cfg.Int("QUOTA_" + scope + "_PER_MONTH")}
+}
+
+// consume counts n units against the key or session of the request and
+// sets the quota headers. Once the quota is exhausted it answers 429 and
+// returns false. Store errors fail open, like the rate limiter.
+func (q *Quota) consume(w http.ResponseWriter, r *http.Request, n int) bool {
+	meter, ok := usageMeterFromContext(r.Context())
+	if !ok {
+		return true
+	}
+
+	used, allowed, err := q.use(r.Context(), meter, n, q.Limit)
+	if err != nil {
+		return true
+	}
+
+	q.writeHeaders(w, used)
+
+	if !allowed {
+		writeErrorCode(w, http.StatusTooManyRequests, "quota_exceeded", "Monthly quota exceeded", nil)
+		return false
+	}
+
+	return true
+}
+
+// charge counts n units of work already done against the key or session
+// of the request, even past the limit, and sets the quota headers.
+func (q *Quota) charge(w http.ResponseWriter, r *http.Request, n int) {
+	meter, ok := usageMeterFromContext(r.Context())
+	if !ok {
+		return
+	}
+
+	if used, _, err := q.use(r.Context(), meter, n, 0); err == nil {
+		q.writeHeaders(w, used)
+	}
+}
+
+// exhausted reports whether the key or session of ctx used up the quota
+// this month, without using any of it. Store errors fail open.
+func (q *Quota) exhausted(ctx context.Context) bool {
+	meter, ok := usageMeterFromContext(ctx)
+	if !ok || q.Limit == 0 {
+		return false
+	}
+
+	usage, err := q.usage.MeterUsage(ctx, meter, time.Now().UTC().Format(usageMonthFormat))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error metering usage", "error", err)
+		return false
+	}
+
+	return usageOf(usage, q.metric) >= q.Limit
+}
+
+// visit counts a visit of link against the organization owning it and
+// reports whether its quota allowed the visit. No quota headers are sent,
+// as the visitor is not the one metered.
+func (q *Quota) visit(ctx context.Context, link Link) bool {
+	if link.OrgID == 0 {
+		return true
+	}
+
+	_, allowed, err := q.use(ctx, UsageMeter{OrgID: link.OrgID}, 1, q.Limit)
+	return err != nil || allowed
+}
+
+// use is ConsumeUsage for the current month. Errors are logged here, so
+// that callers only need to fail open.
+func (q *Quota) use(ctx context.Context, meter UsageMeter, n int, limit int) (int, bool, error) {
+	used, allowed, err := q.usage.ConsumeUsage(ctx, meter, time.Now().UTC().Format(usageMonthFormat), q.metric, n, limit)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error metering usage", "error", err)
+	}
+
+	return used, allowed, err
+}
+
+func (q *Quota) writeHeaders(w http.ResponseWriter, used int) {
+	if q.Limit == 0 {
+		return
+	}
+
+	w.Header().Set("X-Quota-Limit", strconv.Itoa(q.Limit))
+	w.Header().Set("X-Quota-Remaining", strconv.Itoa(max(q.Limit-used, 0)))
+	w.Header().Set("X-Quota-Reset", strconv.FormatInt(nextUsageMonth(time.Now().UTC()).Unix(), 10))
+}
+
+// usageMeterFromContext returns what the usage of the request counts
+// against: its organization key, or the member of its session. Requests
+// with neither, such as those with a stats share token, are not metered.
+func usageMeterFromContext(ctx context.Context) (UsageMeter, bool) {
+	c, ok := callerFromContext(ctx)
+	switch {
+	case !ok:
+		return UsageMeter{}, false
+	case c.KeyID != 0:
+		return UsageMeter{KeyID: c.KeyID}, true
+	case c.MemberID != 0:
+		return UsageMeter{MemberID: c.MemberID}, true
+	default:
+		return UsageMeter{}, false
+	}
+}
+
+// UsageHandler reports how much of the shorten quota the caller's API
+// key, or session, and of the redirect quota the caller's organization
+// used in the current month, or in the month given as ?month=YYYY-MM.
+func UsageHandler(usage UsageStore, shortens *Quota, redirects *Quota) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		c, ok := requireRole(w, r, roleMember)
+		if !ok {
+			return
+		}
+		meter, _ := usageMeterFromContext(r.Context())
+
+		month := time.Now().UTC()
+		if value := r.URL.Query().Get("month"); value != "" {
+			var err error
+			month, err = time.Parse(usageMonthFormat, value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "month must be a month such as 2006-01")
+				return
+			}
+		}
+
+		keyUsage, err := usage.MeterUsage(r.Context(), meter, month.Format(usageMonthFormat))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		orgUsage, err := usage.MeterUsage(r.Context(), UsageMeter{OrgID: c.OrgID}, month.Format(usageMonthFormat))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := UsageResponse{
+			Month:       month.Format(usageMonthFormat),
+			Shortens:    quotaUsage(keyUsage.Shortens, shortens.Limit),
+			Redirects:   quotaUsage(orgUsage.Redirects, redirects.Limit),
+			ResetsAt:    nextUsageMonth(month),
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+func quotaUsage(used int, limit int) QuotaUsage {
+	usage := QuotaUsage{Used: used, Limit: limit}
+	if limit > 0 {
+		remaining := max(limit-used, 0)
+		usage.Remaining = &remaining
+	}
+
+	return usage
+}
+
+// nextUsageMonth returns the start of the month after t.
+func nextUsageMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimit is a token bucket holding up to PerMinute tokens that refills
+// at PerMinute tokens per minute. A zero PerMinute disables the limit.
+type RateLimit struct {
+	PerMinute int
+}
+
+func (l RateLimit) refillPerSecond() float64 {
+	return float64(l.PerMinute) / 60
+}
+
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until the next token is available.
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again.
+	ResetAfter time.Duration
+}
+
+// RateLimitStore takes one token from the bucket identified by key.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// NewRateLimitStore picks the backing store from RATE_LIMIT_STORE. The
+// redis store is required when several instances serve the same traffic.
+func NewRateLimitStore(cfg *config.Config, redisClient *redis.Client) (RateLimitStore, error) {
+	switch cfg.String("RATE_LIMIT_STORE") {
+	case "memory":
+		return NewMemoryRateLimitStore(), nil
+	case "redis":
+		if redisClient == nil {
+			return nil, errors.New("RATE_LIMIT_STORE=redis requires REDIS_URL")
+		}
+		return &RedisRateLimitStore{client: redisClient}, nil
+	default:
+		return nil, errors.New("RATE_LIMIT_STORE must be memory or redis")
+	}
+}
+
+// RateLimiter enforces a per-IP and, when the request carries an API key,
+// a per-key limit on the handlers it wraps.
+type RateLimiter struct {
+	store     RateLimitStore
+	scope     string
+	perIP     RateLimit
+	perAPIKey RateLimit
+}
+
+// NewRateLimiter reads the limits for scope from
+// RATE_LIMIT_<SCOPE>_PER_IP and RATE_LIMIT_<SCOPE>_PER_KEY.
+func NewRateLimiter(cfg *config.Config, store RateLimitStore, scope string) *RateLimiter {
+	prefix := "RATE_LIMIT_" + scope
+
+	return &RateLimiter{
+		store:     store,
+		scope:     scope,
+		perIP:     RateLimit{PerMinute: cfg.Int(prefix + "_PER_IP")},
+		perAPIKey: RateLimit{PerMinute: cfg.Int(prefix + "_PER_KEY")},
+	}
+}
+
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var results []RateLimitResult
+
+		if l.perIP.PerMinute > 0 {
+			result, err := l.store.Take(r.Context(), l.scope+":ip:"+clientIP(r), l.perIP)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error checking rate limit", "error", err)
+			} else {
+				results = append(results, result)
+			}
+		}
+
+		if key := apiKeyFromRequest(r); key != "" && l.perAPIKey.PerMinute > 0 {
+			result, err := l.store.Take(r.Context(), l.scope+":key:"+key, l.perAPIKey)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error checking rate limit", "error", err)
+			} else {
+				results = append(results, result)
+			}
+		}
+
+		// Store errors fail open: an unavailable limiter must not take the
+		// service down with it.
+		if len(results) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		strictest := results[0]
+		for _, result := range results[1:] {
+			if !result.Allowed || (strictest.Allowed && result.Remaining < strictest.Remaining) {
+				strictest = result
+			}
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(strictest.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(strictest.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(strictest.ResetAfter).Unix(), 10))
+
+		if !strictest.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(strictest.RetryAfter.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "Too Many Requests")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bucketResult converts the token count left after a take into the
+// values reported to clients.
+func bucketResult(allowed bool, tokens float64, limit RateLimit) RateLimitResult {
+	rate := limit.refillPerSecond()
+
+	result := RateLimitResult{
+		Allowed:    allowed,
+		Limit:      limit.PerMinute,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: time.Duration((float64(limit.PerMinute) - tokens) / rate * float64(time.Second)),
+	}
+	if tokens < 1 {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+
+	return result
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// MemoryRateLimitStore keeps buckets in process memory. Buckets that have
+// refilled completely are dropped by a periodic sweep.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	store := &MemoryRateLimitStore{buckets: make(map[string]*memoryBucket)}
+
+	go store.sweep(time.Minute)
+
+	return store
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	capacity := float64(limit.PerMinute)
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: capacity, updatedAt: now}
+		s.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*limit.refillPerSecond())
+	bucket.updatedAt = now
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+
+	return bucketResult(allowed, bucket.tokens, limit), nil
+}
+
+func (s *MemoryRateLimitStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		for key, bucket := range s.buckets {
+			// Every limit refills within a minute, so a bucket idle for
+			// longer is full and equivalent to a missing one.
+			if time.Since(bucket.updatedAt) > time.Minute {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// takeTokenScript refills and takes from a bucket atomically. It returns
+// whether the take succeeded and the tokens left as a string, since Redis
+// truncates Lua numbers to integers.
+var takeTokenScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated_at")
+local tokens = tonumber(bucket[1])
+local updated_at = tonumber(bucket[2])
+if tokens == nil then
+	tokens = capacity
+	updated_at = now
+end
+
+tokens = math.min(capacity, tokens + (now - updated_at) / 1000 * rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated_at", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(capacity / rate * 1000))
+
+return {allowed, tostring(tokens)}
+`)
+
+// RedisRateLimitStore shares buckets between instances through Redis.
+type RedisRateLimitStore struct {
+	client *redis.Client
+}
+
+func (s *RedisRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	args := []interface{}{limit.PerMinute, limit.refillPerSecond(), time.Now().UnixMilli()}
+
+	values, err := takeTokenScript.Run(ctx, s.client, []string{"ratelimit:" + key}, args...).Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	if len(values) != 2 {
+		return RateLimitResult{}, errors.New("unexpected rate limit script result")
+	}
+
+	allowed, _ := values[0].(int64)
+	tokensValue, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(tokensValue, 64)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	return bucketResult(allowed == 1, tokens, limit), nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"google.golang.org/grpc"
+)
+
+// panicsRecovered counts the requests whose handler panicked. It is
+// published through expvar as panics_recovered.
+var panicsRecovered = expvar.NewInt("panics_recovered")
+
+// RecoveryMiddleware turns a panicking handler into a logged 500, so one
+// bad request does not drop the connection. It must run inside
+// RequestIDMiddleware for the log record to carry the request ID.
+// http.ErrAbortHandler is passed on, as net/http uses it to abort a
+// response on purpose.
+func RecoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			logPanic(r.Context(), recovered, "method", r.Method, "path", r.URL.Path)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// grpcRecoveryInterceptor is RecoveryMiddleware for gRPC calls, which
+// fail with INTERNAL instead.
+func grpcRecoveryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logPanic(ctx, recovered, "method", info.FullMethod)
+			resp, err = nil, errGRPCInternal
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+func logPanic(ctx context.Context, recovered interface{}, args ...interface{}) {
+	panicsRecovered.Add(1)
+
+	args = append(args, "error", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+	slog.ErrorContext(ctx, "Recovered from panic", args...)
+}
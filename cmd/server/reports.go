@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxReportDetailsLength = 2000
+
+	// Resolutions of the reports of a link. Disabling a link quarantines
+	// it, confirmed; whitelisting it closes it to further reports.
+	reportDismissed   = "dismissed"
+	reportDisabled    = "disabled"
+	reportWhitelisted = "whitelisted"
+
+	// reportedQuarantineReason is the quarantine reason of disabled links.
+	reportedQuarantineReason = "reported by visitors"
+)
+
+var reportReasons = []string{"phishing", "malware", "spam", "illegal", "other"}
+
+// LinkReport is a visitor telling that a link is malicious. ReporterHash
+// is the hashed address of the reporter, so that a visitor reports a link
+// once until its reports are resolved.
+type LinkReport struct {
+	ID           int        `db:"id" json:"id"`
+	LinkID       int        `db:"link_id" json:"link_id"`
+	Reason       string     `db:"reason" json:"reason"`
+	Details      *string    `db:"details" json:"details,omitempty"`
+	Email        *string    `db:"email" json:"email,omitempty"`
+	ReporterHash *string    `db:"reporter_hash" json:"-"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	ResolvedAt   *time.Time `db:"resolved_at" json:"resolved_at,omitempty"`
+	Resolution   *string    `db:"resolution" json:"resolution,omitempty"`
+}
+
+// ReportedLink is a link on the moderation queue with the number of its
+// reports awaiting review.
+type ReportedLink struct {
+	Link
+	OpenReports int `db:"open_reports" json:"open_reports"`
+}
+
+// ReportLinkRequest is sent by visitors, as JSON or from an HTML form.
+// Email is optional, for the reporter to be contacted back.
+type ReportLinkRequest struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details,omitempty"`
+	Email   string `json:"email,omitempty"`
+}
+
+type ReportLinkResponse struct {
+	Message     string `json:"message"`
+	ElapsedTime int64  `json:"elapsed_time"`
+}
+
+type ReportedLinksResponse struct {
+	Links       []ReportedLink `json:"links"`
+	Total       int            `json:"total"`
+	Limit       int            `json:"limit"`
+	Offset      int            `json:"offset"`
+	ElapsedTime int64          `json:"elapsed_time"`
+}
+
+type LinkReportsResponse struct {
+	LinkID      int          `json:"link_id"`
+	Reports     []LinkReport `json:"reports"`
+	ElapsedTime int64        `json:"elapsed_time"`
+}
+
+func decodeReportLinkRequest(r *http.Request) (ReportLinkRequest, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" {
+		if err := r.ParseForm(); err != nil {
+			return ReportLinkRequest{}, err
+		}
+		return ReportLinkRequest{
+			Reason:  r.PostForm.Get("reason"),
+			Details: r.PostForm.Get("details"),
+			Email:   r.PostForm.Get("email"),
+		}, nil
+	}
+
+	var request ReportLinkRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	return request, err
+}
+
+// newLinkReport checks request and turns it into a report of link.
+func newLinkReport(link Link, request ReportLinkRequest) (LinkReport, error) {
+	report := LinkReport{LinkID: link.ID, Reason: strings.ToLower(strings.TrimSpace(request.Reason))}
+
+	valid := false
+	for _, reason := range reportReasons {
+		valid = valid || report.Reason == reason
+	}
+	if !valid {
+		return report, &fieldError{Field: "reason", Message: "reason must be one of " + strings.Join(reportReasons, ", ")}
+	}
+
+	details := strings.TrimSpace(request.Details)
+	if len(details) > maxReportDetailsLength {
+		return report, &fieldError{Field: "details", Message: fmt.Sprintf("details must be at most %d characters", maxReportDetailsLength)}
+	}
+	report.Details = nonEmpty(details)
+
+	email := strings.TrimSpace(request.Email)
+	if email != "" && !isValidMemberEmail(email) {
+		return report, &fieldError{Field: "email", Message: "email must be a valid email address"}
+	}
+	report.Email = nonEmpty(email)
+
+	return report, nil
+}
+
+// ReportLinkHandler takes the report of a visitor about the link of the
+// code in the path, resolved like redirects are. The reporter is told the
+// same whether the report was queued or dropped as a repeat or because
+// the link was whitelisted.
+func ReportLinkHandler(links LinkStore, reports ReportStore, orgs OrgStore, resolver *DomainResolver, cache LinkCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		orgID, domainID, ok := resolveLinkNamespace(w, r, orgs, resolver)
+		if !ok {
+			return
+		}
+
+		link, err := lookupLink(r.Context(), links, cache, orgID, domainID, mux.Vars(r)["code"])
+		if err == nil && (link.DeletedAt != nil || link.ArchivedAt != nil) {
+			err = ErrNotFound
+		}
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		request, err := decodeReportLinkRequest(r)
+		if err != nil {
+			writeInvalidBody(w, err)
+			return
+		}
+
+		report, err := newLinkReport(link, request)
+		if err != nil {
+			writeValidationError(w, err)
+			return
+		}
+		report.ReporterHash = hashIP(clientIP(r))
+
+		created, err := reports.CreateLinkReport(r.Context(), &report)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		if created {
+			slog.InfoContext(r.Context(), "Link reported", "link_id", link.ID, "reason", report.Reason)
+		}
+
+		response := ReportLinkResponse{
+			Message:     "Thank you, the link will be reviewed",
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(jsonResponse)
+	}
+}
+
+// ListReportedLinksHandler is the moderation queue of the admin: the live
+// links with reports awaiting review, most reported first.
+func ListReportedLinksHandler(reports ReportStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+		params := r.URL.Query()
+
+		limit, err := parseIntParam(params.Get("limit"), defaultListLimit)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+
+		offset, err := parseIntParam(params.Get("offset"), 0)
+		if err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+
+		page, total, err := reports.ListReportedLinks(r.Context(), limit, offset)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := ReportedLinksResponse{
+			Links:       page,
+			Total:       total,
+			Limit:       limit,
+			Offset:      offset,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// LinkReportsHandler lists every report of the link with the ID in the
+// path, newest first, resolved ones included.
+func LinkReportsHandler(reports ReportStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Link not found")
+			return
+		}
+
+		linkReports, err := reports.LinkReports(r.Context(), id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := LinkReportsResponse{
+			LinkID:      id,
+			Reports:     linkReports,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// ResolveLinkReportsHandler resolves the open reports of the link with
+// the ID in the path as resolution. Disabled links are announced like
+// those quarantined by a scanner.
+func ResolveLinkReportsHandler(reports ReportStore, cache LinkCache, webhooks *WebhookDispatcher, resolution string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Link not found")
+			return
+		}
+
+		link, err := reports.ResolveLinkReports(r.Context(), id, resolution)
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link has no open reports")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		cache.Delete(r.Context(), link.OrgID, link.DomainID, link.Code)
+
+		if resolution == reportDisabled {
+			data := newWebhookEventData(link)
+			data.Reason = link.QuarantineReason
+			webhooks.Emit(link.OrgID, webhookLinkQuarantined, data)
+		}
+
+		link.ElapsedTime = time.Since(startTime).Milliseconds()
+		jsonResponse, err := json.Marshal(link)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxReferrerLength bounds the referrer hosts that are stored.
+const maxReferrerLength = 255
+
+// trustProxyHeaders makes clientIP honour X-Forwarded-For, and short URLs
+// and custom domains X-Forwarded-Proto and X-Forwarded-Host. It must only be enabled when the
+// server sits behind a proxy that overwrites the headers, otherwise
+// clients can pick their own address.
+var trustProxyHeaders bool
+
+// baseURL is the scheme and host short URLs are built on, from BASE_URL.
+// When it is empty, the ones the request came in on are used.
+var baseURL string
+
+// clientIP returns the address the request originated from.
+func clientIP(r *http.Request) string {
+	if trustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// parseBaseURL checks a BASE_URL value and drops its trailing slash.
+func parseBaseURL(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", err
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("BASE_URL must be an http or https URL, got %q", value)
+	}
+
+	return strings.TrimSuffix(value, "/"), nil
+}
+
+// shortURL returns the address code redirects from, with the /o/ prefix
+// of links in an organization's namespace.
+func shortURL(r *http.Request, orgSlug string, code string) string {
+	base := baseURL
+	if base == "" {
+		base = requestScheme(r) + "://" + forwardedHost(r)
+	}
+
+	if orgSlug != "" {
+		return base + "/o/" + url.PathEscape(orgSlug) + "/" + url.PathEscape(code)
+	}
+
+	return base + "/" + url.PathEscape(code)
+}
+
+// domainShortURL returns the address code redirects from on a custom
+// domain, with the scheme of BASE_URL or of the request.
+func domainShortURL(r *http.Request, hostname string, code string) string {
+	scheme := requestScheme(r)
+	if baseURL != "" {
+		scheme, _, _ = strings.Cut(baseURL, ":")
+	}
+
+	return scheme + "://" + hostname + "/" + url.PathEscape(code)
+}
+
+func requestScheme(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if trustProxyHeaders {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+	}
+
+	return scheme
+}
+
+func forwardedHost(r *http.Request) string {
+	if trustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+			return forwarded
+		}
+	}
+
+	return r.Host
+}
+
+// requestHost returns the lowercased hostname the request came in on,
+// without its port.
+func requestHost(r *http.Request) string {
+	host := forwardedHost(r)
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// apiKeyFromRequest returns the API key sent in the X-API-Key header or
+// as a bearer token, or an empty string when there is none.
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+
+	return ""
+}
+
+// referrerHost returns the lowercased host of the Referer header. Paths and
+// query strings are dropped so that stored referrers stay low-cardinality
+// and do not leak what the visitor was reading. Direct visits and
+// unparsable headers yield an empty string.
+func referrerHost(r *http.Request) string {
+	referer, err := url.Parse(r.Referer())
+	if err != nil {
+		return ""
+	}
+
+	host := strings.ToLower(referer.Hostname())
+	if len(host) > maxReferrerLength {
+		return ""
+	}
+
+	return host
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const defaultClickRollupInterval = 24 * time.Hour
+
+// clickRollupTables hold the weekly and monthly sums of the daily clicks,
+// by the granularity of ClickTimeSeries they serve.
+var clickRollupTables = map[string]string{
+	"week":  "clicks_weekly",
+	"month": "clicks_monthly",
+}
+
+// maintainClicks creates the click partitions of the current and the next
+// month and rolls up the weeks and months that have ended, right away and
+// then every interval.
+func maintainClicks(clicks ClickStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx := context.Background()
+		today := utcToday()
+
+		for _, month := range []time.Time{today, today.AddDate(0, 1, 1-today.Day())} {
+			if err := clicks.CreateClickPartitions(ctx, month); err != nil {
+				slog.Error("Error creating click partitions", "month", month.Format("2006-01"), "error", err)
+			}
+		}
+
+		for granularity := range clickRollupTables {
+			if err := clicks.RollupClicks(ctx, granularity, today); err != nil {
+				slog.Error("Error rolling up clicks", "granularity", granularity, "error", err)
+			}
+		}
+
+		<-ticker.C
+	}
+}
+
+// clickBucketStart returns the first day of the week, a Monday, or the
+// month that day falls in.
+func clickBucketStart(granularity string, day time.Time) time.Time {
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	if granularity == "week" {
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+
+	return day.AddDate(0, 0, 1-day.Day())
+}
+
+// nextClickBucket returns the first day of the week or month after the
+// one starting on start.
+func nextClickBucket(granularity string, start time.Time) time.Time {
+	if granularity == "week" {
+		return start.AddDate(0, 0, 7)
+	}
+
+	return start.AddDate(0, 1, 0)
+}
+
+// clickRollupWindow returns the days, from inclusive to exclusive, that
+// RollupClicks sums up on today: the weeks or months that have ended by
+// today, starting with the last one that was rolled up before, so that
+// clicks recorded after that rollup are taken in. from is empty when
+// nothing has been rolled up yet.
+func clickRollupWindow(granularity string, rolledUpTo string, today time.Time) (from string, to string) {
+	to = clickBucketStart(granularity, today).Format("2006-01-02")
+
+	last, err := time.Parse("2006-01-02", rolledUpTo)
+	if err != nil {
+		return "", to
+	}
+
+	return clickBucketStart(granularity, last.AddDate(0, 0, -1)).Format("2006-01-02"), to
+}
+
+// clickRollupSpan returns the days, from inclusive to exclusive, whose
+// clicks ClickTimeSeries reads from the rollups: those of the buckets that
+// lie wholly within the filter and were rolled up before rolledUpTo. from
+// is empty when the filter has no lower bound. ok is false when no bucket
+// qualifies.
+func clickRollupSpan(filter ClickSeriesFilter, rolledUpTo string) (from string, to string, ok bool) {
+	end, err := time.Parse("2006-01-02", rolledUpTo)
+	if err != nil {
+		return "", "", false
+	}
+
+	if filter.To != "" {
+		last, err := time.Parse("2006-01-02", filter.To)
+		if err != nil {
+			return "", "", false
+		}
+		if bucketEnd := clickBucketStart(filter.Granularity, last.AddDate(0, 0, 1)); bucketEnd.Before(end) {
+			end = bucketEnd
+		}
+	}
+
+	if filter.From != "" {
+		first, err := time.Parse("2006-01-02", filter.From)
+		if err != nil {
+			return "", "", false
+		}
+
+		start := clickBucketStart(filter.Granularity, first)
+		if start.Before(first) {
+			start = nextClickBucket(filter.Granularity, start)
+		}
+		if !start.Before(end) {
+			return "", "", false
+		}
+		from = start.Format("2006-01-02")
+	}
+
+	return from, end.Format("2006-01-02"), true
+}
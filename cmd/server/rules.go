@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxRoutingRules   = 50
+	maxRuleValues     = 50
+	ruleTimeOfDay     = "15:04"
+	directReferrer    = "direct"
+	maxLanguageLength = 35
+)
+
+var ruleWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ruleLocations caches the time zones of rules, which are otherwise read
+// from the zone database on every visit.
+var ruleLocations sync.Map
+
+func loadRuleLocation(name string) (*time.Location, error) {
+	if location, ok := ruleLocations.Load(name); ok {
+		return location.(*time.Location), nil
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	ruleLocations.Store(name, location)
+
+	return location, nil
+}
+
+// RoutingRule sends the visits that meet every condition it sets to URL.
+// A condition lists values of which any matches: countries are ISO 3166-1
+// alpha-2 codes, continents, operating systems and devices are named as in
+// geo and device targets, languages are tags of the visitor's preferred
+// language such as pt or pt-br, and referrers are hosts that match their
+// subdomains too, or direct for visits without a referrer. Weekdays (mon to
+// sun) and the time of day between TimeFrom and TimeTo, as HH:MM, are
+// taken in TimeZone, UTC by default; a TimeTo before TimeFrom spans
+// midnight.
+type RoutingRule struct {
+	Countries  []string `json:"countries,omitempty"`
+	Continents []string `json:"continents,omitempty"`
+	OS         []string `json:"os,omitempty"`
+	Devices    []string `json:"devices,omitempty"`
+	Languages  []string `json:"languages,omitempty"`
+	Referrers  []string `json:"referrers,omitempty"`
+	Weekdays   []string `json:"weekdays,omitempty"`
+	TimeFrom   string   `json:"time_from,omitempty"`
+	TimeTo     string   `json:"time_to,omitempty"`
+	TimeZone   string   `json:"time_zone,omitempty"`
+	URL        string   `json:"url"`
+}
+
+// linkRoutingRules is stored as a JSON array, and as NULL when the link
+// has none.
+type linkRoutingRules []RoutingRule
+
+func (r linkRoutingRules) Value() (driver.Value, error) {
+	if len(r) == 0 {
+		return nil, nil
+	}
+
+	return jsonColumnValue([]RoutingRule(r))
+}
+
+func (r *linkRoutingRules) Scan(src interface{}) error {
+	*r = nil
+	return scanJSONColumn(src, (*[]RoutingRule)(r))
+}
+
+// ruleVisit is what routing rules are matched against.
+type ruleVisit struct {
+	Location Location
+	Device   Device
+	Language string
+	Referrer string
+	Time     time.Time
+}
+
+func newRuleVisit(countries CountryLookup, click Click) ruleVisit {
+	return ruleVisit{
+		Location: countries.Locate(click.IP),
+		Device:   classifyUserAgent(click.UserAgent),
+		Language: click.Language,
+		Referrer: click.Referrer,
+		Time:     time.Now(),
+	}
+}
+
+// match returns the first rule visit meets.
+func (r linkRoutingRules) match(visit ruleVisit) (RoutingRule, bool) {
+	for _, rule := range r {
+		if rule.matches(visit) {
+			return rule, true
+		}
+	}
+
+	return RoutingRule{}, false
+}
+
+func (rule RoutingRule) matches(visit ruleVisit) bool {
+	if len(rule.Countries) > 0 && !containsValue(rule.Countries, visit.Location.Country) {
+		return false
+	}
+	if len(rule.Continents) > 0 && !containsValue(rule.Continents, visit.Location.Continent) {
+		return false
+	}
+
+	if len(rule.OS) > 0 || len(rule.Devices) > 0 {
+		// Bots never match, like with device targets, so that link
+		// previews show the default destination.
+		if visit.Device.Type == deviceBot || visit.Device.Type == deviceUnknown {
+			return false
+		}
+	}
+	if len(rule.OS) > 0 && !rule.matchesOS(visit.Device.OS) {
+		return false
+	}
+	if len(rule.Devices) > 0 && !containsValue(rule.Devices, visit.Device.Type) {
+		return false
+	}
+
+	if len(rule.Languages) > 0 && !rule.matchesLanguage(visit.Language) {
+		return false
+	}
+	if len(rule.Referrers) > 0 && !rule.matchesReferrer(visit.Referrer) {
+		return false
+	}
+
+	return rule.matchesTime(visit.Time)
+}
+
+func (rule RoutingRule) matchesOS(os string) bool {
+	for _, name := range rule.OS {
+		if targetOperatingSystems[name] == os {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (rule RoutingRule) matchesLanguage(language string) bool {
+	for _, tag := range rule.Languages {
+		if language == tag || strings.HasPrefix(language, tag+"-") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (rule RoutingRule) matchesReferrer(referrer string) bool {
+	for _, host := range rule.Referrers {
+		if host == directReferrer {
+			if referrer == "" {
+				return true
+			}
+			continue
+		}
+
+		if referrer == host || strings.HasSuffix(referrer, "."+host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesTime reports whether now falls on the weekdays and within the
+// time of day of the rule. The rule has been validated, so its time zone
+// and times parse.
+func (rule RoutingRule) matchesTime(now time.Time) bool {
+	if len(rule.Weekdays) == 0 && rule.TimeFrom == "" && rule.TimeTo == "" {
+		return true
+	}
+
+	location := time.UTC
+	if rule.TimeZone != "" {
+		if loaded, err := loadRuleLocation(rule.TimeZone); err == nil {
+			location = loaded
+		}
+	}
+	now = now.In(location)
+
+	if len(rule.Weekdays) > 0 {
+		weekday := false
+		for _, name := range rule.Weekdays {
+			if ruleWeekdays[name] == now.Weekday() {
+				weekday = true
+				break
+			}
+		}
+		if !weekday {
+			return false
+		}
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	from, to := 0, 24*60
+	if rule.TimeFrom != "" {
+		from = minuteOfDay(rule.TimeFrom)
+	}
+	if rule.TimeTo != "" {
+		to = minuteOfDay(rule.TimeTo)
+	}
+
+	if to < from {
+		return minute >= from || minute < to
+	}
+	return minute >= from && minute < to
+}
+
+func minuteOfDay(value string) int {
+	t, _ := time.Parse(ruleTimeOfDay, value)
+	return t.Hour()*60 + t.Minute()
+}
+
+func containsValue(values []string, value string) bool {
+	if value == "" {
+		return false
+	}
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateRoutingRules normalizes the values of the rules in place and
+// returns a *fieldError for the first invalid one. Every rule sets at
+// least one condition and a URL.
+func validateRoutingRules(rules []RoutingRule) error {
+	if len(rules) > maxRoutingRules {
+		return &fieldError{Field: "routing_rules", Message: fmt.Sprintf("A link is limited to %d routing rules", maxRoutingRules)}
+	}
+
+	for i := range rules {
+		if err := rules[i].validate(fmt.Sprintf("routing_rules[%d]", i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (rule *RoutingRule) validate(field string) error {
+	conditions := []struct {
+		name      string
+		values    []string
+		normalize func(string) string
+		valid     func(string) bool
+		message   string
+	}{
+		{"countries", rule.Countries, strings.ToUpper, isCountryCode, "must be an ISO 3166-1 alpha-2 code"},
+		{"continents", rule.Continents, strings.ToUpper, func(v string) bool { return continentCodes[v] }, "must be AF, AN, AS, EU, NA, OC or SA"},
+		{"os", rule.OS, strings.ToLower, func(v string) bool { return targetOperatingSystems[v] != "" }, "must be ios, android, windows, macos, linux or chromeos"},
+		{"devices", rule.Devices, strings.ToLower, func(v string) bool { return targetDeviceTypes[v] }, "must be desktop, mobile or tablet"},
+		{"languages", rule.Languages, strings.ToLower, isLanguageTag, "must be a language tag such as en or pt-br"},
+		{"referrers", rule.Referrers, normalizeRuleReferrer, isRuleReferrer, "must be a host or direct"},
+		{"weekdays", rule.Weekdays, strings.ToLower, func(v string) bool { _, ok := ruleWeekdays[v]; return ok }, "must be mon, tue, wed, thu, fri, sat or sun"},
+	}
+
+	set := rule.TimeFrom != "" || rule.TimeTo != ""
+	for _, condition := range conditions {
+		if len(condition.values) > maxRuleValues {
+			return &fieldError{Field: field + "." + condition.name, Message: fmt.Sprintf("A condition is limited to %d values", maxRuleValues)}
+		}
+
+		for j, value := range condition.values {
+			value = condition.normalize(strings.TrimSpace(value))
+			if !condition.valid(value) {
+				return &fieldError{Field: fmt.Sprintf("%s.%s[%d]", field, condition.name, j), Message: condition.name + " " + condition.message}
+			}
+			condition.values[j] = value
+		}
+
+		set = set || len(condition.values) > 0
+	}
+
+	if !set {
+		return &fieldError{Field: field, Message: "Routing rule needs at least one condition"}
+	}
+
+	for _, value := range []struct {
+		name  string
+		value string
+	}{{"time_from", rule.TimeFrom}, {"time_to", rule.TimeTo}} {
+		if value.value == "" {
+			continue
+		}
+		if _, err := time.Parse(ruleTimeOfDay, value.value); err != nil {
+			return &fieldError{Field: field + "." + value.name, Message: value.name + " must be a time of day such as 09:30"}
+		}
+	}
+
+	if rule.TimeFrom != "" && rule.TimeFrom == rule.TimeTo {
+		return &fieldError{Field: field + ".time_to", Message: "time_to must differ from time_from"}
+	}
+
+	if rule.TimeZone != "" {
+		if _, err := loadRuleLocation(rule.TimeZone); err != nil {
+			return &fieldError{Field: field + ".time_zone", Message: "time_zone must be an IANA time zone such as Europe/Warsaw"}
+		}
+	}
+
+	if rule.URL == "" {
+		return &fieldError{Field: field + ".url", Message: "URL is required"}
+	}
+
+	return nil
+}
+
+func isLanguageTag(tag string) bool {
+	if tag == "" || len(tag) > maxLanguageLength {
+		return false
+	}
+
+	for _, part := range strings.Split(tag, "-") {
+		if part == "" || len(part) > 8 {
+			return false
+		}
+		for _, c := range part {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func normalizeRuleReferrer(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func isRuleReferrer(host string) bool {
+	return host != "" && len(host) <= maxReferrerLength && !strings.ContainsAny(host, "/:?# ")
+}
+
+// prepareRoutingRules validates the routing rules of a request and
+// unwraps their URLs in place.
+func prepareRoutingRules(ctx context.Context, rules []RoutingRule) error {
+	if err := validateRoutingRules(rules); err != nil {
+		return err
+	}
+
+	for i := range rules {
+		unwrapped, err := unwrapURL(ctx, rules[i].URL)
+		if err != nil {
+			return &fieldError{Field: fmt.Sprintf("routing_rules[%d].url", i), Message: err.Error()}
+		}
+		rules[i].URL = unwrapped
+	}
+
+	return nil
+}
+
+// applyRoutingRulesUpdate sets the routing rules of link when request has
+// them. An empty list removes them.
+func applyRoutingRulesUpdate(link *Link, request UpdateLinkRequest) {
+	if request.RoutingRules != nil {
+		link.RoutingRules = newLinkRoutingRules(*request.RoutingRules)
+	}
+}
+
+func newLinkRoutingRules(rules []RoutingRule) linkRoutingRules {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return linkRoutingRules(rules)
+}
+
+// preferredLanguage returns the lowercased tag of the language an
+// Accept-Language header prefers most, or an empty string when it names
+// none.
+func preferredLanguage(header string) string {
+	best, bestQuality := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" || !isLanguageTag(tag) {
+			continue
+		}
+
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+
+		if quality > bestQuality {
+			best, bestQuality = tag, quality
+		}
+	}
+
+	return best
+}
+
+// ValidateRoutingRulesHandler validates a set of routing rules without
+// storing them, and returns them as a link would store them.
+func ValidateRoutingRulesHandler(domains *DomainPolicy, checker URLChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		var request ValidateRoutingRulesRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeInvalidBody(w, err)
+			return
+		}
+
+		if len(request.RoutingRules) == 0 {
+			writeValidationError(w, &fieldError{Field: "routing_rules", Message: "routing_rules is required"})
+			return
+		}
+
+		if err := prepareRoutingRules(r.Context(), request.RoutingRules); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		urls := make([]string, len(request.RoutingRules))
+		for i, rule := range request.RoutingRules {
+			urls[i] = rule.URL
+		}
+		if !checkURLs(w, r, domains, checker, urls) {
+			return
+		}
+
+		response := ValidateRoutingRulesResponse{
+			RoutingRules: request.RoutingRules,
+			ElapsedTime:  time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+)
+
+const (
+	safeBrowsingEndpoint = "https://safebrowsing.googleapis.com/v4/threatMatches:find"
+	safeBrowsingTimeout  = 5 * time.Second
+
+	defaultVerdictTTL = 30 * time.Minute
+	maxCachedVerdicts = 10000
+)
+
+// safeBrowsingThreatTypes are the lists destinations are checked against.
+var safeBrowsingThreatTypes = []string{"MALWARE", "SOCIAL_ENGINEERING", "UNWANTED_SOFTWARE", "POTENTIALLY_HARMFUL_APPLICATION"}
+
+// checkURLsOnRedirect makes redirects check their destination too, set
+// from SAFE_BROWSING_ON_REDIRECT. It catches destinations flagged after
+// the link was created.
+var checkURLsOnRedirect bool
+
+// URLVerdict is the outcome of checking a URL. Threat names the list an
+// unsafe URL is on, such as MALWARE or SOCIAL_ENGINEERING.
+type URLVerdict struct {
+	Unsafe bool
+	Threat string
+}
+
+// URLChecker decides whether a destination is safe to link to.
+type URLChecker interface {
+	Check(ctx context.Context, url string) (URLVerdict, error)
+}
+
+// NewURLChecker returns a Safe Browsing checker when
+// SAFE_BROWSING_API_KEY is set and a checker that passes every URL
+// otherwise. Verdicts are cached for SAFE_BROWSING_CACHE_TTL to stay within
+// the API quota.
+func NewURLChecker(cfg *config.Config) URLChecker {
+	apiKey := cfg.String("SAFE_BROWSING_API_KEY")
+	if apiKey == "" {
+		return noopURLChecker{}
+	}
+
+	checker := &SafeBrowsingChecker{
+		apiKey: apiKey,
+		client: &http.Client{Timeout: safeBrowsingTimeout, Transport: tracedTransport(nil)},
+	}
+
+	return newCachingURLChecker(checker, cfg.Duration("SAFE_BROWSING_CACHE_TTL"))
+}
+
+type noopURLChecker struct{}
+
+func (noopURLChecker) Check(ctx context.Context, url string) (URLVerdict, error) {
+	return URLVerdict{}, nil
+}
+
+// SafeBrowsingChecker looks URLs up with the Google Safe Browsing Lookup
+// API.
+type SafeBrowsingChecker struct {
+	apiKey string
+	client *http.Client
+}
+
+type safeBrowsingRequest struct {
+	Client struct {
+		ClientID      string `json:"clientId"`
+		ClientVersion string `json:"clientVersion"`
+	} `json:"client"`
+	ThreatInfo struct {
+		ThreatTypes      []string            `json:"threatTypes"`
+		PlatformTypes    []string            `json:"platformTypes"`
+		ThreatEntryTypes []string            `json:"threatEntryTypes"`
+		ThreatEntries    []map[string]string `json:"threatEntries"`
+	} `json:"threatInfo"`
+}
+
+type safeBrowsingResponse struct {
+	Matches []struct {
+		ThreatType string `json:"threatType"`
+	} `json:"matches"`
+}
+
+func (c *SafeBrowsingChecker) Check(ctx context.Context, target string) (URLVerdict, error) {
+	var request safeBrowsingRequest
+	request.Client.ClientID = "wowee-link"
+	request.Client.ClientVersion = "1.0"
+	request.ThreatInfo.ThreatTypes = safeBrowsingThreatTypes
+	request.ThreatInfo.PlatformTypes = []string{"ANY_PLATFORM"}
+	request.ThreatInfo.ThreatEntryTypes = []string{"URL"}
+	request.ThreatInfo.ThreatEntries = []map[string]string{{"url": target}}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return URLVerdict{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, safeBrowsingEndpoint+"?key="+url.QueryEscape(c.apiKey), bytes.NewReader(body))
+	if err != nil {
+		return URLVerdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return URLVerdict{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return URLVerdict{}, fmt.Errorf("safe browsing responded with status %d", resp.StatusCode)
+	}
+
+	var response safeBrowsingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return URLVerdict{}, err
+	}
+
+	if len(response.Matches) == 0 {
+		return URLVerdict{}, nil
+	}
+
+	return URLVerdict{Unsafe: true, Threat: response.Matches[0].ThreatType}, nil
+}
+
+type cachedVerdict struct {
+	verdict   URLVerdict
+	expiresAt time.Time
+}
+
+// cachingURLChecker remembers the verdicts of another checker for ttl.
+// Failed checks are not cached.
+type cachingURLChecker struct {
+	checker  URLChecker
+	ttl      time.Duration
+	mu       sync.Mutex
+	verdicts map[string]cachedVerdict
+}
+
+func newCachingURLChecker(checker URLChecker, ttl time.Duration) *cachingURLChecker {
+	return &cachingURLChecker{
+		checker:  checker,
+		ttl:      ttl,
+		verdicts: make(map[string]cachedVerdict),
+	}
+}
+
+func (c *cachingURLChecker) Check(ctx context.Context, url string) (URLVerdict, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	cached, ok := c.verdicts[url]
+	c.mu.Unlock()
+
+	if ok && now.Before(cached.expiresAt) {
+		return cached.verdict, nil
+	}
+
+	verdict, err := c.checker.Check(ctx, url)
+	if err != nil {
+		return verdict, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.verdicts) >= maxCachedVerdicts {
+		c.evict(now)
+	}
+	c.verdicts[url] = cachedVerdict{verdict: verdict, expiresAt: now.Add(c.ttl)}
+
+	return verdict, nil
+}
+
+// evict drops expired verdicts, and every verdict when none had expired,
+// so that the cache stays bounded.
+func (c *cachingURLChecker) evict(now time.Time) {
+	for url, cached := range c.verdicts {
+		if !now.Before(cached.expiresAt) {
+			delete(c.verdicts, url)
+		}
+	}
+
+	if len(c.verdicts) >= maxCachedVerdicts {
+		c.verdicts = make(map[string]cachedVerdict)
+	}
+}
+
+// isUnsafeURL reports whether checker flags url. When the check itself
+// fails the URL is let through, so an outage of the checker does not stop
+// links from being created or followed.
+func isUnsafeURL(ctx context.Context, checker URLChecker, url string) (URLVerdict, bool) {
+	verdict, err := checker.Check(ctx, url)
+	if err != nil {
+		slog.WarnContext(ctx, "Error checking URL", "error", err)
+		return URLVerdict{}, false
+	}
+
+	return verdict, verdict.Unsafe
+}
+
+func unsafeURLMessage(verdict URLVerdict) string {
+	return "URL is flagged as unsafe (" + strings.ToLower(verdict.Threat) + ")"
+}
+
+func writeUnsafeURL(w http.ResponseWriter, verdict URLVerdict) {
+	details := map[string]interface{}{"field": "url", "threat": verdict.Threat}
+	writeErrorCode(w, http.StatusBadRequest, "unsafe_url", unsafeURLMessage(verdict), details)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+)
+
+const (
+	virusTotalEndpoint = "https://www.virustotal.com/api/v3/urls/"
+	phishTankEndpoint  = "https://checkurl.phishtank.com/checkurl/"
+	scannerTimeout     = 10 * time.Second
+	openPhishTimeout   = time.Minute
+	scannerUserAgent   = "wowee-link-api"
+
+	defaultVirusTotalDetections = 2
+	defaultOpenPhishRefresh     = time.Hour
+	openPhishRetry              = time.Minute
+	maxOpenPhishFeedSize        = 32 << 20
+)
+
+// NewDestinationScanners returns the scanners whose settings are given:
+// VirusTotal with VIRUSTOTAL_API_KEY, PhishTank with PHISHTANK_APP_KEY
+// and the OpenPhish feed with OPENPHISH_FEED_URL.
+func NewDestinationScanners(cfg *config.Config) []DestinationScanner {
+	client := &http.Client{Timeout: scannerTimeout, Transport: tracedTransport(nil)}
+
+	var scanners []DestinationScanner
+	if apiKey := cfg.String("VIRUSTOTAL_API_KEY"); apiKey != "" {
+		scanners = append(scanners, &VirusTotalScanner{
+			apiKey:     apiKey,
+			detections: cfg.Int("VIRUSTOTAL_MIN_DETECTIONS"),
+			client:     client,
+		})
+	}
+	if appKey := cfg.String("PHISHTANK_APP_KEY"); appKey != "" {
+		scanners = append(scanners, &PhishTankScanner{appKey: appKey, client: client})
+	}
+	if feedURL := cfg.String("OPENPHISH_FEED_URL"); feedURL != "" {
+		feedClient := &http.Client{Timeout: openPhishTimeout, Transport: tracedTransport(nil)}
+		scanners = append(scanners, NewOpenPhishScanner(feedURL, cfg.Duration("OPENPHISH_REFRESH_INTERVAL"), feedClient))
+	}
+
+	return scanners
+}
+
+// VirusTotalScanner looks up the last analysis of a URL by VirusTotal. A
+// URL VirusTotal has never analysed counts as clean; it is not submitted,
+// as analyses take longer than a scan may. It is flagged once detections
+// engines or more find it malicious or suspicious.
+type VirusTotalScanner struct {
+	apiKey     string
+	detections int
+	client     *http.Client
+}
+
+type virusTotalResponse struct {
+	Data struct {
+		Attributes struct {
+			LastAnalysisStats struct {
+				Malicious  int `json:"malicious"`
+				Suspicious int `json:"suspicious"`
+			} `json:"last_analysis_stats"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+func (s *VirusTotalScanner) Name() string {
+	return "virustotal"
+}
+
+func (s *VirusTotalScanner) Scan(ctx context.Context, rawURL string) (URLVerdict, error) {
+	id := base64.RawURLEncoding.EncodeToString([]byte(rawURL))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, virusTotalEndpoint+id, nil)
+	if err != nil {
+		return URLVerdict{}, err
+	}
+	req.Header.Set("x-apikey", s.apiKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return URLVerdict{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return URLVerdict{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return URLVerdict{}, fmt.Errorf("VirusTotal answered %s", resp.Status)
+	}
+
+	var result virusTotalResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return URLVerdict{}, err
+	}
+
+	stats := result.Data.Attributes.LastAnalysisStats
+	if stats.Malicious+stats.Suspicious < max(s.detections, 1) {
+		return URLVerdict{}, nil
+	}
+
+	return URLVerdict{Unsafe: true, Threat: fmt.Sprintf("%d detections", stats.Malicious+stats.Suspicious)}, nil
+}
+
+// PhishTankScanner asks PhishTank whether a URL is a verified phish.
+type PhishTankScanner struct {
+	appKey string
+	client *http.Client
+}
+
+type phishTankResponse struct {
+	Results struct {
+		InDatabase bool `json:"in_database"`
+		Verified   bool `json:"verified"`
+		Valid      bool `json:"valid"`
+	} `json:"results"`
+}
+
+func (s *PhishTankScanner) Name() string {
+	return "phishtank"
+}
+
+func (s *PhishTankScanner) Scan(ctx context.Context, rawURL string) (URLVerdict, error) {
+	form := url.Values{"url": {rawURL}, "format": {"json"}, "app_key": {s.appKey}}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, phishTankEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return URLVerdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// PhishTank refuses requests without a descriptive user agent.
+	req.Header.Set("User-Agent", scannerUserAgent)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return URLVerdict{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return URLVerdict{}, fmt.Errorf("PhishTank answered %s", resp.Status)
+	}
+
+	var result phishTankResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return URLVerdict{}, err
+	}
+
+	if !result.Results.InDatabase || !result.Results.Verified || !result.Results.Valid {
+		return URLVerdict{}, nil
+	}
+
+	return URLVerdict{Unsafe: true, Threat: "phishing"}, nil
+}
+
+// OpenPhishScanner matches URLs against the OpenPhish feed, a plain list
+// of phishing URLs downloaded again every refresh interval, or after a
+// minute when a download fails. Until the first download succeeds nothing
+// is flagged.
+type OpenPhishScanner struct {
+	feedURL string
+	client  *http.Client
+
+	mu   sync.RWMutex
+	urls map[string]bool
+}
+
+func NewOpenPhishScanner(feedURL string, refresh time.Duration, client *http.Client) *OpenPhishScanner {
+	scanner := &OpenPhishScanner{feedURL: feedURL, client: client}
+
+	if refresh <= 0 {
+		refresh = defaultOpenPhishRefresh
+	}
+	go scanner.refresh(refresh)
+
+	return scanner
+}
+
+func (s *OpenPhishScanner) Name() string {
+	return "openphish"
+}
+
+func (s *OpenPhishScanner) Scan(ctx context.Context, rawURL string) (URLVerdict, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.urls[strings.TrimSuffix(rawURL, "/")] {
+		return URLVerdict{Unsafe: true, Threat: "phishing"}, nil
+	}
+
+	return URLVerdict{}, nil
+}
+
+func (s *OpenPhishScanner) refresh(interval time.Duration) {
+	for {
+		wait := interval
+		if err := s.download(); err != nil {
+			slog.Warn("Error downloading OpenPhish feed", "error", err)
+			wait = min(interval, openPhishRetry)
+		}
+		time.Sleep(wait)
+	}
+}
+
+func (s *OpenPhishScanner) download() error {
+	req, err := http.NewRequest(http.MethodGet, s.feedURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", scannerUserAgent)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OpenPhish answered %s", resp.Status)
+	}
+
+	urls := make(map[string]bool)
+	lines := bufio.NewScanner(io.LimitReader(resp.Body, maxOpenPhishFeedSize))
+	for lines.Scan() {
+		if line := strings.TrimSpace(lines.Text()); line != "" {
+			urls[strings.TrimSuffix(line, "/")] = true
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.urls = urls
+	s.mu.Unlock()
+
+	slog.Info("Loaded OpenPhish feed", "urls", len(urls))
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidSchedule is returned when an update leaves a link active
+// until a time that is not after the one it is active from.
+var ErrInvalidSchedule = errors.New("active_until must be after active_from")
+
+// isActive reports whether now is within the schedule of the link. A
+// link without active_from has been active since it was created, and one
+// without active_until stays active.
+func (l Link) isActive(now time.Time) bool {
+	if l.ActiveFrom != nil && now.Before(*l.ActiveFrom) {
+		return false
+	}
+
+	return l.ActiveUntil == nil || now.Before(*l.ActiveUntil)
+}
+
+// validateSchedule returns a *fieldError unless active_until is in the
+// future and after active_from.
+func validateSchedule(activeFrom *time.Time, activeUntil *time.Time) error {
+	if activeUntil == nil {
+		return nil
+	}
+
+	if !activeUntil.After(time.Now()) {
+		return &fieldError{Field: "active_until", Message: "active_until must be in the future"}
+	}
+
+	if activeFrom != nil && !activeUntil.After(*activeFrom) {
+		return &fieldError{Field: "active_until", Message: ErrInvalidSchedule.Error()}
+	}
+
+	return nil
+}
+
+// applyScheduleUpdate sets the bounds of the schedule of link that are
+// present in request, and returns ErrInvalidSchedule when they end up out
+// of order.
+func applyScheduleUpdate(link *Link, request UpdateLinkRequest) error {
+	if request.ActiveFrom != nil {
+		link.ActiveFrom = request.ActiveFrom
+	}
+	if request.ActiveUntil != nil {
+		link.ActiveUntil = request.ActiveUntil
+	}
+
+	if link.ActiveFrom != nil && link.ActiveUntil != nil && !link.ActiveUntil.After(*link.ActiveFrom) {
+		return ErrInvalidSchedule
+	}
+
+	return nil
+}
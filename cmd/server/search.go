@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	maxSearchLength = 200
+	maxSearchTerms  = 8
+)
+
+// Ranks of a term matching a link. A link ranks by the sum over the terms
+// of query, so an exact code or tag outranks a URL that merely mentions
+// the term.
+const (
+	searchRankCode        = 8
+	searchRankTag         = 4
+	searchRankTitle       = 2
+	searchRankDescription = 2
+	searchRankURL         = 1
+	searchRankNotes       = 1
+)
+
+// searchTerms splits a search query into its words.
+func searchTerms(query string) ([]string, error) {
+	if len(query) > maxSearchLength {
+		return nil, fmt.Errorf("q must be at most %d characters", maxSearchLength)
+	}
+
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return nil, errors.New("q is required")
+	}
+	if len(terms) > maxSearchTerms {
+		return nil, fmt.Errorf("q must have at most %d words", maxSearchTerms)
+	}
+
+	return terms, nil
+}
+
+// likePattern matches term anywhere in a value, with the wildcards in term
+// escaped by the ESCAPE '!' of the search conditions.
+func likePattern(term string) string {
+	replacer := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	return "%" + replacer.Replace(term) + "%"
+}
+
+// linkSearchConditions matches the links that have every term in their
+// code, URL, title, description, notes or a tag. like is the case-insensitive LIKE of the
+// dialect, and bind adds a value to the arguments of the query and
+// returns its placeholder.
+func linkSearchConditions(terms []string, like string, bind func(value interface{}) string) string {
+	conditions := make([]string, len(terms))
+	for i, term := range terms {
+		pattern := likePattern(term)
+		conditions[i] = fmt.Sprintf(
+			"(code %s %s ESCAPE '!' OR url %s %s ESCAPE '!' OR title %s %s ESCAPE '!' OR description %s %s ESCAPE '!' OR notes %s %s ESCAPE '!' OR id IN (SELECT link_id FROM link_tags WHERE tag LIKE %s ESCAPE '!'))",
+			like, bind(pattern),
+			like, bind(pattern),
+			like, bind(pattern),
+			like, bind(pattern),
+			like, bind(pattern),
+			bind(likePattern(normalizeTag(term))),
+		)
+	}
+
+	return strings.Join(conditions, " AND ")
+}
+
+// linkSearchRank ranks the links matched by linkSearchConditions.
+func linkSearchRank(terms []string, like string, bind func(value interface{}) string) string {
+	ranks := make([]string, len(terms))
+	for i, term := range terms {
+		pattern := likePattern(term)
+		ranks[i] = fmt.Sprintf(
+			"CASE WHEN code = %s THEN %d ELSE 0 END + CASE WHEN id IN (SELECT link_id FROM link_tags WHERE tag = %s) THEN %d ELSE 0 END + CASE WHEN title %s %s ESCAPE '!' THEN %d ELSE 0 END + CASE WHEN description %s %s ESCAPE '!' THEN %d ELSE 0 END + CASE WHEN url %s %s ESCAPE '!' THEN %d ELSE 0 END + CASE WHEN notes %s %s ESCAPE '!' THEN %d ELSE 0 END",
+			bind(term), searchRankCode,
+			bind(normalizeTag(term)), searchRankTag,
+			like, bind(pattern), searchRankTitle,
+			like, bind(pattern), searchRankDescription,
+			like, bind(pattern), searchRankURL,
+			like, bind(pattern), searchRankNotes,
+		)
+	}
+
+	return strings.Join(ranks, " + ")
+}
+
+// SearchLinksHandler finds the links whose code, URL, title, description,
+// notes or tags contain every word of the q parameter, best matches first. Links that
+// rank the same are ordered by their clicks.
+func SearchLinksHandler(links LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+		params := r.URL.Query()
+
+		terms, err := searchTerms(params.Get("q"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		filter := LinkFilter{OrgID: orgIDFromContext(r.Context())}
+
+		filter.Limit, err = parseIntParam(params.Get("limit"), defaultListLimit)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxListLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+
+		filter.Offset, err = parseIntParam(params.Get("offset"), 0)
+		if err != nil || filter.Offset < 0 {
+			writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+
+		page, total, err := links.SearchLinks(r.Context(), filter, terms)
+		if err == nil {
+			err = loadLinkTags(r.Context(), links, page)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := ListLinksResponse{
+			Links:       page,
+			Total:       total,
+			Limit:       filter.Limit,
+			Offset:      filter.Offset,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
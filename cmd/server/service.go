@@ -0,0 +1,995 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// linkService carries out the link operations of the gRPC and GraphQL
+// APIs against the same stores as the JSON handlers, with the same
+// validation and quotas. Links are created through it by all three.
+// Failures the caller can act on are returned as *serviceError; store
+// errors are logged and reported as errServiceInternal.
+type linkService struct {
+	links      LinkStore
+	clickStore ClickStore
+	cache      LinkCache
+	codes      CodeGenerator
+	codeConfig CodeConfig
+	domains    *DomainPolicy
+	checker    URLChecker
+	captcha    CaptchaVerifier
+	clicks     *ClickRecorder
+	countries  CountryLookup
+	webhooks   *WebhookDispatcher
+	titles     *TitleFetcher
+	folders    FolderStore
+	audit      AuditStore
+	aliases    AliasStore
+	orgs       OrgStore
+	shortens   *Quota
+	redirects  *Quota
+
+	restoreWindow time.Duration
+}
+
+// serviceError is a failure reported to the caller. Status is the HTTP
+// status the JSON API answers with, and Code the error code of its
+// ErrorResponse when more specific than the one of the status. Details
+// are those of the ErrorResponse, such as the field a 400 is about.
+type serviceError struct {
+	Status  int
+	Code    string
+	Message string
+	Details map[string]interface{}
+}
+
+func (e *serviceError) Error() string {
+	return e.Message
+}
+
+var (
+	errServiceNotFound            = &serviceError{Status: http.StatusNotFound, Message: "Link not found"}
+	errServiceLinkDeleted         = &serviceError{Status: http.StatusGone, Code: "link_deleted", Message: "Link has been deleted"}
+	errServiceLinkArchived        = &serviceError{Status: http.StatusGone, Code: "link_archived", Message: "Link has been archived"}
+	errServiceRestoreWindowPassed = &serviceError{Status: http.StatusGone, Code: "restore_window_passed", Message: "Link was deleted too long ago to be restored"}
+	errServiceLinkInactive        = &serviceError{Status: http.StatusNotFound, Code: "link_inactive", Message: "Link is not active"}
+	errServiceLinkExpired         = &serviceError{Status: http.StatusGone, Code: "link_expired", Message: "Link has expired"}
+	errServiceClickLimitReached   = &serviceError{Status: http.StatusGone, Code: "click_limit_reached", Message: "Link has reached its click limit"}
+	errServiceQuotaExceeded       = &serviceError{Status: http.StatusTooManyRequests, Code: "quota_exceeded", Message: "Monthly quota exceeded"}
+	errServiceLinkQuarantined     = &serviceError{Status: http.StatusForbidden, Code: "link_quarantined", Message: "Link is quarantined"}
+	errServiceInternal            = &serviceError{Status: http.StatusInternalServerError, Message: "Internal Server Error"}
+)
+
+func invalidRequest(message string) error {
+	return &serviceError{Status: http.StatusBadRequest, Message: message}
+}
+
+// validationError is invalidRequest for err, naming the offending field
+// in the details when err is a fieldError.
+func validationError(err error) error {
+	var fieldErr *fieldError
+	if errors.As(err, &fieldErr) {
+		return &serviceError{Status: http.StatusBadRequest, Message: err.Error(), Details: map[string]interface{}{"field": fieldErr.Field}}
+	}
+
+	return invalidRequest(err.Error())
+}
+
+func conflictError(errorCode string, message string) error {
+	return &serviceError{Status: http.StatusConflict, Code: errorCode, Message: message}
+}
+
+// Shorten creates a link, or returns the existing link of a URL that the
+// caller's API key already shortened.
+func (s *linkService) Shorten(ctx context.Context, request ShortenRequest) (Link, error) {
+	if err := checkScope(ctx, scopeLinksWrite); err != nil {
+		return Link{}, err
+	}
+
+	// gRPC and GraphQL have no way to carry a CAPTCHA token, so they
+	// shorten anonymously only when no CAPTCHA is configured. The admin key
+	// is exempt, as it is from checkCaptcha.
+	if _, ok := callerFromContext(ctx); !ok && !adminFromContext(ctx) && s.captcha != nil {
+		return Link{}, errServiceCaptchaRequired
+	}
+
+	link, replayed, err := s.shorten(ctx, request)
+	if meter, ok := usageMeterFromContext(ctx); ok && err == nil && createdLink(link, replayed) {
+		s.shortens.use(ctx, meter, 1, 0)
+	}
+
+	return link, err
+}
+
+// shorten is Shorten once the caller is allowed to shorten, which
+// ShortenURLHandler checks itself. It refuses to create a link once the
+// shorten quota is exhausted but leaves charging it to the caller.
+// replayed is set when the link is the one an earlier request with the
+// same IdempotencyKey created.
+func (s *linkService) shorten(ctx context.Context, request ShortenRequest) (link Link, replayed bool, err error) {
+	if request.IdempotencyKey != nil {
+		request.IdempotencyHash = request.fingerprint()
+	}
+
+	if len(request.Destinations) > 0 {
+		if err := request.useDestinations(ctx); err != nil {
+			return Link{}, false, validationError(err)
+		}
+	} else {
+		if request.URL == "" {
+			return Link{}, false, validationError(&fieldError{Field: "url", Message: "URL is required"})
+		}
+
+		request.URL, err = unwrapURL(ctx, request.URL)
+		if err != nil {
+			return Link{}, false, validationError(&fieldError{Field: "url", Message: err.Error()})
+		}
+	}
+
+	if err := prepareGeoTargets(ctx, request.GeoTargets); err != nil {
+		return Link{}, false, validationError(err)
+	}
+
+	if err := prepareDeviceTargets(ctx, request.DeviceTargets); err != nil {
+		return Link{}, false, validationError(err)
+	}
+
+	if err := prepareRoutingRules(ctx, request.RoutingRules); err != nil {
+		return Link{}, false, validationError(err)
+	}
+
+	if err := validateSchedule(request.ActiveFrom, request.ActiveUntil); err != nil {
+		return Link{}, false, validationError(err)
+	}
+
+	if err := prepareFallbackURL(ctx, &request.FallbackURL); err != nil {
+		return Link{}, false, validationError(err)
+	}
+
+	if err := prepareNotes(&request.Description, &request.Notes); err != nil {
+		return Link{}, false, validationError(err)
+	}
+
+	request.Tags, err = normalizeTags(request.Tags)
+	if err != nil {
+		return Link{}, false, validationError(err)
+	}
+
+	if _, err := s.folderID(ctx, request.FolderID); err != nil {
+		return Link{}, false, err
+	}
+
+	if request.DomainID, err = s.domainID(ctx, request.Domain); err != nil {
+		return Link{}, false, err
+	}
+
+	expiresAt, err := resolveExpiration(request)
+	if err != nil {
+		return Link{}, false, validationError(err)
+	}
+
+	if request.MaxClicks < 0 {
+		return Link{}, false, validationError(&fieldError{Field: "max_clicks", Message: "max_clicks must be positive"})
+	}
+
+	var maxClicks *int
+	if request.MaxClicks > 0 {
+		maxClicks = &request.MaxClicks
+	}
+
+	if request.RedirectStatus == 0 {
+		request.RedirectStatus = defaultRedirectStatus
+	}
+
+	if !isValidRedirectStatus(request.RedirectStatus) {
+		return Link{}, false, validationError(&fieldError{Field: "redirect_status", Message: "redirect_status must be 301, 302 or 307"})
+	}
+
+	if err := request.utm().validate(); err != nil {
+		return Link{}, false, validationError(err)
+	}
+
+	if request.CodeLength == 0 {
+		request.CodeLength = s.codeConfig.Length
+	}
+
+	if request.CodeLength < s.codeConfig.Length || request.CodeLength > s.codeConfig.MaxLength {
+		message := fmt.Sprintf("code_length must be between %d and %d", s.codeConfig.Length, s.codeConfig.MaxLength)
+		return Link{}, false, validationError(&fieldError{Field: "code_length", Message: message})
+	}
+
+	if request.IdempotencyKey != nil {
+		link, err := s.links.GetLinkByIdempotencyKey(ctx, orgIDFromContext(ctx), keyIDFromContext(ctx), sessionMemberIDFromContext(ctx), *request.IdempotencyKey)
+		if err == nil {
+			return replayShorten(link, request)
+		}
+		if err != ErrNotFound {
+			slog.ErrorContext(ctx, "Error querying database", "error", err)
+			return Link{}, false, errServiceInternal
+		}
+	}
+
+	for _, rawURL := range request.routedURLs() {
+		if err := s.checkURL(ctx, rawURL); err != nil {
+			return Link{}, false, err
+		}
+	}
+
+	if s.shortens.exhausted(ctx) {
+		return Link{}, false, errServiceQuotaExceeded
+	}
+
+	if request.Alias != "" {
+		return s.createAliasLink(ctx, request, expiresAt, maxClicks)
+	}
+
+	link, err = insertLinkWithGeneratedCode(ctx, s.links, s.codes, request, expiresAt, maxClicks)
+	if err == ErrIdempotencyKeyTaken {
+		return s.replayConcurrentShorten(ctx, request)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error inserting URL into the database", "error", err)
+		return Link{}, false, errServiceInternal
+	}
+
+	if err := s.completeLink(ctx, &link, request); err != nil {
+		return Link{}, false, err
+	}
+
+	// A URL that was already shortened comes back with its existing
+	// link, whose attempt_count has been bumped.
+	if link.AttemptCount == 1 {
+		s.webhooks.Emit(link.OrgID, webhookLinkCreated, newWebhookEventData(link))
+		s.titles.Fetch(link)
+	}
+
+	return link, false, nil
+}
+
+func (s *linkService) createAliasLink(ctx context.Context, request ShortenRequest, expiresAt *time.Time, maxClicks *int) (Link, bool, error) {
+	if !isValidAlias(request.Alias, s.codeConfig.Charset) {
+		return Link{}, false, validationError(&fieldError{Field: "alias", Message: "Invalid alias"})
+	}
+
+	if isReservedCode(request.Alias) {
+		return Link{}, false, conflictError("alias_reserved", "Alias \""+request.Alias+"\" is reserved")
+	}
+
+	orgID := orgIDFromContext(ctx)
+
+	exists, err := s.links.CodeExists(ctx, orgID, request.DomainID, request.Alias)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error querying database", "error", err)
+		return Link{}, false, errServiceInternal
+	}
+
+	if exists {
+		return Link{}, false, conflictError("alias_taken", "Alias \""+request.Alias+"\" is already in use")
+	}
+
+	link := Link{
+		OrgID:              orgID,
+		Code:               request.Alias,
+		URL:                request.URL,
+		ExpiresAt:          expiresAt,
+		RedirectStatus:     request.RedirectStatus,
+		MaxClicks:          maxClicks,
+		TrackingDisabled:   request.TrackingDisabled,
+		ForwardQuery:       request.ForwardQuery,
+		UTMParams:          request.utm(),
+		IdempotencyKey:     request.IdempotencyKey,
+		IdempotencyHash:    nonEmpty(request.IdempotencyHash),
+		Destinations:       newLinkDestinations(request.Destinations),
+		StickyDestinations: request.StickyDestinations,
+		GeoTargets:         newLinkGeoTargets(request.GeoTargets),
+		DeviceTargets:      newLinkDeviceTargets(request.DeviceTargets),
+		RoutingRules:       newLinkRoutingRules(request.RoutingRules),
+		ActiveFrom:         request.ActiveFrom,
+		ActiveUntil:        request.ActiveUntil,
+		FallbackURL:        nonEmpty(request.FallbackURL),
+		FolderID:           nonZero(request.FolderID),
+		Description:        nonEmpty(request.Description),
+		Notes:              nonEmpty(request.Notes),
+		KeyID:              keyIDFromContext(ctx),
+		MemberID:           sessionMemberIDFromContext(ctx),
+		ForceNew:           request.ForceNew,
+		DomainID:           request.DomainID,
+	}
+
+	err = s.links.CreateLink(ctx, &link)
+	if err != nil {
+		switch err {
+		case ErrURLTaken:
+			return s.attachAlias(ctx, request)
+		case ErrCodeTaken:
+			return Link{}, false, conflictError("alias_taken", "Alias \""+request.Alias+"\" is already in use")
+		case ErrIdempotencyKeyTaken:
+			return s.replayConcurrentShorten(ctx, request)
+		}
+		slog.ErrorContext(ctx, "Error inserting URL into the database", "error", err)
+		return Link{}, false, errServiceInternal
+	}
+
+	if err := addLinkTags(ctx, s.links, &link, request.Tags); err != nil {
+		slog.ErrorContext(ctx, "Error tagging link", "error", err)
+		return Link{}, false, errServiceInternal
+	}
+
+	s.webhooks.Emit(link.OrgID, webhookLinkCreated, newWebhookEventData(link))
+	s.titles.Fetch(link)
+
+	return link, false, nil
+}
+
+// attachAlias adds the alias of request to the link of its URL, which is
+// already shortened, so that the alias and the code it had share one link
+// and its stats, and returns that link under the alias.
+func (s *linkService) attachAlias(ctx context.Context, request ShortenRequest) (Link, bool, error) {
+	link, err := upsertAliasedLink(ctx, s.links, s.aliases, request)
+	if err != nil {
+		switch err {
+		case ErrCodeTaken:
+			return Link{}, false, conflictError("alias_taken", "Alias \""+request.Alias+"\" is already in use")
+		case ErrTooManyAliases:
+			return Link{}, false, validationError(&fieldError{Field: "alias", Message: fmt.Sprintf("A link can have at most %d aliases", maxLinkAliases)})
+		case ErrIdempotencyKeyTaken:
+			return s.replayConcurrentShorten(ctx, request)
+		}
+		slog.ErrorContext(ctx, "Error adding alias", "error", err)
+		return Link{}, false, errServiceInternal
+	}
+
+	if err := s.completeLink(ctx, &link, request); err != nil {
+		return Link{}, false, err
+	}
+
+	if link.AttemptCount == 1 {
+		s.webhooks.Emit(link.OrgID, webhookLinkCreated, newWebhookEventData(link))
+		s.titles.Fetch(link)
+	}
+
+	link.Code = request.Alias
+
+	return link, false, nil
+}
+
+// completeLink applies the tags, folder, description and notes of request
+// to a link that may have existed before it.
+func (s *linkService) completeLink(ctx context.Context, link *Link, request ShortenRequest) error {
+	if err := addLinkTags(ctx, s.links, link, request.Tags); err != nil {
+		slog.ErrorContext(ctx, "Error tagging link", "error", err)
+		return errServiceInternal
+	}
+
+	if err := fileLink(ctx, s.folders, link, request.FolderID); err != nil {
+		slog.ErrorContext(ctx, "Error moving link", "error", err)
+		return errServiceInternal
+	}
+
+	if err := describeLink(ctx, s.links, link, request); err != nil {
+		slog.ErrorContext(ctx, "Error updating link", "error", err)
+		return errServiceInternal
+	}
+
+	return nil
+}
+
+// createdLink reports whether shorten created link, rather than replaying
+// it or returning the existing link of an already shortened URL.
+func createdLink(link Link, replayed bool) bool {
+	return !replayed && link.AttemptCount == 1
+}
+
+// fingerprint returns the hex SHA-256 of request as JSON, leaving out the
+// CAPTCHA token, which every attempt solves anew.
+func (request ShortenRequest) fingerprint() string {
+	request.CaptchaToken = ""
+	body, _ := json.Marshal(request)
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// replayShorten returns the link the first attempt of a retried shorten
+// request created. Reusing a key for a different request is refused, as
+// the client would otherwise get a link it did not ask for. Links created
+// before requests were fingerprinted are compared by URL.
+func replayShorten(link Link, request ShortenRequest) (Link, bool, error) {
+	reused := link.URL != request.URL
+	if link.IdempotencyHash != nil {
+		reused = *link.IdempotencyHash != request.IdempotencyHash
+	}
+
+	if reused {
+		return Link{}, false, &serviceError{Status: http.StatusUnprocessableEntity, Code: "idempotency_key_reused", Message: idempotencyKeyHeader + " was already used for a different request"}
+	}
+
+	return link, true, nil
+}
+
+// replayConcurrentShorten is replayShorten for a request that lost the race
+// against another attempt with the same key.
+func (s *linkService) replayConcurrentShorten(ctx context.Context, request ShortenRequest) (Link, bool, error) {
+	link, err := s.links.GetLinkByIdempotencyKey(ctx, orgIDFromContext(ctx), keyIDFromContext(ctx), sessionMemberIDFromContext(ctx), *request.IdempotencyKey)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error querying database", "error", err)
+		return Link{}, false, errServiceInternal
+	}
+
+	return replayShorten(link, request)
+}
+
+// Resolve returns the link of a code for a visit, counting the visit
+// like GET /get-link does. click describes the visitor; its LinkID is
+// filled in. A link that is expired, outside of its schedule, over its
+// click limit or broken resolves to its fallback URL, without counting the
+// visit. Visits count against the redirect quota of the organization
+// owning the link.
+func (s *linkService) Resolve(ctx context.Context, code string, click Click) (Link, error) {
+	link, err := lookupLink(ctx, s.links, s.cache, orgIDFromContext(ctx), domainIDFromContext(ctx), code)
+	if err != nil {
+		return Link{}, s.lookupError(ctx, err)
+	}
+
+	if link.DeletedAt != nil {
+		return Link{}, errServiceLinkDeleted
+	}
+
+	if link.ArchivedAt != nil {
+		return Link{}, errServiceLinkArchived
+	}
+
+	if link.quarantined() {
+		return Link{}, errServiceLinkQuarantined
+	}
+
+	if link.ExpiresAt != nil && !link.ExpiresAt.After(time.Now()) {
+		return resolveFallback(link, errServiceLinkExpired)
+	}
+
+	if !link.isActive(time.Now()) {
+		return resolveFallback(link, errServiceLinkInactive)
+	}
+
+	if link.broken() && link.FallbackURL != nil {
+		return resolveFallback(link, nil)
+	}
+
+	click.DestinationID = routeClick(&link, s.countries, click)
+
+	if checkURLsOnRedirect {
+		if _, unsafe := isUnsafeURL(ctx, s.checker, link.URL); unsafe {
+			return Link{}, &serviceError{Status: http.StatusForbidden, Code: "unsafe_url", Message: "Link destination is flagged as unsafe"}
+		}
+	}
+
+	if !s.redirects.visit(ctx, link) {
+		return Link{}, errServiceQuotaExceeded
+	}
+
+	if link.MaxClicks != nil {
+		allowed, err := s.links.ConsumeClick(ctx, link.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error querying database", "error", err)
+			return Link{}, errServiceInternal
+		}
+
+		if !allowed {
+			return resolveFallback(link, errServiceClickLimitReached)
+		}
+	}
+
+	if isTracked(link) {
+		click.LinkID, click.Code = link.ID, code
+		s.clicks.Record(click)
+
+		data := newWebhookEventData(link)
+		data.Referrer = &click.Referrer
+		s.webhooks.Emit(link.OrgID, webhookLinkClicked, data)
+	}
+
+	return link, nil
+}
+
+// GetLink returns a link with its counts and tags. Deleted links are
+// returned too.
+func (s *linkService) GetLink(ctx context.Context, code string) (Link, error) {
+	link, err := s.links.GetLink(ctx, orgIDFromContext(ctx), domainIDFromContext(ctx), code)
+	if err != nil {
+		return Link{}, s.lookupError(ctx, err)
+	}
+
+	if err := updateLinkTags(ctx, s.links, &link, UpdateLinkRequest{}); err != nil {
+		slog.ErrorContext(ctx, "Error querying database", "error", err)
+		return Link{}, errServiceInternal
+	}
+
+	return link, nil
+}
+
+// ListLinks validates filter like ListLinksHandler validates its query
+// and returns the page. A zero Limit and an empty Sort take their
+// defaults; OrgID is set from the context.
+func (s *linkService) ListLinks(ctx context.Context, filter LinkFilter) (ListLinksResponse, error) {
+	filter.OrgID = orgIDFromContext(ctx)
+	filter.Tag = normalizeTag(filter.Tag)
+
+	if filter.Limit == 0 {
+		filter.Limit = defaultListLimit
+	}
+	if filter.Limit < 1 || filter.Limit > maxListLimit {
+		return ListLinksResponse{}, invalidRequest("limit must be between 1 and 100")
+	}
+
+	if filter.Offset < 0 {
+		return ListLinksResponse{}, invalidRequest("offset must be a non-negative integer")
+	}
+
+	if filter.Sort == "" {
+		filter.Sort = "created_at"
+	}
+	if !linkSortFields[filter.Sort] {
+		return ListLinksResponse{}, invalidRequest("Invalid sort column")
+	}
+
+	if filter.MinClicks < 0 {
+		return ListLinksResponse{}, invalidRequest("min_clicks must be a non-negative integer")
+	}
+
+	page, total, err := s.links.ListLinks(ctx, filter)
+	if err == nil {
+		err = loadLinkTags(ctx, s.links, page)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error querying database", "error", err)
+		return ListLinksResponse{}, errServiceInternal
+	}
+
+	return ListLinksResponse{
+		Links:  page,
+		Total:  total,
+		Limit:  filter.Limit,
+		Offset: filter.Offset,
+	}, nil
+}
+
+// SearchLinks is ListLinks for the links matching every word of query,
+// like GET /links/search. Only the limit and offset of filter are used.
+func (s *linkService) SearchLinks(ctx context.Context, query string, filter LinkFilter) (ListLinksResponse, error) {
+	terms, err := searchTerms(query)
+	if err != nil {
+		return ListLinksResponse{}, invalidRequest(err.Error())
+	}
+
+	filter = LinkFilter{OrgID: orgIDFromContext(ctx), Limit: filter.Limit, Offset: filter.Offset}
+
+	if filter.Limit == 0 {
+		filter.Limit = defaultListLimit
+	}
+	if filter.Limit < 1 || filter.Limit > maxListLimit {
+		return ListLinksResponse{}, invalidRequest("limit must be between 1 and 100")
+	}
+
+	if filter.Offset < 0 {
+		return ListLinksResponse{}, invalidRequest("offset must be a non-negative integer")
+	}
+
+	page, total, err := s.links.SearchLinks(ctx, filter, terms)
+	if err == nil {
+		err = loadLinkTags(ctx, s.links, page)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error querying database", "error", err)
+		return ListLinksResponse{}, errServiceInternal
+	}
+
+	return ListLinksResponse{
+		Links:  page,
+		Total:  total,
+		Limit:  filter.Limit,
+		Offset: filter.Offset,
+	}, nil
+}
+
+// UpdateLink changes the fields of a link that are set in request, like
+// PATCH /links/{code}.
+func (s *linkService) UpdateLink(ctx context.Context, code string, request UpdateLinkRequest) (Link, error) {
+	if err := checkScope(ctx, scopeLinksWrite); err != nil {
+		return Link{}, err
+	}
+
+	if err := validateDestinationsUpdate(ctx, request); err != nil {
+		return Link{}, invalidRequest(err.Error())
+	}
+
+	if request.GeoTargets != nil {
+		if err := prepareGeoTargets(ctx, *request.GeoTargets); err != nil {
+			return Link{}, invalidRequest(err.Error())
+		}
+	}
+
+	if request.DeviceTargets != nil {
+		if err := prepareDeviceTargets(ctx, *request.DeviceTargets); err != nil {
+			return Link{}, invalidRequest(err.Error())
+		}
+	}
+
+	if request.RoutingRules != nil {
+		if err := prepareRoutingRules(ctx, *request.RoutingRules); err != nil {
+			return Link{}, invalidRequest(err.Error())
+		}
+	}
+
+	if err := validateSchedule(request.ActiveFrom, request.ActiveUntil); err != nil {
+		return Link{}, invalidRequest(err.Error())
+	}
+
+	if request.FallbackURL != nil {
+		if err := prepareFallbackURL(ctx, request.FallbackURL); err != nil {
+			return Link{}, invalidRequest(err.Error())
+		}
+	}
+
+	if err := prepareNotes(request.Description, request.Notes); err != nil {
+		return Link{}, invalidRequest(err.Error())
+	}
+
+	if request.Tags != nil {
+		tags, err := normalizeTags(*request.Tags)
+		if err != nil {
+			return Link{}, invalidRequest(err.Error())
+		}
+		request.Tags = &tags
+	}
+
+	for _, rawURL := range request.routedURLs() {
+		if err := s.checkURL(ctx, rawURL); err != nil {
+			return Link{}, err
+		}
+	}
+
+	if request.URL != nil {
+		if *request.URL == "" {
+			return Link{}, invalidRequest("URL is required")
+		}
+
+		unwrapped, err := unwrapURL(ctx, *request.URL)
+		if err != nil {
+			return Link{}, invalidRequest(err.Error())
+		}
+		request.URL = &unwrapped
+
+		if err := s.checkURL(ctx, *request.URL); err != nil {
+			return Link{}, err
+		}
+	}
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		return Link{}, invalidRequest("expires_at must be in the future")
+	}
+
+	if request.RedirectStatus != nil && !isValidRedirectStatus(*request.RedirectStatus) {
+		return Link{}, invalidRequest("redirect_status must be 301, 302 or 307")
+	}
+
+	if err := validateUTMUpdate(request); err != nil {
+		return Link{}, invalidRequest(err.Error())
+	}
+
+	var folderID *int
+	if request.FolderID != nil {
+		var err error
+		if folderID, err = s.folderID(ctx, *request.FolderID); err != nil {
+			return Link{}, err
+		}
+	}
+
+	orgID := orgIDFromContext(ctx)
+	domainID := domainIDFromContext(ctx)
+
+	link, err := s.links.UpdateLink(ctx, orgID, domainID, code, func(link *Link) error {
+		if link.DeletedAt != nil {
+			return ErrLinkDeleted
+		}
+
+		if err := applyDestinationsUpdate(link, request); err != nil {
+			return err
+		}
+		applyGeoTargetsUpdate(link, request)
+		applyDeviceTargetsUpdate(link, request)
+		applyRoutingRulesUpdate(link, request)
+		if err := applyScheduleUpdate(link, request); err != nil {
+			return err
+		}
+		applyFallbackUpdate(link, request)
+		if request.URL != nil {
+			link.URL = *request.URL
+		}
+		if request.ExpiresAt != nil {
+			link.ExpiresAt = request.ExpiresAt
+		}
+		if request.RedirectStatus != nil {
+			link.RedirectStatus = *request.RedirectStatus
+		}
+		if request.TrackingDisabled != nil {
+			link.TrackingDisabled = *request.TrackingDisabled
+		}
+		if request.ForwardQuery != nil {
+			link.ForwardQuery = *request.ForwardQuery
+		}
+		applyUTMUpdate(link, request)
+		applyNotesUpdate(link, request)
+		if request.FolderID != nil {
+			link.FolderID = folderID
+		}
+
+		return nil
+	})
+	if err != nil {
+		switch err {
+		case ErrNotFound:
+			return Link{}, errServiceNotFound
+		case ErrLinkDeleted:
+			return Link{}, errServiceLinkDeleted
+		case ErrURLTaken:
+			return Link{}, conflictError("url_already_shortened", "URL is already shortened under another code")
+		case ErrLinkHasDestinations:
+			return Link{}, invalidRequest(ErrLinkHasDestinations.Error())
+		case ErrInvalidSchedule:
+			return Link{}, invalidRequest(ErrInvalidSchedule.Error())
+		}
+		slog.ErrorContext(ctx, "Error updating link", "error", err)
+		return Link{}, errServiceInternal
+	}
+
+	s.cache.Delete(ctx, orgID, domainID, code)
+
+	if request.URL != nil {
+		s.titles.Fetch(link)
+	}
+
+	if err := updateLinkTags(ctx, s.links, &link, request); err != nil {
+		slog.ErrorContext(ctx, "Error tagging link", "error", err)
+		return Link{}, errServiceInternal
+	}
+
+	return link, nil
+}
+
+// DeleteLink marks a link as deleted, like DELETE /links/{code}.
+func (s *linkService) DeleteLink(ctx context.Context, code string, deleteClicks bool) error {
+	if err := checkScope(ctx, scopeLinksWrite); err != nil {
+		return err
+	}
+
+	orgID := orgIDFromContext(ctx)
+	domainID := domainIDFromContext(ctx)
+
+	err := s.links.DeleteLink(ctx, orgID, domainID, code, deleteClicks)
+	if err != nil {
+		switch err {
+		case ErrNotFound:
+			return errServiceNotFound
+		case ErrLinkDeleted:
+			return errServiceLinkDeleted
+		}
+		slog.ErrorContext(ctx, "Error deleting link", "error", err)
+		return errServiceInternal
+	}
+
+	s.cache.Delete(ctx, orgID, domainID, code)
+
+	return nil
+}
+
+// ArchiveLink archives a link, like POST /links/{code}/archive.
+func (s *linkService) ArchiveLink(ctx context.Context, code string) (Link, error) {
+	if err := checkScope(ctx, scopeLinksWrite); err != nil {
+		return Link{}, err
+	}
+
+	orgID := orgIDFromContext(ctx)
+	domainID := domainIDFromContext(ctx)
+
+	link, err := archiveLink(ctx, s.links, orgID, domainID, code)
+	if err != nil {
+		switch err {
+		case ErrNotFound:
+			return Link{}, errServiceNotFound
+		case ErrLinkDeleted:
+			return Link{}, errServiceLinkDeleted
+		case ErrLinkArchived:
+			return Link{}, conflictError("link_archived", "Link is already archived")
+		}
+		slog.ErrorContext(ctx, "Error archiving link", "error", err)
+		return Link{}, errServiceInternal
+	}
+
+	s.cache.Delete(ctx, orgID, domainID, code)
+
+	return s.withTags(ctx, link)
+}
+
+// RestoreLink brings back an archived or deleted link, like
+// POST /links/{code}/restore.
+func (s *linkService) RestoreLink(ctx context.Context, code string) (Link, error) {
+	if err := checkScope(ctx, scopeLinksWrite); err != nil {
+		return Link{}, err
+	}
+
+	orgID := orgIDFromContext(ctx)
+	domainID := domainIDFromContext(ctx)
+
+	link, err := restoreLink(ctx, s.links, orgID, domainID, code, s.restoreWindow)
+	if err != nil {
+		switch err {
+		case ErrNotFound:
+			return Link{}, errServiceNotFound
+		case ErrRestoreWindowPassed:
+			return Link{}, errServiceRestoreWindowPassed
+		case ErrLinkActive:
+			return Link{}, conflictError("link_active", "Link is neither deleted nor archived")
+		case ErrURLTaken:
+			return Link{}, conflictError("url_already_shortened", "URL is already shortened under another code")
+		}
+		slog.ErrorContext(ctx, "Error restoring link", "error", err)
+		return Link{}, errServiceInternal
+	}
+
+	s.cache.Delete(ctx, orgID, domainID, code)
+
+	return s.withTags(ctx, link)
+}
+
+// withTags fills in the tags of link.
+func (s *linkService) withTags(ctx context.Context, link Link) (Link, error) {
+	page := []Link{link}
+	if err := loadLinkTags(ctx, s.links, page); err != nil {
+		slog.ErrorContext(ctx, "Error querying database", "error", err)
+		return Link{}, errServiceInternal
+	}
+
+	return page[0], nil
+}
+
+// LinkHistory returns a page of the changes made to link, like
+// GET /links/{code}/history. A zero limit is the default page size.
+func (s *linkService) LinkHistory(ctx context.Context, link Link, limit int, offset int) ([]LinkEvent, error) {
+	if limit == 0 {
+		limit = defaultListLimit
+	}
+	if limit < 1 || limit > maxListLimit {
+		return nil, invalidRequest("limit must be between 1 and 100")
+	}
+
+	if offset < 0 {
+		return nil, invalidRequest("offset must be a non-negative integer")
+	}
+
+	events, _, err := s.audit.LinkHistory(ctx, link.ID, limit, offset)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error querying database", "error", err)
+		return nil, errServiceInternal
+	}
+
+	return events, nil
+}
+
+// LinkAliases returns the aliases of a link, oldest first.
+func (s *linkService) LinkAliases(ctx context.Context, link Link) ([]LinkAlias, error) {
+	aliases, err := s.aliases.LinkAliases(ctx, link.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error querying database", "error", err)
+		return nil, errServiceInternal
+	}
+
+	return aliases, nil
+}
+
+// ClickTimeSeries validates filter like GetURLTimeSeriesHandler and
+// returns the clicks of a link per bucket. An empty Granularity is day.
+func (s *linkService) ClickTimeSeries(ctx context.Context, link Link, filter ClickSeriesFilter) ([]ClickBucket, error) {
+	if err := checkScope(ctx, scopeStatsRead); err != nil {
+		return nil, err
+	}
+
+	if filter.Granularity == "" {
+		filter.Granularity = "day"
+	}
+	if !clickGranularities[filter.Granularity] {
+		return nil, invalidRequest("granularity must be day, week or month")
+	}
+
+	if !isValidDate(filter.From) {
+		return nil, invalidRequest("from must be a date such as 2006-01-02")
+	}
+
+	if !isValidDate(filter.To) {
+		return nil, invalidRequest("to must be a date such as 2006-01-02")
+	}
+
+	if filter.From != "" && filter.To != "" && filter.From > filter.To {
+		return nil, invalidRequest("from must not be after to")
+	}
+
+	series, err := s.clickStore.ClickTimeSeries(ctx, link.ID, filter)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error querying database", "error", err)
+		return nil, errServiceInternal
+	}
+
+	return series, nil
+}
+
+// folderID resolves the folder a link is filed in within the caller's
+// namespace.
+func (s *linkService) folderID(ctx context.Context, id int) (*int, error) {
+	folderID, err := resolveFolderID(ctx, s.folders, orgIDFromContext(ctx), id)
+	if err == ErrFolderNotFound {
+		return nil, validationError(&fieldError{Field: "folder_id", Message: "Folder not found"})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error querying database", "error", err)
+		return nil, errServiceInternal
+	}
+
+	return folderID, nil
+}
+
+// domainID resolves the custom domain a link is issued under within the
+// caller's organization, the one of the domain parameter without a
+// hostname.
+func (s *linkService) domainID(ctx context.Context, hostname string) (int, error) {
+	if hostname == "" {
+		return domainIDFromContext(ctx), nil
+	}
+
+	domainID, err := resolveDomainID(ctx, s.orgs, orgIDFromContext(ctx), hostname)
+	switch err {
+	case nil:
+		return domainID, nil
+	case ErrDomainNotFound:
+		return 0, validationError(&fieldError{Field: "domain", Message: "Domain not found"})
+	case errDomainNotVerified:
+		return 0, validationError(&fieldError{Field: "domain", Message: "Domain is not verified"})
+	}
+
+	slog.ErrorContext(ctx, "Error querying database", "error", err)
+	return 0, errServiceInternal
+}
+
+// checkURL applies the domain rules and the URL checker to a destination.
+func (s *linkService) checkURL(ctx context.Context, rawURL string) error {
+	rules, err := s.domains.load(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error querying database", "error", err)
+		return errServiceInternal
+	}
+
+	if message := rules.check(rawURL); message != "" {
+		return &serviceError{Status: http.StatusBadRequest, Code: "domain_not_allowed", Message: message, Details: map[string]interface{}{"field": "url"}}
+	}
+
+	if verdict, unsafe := isUnsafeURL(ctx, s.checker, rawURL); unsafe {
+		details := map[string]interface{}{"field": "url", "threat": verdict.Threat}
+		return &serviceError{Status: http.StatusBadRequest, Code: "unsafe_url", Message: unsafeURLMessage(verdict), Details: details}
+	}
+
+	return nil
+}
+
+func (s *linkService) lookupError(ctx context.Context, err error) error {
+	if err == ErrNotFound {
+		return errServiceNotFound
+	}
+
+	slog.ErrorContext(ctx, "Error querying database", "error", err)
+	return errServiceInternal
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+)
+
+const (
+	sessionIssuer        = "wowee-link-api"
+	defaultSessionTTL    = 12 * time.Hour
+	minSessionSecretSize = 32
+)
+
+// sessionHeader is the JOSE header of every session token; tokens with
+// another one are rejected, so that no other algorithm is ever accepted.
+var sessionHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+var (
+	errSessionInvalid = errors.New("invalid session token")
+	errSessionExpired = errors.New("session expired")
+)
+
+// SessionIssuer issues the session tokens members get by logging in with
+// an identity provider: JWTs signed with HMAC-SHA256 under SESSION_SECRET,
+// as RFC 7519 libraries verify them. They are sent as bearer tokens in
+// place of an API key and act as the member with every scope. Everything
+// else it signs is signed with keys derived from the secret, so that none
+// of it can pass for a session token.
+type SessionIssuer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+type sessionClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	OrgID     int    `json:"org"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	ID        string `json:"jti"`
+}
+
+// NewSessionIssuer returns nil when SESSION_SECRET is not set.
+func NewSessionIssuer(cfg *config.Config) (*SessionIssuer, error) {
+	secret := cfg.String("SESSION_SECRET")
+	if secret == "" {
+		return nil, nil
+	}
+	if len(secret) < minSessionSecretSize {
+		return nil, errors.New("SESSION_SECRET must be at least 32 characters")
+	}
+
+	ttl := cfg.Duration("SESSION_TTL")
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+
+	return &SessionIssuer{secret: []byte(secret), ttl: ttl}, nil
+}
+
+// Issue returns a session token of member and when it expires.
+func (s *SessionIssuer) Issue(member Member) (string, time.Time, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.ttl)
+
+	payload, err := json.Marshal(sessionClaims{
+		Issuer:    sessionIssuer,
+		Subject:   strconv.Itoa(member.ID),
+		OrgID:     member.OrgID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+		ID:        hex.EncodeToString(id),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	signed := sessionHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	return signed + "." + signHS256(s.secret, signed), expiresAt.Truncate(time.Second), nil
+}
+
+// Verify checks the signature and expiration of token and returns its
+// claims.
+func (s *SessionIssuer) Verify(token string) (sessionClaims, error) {
+	var claims sessionClaims
+
+	header, rest, _ := strings.Cut(token, ".")
+	payload, signature, _ := strings.Cut(rest, ".")
+	if header != sessionHeader || !verifyHS256(s.secret, header+"."+payload, signature) {
+		return claims, errSessionInvalid
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return claims, errSessionInvalid
+	}
+	if err := json.Unmarshal(data, &claims); err != nil || claims.Issuer != sessionIssuer {
+		return claims, errSessionInvalid
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return claims, errSessionExpired
+	}
+
+	return claims, nil
+}
+
+// seal encodes v as JSON and signs it with the key of purpose.
+func (s *SessionIssuer) seal(purpose string, v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	value := base64.RawURLEncoding.EncodeToString(data)
+	return value + "." + signHS256(s.purposeKey(purpose), value), nil
+}
+
+// open decodes into v what seal signed for purpose, and reports whether
+// the signature holds.
+func (s *SessionIssuer) open(purpose string, sealed string, v interface{}) bool {
+	value, signature, _ := strings.Cut(sealed, ".")
+	if !verifyHS256(s.purposeKey(purpose), value, signature) {
+		return false
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	return err == nil && json.Unmarshal(data, v) == nil
+}
+
+// purposeKey derives the key of purpose from the secret, so that a
+// signature made for one use is worthless for another.
+func (s *SessionIssuer) purposeKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(sessionIssuer + ":" + purpose))
+	return mac.Sum(nil)
+}
+
+// signHS256 returns the HMAC-SHA256 of data under key, encoded as in JWTs.
+func signHS256(key []byte, data string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func verifyHS256(key []byte, data string, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(signHS256(key, data)))
+}
+
+// isSessionToken tells session tokens from API keys, which have a prefix
+// and no dots.
+func isSessionToken(key string) bool {
+	return !strings.HasPrefix(key, apiKeyPrefix) && strings.Count(key, ".") == 2
+}
+
+// authenticateSession resolves a session token to the member it was issued
+// to, as they are now: removed members lose their sessions and members
+// whose role changed act with their new one.
+func authenticateSession(ctx context.Context, orgs OrgStore, sessions *SessionIssuer, token string) (caller, error) {
+	claims, err := sessions.Verify(token)
+	if err != nil {
+		return caller{}, err
+	}
+
+	memberID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return caller{}, errSessionInvalid
+	}
+
+	member, err := orgs.GetMember(ctx, claims.OrgID, memberID)
+	if err == ErrMemberNotFound {
+		return caller{}, errSessionInvalid
+	}
+	if err != nil {
+		return caller{}, err
+	}
+
+	return caller{
+		OrgID:    member.OrgID,
+		MemberID: member.ID,
+		Role:     member.Role,
+		Scopes:   append(apiKeyScopes(nil), apiKeyScopeNames...),
+	}, nil
+}
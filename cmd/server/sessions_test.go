@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSessionSecret = "0123456789abcdef0123456789abcdef"
+
+// testSessionToken signs claims under header with secret, as Issue would
+// for the claims it makes.
+func testSessionToken(t *testing.T, secret string, header string, claims sessionClaims) string {
+	t.Helper()
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("marshaling claims: %v", err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + signHS256([]byte(secret), signed)
+}
+
+func TestSessionIssuerVerify(t *testing.T) {
+	sessions := &SessionIssuer{secret: []byte(testSessionSecret), ttl: time.Hour}
+
+	member := Member{ID: 7, OrgID: 3}
+	issued, _, err := sessions.Issue(member)
+	if err != nil {
+		t.Fatalf("Issue error = %v", err)
+	}
+
+	now := time.Now().Unix()
+	valid := sessionClaims{Issuer: sessionIssuer, Subject: "7", OrgID: 3, IssuedAt: now, ExpiresAt: now + 60, ID: "abc"}
+	expired := valid
+	expired.IssuedAt, expired.ExpiresAt = now-120, now-60
+	expiring := valid
+	expiring.ExpiresAt = now
+	otherIssuer := valid
+	otherIssuer.Issuer = "someone-else"
+
+	const hs256 = `{"alg":"HS256","typ":"JWT"}`
+	parts := strings.Split(issued, ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"issued", issued, nil},
+		{"valid", testSessionToken(t, testSessionSecret, hs256, valid), nil},
+		{"expired", testSessionToken(t, testSessionSecret, hs256, expired), errSessionExpired},
+		{"expiring now", testSessionToken(t, testSessionSecret, hs256, expiring), errSessionExpired},
+		{"wrong secret", testSessionToken(t, testSessionSecret+"x", hs256, valid), errSessionInvalid},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2])), errSessionInvalid},
+		{"missing signature", parts[0] + "." + parts[1] + ".", errSessionInvalid},
+		{"tampered claims", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"wowee-link-api","sub":"1","org":3,"exp":9999999999}`)) + "." + parts[2], errSessionInvalid},
+		{"alg none", testSessionToken(t, testSessionSecret, `{"alg":"none","typ":"JWT"}`, valid), errSessionInvalid},
+		{"alg none unsigned", base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + ".", errSessionInvalid},
+		{"alg HS512", testSessionToken(t, testSessionSecret, `{"alg":"HS512","typ":"JWT"}`, valid), errSessionInvalid},
+		{"alg RS256", testSessionToken(t, testSessionSecret, `{"alg":"RS256","typ":"JWT"}`, valid), errSessionInvalid},
+		{"header spelled differently", testSessionToken(t, testSessionSecret, `{"typ":"JWT","alg":"HS256"}`, valid), errSessionInvalid},
+		{"other issuer", testSessionToken(t, testSessionSecret, hs256, otherIssuer), errSessionInvalid},
+		{"API key", apiKeyPrefix + "0123456789abcdef", errSessionInvalid},
+		{"empty", "", errSessionInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := sessions.Verify(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (claims.Subject != "7" || claims.OrgID != 3) {
+				t.Errorf("Verify claims = %+v, want member 7 of organization 3", claims)
+			}
+		})
+	}
+}
+
+func TestSessionIssuerIssueExpires(t *testing.T) {
+	sessions := &SessionIssuer{secret: []byte(testSessionSecret), ttl: -time.Second}
+
+	token, expiresAt, err := sessions.Issue(Member{ID: 1, OrgID: 1})
+	if err != nil {
+		t.Fatalf("Issue error = %v", err)
+	}
+	if !expiresAt.Before(time.Now()) {
+		t.Errorf("Issue expires at %v, want a time in the past", expiresAt)
+	}
+
+	if _, err := sessions.Verify(token); !errors.Is(err, errSessionExpired) {
+		t.Errorf("Verify error = %v, want %v", err, errSessionExpired)
+	}
+}
+
+func TestSessionIssuerSealIsNotASession(t *testing.T) {
+	sessions := &SessionIssuer{secret: []byte(testSessionSecret), ttl: time.Hour}
+
+	sealed, err := sessions.seal("login", sessionClaims{Issuer: sessionIssuer, Subject: "1", OrgID: 1, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("seal error = %v", err)
+	}
+
+	if _, err := sessions.Verify(sessionHeader + "." + sealed); !errors.Is(err, errSessionInvalid) {
+		t.Errorf("Verify of a sealed value error = %v, want %v", err, errSessionInvalid)
+	}
+
+	var claims sessionClaims
+	if sessions.open("invite", sealed, &claims) {
+		t.Error("open for another purpose succeeded")
+	}
+	if !sessions.open("login", sealed, &claims) || claims.Subject != "1" {
+		t.Errorf("open = %+v, want the sealed claims", claims)
+	}
+}
+
+func TestAuthenticateSession(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	sessions := &SessionIssuer{secret: []byte(testSessionSecret), ttl: time.Hour}
+
+	_, _, owner := newTestOrg(t, store, "acme")
+	member := Member{OrgID: owner.OrgID, Email: "member@acme.test", Role: roleMember}
+	if err := store.AddMember(ctx, &member); err != nil {
+		t.Fatalf("adding member: %v", err)
+	}
+
+	token, _, err := sessions.Issue(member)
+	if err != nil {
+		t.Fatalf("Issue error = %v", err)
+	}
+
+	c, err := authenticateSession(ctx, store, sessions, token)
+	if err != nil {
+		t.Fatalf("authenticateSession error = %v", err)
+	}
+	if c.OrgID != member.OrgID || c.MemberID != member.ID || c.Role != roleMember || c.KeyID != 0 {
+		t.Errorf("authenticateSession = %+v, want member %d of organization %d", c, member.ID, member.OrgID)
+	}
+	if len(c.Scopes) != len(apiKeyScopeNames) {
+		t.Errorf("authenticateSession scopes = %v, want %v", c.Scopes, apiKeyScopeNames)
+	}
+
+	// A session issued for the member in another organization names a
+	// member that does not exist there.
+	moved := member
+	moved.OrgID = owner.OrgID + 1
+	token, _, err = sessions.Issue(moved)
+	if err != nil {
+		t.Fatalf("Issue error = %v", err)
+	}
+	if _, err := authenticateSession(ctx, store, sessions, token); !errors.Is(err, errSessionInvalid) {
+		t.Errorf("authenticateSession in another organization error = %v, want %v", err, errSessionInvalid)
+	}
+
+	// Removing the member revokes the sessions they were issued.
+	token, _, err = sessions.Issue(member)
+	if err != nil {
+		t.Fatalf("Issue error = %v", err)
+	}
+	if err := store.RemoveMember(ctx, member.OrgID, member.ID); err != nil {
+		t.Fatalf("removing member: %v", err)
+	}
+	if _, err := authenticateSession(ctx, store, sessions, token); !errors.Is(err, errSessionInvalid) {
+		t.Errorf("authenticateSession of a removed member error = %v, want %v", err, errSessionInvalid)
+	}
+}
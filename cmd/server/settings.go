@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+)
+
+// settings are the settings of the server. Each one is read from the
+// flag, environment variable or config file key of its name; see
+// config.Load for the precedence.
+var settings = []config.Setting{
+	{Name: "DATABASE_DRIVER", Kind: config.String, Default: "postgres", Usage: "database to store links in: postgres, sqlite or mysql"},
+	{Name: "DATABASE_URL", Kind: config.String, Usage: "connection URL of the database"},
+	{Name: "DATABASE_REPLICA_URL", Kind: config.String, Usage: "connection URL of a read replica for redirect lookups and stats"},
+	{Name: "DATABASE_MAX_OPEN_CONNS", Kind: config.Int, Default: strconv.Itoa(defaultMaxOpenConns), Usage: "connections the pool opens at most, 0 for no limit"},
+	{Name: "DATABASE_MAX_IDLE_CONNS", Kind: config.Int, Default: strconv.Itoa(defaultMaxIdleConns), Usage: "idle connections the pool keeps"},
+	{Name: "DATABASE_CONN_MAX_LIFETIME", Kind: config.Duration, Default: defaultConnMaxLifetime.String(), Usage: "how long a connection is reused, 0 for no limit"},
+	{Name: "CLICKHOUSE_URL", Kind: config.String, Usage: "ClickHouse HTTP interface to store click events in"},
+	{Name: "AUTO_MIGRATE", Kind: config.Bool, Default: "true", Usage: "apply pending migrations on startup"},
+
+	{Name: "LISTEN_ADDR", Kind: config.String, Default: defaultListenAddr, Usage: "host:port, or unix:/path, to serve HTTP on"},
+	{Name: "TLS_CERT_FILE", Kind: config.String, Usage: "certificate to serve TLS with"},
+	{Name: "TLS_KEY_FILE", Kind: config.String, Usage: "private key of TLS_CERT_FILE"},
+	{Name: "TLS_AUTOCERT_DOMAINS", Kind: config.List, Usage: "domains to obtain Let's Encrypt certificates for, besides verified custom domains"},
+	{Name: "TLS_AUTOCERT_EMAIL", Kind: config.String, Usage: "contact address of the Let's Encrypt account"},
+	{Name: "TLS_AUTOCERT_CACHE_DIR", Kind: config.String, Default: defaultAutocertCacheDir, Usage: "directory Let's Encrypt certificates are kept in"},
+	{Name: "DEBUG_ADDR", Kind: config.String, Usage: "internal host:port to serve pprof and expvar on without authentication"},
+	{Name: "GRPC_PORT", Kind: config.String, Usage: "port to serve the gRPC API on"},
+	{Name: "BASE_URL", Kind: config.String, Usage: "public URL short links are built on"},
+	{Name: "TRUST_PROXY_HEADERS", Kind: config.Bool, Default: "false", Usage: "trust X-Forwarded-* headers"},
+	{Name: "LOG_LEVEL", Kind: config.String, Default: "info", Usage: "debug, info, warn or error"},
+
+	{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", Kind: config.String, Usage: "OTLP/HTTP collector to export traces to"},
+	{Name: "OTEL_SERVICE_NAME", Kind: config.String, Default: defaultServiceName, Usage: "service name spans are reported under"},
+	{Name: "TRACE_SAMPLE_RATIO", Kind: config.String, Default: "1", Usage: "share of traces to sample, from 0 to 1"},
+
+	{Name: "CODE_LENGTH", Kind: config.Int, Default: strconv.Itoa(defaultCodeLength), Usage: "length of generated codes"},
+	{Name: "CODE_MAX_LENGTH", Kind: config.Int, Default: strconv.Itoa(defaultMaxCodeLength), Usage: "longest code length clients may ask for"},
+	{Name: "CODE_CHARSET", Kind: config.String, Default: defaultCharset, Usage: "characters generated codes are made of"},
+	{Name: "CODE_STRATEGY", Kind: config.String, Default: codeStrategyRandom, Usage: "how codes are generated: random or sequential"},
+	{Name: "CODE_SEQUENCE_SECRET", Kind: config.String, Usage: "secret that scrambles the order of sequential codes"},
+	{Name: "CODE_SEQUENCE_BLOCK", Kind: config.Int, Default: strconv.Itoa(defaultCodeSequenceBlock), Usage: "sequential codes an instance reserves at a time"},
+	{Name: "RESERVED_CODES", Kind: config.List, Usage: "codes that cannot be used, besides the built-in ones"},
+
+	{Name: "REDIS_URL", Kind: config.String, Usage: "Redis server for the link cache and rate limits"},
+	{Name: "CACHE_STORE", Kind: config.String, Usage: "where links are cached: redis, memory or none; unset, redis when REDIS_URL is set"},
+	{Name: "CACHE_MAX_ENTRIES", Kind: config.Int, Default: strconv.Itoa(defaultCacheMaxEntries), Usage: "links the memory cache holds at most"},
+	{Name: "CACHE_TTL", Kind: config.Duration, Default: defaultCacheTTL.String(), Usage: "how long links are cached"},
+	{Name: "REDIRECT_CACHE_CONTROL", Kind: config.String, Default: defaultRedirectCacheControl, Usage: "Cache-Control header of redirects"},
+	{Name: "REDIRECT_HEAD_CLICKS", Kind: config.Bool, Default: "false", Usage: "count HEAD requests of redirects as clicks"},
+
+	{Name: "ADMIN_API_KEY", Kind: config.String, Usage: "deployment-wide key that may create organizations"},
+	{Name: "SESSION_SECRET", Kind: config.String, Usage: "secret of at least 32 characters session tokens and stats share links are signed with"},
+	{Name: "SESSION_TTL", Kind: config.Duration, Default: defaultSessionTTL.String(), Usage: "how long session tokens of members who logged in are valid"},
+	{Name: "GOOGLE_CLIENT_ID", Kind: config.String, Usage: "OAuth client ID to log members in with Google"},
+	{Name: "GOOGLE_CLIENT_SECRET", Kind: config.String, Usage: "OAuth client secret of GOOGLE_CLIENT_ID"},
+	{Name: "GITHUB_CLIENT_ID", Kind: config.String, Usage: "OAuth app client ID to log members in with GitHub"},
+	{Name: "GITHUB_CLIENT_SECRET", Kind: config.String, Usage: "OAuth app client secret of GITHUB_CLIENT_ID"},
+	{Name: "OIDC_ISSUER_URL", Kind: config.String, Usage: "issuer of an OpenID Connect provider, such as a Keycloak realm, to log members in with"},
+	{Name: "OIDC_CLIENT_ID", Kind: config.String, Usage: "client ID at OIDC_ISSUER_URL"},
+	{Name: "OIDC_CLIENT_SECRET", Kind: config.String, Usage: "client secret of OIDC_CLIENT_ID"},
+	{Name: "SIGNATURE_MAX_SKEW", Kind: config.Duration, Default: defaultSignatureSkew.String(), Usage: "how far the timestamp of a signed request may be from now"},
+	{Name: "API_KEY_ROTATION_GRACE", Kind: config.Duration, Default: defaultAPIKeyGrace.String(), Usage: "how long a rotated API key keeps working"},
+	{Name: "PRIVACY_MODE", Kind: config.Bool, Default: "false", Usage: "turn off click tracking for every link"},
+	{Name: "CLICK_EVENT_RETENTION", Kind: config.Duration, Usage: "how long click events are kept"},
+	{Name: "RESTORE_WINDOW", Kind: config.Duration, Default: defaultRestoreWindow.String(), Usage: "how long deleted links can be restored, 0 for no limit"},
+	{Name: "CLICK_ROLLUP_INTERVAL", Kind: config.Duration, Default: defaultClickRollupInterval.String(), Usage: "how often weekly and monthly clicks are rolled up"},
+	{Name: "SWAGGER_UI", Kind: config.Bool, Default: "false", Usage: "serve the API documentation on /docs"},
+
+	{Name: "CORS_ALLOWED_ORIGINS", Kind: config.List, Usage: "origins browsers may call the API from, or *"},
+	{Name: "CORS_ALLOWED_METHODS", Kind: config.List, Default: defaultCORSMethods, Usage: "methods allowed in CORS requests"},
+	{Name: "CORS_ALLOWED_HEADERS", Kind: config.List, Default: defaultCORSHeaders, Usage: "headers allowed in CORS requests"},
+	{Name: "CORS_MAX_AGE", Kind: config.Duration, Default: defaultCORSMaxAge.String(), Usage: "how long browsers may cache preflight responses"},
+
+	{Name: "RATE_LIMIT_STORE", Kind: config.String, Default: "memory", Usage: "where rate limits are counted: memory or redis"},
+	{Name: "RATE_LIMIT_SHORTEN_PER_IP", Kind: config.Int, Default: "30", Usage: "shortens per minute and address"},
+	{Name: "RATE_LIMIT_SHORTEN_PER_KEY", Kind: config.Int, Default: "300", Usage: "shortens per minute and API key"},
+	{Name: "RATE_LIMIT_REDIRECT_PER_IP", Kind: config.Int, Default: "600", Usage: "redirects per minute and address"},
+	{Name: "RATE_LIMIT_REDIRECT_PER_KEY", Kind: config.Int, Default: "6000", Usage: "redirects per minute and API key"},
+	{Name: "RATE_LIMIT_PREVIEW_PER_IP", Kind: config.Int, Default: "30", Usage: "previews per minute and address"},
+	{Name: "RATE_LIMIT_PREVIEW_PER_KEY", Kind: config.Int, Default: "300", Usage: "previews per minute and API key"},
+	{Name: "RATE_LIMIT_GRAPHQL_PER_IP", Kind: config.Int, Default: "120", Usage: "GraphQL requests per minute and address"},
+	{Name: "RATE_LIMIT_GRAPHQL_PER_KEY", Kind: config.Int, Default: "1200", Usage: "GraphQL requests per minute and API key"},
+	{Name: "RATE_LIMIT_ALIAS_PER_IP", Kind: config.Int, Default: "120", Usage: "alias availability checks per minute and address"},
+	{Name: "RATE_LIMIT_ALIAS_PER_KEY", Kind: config.Int, Default: "1200", Usage: "alias availability checks per minute and API key"},
+	{Name: "RATE_LIMIT_REPORT_PER_IP", Kind: config.Int, Default: "5", Usage: "link reports per minute and address"},
+	{Name: "RATE_LIMIT_REPORT_PER_KEY", Kind: config.Int, Default: "60", Usage: "link reports per minute and API key"},
+	{Name: "ENUMERATION_MISSES_PER_MINUTE", Kind: config.Int, Default: "30", Usage: "unknown codes per minute and anonymous address before it is banned, 0 for no limit"},
+	{Name: "ENUMERATION_BAN", Kind: config.Duration, Default: "1m", Usage: "first ban of an address scanning for codes, doubled for every repeat"},
+	{Name: "ENUMERATION_MAX_BAN", Kind: config.Duration, Default: "1h", Usage: "longest ban of an address scanning for codes"},
+	{Name: "QUOTA_SHORTEN_PER_MONTH", Kind: config.Int, Default: "10000", Usage: "links created per month and organization API key or logged-in member, 0 for no limit"},
+	{Name: "QUOTA_REDIRECT_PER_MONTH", Kind: config.Int, Default: "0", Usage: "redirects per month and organization owning the links, 0 for no limit"},
+
+	{Name: "GEOIP_DB_PATH", Kind: config.String, Usage: "MaxMind country database to resolve click countries with"},
+	{Name: "GEOIP_RELOAD_INTERVAL", Kind: config.Duration, Default: defaultGeoIPReloadInterval.String(), Usage: "how often GEOIP_DB_PATH is checked for changes"},
+	{Name: "SAFE_BROWSING_API_KEY", Kind: config.String, Usage: "Google Safe Browsing key to check destinations with"},
+	{Name: "SAFE_BROWSING_CACHE_TTL", Kind: config.Duration, Default: defaultVerdictTTL.String(), Usage: "how long Safe Browsing verdicts are cached"},
+	{Name: "SAFE_BROWSING_ON_REDIRECT", Kind: config.Bool, Default: "false", Usage: "check destinations again on every redirect"},
+	{Name: "VIRUSTOTAL_API_KEY", Kind: config.String, Usage: "VirusTotal key to scan new destinations with"},
+	{Name: "VIRUSTOTAL_MIN_DETECTIONS", Kind: config.Int, Default: strconv.Itoa(defaultVirusTotalDetections), Usage: "VirusTotal engines that must flag a destination to quarantine its link"},
+	{Name: "PHISHTANK_APP_KEY", Kind: config.String, Usage: "PhishTank application key to scan new destinations with"},
+	{Name: "OPENPHISH_FEED_URL", Kind: config.String, Usage: "OpenPhish feed to scan new destinations against, such as https://openphish.com/feed.txt"},
+	{Name: "OPENPHISH_REFRESH_INTERVAL", Kind: config.Duration, Default: defaultOpenPhishRefresh.String(), Usage: "how often OPENPHISH_FEED_URL is downloaded again"},
+	{Name: "CAPTCHA_PROVIDER", Kind: config.String, Usage: "hcaptcha or recaptcha to require a CAPTCHA for shortening without an API key"},
+	{Name: "CAPTCHA_SECRET", Kind: config.String, Usage: "secret key of the CAPTCHA_PROVIDER site"},
+	{Name: "HEALTH_CHECK_INTERVAL", Kind: config.Duration, Usage: "how often destinations are checked for dead links"},
+	{Name: "DOMAIN_BLOCKLIST", Kind: config.List, Usage: "domains links may not point to"},
+	{Name: "DOMAIN_ALLOWLIST", Kind: config.List, Usage: "the only domains links may point to"},
+	{Name: "SHORT_DOMAINS", Kind: config.List, Default: "wowee.link", Usage: "hosts this shortener serves links on"},
+	{Name: "KNOWN_SHORTENERS", Kind: config.List, Default: strings.Join(defaultKnownShorteners, ","), Usage: "shorteners whose links are unwrapped"},
+	{Name: "UNWRAP_MAX_REDIRECTS", Kind: config.Int, Default: strconv.Itoa(defaultMaxUnwrapRedirects), Usage: "redirects followed to unwrap a shortened link"},
+	{Name: "STRIP_TRACKING_PARAMS", Kind: config.Bool, Default: "false", Usage: "ignore tracking query parameters when deduplicating destinations"},
+	{Name: "TRACKING_PARAMS", Kind: config.List, Default: strings.Join(defaultTrackingParams, ","), Usage: "query parameters STRIP_TRACKING_PARAMS ignores, * matching any suffix"},
+	{Name: "BOT_IP_RANGES", Kind: config.List, Default: strings.Join(defaultBotRanges, ","), Usage: "address ranges clicks are counted as bot clicks from"},
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// shareParam carries the token of a stats share link.
+	shareParam = "share"
+
+	defaultShareTTL = 7 * 24 * time.Hour
+	maxShareTTL     = 90 * 24 * time.Hour
+)
+
+var (
+	errShareInvalid = errors.New("invalid share token")
+	errShareExpired = errors.New("share token expired")
+)
+
+// shareClaims are what a share token grants: reading the stats of one link
+// until ExpiresAt. The domain is part of them because DomainMiddleware
+// cannot resolve it for a request without a caller.
+type shareClaims struct {
+	LinkID    int   `json:"link"`
+	OrgID     int   `json:"org"`
+	DomainID  int   `json:"domain,omitempty"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+// StatsShareResponse carries a share link of the stats of a link.
+type StatsShareResponse struct {
+	URL         string    `json:"url"`
+	Token       string    `json:"token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	ElapsedTime int64     `json:"elapsed_time"`
+}
+
+// ShareStatsHandler issues a link to the stats of the link with the code
+// in the path that needs no API key, for ttl_seconds or a week by default.
+// Share links are signed with SESSION_SECRET and cannot be revoked one by
+// one; changing the secret revokes them all.
+func ShareStatsHandler(links LinkStore, sessions *SessionIssuer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		if _, ok := requireRole(w, r, roleMember); !ok {
+			return
+		}
+
+		var request StatsShareRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				writeInvalidBody(w, err)
+				return
+			}
+		}
+
+		ttl := defaultShareTTL
+		if request.TTLSeconds != nil {
+			if *request.TTLSeconds <= 0 || time.Duration(*request.TTLSeconds)*time.Second > maxShareTTL {
+				writeValidationError(w, &fieldError{Field: "ttl_seconds", Message: "ttl_seconds must be between 1 and " + strconv.Itoa(int(maxShareTTL.Seconds()))})
+				return
+			}
+			ttl = time.Duration(*request.TTLSeconds) * time.Second
+		}
+
+		code := mux.Vars(r)["code"]
+		link, err := links.GetLink(r.Context(), orgIDFromContext(r.Context()), domainIDFromContext(r.Context()), code)
+		if err == nil && link.DeletedAt != nil {
+			err = ErrNotFound
+		}
+		if err != nil {
+			if err == ErrNotFound {
+				writeError(w, http.StatusNotFound, "Link not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		expiresAt := startTime.Add(ttl).Truncate(time.Second)
+		token, err := sessions.seal("stats-share", shareClaims{
+			LinkID:    link.ID,
+			OrgID:     orgIDFromContext(r.Context()),
+			DomainID:  domainIDFromContext(r.Context()),
+			ExpiresAt: expiresAt.Unix(),
+		})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error signing share token", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		base := baseURL
+		if base == "" {
+			base = requestScheme(r) + "://" + forwardedHost(r)
+		}
+
+		response := StatsShareResponse{
+			URL:         base + apiPrefix + "/stats/" + url.PathEscape(code) + "?" + url.Values{shareParam: {token}}.Encode(),
+			Token:       token,
+			ExpiresAt:   expiresAt,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(jsonResponse)
+	}
+}
+
+// StatsShareMiddleware lets requests with a share token in the share
+// parameter read the stats of the link it was issued for, and of no other,
+// as a caller of its organization with only the stats:read scope. It does
+// nothing when sessions is nil.
+func StatsShareMiddleware(links LinkStore, sessions *SessionIssuer) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if sessions == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.URL.Query().Get(shareParam)
+			if token == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := verifyShare(r.Context(), links, sessions, token, mux.Vars(r)["code"])
+			if err != nil {
+				if failure, ok := authenticationFailures[err]; ok {
+					writeErrorCode(w, http.StatusUnauthorized, failure.Code, failure.Message, nil)
+				} else {
+					slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+					writeError(w, http.StatusInternalServerError, "Internal Server Error")
+				}
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), callerKey, caller{OrgID: claims.OrgID, Scopes: apiKeyScopes{scopeStatsRead}, Share: true})
+			ctx = context.WithValue(ctx, domainIDKey, claims.DomainID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// sharedLinkStats is what share links show of link: its code, title and
+// counts, without the notes, targeting and other settings that are for the
+// team managing it.
+func sharedLinkStats(link Link) Link {
+	return Link{
+		ID:           link.ID,
+		Code:         link.Code,
+		URL:          link.URL,
+		CreatedAt:    link.CreatedAt,
+		AttemptCount: link.AttemptCount,
+		ClickCount:   link.ClickCount,
+		BotClicks:    link.BotClicks,
+		ExpiresAt:    link.ExpiresAt,
+		DeletedAt:    link.DeletedAt,
+		Title:        link.Title,
+		ElapsedTime:  link.ElapsedTime,
+	}
+}
+
+// verifyShare checks token and that code is one of the link it was issued
+// for.
+func verifyShare(ctx context.Context, links LinkStore, sessions *SessionIssuer, token string, code string) (shareClaims, error) {
+	var claims shareClaims
+	if !sessions.open("stats-share", token, &claims) || claims.LinkID == 0 {
+		return claims, errShareInvalid
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return claims, errShareExpired
+	}
+
+	link, err := links.GetLink(ctx, claims.OrgID, claims.DomainID, code)
+	if err == ErrNotFound || (err == nil && link.ID != claims.LinkID) {
+		return claims, errShareInvalid
+	}
+	if err != nil {
+		return claims, err
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+)
+
+const defaultMaxUnwrapRedirects = 5
+
+// defaultKnownShorteners are other shorteners whose links are unwrapped
+// before they are shortened again.
+var defaultKnownShorteners = []string{
+	"bit.ly", "bitly.com", "buff.ly", "cutt.ly", "goo.gl", "is.gd", "j.mp",
+	"ow.ly", "rb.gy", "rebrand.ly", "shorturl.at", "t.co", "t.ly", "tiny.cc",
+	"tinyurl.com", "v.gd",
+}
+
+// shortDomains are the hosts this shortener serves links on, set from
+// SHORT_DOMAINS. knownShorteners and maxUnwrapRedirects are set from
+// KNOWN_SHORTENERS and UNWRAP_MAX_REDIRECTS.
+var (
+	shortDomains       = []string{"wowee.link"}
+	knownShorteners    = defaultKnownShorteners
+	maxUnwrapRedirects = defaultMaxUnwrapRedirects
+)
+
+var (
+	errShortenedURL     = errors.New("URL is already shortened")
+	errRedirectLoop     = errors.New("URL redirects in a loop")
+	errTooManyRedirects = errors.New("URL redirects through too many shorteners")
+	errUnresolvedURL    = errors.New("Shortened URL could not be resolved")
+	errMissingLocation  = errors.New("redirect has no Location")
+)
+
+// unwrapClient makes one request per hop so that every hop can be checked.
+// It shares the transport of pageClient and only reaches public addresses.
+var unwrapClient = &http.Client{
+	Timeout:   pageFetchTimeout,
+	Transport: pageClient.Transport,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func loadShortenerConfig(cfg *config.Config) error {
+	domains, err := configDomains(cfg, "SHORT_DOMAINS")
+	if err != nil {
+		return err
+	}
+	shortDomains = domains
+
+	shorteners, err := configDomains(cfg, "KNOWN_SHORTENERS")
+	if err != nil {
+		return err
+	}
+	knownShorteners = shorteners
+
+	redirects := cfg.Int("UNWRAP_MAX_REDIRECTS")
+	if redirects < 0 {
+		return errors.New("UNWRAP_MAX_REDIRECTS must not be negative")
+	}
+	maxUnwrapRedirects = redirects
+
+	return nil
+}
+
+// unwrapURL returns the destination to store for rawURL. URLs on this
+// shortener are refused, and URLs on known shorteners are followed, up to
+// maxUnwrapRedirects redirects, until they leave them. The returned errors
+// are meant for the client.
+func unwrapURL(ctx context.Context, rawURL string) (string, error) {
+	visited := make(map[string]bool)
+	current := rawURL
+
+	for hops := 0; ; hops++ {
+		host := urlHost(current)
+
+		if matchesDomain(host, shortDomains) {
+			if hops == 0 {
+				return "", errShortenedURL
+			}
+			return "", errRedirectLoop
+		}
+
+		if !matchesDomain(host, knownShorteners) {
+			return current, nil
+		}
+
+		if visited[current] {
+			return "", errRedirectLoop
+		}
+		visited[current] = true
+
+		if hops == maxUnwrapRedirects {
+			return "", errTooManyRedirects
+		}
+
+		next, err := followRedirect(ctx, current)
+		if err != nil {
+			slog.WarnContext(ctx, "Error unwrapping shortened URL", "error", err, "url", current)
+			return "", errUnresolvedURL
+		}
+
+		// A shortener page that does not redirect is the destination.
+		if next == "" {
+			return current, nil
+		}
+
+		current = next
+	}
+}
+
+// followRedirect requests rawURL and returns where it redirects to, or an
+// empty string when it answers without redirecting.
+func followRedirect(ctx context.Context, rawURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return "", errors.New("destination is not an http or https URL")
+	}
+
+	req.Header.Set("User-Agent", "wowee-link-unwrap")
+
+	resp, err := unwrapClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 300 && resp.StatusCode <= 399:
+		location, err := resp.Location()
+		if err != nil {
+			return "", errMissingLocation
+		}
+		return location.String(), nil
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return "", nil
+	default:
+		return "", errors.New("shortener responded with " + resp.Status)
+	}
+}
+
+// urlHost returns the lowercased host of rawURL without a trailing dot, or
+// an empty string when it has none.
+func urlHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// redirectTransport answers every request with a redirect to the URL it
+// maps the requested one to, an empty URL answering 200 and a missing one
+// 404.
+type redirectTransport map[string]string
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{
+		StatusCode: http.StatusNotFound,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}
+
+	if location, ok := t[req.URL.String()]; ok {
+		resp.StatusCode = http.StatusOK
+		if location != "" {
+			resp.StatusCode = http.StatusMovedPermanently
+			resp.Header.Set("Location", location)
+		}
+	}
+
+	return resp, nil
+}
+
+func TestUnwrapURL(t *testing.T) {
+	defer func(client *http.Client, domains, shorteners []string, redirects int) {
+		unwrapClient, shortDomains, knownShorteners, maxUnwrapRedirects = client, domains, shorteners, redirects
+	}(unwrapClient, shortDomains, knownShorteners, maxUnwrapRedirects)
+
+	unwrapClient = &http.Client{
+		Transport: redirectTransport{
+			"https://bit.ly/one":        "https://example.com/Page/?b=2&a=1",
+			"https://bit.ly/two":        "https://t.co/two",
+			"https://t.co/two":          "https://example.com/two",
+			"https://bit.ly/three":      "https://t.co/three",
+			"https://t.co/three":        "https://is.gd/three",
+			"https://is.gd/three":       "https://example.com/three",
+			"https://bit.ly/four":       "https://t.co/four",
+			"https://t.co/four":         "https://is.gd/four",
+			"https://is.gd/four":        "https://v.gd/four",
+			"https://v.gd/four":         "https://example.com/four",
+			"https://bit.ly/loop":       "https://t.co/loop",
+			"https://t.co/loop":         "https://bit.ly/loop",
+			"https://bit.ly/self":       "https://bit.ly/self",
+			"https://bit.ly/ours":       "https://wowee.link/abc",
+			"https://bit.ly/back":       "https://t.co/back",
+			"https://t.co/back":         "https://go.wowee.link/abc",
+			"https://bit.ly/page":       "",
+			"https://bit.ly/no-where":   "https://t.co/missing",
+			"https://bit.ly/relative":   "/one",
+			"https://bit.ly/bad-scheme": "ftp://t.co/file",
+		},
+		CheckRedirect: unwrapClient.CheckRedirect,
+	}
+	shortDomains = []string{"wowee.link"}
+	knownShorteners = []string{"bit.ly", "t.co", "is.gd", "v.gd"}
+	maxUnwrapRedirects = 3
+
+	tests := []struct {
+		name    string
+		url     string
+		want    string
+		wantErr error
+	}{
+		{name: "not shortened", url: "https://Example.com/a/?b=2&a=1", want: "https://Example.com/a/?b=2&a=1"},
+		{name: "one hop", url: "https://bit.ly/one", want: "https://example.com/Page/?b=2&a=1"},
+		{name: "two hops", url: "https://bit.ly/two", want: "https://example.com/two"},
+		{name: "as many hops as allowed", url: "https://bit.ly/three", want: "https://example.com/three"},
+		{name: "one hop too many", url: "https://bit.ly/four", wantErr: errTooManyRedirects},
+		{name: "loop", url: "https://bit.ly/loop", wantErr: errRedirectLoop},
+		{name: "redirect to itself", url: "https://bit.ly/self", wantErr: errRedirectLoop},
+		{name: "already ours", url: "https://wowee.link/abc", wantErr: errShortenedURL},
+		{name: "our subdomain", url: "https://go.wowee.link/abc", wantErr: errShortenedURL},
+		{name: "redirect to ours", url: "https://bit.ly/ours", wantErr: errRedirectLoop},
+		{name: "redirect to ours later", url: "https://bit.ly/back", wantErr: errRedirectLoop},
+		{name: "shortener page", url: "https://bit.ly/page", want: "https://bit.ly/page"},
+		{name: "missing link", url: "https://bit.ly/missing", wantErr: errUnresolvedURL},
+		{name: "missing hop", url: "https://bit.ly/no-where", wantErr: errUnresolvedURL},
+		{name: "relative redirect", url: "https://bit.ly/relative", want: "https://example.com/Page/?b=2&a=1"},
+		{name: "other scheme", url: "https://bit.ly/bad-scheme", wantErr: errUnresolvedURL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unwrapURL(context.Background(), tt.url)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("unwrapURL(%q) error = %v, want %v", tt.url, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("unwrapURL(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnwrapURLWithoutRedirects(t *testing.T) {
+	defer func(shorteners []string, redirects int) {
+		knownShorteners, maxUnwrapRedirects = shorteners, redirects
+	}(knownShorteners, maxUnwrapRedirects)
+
+	knownShorteners = []string{"bit.ly"}
+	maxUnwrapRedirects = 0
+
+	if _, err := unwrapURL(context.Background(), "https://bit.ly/one"); !errors.Is(err, errTooManyRedirects) {
+		t.Errorf("unwrapURL error = %v, want %v", err, errTooManyRedirects)
+	}
+
+	want := "https://example.com/a"
+	if got, err := unwrapURL(context.Background(), want); err != nil || got != want {
+		t.Errorf("unwrapURL(%q) = %q, %v, want %q", want, got, err, want)
+	}
+}
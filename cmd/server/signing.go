@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	keyIDHeader              = "X-Key-ID"
+	signatureHeader          = "X-Signature"
+	signatureTimestampHeader = "X-Signature-Timestamp"
+	signatureNonceHeader     = "X-Signature-Nonce"
+
+	signingSecretPrefix   = "wss_"
+	defaultSignatureSkew  = 5 * time.Minute
+	minSignatureNonceSize = 16
+	maxSignatureNonceSize = 128
+	maxSignedBodyBytes    = maxImportBodyBytes
+)
+
+var (
+	errSignatureInvalid  = errors.New("invalid request signature")
+	errSignatureExpired  = errors.New("request signature expired")
+	errSignatureReplayed = errors.New("request signature replayed")
+)
+
+// NonceStore remembers the nonces of signed requests, so that a request
+// is only taken once.
+type NonceStore interface {
+	// Claim reports whether key was new, and remembers it for ttl.
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// NewNonceStore keeps nonces where RATE_LIMIT_STORE counts rate limits.
+func NewNonceStore(cfg *config.Config, redisClient *redis.Client) (NonceStore, error) {
+	switch cfg.String("RATE_LIMIT_STORE") {
+	case "memory":
+		return NewMemoryNonceStore(), nil
+	case "redis":
+		if redisClient == nil {
+			return nil, errors.New("RATE_LIMIT_STORE=redis requires REDIS_URL")
+		}
+		return &RedisNonceStore{client: redisClient}, nil
+	default:
+		return nil, errors.New("RATE_LIMIT_STORE must be memory or redis")
+	}
+}
+
+// MemoryNonceStore keeps nonces in process memory until they expire.
+type MemoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func NewMemoryNonceStore() *MemoryNonceStore {
+	store := &MemoryNonceStore{nonces: make(map[string]time.Time)}
+
+	go store.sweep(time.Minute)
+
+	return store
+}
+
+func (s *MemoryNonceStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if until, ok := s.nonces[key]; ok && now.Before(until) {
+		return false, nil
+	}
+	s.nonces[key] = now.Add(ttl)
+
+	return true, nil
+}
+
+func (s *MemoryNonceStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		now := time.Now()
+		for key, until := range s.nonces {
+			if now.After(until) {
+				delete(s.nonces, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// RedisNonceStore shares nonces between instances through Redis.
+type RedisNonceStore struct {
+	client *redis.Client
+}
+
+func (s *RedisNonceStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, "nonce:"+key, "1", ttl).Result()
+}
+
+// RequestVerifier authenticates requests signed with the signing secret
+// of an API key, for server-to-server callers that would rather not send
+// the key itself. A signed request names the key in X-Key-ID and carries:
+//
+//	X-Signature-Timestamp: the Unix time it was signed at
+//	X-Signature-Nonce: a random value of 16 to 128 characters, never reused
+//	X-Signature: sha256= and the hex HMAC-SHA256 of the timestamp, nonce,
+//	method, path with query and body, joined by dots
+//
+// Requests signed more than SIGNATURE_MAX_SKEW away from now are rejected,
+// and so are those whose nonce was seen already.
+type RequestVerifier struct {
+	orgs   OrgStore
+	nonces NonceStore
+	skew   time.Duration
+}
+
+func NewRequestVerifier(cfg *config.Config, orgs OrgStore, nonces NonceStore) *RequestVerifier {
+	skew := cfg.Duration("SIGNATURE_MAX_SKEW")
+	if skew <= 0 {
+		skew = defaultSignatureSkew
+	}
+
+	return &RequestVerifier{orgs: orgs, nonces: nonces, skew: skew}
+}
+
+// signedRequest reports whether r is signed rather than sent with a key.
+func signedRequest(r *http.Request) bool {
+	return r.Header.Get(keyIDHeader) != ""
+}
+
+// Authenticate checks the signature of r and resolves its key like
+// authenticateCaller does. The body is read and put back for the handler.
+func (v *RequestVerifier) Authenticate(r *http.Request) (caller, error) {
+	keyID, err := strconv.Atoi(r.Header.Get(keyIDHeader))
+	if err != nil {
+		return caller{}, errSignatureInvalid
+	}
+
+	timestamp := r.Header.Get(signatureTimestampHeader)
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return caller{}, errSignatureInvalid
+	}
+	if skew := time.Since(time.Unix(signedAt, 0)); skew > v.skew || skew < -v.skew {
+		return caller{}, errSignatureExpired
+	}
+
+	nonce := r.Header.Get(signatureNonceHeader)
+	if len(nonce) < minSignatureNonceSize || len(nonce) > maxSignatureNonceSize {
+		return caller{}, errSignatureInvalid
+	}
+
+	signature, ok := strings.CutPrefix(r.Header.Get(signatureHeader), "sha256=")
+	if !ok {
+		return caller{}, errSignatureInvalid
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBodyBytes+1))
+	if err != nil {
+		return caller{}, err
+	}
+	if len(body) > maxSignedBodyBytes {
+		return caller{}, errSignatureInvalid
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	apiKey, member, err := v.orgs.AuthenticateSigningKey(r.Context(), keyID)
+	if err == ErrAPIKeyNotFound {
+		return caller{}, errSignatureInvalid
+	}
+	if err != nil {
+		return caller{}, err
+	}
+
+	expected := signRequest(*apiKey.SigningSecret, timestamp, nonce, r.Method, r.URL.RequestURI(), body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return caller{}, errSignatureInvalid
+	}
+
+	// The nonce is claimed once the signature holds, so that unsigned
+	// requests cannot use up the nonces of signed ones.
+	claimed, err := v.nonces.Claim(r.Context(), strconv.Itoa(keyID)+":"+nonce, 2*v.skew)
+	if err != nil {
+		return caller{}, err
+	}
+	if !claimed {
+		return caller{}, errSignatureReplayed
+	}
+
+	return keyCaller(r.Context(), v.orgs, apiKey, member)
+}
+
+func signRequest(secret string, timestamp string, nonce string, method string, uri string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + nonce + "." + method + "." + uri + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newSigningSecret() (string, error) {
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return signingSecretPrefix + hex.EncodeToString(random), nil
+}
+
+// SigningSecretResponse carries a new signing secret, returned once.
+type SigningSecretResponse struct {
+	KeyID         int    `json:"key_id"`
+	SigningSecret string `json:"signing_secret"`
+	ElapsedTime   int64  `json:"elapsed_time"`
+}
+
+// CreateSigningSecretHandler gives the key with the ID in the path a new
+// signing secret, replacing the one it had. The secret is part of the
+// response and cannot be retrieved again.
+func CreateSigningSecretHandler(orgs OrgStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		key, ok := manageableAPIKey(w, r, orgs)
+		if !ok {
+			return
+		}
+
+		secret, err := newSigningSecret()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error generating signing secret", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		if !setSigningSecret(w, r, orgs, key, &secret) {
+			return
+		}
+
+		response := SigningSecretResponse{
+			KeyID:         key.ID,
+			SigningSecret: secret,
+			ElapsedTime:   time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(jsonResponse)
+	}
+}
+
+// DeleteSigningSecretHandler stops the key with the ID in the path from
+// signing requests.
+func DeleteSigningSecretHandler(orgs OrgStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := manageableAPIKey(w, r, orgs)
+		if !ok {
+			return
+		}
+
+		if setSigningSecret(w, r, orgs, key, nil) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// manageableAPIKey returns the live key with the ID in the path when the
+// caller may manage it, answering the request otherwise.
+func manageableAPIKey(w http.ResponseWriter, r *http.Request, orgs OrgStore) (APIKey, bool) {
+	c, ok := requireRole(w, r, roleAdmin)
+	if !ok {
+		return APIKey{}, false
+	}
+
+	keyID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, "API key not found")
+		return APIKey{}, false
+	}
+
+	key, err := orgs.GetAPIKey(r.Context(), c.OrgID, keyID)
+	if err == nil && key.RevokedAt != nil {
+		err = ErrAPIKeyNotFound
+	}
+	if err != nil {
+		if err == ErrAPIKeyNotFound {
+			writeError(w, http.StatusNotFound, "API key not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return APIKey{}, false
+	}
+
+	member, err := orgs.GetMember(r.Context(), c.OrgID, key.MemberID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		return APIKey{}, false
+	}
+	if !canManage(c, member.Role) {
+		writeError(w, http.StatusForbidden, "Cannot manage keys of a member above your own role")
+		return APIKey{}, false
+	}
+
+	return key, true
+}
+
+func setSigningSecret(w http.ResponseWriter, r *http.Request, orgs OrgStore, key APIKey, secret *string) bool {
+	err := orgs.SetAPIKeySigningSecret(r.Context(), key.OrgID, key.ID, secret)
+	if err != nil {
+		if err == ErrAPIKeyNotFound {
+			writeError(w, http.StatusNotFound, "API key not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Error updating API key", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedTestRequest returns a request to target signed with secret the
+// way RequestVerifier expects.
+func signedTestRequest(method, target, body string, keyID int, secret string, signedAt time.Time, nonce string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	r.Header.Set(keyIDHeader, strconv.Itoa(keyID))
+	r.Header.Set(signatureTimestampHeader, timestamp)
+	r.Header.Set(signatureNonceHeader, nonce)
+	r.Header.Set(signatureHeader, "sha256="+signRequest(secret, timestamp, nonce, method, r.URL.RequestURI(), []byte(body)))
+
+	return r
+}
+
+// newTestVerifier returns a verifier allowing a minute of skew, with an
+// owner key of a new organization that signs with the returned secret.
+func newTestVerifier(t *testing.T) (*RequestVerifier, *SQLiteStore, APIKey, string) {
+	t.Helper()
+
+	store := newTestStore(t)
+	_, key, _ := newTestOrg(t, store, "acme")
+
+	secret, err := newSigningSecret()
+	if err != nil {
+		t.Fatalf("generating signing secret: %v", err)
+	}
+	if err := store.SetAPIKeySigningSecret(context.Background(), key.OrgID, key.ID, &secret); err != nil {
+		t.Fatalf("setting signing secret: %v", err)
+	}
+
+	verifier := &RequestVerifier{orgs: store, nonces: NewMemoryNonceStore(), skew: time.Minute}
+
+	return verifier, store, key, secret
+}
+
+func TestRequestVerifierAuthenticate(t *testing.T) {
+	verifier, store, key, secret := newTestVerifier(t)
+
+	_, otherKey, _ := newTestOrg(t, store, "other")
+	otherSecret, err := newSigningSecret()
+	if err != nil {
+		t.Fatalf("generating signing secret: %v", err)
+	}
+	if err := store.SetAPIKeySigningSecret(context.Background(), otherKey.OrgID, otherKey.ID, &otherSecret); err != nil {
+		t.Fatalf("setting signing secret: %v", err)
+	}
+
+	_, unsignedKey, _ := newTestOrg(t, store, "unsigned")
+
+	const body = `{"url":"https://example.com"}`
+
+	tests := []struct {
+		name string
+		// tamper changes the request after it was signed.
+		tamper   func(r *http.Request)
+		keyID    int
+		secret   string
+		signedAt time.Duration
+		wantErr  error
+	}{
+		{name: "valid"},
+		{name: "valid within the skew in the past", signedAt: -50 * time.Second},
+		{name: "valid within the skew in the future", signedAt: 50 * time.Second},
+		{
+			name:    "tampered body",
+			tamper:  func(r *http.Request) { r.Body = io.NopCloser(strings.NewReader(`{"url":"https://evil.example"}`)) },
+			wantErr: errSignatureInvalid,
+		},
+		{
+			name:    "truncated body",
+			tamper:  func(r *http.Request) { r.Body = io.NopCloser(strings.NewReader(body[:10])) },
+			wantErr: errSignatureInvalid,
+		},
+		{
+			name:    "tampered path",
+			tamper:  func(r *http.Request) { r.URL.Path = "/api/v1/links" },
+			wantErr: errSignatureInvalid,
+		},
+		{
+			name:    "tampered query",
+			tamper:  func(r *http.Request) { r.URL.RawQuery = "force_new=false" },
+			wantErr: errSignatureInvalid,
+		},
+		{
+			name:    "dropped query",
+			tamper:  func(r *http.Request) { r.URL.RawQuery = "" },
+			wantErr: errSignatureInvalid,
+		},
+		{
+			name:    "tampered method",
+			tamper:  func(r *http.Request) { r.Method = http.MethodPut },
+			wantErr: errSignatureInvalid,
+		},
+		{
+			name: "tampered timestamp",
+			tamper: func(r *http.Request) {
+				r.Header.Set(signatureTimestampHeader, strconv.FormatInt(time.Now().Unix()+1, 10))
+			},
+			wantErr: errSignatureInvalid,
+		},
+		{
+			name:    "tampered nonce",
+			tamper:  func(r *http.Request) { r.Header.Set(signatureNonceHeader, "another-nonce-of-enough-length") },
+			wantErr: errSignatureInvalid,
+		},
+		{
+			name: "signature without prefix",
+			tamper: func(r *http.Request) {
+				r.Header.Set(signatureHeader, strings.TrimPrefix(r.Header.Get(signatureHeader), "sha256="))
+			},
+			wantErr: errSignatureInvalid,
+		},
+		{name: "wrong secret", secret: "wss_wrong", wantErr: errSignatureInvalid},
+		{name: "secret of another key", secret: otherSecret, wantErr: errSignatureInvalid},
+		{name: "expired", signedAt: -61 * time.Second, wantErr: errSignatureExpired},
+		{name: "signed in the future", signedAt: 61 * time.Second, wantErr: errSignatureExpired},
+		{name: "unknown key", keyID: 999, wantErr: errSignatureInvalid},
+		{name: "key without a signing secret", keyID: unsignedKey.ID, wantErr: errSignatureInvalid},
+		{
+			name:    "malformed key ID",
+			tamper:  func(r *http.Request) { r.Header.Set(keyIDHeader, "key") },
+			wantErr: errSignatureInvalid,
+		},
+		{
+			name:    "malformed timestamp",
+			tamper:  func(r *http.Request) { r.Header.Set(signatureTimestampHeader, "yesterday") },
+			wantErr: errSignatureInvalid,
+		},
+		{
+			name:    "short nonce",
+			tamper:  func(r *http.Request) { r.Header.Set(signatureNonceHeader, "short") },
+			wantErr: errSignatureInvalid,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyID, signingSecret := key.ID, secret
+			if tt.keyID != 0 {
+				keyID = tt.keyID
+			}
+			if tt.secret != "" {
+				signingSecret = tt.secret
+			}
+
+			nonce := "nonce-of-test-" + strconv.Itoa(i) + "-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+			r := signedTestRequest(http.MethodPost, "/api/v1/shorten?force_new=true", body, keyID, signingSecret, time.Now().Add(tt.signedAt), nonce)
+			if tt.tamper != nil {
+				tt.tamper(r)
+			}
+
+			c, err := verifier.Authenticate(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if c.OrgID != key.OrgID || c.KeyID != key.ID || c.Role != roleOwner {
+				t.Errorf("Authenticate = %+v, want the owner key %d of organization %d", c, key.ID, key.OrgID)
+			}
+
+			// The handler still gets to read the body.
+			read, err := io.ReadAll(r.Body)
+			if err != nil || string(read) != body {
+				t.Errorf("body after Authenticate = %q, %v, want %q", read, err, body)
+			}
+		})
+	}
+}
+
+func TestRequestVerifierRejectsReplays(t *testing.T) {
+	verifier, _, key, secret := newTestVerifier(t)
+
+	const nonce = "nonce-used-only-once"
+	signedAt := time.Now()
+
+	first := signedTestRequest(http.MethodPost, "/api/v1/shorten", "{}", key.ID, secret, signedAt, nonce)
+	if _, err := verifier.Authenticate(first); err != nil {
+		t.Fatalf("first Authenticate error = %v", err)
+	}
+
+	replayed := signedTestRequest(http.MethodPost, "/api/v1/shorten", "{}", key.ID, secret, signedAt, nonce)
+	if _, err := verifier.Authenticate(replayed); !errors.Is(err, errSignatureReplayed) {
+		t.Errorf("replayed Authenticate error = %v, want %v", err, errSignatureReplayed)
+	}
+
+	// Signing another request with the nonce does not make it new.
+	resigned := signedTestRequest(http.MethodGet, "/api/v1/links", "", key.ID, secret, time.Now(), nonce)
+	if _, err := verifier.Authenticate(resigned); !errors.Is(err, errSignatureReplayed) {
+		t.Errorf("resigned Authenticate error = %v, want %v", err, errSignatureReplayed)
+	}
+
+	fresh := signedTestRequest(http.MethodPost, "/api/v1/shorten", "{}", key.ID, secret, signedAt, nonce+"-fresh")
+	if _, err := verifier.Authenticate(fresh); err != nil {
+		t.Errorf("Authenticate with a fresh nonce error = %v", err)
+	}
+}
+
+func TestRequestVerifierKeepsNoncesOfForgedRequests(t *testing.T) {
+	verifier, _, key, secret := newTestVerifier(t)
+
+	const nonce = "nonce-a-forger-saw"
+
+	forged := signedTestRequest(http.MethodPost, "/api/v1/shorten", "{}", key.ID, "wss_forged", time.Now(), nonce)
+	if _, err := verifier.Authenticate(forged); !errors.Is(err, errSignatureInvalid) {
+		t.Fatalf("forged Authenticate error = %v, want %v", err, errSignatureInvalid)
+	}
+
+	genuine := signedTestRequest(http.MethodPost, "/api/v1/shorten", "{}", key.ID, secret, time.Now(), nonce)
+	if _, err := verifier.Authenticate(genuine); err != nil {
+		t.Errorf("genuine Authenticate error = %v", err)
+	}
+}
+
+func TestRequestVerifierRevokedKey(t *testing.T) {
+	verifier, store, key, secret := newTestVerifier(t)
+
+	if err := store.RevokeAPIKey(context.Background(), key.OrgID, key.ID); err != nil {
+		t.Fatalf("revoking API key: %v", err)
+	}
+
+	r := signedTestRequest(http.MethodPost, "/api/v1/shorten", "{}", key.ID, secret, time.Now(), "nonce-of-a-revoked-key")
+	if _, err := verifier.Authenticate(r); !errors.Is(err, errSignatureInvalid) {
+		t.Errorf("Authenticate error = %v, want %v", err, errSignatureInvalid)
+	}
+}
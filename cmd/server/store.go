@@ -0,0 +1,812 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/XSAM/otelsql"
+	"github.com/boleknowak/wowee-link-api/internal/config"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrNotFound    = errors.New("link not found")
+	ErrLinkDeleted = errors.New("link has been deleted")
+	// ErrCodeTaken is returned when the code of a new link is in use.
+	ErrCodeTaken = errors.New("code is already in use")
+	// ErrURLTaken is returned when a permanent, unlimited link would
+	// duplicate the URL of another such link.
+	ErrURLTaken = errors.New("url is already shortened")
+	// ErrIdempotencyKeyTaken is returned when the idempotency key of a new
+	// link belongs to another link.
+	ErrIdempotencyKeyTaken = errors.New("idempotency key is already in use")
+
+	ErrOrgNotFound    = errors.New("organization not found")
+	ErrSlugTaken      = errors.New("organization slug is already in use")
+	ErrMemberNotFound = errors.New("member not found")
+	ErrMemberExists   = errors.New("member already belongs to the organization")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+
+	ErrDomainNotFound = errors.New("domain not found")
+	ErrDomainTaken    = errors.New("domain is already registered")
+	ErrDomainInUse    = errors.New("domain still has links")
+
+	ErrWebhookNotFound = errors.New("webhook not found")
+
+	ErrDomainRuleNotFound = errors.New("domain rule not found")
+	ErrDomainRuleExists   = errors.New("domain already has a rule")
+
+	ErrFolderNotFound = errors.New("folder not found")
+	ErrFolderNotEmpty = errors.New("folder still holds links or folders")
+)
+
+// exportPageSize is how many rows the export methods read per query.
+const exportPageSize = 1000
+
+// exportStartDate sorts before every date in the clicks table.
+const exportStartDate = "0001-01-01"
+
+// linkSortFields are the Link fields ListLinks can order by.
+var linkSortFields = map[string]bool{
+	"created_at":    true,
+	"click_count":   true,
+	"attempt_count": true,
+	"code":          true,
+}
+
+// LinkFilter selects and orders a page of links in the namespace of OrgID
+// for ListLinks. Deleted links are never listed.
+type LinkFilter struct {
+	OrgID       int
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	MinClicks   int
+	// Broken keeps the links the health checker found dead.
+	Broken bool
+	// Tag keeps the links with the tag.
+	Tag string
+	// FolderIDs keeps the links filed in one of the folders.
+	FolderIDs []int
+	// Deleted keeps the deleted links instead of the live ones.
+	Deleted bool
+	// Archived keeps the archived links instead of the live ones.
+	Archived   bool
+	Sort       string
+	Descending bool
+	Limit      int
+	Offset     int
+}
+
+// LinkStore persists links. Codes are looked up in the namespace of an
+// organization; org ID 0 is the shared namespace of links created without
+// an organization API key. Within it every custom domain has a code space
+// of its own, domain ID 0 being the one of SHORT_DOMAINS.
+type LinkStore interface {
+	// CreateLink inserts link under link.Code in the namespace of
+	// link.OrgID and link.DomainID and fills in the stored fields. It fails with
+	// ErrCodeTaken, ErrURLTaken or ErrIdempotencyKeyTaken on conflicts
+	// within that namespace.
+	CreateLink(ctx context.Context, link *Link) error
+	// UpsertLink inserts a permanent, unlimited link, or, when link.KeyID
+	// already shortened its URL under link.DomainID, bumps the
+	// attempt_count of the existing link instead.
+	// Either way link is filled in with the stored row. It fails with
+	// ErrCodeTaken when link.Code belongs to a different URL, and with
+	// ErrIdempotencyKeyTaken when link.IdempotencyKey belongs to another
+	// link.
+	UpsertLink(ctx context.Context, link *Link) error
+	GetLink(ctx context.Context, orgID int, domainID int, code string) (Link, error)
+	// GetLinkByIdempotencyKey returns the link the API key keyID, or the
+	// member memberID acting with a session, created with key in the
+	// namespace of orgID, with its IdempotencyHash.
+	GetLinkByIdempotencyKey(ctx context.Context, orgID int, keyID int, memberID int, key string) (Link, error)
+	CodeExists(ctx context.Context, orgID int, domainID int, code string) (bool, error)
+	// ReserveCodeSequence advances the code sequence by count and returns
+	// its new value. The count values up to and including it are handed
+	// out by no other call.
+	ReserveCodeSequence(ctx context.Context, count int64) (int64, error)
+	// ConsumeClick counts a visit against the max_clicks of a limited link
+	// and reports false once the limit has been reached. It keeps its own
+	// counter because click_count is only updated in the background.
+	ConsumeClick(ctx context.Context, linkID int) (bool, error)
+	// ListLinks returns the requested page and the number of links
+	// matching the filter.
+	ListLinks(ctx context.Context, filter LinkFilter) ([]Link, int, error)
+	// SearchLinks returns the requested page of the links matching the
+	// filter that have every term in their code, URL, title or a tag, best
+	// matches first, and the number of such links. The sort of the filter
+	// is ignored.
+	SearchLinks(ctx context.Context, filter LinkFilter, terms []string) ([]Link, int, error)
+	// ExportLinks calls fn for every link matching the filter, ignoring
+	// its sort and paging, in id order. An error from fn stops the export
+	// and is returned as is.
+	ExportLinks(ctx context.Context, filter LinkFilter, fn func(link Link) error) error
+	// UpdateLink loads the link for code, applies update and stores the
+	// result atomically. An error from update aborts the change and is
+	// returned as is. Pointing the link at another URL resets its health.
+	UpdateLink(ctx context.Context, orgID int, domainID int, code string, update func(link *Link) error) (Link, error)
+	// DeleteLink marks the link as deleted, optionally removing its clicks.
+	// It fails with ErrLinkDeleted when the link was already deleted.
+	DeleteLink(ctx context.Context, orgID int, domainID int, code string, deleteClicks bool) error
+	// SetLinkTitle stores the title of the page a link points to, unless
+	// the link has been pointed at another URL since.
+	SetLinkTitle(ctx context.Context, linkID int, url string, title *string) error
+	// PurgeExpiredLinks removes expired links with their clicks and
+	// returns the links that were removed. Links with a fallback URL keep
+	// sending visitors to it and are never purged.
+	PurgeExpiredLinks(ctx context.Context) ([]Link, error)
+	// ClaimLinkChecks marks up to limit live links last checked before
+	// before, or never, as checked now and returns them, least recently
+	// checked first. Links claimed by another instance are skipped.
+	ClaimLinkChecks(ctx context.Context, before time.Time, limit int) ([]Link, error)
+	// SetLinkHealth stores link.LinkHealth. It fails with ErrNotFound when
+	// the link has been pointed at another URL than link.URL since.
+	SetLinkHealth(ctx context.Context, link Link) error
+	// SetLinkTags replaces the tags of a link.
+	SetLinkTags(ctx context.Context, linkID int, tags []string) error
+	// LinkTags returns the sorted tags of those of the links that have
+	// any.
+	LinkTags(ctx context.Context, linkIDs []int) (map[int][]string, error)
+	// ListTags returns the tags of the links in the namespace of orgID
+	// that are not deleted, with how many links have each and their
+	// clicks, most used first.
+	ListTags(ctx context.Context, orgID int) ([]TagCount, error)
+}
+
+// TagCount is a tag with the number of links that have it and the clicks
+// they received.
+type TagCount struct {
+	Tag    string `db:"tag" json:"tag"`
+	Links  int64  `db:"links" json:"links"`
+	Clicks int64  `db:"clicks" json:"clicks"`
+}
+
+// ClickCount is the number of clicks a link received on one day.
+type ClickCount struct {
+	LinkID int
+	Date   string
+	Clicks int64
+}
+
+// clickGranularities are the bucket sizes ClickTimeSeries aggregates by.
+// Weeks start on Monday.
+var clickGranularities = map[string]bool{
+	"day":   true,
+	"week":  true,
+	"month": true,
+}
+
+// ClickSeriesFilter selects the daily clicks of a link for ClickTimeSeries.
+// From and To are inclusive YYYY-MM-DD dates; empty means unbounded.
+type ClickSeriesFilter struct {
+	From        string
+	To          string
+	Granularity string
+}
+
+// ClickBucket is the number of clicks in the day, week or month starting
+// on Date.
+type ClickBucket struct {
+	Date   string `db:"date" json:"date"`
+	Clicks int64  `db:"clicks" json:"clicks"`
+}
+
+// ReferrerCount is the number of clicks a link received from one referrer.
+type ReferrerCount struct {
+	LinkID   int    `db:"link_id" json:"-"`
+	Referrer string `db:"referrer" json:"referrer"`
+	Clicks   int64  `db:"clicks" json:"clicks"`
+}
+
+// DestinationCount is the number of clicks one destination of a link
+// received.
+type DestinationCount struct {
+	LinkID        int   `db:"link_id" json:"-"`
+	DestinationID int   `db:"destination_id" json:"destination_id"`
+	Clicks        int64 `db:"clicks" json:"clicks"`
+}
+
+// CodeCount is the number of clicks a link received under one of its
+// codes.
+type CodeCount struct {
+	LinkID int    `db:"link_id" json:"-"`
+	Code   string `db:"code" json:"code"`
+	Clicks int64  `db:"clicks" json:"clicks"`
+}
+
+// CountryCount is the number of clicks a link received from one country.
+type CountryCount struct {
+	LinkID  int    `db:"link_id" json:"-"`
+	Country string `db:"country" json:"country"`
+	Clicks  int64  `db:"clicks" json:"clicks"`
+}
+
+// DeviceCount is the number of clicks a link received from one kind of
+// device, browser and operating system.
+type DeviceCount struct {
+	LinkID     int    `db:"link_id"`
+	DeviceType string `db:"device_type"`
+	Browser    string `db:"browser"`
+	OS         string `db:"os"`
+	Clicks     int64  `db:"clicks"`
+}
+
+// ClickEvent is one stored click. ReferrerHash is the hex SHA-256 of the
+// referrer host, nil for direct visits. IPHash is the hex SHA-256 of the
+// client address; it is only written, to erase a visitor's events, and is
+// never returned.
+type ClickEvent struct {
+	ID           int64     `db:"id" json:"id"`
+	LinkID       int       `db:"link_id" json:"-"`
+	ClickedAt    time.Time `db:"clicked_at" json:"clicked_at"`
+	Country      string    `db:"country" json:"country"`
+	ReferrerHash *string   `db:"referrer_hash" json:"referrer_hash"`
+	IPHash       *string   `db:"ip_hash" json:"-"`
+	DeviceType   string    `db:"device_type" json:"device_type"`
+}
+
+// ClickEventFilter selects a page of the click events of a link for
+// ClickEvents. From is inclusive and To exclusive; zero times are
+// unbounded. A non-zero Before only returns events with a lower ID, which
+// continues after the last event of the previous page.
+type ClickEventFilter struct {
+	From   time.Time
+	To     time.Time
+	Before int64
+	Limit  int
+}
+
+// BotCount is the number of clicks by bots a link received.
+type BotCount struct {
+	LinkID int
+	Clicks int64
+}
+
+// ClickBatch is a set of clicks folded into counters, along with the
+// individual events. Every link appears at most once per key in each of
+// the counters. Bot clicks are only part of Bots, Devices and Events.
+type ClickBatch struct {
+	Daily        []ClickCount
+	Referrers    []ReferrerCount
+	Countries    []CountryCount
+	Devices      []DeviceCount
+	Destinations []DestinationCount
+	Codes        []CodeCount
+	Bots         []BotCount
+	Events       []ClickEvent
+}
+
+// clickTables hold per-link clicks. They are cleared together whenever a
+// link's clicks are removed.
+var clickTables = []string{"clicks", "clicks_weekly", "clicks_monthly", "link_referrers", "link_countries", "link_devices", "link_destination_clicks", "link_code_clicks", "click_events"}
+
+// linkTables hold every row that belongs to a link, its clicks included.
+// They are cleared when the link is purged.
+var linkTables = append([]string{"link_tags", "link_aliases", "link_reports"}, clickTables...)
+
+// ClickExportFilter selects the daily clicks for ExportClicks. A zero
+// LinkID exports the clicks of every link in the namespace of OrgID that
+// is not deleted. From and To are inclusive YYYY-MM-DD dates; empty means
+// unbounded.
+type ClickExportFilter struct {
+	OrgID  int
+	LinkID int
+	From   string
+	To     string
+}
+
+// ClickExport is the number of clicks a link received on one day.
+type ClickExport struct {
+	LinkID int    `db:"link_id"`
+	Code   string `db:"code"`
+	Date   string `db:"date"`
+	Clicks int64  `db:"clicks"`
+}
+
+// ClickStore persists click counters.
+type ClickStore interface {
+	// AddClicks adds the daily counts to both the daily clicks and the
+	// click_count of their links, the bot counts to bot_clicks, the
+	// referrer, country, device, destination and code counts to their
+	// totals, and stores the
+	// events, in one transaction.
+	AddClicks(ctx context.Context, batch ClickBatch) error
+	// ClickEvents returns up to filter.Limit click events of a link, newest
+	// first.
+	ClickEvents(ctx context.Context, linkID int, filter ClickEventFilter) ([]ClickEvent, error)
+	// PurgeClickEvents removes the click events from before before. The
+	// counters are kept.
+	PurgeClickEvents(ctx context.Context, before time.Time) (int64, error)
+	// EraseLinkClicks removes every click of a link, counters and events
+	// alike, resets its click_count and bot_clicks, and returns how many
+	// events there were.
+	EraseLinkClicks(ctx context.Context, linkID int) (int64, error)
+	// EraseVisitorClicks removes the click events whose ip_hash is ipHash
+	// and returns how many there were.
+	EraseVisitorClicks(ctx context.Context, ipHash string) (int64, error)
+	// ClickTimeSeries sums the daily clicks of a link into buckets,
+	// oldest first. Buckets without clicks are omitted. Weeks and months
+	// that have been rolled up are read from the rollups.
+	ClickTimeSeries(ctx context.Context, linkID int, filter ClickSeriesFilter) ([]ClickBucket, error)
+	// RollupClicks sums the daily clicks into the weeks or months that
+	// have ended by today. The last week or month rolled up before is
+	// summed again, to take in clicks recorded since.
+	RollupClicks(ctx context.Context, granularity string, today time.Time) error
+	// CreateClickPartitions creates the partitions of the click tables for
+	// the month of month, unless they exist. It does nothing on databases
+	// whose click tables are not partitioned.
+	CreateClickPartitions(ctx context.Context, month time.Time) error
+	// TopReferrers returns the limit referrers with the most clicks on a
+	// link, most clicks first.
+	TopReferrers(ctx context.Context, linkID int, limit int) ([]ReferrerCount, error)
+	// CountryClicks returns the clicks of a link per country, most clicks
+	// first.
+	CountryClicks(ctx context.Context, linkID int) ([]CountryCount, error)
+	// DeviceClicks returns the clicks of a link per device type, browser
+	// and operating system, most clicks first.
+	DeviceClicks(ctx context.Context, linkID int) ([]DeviceCount, error)
+	// DestinationClicks returns the clicks of a link per destination ID.
+	DestinationClicks(ctx context.Context, linkID int) ([]DestinationCount, error)
+	// CodeClicks returns the clicks of a link per code it was visited
+	// under.
+	CodeClicks(ctx context.Context, linkID int) ([]CodeCount, error)
+	// ExportClicks calls fn for every daily count matching the filter,
+	// ordered by link and date. An error from fn stops the export and is
+	// returned as is.
+	ExportClicks(ctx context.Context, filter ClickExportFilter, fn func(click ClickExport) error) error
+}
+
+// OrgStore persists organizations, their members, the API keys that act
+// on their behalf and the custom domains they serve links on.
+type OrgStore interface {
+	// CreateOrganization inserts org together with owner as its first
+	// member and key as the owner's first API key, stored under the
+	// SHA-256 hash of its secret. It fails with ErrSlugTaken when the slug
+	// is in use.
+	CreateOrganization(ctx context.Context, org *Organization, owner *Member, key *APIKey, hash string) error
+	GetOrganization(ctx context.Context, id int) (Organization, error)
+	GetOrganizationBySlug(ctx context.Context, slug string) (Organization, error)
+	// AddMember fails with ErrMemberExists when the email already belongs
+	// to a member of member.OrgID.
+	AddMember(ctx context.Context, member *Member) error
+	GetMember(ctx context.Context, orgID int, memberID int) (Member, error)
+	ListMembers(ctx context.Context, orgID int) ([]Member, error)
+	// RemoveMember deletes the member and every API key issued to them,
+	// along with their usage, that of those keys and the accounts they log
+	// in with.
+	RemoveMember(ctx context.Context, orgID int, memberID int) error
+	// CreateAPIKey stores key under the SHA-256 hash of its secret.
+	CreateAPIKey(ctx context.Context, key *APIKey, hash string) error
+	GetAPIKey(ctx context.Context, orgID int, keyID int) (APIKey, error)
+	ListAPIKeys(ctx context.Context, orgID int) ([]APIKey, error)
+	// RotateAPIKey stores key like CreateAPIKey and makes it replace old,
+	// which expires at graceUntil. key takes over the usage and links of
+	// old. It fails with ErrAPIKeyNotFound when old was revoked or rotated
+	// in the meantime.
+	RotateAPIKey(ctx context.Context, old *APIKey, key *APIKey, hash string, graceUntil time.Time) error
+	// SetAPIKeySigningSecret replaces the signing secret of the unrevoked
+	// key, or removes it when secret is nil.
+	SetAPIKeySigningSecret(ctx context.Context, orgID int, keyID int, secret *string) error
+	// AuthenticateSigningKey is AuthenticateAPIKey for the unrevoked key
+	// with the ID and a signing secret.
+	AuthenticateSigningKey(ctx context.Context, keyID int) (APIKey, Member, error)
+	// TouchAPIKey records that the key was just used.
+	TouchAPIKey(ctx context.Context, keyID int) error
+	// RevokeAPIKey fails with ErrAPIKeyNotFound when the key does not
+	// exist or was already revoked.
+	RevokeAPIKey(ctx context.Context, orgID int, keyID int) error
+	// IdentityMembers returns the members of every organization who logged
+	// in with the subject account of provider, and those whose email is
+	// email and who have not logged in with another account of provider.
+	IdentityMembers(ctx context.Context, provider string, subject string, email string) ([]Member, error)
+	// LinkIdentity records that the member logged in with the subject
+	// account of provider.
+	LinkIdentity(ctx context.Context, memberID int, provider string, subject string) error
+	// AuthenticateAPIKey returns the unrevoked key with the given hash and
+	// the member it was issued to, whether it expired or not.
+	AuthenticateAPIKey(ctx context.Context, hash string) (APIKey, Member, error)
+	// CreateDomain fails with ErrDomainTaken when the organization has
+	// registered the hostname already or another one has verified it.
+	// Organizations may claim a hostname none has verified side by side.
+	CreateDomain(ctx context.Context, domain *CustomDomain) error
+	GetDomain(ctx context.Context, orgID int, id int) (CustomDomain, error)
+	// GetDomainByHostname returns the verified domain of hostname.
+	GetDomainByHostname(ctx context.Context, hostname string) (CustomDomain, error)
+	// ListDomainsByHostname returns the domains of every organization
+	// registered under hostname, verified or not, oldest first.
+	ListDomainsByHostname(ctx context.Context, hostname string) ([]CustomDomain, error)
+	ListDomains(ctx context.Context, orgID int) ([]CustomDomain, error)
+	// VerifyDomain marks domain as verified and fills in the stored fields.
+	// It fails with ErrDomainTaken when another organization verified the
+	// hostname first.
+	VerifyDomain(ctx context.Context, domain *CustomDomain) error
+	// DeleteDomain fails with ErrDomainInUse while links, deleted ones
+	// included, are issued under the domain.
+	DeleteDomain(ctx context.Context, orgID int, id int) error
+}
+
+// usageMetrics are the usage counters a quota can meter.
+var usageMetrics = map[string]bool{
+	"shortens":  true,
+	"redirects": true,
+}
+
+// UsageMeter is what usage is counted against: an organization API key,
+// the member of a session token, which has none, or an organization, for
+// the visits of its links.
+type UsageMeter struct {
+	KeyID    int
+	MemberID int
+	OrgID    int
+}
+
+// usageTable returns the table counting the usage of m, its column
+// identifying m and the value of that column.
+func (m UsageMeter) usageTable() (table string, column string, id int) {
+	switch {
+	case m.KeyID != 0:
+		return "api_key_usage", "api_key_id", m.KeyID
+	case m.MemberID != 0:
+		return "member_usage", "member_id", m.MemberID
+	default:
+		return "org_usage", "org_id", m.OrgID
+	}
+}
+
+// Usage is what an API key, member or organization consumed in one month.
+type Usage struct {
+	Shortens  int `db:"shortens"`
+	Redirects int `db:"redirects"`
+}
+
+// usageOf returns the counter of usage named by metric.
+func usageOf(usage Usage, metric string) int {
+	if metric == "redirects" {
+		return usage.Redirects
+	}
+
+	return usage.Shortens
+}
+
+// UsageStore meters API keys, members and organizations per month. Months are YYYY-MM
+// in UTC.
+type UsageStore interface {
+	// ConsumeUsage adds n to the metric counter of meter for month and
+	// returns the new count. When limit is positive and the counter would
+	// exceed it, the counter is left unchanged, the current count is
+	// returned and allowed is false.
+	ConsumeUsage(ctx context.Context, meter UsageMeter, month string, metric string, n int, limit int) (used int, allowed bool, err error)
+	MeterUsage(ctx context.Context, meter UsageMeter, month string) (Usage, error)
+}
+
+// StatsFilter scopes Stats to the namespace of OrgID, or to every
+// namespace when AllOrgs is set. The day, week and month windows are the
+// UTC days ending on Today, which must be a UTC midnight.
+type StatsFilter struct {
+	OrgID   int
+	AllOrgs bool
+	// Tag narrows the summary to the links with the tag.
+	Tag      string
+	Today    time.Time
+	TopLimit int
+}
+
+// Stats summarizes the links that are not deleted. Clicks are counted per
+// day, so the windows follow whole UTC days.
+type Stats struct {
+	Links         int64  `db:"links"`
+	CreatedToday  int64  `db:"created_today"`
+	Created7Days  int64  `db:"created_7_days"`
+	Created30Days int64  `db:"created_30_days"`
+	ClicksToday   int64  `db:"clicks_today"`
+	Clicks7Days   int64  `db:"clicks_7_days"`
+	Clicks30Days  int64  `db:"clicks_30_days"`
+	TopLinks      []Link `db:"-"`
+}
+
+// statsWindows returns the first day of the day, week and month windows
+// ending on today.
+func statsWindows(today time.Time) (time.Time, time.Time, time.Time) {
+	return today, today.AddDate(0, 0, -6), today.AddDate(0, 0, -29)
+}
+
+// trendingWindows maps the windows TrendingLinks ranks by to how many UTC
+// days, ending today, they cover.
+var trendingWindows = map[string]int{
+	"24h": 1,
+	"7d":  7,
+	"30d": 30,
+}
+
+// TrendingFilter selects a page of the links in the namespace of OrgID
+// ranked by their clicks since From, a YYYY-MM-DD date.
+type TrendingFilter struct {
+	OrgID  int
+	From   string
+	Limit  int
+	Offset int
+}
+
+// TrendingLink is a link with the clicks it received in the window.
+type TrendingLink struct {
+	Link
+	WindowClicks int64 `db:"window_clicks" json:"window_clicks"`
+}
+
+// StatsStore computes statistics over many links with aggregate queries.
+type StatsStore interface {
+	// Stats counts links, the links created and the clicks received in
+	// each window, and returns the TopLimit links with the most clicks of
+	// all time.
+	Stats(ctx context.Context, filter StatsFilter) (Stats, error)
+	// TrendingLinks returns the requested page of links that are not
+	// deleted and were clicked in the window, most clicks first, and how
+	// many such links there are.
+	TrendingLinks(ctx context.Context, filter TrendingFilter) ([]TrendingLink, int, error)
+}
+
+// WebhookStore persists webhook subscriptions and the queue of their
+// deliveries. A delivery is due while its next_attempt_at is set and has
+// passed.
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, hook *Webhook) error
+	GetWebhook(ctx context.Context, orgID int, id int) (Webhook, error)
+	ListWebhooks(ctx context.Context, orgID int) ([]Webhook, error)
+	// UpdateWebhook stores the URL, events and active flag of hook and
+	// fills in the stored fields.
+	UpdateWebhook(ctx context.Context, hook *Webhook) error
+	// DeleteWebhook removes the webhook together with its deliveries.
+	DeleteWebhook(ctx context.Context, orgID int, id int) error
+	// EnqueueDelivery queues a delivery that is due immediately.
+	EnqueueDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	// ClaimDeliveries leases up to limit due deliveries to token until
+	// leaseUntil and returns them along with the URL, secret and active
+	// flag of their webhook. A delivery whose lease runs out is due again.
+	ClaimDeliveries(ctx context.Context, token string, leaseUntil time.Time, limit int) ([]WebhookDelivery, error)
+	// UpdateDelivery records the outcome of an attempt at a delivery that
+	// is still leased to delivery.ClaimToken and releases the lease.
+	UpdateDelivery(ctx context.Context, delivery WebhookDelivery) error
+	// PurgeDeliveries removes finished deliveries created before before
+	// and returns how many were removed.
+	PurgeDeliveries(ctx context.Context, before time.Time) (int64, error)
+}
+
+// FolderStore persists the folders links are filed in.
+type FolderStore interface {
+	CreateFolder(ctx context.Context, folder *Folder) error
+	GetFolder(ctx context.Context, orgID int, id int) (Folder, error)
+	// ListFolders returns the folders of the namespace of orgID by name,
+	// with the number of live links filed directly in each.
+	ListFolders(ctx context.Context, orgID int) ([]Folder, error)
+	// UpdateFolder stores the name and parent of folder and fills in the
+	// stored fields.
+	UpdateFolder(ctx context.Context, folder *Folder) error
+	// DeleteFolder removes a folder and fails with ErrFolderNotEmpty while
+	// it holds live links or other folders. Deleted links in it are taken
+	// out of it.
+	DeleteFolder(ctx context.Context, orgID int, id int) error
+	// MoveLinks files the live links of codes in folderID, or in no folder
+	// when it is nil, and returns how many were moved.
+	MoveLinks(ctx context.Context, orgID int, domainID int, codes []string, folderID *int) (int64, error)
+}
+
+// AliasStore persists the further codes links answer to. GetLink resolves
+// them to their link.
+type AliasStore interface {
+	// AddLinkAlias fails with ErrCodeTaken when the code of alias is the
+	// code or an alias of a link in its namespace and domain.
+	AddLinkAlias(ctx context.Context, alias *LinkAlias) error
+	// LinkAliases returns the aliases of a link, oldest first.
+	LinkAliases(ctx context.Context, linkID int) ([]LinkAlias, error)
+	// DeleteLinkAlias fails with ErrNotFound when the link has no such
+	// alias.
+	DeleteLinkAlias(ctx context.Context, linkID int, code string) error
+}
+
+// QuarantineStore keeps the links a destination scanner flagged out of
+// service until an admin reviews them. Quarantine is deployment-wide, so
+// links are addressed by ID whatever their organization.
+type QuarantineStore interface {
+	// QuarantineLink quarantines a link that is not yet for reason. It
+	// fails with ErrNotFound when the link no longer points to url, as
+	// the scan is then outdated, or is already quarantined.
+	QuarantineLink(ctx context.Context, linkID int, url string, reason string) error
+	// ListQuarantinedLinks returns the requested page of the live links
+	// awaiting review, or of those whose quarantine was confirmed, oldest
+	// quarantine first, and the number of such links.
+	ListQuarantinedLinks(ctx context.Context, confirmed bool, limit int, offset int) ([]Link, int, error)
+	// ReleaseLink lifts the quarantine of a link and ConfirmQuarantine
+	// keeps it in place for good. Both fail with ErrNotFound when the link
+	// is not quarantined.
+	ReleaseLink(ctx context.Context, linkID int) (Link, error)
+	ConfirmQuarantine(ctx context.Context, linkID int) (Link, error)
+}
+
+// ReportStore keeps the reports visitors make of malicious links until an
+// admin resolves them. Like quarantine, moderation is deployment-wide.
+type ReportStore interface {
+	// CreateLinkReport reports a link unless its reports were
+	// whitelisted or the reporter already has an open report of it, and
+	// tells whether it did.
+	CreateLinkReport(ctx context.Context, report *LinkReport) (bool, error)
+	// ListReportedLinks returns the requested page of the live links with
+	// open reports, most reported first, and the number of such links.
+	ListReportedLinks(ctx context.Context, limit int, offset int) ([]ReportedLink, int, error)
+	// LinkReports returns every report of a link, newest first.
+	LinkReports(ctx context.Context, linkID int) ([]LinkReport, error)
+	// ResolveLinkReports resolves the open reports of a link as
+	// dismissed, disabled or whitelisted. It fails with ErrNotFound when
+	// the link has none.
+	ResolveLinkReports(ctx context.Context, linkID int, resolution string) (Link, error)
+}
+
+// AdminStore gives the admin the run of the links of every organization.
+type AdminStore interface {
+	// ListAllLinks returns the requested page of the links matching the
+	// filter, newest first, and the number of such links.
+	ListAllLinks(ctx context.Context, filter AdminLinkFilter) ([]Link, int, error)
+	// DisableLink quarantines a live link for reason, confirmed. It fails
+	// with ErrNotFound when there is no such link.
+	DisableLink(ctx context.Context, linkID int, reason string) (Link, error)
+	// KeyActivity returns the limit API keys that created the most links
+	// from from up to to, most active first.
+	KeyActivity(ctx context.Context, from time.Time, to time.Time, limit int) ([]KeyActivity, error)
+}
+
+// AuditStore reads the changes made to links. The link stores record them
+// as they update, move, delete or moderate links or change their aliases,
+// with the member and API key of the caller of the context they are
+// given, or the admin key.
+type AuditStore interface {
+	// LinkHistory returns the requested page of the events of a link,
+	// newest first, and the number of its events.
+	LinkHistory(ctx context.Context, linkID int, limit int, offset int) ([]LinkEvent, int, error)
+	// ListLinkEvents returns the requested page of the events matching
+	// the filter, newest first, and the number of such events.
+	ListLinkEvents(ctx context.Context, filter LinkEventFilter) ([]LinkEvent, int, error)
+}
+
+// DomainRuleStore persists the domains links may or may not point to.
+type DomainRuleStore interface {
+	// CreateDomainRule fails with ErrDomainRuleExists when the domain is
+	// already on either list.
+	CreateDomainRule(ctx context.Context, rule *DomainRule) error
+	ListDomainRules(ctx context.Context) ([]DomainRule, error)
+	DeleteDomainRule(ctx context.Context, id int) error
+}
+
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Store is everything the server needs from its database.
+type Store interface {
+	LinkStore
+	ClickStore
+	OrgStore
+	UsageStore
+	WebhookStore
+	StatsStore
+	DomainRuleStore
+	FolderStore
+	AuditStore
+	AliasStore
+	QuarantineStore
+	ReportStore
+	AdminStore
+	Pinger
+	Close() error
+}
+
+const (
+	defaultMaxOpenConns    = 25
+	defaultMaxIdleConns    = 25
+	defaultConnMaxLifetime = 5 * time.Minute
+)
+
+// databaseDriver describes one supported DATABASE_DRIVER.
+type databaseDriver struct {
+	// sqlDriver is the database/sql driver name.
+	sqlDriver string
+	// dialect and migrationsDir select the goose migrations to run.
+	dialect       string
+	migrationsDir string
+	dsn           func(dsn string) string
+	newStore      func(db *sqlx.DB) (Store, error)
+	// goMigrations run in order with the SQL ones of migrationsDir.
+	goMigrations []goMigration
+}
+
+var databaseDrivers = map[string]databaseDriver{
+	"postgres": {
+		sqlDriver:     "postgres",
+		dialect:       "postgres",
+		migrationsDir: "migrations/postgres",
+		dsn:           func(dsn string) string { return dsn },
+		newStore:      func(db *sqlx.DB) (Store, error) { return NewPostgresStore(db) },
+		goMigrations: []goMigration{
+			{"00047_backfill_link_url_keys.go", backfillLinkURLKeys(sqlx.DOLLAR, func(err error) bool {
+				return isUniqueViolationOf(err, urlIndexName)
+			})},
+		},
+	},
+	"sqlite": {
+		sqlDriver:     "sqlite",
+		dialect:       "sqlite3",
+		migrationsDir: "migrations/sqlite",
+		dsn:           sqliteDSN,
+		newStore:      func(db *sqlx.DB) (Store, error) { return NewSQLiteStore(db) },
+		goMigrations: []goMigration{
+			{"00044_backfill_link_url_keys.go", backfillLinkURLKeys(sqlx.QUESTION, isSQLiteUniqueViolation)},
+		},
+	},
+	"mysql": {
+		sqlDriver:     "mysql",
+		dialect:       "mysql",
+		migrationsDir: "migrations/mysql",
+		dsn:           mysqlDSN,
+		newStore:      func(db *sqlx.DB) (Store, error) { return NewMySQLStore(db) },
+		goMigrations: []goMigration{
+			{"00044_backfill_link_url_keys.go", backfillLinkURLKeys(sqlx.QUESTION, func(err error) bool {
+				return isMySQLDuplicateOf(err, urlIndexName)
+			})},
+		},
+	},
+}
+
+// lookupDatabaseDriver returns the driver named by DATABASE_DRIVER,
+// defaulting to postgres.
+func lookupDatabaseDriver(cfg *config.Config) (databaseDriver, error) {
+	name := cfg.String("DATABASE_DRIVER")
+
+	driver, ok := databaseDrivers[name]
+	if !ok {
+		return databaseDriver{}, fmt.Errorf("DATABASE_DRIVER must be postgres, sqlite or mysql, got %q", name)
+	}
+
+	return driver, nil
+}
+
+// open connects to dsn through an instrumented driver, so that every
+// query is traced.
+func (d databaseDriver) open(dsn string) (*sqlx.DB, error) {
+	db, err := otelsql.Open(d.sqlDriver, d.dsn(dsn), otelsql.WithSpanOptions(otelsql.SpanOptions{
+		OmitConnResetSession: true,
+		OmitRows:             true,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return sqlx.NewDb(db, d.sqlDriver), nil
+}
+
+// configurePool applies DATABASE_MAX_OPEN_CONNS, DATABASE_MAX_IDLE_CONNS
+// and DATABASE_CONN_MAX_LIFETIME to db. SQLite always gets a single
+// connection that is never replaced, which SQLiteStore relies on to
+// serialise writes.
+func (d databaseDriver) configurePool(cfg *config.Config, db *sqlx.DB) {
+	if d.sqlDriver == "sqlite" {
+		db.SetMaxOpenConns(1)
+		return
+	}
+
+	db.SetMaxOpenConns(cfg.Int("DATABASE_MAX_OPEN_CONNS"))
+	db.SetMaxIdleConns(cfg.Int("DATABASE_MAX_IDLE_CONNS"))
+	db.SetConnMaxLifetime(cfg.Duration("DATABASE_CONN_MAX_LIFETIME"))
+}
+
+// closeStatements closes the prepared statements of a store that is
+// being closed. Errors are ignored, as the connections go right after.
+func closeStatements(stmts ...*sqlx.Stmt) {
+	for _, stmt := range stmts {
+		stmt.Close()
+	}
+}
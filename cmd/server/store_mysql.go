@@ -0,0 +1,2533 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// MySQLStore implements Store on MySQL 8 and MariaDB 10.5 or newer.
+//
+// Neither supports partial indexes, so the links table carries a stored
+// url_hash column that is only set for permanent, unlimited, non-deleted
+// links and backs urlIndexName instead.
+type MySQLStore struct {
+	db *sqlx.DB
+
+	// The statements of the redirect path are prepared once, when the
+	// store is opened. Daily click counts are upserted in a single
+	// statement of as many rows as the batch has, which is not prepared.
+	getLinkStmt    *sqlx.Stmt
+	clickCountStmt *sqlx.Stmt
+}
+
+// NewMySQLStore prepares the statements of the store, so the schema must
+// be migrated first.
+func NewMySQLStore(db *sqlx.DB) (*MySQLStore, error) {
+	s := &MySQLStore{db: db}
+
+	var err error
+	s.getLinkStmt, err = db.Preparex(`SELECT ` + linkColumns + ` FROM links WHERE org_id = ? AND domain_id = ? AND code = ?`)
+	if err != nil {
+		return nil, fmt.Errorf("preparing link lookup: %w", err)
+	}
+
+	s.clickCountStmt, err = db.Preparex(`UPDATE links SET click_count = click_count + ? WHERE id = ?`)
+	if err != nil {
+		return nil, fmt.Errorf("preparing click count update: %w", err)
+	}
+
+	return s, nil
+}
+
+// mysqlDSN makes the driver scan DATETIME columns into time.Time. Invalid
+// DSNs are passed through for the driver to report.
+func mysqlDSN(dsn string) string {
+	config, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return dsn
+	}
+
+	config.ParseTime = true
+	return config.FormatDSN()
+}
+
+func (s *MySQLStore) CreateLink(ctx context.Context, link *Link) error {
+	aliased, err := s.isAlias(ctx, link.OrgID, link.DomainID, link.Code)
+	if err != nil {
+		return err
+	}
+	if aliased {
+		return ErrCodeTaken
+	}
+
+	query := `
+		INSERT INTO links (org_id, code, url, created_at, attempt_count, expires_at, redirect_status, max_clicks, tracking_disabled, forward_query, utm_source, utm_medium, utm_campaign, destinations, sticky_destinations, geo_targets, device_targets, routing_rules, active_from, active_until, fallback_url, folder_id, description, notes, idempotency_key, key_id, force_new, domain_id, url_key, idempotency_hash, member_id)
+		VALUES (?, ?, ?, ?, 1, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := s.db.ExecContext(ctx, query, link.OrgID, link.Code, link.URL, time.Now(), link.ExpiresAt, link.RedirectStatus, link.MaxClicks, link.TrackingDisabled, link.ForwardQuery, link.UTMSource, link.UTMMedium, link.UTMCampaign, link.Destinations, link.StickyDestinations, link.GeoTargets, link.DeviceTargets, link.RoutingRules, link.ActiveFrom, link.ActiveUntil, link.FallbackURL, link.FolderID, link.Description, link.Notes, link.IdempotencyKey, link.KeyID, link.ForceNew, link.DomainID, canonicalURL(link.URL), link.IdempotencyHash, link.MemberID)
+	if isMySQLDuplicateOf(err, urlIndexName) {
+		return ErrURLTaken
+	}
+	if isMySQLDuplicateOf(err, idempotencyKeyIndexName) {
+		return ErrIdempotencyKeyTaken
+	}
+	if isMySQLDuplicate(err) {
+		return ErrCodeTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	return s.db.GetContext(ctx, link, `SELECT `+linkColumns+` FROM links WHERE id = ?`, id)
+}
+
+// UpsertLink cannot name the conflicting index, so ON DUPLICATE KEY UPDATE
+// also fires when only the code or idempotency key collides. The
+// attempt_count bump is guarded to cover just the URL case, and a missing
+// active row for the URL afterwards means the code or key belonged to
+// another link.
+func (s *MySQLStore) UpsertLink(ctx context.Context, link *Link) error {
+	aliased, err := s.isAlias(ctx, link.OrgID, link.DomainID, link.Code)
+	if err != nil {
+		return err
+	}
+	if aliased {
+		return ErrCodeTaken
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO links (org_id, code, url, created_at, attempt_count, expires_at, redirect_status, tracking_disabled, forward_query, idempotency_key, key_id, domain_id, url_key, idempotency_hash, member_id)
+		VALUES (?, ?, ?, ?, 1, NULL, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE attempt_count = IF(
+			url_hash IS NOT NULL AND url_key = VALUES(url_key) AND key_id = VALUES(key_id) AND domain_id = VALUES(domain_id) AND (VALUES(idempotency_key) IS NULL OR NOT idempotency_key <=> VALUES(idempotency_key)),
+			attempt_count + 1, attempt_count)
+	`
+
+	key := link.IdempotencyKey
+	_, err = tx.ExecContext(ctx, query, link.OrgID, link.Code, link.URL, time.Now(), link.RedirectStatus, link.TrackingDisabled, link.ForwardQuery, key, link.KeyID, link.DomainID, canonicalURL(link.URL), link.IdempotencyHash, link.MemberID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.GetContext(ctx, link, `SELECT `+linkColumns+` FROM links WHERE org_id = ? AND domain_id = ? AND key_id = ? AND url_hash = UNHEX(SHA2(?, 256))`, link.OrgID, link.DomainID, link.KeyID, canonicalURL(link.URL))
+	if err == sql.ErrNoRows {
+		if key != nil {
+			var keyTaken bool
+			err = tx.GetContext(ctx, &keyTaken, `SELECT EXISTS(SELECT 1 FROM links WHERE org_id = ? AND key_id = ? AND member_id = ? AND idempotency_key = ?)`, link.OrgID, link.KeyID, link.MemberID, *key)
+			if err != nil {
+				return err
+			}
+			if keyTaken {
+				return ErrIdempotencyKeyTaken
+			}
+		}
+		return ErrCodeTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *MySQLStore) GetLink(ctx context.Context, orgID int, domainID int, code string) (Link, error) {
+	var link Link
+	err := s.getLinkStmt.GetContext(ctx, &link, orgID, domainID, code)
+	if err == sql.ErrNoRows {
+		query := `SELECT ` + linkColumns + ` FROM links WHERE id = (SELECT link_id FROM link_aliases WHERE org_id = ? AND domain_id = ? AND code = ?)`
+		err = s.db.GetContext(ctx, &link, query, orgID, domainID, code)
+	}
+	if err == sql.ErrNoRows {
+		return link, ErrNotFound
+	}
+
+	return link, err
+}
+
+func (s *MySQLStore) GetLinkByIdempotencyKey(ctx context.Context, orgID int, keyID int, memberID int, key string) (Link, error) {
+	var link Link
+	err := s.db.GetContext(ctx, &link, `SELECT `+linkColumns+`, idempotency_hash FROM links WHERE org_id = ? AND key_id = ? AND member_id = ? AND idempotency_key = ?`, orgID, keyID, memberID, key)
+	if err == sql.ErrNoRows {
+		return link, ErrNotFound
+	}
+
+	return link, err
+}
+
+func (s *MySQLStore) CodeExists(ctx context.Context, orgID int, domainID int, code string) (bool, error) {
+	var exists bool
+	query := `
+		SELECT EXISTS(SELECT 1 FROM links WHERE org_id = ? AND domain_id = ? AND code = ?)
+			OR EXISTS(SELECT 1 FROM link_aliases WHERE org_id = ? AND domain_id = ? AND code = ?)
+	`
+	err := s.db.GetContext(ctx, &exists, query, orgID, domainID, code, orgID, domainID, code)
+
+	return exists, err
+}
+
+// isAlias reports whether code is an alias in the namespace of orgID and
+// domainID. The unique index on links.code does not cover aliases, so the
+// inserts of links check them first.
+func (s *MySQLStore) isAlias(ctx context.Context, orgID int, domainID int, code string) (bool, error) {
+	var exists bool
+	err := s.db.GetContext(ctx, &exists, `SELECT EXISTS(SELECT 1 FROM link_aliases WHERE org_id = ? AND domain_id = ? AND code = ?)`, orgID, domainID, code)
+
+	return exists, err
+}
+
+// ReserveCodeSequence reads the new value back through LAST_INSERT_ID,
+// which MySQL keeps per connection, as UPDATE has no RETURNING clause.
+func (s *MySQLStore) ReserveCodeSequence(ctx context.Context, count int64) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `UPDATE code_sequence SET value = LAST_INSERT_ID(value + ?)`, count)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+func (s *MySQLStore) ConsumeClick(ctx context.Context, linkID int) (bool, error) {
+	query := `UPDATE links SET consumed_clicks = consumed_clicks + 1 WHERE id = ? AND consumed_clicks < max_clicks`
+
+	result, err := s.db.ExecContext(ctx, query, linkID)
+	if err != nil {
+		return false, err
+	}
+
+	consumed, err := result.RowsAffected()
+	return consumed > 0, err
+}
+
+func (s *MySQLStore) ListLinks(ctx context.Context, filter LinkFilter) ([]Link, int, error) {
+	if !linkSortFields[filter.Sort] {
+		return nil, 0, fmt.Errorf("cannot sort links by %q", filter.Sort)
+	}
+
+	where, args := mysqlLinkConditions(filter)
+
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM links WHERE `+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	order := "ASC"
+	if filter.Descending {
+		order = "DESC"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM links
+		WHERE %s
+		ORDER BY %s %s, id %s
+		LIMIT ? OFFSET ?
+	`, linkColumns, where, filter.Sort, order, order)
+
+	links := []Link{}
+	err = s.db.SelectContext(ctx, &links, query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+func (s *MySQLStore) SearchLinks(ctx context.Context, filter LinkFilter, terms []string) ([]Link, int, error) {
+	where, args := mysqlLinkConditions(filter)
+	bind := func(value interface{}) string {
+		args = append(args, value)
+		return "?"
+	}
+	where += " AND " + linkSearchConditions(terms, "LIKE", bind)
+
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM links WHERE `+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rank := linkSearchRank(terms, "LIKE", bind)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM links
+		WHERE %s
+		ORDER BY %s DESC, click_count DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, linkColumns, where, rank)
+
+	links := []Link{}
+	err = s.db.SelectContext(ctx, &links, query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+// ExportLinks pages through the links by id so that no connection is held
+// while fn writes to a slow client.
+func (s *MySQLStore) ExportLinks(ctx context.Context, filter LinkFilter, fn func(link Link) error) error {
+	where, args := mysqlLinkConditions(filter)
+	query := `
+		SELECT ` + linkColumns + `
+		FROM links
+		WHERE ` + where + ` AND id > ?
+		ORDER BY id
+		LIMIT ?
+	`
+
+	lastID := 0
+	for {
+		var page []Link
+		err := s.db.SelectContext(ctx, &page, query, append(args, lastID, exportPageSize)...)
+		if err != nil {
+			return err
+		}
+
+		for _, link := range page {
+			if err := fn(link); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < exportPageSize {
+			return nil
+		}
+		lastID = page[len(page)-1].ID
+	}
+}
+
+func mysqlLinkConditions(filter LinkFilter) (string, []interface{}) {
+	conditions := []string{"org_id = ?", "deleted_at IS NULL", "archived_at IS NULL"}
+	args := []interface{}{filter.OrgID}
+	if filter.Deleted {
+		conditions[1] = "deleted_at IS NOT NULL"
+	}
+	if filter.Archived {
+		conditions[2] = "archived_at IS NOT NULL"
+	}
+
+	if filter.CreatedFrom != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *filter.CreatedFrom)
+	}
+
+	if filter.CreatedTo != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, *filter.CreatedTo)
+	}
+
+	if filter.MinClicks > 0 {
+		conditions = append(conditions, "click_count >= ?")
+		args = append(args, filter.MinClicks)
+	}
+
+	if filter.Broken {
+		conditions = append(conditions, "broken_since IS NOT NULL")
+	}
+
+	if filter.Tag != "" {
+		conditions = append(conditions, "id IN (SELECT link_id FROM link_tags WHERE tag = ?)")
+		args = append(args, filter.Tag)
+	}
+
+	if filter.FolderIDs != nil {
+		conditions = append(conditions, "folder_id IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(filter.FolderIDs)), ", ")+")")
+		for _, id := range filter.FolderIDs {
+			args = append(args, id)
+		}
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+func (s *MySQLStore) UpdateLink(ctx context.Context, orgID int, domainID int, code string, update func(link *Link) error) (Link, error) {
+	var link Link
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return link, err
+	}
+	defer tx.Rollback()
+
+	err = tx.GetContext(ctx, &link, `SELECT `+linkColumns+` FROM links WHERE org_id = ? AND domain_id = ? AND code = ? FOR UPDATE`, orgID, domainID, code)
+	if err == sql.ErrNoRows {
+		return link, ErrNotFound
+	}
+	if err != nil {
+		return link, err
+	}
+
+	before := link
+	url := link.URL
+	if err = update(&link); err != nil {
+		return link, err
+	}
+	if link.URL != url {
+		link.LinkHealth = LinkHealth{}
+	}
+
+	updatedAt := time.Now()
+	link.UpdatedAt = &updatedAt
+
+	query := `
+		UPDATE links SET url = ?, expires_at = ?, redirect_status = ?, tracking_disabled = ?, forward_query = ?,
+			utm_source = ?, utm_medium = ?, utm_campaign = ?, destinations = ?, sticky_destinations = ?,
+			geo_targets = ?, device_targets = ?, routing_rules = ?, active_from = ?, active_until = ?,
+			fallback_url = ?, folder_id = ?, description = ?, notes = ?, checked_at = ?, check_status = ?, check_failures = ?,
+			broken_since = ?, deleted_at = ?, archived_at = ?, updated_at = ?, url_key = ?
+		WHERE id = ?`
+	_, err = tx.ExecContext(ctx, query, link.URL, link.ExpiresAt, link.RedirectStatus, link.TrackingDisabled, link.ForwardQuery, link.UTMSource, link.UTMMedium, link.UTMCampaign, link.Destinations, link.StickyDestinations, link.GeoTargets, link.DeviceTargets, link.RoutingRules, link.ActiveFrom, link.ActiveUntil, link.FallbackURL, link.FolderID, link.Description, link.Notes, link.CheckedAt, link.CheckStatus, link.CheckFailures, link.BrokenSince, link.DeletedAt, link.ArchivedAt, link.UpdatedAt, canonicalURL(link.URL), link.ID)
+	if isMySQLDuplicateOf(err, urlIndexName) {
+		return link, ErrURLTaken
+	}
+	if err != nil {
+		return link, err
+	}
+
+	if err = s.recordLinkEvent(ctx, tx, before, link); err != nil {
+		return link, err
+	}
+
+	return link, tx.Commit()
+}
+
+func (s *MySQLStore) DeleteLink(ctx context.Context, orgID int, domainID int, code string, deleteClicks bool) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var link Link
+	err = tx.GetContext(ctx, &link, `SELECT `+linkColumns+` FROM links WHERE org_id = ? AND domain_id = ? AND code = ? FOR UPDATE`, orgID, domainID, code)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if link.DeletedAt != nil {
+		return ErrLinkDeleted
+	}
+
+	deleted := link
+	deletedAt := time.Now()
+	deleted.DeletedAt = &deletedAt
+
+	_, err = tx.ExecContext(ctx, `UPDATE links SET deleted_at = ? WHERE id = ?`, deleted.DeletedAt, link.ID)
+	if err != nil {
+		return err
+	}
+
+	if err = s.recordLinkEvent(ctx, tx, link, deleted); err != nil {
+		return err
+	}
+
+	if deleteClicks {
+		for _, table := range clickTables {
+			_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE link_id = ?`, link.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *MySQLStore) SetLinkTitle(ctx context.Context, linkID int, url string, title *string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE links SET title = ? WHERE id = ? AND url = ?`, title, linkID, url)
+	return err
+}
+
+// PurgeExpiredLinks locks the expired links while it reads them, as
+// MySQL cannot return the rows a DELETE removes.
+func (s *MySQLStore) PurgeExpiredLinks(ctx context.Context) ([]Link, error) {
+	now := time.Now()
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var purged []Link
+	err = tx.SelectContext(ctx, &purged, `SELECT `+linkColumns+` FROM links WHERE expires_at <= ? AND fallback_url IS NULL FOR UPDATE`, now)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, table := range linkTables {
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE link_id IN (SELECT id FROM links WHERE expires_at <= ? AND fallback_url IS NULL)`, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM links WHERE expires_at <= ? AND fallback_url IS NULL`, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return purged, tx.Commit()
+}
+
+// ClaimLinkChecks locks the links while it reads them, as MySQL cannot
+// return the rows an UPDATE changes.
+func (s *MySQLStore) ClaimLinkChecks(ctx context.Context, before time.Time, limit int) ([]Link, error) {
+	now := time.Now()
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT ` + linkColumns + ` FROM links
+		WHERE deleted_at IS NULL AND archived_at IS NULL AND (expires_at IS NULL OR expires_at > ?)
+			AND (checked_at IS NULL OR checked_at < ?)
+		ORDER BY checked_at
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED`
+
+	links := []Link{}
+	err = tx.SelectContext(ctx, &links, query, now, before, limit)
+	if err != nil || len(links) == 0 {
+		return links, err
+	}
+
+	ids := make([]interface{}, 0, len(links)+1)
+	ids = append(ids, now)
+	for i := range links {
+		links[i].CheckedAt = &now
+		ids = append(ids, links[i].ID)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(links)), ", ")
+	_, err = tx.ExecContext(ctx, `UPDATE links SET checked_at = ? WHERE id IN (`+placeholders+`)`, ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	return links, tx.Commit()
+}
+
+func (s *MySQLStore) SetLinkHealth(ctx context.Context, link Link) error {
+	query := `
+		UPDATE links SET checked_at = ?, check_status = ?, check_failures = ?, broken_since = ?
+		WHERE id = ? AND url = ?`
+
+	result, err := s.db.ExecContext(ctx, query, link.CheckedAt, link.CheckStatus, link.CheckFailures, link.BrokenSince, link.ID, link.URL)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *MySQLStore) QuarantineLink(ctx context.Context, linkID int, url string, reason string) error {
+	applies := func(link Link) bool { return link.URL == url && !link.quarantined() }
+	_, err := s.moderateLink(ctx, linkID, applies, `quarantined_at = ?, quarantine_reason = ?`, time.Now(), reason)
+
+	return err
+}
+
+func (s *MySQLStore) ListQuarantinedLinks(ctx context.Context, confirmed bool, limit int, offset int) ([]Link, int, error) {
+	where := `quarantined_at IS NOT NULL AND quarantine_reviewed_at IS NULL AND deleted_at IS NULL`
+	if confirmed {
+		where = `quarantined_at IS NOT NULL AND quarantine_reviewed_at IS NOT NULL AND deleted_at IS NULL`
+	}
+
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM links WHERE `+where)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	links := []Link{}
+	query := `SELECT ` + linkColumns + ` FROM links WHERE ` + where + ` ORDER BY quarantined_at, id LIMIT ? OFFSET ?`
+	err = s.db.SelectContext(ctx, &links, query, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+func (s *MySQLStore) ReleaseLink(ctx context.Context, linkID int) (Link, error) {
+	return s.moderateLink(ctx, linkID, Link.quarantined, `quarantined_at = NULL, quarantine_reason = NULL, quarantine_reviewed_at = NULL`)
+}
+
+func (s *MySQLStore) ConfirmQuarantine(ctx context.Context, linkID int) (Link, error) {
+	return s.moderateLink(ctx, linkID, Link.quarantined, `quarantine_reviewed_at = ?`, time.Now())
+}
+
+// moderateLink applies assignments, whose placeholders are args, to the
+// link of linkID when applies to it, reads it back, which MySQL cannot do
+// in the UPDATE, and records the change in the history of the link. It
+// fails with ErrNotFound when applies does not.
+func (s *MySQLStore) moderateLink(ctx context.Context, linkID int, applies func(link Link) bool, assignments string, args ...interface{}) (Link, error) {
+	var link Link
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return link, err
+	}
+	defer tx.Rollback()
+
+	var before Link
+	err = tx.GetContext(ctx, &before, `SELECT `+linkColumns+` FROM links WHERE id = ? FOR UPDATE`, linkID)
+	if err == sql.ErrNoRows || (err == nil && !applies(before)) {
+		return link, ErrNotFound
+	}
+	if err != nil {
+		return link, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE links SET `+assignments+` WHERE id = ?`, append(args, linkID)...); err != nil {
+		return link, err
+	}
+
+	if err = tx.GetContext(ctx, &link, `SELECT `+linkColumns+` FROM links WHERE id = ?`, linkID); err != nil {
+		return link, err
+	}
+
+	if err = s.recordLinkEvent(ctx, tx, before, link); err != nil {
+		return link, err
+	}
+
+	return link, tx.Commit()
+}
+
+func (s *MySQLStore) CreateLinkReport(ctx context.Context, report *LinkReport) (bool, error) {
+	query := `
+		INSERT INTO link_reports (link_id, reason, details, email, reporter_hash, created_at)
+		SELECT ?, ?, ?, ?, ?, ? FROM DUAL
+		WHERE EXISTS (SELECT 1 FROM links WHERE id = ? AND reports_whitelisted_at IS NULL)
+		AND NOT EXISTS (SELECT 1 FROM link_reports WHERE link_id = ? AND reporter_hash = ? AND resolved_at IS NULL)`
+
+	now := time.Now()
+	result, err := s.db.ExecContext(ctx, query, report.LinkID, report.Reason, report.Details, report.Email, report.ReporterHash, now, report.LinkID, report.LinkID, report.ReporterHash)
+	if err != nil {
+		return false, err
+	}
+
+	created, err := result.RowsAffected()
+	if err != nil || created == 0 {
+		return false, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return false, err
+	}
+	report.ID, report.CreatedAt = int(id), now
+
+	return true, nil
+}
+
+func (s *MySQLStore) ListReportedLinks(ctx context.Context, limit int, offset int) ([]ReportedLink, int, error) {
+	var total int
+	err := s.db.GetContext(ctx, &total, `
+		SELECT COUNT(DISTINCT r.link_id) FROM link_reports r
+		JOIN links l ON l.id = r.link_id
+		WHERE r.resolved_at IS NULL AND l.deleted_at IS NULL`)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	links := []ReportedLink{}
+	query := `
+		SELECT ` + linkColumns + `, r.open_reports FROM links
+		JOIN (
+			SELECT link_id, COUNT(*) AS open_reports, MAX(id) AS last_report_id
+			FROM link_reports WHERE resolved_at IS NULL GROUP BY link_id
+		) r ON r.link_id = links.id
+		WHERE deleted_at IS NULL
+		ORDER BY r.open_reports DESC, r.last_report_id DESC
+		LIMIT ? OFFSET ?`
+	err = s.db.SelectContext(ctx, &links, query, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+func (s *MySQLStore) LinkReports(ctx context.Context, linkID int) ([]LinkReport, error) {
+	reports := []LinkReport{}
+	err := s.db.SelectContext(ctx, &reports, `SELECT `+linkReportColumns+` FROM link_reports WHERE link_id = ? ORDER BY id DESC`, linkID)
+
+	return reports, err
+}
+
+func (s *MySQLStore) ResolveLinkReports(ctx context.Context, linkID int, resolution string) (Link, error) {
+	var link Link
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return link, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx, `UPDATE link_reports SET resolved_at = ?, resolution = ? WHERE link_id = ? AND resolved_at IS NULL`, now, resolution, linkID)
+	if err != nil {
+		return link, err
+	}
+	resolved, err := result.RowsAffected()
+	if err != nil {
+		return link, err
+	}
+	if resolved == 0 {
+		return link, ErrNotFound
+	}
+
+	var before Link
+	if err = tx.GetContext(ctx, &before, `SELECT `+linkColumns+` FROM links WHERE id = ? FOR UPDATE`, linkID); err != nil {
+		return link, err
+	}
+
+	switch resolution {
+	case reportDisabled:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE links SET quarantined_at = COALESCE(quarantined_at, ?), quarantine_reason = COALESCE(quarantine_reason, ?), quarantine_reviewed_at = ?
+			WHERE id = ?`, now, reportedQuarantineReason, now, linkID)
+	case reportWhitelisted:
+		_, err = tx.ExecContext(ctx, `UPDATE links SET reports_whitelisted_at = ? WHERE id = ?`, now, linkID)
+	}
+	if err != nil {
+		return link, err
+	}
+
+	if err = tx.GetContext(ctx, &link, `SELECT `+linkColumns+` FROM links WHERE id = ?`, linkID); err != nil {
+		return link, err
+	}
+
+	if err = s.recordLinkEvent(ctx, tx, before, link); err != nil {
+		return link, err
+	}
+
+	return link, tx.Commit()
+}
+
+func (s *MySQLStore) ListAllLinks(ctx context.Context, filter AdminLinkFilter) ([]Link, int, error) {
+	var args []interface{}
+	bind := func(value interface{}) string {
+		args = append(args, value)
+		return "?"
+	}
+	where := adminLinkConditions(filter, "LIKE", bind)
+
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM links WHERE `+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM links
+		WHERE %s
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, linkColumns, where)
+
+	links := []Link{}
+	err = s.db.SelectContext(ctx, &links, query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+func (s *MySQLStore) DisableLink(ctx context.Context, linkID int, reason string) (Link, error) {
+	now := time.Now()
+	applies := func(link Link) bool { return link.DeletedAt == nil }
+	return s.moderateLink(ctx, linkID, applies, `quarantined_at = COALESCE(quarantined_at, ?), quarantine_reason = ?, quarantine_reviewed_at = ?`, now, reason, now)
+}
+
+func (s *MySQLStore) KeyActivity(ctx context.Context, from time.Time, to time.Time, limit int) ([]KeyActivity, error) {
+	query := `
+		SELECT k.id AS key_id, k.org_id, o.slug AS org_slug, k.name, k.prefix, k.revoked_at,
+			COUNT(*) AS links, COALESCE(SUM(l.click_count), 0) AS clicks
+		FROM links l
+		JOIN api_keys k ON k.id = l.key_id
+		JOIN organizations o ON o.id = k.org_id
+		WHERE l.created_at >= ? AND l.created_at < ?
+		GROUP BY k.id, k.org_id, o.slug, k.name, k.prefix, k.revoked_at
+		ORDER BY links DESC, k.id
+		LIMIT ?`
+
+	keys := []KeyActivity{}
+	err := s.db.SelectContext(ctx, &keys, query, from, to, limit)
+
+	return keys, err
+}
+
+func (s *MySQLStore) SetLinkTags(ctx context.Context, linkID int, tags []string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM link_tags WHERE link_id = ?`, linkID)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		_, err = tx.ExecContext(ctx, `INSERT INTO link_tags (link_id, tag) VALUES (?, ?)`, linkID, tag)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *MySQLStore) LinkTags(ctx context.Context, linkIDs []int) (map[int][]string, error) {
+	if len(linkIDs) == 0 {
+		return map[int][]string{}, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(linkIDs)), ", ")
+	args := make([]interface{}, len(linkIDs))
+	for i, id := range linkIDs {
+		args[i] = id
+	}
+
+	var rows []linkTag
+	err := s.db.SelectContext(ctx, &rows, `SELECT link_id, tag FROM link_tags WHERE link_id IN (`+placeholders+`) ORDER BY link_id, tag`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupLinkTags(rows), nil
+}
+
+func (s *MySQLStore) ListTags(ctx context.Context, orgID int) ([]TagCount, error) {
+	query := `
+		SELECT t.tag, COUNT(*) AS links, COALESCE(SUM(l.click_count), 0) AS clicks
+		FROM link_tags t
+		JOIN links l ON l.id = t.link_id
+		WHERE l.org_id = ? AND l.deleted_at IS NULL
+		GROUP BY t.tag
+		ORDER BY links DESC, t.tag
+	`
+
+	tags := []TagCount{}
+	err := s.db.SelectContext(ctx, &tags, query, orgID)
+
+	return tags, err
+}
+
+func (s *MySQLStore) AddLinkAlias(ctx context.Context, alias *LinkAlias) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.GetContext(ctx, &exists, `SELECT EXISTS(SELECT 1 FROM links WHERE org_id = ? AND domain_id = ? AND code = ?)`, alias.OrgID, alias.DomainID, alias.Code)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrCodeTaken
+	}
+
+	query := `
+		INSERT INTO link_aliases (org_id, domain_id, link_id, code, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	result, err := tx.ExecContext(ctx, query, alias.OrgID, alias.DomainID, alias.LinkID, alias.Code, time.Now())
+	if isMySQLDuplicate(err) {
+		return ErrCodeTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	err = tx.GetContext(ctx, alias, `SELECT id, org_id, domain_id, link_id, code, created_at FROM link_aliases WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	if err = s.recordAliasEvent(ctx, tx, alias.LinkID, alias.Code, false); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *MySQLStore) LinkAliases(ctx context.Context, linkID int) ([]LinkAlias, error) {
+	aliases := []LinkAlias{}
+	err := s.db.SelectContext(ctx, &aliases, `SELECT id, org_id, domain_id, link_id, code, created_at FROM link_aliases WHERE link_id = ? ORDER BY id`, linkID)
+
+	return aliases, err
+}
+
+func (s *MySQLStore) DeleteLinkAlias(ctx context.Context, linkID int, code string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM link_aliases WHERE link_id = ? AND code = ?`, linkID, code)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+
+	if err = s.recordAliasEvent(ctx, tx, linkID, code, true); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *MySQLStore) CreateFolder(ctx context.Context, folder *Folder) error {
+	query := `
+		INSERT INTO folders (org_id, parent_id, name, created_at)
+		VALUES (?, ?, ?, ?)
+	`
+
+	result, err := s.db.ExecContext(ctx, query, folder.OrgID, folder.ParentID, folder.Name, time.Now())
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	return s.db.GetContext(ctx, folder, `SELECT `+folderColumns+` FROM folders WHERE id = ?`, id)
+}
+
+func (s *MySQLStore) GetFolder(ctx context.Context, orgID int, id int) (Folder, error) {
+	var folder Folder
+	err := s.db.GetContext(ctx, &folder, `SELECT `+folderColumns+` FROM folders WHERE org_id = ? AND id = ?`, orgID, id)
+	if err == sql.ErrNoRows {
+		return folder, ErrFolderNotFound
+	}
+
+	return folder, err
+}
+
+func (s *MySQLStore) ListFolders(ctx context.Context, orgID int) ([]Folder, error) {
+	query := `
+		SELECT ` + folderColumns + `,
+			(SELECT COUNT(*) FROM links WHERE folder_id = folders.id AND deleted_at IS NULL) AS link_count
+		FROM folders
+		WHERE org_id = ?
+		ORDER BY name, id
+	`
+
+	folders := []Folder{}
+	err := s.db.SelectContext(ctx, &folders, query, orgID)
+
+	return folders, err
+}
+
+func (s *MySQLStore) UpdateFolder(ctx context.Context, folder *Folder) error {
+	query := `UPDATE folders SET parent_id = ?, name = ?, updated_at = ? WHERE org_id = ? AND id = ?`
+
+	_, err := s.db.ExecContext(ctx, query, folder.ParentID, folder.Name, time.Now(), folder.OrgID, folder.ID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := s.GetFolder(ctx, folder.OrgID, folder.ID)
+	if err != nil {
+		return err
+	}
+
+	*folder = updated
+	return nil
+}
+
+func (s *MySQLStore) DeleteFolder(ctx context.Context, orgID int, id int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var folder Folder
+	err = tx.GetContext(ctx, &folder, `SELECT `+folderColumns+` FROM folders WHERE org_id = ? AND id = ? FOR UPDATE`, orgID, id)
+	if err == sql.ErrNoRows {
+		return ErrFolderNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var contents int
+	query := `
+		SELECT (SELECT COUNT(*) FROM links WHERE folder_id = ? AND deleted_at IS NULL)
+			+ (SELECT COUNT(*) FROM folders WHERE parent_id = ?)
+	`
+	if err = tx.GetContext(ctx, &contents, query, id, id); err != nil {
+		return err
+	}
+	if contents > 0 {
+		return ErrFolderNotEmpty
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE links SET folder_id = NULL WHERE folder_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM folders WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *MySQLStore) MoveLinks(ctx context.Context, orgID int, domainID int, codes []string, folderID *int) (int64, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(codes)), ", ")
+	args := []interface{}{folderID, time.Now(), orgID, domainID}
+	for _, code := range codes {
+		args = append(args, code)
+	}
+	where := `org_id = ? AND domain_id = ? AND code IN (` + placeholders + `) AND deleted_at IS NULL`
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var links []Link
+	err = tx.SelectContext(ctx, &links, `SELECT `+linkColumns+` FROM links WHERE `+where+` FOR UPDATE`, args[2:]...)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE links SET folder_id = ?, updated_at = ? WHERE `+where, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, link := range links {
+		moved := link
+		moved.FolderID = folderID
+		if err = s.recordLinkEvent(ctx, tx, link, moved); err != nil {
+			return 0, err
+		}
+	}
+
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return moved, tx.Commit()
+}
+
+// recordLinkEvent records the change of a link from before to after
+// within tx, unless no audited field changed.
+func (s *MySQLStore) recordLinkEvent(ctx context.Context, tx *sqlx.Tx, before Link, after Link) error {
+	event, changed, err := newLinkEvent(ctx, before, after)
+	if err != nil || !changed {
+		return err
+	}
+
+	return s.insertLinkEvent(ctx, tx, event)
+}
+
+// recordAliasEvent records the addition of alias to the link of linkID,
+// or its removal, within tx.
+func (s *MySQLStore) recordAliasEvent(ctx context.Context, tx *sqlx.Tx, linkID int, alias string, removed bool) error {
+	var link Link
+	if err := tx.GetContext(ctx, &link, `SELECT `+linkColumns+` FROM links WHERE id = ?`, linkID); err != nil {
+		return err
+	}
+
+	event, err := newAliasEvent(ctx, link, alias, removed)
+	if err != nil {
+		return err
+	}
+
+	return s.insertLinkEvent(ctx, tx, event)
+}
+
+func (s *MySQLStore) insertLinkEvent(ctx context.Context, tx *sqlx.Tx, event LinkEvent) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO link_events (org_id, link_id, code, action, member_id, key_id, admin, changes, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, event.OrgID, event.LinkID, event.Code, event.Action, event.MemberID, event.KeyID, event.Admin, event.Changes, event.CreatedAt)
+	return err
+}
+
+func (s *MySQLStore) LinkHistory(ctx context.Context, linkID int, limit int, offset int) ([]LinkEvent, int, error) {
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM link_events WHERE link_id = ?`, linkID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	events := []LinkEvent{}
+	err = s.db.SelectContext(ctx, &events, `
+		SELECT `+linkEventColumns+` FROM link_events
+		WHERE link_id = ?
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, linkID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+func (s *MySQLStore) ListLinkEvents(ctx context.Context, filter LinkEventFilter) ([]LinkEvent, int, error) {
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+	if filter.OrgID != nil {
+		conditions = append(conditions, "org_id = ?")
+		args = append(args, *filter.OrgID)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, *filter.To)
+	}
+	where := strings.Join(conditions, " AND ")
+
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM link_events WHERE `+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	events := []LinkEvent{}
+	err = s.db.SelectContext(ctx, &events, `SELECT `+linkEventColumns+` FROM link_events WHERE `+where+` ORDER BY id DESC LIMIT ? OFFSET ?`, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+func (s *MySQLStore) AddClicks(ctx context.Context, batch ClickBatch) error {
+	linkTotals := make(map[int]int64)
+	rows := make([]string, 0, len(batch.Daily))
+	args := make([]interface{}, 0, 3*len(batch.Daily))
+	for _, count := range batch.Daily {
+		linkTotals[count.LinkID] += count.Clicks
+		rows = append(rows, "(?, ?, ?)")
+		args = append(args, count.LinkID, count.Clicks, count.Date)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	clickCount := tx.StmtxContext(ctx, s.clickCountStmt)
+	for linkID, total := range linkTotals {
+		_, err = clickCount.ExecContext(ctx, total, linkID)
+		if err != nil {
+			return fmt.Errorf("updating click count: %w", err)
+		}
+	}
+
+	// A batch of nothing but bot clicks has no daily counts.
+	if len(rows) > 0 {
+		clicksQuery := `
+			INSERT INTO clicks (link_id, clicks, date)
+			VALUES ` + strings.Join(rows, ", ") + `
+			ON DUPLICATE KEY UPDATE clicks = clicks + VALUES(clicks)
+		`
+		_, err = tx.ExecContext(ctx, clicksQuery, args...)
+		if err != nil {
+			return fmt.Errorf("inserting/updating click count: %w", err)
+		}
+	}
+
+	for _, count := range batch.Bots {
+		_, err = tx.ExecContext(ctx, `UPDATE links SET bot_clicks = bot_clicks + ? WHERE id = ?`, count.Clicks, count.LinkID)
+		if err != nil {
+			return fmt.Errorf("updating bot click count: %w", err)
+		}
+	}
+
+	if len(batch.Referrers) > 0 {
+		rows = rows[:0]
+		args = args[:0]
+		for _, count := range batch.Referrers {
+			rows = append(rows, "(?, ?, ?)")
+			args = append(args, count.LinkID, count.Referrer, count.Clicks)
+		}
+
+		referrersQuery := `
+			INSERT INTO link_referrers (link_id, referrer, clicks)
+			VALUES ` + strings.Join(rows, ", ") + `
+			ON DUPLICATE KEY UPDATE clicks = clicks + VALUES(clicks)
+		`
+		_, err = tx.ExecContext(ctx, referrersQuery, args...)
+		if err != nil {
+			return fmt.Errorf("inserting/updating referrer count: %w", err)
+		}
+	}
+
+	if len(batch.Countries) > 0 {
+		rows = rows[:0]
+		args = args[:0]
+		for _, count := range batch.Countries {
+			rows = append(rows, "(?, ?, ?)")
+			args = append(args, count.LinkID, count.Country, count.Clicks)
+		}
+
+		countriesQuery := `
+			INSERT INTO link_countries (link_id, country, clicks)
+			VALUES ` + strings.Join(rows, ", ") + `
+			ON DUPLICATE KEY UPDATE clicks = clicks + VALUES(clicks)
+		`
+		_, err = tx.ExecContext(ctx, countriesQuery, args...)
+		if err != nil {
+			return fmt.Errorf("inserting/updating country count: %w", err)
+		}
+	}
+
+	if len(batch.Destinations) > 0 {
+		rows = rows[:0]
+		args = args[:0]
+		for _, count := range batch.Destinations {
+			rows = append(rows, "(?, ?, ?)")
+			args = append(args, count.LinkID, count.DestinationID, count.Clicks)
+		}
+
+		destinationsQuery := `
+			INSERT INTO link_destination_clicks (link_id, destination_id, clicks)
+			VALUES ` + strings.Join(rows, ", ") + `
+			ON DUPLICATE KEY UPDATE clicks = clicks + VALUES(clicks)
+		`
+		_, err = tx.ExecContext(ctx, destinationsQuery, args...)
+		if err != nil {
+			return fmt.Errorf("inserting/updating destination count: %w", err)
+		}
+	}
+
+	if len(batch.Codes) > 0 {
+		rows = rows[:0]
+		args = args[:0]
+		for _, count := range batch.Codes {
+			rows = append(rows, "(?, ?, ?)")
+			args = append(args, count.LinkID, count.Code, count.Clicks)
+		}
+
+		codesQuery := `
+			INSERT INTO link_code_clicks (link_id, code, clicks)
+			VALUES ` + strings.Join(rows, ", ") + `
+			ON DUPLICATE KEY UPDATE clicks = clicks + VALUES(clicks)
+		`
+		_, err = tx.ExecContext(ctx, codesQuery, args...)
+		if err != nil {
+			return fmt.Errorf("inserting/updating code count: %w", err)
+		}
+	}
+
+	if len(batch.Devices) > 0 {
+		rows = rows[:0]
+		args = args[:0]
+		for _, count := range batch.Devices {
+			rows = append(rows, "(?, ?, ?, ?, ?)")
+			args = append(args, count.LinkID, count.DeviceType, count.Browser, count.OS, count.Clicks)
+		}
+
+		devicesQuery := `
+			INSERT INTO link_devices (link_id, device_type, browser, os, clicks)
+			VALUES ` + strings.Join(rows, ", ") + `
+			ON DUPLICATE KEY UPDATE clicks = clicks + VALUES(clicks)
+		`
+		_, err = tx.ExecContext(ctx, devicesQuery, args...)
+		if err != nil {
+			return fmt.Errorf("inserting/updating device count: %w", err)
+		}
+	}
+
+	if len(batch.Events) > 0 {
+		rows = rows[:0]
+		args = args[:0]
+		for _, event := range batch.Events {
+			rows = append(rows, "(?, ?, ?, ?, ?, ?)")
+			args = append(args, event.LinkID, event.ClickedAt, event.Country, event.ReferrerHash, event.IPHash, event.DeviceType)
+		}
+
+		eventsQuery := `
+			INSERT INTO click_events (link_id, clicked_at, country, referrer_hash, ip_hash, device_type)
+			VALUES ` + strings.Join(rows, ", ")
+
+		_, err = tx.ExecContext(ctx, eventsQuery, args...)
+		if err != nil {
+			return fmt.Errorf("inserting click events: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *MySQLStore) ClickEvents(ctx context.Context, linkID int, filter ClickEventFilter) ([]ClickEvent, error) {
+	conditions := []string{"link_id = ?"}
+	args := []interface{}{linkID}
+
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "clicked_at >= ?")
+		args = append(args, filter.From)
+	}
+
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "clicked_at < ?")
+		args = append(args, filter.To)
+	}
+
+	if filter.Before != 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.Before)
+	}
+
+	query := `
+		SELECT ` + clickEventColumns + `
+		FROM click_events
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY id DESC
+		LIMIT ?
+	`
+
+	events := []ClickEvent{}
+	err := s.db.SelectContext(ctx, &events, query, append(args, filter.Limit)...)
+
+	return events, err
+}
+
+func (s *MySQLStore) PurgeClickEvents(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM click_events WHERE clicked_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *MySQLStore) EraseLinkClicks(ctx context.Context, linkID int) (int64, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var events int64
+	err = tx.GetContext(ctx, &events, `SELECT COUNT(*) FROM click_events WHERE link_id = ?`, linkID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, table := range clickTables {
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE link_id = ?`, linkID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE links SET click_count = 0, bot_clicks = 0 WHERE id = ?`, linkID)
+	if err != nil {
+		return 0, err
+	}
+
+	return events, tx.Commit()
+}
+
+func (s *MySQLStore) EraseVisitorClicks(ctx context.Context, ipHash string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM click_events WHERE ip_hash = ?`, ipHash)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+var mysqlClickBuckets = map[string]string{
+	"day":   `DATE_FORMAT(date, '%Y-%m-%d')`,
+	"week":  `DATE_FORMAT(DATE_SUB(date, INTERVAL WEEKDAY(date) DAY), '%Y-%m-%d')`,
+	"month": `DATE_FORMAT(date, '%Y-%m-01')`,
+}
+
+func (s *MySQLStore) ClickTimeSeries(ctx context.Context, linkID int, filter ClickSeriesFilter) ([]ClickBucket, error) {
+	bucket, ok := mysqlClickBuckets[filter.Granularity]
+	if !ok {
+		return nil, fmt.Errorf("cannot aggregate clicks by %q", filter.Granularity)
+	}
+
+	conditions := []string{"link_id = ?"}
+	args := []interface{}{linkID}
+
+	if filter.From != "" {
+		conditions = append(conditions, "date >= ?")
+		args = append(args, filter.From)
+	}
+
+	if filter.To != "" {
+		conditions = append(conditions, "date <= ?")
+		args = append(args, filter.To)
+	}
+
+	rollups := ""
+	if table, ok := clickRollupTables[filter.Granularity]; ok {
+		var rolledUpTo sql.NullString
+		err := s.db.GetContext(ctx, &rolledUpTo, `SELECT DATE_FORMAT(rolled_up_to, '%Y-%m-%d') FROM click_rollups WHERE granularity = ?`, filter.Granularity)
+		if err != nil {
+			return nil, err
+		}
+
+		// The days of the span are read from the rollups, the others are
+		// summed from clicks.
+		if from, to, ok := clickRollupSpan(filter, rolledUpTo.String); ok {
+			rollupConditions := []string{"link_id = ?", "date < ?"}
+			rollupArgs := []interface{}{linkID, to}
+			outside := "date >= ?"
+			args = append(args, to)
+
+			if from != "" {
+				rollupConditions = append(rollupConditions, "date >= ?")
+				rollupArgs = append(rollupArgs, from)
+				outside = "(date >= ? OR date < ?)"
+				args = append(args, from)
+			}
+
+			conditions = append(conditions, outside)
+			args = append(args, rollupArgs...)
+			rollups = `
+		UNION ALL
+		SELECT DATE_FORMAT(date, '%Y-%m-%d') AS date, clicks
+		FROM ` + table + `
+		WHERE ` + strings.Join(rollupConditions, " AND ")
+		}
+	}
+
+	query := `
+		SELECT ` + bucket + ` AS date, SUM(clicks) AS clicks
+		FROM clicks
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		GROUP BY 1` + rollups + `
+		ORDER BY 1
+	`
+
+	series := []ClickBucket{}
+	err := s.db.SelectContext(ctx, &series, query, args...)
+
+	return series, err
+}
+
+func (s *MySQLStore) RollupClicks(ctx context.Context, granularity string, today time.Time) error {
+	table, ok := clickRollupTables[granularity]
+	if !ok {
+		return fmt.Errorf("cannot roll up clicks by %q", granularity)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the progress row keeps instances from rolling up at once.
+	var rolledUpTo sql.NullString
+	err = tx.GetContext(ctx, &rolledUpTo, `SELECT DATE_FORMAT(rolled_up_to, '%Y-%m-%d') FROM click_rollups WHERE granularity = ? FOR UPDATE`, granularity)
+	if err != nil {
+		return err
+	}
+
+	from, to := clickRollupWindow(granularity, rolledUpTo.String, today)
+	window, args := "date < ?", []interface{}{to}
+	if from != "" {
+		window, args = "date < ? AND date >= ?", append(args, from)
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE `+window, args...)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO ` + table + ` (link_id, date, clicks)
+		SELECT link_id, ` + mysqlClickBuckets[granularity] + `, SUM(clicks)
+		FROM clicks
+		WHERE ` + window + `
+		GROUP BY 1, 2
+	`
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE click_rollups SET rolled_up_to = ? WHERE granularity = ?`, to, granularity)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateClickPartitions does nothing, as MySQL cannot partition the click
+// tables while they have foreign keys.
+func (s *MySQLStore) CreateClickPartitions(ctx context.Context, month time.Time) error {
+	return nil
+}
+
+func (s *MySQLStore) TopReferrers(ctx context.Context, linkID int, limit int) ([]ReferrerCount, error) {
+	query := `
+		SELECT link_id, referrer, clicks
+		FROM link_referrers
+		WHERE link_id = ?
+		ORDER BY clicks DESC, referrer
+		LIMIT ?
+	`
+
+	referrers := []ReferrerCount{}
+	err := s.db.SelectContext(ctx, &referrers, query, linkID, limit)
+
+	return referrers, err
+}
+
+func (s *MySQLStore) CountryClicks(ctx context.Context, linkID int) ([]CountryCount, error) {
+	query := `
+		SELECT link_id, country, clicks
+		FROM link_countries
+		WHERE link_id = ?
+		ORDER BY clicks DESC, country
+	`
+
+	countries := []CountryCount{}
+	err := s.db.SelectContext(ctx, &countries, query, linkID)
+
+	return countries, err
+}
+
+func (s *MySQLStore) DestinationClicks(ctx context.Context, linkID int) ([]DestinationCount, error) {
+	query := `
+		SELECT link_id, destination_id, clicks
+		FROM link_destination_clicks
+		WHERE link_id = ?
+		ORDER BY destination_id
+	`
+
+	destinations := []DestinationCount{}
+	err := s.db.SelectContext(ctx, &destinations, query, linkID)
+
+	return destinations, err
+}
+
+func (s *MySQLStore) CodeClicks(ctx context.Context, linkID int) ([]CodeCount, error) {
+	query := `
+		SELECT link_id, code, clicks
+		FROM link_code_clicks
+		WHERE link_id = ?
+		ORDER BY clicks DESC, code
+	`
+
+	codes := []CodeCount{}
+	err := s.db.SelectContext(ctx, &codes, query, linkID)
+
+	return codes, err
+}
+
+func (s *MySQLStore) DeviceClicks(ctx context.Context, linkID int) ([]DeviceCount, error) {
+	query := `
+		SELECT link_id, device_type, browser, os, clicks
+		FROM link_devices
+		WHERE link_id = ?
+		ORDER BY clicks DESC, device_type, browser, os
+	`
+
+	devices := []DeviceCount{}
+	err := s.db.SelectContext(ctx, &devices, query, linkID)
+
+	return devices, err
+}
+
+// ExportClicks pages through the daily clicks by link and date so that no
+// connection is held while fn writes to a slow client.
+func (s *MySQLStore) ExportClicks(ctx context.Context, filter ClickExportFilter, fn func(click ClickExport) error) error {
+	conditions := []string{"links.org_id = ?"}
+	args := []interface{}{filter.OrgID}
+
+	if filter.LinkID != 0 {
+		conditions = append(conditions, "clicks.link_id = ?")
+		args = append(args, filter.LinkID)
+	} else {
+		conditions = append(conditions, "links.deleted_at IS NULL")
+	}
+
+	if filter.From != "" {
+		conditions = append(conditions, "clicks.date >= ?")
+		args = append(args, filter.From)
+	}
+
+	if filter.To != "" {
+		conditions = append(conditions, "clicks.date <= ?")
+		args = append(args, filter.To)
+	}
+
+	query := `
+		SELECT clicks.link_id, links.code, DATE_FORMAT(clicks.date, '%Y-%m-%d') AS date, clicks.clicks
+		FROM clicks
+		JOIN links ON links.id = clicks.link_id
+		WHERE ` + strings.Join(conditions, " AND ") + ` AND (clicks.link_id, clicks.date) > (?, ?)
+		ORDER BY clicks.link_id, clicks.date
+		LIMIT ?
+	`
+
+	lastLinkID, lastDate := 0, exportStartDate
+	for {
+		var page []ClickExport
+		err := s.db.SelectContext(ctx, &page, query, append(args, lastLinkID, lastDate, exportPageSize)...)
+		if err != nil {
+			return err
+		}
+
+		for _, click := range page {
+			if err := fn(click); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < exportPageSize {
+			return nil
+		}
+		lastLinkID, lastDate = page[len(page)-1].LinkID, page[len(page)-1].Date
+	}
+}
+
+func (s *MySQLStore) CreateOrganization(ctx context.Context, org *Organization, owner *Member, key *APIKey, hash string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO organizations (slug, name, created_at) VALUES (?, ?, ?)`
+
+	result, err := tx.ExecContext(ctx, query, org.Slug, org.Name, time.Now())
+	if isMySQLDuplicate(err) {
+		return ErrSlugTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	orgID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	query = `INSERT INTO organization_members (org_id, email, role, created_at) VALUES (?, ?, ?, ?)`
+
+	result, err = tx.ExecContext(ctx, query, orgID, owner.Email, owner.Role, time.Now())
+	if err != nil {
+		return err
+	}
+
+	ownerID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO api_keys (org_id, member_id, name, prefix, key_hash, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	result, err = tx.ExecContext(ctx, query, orgID, ownerID, key.Name, key.Prefix, hash, time.Now())
+	if err != nil {
+		return err
+	}
+
+	keyID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	err = tx.GetContext(ctx, org, `SELECT `+organizationColumns+` FROM organizations WHERE id = ?`, orgID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.GetContext(ctx, owner, `SELECT `+memberColumns+` FROM organization_members WHERE id = ?`, ownerID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.GetContext(ctx, key, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, keyID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *MySQLStore) GetOrganization(ctx context.Context, id int) (Organization, error) {
+	var org Organization
+	err := s.db.GetContext(ctx, &org, `SELECT `+organizationColumns+` FROM organizations WHERE id = ?`, id)
+	if err == sql.ErrNoRows {
+		return org, ErrOrgNotFound
+	}
+
+	return org, err
+}
+
+func (s *MySQLStore) GetOrganizationBySlug(ctx context.Context, slug string) (Organization, error) {
+	var org Organization
+	err := s.db.GetContext(ctx, &org, `SELECT `+organizationColumns+` FROM organizations WHERE slug = ?`, slug)
+	if err == sql.ErrNoRows {
+		return org, ErrOrgNotFound
+	}
+
+	return org, err
+}
+
+func (s *MySQLStore) AddMember(ctx context.Context, member *Member) error {
+	query := `INSERT INTO organization_members (org_id, email, role, created_at) VALUES (?, ?, ?, ?)`
+
+	result, err := s.db.ExecContext(ctx, query, member.OrgID, member.Email, member.Role, time.Now())
+	if isMySQLDuplicate(err) {
+		return ErrMemberExists
+	}
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	return s.db.GetContext(ctx, member, `SELECT `+memberColumns+` FROM organization_members WHERE id = ?`, id)
+}
+
+func (s *MySQLStore) GetMember(ctx context.Context, orgID int, memberID int) (Member, error) {
+	var member Member
+	err := s.db.GetContext(ctx, &member, `SELECT `+memberColumns+` FROM organization_members WHERE org_id = ? AND id = ?`, orgID, memberID)
+	if err == sql.ErrNoRows {
+		return member, ErrMemberNotFound
+	}
+
+	return member, err
+}
+
+func (s *MySQLStore) ListMembers(ctx context.Context, orgID int) ([]Member, error) {
+	members := []Member{}
+	err := s.db.SelectContext(ctx, &members, `SELECT `+memberColumns+` FROM organization_members WHERE org_id = ? ORDER BY id`, orgID)
+
+	return members, err
+}
+
+func (s *MySQLStore) RemoveMember(ctx context.Context, orgID int, memberID int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM api_key_usage WHERE api_key_id IN (SELECT id FROM api_keys WHERE org_id = ? AND member_id = ?)`, orgID, memberID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM api_keys WHERE org_id = ? AND member_id = ?`, orgID, memberID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM member_identities WHERE member_id IN (SELECT id FROM organization_members WHERE org_id = ? AND id = ?)`, orgID, memberID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM member_usage WHERE member_id IN (SELECT id FROM organization_members WHERE org_id = ? AND id = ?)`, orgID, memberID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM organization_members WHERE org_id = ? AND id = ?`, orgID, memberID)
+	if err != nil {
+		return err
+	}
+
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrMemberNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (s *MySQLStore) CreateAPIKey(ctx context.Context, key *APIKey, hash string) error {
+	query := `
+		INSERT INTO api_keys (org_id, member_id, name, prefix, key_hash, scopes, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := s.db.ExecContext(ctx, query, key.OrgID, key.MemberID, key.Name, key.Prefix, hash, key.Scopes, key.ExpiresAt, time.Now())
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	return s.db.GetContext(ctx, key, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, id)
+}
+
+func (s *MySQLStore) GetAPIKey(ctx context.Context, orgID int, keyID int) (APIKey, error) {
+	var key APIKey
+	err := s.db.GetContext(ctx, &key, `SELECT `+apiKeyColumns+` FROM api_keys WHERE org_id = ? AND id = ?`, orgID, keyID)
+	if err == sql.ErrNoRows {
+		return key, ErrAPIKeyNotFound
+	}
+
+	return key, err
+}
+
+func (s *MySQLStore) RotateAPIKey(ctx context.Context, old *APIKey, key *APIKey, hash string, graceUntil time.Time) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO api_keys (org_id, member_id, name, prefix, key_hash, scopes, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := tx.ExecContext(ctx, query, key.OrgID, key.MemberID, key.Name, key.Prefix, hash, key.Scopes, key.ExpiresAt, time.Now())
+	if err != nil {
+		return err
+	}
+
+	keyID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE api_keys SET expires_at = ?, replaced_by = ?
+		WHERE org_id = ? AND id = ? AND revoked_at IS NULL AND replaced_by IS NULL
+	`
+
+	result, err = tx.ExecContext(ctx, query, graceUntil, keyID, old.OrgID, old.ID)
+	if err != nil {
+		return err
+	}
+
+	rotated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rotated == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	// The new key takes over the usage and links of the old one, and the
+	// keys the old one replaced, so that rotating resets neither its quota
+	// nor the links its shortens are deduplicated against.
+	_, err = tx.ExecContext(ctx, `UPDATE api_key_usage SET api_key_id = ? WHERE api_key_id = ?`, keyID, old.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE links SET key_id = ? WHERE org_id = ? AND key_id = ?`, keyID, old.OrgID, old.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE api_keys SET replaced_by = ? WHERE org_id = ? AND replaced_by = ?`, keyID, old.OrgID, old.ID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.GetContext(ctx, key, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, keyID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.GetContext(ctx, old, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, old.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *MySQLStore) SetAPIKeySigningSecret(ctx context.Context, orgID int, keyID int, secret *string) error {
+	query := `UPDATE api_keys SET signing_secret = ? WHERE org_id = ? AND id = ? AND revoked_at IS NULL`
+
+	result, err := s.db.ExecContext(ctx, query, secret, orgID, keyID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func (s *MySQLStore) AuthenticateSigningKey(ctx context.Context, keyID int) (APIKey, Member, error) {
+	var key APIKey
+	var member Member
+
+	err := s.db.GetContext(ctx, &key, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ? AND revoked_at IS NULL AND signing_secret IS NOT NULL`, keyID)
+	if err == sql.ErrNoRows {
+		return key, member, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return key, member, err
+	}
+
+	member, err = s.GetMember(ctx, key.OrgID, key.MemberID)
+	if err == ErrMemberNotFound {
+		return key, member, ErrAPIKeyNotFound
+	}
+
+	return key, member, err
+}
+
+func (s *MySQLStore) TouchAPIKey(ctx context.Context, keyID int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = ? WHERE id = ?`, time.Now(), keyID)
+	return err
+}
+
+func (s *MySQLStore) ListAPIKeys(ctx context.Context, orgID int) ([]APIKey, error) {
+	keys := []APIKey{}
+	err := s.db.SelectContext(ctx, &keys, `SELECT `+apiKeyColumns+` FROM api_keys WHERE org_id = ? ORDER BY id`, orgID)
+
+	return keys, err
+}
+
+func (s *MySQLStore) RevokeAPIKey(ctx context.Context, orgID int, keyID int) error {
+	query := `UPDATE api_keys SET revoked_at = ? WHERE org_id = ? AND id = ? AND revoked_at IS NULL`
+
+	result, err := s.db.ExecContext(ctx, query, time.Now(), orgID, keyID)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func (s *MySQLStore) IdentityMembers(ctx context.Context, provider string, subject string, email string) ([]Member, error) {
+	query := `
+		SELECT ` + memberColumns + ` FROM organization_members m
+		WHERE m.id IN (SELECT member_id FROM member_identities WHERE provider = ? AND subject = ?)
+		   OR (? <> '' AND LOWER(m.email) = LOWER(?)
+		       AND NOT EXISTS (SELECT 1 FROM member_identities i WHERE i.member_id = m.id AND i.provider = ?))
+		ORDER BY m.id
+	`
+
+	members := []Member{}
+	err := s.db.SelectContext(ctx, &members, query, provider, subject, email, email, provider)
+
+	return members, err
+}
+
+func (s *MySQLStore) LinkIdentity(ctx context.Context, memberID int, provider string, subject string) error {
+	query := `
+		INSERT INTO member_identities (member_id, provider, subject, created_at, last_login_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE subject = VALUES(subject), last_login_at = VALUES(last_login_at)
+	`
+
+	now := time.Now()
+	_, err := s.db.ExecContext(ctx, query, memberID, provider, subject, now, now)
+	return err
+}
+
+func (s *MySQLStore) AuthenticateAPIKey(ctx context.Context, hash string) (APIKey, Member, error) {
+	var key APIKey
+	var member Member
+
+	err := s.db.GetContext(ctx, &key, `SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL`, hash)
+	if err == sql.ErrNoRows {
+		return key, member, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return key, member, err
+	}
+
+	member, err = s.GetMember(ctx, key.OrgID, key.MemberID)
+	if err == ErrMemberNotFound {
+		return key, member, ErrAPIKeyNotFound
+	}
+
+	return key, member, err
+}
+
+func (s *MySQLStore) CreateDomain(ctx context.Context, domain *CustomDomain) error {
+	query := `
+		INSERT INTO domains (org_id, hostname, verification_token, created_at)
+		SELECT ?, ?, ?, ? FROM DUAL
+		WHERE NOT EXISTS (SELECT 1 FROM domains WHERE hostname = ? AND verified_at IS NOT NULL)`
+
+	result, err := s.db.ExecContext(ctx, query, domain.OrgID, domain.Hostname, domain.VerificationToken, time.Now(), domain.Hostname)
+	if isMySQLDuplicate(err) {
+		return ErrDomainTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return ErrDomainTaken
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	return s.db.GetContext(ctx, domain, `SELECT `+customDomainColumns+` FROM domains WHERE id = ?`, id)
+}
+
+func (s *MySQLStore) GetDomain(ctx context.Context, orgID int, id int) (CustomDomain, error) {
+	var domain CustomDomain
+	err := s.db.GetContext(ctx, &domain, `SELECT `+customDomainColumns+` FROM domains WHERE org_id = ? AND id = ?`, orgID, id)
+	if err == sql.ErrNoRows {
+		return domain, ErrDomainNotFound
+	}
+
+	return domain, err
+}
+
+func (s *MySQLStore) GetDomainByHostname(ctx context.Context, hostname string) (CustomDomain, error) {
+	var domain CustomDomain
+	err := s.db.GetContext(ctx, &domain, `SELECT `+customDomainColumns+` FROM domains WHERE hostname = ? AND verified_at IS NOT NULL`, hostname)
+	if err == sql.ErrNoRows {
+		return domain, ErrDomainNotFound
+	}
+
+	return domain, err
+}
+
+func (s *MySQLStore) ListDomainsByHostname(ctx context.Context, hostname string) ([]CustomDomain, error) {
+	domains := []CustomDomain{}
+	err := s.db.SelectContext(ctx, &domains, `SELECT `+customDomainColumns+` FROM domains WHERE hostname = ? ORDER BY id`, hostname)
+
+	return domains, err
+}
+
+func (s *MySQLStore) ListDomains(ctx context.Context, orgID int) ([]CustomDomain, error) {
+	domains := []CustomDomain{}
+	err := s.db.SelectContext(ctx, &domains, `SELECT `+customDomainColumns+` FROM domains WHERE org_id = ? ORDER BY hostname`, orgID)
+
+	return domains, err
+}
+
+func (s *MySQLStore) VerifyDomain(ctx context.Context, domain *CustomDomain) error {
+	query := `UPDATE domains SET verified_at = ? WHERE org_id = ? AND id = ?`
+
+	_, err := s.db.ExecContext(ctx, query, time.Now(), domain.OrgID, domain.ID)
+	if isMySQLDuplicate(err) {
+		return ErrDomainTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	verified, err := s.GetDomain(ctx, domain.OrgID, domain.ID)
+	if err != nil {
+		return err
+	}
+
+	*domain = verified
+	return nil
+}
+
+func (s *MySQLStore) DeleteDomain(ctx context.Context, orgID int, id int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var domain CustomDomain
+	err = tx.GetContext(ctx, &domain, `SELECT `+customDomainColumns+` FROM domains WHERE org_id = ? AND id = ? FOR UPDATE`, orgID, id)
+	if err == sql.ErrNoRows {
+		return ErrDomainNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var inUse bool
+	err = tx.GetContext(ctx, &inUse, `SELECT EXISTS(SELECT 1 FROM links WHERE org_id = ? AND domain_id = ?)`, orgID, id)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrDomainInUse
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM domains WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ConsumeUsage leaves the row untouched when the quota is exhausted, which
+// the driver reports as no affected rows.
+func (s *MySQLStore) ConsumeUsage(ctx context.Context, meter UsageMeter, month string, metric string, n int, limit int) (int, bool, error) {
+	if !usageMetrics[metric] {
+		return 0, false, fmt.Errorf("unknown usage metric %q", metric)
+	}
+
+	allowed := true
+	if limit > 0 && n > limit {
+		allowed = false
+	} else {
+		table, column, id := meter.usageTable()
+		query := fmt.Sprintf(`
+			INSERT INTO %[2]s (%[3]s, month, %[1]s)
+			VALUES (?, ?, ?)
+			ON DUPLICATE KEY UPDATE %[1]s = IF(? = 0 OR %[1]s + VALUES(%[1]s) <= ?, %[1]s + VALUES(%[1]s), %[1]s)
+		`, metric, table, column)
+
+		result, err := s.db.ExecContext(ctx, query, id, month, n, limit, limit)
+		if err != nil {
+			return 0, false, err
+		}
+
+		changed, err := result.RowsAffected()
+		if err != nil {
+			return 0, false, err
+		}
+		allowed = changed > 0
+	}
+
+	usage, err := s.MeterUsage(ctx, meter, month)
+	return usageOf(usage, metric), allowed, err
+}
+
+func (s *MySQLStore) MeterUsage(ctx context.Context, meter UsageMeter, month string) (Usage, error) {
+	table, column, id := meter.usageTable()
+
+	var usage Usage
+	err := s.db.GetContext(ctx, &usage, `SELECT shortens, redirects FROM `+table+` WHERE `+column+` = ? AND month = ?`, id, month)
+	if err == sql.ErrNoRows {
+		return usage, nil
+	}
+
+	return usage, err
+}
+
+func (s *MySQLStore) Stats(ctx context.Context, filter StatsFilter) (Stats, error) {
+	var stats Stats
+	day, week, month := statsWindows(filter.Today)
+
+	scope, args := "", []interface{}{day, week, month}
+	if !filter.AllOrgs {
+		scope, args = ` AND org_id = ?`, append(args, filter.OrgID)
+	}
+	if filter.Tag != "" {
+		scope, args = scope+` AND id IN (SELECT link_id FROM link_tags WHERE tag = ?)`, append(args, filter.Tag)
+	}
+
+	query := `
+		SELECT
+			COUNT(*) AS links,
+			COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0) AS created_today,
+			COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0) AS created_7_days,
+			COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0) AS created_30_days
+		FROM links
+		WHERE deleted_at IS NULL` + scope
+
+	err := s.db.GetContext(ctx, &stats, query, args...)
+	if err != nil {
+		return stats, err
+	}
+
+	scope, args = "", []interface{}{day.Format("2006-01-02"), week.Format("2006-01-02"), month.Format("2006-01-02")}
+	if !filter.AllOrgs {
+		scope, args = ` AND l.org_id = ?`, append(args, filter.OrgID)
+	}
+	if filter.Tag != "" {
+		scope, args = scope+` AND l.id IN (SELECT link_id FROM link_tags WHERE tag = ?)`, append(args, filter.Tag)
+	}
+
+	query = `
+		SELECT
+			COALESCE(SUM(CASE WHEN c.date >= ? THEN c.clicks ELSE 0 END), 0) AS clicks_today,
+			COALESCE(SUM(CASE WHEN c.date >= ? THEN c.clicks ELSE 0 END), 0) AS clicks_7_days,
+			COALESCE(SUM(c.clicks), 0) AS clicks_30_days
+		FROM clicks c
+		JOIN links l ON l.id = c.link_id
+		WHERE c.date >= ? AND l.deleted_at IS NULL` + scope
+
+	err = s.db.GetContext(ctx, &stats, query, args...)
+	if err != nil {
+		return stats, err
+	}
+
+	scope, args = "", nil
+	if !filter.AllOrgs {
+		scope, args = ` AND org_id = ?`, append(args, filter.OrgID)
+	}
+	if filter.Tag != "" {
+		scope, args = scope+` AND id IN (SELECT link_id FROM link_tags WHERE tag = ?)`, append(args, filter.Tag)
+	}
+
+	query = `
+		SELECT ` + linkColumns + `
+		FROM links
+		WHERE deleted_at IS NULL` + scope + `
+		ORDER BY click_count DESC, id
+		LIMIT ?
+	`
+
+	stats.TopLinks = []Link{}
+	err = s.db.SelectContext(ctx, &stats.TopLinks, query, append(args, filter.TopLimit)...)
+
+	return stats, err
+}
+
+func (s *MySQLStore) TrendingLinks(ctx context.Context, filter TrendingFilter) ([]TrendingLink, int, error) {
+	var total int
+	query := `
+		SELECT COUNT(DISTINCT c.link_id)
+		FROM clicks c
+		JOIN links l ON l.id = c.link_id
+		WHERE c.date >= ? AND l.org_id = ? AND l.deleted_at IS NULL
+	`
+
+	err := s.db.GetContext(ctx, &total, query, filter.From, filter.OrgID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query = `
+		SELECT ` + linkColumns + `, w.clicks AS window_clicks
+		FROM (
+			SELECT link_id, SUM(clicks) AS clicks
+			FROM clicks
+			WHERE date >= ?
+			GROUP BY link_id
+		) w
+		JOIN links ON links.id = w.link_id
+		WHERE org_id = ? AND deleted_at IS NULL
+		ORDER BY w.clicks DESC, id
+		LIMIT ? OFFSET ?
+	`
+
+	links := []TrendingLink{}
+	err = s.db.SelectContext(ctx, &links, query, filter.From, filter.OrgID, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+func (s *MySQLStore) CreateWebhook(ctx context.Context, hook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (org_id, url, events, secret, active, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := s.db.ExecContext(ctx, query, hook.OrgID, hook.URL, hook.Events, hook.Secret, hook.Active, time.Now())
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	return s.db.GetContext(ctx, hook, `SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id)
+}
+
+func (s *MySQLStore) GetWebhook(ctx context.Context, orgID int, id int) (Webhook, error) {
+	var hook Webhook
+	err := s.db.GetContext(ctx, &hook, `SELECT `+webhookColumns+` FROM webhooks WHERE org_id = ? AND id = ?`, orgID, id)
+	if err == sql.ErrNoRows {
+		return hook, ErrWebhookNotFound
+	}
+
+	return hook, err
+}
+
+func (s *MySQLStore) ListWebhooks(ctx context.Context, orgID int) ([]Webhook, error) {
+	hooks := []Webhook{}
+	err := s.db.SelectContext(ctx, &hooks, `SELECT `+webhookColumns+` FROM webhooks WHERE org_id = ? ORDER BY id`, orgID)
+
+	return hooks, err
+}
+
+// UpdateWebhook checks for the webhook by reading it back, as MySQL does
+// not count rows whose values did not change as affected.
+func (s *MySQLStore) UpdateWebhook(ctx context.Context, hook *Webhook) error {
+	query := `UPDATE webhooks SET url = ?, events = ?, active = ?, updated_at = ? WHERE org_id = ? AND id = ?`
+
+	_, err := s.db.ExecContext(ctx, query, hook.URL, hook.Events, hook.Active, time.Now(), hook.OrgID, hook.ID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := s.GetWebhook(ctx, hook.OrgID, hook.ID)
+	if err != nil {
+		return err
+	}
+
+	*hook = updated
+	return nil
+}
+
+func (s *MySQLStore) DeleteWebhook(ctx context.Context, orgID int, id int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE org_id = ? AND id = ?)`, orgID, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM webhooks WHERE org_id = ? AND id = ?`, orgID, id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrWebhookNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (s *MySQLStore) EnqueueDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	now := time.Now()
+
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, attempts, next_attempt_at, created_at)
+		VALUES (?, ?, ?, 0, ?, ?)
+	`
+
+	result, err := s.db.ExecContext(ctx, query, delivery.WebhookID, delivery.Event, delivery.Payload, now, now)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	delivery.ID = int(id)
+	delivery.CreatedAt = now
+	return nil
+}
+
+// ClaimDeliveries claims rows with a single-table UPDATE, which MySQL
+// allows to be ordered and limited.
+func (s *MySQLStore) ClaimDeliveries(ctx context.Context, token string, leaseUntil time.Time, limit int) ([]WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries SET claim_token = ?, next_attempt_at = ?
+		WHERE next_attempt_at <= ?
+		ORDER BY next_attempt_at
+		LIMIT ?
+	`
+
+	_, err := s.db.ExecContext(ctx, query, token, leaseUntil, time.Now(), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT ` + claimedDeliveryColumns + `
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.claim_token = ?
+		ORDER BY d.id
+	`
+
+	deliveries := []WebhookDelivery{}
+	err = s.db.SelectContext(ctx, &deliveries, query, token)
+
+	return deliveries, err
+}
+
+func (s *MySQLStore) UpdateDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET attempts = ?, next_attempt_at = ?, delivered_at = ?, last_error = ?, claim_token = NULL
+		WHERE id = ? AND claim_token = ?
+	`
+
+	_, err := s.db.ExecContext(ctx, query, delivery.Attempts, delivery.NextAttemptAt, delivery.DeliveredAt, delivery.LastError, delivery.ID, delivery.ClaimToken)
+	return err
+}
+
+func (s *MySQLStore) PurgeDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE next_attempt_at IS NULL AND created_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *MySQLStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *MySQLStore) CreateDomainRule(ctx context.Context, rule *DomainRule) error {
+	query := `INSERT INTO domain_rules (domain, list, created_at) VALUES (?, ?, ?)`
+
+	result, err := s.db.ExecContext(ctx, query, rule.Domain, rule.List, time.Now())
+	if isMySQLDuplicate(err) {
+		return ErrDomainRuleExists
+	}
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	return s.db.GetContext(ctx, rule, `SELECT `+domainRuleColumns+` FROM domain_rules WHERE id = ?`, id)
+}
+
+func (s *MySQLStore) ListDomainRules(ctx context.Context) ([]DomainRule, error) {
+	rules := []DomainRule{}
+	err := s.db.SelectContext(ctx, &rules, `SELECT `+domainRuleColumns+` FROM domain_rules ORDER BY domain`)
+
+	return rules, err
+}
+
+func (s *MySQLStore) DeleteDomainRule(ctx context.Context, id int) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM domain_rules WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrDomainRuleNotFound
+	}
+
+	return nil
+}
+
+func (s *MySQLStore) Close() error {
+	closeStatements(s.getLinkStmt, s.clickCountStmt)
+	return s.db.Close()
+}
+
+func isMySQLDuplicate(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062
+}
+
+// isMySQLDuplicateOf reports whether err is a duplicate key error on index.
+// MySQL qualifies the key with the table name in its message, MariaDB
+// does not.
+func isMySQLDuplicateOf(err error, index string) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, index+"'")
+}
@@ -0,0 +1,2416 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// urlIndexName is the partial unique index on the MD5 of links.url_key
+// covering permanent, unlimited, non-deleted links per domain and API key.
+// Shortening deduplicates against it.
+const urlIndexName = "links_url_active_key"
+
+// idempotencyKeyIndexName is the unique index on the idempotency keys of
+// links, per namespace.
+const idempotencyKeyIndexName = "links_idempotency_key"
+
+const linkColumns = `id, org_id, code, url, created_at, attempt_count, click_count, expires_at, redirect_status, deleted_at, archived_at, updated_at, max_clicks, title, bot_clicks, tracking_disabled, forward_query, utm_source, utm_medium, utm_campaign, destinations, sticky_destinations, geo_targets, device_targets, routing_rules, active_from, active_until, fallback_url, folder_id, description, notes, checked_at, check_status, check_failures, broken_since, idempotency_key, key_id, force_new, domain_id, quarantined_at, quarantine_reason, quarantine_reviewed_at`
+
+const (
+	organizationColumns = `id, slug, name, created_at`
+	memberColumns       = `id, org_id, email, role, created_at`
+	apiKeyColumns       = `id, org_id, member_id, name, prefix, scopes, created_at, expires_at, last_used_at, revoked_at, replaced_by, signing_secret`
+	customDomainColumns = `id, org_id, hostname, verification_token, verified_at, created_at`
+	linkReportColumns   = `id, link_id, reason, details, email, reporter_hash, created_at, resolved_at, resolution`
+)
+
+const folderColumns = `id, org_id, parent_id, name, created_at, updated_at`
+
+const linkEventColumns = `id, org_id, link_id, code, action, member_id, key_id, admin, changes, created_at`
+
+const (
+	webhookColumns = `id, org_id, url, events, secret, active, created_at, updated_at`
+	// claimedDeliveryColumns select a delivery with the webhook it is for,
+	// joined as d and w.
+	claimedDeliveryColumns = `d.id, d.webhook_id, d.event, d.payload, d.attempts, d.next_attempt_at, d.claim_token, d.delivered_at, d.last_error, d.created_at, w.url, w.secret, w.active`
+)
+
+const domainRuleColumns = `id, domain, list, created_at`
+
+const clickEventColumns = `id, link_id, clicked_at, country, referrer_hash, device_type`
+
+// PostgresStore implements Store on top of the links and clicks tables.
+type PostgresStore struct {
+	db *sqlx.DB
+
+	// The statements of the redirect path are prepared once, when the
+	// store is opened.
+	getLinkStmt     *sqlx.Stmt
+	clickCountStmt  *sqlx.Stmt
+	dailyClicksStmt *sqlx.Stmt
+}
+
+// NewPostgresStore prepares the statements of the store, so the schema
+// must be migrated first.
+func NewPostgresStore(db *sqlx.DB) (*PostgresStore, error) {
+	s := &PostgresStore{db: db}
+
+	var err error
+	s.getLinkStmt, err = db.Preparex(`SELECT ` + linkColumns + ` FROM links WHERE org_id = $1 AND domain_id = $2 AND code = $3`)
+	if err != nil {
+		return nil, fmt.Errorf("preparing link lookup: %w", err)
+	}
+
+	s.clickCountStmt, err = db.Preparex(`
+		UPDATE links SET click_count = links.click_count + batch.clicks
+		FROM (SELECT unnest($1::bigint[]) AS id, unnest($2::bigint[]) AS clicks) AS batch
+		WHERE links.id = batch.id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("preparing click count update: %w", err)
+	}
+
+	s.dailyClicksStmt, err = db.Preparex(`
+		INSERT INTO clicks (link_id, clicks, date)
+		SELECT unnest($1::bigint[]), unnest($2::bigint[]), unnest($3::date[])
+		ON CONFLICT (link_id, date)
+		DO UPDATE SET clicks = clicks.clicks + EXCLUDED.clicks
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("preparing click upsert: %w", err)
+	}
+
+	return s, nil
+}
+
+func (s *PostgresStore) CreateLink(ctx context.Context, link *Link) error {
+	aliased, err := s.isAlias(ctx, link.OrgID, link.DomainID, link.Code)
+	if err != nil {
+		return err
+	}
+	if aliased {
+		return ErrCodeTaken
+	}
+
+	query := `
+		INSERT INTO links (org_id, code, url, created_at, attempt_count, expires_at, redirect_status, max_clicks, tracking_disabled, forward_query, utm_source, utm_medium, utm_campaign, destinations, sticky_destinations, geo_targets, device_targets, routing_rules, active_from, active_until, fallback_url, folder_id, description, notes, idempotency_key, key_id, force_new, domain_id, url_key, idempotency_hash, member_id)
+		VALUES ($1, $2, $3, $4, 1, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30)
+		RETURNING ` + linkColumns
+
+	err = s.db.GetContext(ctx, link, query, link.OrgID, link.Code, link.URL, time.Now(), link.ExpiresAt, link.RedirectStatus, link.MaxClicks, link.TrackingDisabled, link.ForwardQuery, link.UTMSource, link.UTMMedium, link.UTMCampaign, link.Destinations, link.StickyDestinations, link.GeoTargets, link.DeviceTargets, link.RoutingRules, link.ActiveFrom, link.ActiveUntil, link.FallbackURL, link.FolderID, link.Description, link.Notes, link.IdempotencyKey, link.KeyID, link.ForceNew, link.DomainID, canonicalURL(link.URL), link.IdempotencyHash, link.MemberID)
+	if isUniqueViolationOf(err, urlIndexName) {
+		return ErrURLTaken
+	}
+	if isUniqueViolationOf(err, idempotencyKeyIndexName) {
+		return ErrIdempotencyKeyTaken
+	}
+	if isUniqueViolation(err) {
+		return ErrCodeTaken
+	}
+
+	return err
+}
+
+// UpsertLink relies on urlIndexName so that concurrent requests for the
+// same URL share one row instead of racing to insert duplicates.
+func (s *PostgresStore) UpsertLink(ctx context.Context, link *Link) error {
+	aliased, err := s.isAlias(ctx, link.OrgID, link.DomainID, link.Code)
+	if err != nil {
+		return err
+	}
+	if aliased {
+		return ErrCodeTaken
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO links (org_id, code, url, created_at, attempt_count, expires_at, redirect_status, tracking_disabled, forward_query, idempotency_key, key_id, domain_id, url_key, idempotency_hash, member_id)
+		VALUES ($1, $2, $3, $4, 1, NULL, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (org_id, domain_id, key_id, md5(url_key)) WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+			AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+			AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL
+			AND active_from IS NULL AND active_until IS NULL AND fallback_url IS NULL
+			AND routing_rules IS NULL AND archived_at IS NULL AND NOT force_new
+		DO UPDATE SET attempt_count = links.attempt_count + 1
+		RETURNING ` + linkColumns
+
+	err = tx.GetContext(ctx, link, query, link.OrgID, link.Code, link.URL, time.Now(), link.RedirectStatus, link.TrackingDisabled, link.ForwardQuery, link.IdempotencyKey, link.KeyID, link.DomainID, canonicalURL(link.URL), link.IdempotencyHash, link.MemberID)
+	if isUniqueViolationOf(err, idempotencyKeyIndexName) {
+		return ErrIdempotencyKeyTaken
+	}
+	if isUniqueViolation(err) {
+		return ErrCodeTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) GetLink(ctx context.Context, orgID int, domainID int, code string) (Link, error) {
+	var link Link
+	err := s.getLinkStmt.GetContext(ctx, &link, orgID, domainID, code)
+	if err == sql.ErrNoRows {
+		query := `SELECT ` + linkColumns + ` FROM links WHERE id = (SELECT link_id FROM link_aliases WHERE org_id = $1 AND domain_id = $2 AND code = $3)`
+		err = s.db.GetContext(ctx, &link, query, orgID, domainID, code)
+	}
+	if err == sql.ErrNoRows {
+		return link, ErrNotFound
+	}
+
+	return link, err
+}
+
+func (s *PostgresStore) GetLinkByIdempotencyKey(ctx context.Context, orgID int, keyID int, memberID int, key string) (Link, error) {
+	var link Link
+	err := s.db.GetContext(ctx, &link, `SELECT `+linkColumns+`, idempotency_hash FROM links WHERE org_id = $1 AND key_id = $2 AND member_id = $3 AND idempotency_key = $4`, orgID, keyID, memberID, key)
+	if err == sql.ErrNoRows {
+		return link, ErrNotFound
+	}
+
+	return link, err
+}
+
+func (s *PostgresStore) CodeExists(ctx context.Context, orgID int, domainID int, code string) (bool, error) {
+	var exists bool
+	query := `
+		SELECT EXISTS(SELECT 1 FROM links WHERE org_id = $1 AND domain_id = $2 AND code = $3)
+			OR EXISTS(SELECT 1 FROM link_aliases WHERE org_id = $1 AND domain_id = $2 AND code = $3)
+	`
+	err := s.db.GetContext(ctx, &exists, query, orgID, domainID, code)
+
+	return exists, err
+}
+
+// isAlias reports whether code is an alias in the namespace of orgID and
+// domainID. The unique index on links.code does not cover aliases, so the
+// inserts of links check them first.
+func (s *PostgresStore) isAlias(ctx context.Context, orgID int, domainID int, code string) (bool, error) {
+	var exists bool
+	err := s.db.GetContext(ctx, &exists, `SELECT EXISTS(SELECT 1 FROM link_aliases WHERE org_id = $1 AND domain_id = $2 AND code = $3)`, orgID, domainID, code)
+
+	return exists, err
+}
+
+func (s *PostgresStore) ReserveCodeSequence(ctx context.Context, count int64) (int64, error) {
+	var value int64
+	err := s.db.GetContext(ctx, &value, `UPDATE code_sequence SET value = value + $1 RETURNING value`, count)
+
+	return value, err
+}
+
+func (s *PostgresStore) ConsumeClick(ctx context.Context, linkID int) (bool, error) {
+	query := `UPDATE links SET consumed_clicks = consumed_clicks + 1 WHERE id = $1 AND consumed_clicks < max_clicks`
+
+	result, err := s.db.ExecContext(ctx, query, linkID)
+	if err != nil {
+		return false, err
+	}
+
+	consumed, err := result.RowsAffected()
+	return consumed > 0, err
+}
+
+func (s *PostgresStore) ListLinks(ctx context.Context, filter LinkFilter) ([]Link, int, error) {
+	if !linkSortFields[filter.Sort] {
+		return nil, 0, fmt.Errorf("cannot sort links by %q", filter.Sort)
+	}
+
+	where, args := postgresLinkConditions(filter)
+
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM links WHERE `+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	order := "ASC"
+	if filter.Descending {
+		order = "DESC"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM links
+		WHERE %s
+		ORDER BY %s %s, id %s
+		LIMIT $%d OFFSET $%d
+	`, linkColumns, where, filter.Sort, order, order, len(args)+1, len(args)+2)
+
+	links := []Link{}
+	err = s.db.SelectContext(ctx, &links, query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+func (s *PostgresStore) SearchLinks(ctx context.Context, filter LinkFilter, terms []string) ([]Link, int, error) {
+	where, args := postgresLinkConditions(filter)
+	bind := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	where += " AND " + linkSearchConditions(terms, "ILIKE", bind)
+
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM links WHERE `+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rank := linkSearchRank(terms, "ILIKE", bind)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM links
+		WHERE %s
+		ORDER BY %s DESC, click_count DESC, id DESC
+		LIMIT $%d OFFSET $%d
+	`, linkColumns, where, rank, len(args)+1, len(args)+2)
+
+	links := []Link{}
+	err = s.db.SelectContext(ctx, &links, query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+// ExportLinks pages through the links by id so that no connection is held
+// while fn writes to a slow client.
+func (s *PostgresStore) ExportLinks(ctx context.Context, filter LinkFilter, fn func(link Link) error) error {
+	where, args := postgresLinkConditions(filter)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM links
+		WHERE %s AND id > $%d
+		ORDER BY id
+		LIMIT $%d
+	`, linkColumns, where, len(args)+1, len(args)+2)
+
+	lastID := 0
+	for {
+		var page []Link
+		err := s.db.SelectContext(ctx, &page, query, append(args, lastID, exportPageSize)...)
+		if err != nil {
+			return err
+		}
+
+		for _, link := range page {
+			if err := fn(link); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < exportPageSize {
+			return nil
+		}
+		lastID = page[len(page)-1].ID
+	}
+}
+
+func postgresLinkConditions(filter LinkFilter) (string, []interface{}) {
+	conditions := []string{"org_id = $1", "deleted_at IS NULL", "archived_at IS NULL"}
+	args := []interface{}{filter.OrgID}
+	if filter.Deleted {
+		conditions[1] = "deleted_at IS NOT NULL"
+	}
+	if filter.Archived {
+		conditions[2] = "archived_at IS NOT NULL"
+	}
+
+	if filter.CreatedFrom != nil {
+		args = append(args, *filter.CreatedFrom)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+
+	if filter.CreatedTo != nil {
+		args = append(args, *filter.CreatedTo)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	if filter.MinClicks > 0 {
+		args = append(args, filter.MinClicks)
+		conditions = append(conditions, fmt.Sprintf("click_count >= $%d", len(args)))
+	}
+
+	if filter.Broken {
+		conditions = append(conditions, "broken_since IS NOT NULL")
+	}
+
+	if filter.Tag != "" {
+		args = append(args, filter.Tag)
+		conditions = append(conditions, fmt.Sprintf("id IN (SELECT link_id FROM link_tags WHERE tag = $%d)", len(args)))
+	}
+
+	if filter.FolderIDs != nil {
+		args = append(args, pq.Array(filter.FolderIDs))
+		conditions = append(conditions, fmt.Sprintf("folder_id = ANY($%d)", len(args)))
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+func (s *PostgresStore) UpdateLink(ctx context.Context, orgID int, domainID int, code string, update func(link *Link) error) (Link, error) {
+	var link Link
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return link, err
+	}
+	defer tx.Rollback()
+
+	err = tx.GetContext(ctx, &link, `SELECT `+linkColumns+` FROM links WHERE org_id = $1 AND domain_id = $2 AND code = $3 FOR UPDATE`, orgID, domainID, code)
+	if err == sql.ErrNoRows {
+		return link, ErrNotFound
+	}
+	if err != nil {
+		return link, err
+	}
+
+	before := link
+	url := link.URL
+	if err = update(&link); err != nil {
+		return link, err
+	}
+	if link.URL != url {
+		link.LinkHealth = LinkHealth{}
+	}
+
+	updatedAt := time.Now()
+	link.UpdatedAt = &updatedAt
+
+	query := `
+		UPDATE links SET url = $1, expires_at = $2, redirect_status = $3, tracking_disabled = $4, forward_query = $5,
+			utm_source = $6, utm_medium = $7, utm_campaign = $8, destinations = $9, sticky_destinations = $10,
+			geo_targets = $11, device_targets = $12, routing_rules = $13, active_from = $14, active_until = $15,
+			fallback_url = $16, folder_id = $17, description = $18, notes = $19, checked_at = $20, check_status = $21,
+			check_failures = $22, broken_since = $23, deleted_at = $24, archived_at = $25, updated_at = $26,
+			url_key = $27
+		WHERE id = $28`
+	_, err = tx.ExecContext(ctx, query, link.URL, link.ExpiresAt, link.RedirectStatus, link.TrackingDisabled, link.ForwardQuery, link.UTMSource, link.UTMMedium, link.UTMCampaign, link.Destinations, link.StickyDestinations, link.GeoTargets, link.DeviceTargets, link.RoutingRules, link.ActiveFrom, link.ActiveUntil, link.FallbackURL, link.FolderID, link.Description, link.Notes, link.CheckedAt, link.CheckStatus, link.CheckFailures, link.BrokenSince, link.DeletedAt, link.ArchivedAt, link.UpdatedAt, canonicalURL(link.URL), link.ID)
+	if isUniqueViolationOf(err, urlIndexName) {
+		return link, ErrURLTaken
+	}
+	if err != nil {
+		return link, err
+	}
+
+	if err = s.recordLinkEvent(ctx, tx, before, link); err != nil {
+		return link, err
+	}
+
+	return link, tx.Commit()
+}
+
+func (s *PostgresStore) DeleteLink(ctx context.Context, orgID int, domainID int, code string, deleteClicks bool) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var link Link
+	err = tx.GetContext(ctx, &link, `SELECT `+linkColumns+` FROM links WHERE org_id = $1 AND domain_id = $2 AND code = $3 FOR UPDATE`, orgID, domainID, code)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if link.DeletedAt != nil {
+		return ErrLinkDeleted
+	}
+
+	deleted := link
+	deletedAt := time.Now()
+	deleted.DeletedAt = &deletedAt
+
+	_, err = tx.ExecContext(ctx, `UPDATE links SET deleted_at = $1 WHERE id = $2`, deleted.DeletedAt, link.ID)
+	if err != nil {
+		return err
+	}
+
+	if err = s.recordLinkEvent(ctx, tx, link, deleted); err != nil {
+		return err
+	}
+
+	if deleteClicks {
+		for _, table := range clickTables {
+			_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE link_id = $1`, link.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) SetLinkTitle(ctx context.Context, linkID int, url string, title *string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE links SET title = $1 WHERE id = $2 AND url = $3`, title, linkID, url)
+	return err
+}
+
+func (s *PostgresStore) PurgeExpiredLinks(ctx context.Context) ([]Link, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, table := range linkTables {
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE link_id IN (SELECT id FROM links WHERE expires_at <= NOW() AND fallback_url IS NULL)`)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var purged []Link
+	err = tx.SelectContext(ctx, &purged, `DELETE FROM links WHERE expires_at <= NOW() AND fallback_url IS NULL RETURNING `+linkColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	return purged, tx.Commit()
+}
+
+// ClaimLinkChecks skips rows locked by another instance's claim, like
+// ClaimDeliveries.
+func (s *PostgresStore) ClaimLinkChecks(ctx context.Context, before time.Time, limit int) ([]Link, error) {
+	query := `
+		UPDATE links SET checked_at = NOW()
+		WHERE id IN (
+			SELECT id FROM links
+			WHERE deleted_at IS NULL AND archived_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+				AND (checked_at IS NULL OR checked_at < $1)
+			ORDER BY checked_at NULLS FIRST
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + linkColumns
+
+	links := []Link{}
+	err := s.db.SelectContext(ctx, &links, query, before, limit)
+
+	return links, err
+}
+
+func (s *PostgresStore) SetLinkHealth(ctx context.Context, link Link) error {
+	query := `
+		UPDATE links SET checked_at = $1, check_status = $2, check_failures = $3, broken_since = $4
+		WHERE id = $5 AND url = $6`
+
+	result, err := s.db.ExecContext(ctx, query, link.CheckedAt, link.CheckStatus, link.CheckFailures, link.BrokenSince, link.ID, link.URL)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) QuarantineLink(ctx context.Context, linkID int, url string, reason string) error {
+	applies := func(link Link) bool { return link.URL == url && !link.quarantined() }
+	_, err := s.moderateLink(ctx, linkID, applies, `quarantined_at = NOW(), quarantine_reason = $1`, reason)
+
+	return err
+}
+
+func (s *PostgresStore) ListQuarantinedLinks(ctx context.Context, confirmed bool, limit int, offset int) ([]Link, int, error) {
+	where := `quarantined_at IS NOT NULL AND quarantine_reviewed_at IS NULL AND deleted_at IS NULL`
+	if confirmed {
+		where = `quarantined_at IS NOT NULL AND quarantine_reviewed_at IS NOT NULL AND deleted_at IS NULL`
+	}
+
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM links WHERE `+where)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	links := []Link{}
+	query := `SELECT ` + linkColumns + ` FROM links WHERE ` + where + ` ORDER BY quarantined_at, id LIMIT $1 OFFSET $2`
+	err = s.db.SelectContext(ctx, &links, query, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+func (s *PostgresStore) ReleaseLink(ctx context.Context, linkID int) (Link, error) {
+	return s.moderateLink(ctx, linkID, Link.quarantined, `quarantined_at = NULL, quarantine_reason = NULL, quarantine_reviewed_at = NULL`)
+}
+
+func (s *PostgresStore) ConfirmQuarantine(ctx context.Context, linkID int) (Link, error) {
+	return s.moderateLink(ctx, linkID, Link.quarantined, `quarantine_reviewed_at = NOW()`)
+}
+
+// moderateLink applies assignments, whose placeholders are args, to the
+// link of linkID when applies to it, and records the change in the
+// history of the link. It fails with ErrNotFound otherwise.
+func (s *PostgresStore) moderateLink(ctx context.Context, linkID int, applies func(link Link) bool, assignments string, args ...interface{}) (Link, error) {
+	var link Link
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return link, err
+	}
+	defer tx.Rollback()
+
+	var before Link
+	err = tx.GetContext(ctx, &before, `SELECT `+linkColumns+` FROM links WHERE id = $1 FOR UPDATE`, linkID)
+	if err == sql.ErrNoRows || (err == nil && !applies(before)) {
+		return link, ErrNotFound
+	}
+	if err != nil {
+		return link, err
+	}
+
+	query := fmt.Sprintf(`UPDATE links SET %s WHERE id = $%d RETURNING %s`, assignments, len(args)+1, linkColumns)
+	if err = tx.GetContext(ctx, &link, query, append(args, linkID)...); err != nil {
+		return link, err
+	}
+
+	if err = s.recordLinkEvent(ctx, tx, before, link); err != nil {
+		return link, err
+	}
+
+	return link, tx.Commit()
+}
+
+func (s *PostgresStore) CreateLinkReport(ctx context.Context, report *LinkReport) (bool, error) {
+	query := `
+		INSERT INTO link_reports (link_id, reason, details, email, reporter_hash)
+		SELECT $1, $2, $3, $4, $5
+		WHERE EXISTS (SELECT 1 FROM links WHERE id = $1 AND reports_whitelisted_at IS NULL)
+		AND NOT EXISTS (SELECT 1 FROM link_reports WHERE link_id = $1 AND reporter_hash = $5 AND resolved_at IS NULL)
+		RETURNING id, created_at`
+
+	err := s.db.QueryRowxContext(ctx, query, report.LinkID, report.Reason, report.Details, report.Email, report.ReporterHash).Scan(&report.ID, &report.CreatedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *PostgresStore) ListReportedLinks(ctx context.Context, limit int, offset int) ([]ReportedLink, int, error) {
+	var total int
+	err := s.db.GetContext(ctx, &total, `
+		SELECT COUNT(DISTINCT r.link_id) FROM link_reports r
+		JOIN links l ON l.id = r.link_id
+		WHERE r.resolved_at IS NULL AND l.deleted_at IS NULL`)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	links := []ReportedLink{}
+	query := `
+		SELECT ` + linkColumns + `, r.open_reports FROM links
+		JOIN (
+			SELECT link_id, COUNT(*) AS open_reports, MAX(id) AS last_report_id
+			FROM link_reports WHERE resolved_at IS NULL GROUP BY link_id
+		) r ON r.link_id = links.id
+		WHERE deleted_at IS NULL
+		ORDER BY r.open_reports DESC, r.last_report_id DESC
+		LIMIT $1 OFFSET $2`
+	err = s.db.SelectContext(ctx, &links, query, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+func (s *PostgresStore) LinkReports(ctx context.Context, linkID int) ([]LinkReport, error) {
+	reports := []LinkReport{}
+	err := s.db.SelectContext(ctx, &reports, `SELECT `+linkReportColumns+` FROM link_reports WHERE link_id = $1 ORDER BY id DESC`, linkID)
+
+	return reports, err
+}
+
+func (s *PostgresStore) ResolveLinkReports(ctx context.Context, linkID int, resolution string) (Link, error) {
+	var link Link
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return link, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE link_reports SET resolved_at = NOW(), resolution = $1 WHERE link_id = $2 AND resolved_at IS NULL`, resolution, linkID)
+	if err != nil {
+		return link, err
+	}
+	resolved, err := result.RowsAffected()
+	if err != nil {
+		return link, err
+	}
+	if resolved == 0 {
+		return link, ErrNotFound
+	}
+
+	var before Link
+	if err = tx.GetContext(ctx, &before, `SELECT `+linkColumns+` FROM links WHERE id = $1 FOR UPDATE`, linkID); err != nil {
+		return link, err
+	}
+
+	switch resolution {
+	case reportDisabled:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE links SET quarantined_at = COALESCE(quarantined_at, NOW()), quarantine_reason = COALESCE(quarantine_reason, $1), quarantine_reviewed_at = NOW()
+			WHERE id = $2`, reportedQuarantineReason, linkID)
+	case reportWhitelisted:
+		_, err = tx.ExecContext(ctx, `UPDATE links SET reports_whitelisted_at = NOW() WHERE id = $1`, linkID)
+	}
+	if err != nil {
+		return link, err
+	}
+
+	if err = tx.GetContext(ctx, &link, `SELECT `+linkColumns+` FROM links WHERE id = $1`, linkID); err != nil {
+		return link, err
+	}
+
+	if err = s.recordLinkEvent(ctx, tx, before, link); err != nil {
+		return link, err
+	}
+
+	return link, tx.Commit()
+}
+
+func (s *PostgresStore) ListAllLinks(ctx context.Context, filter AdminLinkFilter) ([]Link, int, error) {
+	var args []interface{}
+	bind := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	where := adminLinkConditions(filter, "ILIKE", bind)
+
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM links WHERE `+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM links
+		WHERE %s
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d OFFSET $%d
+	`, linkColumns, where, len(args)+1, len(args)+2)
+
+	links := []Link{}
+	err = s.db.SelectContext(ctx, &links, query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+func (s *PostgresStore) DisableLink(ctx context.Context, linkID int, reason string) (Link, error) {
+	applies := func(link Link) bool { return link.DeletedAt == nil }
+	return s.moderateLink(ctx, linkID, applies, `quarantined_at = COALESCE(quarantined_at, NOW()), quarantine_reason = $1, quarantine_reviewed_at = NOW()`, reason)
+}
+
+func (s *PostgresStore) KeyActivity(ctx context.Context, from time.Time, to time.Time, limit int) ([]KeyActivity, error) {
+	query := `
+		SELECT k.id AS key_id, k.org_id, o.slug AS org_slug, k.name, k.prefix, k.revoked_at,
+			COUNT(*) AS links, COALESCE(SUM(l.click_count), 0) AS clicks
+		FROM links l
+		JOIN api_keys k ON k.id = l.key_id
+		JOIN organizations o ON o.id = k.org_id
+		WHERE l.created_at >= $1 AND l.created_at < $2
+		GROUP BY k.id, k.org_id, o.slug, k.name, k.prefix, k.revoked_at
+		ORDER BY links DESC, k.id
+		LIMIT $3`
+
+	keys := []KeyActivity{}
+	err := s.db.SelectContext(ctx, &keys, query, from, to, limit)
+
+	return keys, err
+}
+
+func (s *PostgresStore) SetLinkTags(ctx context.Context, linkID int, tags []string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM link_tags WHERE link_id = $1`, linkID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO link_tags (link_id, tag) SELECT $1, unnest($2::text[])`, linkID, pq.Array(tags))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) LinkTags(ctx context.Context, linkIDs []int) (map[int][]string, error) {
+	if len(linkIDs) == 0 {
+		return map[int][]string{}, nil
+	}
+
+	var rows []linkTag
+	err := s.db.SelectContext(ctx, &rows, `SELECT link_id, tag FROM link_tags WHERE link_id = ANY($1) ORDER BY link_id, tag`, pq.Array(linkIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	return groupLinkTags(rows), nil
+}
+
+func (s *PostgresStore) ListTags(ctx context.Context, orgID int) ([]TagCount, error) {
+	query := `
+		SELECT t.tag, COUNT(*) AS links, COALESCE(SUM(l.click_count), 0) AS clicks
+		FROM link_tags t
+		JOIN links l ON l.id = t.link_id
+		WHERE l.org_id = $1 AND l.deleted_at IS NULL
+		GROUP BY t.tag
+		ORDER BY links DESC, t.tag
+	`
+
+	tags := []TagCount{}
+	err := s.db.SelectContext(ctx, &tags, query, orgID)
+
+	return tags, err
+}
+
+func (s *PostgresStore) AddLinkAlias(ctx context.Context, alias *LinkAlias) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.GetContext(ctx, &exists, `SELECT EXISTS(SELECT 1 FROM links WHERE org_id = $1 AND domain_id = $2 AND code = $3)`, alias.OrgID, alias.DomainID, alias.Code)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrCodeTaken
+	}
+
+	query := `
+		INSERT INTO link_aliases (org_id, domain_id, link_id, code, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, org_id, domain_id, link_id, code, created_at
+	`
+	err = tx.GetContext(ctx, alias, query, alias.OrgID, alias.DomainID, alias.LinkID, alias.Code, time.Now())
+	if isUniqueViolation(err) {
+		return ErrCodeTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = s.recordAliasEvent(ctx, tx, alias.LinkID, alias.Code, false); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) LinkAliases(ctx context.Context, linkID int) ([]LinkAlias, error) {
+	aliases := []LinkAlias{}
+	err := s.db.SelectContext(ctx, &aliases, `SELECT id, org_id, domain_id, link_id, code, created_at FROM link_aliases WHERE link_id = $1 ORDER BY id`, linkID)
+
+	return aliases, err
+}
+
+func (s *PostgresStore) DeleteLinkAlias(ctx context.Context, linkID int, code string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM link_aliases WHERE link_id = $1 AND code = $2`, linkID, code)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+
+	if err = s.recordAliasEvent(ctx, tx, linkID, code, true); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) CreateFolder(ctx context.Context, folder *Folder) error {
+	query := `
+		INSERT INTO folders (org_id, parent_id, name, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + folderColumns
+
+	return s.db.GetContext(ctx, folder, query, folder.OrgID, folder.ParentID, folder.Name, time.Now())
+}
+
+func (s *PostgresStore) GetFolder(ctx context.Context, orgID int, id int) (Folder, error) {
+	var folder Folder
+	err := s.db.GetContext(ctx, &folder, `SELECT `+folderColumns+` FROM folders WHERE org_id = $1 AND id = $2`, orgID, id)
+	if err == sql.ErrNoRows {
+		return folder, ErrFolderNotFound
+	}
+
+	return folder, err
+}
+
+func (s *PostgresStore) ListFolders(ctx context.Context, orgID int) ([]Folder, error) {
+	query := `
+		SELECT ` + folderColumns + `,
+			(SELECT COUNT(*) FROM links WHERE folder_id = folders.id AND deleted_at IS NULL) AS link_count
+		FROM folders
+		WHERE org_id = $1
+		ORDER BY name, id
+	`
+
+	folders := []Folder{}
+	err := s.db.SelectContext(ctx, &folders, query, orgID)
+
+	return folders, err
+}
+
+func (s *PostgresStore) UpdateFolder(ctx context.Context, folder *Folder) error {
+	query := `
+		UPDATE folders SET parent_id = $1, name = $2, updated_at = $3
+		WHERE org_id = $4 AND id = $5
+		RETURNING ` + folderColumns
+
+	err := s.db.GetContext(ctx, folder, query, folder.ParentID, folder.Name, time.Now(), folder.OrgID, folder.ID)
+	if err == sql.ErrNoRows {
+		return ErrFolderNotFound
+	}
+
+	return err
+}
+
+func (s *PostgresStore) DeleteFolder(ctx context.Context, orgID int, id int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var folder Folder
+	err = tx.GetContext(ctx, &folder, `SELECT `+folderColumns+` FROM folders WHERE org_id = $1 AND id = $2 FOR UPDATE`, orgID, id)
+	if err == sql.ErrNoRows {
+		return ErrFolderNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var contents int
+	query := `
+		SELECT (SELECT COUNT(*) FROM links WHERE folder_id = $1 AND deleted_at IS NULL)
+			+ (SELECT COUNT(*) FROM folders WHERE parent_id = $1)
+	`
+	if err = tx.GetContext(ctx, &contents, query, id); err != nil {
+		return err
+	}
+	if contents > 0 {
+		return ErrFolderNotEmpty
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE links SET folder_id = NULL WHERE folder_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM folders WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) MoveLinks(ctx context.Context, orgID int, domainID int, codes []string, folderID *int) (int64, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var links []Link
+	err = tx.SelectContext(ctx, &links, `
+		SELECT `+linkColumns+` FROM links
+		WHERE org_id = $1 AND domain_id = $2 AND code = ANY($3) AND deleted_at IS NULL
+		FOR UPDATE
+	`, orgID, domainID, pq.Array(codes))
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+		UPDATE links SET folder_id = $1, updated_at = $2
+		WHERE org_id = $3 AND domain_id = $4 AND code = ANY($5) AND deleted_at IS NULL
+	`
+
+	result, err := tx.ExecContext(ctx, query, folderID, time.Now(), orgID, domainID, pq.Array(codes))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, link := range links {
+		moved := link
+		moved.FolderID = folderID
+		if err = s.recordLinkEvent(ctx, tx, link, moved); err != nil {
+			return 0, err
+		}
+	}
+
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return moved, tx.Commit()
+}
+
+// recordLinkEvent records the change of a link from before to after
+// within tx, unless no audited field changed.
+func (s *PostgresStore) recordLinkEvent(ctx context.Context, tx *sqlx.Tx, before Link, after Link) error {
+	event, changed, err := newLinkEvent(ctx, before, after)
+	if err != nil || !changed {
+		return err
+	}
+
+	return s.insertLinkEvent(ctx, tx, event)
+}
+
+// recordAliasEvent records the addition of alias to the link of linkID,
+// or its removal, within tx.
+func (s *PostgresStore) recordAliasEvent(ctx context.Context, tx *sqlx.Tx, linkID int, alias string, removed bool) error {
+	var link Link
+	if err := tx.GetContext(ctx, &link, `SELECT `+linkColumns+` FROM links WHERE id = $1`, linkID); err != nil {
+		return err
+	}
+
+	event, err := newAliasEvent(ctx, link, alias, removed)
+	if err != nil {
+		return err
+	}
+
+	return s.insertLinkEvent(ctx, tx, event)
+}
+
+func (s *PostgresStore) insertLinkEvent(ctx context.Context, tx *sqlx.Tx, event LinkEvent) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO link_events (org_id, link_id, code, action, member_id, key_id, admin, changes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, event.OrgID, event.LinkID, event.Code, event.Action, event.MemberID, event.KeyID, event.Admin, event.Changes, event.CreatedAt)
+	return err
+}
+
+func (s *PostgresStore) LinkHistory(ctx context.Context, linkID int, limit int, offset int) ([]LinkEvent, int, error) {
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM link_events WHERE link_id = $1`, linkID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	events := []LinkEvent{}
+	err = s.db.SelectContext(ctx, &events, `
+		SELECT `+linkEventColumns+` FROM link_events
+		WHERE link_id = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`, linkID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+func (s *PostgresStore) ListLinkEvents(ctx context.Context, filter LinkEventFilter) ([]LinkEvent, int, error) {
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	if filter.OrgID != nil {
+		args = append(args, *filter.OrgID)
+		conditions = append(conditions, fmt.Sprintf("org_id = $%d", len(args)))
+	}
+	if filter.Action != "" {
+		args = append(args, filter.Action)
+		conditions = append(conditions, fmt.Sprintf("action = $%d", len(args)))
+	}
+	if filter.From != nil {
+		args = append(args, *filter.From)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if filter.To != nil {
+		args = append(args, *filter.To)
+		conditions = append(conditions, fmt.Sprintf("created_at <= $%d", len(args)))
+	}
+	where := strings.Join(conditions, " AND ")
+
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM link_events WHERE `+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s FROM link_events
+		WHERE %s
+		ORDER BY id DESC
+		LIMIT $%d OFFSET $%d
+	`, linkEventColumns, where, len(args)+1, len(args)+2)
+
+	events := []LinkEvent{}
+	err = s.db.SelectContext(ctx, &events, query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+func (s *PostgresStore) AddClicks(ctx context.Context, batch ClickBatch) error {
+	linkTotals := make(map[int]int64)
+	var linkIDs, clicks []int64
+	var dates []string
+	for _, count := range batch.Daily {
+		linkTotals[count.LinkID] += count.Clicks
+		linkIDs = append(linkIDs, int64(count.LinkID))
+		clicks = append(clicks, count.Clicks)
+		dates = append(dates, count.Date)
+	}
+
+	var totalIDs, totalClicks []int64
+	for linkID, total := range linkTotals {
+		totalIDs = append(totalIDs, int64(linkID))
+		totalClicks = append(totalClicks, total)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.StmtxContext(ctx, s.clickCountStmt).ExecContext(ctx, pq.Array(totalIDs), pq.Array(totalClicks))
+	if err != nil {
+		return fmt.Errorf("updating click count: %w", err)
+	}
+
+	_, err = tx.StmtxContext(ctx, s.dailyClicksStmt).ExecContext(ctx, pq.Array(linkIDs), pq.Array(clicks), pq.Array(dates))
+	if err != nil {
+		return fmt.Errorf("inserting/updating click count: %w", err)
+	}
+
+	if len(batch.Bots) > 0 {
+		var botIDs, botClicks []int64
+		for _, count := range batch.Bots {
+			botIDs = append(botIDs, int64(count.LinkID))
+			botClicks = append(botClicks, count.Clicks)
+		}
+
+		botClicksQuery := `
+			UPDATE links SET bot_clicks = links.bot_clicks + batch.clicks
+			FROM (SELECT unnest($1::bigint[]) AS id, unnest($2::bigint[]) AS clicks) AS batch
+			WHERE links.id = batch.id
+		`
+		_, err = tx.ExecContext(ctx, botClicksQuery, pq.Array(botIDs), pq.Array(botClicks))
+		if err != nil {
+			return fmt.Errorf("updating bot click count: %w", err)
+		}
+	}
+
+	if len(batch.Referrers) > 0 {
+		var referrerIDs, referrerClicks []int64
+		var referrers []string
+		for _, count := range batch.Referrers {
+			referrerIDs = append(referrerIDs, int64(count.LinkID))
+			referrers = append(referrers, count.Referrer)
+			referrerClicks = append(referrerClicks, count.Clicks)
+		}
+
+		referrersQuery := `
+			INSERT INTO link_referrers (link_id, referrer, clicks)
+			SELECT unnest($1::bigint[]), unnest($2::text[]), unnest($3::bigint[])
+			ON CONFLICT (link_id, referrer)
+			DO UPDATE SET clicks = link_referrers.clicks + EXCLUDED.clicks
+		`
+		_, err = tx.ExecContext(ctx, referrersQuery, pq.Array(referrerIDs), pq.Array(referrers), pq.Array(referrerClicks))
+		if err != nil {
+			return fmt.Errorf("inserting/updating referrer count: %w", err)
+		}
+	}
+
+	if len(batch.Countries) > 0 {
+		var countryIDs, countryClicks []int64
+		var countries []string
+		for _, count := range batch.Countries {
+			countryIDs = append(countryIDs, int64(count.LinkID))
+			countries = append(countries, count.Country)
+			countryClicks = append(countryClicks, count.Clicks)
+		}
+
+		countriesQuery := `
+			INSERT INTO link_countries (link_id, country, clicks)
+			SELECT unnest($1::bigint[]), unnest($2::text[]), unnest($3::bigint[])
+			ON CONFLICT (link_id, country)
+			DO UPDATE SET clicks = link_countries.clicks + EXCLUDED.clicks
+		`
+		_, err = tx.ExecContext(ctx, countriesQuery, pq.Array(countryIDs), pq.Array(countries), pq.Array(countryClicks))
+		if err != nil {
+			return fmt.Errorf("inserting/updating country count: %w", err)
+		}
+	}
+
+	if len(batch.Destinations) > 0 {
+		var destinationLinkIDs, destinationIDs, destinationClicks []int64
+		for _, count := range batch.Destinations {
+			destinationLinkIDs = append(destinationLinkIDs, int64(count.LinkID))
+			destinationIDs = append(destinationIDs, int64(count.DestinationID))
+			destinationClicks = append(destinationClicks, count.Clicks)
+		}
+
+		destinationsQuery := `
+			INSERT INTO link_destination_clicks (link_id, destination_id, clicks)
+			SELECT unnest($1::bigint[]), unnest($2::bigint[]), unnest($3::bigint[])
+			ON CONFLICT (link_id, destination_id)
+			DO UPDATE SET clicks = link_destination_clicks.clicks + EXCLUDED.clicks
+		`
+		_, err = tx.ExecContext(ctx, destinationsQuery, pq.Array(destinationLinkIDs), pq.Array(destinationIDs), pq.Array(destinationClicks))
+		if err != nil {
+			return fmt.Errorf("inserting/updating destination count: %w", err)
+		}
+	}
+
+	if len(batch.Codes) > 0 {
+		var codeLinkIDs, codeClicks []int64
+		var codes []string
+		for _, count := range batch.Codes {
+			codeLinkIDs = append(codeLinkIDs, int64(count.LinkID))
+			codes = append(codes, count.Code)
+			codeClicks = append(codeClicks, count.Clicks)
+		}
+
+		codesQuery := `
+			INSERT INTO link_code_clicks (link_id, code, clicks)
+			SELECT unnest($1::bigint[]), unnest($2::text[]), unnest($3::bigint[])
+			ON CONFLICT (link_id, code)
+			DO UPDATE SET clicks = link_code_clicks.clicks + EXCLUDED.clicks
+		`
+		_, err = tx.ExecContext(ctx, codesQuery, pq.Array(codeLinkIDs), pq.Array(codes), pq.Array(codeClicks))
+		if err != nil {
+			return fmt.Errorf("inserting/updating code count: %w", err)
+		}
+	}
+
+	if len(batch.Devices) > 0 {
+		var deviceIDs, deviceClicks []int64
+		var deviceTypes, browsers, operatingSystems []string
+		for _, count := range batch.Devices {
+			deviceIDs = append(deviceIDs, int64(count.LinkID))
+			deviceTypes = append(deviceTypes, count.DeviceType)
+			browsers = append(browsers, count.Browser)
+			operatingSystems = append(operatingSystems, count.OS)
+			deviceClicks = append(deviceClicks, count.Clicks)
+		}
+
+		devicesQuery := `
+			INSERT INTO link_devices (link_id, device_type, browser, os, clicks)
+			SELECT unnest($1::bigint[]), unnest($2::text[]), unnest($3::text[]), unnest($4::text[]), unnest($5::bigint[])
+			ON CONFLICT (link_id, device_type, browser, os)
+			DO UPDATE SET clicks = link_devices.clicks + EXCLUDED.clicks
+		`
+		_, err = tx.ExecContext(ctx, devicesQuery, pq.Array(deviceIDs), pq.Array(deviceTypes), pq.Array(browsers), pq.Array(operatingSystems), pq.Array(deviceClicks))
+		if err != nil {
+			return fmt.Errorf("inserting/updating device count: %w", err)
+		}
+	}
+
+	if len(batch.Events) > 0 {
+		var eventIDs []int64
+		var clickedAt, eventCountries, referrerHashes, ipHashes, eventDevices []string
+		for _, event := range batch.Events {
+			eventIDs = append(eventIDs, int64(event.LinkID))
+			clickedAt = append(clickedAt, event.ClickedAt.Format(time.RFC3339Nano))
+			eventCountries = append(eventCountries, event.Country)
+			referrerHash := ""
+			if event.ReferrerHash != nil {
+				referrerHash = *event.ReferrerHash
+			}
+			referrerHashes = append(referrerHashes, referrerHash)
+			ipHash := ""
+			if event.IPHash != nil {
+				ipHash = *event.IPHash
+			}
+			ipHashes = append(ipHashes, ipHash)
+			eventDevices = append(eventDevices, event.DeviceType)
+		}
+
+		eventsQuery := `
+			INSERT INTO click_events (link_id, clicked_at, country, referrer_hash, ip_hash, device_type)
+			SELECT link_id, clicked_at, country, NULLIF(referrer_hash, ''), NULLIF(ip_hash, ''), device_type
+			FROM unnest($1::bigint[], $2::timestamptz[], $3::text[], $4::text[], $5::text[], $6::text[])
+				AS e (link_id, clicked_at, country, referrer_hash, ip_hash, device_type)
+		`
+		_, err = tx.ExecContext(ctx, eventsQuery, pq.Array(eventIDs), pq.Array(clickedAt), pq.Array(eventCountries), pq.Array(referrerHashes), pq.Array(ipHashes), pq.Array(eventDevices))
+		if err != nil {
+			return fmt.Errorf("inserting click events: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) ClickEvents(ctx context.Context, linkID int, filter ClickEventFilter) ([]ClickEvent, error) {
+	conditions := []string{"link_id = $1"}
+	args := []interface{}{linkID}
+
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("clicked_at >= $%d", len(args)))
+	}
+
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("clicked_at < $%d", len(args)))
+	}
+
+	if filter.Before != 0 {
+		args = append(args, filter.Before)
+		conditions = append(conditions, fmt.Sprintf("id < $%d", len(args)))
+	}
+
+	args = append(args, filter.Limit)
+	query := fmt.Sprintf(`
+		SELECT `+clickEventColumns+`
+		FROM click_events
+		WHERE %s
+		ORDER BY id DESC
+		LIMIT $%d
+	`, strings.Join(conditions, " AND "), len(args))
+
+	events := []ClickEvent{}
+	err := s.db.SelectContext(ctx, &events, query, args...)
+
+	return events, err
+}
+
+func (s *PostgresStore) PurgeClickEvents(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM click_events WHERE clicked_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *PostgresStore) EraseLinkClicks(ctx context.Context, linkID int) (int64, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var events int64
+	err = tx.GetContext(ctx, &events, `SELECT COUNT(*) FROM click_events WHERE link_id = $1`, linkID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, table := range clickTables {
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE link_id = $1`, linkID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE links SET click_count = 0, bot_clicks = 0 WHERE id = $1`, linkID)
+	if err != nil {
+		return 0, err
+	}
+
+	return events, tx.Commit()
+}
+
+func (s *PostgresStore) EraseVisitorClicks(ctx context.Context, ipHash string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM click_events WHERE ip_hash = $1`, ipHash)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+var postgresClickBuckets = map[string]string{
+	"day":   `to_char(date, 'YYYY-MM-DD')`,
+	"week":  `to_char(date_trunc('week', date), 'YYYY-MM-DD')`,
+	"month": `to_char(date_trunc('month', date), 'YYYY-MM-DD')`,
+}
+
+func (s *PostgresStore) ClickTimeSeries(ctx context.Context, linkID int, filter ClickSeriesFilter) ([]ClickBucket, error) {
+	bucket, ok := postgresClickBuckets[filter.Granularity]
+	if !ok {
+		return nil, fmt.Errorf("cannot aggregate clicks by %q", filter.Granularity)
+	}
+
+	conditions := []string{"link_id = $1"}
+	args := []interface{}{linkID}
+
+	if filter.From != "" {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("date >= $%d", len(args)))
+	}
+
+	if filter.To != "" {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("date <= $%d", len(args)))
+	}
+
+	rollups := ""
+	if table, ok := clickRollupTables[filter.Granularity]; ok {
+		var rolledUpTo sql.NullString
+		err := s.db.GetContext(ctx, &rolledUpTo, `SELECT to_char(rolled_up_to, 'YYYY-MM-DD') FROM click_rollups WHERE granularity = $1`, filter.Granularity)
+		if err != nil {
+			return nil, err
+		}
+
+		// The days of the span are read from the rollups, the others are
+		// summed from clicks.
+		if from, to, ok := clickRollupSpan(filter, rolledUpTo.String); ok {
+			args = append(args, to)
+			rollupConditions := []string{"link_id = $1", fmt.Sprintf("date < $%d", len(args))}
+			outside := fmt.Sprintf("date >= $%d", len(args))
+
+			if from != "" {
+				args = append(args, from)
+				rollupConditions = append(rollupConditions, fmt.Sprintf("date >= $%d", len(args)))
+				outside = fmt.Sprintf("(%s OR date < $%d)", outside, len(args))
+			}
+
+			conditions = append(conditions, outside)
+			rollups = `
+		UNION ALL
+		SELECT to_char(date, 'YYYY-MM-DD') AS date, clicks
+		FROM ` + table + `
+		WHERE ` + strings.Join(rollupConditions, " AND ")
+		}
+	}
+
+	query := `
+		SELECT ` + bucket + ` AS date, SUM(clicks) AS clicks
+		FROM clicks
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		GROUP BY 1` + rollups + `
+		ORDER BY 1
+	`
+
+	series := []ClickBucket{}
+	err := s.db.SelectContext(ctx, &series, query, args...)
+
+	return series, err
+}
+
+func (s *PostgresStore) RollupClicks(ctx context.Context, granularity string, today time.Time) error {
+	table, ok := clickRollupTables[granularity]
+	if !ok {
+		return fmt.Errorf("cannot roll up clicks by %q", granularity)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the progress row keeps instances from rolling up at once.
+	var rolledUpTo sql.NullString
+	err = tx.GetContext(ctx, &rolledUpTo, `SELECT to_char(rolled_up_to, 'YYYY-MM-DD') FROM click_rollups WHERE granularity = $1 FOR UPDATE`, granularity)
+	if err != nil {
+		return err
+	}
+
+	from, to := clickRollupWindow(granularity, rolledUpTo.String, today)
+	window, args := "date < $1", []interface{}{to}
+	if from != "" {
+		window, args = "date < $1 AND date >= $2", append(args, from)
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE `+window, args...)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO ` + table + ` (link_id, date, clicks)
+		SELECT link_id, CAST(` + postgresClickBuckets[granularity] + ` AS DATE), SUM(clicks)
+		FROM clicks
+		WHERE ` + window + `
+		GROUP BY 1, 2
+	`
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE click_rollups SET rolled_up_to = $1 WHERE granularity = $2`, to, granularity)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateClickPartitions creates the clicks and click_events partitions of
+// a month, which are named after it, such as clicks_p202601. The bounds
+// are dates or UTC instants the server formats, as DDL takes no
+// parameters.
+func (s *PostgresStore) CreateClickPartitions(ctx context.Context, month time.Time) error {
+	from := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	suffix := from.Format("200601")
+
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS clicks_p` + suffix + ` PARTITION OF clicks
+			FOR VALUES FROM ('` + from.Format("2006-01-02") + `') TO ('` + to.Format("2006-01-02") + `')`,
+		`CREATE TABLE IF NOT EXISTS click_events_p` + suffix + ` PARTITION OF click_events
+			FOR VALUES FROM ('` + from.Format(time.RFC3339) + `') TO ('` + to.Format(time.RFC3339) + `')`,
+	}
+
+	for _, query := range queries {
+		if _, err := s.db.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) TopReferrers(ctx context.Context, linkID int, limit int) ([]ReferrerCount, error) {
+	query := `
+		SELECT link_id, referrer, clicks
+		FROM link_referrers
+		WHERE link_id = $1
+		ORDER BY clicks DESC, referrer
+		LIMIT $2
+	`
+
+	referrers := []ReferrerCount{}
+	err := s.db.SelectContext(ctx, &referrers, query, linkID, limit)
+
+	return referrers, err
+}
+
+func (s *PostgresStore) CountryClicks(ctx context.Context, linkID int) ([]CountryCount, error) {
+	query := `
+		SELECT link_id, country, clicks
+		FROM link_countries
+		WHERE link_id = $1
+		ORDER BY clicks DESC, country
+	`
+
+	countries := []CountryCount{}
+	err := s.db.SelectContext(ctx, &countries, query, linkID)
+
+	return countries, err
+}
+
+func (s *PostgresStore) DestinationClicks(ctx context.Context, linkID int) ([]DestinationCount, error) {
+	query := `
+		SELECT link_id, destination_id, clicks
+		FROM link_destination_clicks
+		WHERE link_id = $1
+		ORDER BY destination_id
+	`
+
+	destinations := []DestinationCount{}
+	err := s.db.SelectContext(ctx, &destinations, query, linkID)
+
+	return destinations, err
+}
+
+func (s *PostgresStore) CodeClicks(ctx context.Context, linkID int) ([]CodeCount, error) {
+	query := `
+		SELECT link_id, code, clicks
+		FROM link_code_clicks
+		WHERE link_id = $1
+		ORDER BY clicks DESC, code
+	`
+
+	codes := []CodeCount{}
+	err := s.db.SelectContext(ctx, &codes, query, linkID)
+
+	return codes, err
+}
+
+func (s *PostgresStore) DeviceClicks(ctx context.Context, linkID int) ([]DeviceCount, error) {
+	query := `
+		SELECT link_id, device_type, browser, os, clicks
+		FROM link_devices
+		WHERE link_id = $1
+		ORDER BY clicks DESC, device_type, browser, os
+	`
+
+	devices := []DeviceCount{}
+	err := s.db.SelectContext(ctx, &devices, query, linkID)
+
+	return devices, err
+}
+
+// ExportClicks pages through the daily clicks by link and date so that no
+// connection is held while fn writes to a slow client.
+func (s *PostgresStore) ExportClicks(ctx context.Context, filter ClickExportFilter, fn func(click ClickExport) error) error {
+	conditions := []string{"links.org_id = $1"}
+	args := []interface{}{filter.OrgID}
+
+	if filter.LinkID != 0 {
+		args = append(args, filter.LinkID)
+		conditions = append(conditions, fmt.Sprintf("clicks.link_id = $%d", len(args)))
+	} else {
+		conditions = append(conditions, "links.deleted_at IS NULL")
+	}
+
+	if filter.From != "" {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("clicks.date >= $%d", len(args)))
+	}
+
+	if filter.To != "" {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("clicks.date <= $%d", len(args)))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT clicks.link_id, links.code, to_char(clicks.date, 'YYYY-MM-DD') AS date, clicks.clicks
+		FROM clicks
+		JOIN links ON links.id = clicks.link_id
+		WHERE %s AND (clicks.link_id, clicks.date) > ($%d, $%d::date)
+		ORDER BY clicks.link_id, clicks.date
+		LIMIT $%d
+	`, strings.Join(conditions, " AND "), len(args)+1, len(args)+2, len(args)+3)
+
+	lastLinkID, lastDate := 0, exportStartDate
+	for {
+		var page []ClickExport
+		err := s.db.SelectContext(ctx, &page, query, append(args, lastLinkID, lastDate, exportPageSize)...)
+		if err != nil {
+			return err
+		}
+
+		for _, click := range page {
+			if err := fn(click); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < exportPageSize {
+			return nil
+		}
+		lastLinkID, lastDate = page[len(page)-1].LinkID, page[len(page)-1].Date
+	}
+}
+
+func (s *PostgresStore) CreateOrganization(ctx context.Context, org *Organization, owner *Member, key *APIKey, hash string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO organizations (slug, name, created_at)
+		VALUES ($1, $2, $3)
+		RETURNING ` + organizationColumns
+
+	err = tx.GetContext(ctx, org, query, org.Slug, org.Name, time.Now())
+	if isUniqueViolation(err) {
+		return ErrSlugTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO organization_members (org_id, email, role, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + memberColumns
+
+	err = tx.GetContext(ctx, owner, query, org.ID, owner.Email, owner.Role, time.Now())
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO api_keys (org_id, member_id, name, prefix, key_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + apiKeyColumns
+
+	err = tx.GetContext(ctx, key, query, org.ID, owner.ID, key.Name, key.Prefix, hash, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) GetOrganization(ctx context.Context, id int) (Organization, error) {
+	var org Organization
+	err := s.db.GetContext(ctx, &org, `SELECT `+organizationColumns+` FROM organizations WHERE id = $1`, id)
+	if err == sql.ErrNoRows {
+		return org, ErrOrgNotFound
+	}
+
+	return org, err
+}
+
+func (s *PostgresStore) GetOrganizationBySlug(ctx context.Context, slug string) (Organization, error) {
+	var org Organization
+	err := s.db.GetContext(ctx, &org, `SELECT `+organizationColumns+` FROM organizations WHERE slug = $1`, slug)
+	if err == sql.ErrNoRows {
+		return org, ErrOrgNotFound
+	}
+
+	return org, err
+}
+
+func (s *PostgresStore) AddMember(ctx context.Context, member *Member) error {
+	query := `
+		INSERT INTO organization_members (org_id, email, role, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + memberColumns
+
+	err := s.db.GetContext(ctx, member, query, member.OrgID, member.Email, member.Role, time.Now())
+	if isUniqueViolation(err) {
+		return ErrMemberExists
+	}
+
+	return err
+}
+
+func (s *PostgresStore) GetMember(ctx context.Context, orgID int, memberID int) (Member, error) {
+	var member Member
+	err := s.db.GetContext(ctx, &member, `SELECT `+memberColumns+` FROM organization_members WHERE org_id = $1 AND id = $2`, orgID, memberID)
+	if err == sql.ErrNoRows {
+		return member, ErrMemberNotFound
+	}
+
+	return member, err
+}
+
+func (s *PostgresStore) ListMembers(ctx context.Context, orgID int) ([]Member, error) {
+	members := []Member{}
+	err := s.db.SelectContext(ctx, &members, `SELECT `+memberColumns+` FROM organization_members WHERE org_id = $1 ORDER BY id`, orgID)
+
+	return members, err
+}
+
+func (s *PostgresStore) RemoveMember(ctx context.Context, orgID int, memberID int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM api_key_usage WHERE api_key_id IN (SELECT id FROM api_keys WHERE org_id = $1 AND member_id = $2)`, orgID, memberID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM api_keys WHERE org_id = $1 AND member_id = $2`, orgID, memberID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM member_identities WHERE member_id IN (SELECT id FROM organization_members WHERE org_id = $1 AND id = $2)`, orgID, memberID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM member_usage WHERE member_id IN (SELECT id FROM organization_members WHERE org_id = $1 AND id = $2)`, orgID, memberID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM organization_members WHERE org_id = $1 AND id = $2`, orgID, memberID)
+	if err != nil {
+		return err
+	}
+
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrMemberNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) CreateAPIKey(ctx context.Context, key *APIKey, hash string) error {
+	query := `
+		INSERT INTO api_keys (org_id, member_id, name, prefix, key_hash, scopes, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING ` + apiKeyColumns
+
+	return s.db.GetContext(ctx, key, query, key.OrgID, key.MemberID, key.Name, key.Prefix, hash, key.Scopes, key.ExpiresAt, time.Now())
+}
+
+func (s *PostgresStore) GetAPIKey(ctx context.Context, orgID int, keyID int) (APIKey, error) {
+	var key APIKey
+	err := s.db.GetContext(ctx, &key, `SELECT `+apiKeyColumns+` FROM api_keys WHERE org_id = $1 AND id = $2`, orgID, keyID)
+	if err == sql.ErrNoRows {
+		return key, ErrAPIKeyNotFound
+	}
+
+	return key, err
+}
+
+func (s *PostgresStore) RotateAPIKey(ctx context.Context, old *APIKey, key *APIKey, hash string, graceUntil time.Time) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO api_keys (org_id, member_id, name, prefix, key_hash, scopes, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING ` + apiKeyColumns
+
+	err = tx.GetContext(ctx, key, query, key.OrgID, key.MemberID, key.Name, key.Prefix, hash, key.Scopes, key.ExpiresAt, time.Now())
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE api_keys SET expires_at = $1, replaced_by = $2
+		WHERE org_id = $3 AND id = $4 AND revoked_at IS NULL AND replaced_by IS NULL
+		RETURNING ` + apiKeyColumns
+
+	err = tx.GetContext(ctx, old, query, graceUntil, key.ID, old.OrgID, old.ID)
+	if err == sql.ErrNoRows {
+		return ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// The new key takes over the usage and links of the old one, and the
+	// keys the old one replaced, so that rotating resets neither its quota
+	// nor the links its shortens are deduplicated against.
+	_, err = tx.ExecContext(ctx, `UPDATE api_key_usage SET api_key_id = $1 WHERE api_key_id = $2`, key.ID, old.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE links SET key_id = $1 WHERE org_id = $2 AND key_id = $3`, key.ID, old.OrgID, old.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE api_keys SET replaced_by = $1 WHERE org_id = $2 AND replaced_by = $3`, key.ID, old.OrgID, old.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) SetAPIKeySigningSecret(ctx context.Context, orgID int, keyID int, secret *string) error {
+	query := `UPDATE api_keys SET signing_secret = $1 WHERE org_id = $2 AND id = $3 AND revoked_at IS NULL`
+
+	result, err := s.db.ExecContext(ctx, query, secret, orgID, keyID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) AuthenticateSigningKey(ctx context.Context, keyID int) (APIKey, Member, error) {
+	var key APIKey
+	var member Member
+
+	err := s.db.GetContext(ctx, &key, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1 AND revoked_at IS NULL AND signing_secret IS NOT NULL`, keyID)
+	if err == sql.ErrNoRows {
+		return key, member, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return key, member, err
+	}
+
+	member, err = s.GetMember(ctx, key.OrgID, key.MemberID)
+	if err == ErrMemberNotFound {
+		return key, member, ErrAPIKeyNotFound
+	}
+
+	return key, member, err
+}
+
+func (s *PostgresStore) TouchAPIKey(ctx context.Context, keyID int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $1 WHERE id = $2`, time.Now(), keyID)
+	return err
+}
+
+func (s *PostgresStore) ListAPIKeys(ctx context.Context, orgID int) ([]APIKey, error) {
+	keys := []APIKey{}
+	err := s.db.SelectContext(ctx, &keys, `SELECT `+apiKeyColumns+` FROM api_keys WHERE org_id = $1 ORDER BY id`, orgID)
+
+	return keys, err
+}
+
+func (s *PostgresStore) RevokeAPIKey(ctx context.Context, orgID int, keyID int) error {
+	query := `UPDATE api_keys SET revoked_at = $1 WHERE org_id = $2 AND id = $3 AND revoked_at IS NULL`
+
+	result, err := s.db.ExecContext(ctx, query, time.Now(), orgID, keyID)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) IdentityMembers(ctx context.Context, provider string, subject string, email string) ([]Member, error) {
+	query := `
+		SELECT ` + memberColumns + ` FROM organization_members m
+		WHERE m.id IN (SELECT member_id FROM member_identities WHERE provider = $1 AND subject = $2)
+		   OR ($3 <> '' AND LOWER(m.email) = LOWER($3)
+		       AND NOT EXISTS (SELECT 1 FROM member_identities i WHERE i.member_id = m.id AND i.provider = $1))
+		ORDER BY m.id
+	`
+
+	members := []Member{}
+	err := s.db.SelectContext(ctx, &members, query, provider, subject, email)
+
+	return members, err
+}
+
+func (s *PostgresStore) LinkIdentity(ctx context.Context, memberID int, provider string, subject string) error {
+	query := `
+		INSERT INTO member_identities (member_id, provider, subject, created_at, last_login_at)
+		VALUES ($1, $2, $3, $4, $4)
+		ON CONFLICT (member_id, provider) DO UPDATE SET subject = EXCLUDED.subject, last_login_at = EXCLUDED.last_login_at
+	`
+
+	_, err := s.db.ExecContext(ctx, query, memberID, provider, subject, time.Now())
+	return err
+}
+
+func (s *PostgresStore) AuthenticateAPIKey(ctx context.Context, hash string) (APIKey, Member, error) {
+	var key APIKey
+	var member Member
+
+	err := s.db.GetContext(ctx, &key, `SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL`, hash)
+	if err == sql.ErrNoRows {
+		return key, member, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return key, member, err
+	}
+
+	member, err = s.GetMember(ctx, key.OrgID, key.MemberID)
+	if err == ErrMemberNotFound {
+		return key, member, ErrAPIKeyNotFound
+	}
+
+	return key, member, err
+}
+
+func (s *PostgresStore) CreateDomain(ctx context.Context, domain *CustomDomain) error {
+	query := `
+		INSERT INTO domains (org_id, hostname, verification_token, created_at)
+		SELECT $1::integer, $2::varchar, $3::varchar, $4::timestamptz
+		WHERE NOT EXISTS (SELECT 1 FROM domains WHERE hostname = $2 AND verified_at IS NOT NULL)
+		RETURNING ` + customDomainColumns
+
+	err := s.db.GetContext(ctx, domain, query, domain.OrgID, domain.Hostname, domain.VerificationToken, time.Now())
+	if err == sql.ErrNoRows || isUniqueViolation(err) {
+		return ErrDomainTaken
+	}
+
+	return err
+}
+
+func (s *PostgresStore) GetDomain(ctx context.Context, orgID int, id int) (CustomDomain, error) {
+	var domain CustomDomain
+	err := s.db.GetContext(ctx, &domain, `SELECT `+customDomainColumns+` FROM domains WHERE org_id = $1 AND id = $2`, orgID, id)
+	if err == sql.ErrNoRows {
+		return domain, ErrDomainNotFound
+	}
+
+	return domain, err
+}
+
+func (s *PostgresStore) GetDomainByHostname(ctx context.Context, hostname string) (CustomDomain, error) {
+	var domain CustomDomain
+	err := s.db.GetContext(ctx, &domain, `SELECT `+customDomainColumns+` FROM domains WHERE hostname = $1 AND verified_at IS NOT NULL`, hostname)
+	if err == sql.ErrNoRows {
+		return domain, ErrDomainNotFound
+	}
+
+	return domain, err
+}
+
+func (s *PostgresStore) ListDomainsByHostname(ctx context.Context, hostname string) ([]CustomDomain, error) {
+	domains := []CustomDomain{}
+	err := s.db.SelectContext(ctx, &domains, `SELECT `+customDomainColumns+` FROM domains WHERE hostname = $1 ORDER BY id`, hostname)
+
+	return domains, err
+}
+
+func (s *PostgresStore) ListDomains(ctx context.Context, orgID int) ([]CustomDomain, error) {
+	domains := []CustomDomain{}
+	err := s.db.SelectContext(ctx, &domains, `SELECT `+customDomainColumns+` FROM domains WHERE org_id = $1 ORDER BY hostname`, orgID)
+
+	return domains, err
+}
+
+func (s *PostgresStore) VerifyDomain(ctx context.Context, domain *CustomDomain) error {
+	query := `
+		UPDATE domains SET verified_at = $1
+		WHERE org_id = $2 AND id = $3
+		RETURNING ` + customDomainColumns
+
+	err := s.db.GetContext(ctx, domain, query, time.Now(), domain.OrgID, domain.ID)
+	if err == sql.ErrNoRows {
+		return ErrDomainNotFound
+	}
+	if isUniqueViolation(err) {
+		return ErrDomainTaken
+	}
+
+	return err
+}
+
+func (s *PostgresStore) DeleteDomain(ctx context.Context, orgID int, id int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var domain CustomDomain
+	err = tx.GetContext(ctx, &domain, `SELECT `+customDomainColumns+` FROM domains WHERE org_id = $1 AND id = $2 FOR UPDATE`, orgID, id)
+	if err == sql.ErrNoRows {
+		return ErrDomainNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var inUse bool
+	err = tx.GetContext(ctx, &inUse, `SELECT EXISTS(SELECT 1 FROM links WHERE org_id = $1 AND domain_id = $2)`, orgID, id)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrDomainInUse
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM domains WHERE id = $1`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) ConsumeUsage(ctx context.Context, meter UsageMeter, month string, metric string, n int, limit int) (int, bool, error) {
+	if !usageMetrics[metric] {
+		return 0, false, fmt.Errorf("unknown usage metric %q", metric)
+	}
+
+	if limit > 0 && n > limit {
+		usage, err := s.MeterUsage(ctx, meter, month)
+		return usageOf(usage, metric), false, err
+	}
+
+	table, column, id := meter.usageTable()
+	query := fmt.Sprintf(`
+		INSERT INTO %[2]s (%[3]s, month, %[1]s)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (%[3]s, month)
+		DO UPDATE SET %[1]s = %[2]s.%[1]s + EXCLUDED.%[1]s
+		WHERE $4 = 0 OR %[2]s.%[1]s + EXCLUDED.%[1]s <= $4
+		RETURNING %[1]s
+	`, metric, table, column)
+
+	var used int
+	err := s.db.GetContext(ctx, &used, query, id, month, n, limit)
+	if err == sql.ErrNoRows {
+		usage, err := s.MeterUsage(ctx, meter, month)
+		return usageOf(usage, metric), false, err
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return used, true, nil
+}
+
+func (s *PostgresStore) MeterUsage(ctx context.Context, meter UsageMeter, month string) (Usage, error) {
+	table, column, id := meter.usageTable()
+
+	var usage Usage
+	err := s.db.GetContext(ctx, &usage, `SELECT shortens, redirects FROM `+table+` WHERE `+column+` = $1 AND month = $2`, id, month)
+	if err == sql.ErrNoRows {
+		return usage, nil
+	}
+
+	return usage, err
+}
+
+func (s *PostgresStore) Stats(ctx context.Context, filter StatsFilter) (Stats, error) {
+	var stats Stats
+	day, week, month := statsWindows(filter.Today)
+
+	scope, args := "", []interface{}{day, week, month}
+	if !filter.AllOrgs {
+		scope, args = ` AND org_id = $4`, append(args, filter.OrgID)
+	}
+	if filter.Tag != "" {
+		args = append(args, filter.Tag)
+		scope += fmt.Sprintf(` AND id IN (SELECT link_id FROM link_tags WHERE tag = $%d)`, len(args))
+	}
+
+	query := `
+		SELECT
+			COUNT(*) AS links,
+			COALESCE(SUM(CASE WHEN created_at >= $1 THEN 1 ELSE 0 END), 0) AS created_today,
+			COALESCE(SUM(CASE WHEN created_at >= $2 THEN 1 ELSE 0 END), 0) AS created_7_days,
+			COALESCE(SUM(CASE WHEN created_at >= $3 THEN 1 ELSE 0 END), 0) AS created_30_days
+		FROM links
+		WHERE deleted_at IS NULL` + scope
+
+	err := s.db.GetContext(ctx, &stats, query, args...)
+	if err != nil {
+		return stats, err
+	}
+
+	scope, args = "", []interface{}{day.Format("2006-01-02"), week.Format("2006-01-02"), month.Format("2006-01-02")}
+	if !filter.AllOrgs {
+		scope, args = ` AND l.org_id = $4`, append(args, filter.OrgID)
+	}
+	if filter.Tag != "" {
+		args = append(args, filter.Tag)
+		scope += fmt.Sprintf(` AND l.id IN (SELECT link_id FROM link_tags WHERE tag = $%d)`, len(args))
+	}
+
+	query = `
+		SELECT
+			COALESCE(SUM(CASE WHEN c.date >= $1 THEN c.clicks ELSE 0 END), 0) AS clicks_today,
+			COALESCE(SUM(CASE WHEN c.date >= $2 THEN c.clicks ELSE 0 END), 0) AS clicks_7_days,
+			COALESCE(SUM(c.clicks), 0) AS clicks_30_days
+		FROM clicks c
+		JOIN links l ON l.id = c.link_id
+		WHERE c.date >= $3 AND l.deleted_at IS NULL` + scope
+
+	err = s.db.GetContext(ctx, &stats, query, args...)
+	if err != nil {
+		return stats, err
+	}
+
+	scope, args = "", nil
+	if !filter.AllOrgs {
+		scope, args = ` AND org_id = $1`, append(args, filter.OrgID)
+	}
+	if filter.Tag != "" {
+		args = append(args, filter.Tag)
+		scope += fmt.Sprintf(` AND id IN (SELECT link_id FROM link_tags WHERE tag = $%d)`, len(args))
+	}
+
+	query = fmt.Sprintf(`
+		SELECT %s
+		FROM links
+		WHERE deleted_at IS NULL%s
+		ORDER BY click_count DESC, id
+		LIMIT $%d
+	`, linkColumns, scope, len(args)+1)
+
+	stats.TopLinks = []Link{}
+	err = s.db.SelectContext(ctx, &stats.TopLinks, query, append(args, filter.TopLimit)...)
+
+	return stats, err
+}
+
+func (s *PostgresStore) TrendingLinks(ctx context.Context, filter TrendingFilter) ([]TrendingLink, int, error) {
+	var total int
+	query := `
+		SELECT COUNT(DISTINCT c.link_id)
+		FROM clicks c
+		JOIN links l ON l.id = c.link_id
+		WHERE c.date >= $1 AND l.org_id = $2 AND l.deleted_at IS NULL
+	`
+
+	err := s.db.GetContext(ctx, &total, query, filter.From, filter.OrgID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query = `
+		SELECT ` + linkColumns + `, w.clicks AS window_clicks
+		FROM (
+			SELECT link_id, SUM(clicks) AS clicks
+			FROM clicks
+			WHERE date >= $1
+			GROUP BY link_id
+		) w
+		JOIN links ON links.id = w.link_id
+		WHERE org_id = $2 AND deleted_at IS NULL
+		ORDER BY w.clicks DESC, id
+		LIMIT $3 OFFSET $4
+	`
+
+	links := []TrendingLink{}
+	err = s.db.SelectContext(ctx, &links, query, filter.From, filter.OrgID, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+func (s *PostgresStore) CreateWebhook(ctx context.Context, hook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (org_id, url, events, secret, active, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + webhookColumns
+
+	return s.db.GetContext(ctx, hook, query, hook.OrgID, hook.URL, hook.Events, hook.Secret, hook.Active, time.Now())
+}
+
+func (s *PostgresStore) GetWebhook(ctx context.Context, orgID int, id int) (Webhook, error) {
+	var hook Webhook
+	err := s.db.GetContext(ctx, &hook, `SELECT `+webhookColumns+` FROM webhooks WHERE org_id = $1 AND id = $2`, orgID, id)
+	if err == sql.ErrNoRows {
+		return hook, ErrWebhookNotFound
+	}
+
+	return hook, err
+}
+
+func (s *PostgresStore) ListWebhooks(ctx context.Context, orgID int) ([]Webhook, error) {
+	hooks := []Webhook{}
+	err := s.db.SelectContext(ctx, &hooks, `SELECT `+webhookColumns+` FROM webhooks WHERE org_id = $1 ORDER BY id`, orgID)
+
+	return hooks, err
+}
+
+func (s *PostgresStore) UpdateWebhook(ctx context.Context, hook *Webhook) error {
+	query := `
+		UPDATE webhooks SET url = $1, events = $2, active = $3, updated_at = $4
+		WHERE org_id = $5 AND id = $6
+		RETURNING ` + webhookColumns
+
+	err := s.db.GetContext(ctx, hook, query, hook.URL, hook.Events, hook.Active, time.Now(), hook.OrgID, hook.ID)
+	if err == sql.ErrNoRows {
+		return ErrWebhookNotFound
+	}
+
+	return err
+}
+
+func (s *PostgresStore) DeleteWebhook(ctx context.Context, orgID int, id int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE org_id = $1 AND id = $2)`, orgID, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM webhooks WHERE org_id = $1 AND id = $2`, orgID, id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrWebhookNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) EnqueueDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, attempts, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, 0, $4, $4)
+		RETURNING id, created_at
+	`
+
+	return s.db.QueryRowxContext(ctx, query, delivery.WebhookID, delivery.Event, delivery.Payload, time.Now()).Scan(&delivery.ID, &delivery.CreatedAt)
+}
+
+// ClaimDeliveries skips rows locked by another instance's claim so that
+// concurrent workers never wait on, or share, a delivery.
+func (s *PostgresStore) ClaimDeliveries(ctx context.Context, token string, leaseUntil time.Time, limit int) ([]WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries SET claim_token = $1, next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+	`
+
+	_, err := s.db.ExecContext(ctx, query, token, leaseUntil, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT ` + claimedDeliveryColumns + `
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.claim_token = $1
+		ORDER BY d.id
+	`
+
+	deliveries := []WebhookDelivery{}
+	err = s.db.SelectContext(ctx, &deliveries, query, token)
+
+	return deliveries, err
+}
+
+func (s *PostgresStore) UpdateDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET attempts = $1, next_attempt_at = $2, delivered_at = $3, last_error = $4, claim_token = NULL
+		WHERE id = $5 AND claim_token = $6
+	`
+
+	_, err := s.db.ExecContext(ctx, query, delivery.Attempts, delivery.NextAttemptAt, delivery.DeliveredAt, delivery.LastError, delivery.ID, delivery.ClaimToken)
+	return err
+}
+
+func (s *PostgresStore) PurgeDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE next_attempt_at IS NULL AND created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *PostgresStore) CreateDomainRule(ctx context.Context, rule *DomainRule) error {
+	query := `
+		INSERT INTO domain_rules (domain, list, created_at)
+		VALUES ($1, $2, $3)
+		RETURNING ` + domainRuleColumns
+
+	err := s.db.GetContext(ctx, rule, query, rule.Domain, rule.List, time.Now())
+	if isUniqueViolation(err) {
+		return ErrDomainRuleExists
+	}
+
+	return err
+}
+
+func (s *PostgresStore) ListDomainRules(ctx context.Context) ([]DomainRule, error) {
+	rules := []DomainRule{}
+	err := s.db.SelectContext(ctx, &rules, `SELECT `+domainRuleColumns+` FROM domain_rules ORDER BY domain`)
+
+	return rules, err
+}
+
+func (s *PostgresStore) DeleteDomainRule(ctx context.Context, id int) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM domain_rules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrDomainRuleNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) Close() error {
+	closeStatements(s.getLinkStmt, s.clickCountStmt, s.dailyClicksStmt)
+	return s.db.Close()
+}
+
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+func isUniqueViolationOf(err error, constraint string) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
@@ -0,0 +1,64 @@
+package main
+
+import "context"
+
+// ReplicaStore sends link lookups and stats queries to a read replica and
+// everything else to the primary, so redirect traffic does not contend
+// with writes. A code the replica does not know yet is looked up on the
+// primary, as a link may be redirected to right after it was created.
+// Other reads may lag behind the primary by the replication delay, much
+// like cached links do. Closing the store leaves the replica open.
+type ReplicaStore struct {
+	Store
+
+	replica Store
+}
+
+func NewReplicaStore(primary Store, replica Store) *ReplicaStore {
+	return &ReplicaStore{Store: primary, replica: replica}
+}
+
+func (s *ReplicaStore) GetLink(ctx context.Context, orgID int, domainID int, code string) (Link, error) {
+	link, err := s.replica.GetLink(ctx, orgID, domainID, code)
+	if err == ErrNotFound {
+		return s.Store.GetLink(ctx, orgID, domainID, code)
+	}
+
+	return link, err
+}
+
+func (s *ReplicaStore) ClickEvents(ctx context.Context, linkID int, filter ClickEventFilter) ([]ClickEvent, error) {
+	return s.replica.ClickEvents(ctx, linkID, filter)
+}
+
+func (s *ReplicaStore) ClickTimeSeries(ctx context.Context, linkID int, filter ClickSeriesFilter) ([]ClickBucket, error) {
+	return s.replica.ClickTimeSeries(ctx, linkID, filter)
+}
+
+func (s *ReplicaStore) TopReferrers(ctx context.Context, linkID int, limit int) ([]ReferrerCount, error) {
+	return s.replica.TopReferrers(ctx, linkID, limit)
+}
+
+func (s *ReplicaStore) CountryClicks(ctx context.Context, linkID int) ([]CountryCount, error) {
+	return s.replica.CountryClicks(ctx, linkID)
+}
+
+func (s *ReplicaStore) CodeClicks(ctx context.Context, linkID int) ([]CodeCount, error) {
+	return s.replica.CodeClicks(ctx, linkID)
+}
+
+func (s *ReplicaStore) DeviceClicks(ctx context.Context, linkID int) ([]DeviceCount, error) {
+	return s.replica.DeviceClicks(ctx, linkID)
+}
+
+func (s *ReplicaStore) DestinationClicks(ctx context.Context, linkID int) ([]DestinationCount, error) {
+	return s.replica.DestinationClicks(ctx, linkID)
+}
+
+func (s *ReplicaStore) Stats(ctx context.Context, filter StatsFilter) (Stats, error) {
+	return s.replica.Stats(ctx, filter)
+}
+
+func (s *ReplicaStore) TrendingLinks(ctx context.Context, filter TrendingFilter) ([]TrendingLink, int, error) {
+	return s.replica.TrendingLinks(ctx, filter)
+}
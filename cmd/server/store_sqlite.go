@@ -0,0 +1,2295 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteStore implements Store on a single SQLite file for deployments
+// that do not want to run Postgres. Writes are serialised by keeping a
+// single connection open, which stands in for row locks; see
+// databaseDriver.configurePool.
+//
+// Timestamps are stored as UTC text, so every time bound to a query goes
+// through sqliteTime to keep comparisons lexicographically correct.
+type SQLiteStore struct {
+	db *sqlx.DB
+
+	// The statements of the redirect path are prepared once, when the
+	// store is opened.
+	getLinkStmt     *sqlx.Stmt
+	clickCountStmt  *sqlx.Stmt
+	dailyClicksStmt *sqlx.Stmt
+}
+
+// NewSQLiteStore prepares the statements of the store, so the schema
+// must be migrated first.
+func NewSQLiteStore(db *sqlx.DB) (*SQLiteStore, error) {
+	s := &SQLiteStore{db: db}
+
+	var err error
+	s.getLinkStmt, err = db.Preparex(`SELECT ` + linkColumns + ` FROM links WHERE org_id = ? AND domain_id = ? AND code = ?`)
+	if err != nil {
+		return nil, fmt.Errorf("preparing link lookup: %w", err)
+	}
+
+	s.clickCountStmt, err = db.Preparex(`UPDATE links SET click_count = click_count + ? WHERE id = ?`)
+	if err != nil {
+		return nil, fmt.Errorf("preparing click count update: %w", err)
+	}
+
+	s.dailyClicksStmt, err = db.Preparex(`
+		INSERT INTO clicks (link_id, clicks, date)
+		VALUES (?, ?, ?)
+		ON CONFLICT (link_id, date)
+		DO UPDATE SET clicks = clicks.clicks + excluded.clicks
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("preparing click upsert: %w", err)
+	}
+
+	return s, nil
+}
+
+// sqliteDSN adds the connection options the store depends on to dsn.
+func sqliteDSN(dsn string) string {
+	options := []string{"_time_format=sqlite", "_pragma=foreign_keys(1)", "_pragma=busy_timeout(5000)"}
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+
+	return dsn + separator + strings.Join(options, "&")
+}
+
+func sqliteTime(t time.Time) time.Time {
+	return t.UTC()
+}
+
+func sqliteNullableTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	utc := t.UTC()
+	return &utc
+}
+
+func (s *SQLiteStore) CreateLink(ctx context.Context, link *Link) error {
+	aliased, err := s.isAlias(ctx, link.OrgID, link.DomainID, link.Code)
+	if err != nil {
+		return err
+	}
+	if aliased {
+		return ErrCodeTaken
+	}
+
+	query := `
+		INSERT INTO links (org_id, code, url, created_at, attempt_count, expires_at, redirect_status, max_clicks, tracking_disabled, forward_query, utm_source, utm_medium, utm_campaign, destinations, sticky_destinations, geo_targets, device_targets, routing_rules, active_from, active_until, fallback_url, folder_id, description, notes, idempotency_key, key_id, force_new, domain_id, url_key, idempotency_hash, member_id)
+		VALUES (?, ?, ?, ?, 1, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING ` + linkColumns
+
+	err = s.db.GetContext(ctx, link, query, link.OrgID, link.Code, link.URL, sqliteTime(time.Now()), sqliteNullableTime(link.ExpiresAt), link.RedirectStatus, link.MaxClicks, link.TrackingDisabled, link.ForwardQuery, link.UTMSource, link.UTMMedium, link.UTMCampaign, link.Destinations, link.StickyDestinations, link.GeoTargets, link.DeviceTargets, link.RoutingRules, sqliteNullableTime(link.ActiveFrom), sqliteNullableTime(link.ActiveUntil), link.FallbackURL, link.FolderID, link.Description, link.Notes, link.IdempotencyKey, link.KeyID, link.ForceNew, link.DomainID, canonicalURL(link.URL), link.IdempotencyHash, link.MemberID)
+
+	return sqliteConflictError(err)
+}
+
+func (s *SQLiteStore) UpsertLink(ctx context.Context, link *Link) error {
+	aliased, err := s.isAlias(ctx, link.OrgID, link.DomainID, link.Code)
+	if err != nil {
+		return err
+	}
+	if aliased {
+		return ErrCodeTaken
+	}
+
+	query := `
+		INSERT INTO links (org_id, code, url, created_at, attempt_count, expires_at, redirect_status, tracking_disabled, forward_query, idempotency_key, key_id, domain_id, url_key, idempotency_hash, member_id)
+		VALUES (?, ?, ?, ?, 1, NULL, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (org_id, domain_id, key_id, url_key) WHERE expires_at IS NULL AND deleted_at IS NULL AND max_clicks IS NULL
+			AND utm_source IS NULL AND utm_medium IS NULL AND utm_campaign IS NULL
+			AND destinations IS NULL AND geo_targets IS NULL AND device_targets IS NULL
+			AND active_from IS NULL AND active_until IS NULL AND fallback_url IS NULL
+			AND routing_rules IS NULL AND archived_at IS NULL AND NOT force_new
+		DO UPDATE SET attempt_count = links.attempt_count + 1
+		RETURNING ` + linkColumns
+
+	err = s.db.GetContext(ctx, link, query, link.OrgID, link.Code, link.URL, sqliteTime(time.Now()), link.RedirectStatus, link.TrackingDisabled, link.ForwardQuery, link.IdempotencyKey, link.KeyID, link.DomainID, canonicalURL(link.URL), link.IdempotencyHash, link.MemberID)
+
+	return sqliteConflictError(err)
+}
+
+func (s *SQLiteStore) GetLink(ctx context.Context, orgID int, domainID int, code string) (Link, error) {
+	var link Link
+	err := s.getLinkStmt.GetContext(ctx, &link, orgID, domainID, code)
+	if err == sql.ErrNoRows {
+		query := `SELECT ` + linkColumns + ` FROM links WHERE id = (SELECT link_id FROM link_aliases WHERE org_id = ? AND domain_id = ? AND code = ?)`
+		err = s.db.GetContext(ctx, &link, query, orgID, domainID, code)
+	}
+	if err == sql.ErrNoRows {
+		return link, ErrNotFound
+	}
+
+	return link, err
+}
+
+func (s *SQLiteStore) GetLinkByIdempotencyKey(ctx context.Context, orgID int, keyID int, memberID int, key string) (Link, error) {
+	var link Link
+	err := s.db.GetContext(ctx, &link, `SELECT `+linkColumns+`, idempotency_hash FROM links WHERE org_id = ? AND key_id = ? AND member_id = ? AND idempotency_key = ?`, orgID, keyID, memberID, key)
+	if err == sql.ErrNoRows {
+		return link, ErrNotFound
+	}
+
+	return link, err
+}
+
+func (s *SQLiteStore) CodeExists(ctx context.Context, orgID int, domainID int, code string) (bool, error) {
+	var exists bool
+	query := `
+		SELECT EXISTS(SELECT 1 FROM links WHERE org_id = ? AND domain_id = ? AND code = ?)
+			OR EXISTS(SELECT 1 FROM link_aliases WHERE org_id = ? AND domain_id = ? AND code = ?)
+	`
+	err := s.db.GetContext(ctx, &exists, query, orgID, domainID, code, orgID, domainID, code)
+
+	return exists, err
+}
+
+// isAlias reports whether code is an alias in the namespace of orgID and
+// domainID. The unique index on links.code does not cover aliases, so the
+// inserts of links check them first.
+func (s *SQLiteStore) isAlias(ctx context.Context, orgID int, domainID int, code string) (bool, error) {
+	var exists bool
+	err := s.db.GetContext(ctx, &exists, `SELECT EXISTS(SELECT 1 FROM link_aliases WHERE org_id = ? AND domain_id = ? AND code = ?)`, orgID, domainID, code)
+
+	return exists, err
+}
+
+func (s *SQLiteStore) ReserveCodeSequence(ctx context.Context, count int64) (int64, error) {
+	var value int64
+	err := s.db.GetContext(ctx, &value, `UPDATE code_sequence SET value = value + ? RETURNING value`, count)
+
+	return value, err
+}
+
+func (s *SQLiteStore) ConsumeClick(ctx context.Context, linkID int) (bool, error) {
+	query := `UPDATE links SET consumed_clicks = consumed_clicks + 1 WHERE id = ? AND consumed_clicks < max_clicks`
+
+	result, err := s.db.ExecContext(ctx, query, linkID)
+	if err != nil {
+		return false, err
+	}
+
+	consumed, err := result.RowsAffected()
+	return consumed > 0, err
+}
+
+func (s *SQLiteStore) ListLinks(ctx context.Context, filter LinkFilter) ([]Link, int, error) {
+	if !linkSortFields[filter.Sort] {
+		return nil, 0, fmt.Errorf("cannot sort links by %q", filter.Sort)
+	}
+
+	where, args := sqliteLinkConditions(filter)
+
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM links WHERE `+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	order := "ASC"
+	if filter.Descending {
+		order = "DESC"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM links
+		WHERE %s
+		ORDER BY %s %s, id %s
+		LIMIT ? OFFSET ?
+	`, linkColumns, where, filter.Sort, order, order)
+
+	links := []Link{}
+	err = s.db.SelectContext(ctx, &links, query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+func (s *SQLiteStore) SearchLinks(ctx context.Context, filter LinkFilter, terms []string) ([]Link, int, error) {
+	where, args := sqliteLinkConditions(filter)
+	bind := func(value interface{}) string {
+		args = append(args, value)
+		return "?"
+	}
+	where += " AND " + linkSearchConditions(terms, "LIKE", bind)
+
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM links WHERE `+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rank := linkSearchRank(terms, "LIKE", bind)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM links
+		WHERE %s
+		ORDER BY %s DESC, click_count DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, linkColumns, where, rank)
+
+	links := []Link{}
+	err = s.db.SelectContext(ctx, &links, query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+// ExportLinks pages through the links by id so that no connection is held
+// while fn writes to a slow client.
+func (s *SQLiteStore) ExportLinks(ctx context.Context, filter LinkFilter, fn func(link Link) error) error {
+	where, args := sqliteLinkConditions(filter)
+	query := `
+		SELECT ` + linkColumns + `
+		FROM links
+		WHERE ` + where + ` AND id > ?
+		ORDER BY id
+		LIMIT ?
+	`
+
+	lastID := 0
+	for {
+		var page []Link
+		err := s.db.SelectContext(ctx, &page, query, append(args, lastID, exportPageSize)...)
+		if err != nil {
+			return err
+		}
+
+		for _, link := range page {
+			if err := fn(link); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < exportPageSize {
+			return nil
+		}
+		lastID = page[len(page)-1].ID
+	}
+}
+
+func sqliteLinkConditions(filter LinkFilter) (string, []interface{}) {
+	conditions := []string{"org_id = ?", "deleted_at IS NULL", "archived_at IS NULL"}
+	args := []interface{}{filter.OrgID}
+	if filter.Deleted {
+		conditions[1] = "deleted_at IS NOT NULL"
+	}
+	if filter.Archived {
+		conditions[2] = "archived_at IS NOT NULL"
+	}
+
+	if filter.CreatedFrom != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, sqliteTime(*filter.CreatedFrom))
+	}
+
+	if filter.CreatedTo != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, sqliteTime(*filter.CreatedTo))
+	}
+
+	if filter.MinClicks > 0 {
+		conditions = append(conditions, "click_count >= ?")
+		args = append(args, filter.MinClicks)
+	}
+
+	if filter.Broken {
+		conditions = append(conditions, "broken_since IS NOT NULL")
+	}
+
+	if filter.Tag != "" {
+		conditions = append(conditions, "id IN (SELECT link_id FROM link_tags WHERE tag = ?)")
+		args = append(args, filter.Tag)
+	}
+
+	if filter.FolderIDs != nil {
+		conditions = append(conditions, "folder_id IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(filter.FolderIDs)), ", ")+")")
+		for _, id := range filter.FolderIDs {
+			args = append(args, id)
+		}
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+func (s *SQLiteStore) UpdateLink(ctx context.Context, orgID int, domainID int, code string, update func(link *Link) error) (Link, error) {
+	var link Link
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return link, err
+	}
+	defer tx.Rollback()
+
+	err = tx.GetContext(ctx, &link, `SELECT `+linkColumns+` FROM links WHERE org_id = ? AND domain_id = ? AND code = ?`, orgID, domainID, code)
+	if err == sql.ErrNoRows {
+		return link, ErrNotFound
+	}
+	if err != nil {
+		return link, err
+	}
+
+	before := link
+	url := link.URL
+	if err = update(&link); err != nil {
+		return link, err
+	}
+	if link.URL != url {
+		link.LinkHealth = LinkHealth{}
+	}
+
+	updatedAt := time.Now()
+	link.UpdatedAt = &updatedAt
+
+	query := `
+		UPDATE links SET url = ?, expires_at = ?, redirect_status = ?, tracking_disabled = ?, forward_query = ?,
+			utm_source = ?, utm_medium = ?, utm_campaign = ?, destinations = ?, sticky_destinations = ?,
+			geo_targets = ?, device_targets = ?, routing_rules = ?, active_from = ?, active_until = ?,
+			fallback_url = ?, folder_id = ?, description = ?, notes = ?, checked_at = ?, check_status = ?, check_failures = ?,
+			broken_since = ?, deleted_at = ?, archived_at = ?, updated_at = ?, url_key = ?
+		WHERE id = ?`
+	_, err = tx.ExecContext(ctx, query, link.URL, sqliteNullableTime(link.ExpiresAt), link.RedirectStatus, link.TrackingDisabled, link.ForwardQuery, link.UTMSource, link.UTMMedium, link.UTMCampaign, link.Destinations, link.StickyDestinations, link.GeoTargets, link.DeviceTargets, link.RoutingRules, sqliteNullableTime(link.ActiveFrom), sqliteNullableTime(link.ActiveUntil), link.FallbackURL, link.FolderID, link.Description, link.Notes, sqliteNullableTime(link.CheckedAt), link.CheckStatus, link.CheckFailures, sqliteNullableTime(link.BrokenSince), sqliteNullableTime(link.DeletedAt), sqliteNullableTime(link.ArchivedAt), sqliteNullableTime(link.UpdatedAt), canonicalURL(link.URL), link.ID)
+	if err = sqliteConflictError(err); err != nil {
+		return link, err
+	}
+
+	if err = s.recordLinkEvent(ctx, tx, before, link); err != nil {
+		return link, err
+	}
+
+	return link, tx.Commit()
+}
+
+func (s *SQLiteStore) DeleteLink(ctx context.Context, orgID int, domainID int, code string, deleteClicks bool) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var link Link
+	err = tx.GetContext(ctx, &link, `SELECT `+linkColumns+` FROM links WHERE org_id = ? AND domain_id = ? AND code = ?`, orgID, domainID, code)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if link.DeletedAt != nil {
+		return ErrLinkDeleted
+	}
+
+	deleted := link
+	deletedAt := sqliteTime(time.Now())
+	deleted.DeletedAt = &deletedAt
+
+	_, err = tx.ExecContext(ctx, `UPDATE links SET deleted_at = ? WHERE id = ?`, deleted.DeletedAt, link.ID)
+	if err != nil {
+		return err
+	}
+
+	if err = s.recordLinkEvent(ctx, tx, link, deleted); err != nil {
+		return err
+	}
+
+	if deleteClicks {
+		for _, table := range clickTables {
+			_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE link_id = ?`, link.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) SetLinkTitle(ctx context.Context, linkID int, url string, title *string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE links SET title = ? WHERE id = ? AND url = ?`, title, linkID, url)
+	return err
+}
+
+func (s *SQLiteStore) PurgeExpiredLinks(ctx context.Context) ([]Link, error) {
+	now := sqliteTime(time.Now())
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, table := range linkTables {
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE link_id IN (SELECT id FROM links WHERE expires_at <= ? AND fallback_url IS NULL)`, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var purged []Link
+	err = tx.SelectContext(ctx, &purged, `DELETE FROM links WHERE expires_at <= ? AND fallback_url IS NULL RETURNING `+linkColumns, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return purged, tx.Commit()
+}
+
+func (s *SQLiteStore) ClaimLinkChecks(ctx context.Context, before time.Time, limit int) ([]Link, error) {
+	now := sqliteTime(time.Now())
+	query := `
+		UPDATE links SET checked_at = ?
+		WHERE id IN (
+			SELECT id FROM links
+			WHERE deleted_at IS NULL AND archived_at IS NULL AND (expires_at IS NULL OR expires_at > ?)
+				AND (checked_at IS NULL OR checked_at < ?)
+			ORDER BY checked_at
+			LIMIT ?
+		)
+		RETURNING ` + linkColumns
+
+	links := []Link{}
+	err := s.db.SelectContext(ctx, &links, query, now, now, sqliteTime(before), limit)
+
+	return links, err
+}
+
+func (s *SQLiteStore) SetLinkHealth(ctx context.Context, link Link) error {
+	query := `
+		UPDATE links SET checked_at = ?, check_status = ?, check_failures = ?, broken_since = ?
+		WHERE id = ? AND url = ?`
+
+	result, err := s.db.ExecContext(ctx, query, sqliteNullableTime(link.CheckedAt), link.CheckStatus, link.CheckFailures, sqliteNullableTime(link.BrokenSince), link.ID, link.URL)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) QuarantineLink(ctx context.Context, linkID int, url string, reason string) error {
+	applies := func(link Link) bool { return link.URL == url && !link.quarantined() }
+	_, err := s.moderateLink(ctx, linkID, applies, `quarantined_at = ?, quarantine_reason = ?`, sqliteTime(time.Now()), reason)
+
+	return err
+}
+
+func (s *SQLiteStore) ListQuarantinedLinks(ctx context.Context, confirmed bool, limit int, offset int) ([]Link, int, error) {
+	where := `quarantined_at IS NOT NULL AND quarantine_reviewed_at IS NULL AND deleted_at IS NULL`
+	if confirmed {
+		where = `quarantined_at IS NOT NULL AND quarantine_reviewed_at IS NOT NULL AND deleted_at IS NULL`
+	}
+
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM links WHERE `+where)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	links := []Link{}
+	query := `SELECT ` + linkColumns + ` FROM links WHERE ` + where + ` ORDER BY quarantined_at, id LIMIT ? OFFSET ?`
+	err = s.db.SelectContext(ctx, &links, query, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+func (s *SQLiteStore) ReleaseLink(ctx context.Context, linkID int) (Link, error) {
+	return s.moderateLink(ctx, linkID, Link.quarantined, `quarantined_at = NULL, quarantine_reason = NULL, quarantine_reviewed_at = NULL`)
+}
+
+func (s *SQLiteStore) ConfirmQuarantine(ctx context.Context, linkID int) (Link, error) {
+	return s.moderateLink(ctx, linkID, Link.quarantined, `quarantine_reviewed_at = ?`, sqliteTime(time.Now()))
+}
+
+// moderateLink applies assignments, whose placeholders are args, to the
+// link of linkID when applies to it, and records the change in the
+// history of the link. It fails with ErrNotFound otherwise.
+func (s *SQLiteStore) moderateLink(ctx context.Context, linkID int, applies func(link Link) bool, assignments string, args ...interface{}) (Link, error) {
+	var link Link
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return link, err
+	}
+	defer tx.Rollback()
+
+	var before Link
+	err = tx.GetContext(ctx, &before, `SELECT `+linkColumns+` FROM links WHERE id = ?`, linkID)
+	if err == sql.ErrNoRows || (err == nil && !applies(before)) {
+		return link, ErrNotFound
+	}
+	if err != nil {
+		return link, err
+	}
+
+	query := `UPDATE links SET ` + assignments + ` WHERE id = ? RETURNING ` + linkColumns
+	if err = tx.GetContext(ctx, &link, query, append(args, linkID)...); err != nil {
+		return link, err
+	}
+
+	if err = s.recordLinkEvent(ctx, tx, before, link); err != nil {
+		return link, err
+	}
+
+	return link, tx.Commit()
+}
+
+func (s *SQLiteStore) CreateLinkReport(ctx context.Context, report *LinkReport) (bool, error) {
+	query := `
+		INSERT INTO link_reports (link_id, reason, details, email, reporter_hash, created_at)
+		SELECT ?, ?, ?, ?, ?, ?
+		WHERE EXISTS (SELECT 1 FROM links WHERE id = ? AND reports_whitelisted_at IS NULL)
+		AND NOT EXISTS (SELECT 1 FROM link_reports WHERE link_id = ? AND reporter_hash = ? AND resolved_at IS NULL)
+		RETURNING id, created_at`
+
+	args := []interface{}{report.LinkID, report.Reason, report.Details, report.Email, report.ReporterHash, sqliteTime(time.Now()), report.LinkID, report.LinkID, report.ReporterHash}
+	err := s.db.QueryRowxContext(ctx, query, args...).Scan(&report.ID, &report.CreatedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *SQLiteStore) ListReportedLinks(ctx context.Context, limit int, offset int) ([]ReportedLink, int, error) {
+	var total int
+	err := s.db.GetContext(ctx, &total, `
+		SELECT COUNT(DISTINCT r.link_id) FROM link_reports r
+		JOIN links l ON l.id = r.link_id
+		WHERE r.resolved_at IS NULL AND l.deleted_at IS NULL`)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	links := []ReportedLink{}
+	query := `
+		SELECT ` + linkColumns + `, r.open_reports FROM links
+		JOIN (
+			SELECT link_id, COUNT(*) AS open_reports, MAX(id) AS last_report_id
+			FROM link_reports WHERE resolved_at IS NULL GROUP BY link_id
+		) r ON r.link_id = links.id
+		WHERE deleted_at IS NULL
+		ORDER BY r.open_reports DESC, r.last_report_id DESC
+		LIMIT ? OFFSET ?`
+	err = s.db.SelectContext(ctx, &links, query, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+func (s *SQLiteStore) LinkReports(ctx context.Context, linkID int) ([]LinkReport, error) {
+	reports := []LinkReport{}
+	err := s.db.SelectContext(ctx, &reports, `SELECT `+linkReportColumns+` FROM link_reports WHERE link_id = ? ORDER BY id DESC`, linkID)
+
+	return reports, err
+}
+
+func (s *SQLiteStore) ResolveLinkReports(ctx context.Context, linkID int, resolution string) (Link, error) {
+	var link Link
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return link, err
+	}
+	defer tx.Rollback()
+
+	now := sqliteTime(time.Now())
+	result, err := tx.ExecContext(ctx, `UPDATE link_reports SET resolved_at = ?, resolution = ? WHERE link_id = ? AND resolved_at IS NULL`, now, resolution, linkID)
+	if err != nil {
+		return link, err
+	}
+	resolved, err := result.RowsAffected()
+	if err != nil {
+		return link, err
+	}
+	if resolved == 0 {
+		return link, ErrNotFound
+	}
+
+	var before Link
+	if err = tx.GetContext(ctx, &before, `SELECT `+linkColumns+` FROM links WHERE id = ?`, linkID); err != nil {
+		return link, err
+	}
+
+	switch resolution {
+	case reportDisabled:
+		_, err = tx.ExecContext(ctx, `
+			UPDATE links SET quarantined_at = COALESCE(quarantined_at, ?), quarantine_reason = COALESCE(quarantine_reason, ?), quarantine_reviewed_at = ?
+			WHERE id = ?`, now, reportedQuarantineReason, now, linkID)
+	case reportWhitelisted:
+		_, err = tx.ExecContext(ctx, `UPDATE links SET reports_whitelisted_at = ? WHERE id = ?`, now, linkID)
+	}
+	if err != nil {
+		return link, err
+	}
+
+	if err = tx.GetContext(ctx, &link, `SELECT `+linkColumns+` FROM links WHERE id = ?`, linkID); err != nil {
+		return link, err
+	}
+
+	if err = s.recordLinkEvent(ctx, tx, before, link); err != nil {
+		return link, err
+	}
+
+	return link, tx.Commit()
+}
+
+func (s *SQLiteStore) ListAllLinks(ctx context.Context, filter AdminLinkFilter) ([]Link, int, error) {
+	var args []interface{}
+	bind := func(value interface{}) string {
+		if t, ok := value.(time.Time); ok {
+			value = sqliteTime(t)
+		}
+		args = append(args, value)
+		return "?"
+	}
+	where := adminLinkConditions(filter, "LIKE", bind)
+
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM links WHERE `+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM links
+		WHERE %s
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, linkColumns, where)
+
+	links := []Link{}
+	err = s.db.SelectContext(ctx, &links, query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+func (s *SQLiteStore) DisableLink(ctx context.Context, linkID int, reason string) (Link, error) {
+	now := sqliteTime(time.Now())
+	applies := func(link Link) bool { return link.DeletedAt == nil }
+	return s.moderateLink(ctx, linkID, applies, `quarantined_at = COALESCE(quarantined_at, ?), quarantine_reason = ?, quarantine_reviewed_at = ?`, now, reason, now)
+}
+
+func (s *SQLiteStore) KeyActivity(ctx context.Context, from time.Time, to time.Time, limit int) ([]KeyActivity, error) {
+	query := `
+		SELECT k.id AS key_id, k.org_id, o.slug AS org_slug, k.name, k.prefix, k.revoked_at,
+			COUNT(*) AS links, COALESCE(SUM(l.click_count), 0) AS clicks
+		FROM links l
+		JOIN api_keys k ON k.id = l.key_id
+		JOIN organizations o ON o.id = k.org_id
+		WHERE l.created_at >= ? AND l.created_at < ?
+		GROUP BY k.id, k.org_id, o.slug, k.name, k.prefix, k.revoked_at
+		ORDER BY links DESC, k.id
+		LIMIT ?`
+
+	keys := []KeyActivity{}
+	err := s.db.SelectContext(ctx, &keys, query, sqliteTime(from), sqliteTime(to), limit)
+
+	return keys, err
+}
+
+func (s *SQLiteStore) SetLinkTags(ctx context.Context, linkID int, tags []string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM link_tags WHERE link_id = ?`, linkID)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		_, err = tx.ExecContext(ctx, `INSERT INTO link_tags (link_id, tag) VALUES (?, ?)`, linkID, tag)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) LinkTags(ctx context.Context, linkIDs []int) (map[int][]string, error) {
+	if len(linkIDs) == 0 {
+		return map[int][]string{}, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(linkIDs)), ", ")
+	args := make([]interface{}, len(linkIDs))
+	for i, id := range linkIDs {
+		args[i] = id
+	}
+
+	var rows []linkTag
+	err := s.db.SelectContext(ctx, &rows, `SELECT link_id, tag FROM link_tags WHERE link_id IN (`+placeholders+`) ORDER BY link_id, tag`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupLinkTags(rows), nil
+}
+
+func (s *SQLiteStore) ListTags(ctx context.Context, orgID int) ([]TagCount, error) {
+	query := `
+		SELECT t.tag, COUNT(*) AS links, COALESCE(SUM(l.click_count), 0) AS clicks
+		FROM link_tags t
+		JOIN links l ON l.id = t.link_id
+		WHERE l.org_id = ? AND l.deleted_at IS NULL
+		GROUP BY t.tag
+		ORDER BY links DESC, t.tag
+	`
+
+	tags := []TagCount{}
+	err := s.db.SelectContext(ctx, &tags, query, orgID)
+
+	return tags, err
+}
+
+func (s *SQLiteStore) AddLinkAlias(ctx context.Context, alias *LinkAlias) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.GetContext(ctx, &exists, `SELECT EXISTS(SELECT 1 FROM links WHERE org_id = ? AND domain_id = ? AND code = ?)`, alias.OrgID, alias.DomainID, alias.Code)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrCodeTaken
+	}
+
+	query := `
+		INSERT INTO link_aliases (org_id, domain_id, link_id, code, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING id, org_id, domain_id, link_id, code, created_at
+	`
+	err = tx.GetContext(ctx, alias, query, alias.OrgID, alias.DomainID, alias.LinkID, alias.Code, sqliteTime(time.Now()))
+	if isSQLiteUniqueViolation(err) {
+		return ErrCodeTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = s.recordAliasEvent(ctx, tx, alias.LinkID, alias.Code, false); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) LinkAliases(ctx context.Context, linkID int) ([]LinkAlias, error) {
+	aliases := []LinkAlias{}
+	err := s.db.SelectContext(ctx, &aliases, `SELECT id, org_id, domain_id, link_id, code, created_at FROM link_aliases WHERE link_id = ? ORDER BY id`, linkID)
+
+	return aliases, err
+}
+
+func (s *SQLiteStore) DeleteLinkAlias(ctx context.Context, linkID int, code string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM link_aliases WHERE link_id = ? AND code = ?`, linkID, code)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+
+	if err = s.recordAliasEvent(ctx, tx, linkID, code, true); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) CreateFolder(ctx context.Context, folder *Folder) error {
+	query := `
+		INSERT INTO folders (org_id, parent_id, name, created_at)
+		VALUES (?, ?, ?, ?)
+		RETURNING ` + folderColumns
+
+	return s.db.GetContext(ctx, folder, query, folder.OrgID, folder.ParentID, folder.Name, sqliteTime(time.Now()))
+}
+
+func (s *SQLiteStore) GetFolder(ctx context.Context, orgID int, id int) (Folder, error) {
+	var folder Folder
+	err := s.db.GetContext(ctx, &folder, `SELECT `+folderColumns+` FROM folders WHERE org_id = ? AND id = ?`, orgID, id)
+	if err == sql.ErrNoRows {
+		return folder, ErrFolderNotFound
+	}
+
+	return folder, err
+}
+
+func (s *SQLiteStore) ListFolders(ctx context.Context, orgID int) ([]Folder, error) {
+	query := `
+		SELECT ` + folderColumns + `,
+			(SELECT COUNT(*) FROM links WHERE folder_id = folders.id AND deleted_at IS NULL) AS link_count
+		FROM folders
+		WHERE org_id = ?
+		ORDER BY name, id
+	`
+
+	folders := []Folder{}
+	err := s.db.SelectContext(ctx, &folders, query, orgID)
+
+	return folders, err
+}
+
+func (s *SQLiteStore) UpdateFolder(ctx context.Context, folder *Folder) error {
+	query := `
+		UPDATE folders SET parent_id = ?, name = ?, updated_at = ?
+		WHERE org_id = ? AND id = ?
+		RETURNING ` + folderColumns
+
+	err := s.db.GetContext(ctx, folder, query, folder.ParentID, folder.Name, sqliteTime(time.Now()), folder.OrgID, folder.ID)
+	if err == sql.ErrNoRows {
+		return ErrFolderNotFound
+	}
+
+	return err
+}
+
+func (s *SQLiteStore) DeleteFolder(ctx context.Context, orgID int, id int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var folder Folder
+	err = tx.GetContext(ctx, &folder, `SELECT `+folderColumns+` FROM folders WHERE org_id = ? AND id = ?`, orgID, id)
+	if err == sql.ErrNoRows {
+		return ErrFolderNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var contents int
+	query := `
+		SELECT (SELECT COUNT(*) FROM links WHERE folder_id = ? AND deleted_at IS NULL)
+			+ (SELECT COUNT(*) FROM folders WHERE parent_id = ?)
+	`
+	if err = tx.GetContext(ctx, &contents, query, id, id); err != nil {
+		return err
+	}
+	if contents > 0 {
+		return ErrFolderNotEmpty
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE links SET folder_id = NULL WHERE folder_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM folders WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) MoveLinks(ctx context.Context, orgID int, domainID int, codes []string, folderID *int) (int64, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(codes)), ", ")
+	args := []interface{}{folderID, sqliteTime(time.Now()), orgID, domainID}
+	for _, code := range codes {
+		args = append(args, code)
+	}
+	where := `org_id = ? AND domain_id = ? AND code IN (` + placeholders + `) AND deleted_at IS NULL`
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var links []Link
+	err = tx.SelectContext(ctx, &links, `SELECT `+linkColumns+` FROM links WHERE `+where+``, args[2:]...)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE links SET folder_id = ?, updated_at = ? WHERE `+where, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, link := range links {
+		moved := link
+		moved.FolderID = folderID
+		if err = s.recordLinkEvent(ctx, tx, link, moved); err != nil {
+			return 0, err
+		}
+	}
+
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return moved, tx.Commit()
+}
+
+// recordLinkEvent records the change of a link from before to after
+// within tx, unless no audited field changed.
+func (s *SQLiteStore) recordLinkEvent(ctx context.Context, tx *sqlx.Tx, before Link, after Link) error {
+	event, changed, err := newLinkEvent(ctx, before, after)
+	if err != nil || !changed {
+		return err
+	}
+
+	return s.insertLinkEvent(ctx, tx, event)
+}
+
+// recordAliasEvent records the addition of alias to the link of linkID,
+// or its removal, within tx.
+func (s *SQLiteStore) recordAliasEvent(ctx context.Context, tx *sqlx.Tx, linkID int, alias string, removed bool) error {
+	var link Link
+	if err := tx.GetContext(ctx, &link, `SELECT `+linkColumns+` FROM links WHERE id = ?`, linkID); err != nil {
+		return err
+	}
+
+	event, err := newAliasEvent(ctx, link, alias, removed)
+	if err != nil {
+		return err
+	}
+
+	return s.insertLinkEvent(ctx, tx, event)
+}
+
+func (s *SQLiteStore) insertLinkEvent(ctx context.Context, tx *sqlx.Tx, event LinkEvent) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO link_events (org_id, link_id, code, action, member_id, key_id, admin, changes, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, event.OrgID, event.LinkID, event.Code, event.Action, event.MemberID, event.KeyID, event.Admin, event.Changes, sqliteTime(event.CreatedAt))
+	return err
+}
+
+func (s *SQLiteStore) LinkHistory(ctx context.Context, linkID int, limit int, offset int) ([]LinkEvent, int, error) {
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM link_events WHERE link_id = ?`, linkID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	events := []LinkEvent{}
+	err = s.db.SelectContext(ctx, &events, `
+		SELECT `+linkEventColumns+` FROM link_events
+		WHERE link_id = ?
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, linkID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+func (s *SQLiteStore) ListLinkEvents(ctx context.Context, filter LinkEventFilter) ([]LinkEvent, int, error) {
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+	if filter.OrgID != nil {
+		conditions = append(conditions, "org_id = ?")
+		args = append(args, *filter.OrgID)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, sqliteTime(*filter.From))
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, sqliteTime(*filter.To))
+	}
+	where := strings.Join(conditions, " AND ")
+
+	var total int
+	err := s.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM link_events WHERE `+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	events := []LinkEvent{}
+	err = s.db.SelectContext(ctx, &events, `SELECT `+linkEventColumns+` FROM link_events WHERE `+where+` ORDER BY id DESC LIMIT ? OFFSET ?`, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+func (s *SQLiteStore) AddClicks(ctx context.Context, batch ClickBatch) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	clickCount := tx.StmtxContext(ctx, s.clickCountStmt)
+	dailyClicks := tx.StmtxContext(ctx, s.dailyClicksStmt)
+
+	for _, count := range batch.Daily {
+		_, err = clickCount.ExecContext(ctx, count.Clicks, count.LinkID)
+		if err != nil {
+			return fmt.Errorf("updating click count: %w", err)
+		}
+
+		_, err = dailyClicks.ExecContext(ctx, count.LinkID, count.Clicks, count.Date)
+		if err != nil {
+			return fmt.Errorf("inserting/updating click count: %w", err)
+		}
+	}
+
+	for _, count := range batch.Bots {
+		_, err = tx.ExecContext(ctx, `UPDATE links SET bot_clicks = bot_clicks + ? WHERE id = ?`, count.Clicks, count.LinkID)
+		if err != nil {
+			return fmt.Errorf("updating bot click count: %w", err)
+		}
+	}
+
+	for _, count := range batch.Referrers {
+		referrersQuery := `
+			INSERT INTO link_referrers (link_id, referrer, clicks)
+			VALUES (?, ?, ?)
+			ON CONFLICT (link_id, referrer)
+			DO UPDATE SET clicks = link_referrers.clicks + excluded.clicks
+		`
+		_, err = tx.ExecContext(ctx, referrersQuery, count.LinkID, count.Referrer, count.Clicks)
+		if err != nil {
+			return fmt.Errorf("inserting/updating referrer count: %w", err)
+		}
+	}
+
+	for _, count := range batch.Countries {
+		countriesQuery := `
+			INSERT INTO link_countries (link_id, country, clicks)
+			VALUES (?, ?, ?)
+			ON CONFLICT (link_id, country)
+			DO UPDATE SET clicks = link_countries.clicks + excluded.clicks
+		`
+		_, err = tx.ExecContext(ctx, countriesQuery, count.LinkID, count.Country, count.Clicks)
+		if err != nil {
+			return fmt.Errorf("inserting/updating country count: %w", err)
+		}
+	}
+
+	for _, count := range batch.Destinations {
+		destinationsQuery := `
+			INSERT INTO link_destination_clicks (link_id, destination_id, clicks)
+			VALUES (?, ?, ?)
+			ON CONFLICT (link_id, destination_id)
+			DO UPDATE SET clicks = link_destination_clicks.clicks + excluded.clicks
+		`
+		_, err = tx.ExecContext(ctx, destinationsQuery, count.LinkID, count.DestinationID, count.Clicks)
+		if err != nil {
+			return fmt.Errorf("inserting/updating destination count: %w", err)
+		}
+	}
+
+	for _, count := range batch.Codes {
+		codesQuery := `
+			INSERT INTO link_code_clicks (link_id, code, clicks)
+			VALUES (?, ?, ?)
+			ON CONFLICT (link_id, code)
+			DO UPDATE SET clicks = link_code_clicks.clicks + excluded.clicks
+		`
+		_, err = tx.ExecContext(ctx, codesQuery, count.LinkID, count.Code, count.Clicks)
+		if err != nil {
+			return fmt.Errorf("inserting/updating code count: %w", err)
+		}
+	}
+
+	for _, count := range batch.Devices {
+		devicesQuery := `
+			INSERT INTO link_devices (link_id, device_type, browser, os, clicks)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (link_id, device_type, browser, os)
+			DO UPDATE SET clicks = link_devices.clicks + excluded.clicks
+		`
+		_, err = tx.ExecContext(ctx, devicesQuery, count.LinkID, count.DeviceType, count.Browser, count.OS, count.Clicks)
+		if err != nil {
+			return fmt.Errorf("inserting/updating device count: %w", err)
+		}
+	}
+
+	for _, event := range batch.Events {
+		eventsQuery := `
+			INSERT INTO click_events (link_id, clicked_at, country, referrer_hash, ip_hash, device_type)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`
+		_, err = tx.ExecContext(ctx, eventsQuery, event.LinkID, sqliteTime(event.ClickedAt), event.Country, event.ReferrerHash, event.IPHash, event.DeviceType)
+		if err != nil {
+			return fmt.Errorf("inserting click events: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) ClickEvents(ctx context.Context, linkID int, filter ClickEventFilter) ([]ClickEvent, error) {
+	conditions := []string{"link_id = ?"}
+	args := []interface{}{linkID}
+
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "clicked_at >= ?")
+		args = append(args, sqliteTime(filter.From))
+	}
+
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "clicked_at < ?")
+		args = append(args, sqliteTime(filter.To))
+	}
+
+	if filter.Before != 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.Before)
+	}
+
+	query := `
+		SELECT ` + clickEventColumns + `
+		FROM click_events
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY id DESC
+		LIMIT ?
+	`
+
+	events := []ClickEvent{}
+	err := s.db.SelectContext(ctx, &events, query, append(args, filter.Limit)...)
+
+	return events, err
+}
+
+func (s *SQLiteStore) PurgeClickEvents(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM click_events WHERE clicked_at < ?`, sqliteTime(before))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SQLiteStore) EraseLinkClicks(ctx context.Context, linkID int) (int64, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var events int64
+	err = tx.GetContext(ctx, &events, `SELECT COUNT(*) FROM click_events WHERE link_id = ?`, linkID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, table := range clickTables {
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE link_id = ?`, linkID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE links SET click_count = 0, bot_clicks = 0 WHERE id = ?`, linkID)
+	if err != nil {
+		return 0, err
+	}
+
+	return events, tx.Commit()
+}
+
+func (s *SQLiteStore) EraseVisitorClicks(ctx context.Context, ipHash string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM click_events WHERE ip_hash = ?`, ipHash)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+var sqliteClickBuckets = map[string]string{
+	"day":   `date`,
+	"week":  `date(date, 'weekday 0', '-6 days')`,
+	"month": `strftime('%Y-%m-01', date)`,
+}
+
+func (s *SQLiteStore) ClickTimeSeries(ctx context.Context, linkID int, filter ClickSeriesFilter) ([]ClickBucket, error) {
+	bucket, ok := sqliteClickBuckets[filter.Granularity]
+	if !ok {
+		return nil, fmt.Errorf("cannot aggregate clicks by %q", filter.Granularity)
+	}
+
+	conditions := []string{"link_id = ?"}
+	args := []interface{}{linkID}
+
+	if filter.From != "" {
+		conditions = append(conditions, "date >= ?")
+		args = append(args, filter.From)
+	}
+
+	if filter.To != "" {
+		conditions = append(conditions, "date <= ?")
+		args = append(args, filter.To)
+	}
+
+	rollups := ""
+	if table, ok := clickRollupTables[filter.Granularity]; ok {
+		var rolledUpTo sql.NullString
+		err := s.db.GetContext(ctx, &rolledUpTo, `SELECT rolled_up_to FROM click_rollups WHERE granularity = ?`, filter.Granularity)
+		if err != nil {
+			return nil, err
+		}
+
+		// The days of the span are read from the rollups, the others are
+		// summed from clicks.
+		if from, to, ok := clickRollupSpan(filter, rolledUpTo.String); ok {
+			rollupConditions := []string{"link_id = ?", "date < ?"}
+			rollupArgs := []interface{}{linkID, to}
+			outside := "date >= ?"
+			args = append(args, to)
+
+			if from != "" {
+				rollupConditions = append(rollupConditions, "date >= ?")
+				rollupArgs = append(rollupArgs, from)
+				outside = "(date >= ? OR date < ?)"
+				args = append(args, from)
+			}
+
+			conditions = append(conditions, outside)
+			args = append(args, rollupArgs...)
+			rollups = `
+		UNION ALL
+		SELECT date, clicks
+		FROM ` + table + `
+		WHERE ` + strings.Join(rollupConditions, " AND ")
+		}
+	}
+
+	query := `
+		SELECT ` + bucket + ` AS date, SUM(clicks) AS clicks
+		FROM clicks
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		GROUP BY 1` + rollups + `
+		ORDER BY 1
+	`
+
+	series := []ClickBucket{}
+	err := s.db.SelectContext(ctx, &series, query, args...)
+
+	return series, err
+}
+
+func (s *SQLiteStore) RollupClicks(ctx context.Context, granularity string, today time.Time) error {
+	table, ok := clickRollupTables[granularity]
+	if !ok {
+		return fmt.Errorf("cannot roll up clicks by %q", granularity)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var rolledUpTo sql.NullString
+	err = tx.GetContext(ctx, &rolledUpTo, `SELECT rolled_up_to FROM click_rollups WHERE granularity = ?`, granularity)
+	if err != nil {
+		return err
+	}
+
+	from, to := clickRollupWindow(granularity, rolledUpTo.String, today)
+	window, args := "date < ?", []interface{}{to}
+	if from != "" {
+		window, args = "date < ? AND date >= ?", append(args, from)
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE `+window, args...)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO ` + table + ` (link_id, date, clicks)
+		SELECT link_id, ` + sqliteClickBuckets[granularity] + `, SUM(clicks)
+		FROM clicks
+		WHERE ` + window + `
+		GROUP BY 1, 2
+	`
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE click_rollups SET rolled_up_to = ? WHERE granularity = ?`, to, granularity)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateClickPartitions does nothing, as SQLite has no partitioning.
+func (s *SQLiteStore) CreateClickPartitions(ctx context.Context, month time.Time) error {
+	return nil
+}
+
+func (s *SQLiteStore) TopReferrers(ctx context.Context, linkID int, limit int) ([]ReferrerCount, error) {
+	query := `
+		SELECT link_id, referrer, clicks
+		FROM link_referrers
+		WHERE link_id = ?
+		ORDER BY clicks DESC, referrer
+		LIMIT ?
+	`
+
+	referrers := []ReferrerCount{}
+	err := s.db.SelectContext(ctx, &referrers, query, linkID, limit)
+
+	return referrers, err
+}
+
+func (s *SQLiteStore) CountryClicks(ctx context.Context, linkID int) ([]CountryCount, error) {
+	query := `
+		SELECT link_id, country, clicks
+		FROM link_countries
+		WHERE link_id = ?
+		ORDER BY clicks DESC, country
+	`
+
+	countries := []CountryCount{}
+	err := s.db.SelectContext(ctx, &countries, query, linkID)
+
+	return countries, err
+}
+
+func (s *SQLiteStore) DestinationClicks(ctx context.Context, linkID int) ([]DestinationCount, error) {
+	query := `
+		SELECT link_id, destination_id, clicks
+		FROM link_destination_clicks
+		WHERE link_id = ?
+		ORDER BY destination_id
+	`
+
+	destinations := []DestinationCount{}
+	err := s.db.SelectContext(ctx, &destinations, query, linkID)
+
+	return destinations, err
+}
+
+func (s *SQLiteStore) CodeClicks(ctx context.Context, linkID int) ([]CodeCount, error) {
+	query := `
+		SELECT link_id, code, clicks
+		FROM link_code_clicks
+		WHERE link_id = ?
+		ORDER BY clicks DESC, code
+	`
+
+	codes := []CodeCount{}
+	err := s.db.SelectContext(ctx, &codes, query, linkID)
+
+	return codes, err
+}
+
+func (s *SQLiteStore) DeviceClicks(ctx context.Context, linkID int) ([]DeviceCount, error) {
+	query := `
+		SELECT link_id, device_type, browser, os, clicks
+		FROM link_devices
+		WHERE link_id = ?
+		ORDER BY clicks DESC, device_type, browser, os
+	`
+
+	devices := []DeviceCount{}
+	err := s.db.SelectContext(ctx, &devices, query, linkID)
+
+	return devices, err
+}
+
+// ExportClicks pages through the daily clicks by link and date so that no
+// connection is held while fn writes to a slow client.
+func (s *SQLiteStore) ExportClicks(ctx context.Context, filter ClickExportFilter, fn func(click ClickExport) error) error {
+	conditions := []string{"links.org_id = ?"}
+	args := []interface{}{filter.OrgID}
+
+	if filter.LinkID != 0 {
+		conditions = append(conditions, "clicks.link_id = ?")
+		args = append(args, filter.LinkID)
+	} else {
+		conditions = append(conditions, "links.deleted_at IS NULL")
+	}
+
+	if filter.From != "" {
+		conditions = append(conditions, "clicks.date >= ?")
+		args = append(args, filter.From)
+	}
+
+	if filter.To != "" {
+		conditions = append(conditions, "clicks.date <= ?")
+		args = append(args, filter.To)
+	}
+
+	query := `
+		SELECT clicks.link_id, links.code, clicks.date AS date, clicks.clicks
+		FROM clicks
+		JOIN links ON links.id = clicks.link_id
+		WHERE ` + strings.Join(conditions, " AND ") + ` AND (clicks.link_id, clicks.date) > (?, ?)
+		ORDER BY clicks.link_id, clicks.date
+		LIMIT ?
+	`
+
+	lastLinkID, lastDate := 0, exportStartDate
+	for {
+		var page []ClickExport
+		err := s.db.SelectContext(ctx, &page, query, append(args, lastLinkID, lastDate, exportPageSize)...)
+		if err != nil {
+			return err
+		}
+
+		for _, click := range page {
+			if err := fn(click); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < exportPageSize {
+			return nil
+		}
+		lastLinkID, lastDate = page[len(page)-1].LinkID, page[len(page)-1].Date
+	}
+}
+
+func (s *SQLiteStore) CreateOrganization(ctx context.Context, org *Organization, owner *Member, key *APIKey, hash string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO organizations (slug, name, created_at)
+		VALUES (?, ?, ?)
+		RETURNING ` + organizationColumns
+
+	err = tx.GetContext(ctx, org, query, org.Slug, org.Name, sqliteTime(time.Now()))
+	if isSQLiteUniqueViolation(err) {
+		return ErrSlugTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO organization_members (org_id, email, role, created_at)
+		VALUES (?, ?, ?, ?)
+		RETURNING ` + memberColumns
+
+	err = tx.GetContext(ctx, owner, query, org.ID, owner.Email, owner.Role, sqliteTime(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO api_keys (org_id, member_id, name, prefix, key_hash, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING ` + apiKeyColumns
+
+	err = tx.GetContext(ctx, key, query, org.ID, owner.ID, key.Name, key.Prefix, hash, sqliteTime(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) GetOrganization(ctx context.Context, id int) (Organization, error) {
+	var org Organization
+	err := s.db.GetContext(ctx, &org, `SELECT `+organizationColumns+` FROM organizations WHERE id = ?`, id)
+	if err == sql.ErrNoRows {
+		return org, ErrOrgNotFound
+	}
+
+	return org, err
+}
+
+func (s *SQLiteStore) GetOrganizationBySlug(ctx context.Context, slug string) (Organization, error) {
+	var org Organization
+	err := s.db.GetContext(ctx, &org, `SELECT `+organizationColumns+` FROM organizations WHERE slug = ?`, slug)
+	if err == sql.ErrNoRows {
+		return org, ErrOrgNotFound
+	}
+
+	return org, err
+}
+
+func (s *SQLiteStore) AddMember(ctx context.Context, member *Member) error {
+	query := `
+		INSERT INTO organization_members (org_id, email, role, created_at)
+		VALUES (?, ?, ?, ?)
+		RETURNING ` + memberColumns
+
+	err := s.db.GetContext(ctx, member, query, member.OrgID, member.Email, member.Role, sqliteTime(time.Now()))
+	if isSQLiteUniqueViolation(err) {
+		return ErrMemberExists
+	}
+
+	return err
+}
+
+func (s *SQLiteStore) GetMember(ctx context.Context, orgID int, memberID int) (Member, error) {
+	var member Member
+	err := s.db.GetContext(ctx, &member, `SELECT `+memberColumns+` FROM organization_members WHERE org_id = ? AND id = ?`, orgID, memberID)
+	if err == sql.ErrNoRows {
+		return member, ErrMemberNotFound
+	}
+
+	return member, err
+}
+
+func (s *SQLiteStore) ListMembers(ctx context.Context, orgID int) ([]Member, error) {
+	members := []Member{}
+	err := s.db.SelectContext(ctx, &members, `SELECT `+memberColumns+` FROM organization_members WHERE org_id = ? ORDER BY id`, orgID)
+
+	return members, err
+}
+
+func (s *SQLiteStore) RemoveMember(ctx context.Context, orgID int, memberID int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM api_key_usage WHERE api_key_id IN (SELECT id FROM api_keys WHERE org_id = ? AND member_id = ?)`, orgID, memberID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM api_keys WHERE org_id = ? AND member_id = ?`, orgID, memberID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM member_identities WHERE member_id IN (SELECT id FROM organization_members WHERE org_id = ? AND id = ?)`, orgID, memberID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM member_usage WHERE member_id IN (SELECT id FROM organization_members WHERE org_id = ? AND id = ?)`, orgID, memberID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM organization_members WHERE org_id = ? AND id = ?`, orgID, memberID)
+	if err != nil {
+		return err
+	}
+
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrMemberNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) CreateAPIKey(ctx context.Context, key *APIKey, hash string) error {
+	query := `
+		INSERT INTO api_keys (org_id, member_id, name, prefix, key_hash, scopes, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING ` + apiKeyColumns
+
+	return s.db.GetContext(ctx, key, query, key.OrgID, key.MemberID, key.Name, key.Prefix, hash, key.Scopes, sqliteNullableTime(key.ExpiresAt), sqliteTime(time.Now()))
+}
+
+func (s *SQLiteStore) GetAPIKey(ctx context.Context, orgID int, keyID int) (APIKey, error) {
+	var key APIKey
+	err := s.db.GetContext(ctx, &key, `SELECT `+apiKeyColumns+` FROM api_keys WHERE org_id = ? AND id = ?`, orgID, keyID)
+	if err == sql.ErrNoRows {
+		return key, ErrAPIKeyNotFound
+	}
+
+	return key, err
+}
+
+func (s *SQLiteStore) RotateAPIKey(ctx context.Context, old *APIKey, key *APIKey, hash string, graceUntil time.Time) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO api_keys (org_id, member_id, name, prefix, key_hash, scopes, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING ` + apiKeyColumns
+
+	err = tx.GetContext(ctx, key, query, key.OrgID, key.MemberID, key.Name, key.Prefix, hash, key.Scopes, sqliteNullableTime(key.ExpiresAt), sqliteTime(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE api_keys SET expires_at = ?, replaced_by = ?
+		WHERE org_id = ? AND id = ? AND revoked_at IS NULL AND replaced_by IS NULL
+		RETURNING ` + apiKeyColumns
+
+	err = tx.GetContext(ctx, old, query, sqliteTime(graceUntil), key.ID, old.OrgID, old.ID)
+	if err == sql.ErrNoRows {
+		return ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// The new key takes over the usage and links of the old one, and the
+	// keys the old one replaced, so that rotating resets neither its quota
+	// nor the links its shortens are deduplicated against.
+	_, err = tx.ExecContext(ctx, `UPDATE api_key_usage SET api_key_id = ? WHERE api_key_id = ?`, key.ID, old.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE links SET key_id = ? WHERE org_id = ? AND key_id = ?`, key.ID, old.OrgID, old.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE api_keys SET replaced_by = ? WHERE org_id = ? AND replaced_by = ?`, key.ID, old.OrgID, old.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) SetAPIKeySigningSecret(ctx context.Context, orgID int, keyID int, secret *string) error {
+	query := `UPDATE api_keys SET signing_secret = ? WHERE org_id = ? AND id = ? AND revoked_at IS NULL`
+
+	result, err := s.db.ExecContext(ctx, query, secret, orgID, keyID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) AuthenticateSigningKey(ctx context.Context, keyID int) (APIKey, Member, error) {
+	var key APIKey
+	var member Member
+
+	err := s.db.GetContext(ctx, &key, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ? AND revoked_at IS NULL AND signing_secret IS NOT NULL`, keyID)
+	if err == sql.ErrNoRows {
+		return key, member, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return key, member, err
+	}
+
+	member, err = s.GetMember(ctx, key.OrgID, key.MemberID)
+	if err == ErrMemberNotFound {
+		return key, member, ErrAPIKeyNotFound
+	}
+
+	return key, member, err
+}
+
+func (s *SQLiteStore) TouchAPIKey(ctx context.Context, keyID int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = ? WHERE id = ?`, sqliteTime(time.Now()), keyID)
+	return err
+}
+
+func (s *SQLiteStore) ListAPIKeys(ctx context.Context, orgID int) ([]APIKey, error) {
+	keys := []APIKey{}
+	err := s.db.SelectContext(ctx, &keys, `SELECT `+apiKeyColumns+` FROM api_keys WHERE org_id = ? ORDER BY id`, orgID)
+
+	return keys, err
+}
+
+func (s *SQLiteStore) RevokeAPIKey(ctx context.Context, orgID int, keyID int) error {
+	query := `UPDATE api_keys SET revoked_at = ? WHERE org_id = ? AND id = ? AND revoked_at IS NULL`
+
+	result, err := s.db.ExecContext(ctx, query, sqliteTime(time.Now()), orgID, keyID)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) IdentityMembers(ctx context.Context, provider string, subject string, email string) ([]Member, error) {
+	query := `
+		SELECT ` + memberColumns + ` FROM organization_members m
+		WHERE m.id IN (SELECT member_id FROM member_identities WHERE provider = ? AND subject = ?)
+		   OR (? <> '' AND LOWER(m.email) = LOWER(?)
+		       AND NOT EXISTS (SELECT 1 FROM member_identities i WHERE i.member_id = m.id AND i.provider = ?))
+		ORDER BY m.id
+	`
+
+	members := []Member{}
+	err := s.db.SelectContext(ctx, &members, query, provider, subject, email, email, provider)
+
+	return members, err
+}
+
+func (s *SQLiteStore) LinkIdentity(ctx context.Context, memberID int, provider string, subject string) error {
+	query := `
+		INSERT INTO member_identities (member_id, provider, subject, created_at, last_login_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (member_id, provider) DO UPDATE SET subject = excluded.subject, last_login_at = excluded.last_login_at
+	`
+
+	now := sqliteTime(time.Now())
+	_, err := s.db.ExecContext(ctx, query, memberID, provider, subject, now, now)
+	return err
+}
+
+func (s *SQLiteStore) AuthenticateAPIKey(ctx context.Context, hash string) (APIKey, Member, error) {
+	var key APIKey
+	var member Member
+
+	err := s.db.GetContext(ctx, &key, `SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL`, hash)
+	if err == sql.ErrNoRows {
+		return key, member, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return key, member, err
+	}
+
+	member, err = s.GetMember(ctx, key.OrgID, key.MemberID)
+	if err == ErrMemberNotFound {
+		return key, member, ErrAPIKeyNotFound
+	}
+
+	return key, member, err
+}
+
+func (s *SQLiteStore) CreateDomain(ctx context.Context, domain *CustomDomain) error {
+	query := `
+		INSERT INTO domains (org_id, hostname, verification_token, created_at)
+		SELECT ?, ?, ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM domains WHERE hostname = ? AND verified_at IS NOT NULL)
+		RETURNING ` + customDomainColumns
+
+	err := s.db.GetContext(ctx, domain, query, domain.OrgID, domain.Hostname, domain.VerificationToken, sqliteTime(time.Now()), domain.Hostname)
+	if err == sql.ErrNoRows || isSQLiteUniqueViolation(err) {
+		return ErrDomainTaken
+	}
+
+	return err
+}
+
+func (s *SQLiteStore) GetDomain(ctx context.Context, orgID int, id int) (CustomDomain, error) {
+	var domain CustomDomain
+	err := s.db.GetContext(ctx, &domain, `SELECT `+customDomainColumns+` FROM domains WHERE org_id = ? AND id = ?`, orgID, id)
+	if err == sql.ErrNoRows {
+		return domain, ErrDomainNotFound
+	}
+
+	return domain, err
+}
+
+func (s *SQLiteStore) GetDomainByHostname(ctx context.Context, hostname string) (CustomDomain, error) {
+	var domain CustomDomain
+	err := s.db.GetContext(ctx, &domain, `SELECT `+customDomainColumns+` FROM domains WHERE hostname = ? AND verified_at IS NOT NULL`, hostname)
+	if err == sql.ErrNoRows {
+		return domain, ErrDomainNotFound
+	}
+
+	return domain, err
+}
+
+func (s *SQLiteStore) ListDomainsByHostname(ctx context.Context, hostname string) ([]CustomDomain, error) {
+	domains := []CustomDomain{}
+	err := s.db.SelectContext(ctx, &domains, `SELECT `+customDomainColumns+` FROM domains WHERE hostname = ? ORDER BY id`, hostname)
+
+	return domains, err
+}
+
+func (s *SQLiteStore) ListDomains(ctx context.Context, orgID int) ([]CustomDomain, error) {
+	domains := []CustomDomain{}
+	err := s.db.SelectContext(ctx, &domains, `SELECT `+customDomainColumns+` FROM domains WHERE org_id = ? ORDER BY hostname`, orgID)
+
+	return domains, err
+}
+
+func (s *SQLiteStore) VerifyDomain(ctx context.Context, domain *CustomDomain) error {
+	query := `
+		UPDATE domains SET verified_at = ?
+		WHERE org_id = ? AND id = ?
+		RETURNING ` + customDomainColumns
+
+	err := s.db.GetContext(ctx, domain, query, sqliteTime(time.Now()), domain.OrgID, domain.ID)
+	if err == sql.ErrNoRows {
+		return ErrDomainNotFound
+	}
+	if isSQLiteUniqueViolation(err) {
+		return ErrDomainTaken
+	}
+
+	return err
+}
+
+func (s *SQLiteStore) DeleteDomain(ctx context.Context, orgID int, id int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var domain CustomDomain
+	err = tx.GetContext(ctx, &domain, `SELECT `+customDomainColumns+` FROM domains WHERE org_id = ? AND id = ?`, orgID, id)
+	if err == sql.ErrNoRows {
+		return ErrDomainNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var inUse bool
+	err = tx.GetContext(ctx, &inUse, `SELECT EXISTS(SELECT 1 FROM links WHERE org_id = ? AND domain_id = ?)`, orgID, id)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrDomainInUse
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM domains WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) ConsumeUsage(ctx context.Context, meter UsageMeter, month string, metric string, n int, limit int) (int, bool, error) {
+	if !usageMetrics[metric] {
+		return 0, false, fmt.Errorf("unknown usage metric %q", metric)
+	}
+
+	if limit > 0 && n > limit {
+		usage, err := s.MeterUsage(ctx, meter, month)
+		return usageOf(usage, metric), false, err
+	}
+
+	table, column, id := meter.usageTable()
+	query := fmt.Sprintf(`
+		INSERT INTO %[2]s (%[3]s, month, %[1]s)
+		VALUES (?, ?, ?)
+		ON CONFLICT (%[3]s, month)
+		DO UPDATE SET %[1]s = %[2]s.%[1]s + excluded.%[1]s
+		WHERE ? = 0 OR %[2]s.%[1]s + excluded.%[1]s <= ?
+		RETURNING %[1]s
+	`, metric, table, column)
+
+	var used int
+	err := s.db.GetContext(ctx, &used, query, id, month, n, limit, limit)
+	if err == sql.ErrNoRows {
+		usage, err := s.MeterUsage(ctx, meter, month)
+		return usageOf(usage, metric), false, err
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return used, true, nil
+}
+
+func (s *SQLiteStore) MeterUsage(ctx context.Context, meter UsageMeter, month string) (Usage, error) {
+	table, column, id := meter.usageTable()
+
+	var usage Usage
+	err := s.db.GetContext(ctx, &usage, `SELECT shortens, redirects FROM `+table+` WHERE `+column+` = ? AND month = ?`, id, month)
+	if err == sql.ErrNoRows {
+		return usage, nil
+	}
+
+	return usage, err
+}
+
+func (s *SQLiteStore) Stats(ctx context.Context, filter StatsFilter) (Stats, error) {
+	var stats Stats
+	day, week, month := statsWindows(filter.Today)
+
+	scope, args := "", []interface{}{sqliteTime(day), sqliteTime(week), sqliteTime(month)}
+	if !filter.AllOrgs {
+		scope, args = ` AND org_id = ?`, append(args, filter.OrgID)
+	}
+	if filter.Tag != "" {
+		scope, args = scope+` AND id IN (SELECT link_id FROM link_tags WHERE tag = ?)`, append(args, filter.Tag)
+	}
+
+	query := `
+		SELECT
+			COUNT(*) AS links,
+			COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0) AS created_today,
+			COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0) AS created_7_days,
+			COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0) AS created_30_days
+		FROM links
+		WHERE deleted_at IS NULL` + scope
+
+	err := s.db.GetContext(ctx, &stats, query, args...)
+	if err != nil {
+		return stats, err
+	}
+
+	scope, args = "", []interface{}{day.Format("2006-01-02"), week.Format("2006-01-02"), month.Format("2006-01-02")}
+	if !filter.AllOrgs {
+		scope, args = ` AND l.org_id = ?`, append(args, filter.OrgID)
+	}
+	if filter.Tag != "" {
+		scope, args = scope+` AND l.id IN (SELECT link_id FROM link_tags WHERE tag = ?)`, append(args, filter.Tag)
+	}
+
+	query = `
+		SELECT
+			COALESCE(SUM(CASE WHEN c.date >= ? THEN c.clicks ELSE 0 END), 0) AS clicks_today,
+			COALESCE(SUM(CASE WHEN c.date >= ? THEN c.clicks ELSE 0 END), 0) AS clicks_7_days,
+			COALESCE(SUM(c.clicks), 0) AS clicks_30_days
+		FROM clicks c
+		JOIN links l ON l.id = c.link_id
+		WHERE c.date >= ? AND l.deleted_at IS NULL` + scope
+
+	err = s.db.GetContext(ctx, &stats, query, args...)
+	if err != nil {
+		return stats, err
+	}
+
+	scope, args = "", nil
+	if !filter.AllOrgs {
+		scope, args = ` AND org_id = ?`, append(args, filter.OrgID)
+	}
+	if filter.Tag != "" {
+		scope, args = scope+` AND id IN (SELECT link_id FROM link_tags WHERE tag = ?)`, append(args, filter.Tag)
+	}
+
+	query = `
+		SELECT ` + linkColumns + `
+		FROM links
+		WHERE deleted_at IS NULL` + scope + `
+		ORDER BY click_count DESC, id
+		LIMIT ?
+	`
+
+	stats.TopLinks = []Link{}
+	err = s.db.SelectContext(ctx, &stats.TopLinks, query, append(args, filter.TopLimit)...)
+
+	return stats, err
+}
+
+func (s *SQLiteStore) TrendingLinks(ctx context.Context, filter TrendingFilter) ([]TrendingLink, int, error) {
+	var total int
+	query := `
+		SELECT COUNT(DISTINCT c.link_id)
+		FROM clicks c
+		JOIN links l ON l.id = c.link_id
+		WHERE c.date >= ? AND l.org_id = ? AND l.deleted_at IS NULL
+	`
+
+	err := s.db.GetContext(ctx, &total, query, filter.From, filter.OrgID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query = `
+		SELECT ` + linkColumns + `, w.clicks AS window_clicks
+		FROM (
+			SELECT link_id, SUM(clicks) AS clicks
+			FROM clicks
+			WHERE date >= ?
+			GROUP BY link_id
+		) w
+		JOIN links ON links.id = w.link_id
+		WHERE org_id = ? AND deleted_at IS NULL
+		ORDER BY w.clicks DESC, id
+		LIMIT ? OFFSET ?
+	`
+
+	links := []TrendingLink{}
+	err = s.db.SelectContext(ctx, &links, query, filter.From, filter.OrgID, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, total, nil
+}
+
+func (s *SQLiteStore) CreateWebhook(ctx context.Context, hook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (org_id, url, events, secret, active, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING ` + webhookColumns
+
+	return s.db.GetContext(ctx, hook, query, hook.OrgID, hook.URL, hook.Events, hook.Secret, hook.Active, sqliteTime(time.Now()))
+}
+
+func (s *SQLiteStore) GetWebhook(ctx context.Context, orgID int, id int) (Webhook, error) {
+	var hook Webhook
+	err := s.db.GetContext(ctx, &hook, `SELECT `+webhookColumns+` FROM webhooks WHERE org_id = ? AND id = ?`, orgID, id)
+	if err == sql.ErrNoRows {
+		return hook, ErrWebhookNotFound
+	}
+
+	return hook, err
+}
+
+func (s *SQLiteStore) ListWebhooks(ctx context.Context, orgID int) ([]Webhook, error) {
+	hooks := []Webhook{}
+	err := s.db.SelectContext(ctx, &hooks, `SELECT `+webhookColumns+` FROM webhooks WHERE org_id = ? ORDER BY id`, orgID)
+
+	return hooks, err
+}
+
+func (s *SQLiteStore) UpdateWebhook(ctx context.Context, hook *Webhook) error {
+	query := `
+		UPDATE webhooks SET url = ?, events = ?, active = ?, updated_at = ?
+		WHERE org_id = ? AND id = ?
+		RETURNING ` + webhookColumns
+
+	err := s.db.GetContext(ctx, hook, query, hook.URL, hook.Events, hook.Active, sqliteTime(time.Now()), hook.OrgID, hook.ID)
+	if err == sql.ErrNoRows {
+		return ErrWebhookNotFound
+	}
+
+	return err
+}
+
+func (s *SQLiteStore) DeleteWebhook(ctx context.Context, orgID int, id int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE org_id = ? AND id = ?)`, orgID, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM webhooks WHERE org_id = ? AND id = ?`, orgID, id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrWebhookNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) EnqueueDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	now := sqliteTime(time.Now())
+
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, attempts, next_attempt_at, created_at)
+		VALUES (?, ?, ?, 0, ?, ?)
+		RETURNING id, created_at
+	`
+
+	return s.db.QueryRowxContext(ctx, query, delivery.WebhookID, delivery.Event, delivery.Payload, now, now).Scan(&delivery.ID, &delivery.CreatedAt)
+}
+
+func (s *SQLiteStore) ClaimDeliveries(ctx context.Context, token string, leaseUntil time.Time, limit int) ([]WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries SET claim_token = ?, next_attempt_at = ?
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE next_attempt_at <= ?
+			ORDER BY next_attempt_at
+			LIMIT ?
+		)
+	`
+
+	_, err := s.db.ExecContext(ctx, query, token, sqliteTime(leaseUntil), sqliteTime(time.Now()), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT ` + claimedDeliveryColumns + `
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.claim_token = ?
+		ORDER BY d.id
+	`
+
+	deliveries := []WebhookDelivery{}
+	err = s.db.SelectContext(ctx, &deliveries, query, token)
+
+	return deliveries, err
+}
+
+func (s *SQLiteStore) UpdateDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET attempts = ?, next_attempt_at = ?, delivered_at = ?, last_error = ?, claim_token = NULL
+		WHERE id = ? AND claim_token = ?
+	`
+
+	_, err := s.db.ExecContext(ctx, query, delivery.Attempts, sqliteNullableTime(delivery.NextAttemptAt), sqliteNullableTime(delivery.DeliveredAt), delivery.LastError, delivery.ID, delivery.ClaimToken)
+	return err
+}
+
+func (s *SQLiteStore) PurgeDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE next_attempt_at IS NULL AND created_at < ?`, sqliteTime(before))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *SQLiteStore) CreateDomainRule(ctx context.Context, rule *DomainRule) error {
+	query := `
+		INSERT INTO domain_rules (domain, list, created_at)
+		VALUES (?, ?, ?)
+		RETURNING ` + domainRuleColumns
+
+	err := s.db.GetContext(ctx, rule, query, rule.Domain, rule.List, sqliteTime(time.Now()))
+	if isSQLiteUniqueViolation(err) {
+		return ErrDomainRuleExists
+	}
+
+	return err
+}
+
+func (s *SQLiteStore) ListDomainRules(ctx context.Context) ([]DomainRule, error) {
+	rules := []DomainRule{}
+	err := s.db.SelectContext(ctx, &rules, `SELECT `+domainRuleColumns+` FROM domain_rules ORDER BY domain`)
+
+	return rules, err
+}
+
+func (s *SQLiteStore) DeleteDomainRule(ctx context.Context, id int) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM domain_rules WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrDomainRuleNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) Close() error {
+	closeStatements(s.getLinkStmt, s.clickCountStmt, s.dailyClicksStmt)
+	return s.db.Close()
+}
+
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+// sqliteConflictError maps unique violations to the store errors. SQLite
+// names the offending columns rather than the index in its messages.
+func sqliteConflictError(err error) error {
+	if !isSQLiteUniqueViolation(err) {
+		return err
+	}
+
+	if strings.Contains(err.Error(), "links.url") {
+		return ErrURLTaken
+	}
+
+	if strings.Contains(err.Error(), "links.idempotency_key") {
+		return ErrIdempotencyKeyTaken
+	}
+
+	return ErrCodeTaken
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pressly/goose/v3"
+)
+
+// newTestStore returns a migrated SQLite store in memory, closed when the
+// test ends.
+func newTestStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+
+	driver := databaseDrivers["sqlite"]
+	db, err := driver.open(":memory:")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	// Every connection to :memory: opens a database of its own.
+	db.SetMaxOpenConns(1)
+
+	goose.SetLogger(goose.NopLogger())
+	if err := runMigrations(db, driver); err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+
+	store, err := NewSQLiteStore(db)
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+// newTestOrg creates an organization named slug and returns the secret
+// of the API key of its owner along with the key and owner themselves.
+func newTestOrg(t *testing.T, store *SQLiteStore, slug string) (string, APIKey, Member) {
+	t.Helper()
+
+	secret, key, err := newAPIKey(defaultAPIKeyName)
+	if err != nil {
+		t.Fatalf("generating API key: %v", err)
+	}
+
+	org := Organization{Slug: slug, Name: slug}
+	owner := Member{Email: "owner@" + slug + ".test", Role: roleOwner}
+	if err := store.CreateOrganization(context.Background(), &org, &owner, &key, hashAPIKey(secret)); err != nil {
+		t.Fatalf("creating organization: %v", err)
+	}
+
+	return secret, key, owner
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	maxLinkTags  = 20
+	maxTagLength = 64
+)
+
+// TagsResponse lists the tags of a namespace.
+type TagsResponse struct {
+	Tags        []TagCount `json:"tags"`
+	ElapsedTime int64      `json:"elapsed_time"`
+}
+
+type linkTag struct {
+	LinkID int    `db:"link_id"`
+	Tag    string `db:"tag"`
+}
+
+func groupLinkTags(rows []linkTag) map[int][]string {
+	tags := make(map[int][]string)
+	for _, row := range rows {
+		tags[row.LinkID] = append(tags[row.LinkID], row.Tag)
+	}
+
+	return tags
+}
+
+// normalizeTags trims and lowercases tags and drops the duplicates, so
+// that "Summer-Sale" and "summer-sale " group the same links.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) > maxLinkTags {
+		return nil, &fieldError{Field: "tags", Message: fmt.Sprintf("A link can have at most %d tags", maxLinkTags)}
+	}
+
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for i, tag := range tags {
+		tag = normalizeTag(tag)
+		if message := validateTag(tag); message != "" {
+			return nil, &fieldError{Field: fmt.Sprintf("tags[%d]", i), Message: message}
+		}
+
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	sort.Strings(normalized)
+
+	return normalized, nil
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// validateTag returns what is wrong with a normalized tag, or an empty
+// string when it is valid.
+func validateTag(tag string) string {
+	if tag == "" {
+		return "Tag must not be empty"
+	}
+
+	if len(tag) > maxTagLength {
+		return fmt.Sprintf("Tag must be at most %d characters", maxTagLength)
+	}
+
+	if strings.ContainsFunc(tag, func(r rune) bool { return r == ',' || unicode.IsControl(r) }) {
+		return "Tag must not contain commas or control characters"
+	}
+
+	return ""
+}
+
+// addLinkTags adds normalized tags to those link already has, as
+// shortening a URL that was already shortened tags its existing link.
+// Tags past the limit of the link are dropped.
+func addLinkTags(ctx context.Context, links LinkStore, link *Link, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	existing, err := links.LinkTags(ctx, []int{link.ID})
+	if err != nil {
+		return err
+	}
+
+	merged := existing[link.ID]
+	for _, tag := range tags {
+		if len(merged) < maxLinkTags && !slices.Contains(merged, tag) {
+			merged = append(merged, tag)
+		}
+	}
+	sort.Strings(merged)
+
+	if err := links.SetLinkTags(ctx, link.ID, merged); err != nil {
+		return err
+	}
+	link.Tags = merged
+
+	return nil
+}
+
+// updateLinkTags replaces the tags of link when request has them and
+// loads them otherwise, so that the updated link is returned with its
+// tags.
+func updateLinkTags(ctx context.Context, links LinkStore, link *Link, request UpdateLinkRequest) error {
+	if request.Tags == nil {
+		page := []Link{*link}
+		if err := loadLinkTags(ctx, links, page); err != nil {
+			return err
+		}
+		link.Tags = page[0].Tags
+		return nil
+	}
+
+	if err := links.SetLinkTags(ctx, link.ID, *request.Tags); err != nil {
+		return err
+	}
+	link.Tags = *request.Tags
+
+	return nil
+}
+
+// loadLinkTags fills in the tags of the links.
+func loadLinkTags(ctx context.Context, links LinkStore, page []Link) error {
+	ids := make([]int, len(page))
+	for i, link := range page {
+		ids[i] = link.ID
+	}
+
+	tags, err := links.LinkTags(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range page {
+		page[i].Tags = tags[page[i].ID]
+	}
+
+	return nil
+}
+
+// ListTagsHandler lists the tags of the caller's namespace with how many
+// links have each and the clicks they received.
+func ListTagsHandler(links LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		tags, err := links.ListTags(r.Context(), orgIDFromContext(r.Context()))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := TagsResponse{
+			Tags:        tags,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
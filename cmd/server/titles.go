@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+const (
+	titleBufferSize = 1000
+	titleWorkers    = 4
+)
+
+// TitleFetcher fills in the title of new links in the background. Links
+// are queued on a buffered channel and a few workers fetch their
+// destination pages and store the titles. As every new destination passes
+// through it, it hands the links on to the scanner too.
+type TitleFetcher struct {
+	links   LinkStore
+	cache   LinkCache
+	scanner *LinkScanner
+	jobs    chan Link
+	stop    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+func NewTitleFetcher(links LinkStore, cache LinkCache, scanner *LinkScanner) *TitleFetcher {
+	fetcher := &TitleFetcher{
+		links:   links,
+		cache:   cache,
+		scanner: scanner,
+		jobs:    make(chan Link, titleBufferSize),
+		stop:    make(chan struct{}),
+	}
+
+	fetcher.wg.Add(titleWorkers)
+	for i := 0; i < titleWorkers; i++ {
+		go fetcher.run()
+	}
+
+	return fetcher
+}
+
+// Fetch queues link to have its title fetched and its destinations
+// scanned. It never blocks: when the buffer is full the link keeps no
+// title.
+func (f *TitleFetcher) Fetch(link Link) {
+	f.scanner.Scan(link)
+
+	select {
+	case f.jobs <- link:
+	default:
+		slog.Warn("Title buffer is full, skipping link", "link_id", link.ID)
+	}
+}
+
+// Close waits for the fetches in flight. Titles still queued are not
+// fetched; fetching them could hold up shutdown for a long time.
+func (f *TitleFetcher) Close() {
+	f.once.Do(func() {
+		close(f.stop)
+	})
+	f.wg.Wait()
+}
+
+func (f *TitleFetcher) run() {
+	defer f.wg.Done()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case link := <-f.jobs:
+			f.fetch(link)
+		}
+	}
+}
+
+func (f *TitleFetcher) fetch(link Link) {
+	ctx := context.Background()
+
+	metadata, err := fetchPageMetadata(ctx, link.URL)
+	if err != nil {
+		slog.Warn("Error fetching link title", "error", err, "link_id", link.ID)
+		return
+	}
+
+	var title *string
+	if metadata.Title != "" {
+		title = &metadata.Title
+	}
+
+	if err := f.links.SetLinkTitle(ctx, link.ID, link.URL, title); err != nil {
+		slog.Error("Error storing link title", "error", err, "link_id", link.ID)
+		return
+	}
+
+	f.cache.Delete(ctx, link.OrgID, link.DomainID, link.Code)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const defaultServiceName = "wowee-link-api"
+
+// tracer starts the spans of the server's own code. Handlers, database
+// calls, Redis commands and outgoing requests are traced by their
+// instrumentation libraries.
+var tracer = otel.Tracer("github.com/boleknowak/wowee-link-api/cmd/server")
+
+// setupTracing exports spans over OTLP/HTTP to the collector at
+// OTEL_EXPORTER_OTLP_ENDPOINT, such as http://localhost:4318. Traces that
+// do not arrive with a sampling decision are sampled at
+// TRACE_SAMPLE_RATIO. Without an endpoint no spans are recorded. The
+// other OTEL_EXPORTER_OTLP_* variables, such as the headers, are read by
+// the exporter itself. The returned function flushes pending spans.
+func setupTracing(cfg *config.Config) (func(ctx context.Context) error, error) {
+	endpoint := cfg.String("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if endpoint == "" {
+		return func(ctx context.Context) error { return nil }, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("OTEL_EXPORTER_OTLP_ENDPOINT must be an http or https URL")
+	}
+
+	ratio, err := strconv.ParseFloat(cfg.String("TRACE_SAMPLE_RATIO"), 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		return nil, errors.New("TRACE_SAMPLE_RATIO must be a number from 0 to 1")
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+"/v1/traces"))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(attribute.String("service.name", cfg.String("OTEL_SERVICE_NAME"))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// tracedTransport records a client span for every request through base,
+// or http.DefaultTransport when base is nil. The trace context is not
+// sent along, as these requests go to third parties.
+func tracedTransport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base, otelhttp.WithPropagators(propagation.NewCompositeTextMapPropagator()))
+}
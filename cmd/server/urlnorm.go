@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/boleknowak/wowee-link-api/internal/config"
+)
+
+// defaultTrackingParams are the query parameters ignored when
+// deduplicating destinations with STRIP_TRACKING_PARAMS on. Parameters
+// ending in "*" match every parameter with that prefix.
+var defaultTrackingParams = []string{
+	"utm_*", "fbclid", "gclid", "dclid", "gbraid", "wbraid", "msclkid",
+	"yclid", "igshid", "mc_cid", "mc_eid", "_ga", "_gl",
+}
+
+// trackingParams are the query parameters ignored when deduplicating
+// destinations, set from TRACKING_PARAMS when STRIP_TRACKING_PARAMS is on.
+var trackingParams []string
+
+func loadURLNormConfig(cfg *config.Config) {
+	trackingParams = nil
+	if !cfg.Bool("STRIP_TRACKING_PARAMS") {
+		return
+	}
+
+	for _, param := range cfg.List("TRACKING_PARAMS") {
+		if param = strings.ToLower(strings.TrimSpace(param)); param != "" {
+			trackingParams = append(trackingParams, param)
+		}
+	}
+}
+
+// canonicalURL returns rawURL in the one form links are deduplicated by,
+// stored as their url_key, so that the spellings of a URL are shortened to
+// the same link: the scheme and host are lowercased, default ports and
+// trailing slashes are dropped and the query parameters are sorted by
+// name, without tracking parameters. URLs that are not http or https are
+// returned as they are.
+func canonicalURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Opaque != "" {
+		return rawURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return rawURL
+	}
+
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
+
+	u.RawQuery = canonicalQuery(u.RawQuery)
+	u.ForceQuery = false
+
+	return u.String()
+}
+
+// canonicalQuery sorts the parameters of a raw query by name, keeping the
+// order of repeated ones and their encoding, and drops empty and tracking
+// parameters.
+func canonicalQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	type param struct {
+		name string
+		raw  string
+	}
+
+	var params []param
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(raw, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if isTrackingParam(name) {
+			continue
+		}
+
+		params = append(params, param{name: name, raw: raw})
+	}
+
+	sort.SliceStable(params, func(i, j int) bool {
+		return params[i].name < params[j].name
+	})
+
+	raws := make([]string, len(params))
+	for i, p := range params {
+		raws[i] = p.raw
+	}
+
+	return strings.Join(raws, "&")
+}
+
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+
+	for _, param := range trackingParams {
+		if prefix, ok := strings.CutSuffix(param, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == param {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,87 @@
+package main
+
+import "testing"
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"unchanged", "https://example.com/a?b=1", "https://example.com/a?b=1"},
+		{"trailing slash", "https://example.com/a/", "https://example.com/a"},
+		{"trailing slashes", "https://example.com/a//", "https://example.com/a"},
+		{"root path", "https://example.com/", "https://example.com"},
+		{"param order", "https://example.com/?b=2&a=1&c=3", "https://example.com?a=1&b=2&c=3"},
+		{"repeated params keep their order", "https://example.com/?b=2&a=1&b=1", "https://example.com?a=1&b=2&b=1"},
+		{"empty params", "https://example.com/?a=1&&b=2&", "https://example.com?a=1&b=2"},
+		{"empty query", "https://example.com/a?", "https://example.com/a"},
+		{"escaped param names", "https://example.com/?%62=2&a=1", "https://example.com?a=1&%62=2"},
+		{"default http port", "http://example.com:80/a", "http://example.com/a"},
+		{"default https port", "https://example.com:443/a", "https://example.com/a"},
+		{"other port", "https://example.com:8443/a", "https://example.com:8443/a"},
+		{"http port on https", "https://example.com:80/a", "https://example.com:80/a"},
+		{"scheme and host case", "HTTPS://Example.COM/a", "https://example.com/a"},
+		{"path case", "https://example.com/A/b", "https://example.com/A/b"},
+		{"query case", "https://example.com/?Q=A", "https://example.com?Q=A"},
+		{"ipv6 host", "http://[2001:DB8::1]:80/a/", "http://[2001:db8::1]/a"},
+		{"ipv6 host with port", "https://[2001:db8::1]:8443/", "https://[2001:db8::1]:8443"},
+		{"escaped path", "https://example.com/a%2Fb/", "https://example.com/a%2Fb"},
+		{"fragment", "https://example.com/a/#top", "https://example.com/a#top"},
+		{"other scheme", "FTP://Example.com/a/", "FTP://Example.com/a/"},
+		{"opaque", "mailto:Someone@Example.com", "mailto:Someone@Example.com"},
+		{"unparsable", "https://example.com/%zz", "https://example.com/%zz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canonicalURL(tt.url); got != tt.want {
+				t.Errorf("canonicalURL(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalURLTrackingParams(t *testing.T) {
+	defer func(params []string) { trackingParams = params }(trackingParams)
+
+	tests := []struct {
+		name   string
+		params []string
+		url    string
+		want   string
+	}{
+		{"kept when not stripping", nil, "https://example.com/?utm_source=x&a=1", "https://example.com?a=1&utm_source=x"},
+		{"exact name", []string{"fbclid"}, "https://example.com/?fbclid=x&a=1", "https://example.com?a=1"},
+		{"exact name only", []string{"fbclid"}, "https://example.com/?fbclid2=x", "https://example.com?fbclid2=x"},
+		{"prefix", []string{"utm_*"}, "https://example.com/?utm_source=x&utm_medium=y&a=1", "https://example.com?a=1"},
+		{"name case", []string{"gclid"}, "https://example.com/?GCLID=x&a=1", "https://example.com?a=1"},
+		{"escaped name", []string{"utm_*"}, "https://example.com/?utm%5Fsource=x&a=1", "https://example.com?a=1"},
+		{"only tracking params", []string{"utm_*"}, "https://example.com/a/?utm_source=x", "https://example.com/a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trackingParams = tt.params
+
+			if got := canonicalURL(tt.url); got != tt.want {
+				t.Errorf("canonicalURL(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalURLIsIdempotent(t *testing.T) {
+	urls := []string{
+		"HTTP://Example.com:80/a/b/?z=1&y=2",
+		"https://[2001:DB8::1]:443/",
+		"https://example.com/?b=&a",
+	}
+
+	for _, url := range urls {
+		once := canonicalURL(url)
+		if twice := canonicalURL(once); twice != once {
+			t.Errorf("canonicalURL(%q) = %q, but canonicalURL(%q) = %q", url, once, once, twice)
+		}
+	}
+}
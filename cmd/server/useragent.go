@@ -0,0 +1,137 @@
+package main
+
+import "strings"
+
+const (
+	deviceDesktop = "desktop"
+	deviceMobile  = "mobile"
+	deviceTablet  = "tablet"
+	deviceBot     = "bot"
+	deviceUnknown = "unknown"
+
+	otherAgent = "Other"
+)
+
+// Device is the coarse classification of a User-Agent header. For bots,
+// Browser holds the name of the bot.
+type Device struct {
+	Type    string
+	Browser string
+	OS      string
+}
+
+type agentToken struct {
+	token string
+	name  string
+}
+
+// knownBots are matched before the generic bot markers so that the most
+// common crawlers and link unfurlers are reported by name.
+var knownBots = []agentToken{
+	{"googlebot", "Googlebot"},
+	{"bingbot", "Bingbot"},
+	{"yandexbot", "YandexBot"},
+	{"duckduckbot", "DuckDuckBot"},
+	{"baiduspider", "Baiduspider"},
+	{"applebot", "Applebot"},
+	{"facebookexternalhit", "Facebook"},
+	{"twitterbot", "Twitterbot"},
+	{"linkedinbot", "LinkedInBot"},
+	{"slackbot", "Slackbot"},
+	{"discordbot", "Discordbot"},
+	{"telegrambot", "TelegramBot"},
+	{"whatsapp", "WhatsApp"},
+	{"curl/", "curl"},
+	{"wget/", "Wget"},
+	{"python-requests", "python-requests"},
+	{"go-http-client", "Go-http-client"},
+}
+
+var botMarkers = []string{"bot", "crawler", "spider", "slurp", "headless", "preview"}
+
+// browsers and operatingSystems are checked in order; several browsers
+// include the tokens of the engines they are built on.
+var browsers = []agentToken{
+	{"edg/", "Edge"},
+	{"edge/", "Edge"},
+	{"opr/", "Opera"},
+	{"opera", "Opera"},
+	{"samsungbrowser", "Samsung Internet"},
+	{"firefox/", "Firefox"},
+	{"fxios", "Firefox"},
+	{"crios", "Chrome"},
+	{"chrome/", "Chrome"},
+	{"chromium", "Chrome"},
+	{"safari/", "Safari"},
+	{"trident/", "Internet Explorer"},
+	{"msie", "Internet Explorer"},
+}
+
+var operatingSystems = []agentToken{
+	{"iphone", "iOS"},
+	{"ipad", "iOS"},
+	{"ipod", "iOS"},
+	{"android", "Android"},
+	{"windows", "Windows"},
+	{"cros", "ChromeOS"},
+	{"macintosh", "macOS"},
+	{"mac os x", "macOS"},
+	{"linux", "Linux"},
+}
+
+// classifyUserAgent sorts a User-Agent header into a device type, browser
+// and operating system. It only looks for well-known tokens and is meant
+// for aggregate statistics, not feature detection.
+func classifyUserAgent(userAgent string) Device {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return Device{Type: deviceUnknown, Browser: otherAgent, OS: otherAgent}
+	}
+
+	if bot, ok := detectBot(ua); ok {
+		return Device{Type: deviceBot, Browser: bot, OS: otherAgent}
+	}
+
+	device := Device{
+		Type:    deviceDesktop,
+		Browser: matchAgentToken(ua, browsers),
+		OS:      matchAgentToken(ua, operatingSystems),
+	}
+
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet"):
+		device.Type = deviceTablet
+	case strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		device.Type = deviceTablet
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || strings.Contains(ua, "ipod"):
+		device.Type = deviceMobile
+	}
+
+	return device
+}
+
+func detectBot(ua string) (string, bool) {
+	for _, bot := range knownBots {
+		if strings.Contains(ua, bot.token) {
+			return bot.name, true
+		}
+	}
+
+	for _, marker := range botMarkers {
+		if strings.Contains(ua, marker) {
+			return otherAgent, true
+		}
+	}
+
+	return "", false
+}
+
+func matchAgentToken(ua string, tokens []agentToken) string {
+	for _, token := range tokens {
+		if strings.Contains(ua, token.token) {
+			return token.name
+		}
+	}
+
+	return otherAgent
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	maxUTMLength        = 255
+	maxCampaignVariants = 100
+)
+
+// UTMParams are the campaign parameters a link appends to its destination
+// on redirect. Unset parameters are nil.
+type UTMParams struct {
+	UTMSource   *string `db:"utm_source" json:"utm_source"`
+	UTMMedium   *string `db:"utm_medium" json:"utm_medium"`
+	UTMCampaign *string `db:"utm_campaign" json:"utm_campaign"`
+}
+
+// newUTMParams leaves the empty parameters unset.
+func newUTMParams(source string, medium string, campaign string) UTMParams {
+	return UTMParams{
+		UTMSource:   nonEmpty(source),
+		UTMMedium:   nonEmpty(medium),
+		UTMCampaign: nonEmpty(campaign),
+	}
+}
+
+func nonEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+
+	return &s
+}
+
+func (p UTMParams) empty() bool {
+	return p.UTMSource == nil && p.UTMMedium == nil && p.UTMCampaign == nil
+}
+
+func (p UTMParams) fields() []struct {
+	name  string
+	value *string
+} {
+	return []struct {
+		name  string
+		value *string
+	}{
+		{"utm_source", p.UTMSource},
+		{"utm_medium", p.UTMMedium},
+		{"utm_campaign", p.UTMCampaign},
+	}
+}
+
+// validate returns a *fieldError for the first parameter that is too long.
+func (p UTMParams) validate() error {
+	for _, field := range p.fields() {
+		if field.value != nil && len(*field.value) > maxUTMLength {
+			return &fieldError{Field: field.name, Message: fmt.Sprintf("%s must be at most %d characters", field.name, maxUTMLength)}
+		}
+	}
+
+	return nil
+}
+
+// utm returns the UTM parameters of the request.
+func (r ShortenRequest) utm() UTMParams {
+	return newUTMParams(r.UTMSource, r.UTMMedium, r.UTMCampaign)
+}
+
+// applyUTMUpdate sets the UTM parameters of link that are present in
+// request. An empty value removes the parameter.
+func applyUTMUpdate(link *Link, request UpdateLinkRequest) {
+	if request.UTMSource != nil {
+		link.UTMSource = nonEmpty(*request.UTMSource)
+	}
+	if request.UTMMedium != nil {
+		link.UTMMedium = nonEmpty(*request.UTMMedium)
+	}
+	if request.UTMCampaign != nil {
+		link.UTMCampaign = nonEmpty(*request.UTMCampaign)
+	}
+}
+
+// validateUTMUpdate is UTMParams.validate for the parameters of an update.
+func validateUTMUpdate(request UpdateLinkRequest) error {
+	return UTMParams{UTMSource: request.UTMSource, UTMMedium: request.UTMMedium, UTMCampaign: request.UTMCampaign}.validate()
+}
+
+// CreateCampaignHandler creates a link per variant of one destination,
+// each with the UTM parameters of its variant. utm_campaign and the other
+// link settings of the request apply to every variant, which may override
+// the campaign. Variants need a source and must differ in their
+// parameters. Every variant counts against the monthly shorten quota.
+// Nothing is created unless all variants are valid, but a store failure
+// or a lost race for an alias midway leaves the links created so far.
+// With a domain parameter, the links are issued under that domain.
+func CreateCampaignHandler(links LinkStore, orgs OrgStore, codes CodeGenerator, codeConfig CodeConfig, quota *Quota, domains *DomainPolicy, checker URLChecker, webhooks *WebhookDispatcher, titles *TitleFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		var request CampaignRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeInvalidBody(w, err)
+			return
+		}
+
+		if request.URL == "" {
+			writeValidationError(w, &fieldError{Field: "url", Message: "URL is required"})
+			return
+		}
+
+		request.URL, err = unwrapURL(r.Context(), request.URL)
+		if err != nil {
+			writeValidationError(w, &fieldError{Field: "url", Message: err.Error()})
+			return
+		}
+
+		if len(request.Variants) == 0 {
+			writeValidationError(w, &fieldError{Field: "variants", Message: "variants is required"})
+			return
+		}
+
+		if len(request.Variants) > maxCampaignVariants {
+			writeValidationError(w, &fieldError{Field: "variants", Message: fmt.Sprintf("A campaign is limited to %d variants", maxCampaignVariants)})
+			return
+		}
+
+		if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+			writeValidationError(w, &fieldError{Field: "expires_at", Message: "expires_at must be in the future"})
+			return
+		}
+
+		if request.RedirectStatus == 0 {
+			request.RedirectStatus = defaultRedirectStatus
+		}
+
+		if !isValidRedirectStatus(request.RedirectStatus) {
+			writeValidationError(w, &fieldError{Field: "redirect_status", Message: "redirect_status must be 301, 302 or 307"})
+			return
+		}
+
+		request.Tags, err = normalizeTags(request.Tags)
+		if err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		orgID := orgIDFromContext(r.Context())
+		domainID := domainIDFromContext(r.Context())
+		shortens := make([]ShortenRequest, len(request.Variants))
+		seen := make(map[[3]string]bool)
+		aliases := make(map[string]bool)
+
+		for i, variant := range request.Variants {
+			field := fmt.Sprintf("variants[%d]", i)
+
+			shorten := ShortenRequest{
+				URL:              request.URL,
+				Alias:            variant.Alias,
+				RedirectStatus:   request.RedirectStatus,
+				CodeLength:       codeConfig.Length,
+				TrackingDisabled: request.TrackingDisabled,
+				ForwardQuery:     request.ForwardQuery,
+				UTMSource:        variant.UTMSource,
+				UTMMedium:        variant.UTMMedium,
+				UTMCampaign:      request.UTMCampaign,
+				Tags:             request.Tags,
+				DomainID:         domainID,
+			}
+			if variant.UTMCampaign != "" {
+				shorten.UTMCampaign = variant.UTMCampaign
+			}
+
+			if shorten.UTMSource == "" {
+				writeValidationError(w, &fieldError{Field: field + ".utm_source", Message: "utm_source is required"})
+				return
+			}
+
+			if err := shorten.utm().validate(); err != nil {
+				fieldErr := err.(*fieldError)
+				writeValidationError(w, &fieldError{Field: field + "." + fieldErr.Field, Message: fieldErr.Message})
+				return
+			}
+
+			key := [3]string{shorten.UTMSource, shorten.UTMMedium, shorten.UTMCampaign}
+			if seen[key] {
+				writeValidationError(w, &fieldError{Field: field, Message: "Variant repeats the UTM parameters of another variant"})
+				return
+			}
+			seen[key] = true
+
+			if shorten.Alias != "" {
+				if !isValidAlias(shorten.Alias, codeConfig.Charset) {
+					writeValidationError(w, &fieldError{Field: field + ".alias", Message: "Invalid alias"})
+					return
+				}
+
+				if isReservedCode(shorten.Alias) {
+					writeConflict(w, "alias_reserved", "Alias \""+shorten.Alias+"\" is reserved")
+					return
+				}
+
+				if aliases[shorten.Alias] {
+					writeConflict(w, "alias_taken", "Alias \""+shorten.Alias+"\" is used by another variant")
+					return
+				}
+				aliases[shorten.Alias] = true
+
+				exists, err := links.CodeExists(r.Context(), orgID, domainID, shorten.Alias)
+				if err != nil {
+					slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+					writeError(w, http.StatusInternalServerError, "Internal Server Error")
+					return
+				}
+
+				if exists {
+					writeConflict(w, "alias_taken", "Alias \""+shorten.Alias+"\" is already in use")
+					return
+				}
+			}
+
+			shortens[i] = shorten
+		}
+
+		if !domains.checkDomain(w, r, request.URL) {
+			return
+		}
+
+		if verdict, unsafe := isUnsafeURL(r.Context(), checker, request.URL); unsafe {
+			writeUnsafeURL(w, verdict)
+			return
+		}
+
+		slug := ""
+		if orgID != 0 {
+			org, err := orgs.GetOrganization(r.Context(), orgID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+			slug = org.Slug
+		}
+
+		if !quota.consume(w, r, len(shortens)) {
+			return
+		}
+
+		response := CampaignResponse{
+			URL:   request.URL,
+			Links: make([]CampaignLink, 0, len(shortens)),
+		}
+
+		for _, shorten := range shortens {
+			link := Link{
+				OrgID:            orgID,
+				Code:             shorten.Alias,
+				URL:              shorten.URL,
+				ExpiresAt:        request.ExpiresAt,
+				RedirectStatus:   shorten.RedirectStatus,
+				TrackingDisabled: shorten.TrackingDisabled,
+				ForwardQuery:     shorten.ForwardQuery,
+				UTMParams:        shorten.utm(),
+				KeyID:            keyIDFromContext(r.Context()),
+				MemberID:         sessionMemberIDFromContext(r.Context()),
+				DomainID:         shorten.DomainID,
+			}
+
+			if shorten.Alias != "" {
+				err = links.CreateLink(r.Context(), &link)
+			} else {
+				link, err = insertLinkWithGeneratedCode(r.Context(), links, codes, shorten, request.ExpiresAt, nil)
+			}
+			if err == ErrCodeTaken {
+				writeConflict(w, "alias_taken", "Alias \""+shorten.Alias+"\" is already in use")
+				return
+			}
+			if err == nil {
+				err = addLinkTags(r.Context(), links, &link, shorten.Tags)
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error inserting URL into the database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+
+			webhooks.Emit(link.OrgID, webhookLinkCreated, newWebhookEventData(link))
+			titles.Fetch(link)
+
+			short, err := linkShortURL(r, orgs, link, slug)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+
+			response.Links = append(response.Links, CampaignLink{
+				Code:           link.Code,
+				ShortURL:       short,
+				DestinationURL: destinationURL(link, ""),
+				UTMParams:      link.UTMParams,
+			})
+		}
+
+		response.ElapsedTime = time.Since(startTime).Milliseconds()
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(jsonResponse)
+	}
+}
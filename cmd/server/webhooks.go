@@ -0,0 +1,752 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	webhookLinkCreated     = "link.created"
+	webhookLinkClicked     = "link.clicked"
+	webhookLinkExpired     = "link.expired"
+	webhookLinkBroken      = "link.broken"
+	webhookLinkQuarantined = "link.quarantined"
+
+	webhookSecretPrefix = "whsec_"
+	maxWebhookURLLength = 2048
+
+	webhookBufferSize   = 10000
+	webhookPollInterval = 5 * time.Second
+	webhookClaimLimit   = 20
+	// webhookLease must outlast a batch of deliveries, which are sent in
+	// parallel and each bounded by webhookTimeout.
+	webhookLease   = time.Minute
+	webhookTimeout = 10 * time.Second
+	// Failed deliveries are retried after webhookRetryBase, doubling with
+	// every attempt, until webhookMaxAttempts have been made.
+	webhookRetryBase   = 30 * time.Second
+	webhookMaxAttempts = 10
+	// Finished deliveries are kept for webhookRetention so that failures
+	// can be looked into.
+	webhookRetention     = 7 * 24 * time.Hour
+	webhookPurgeInterval = time.Hour
+	// webhookResponseLimit is how much of a response body is read so that
+	// the connection can be reused.
+	webhookResponseLimit = 64 << 10
+)
+
+// webhookEventTypes are the events a webhook can subscribe to.
+var webhookEventTypes = map[string]bool{
+	webhookLinkCreated:     true,
+	webhookLinkClicked:     true,
+	webhookLinkExpired:     true,
+	webhookLinkBroken:      true,
+	webhookLinkQuarantined: true,
+}
+
+// Webhook is a subscription of an organization to link events. Secret
+// signs every delivery and is only returned when the webhook is created.
+type Webhook struct {
+	ID        int           `db:"id" json:"id"`
+	OrgID     int           `db:"org_id" json:"-"`
+	URL       string        `db:"url" json:"url"`
+	Events    webhookEvents `db:"events" json:"events"`
+	Secret    string        `db:"secret" json:"-"`
+	Active    bool          `db:"active" json:"active"`
+	CreatedAt time.Time     `db:"created_at" json:"created_at"`
+	UpdatedAt *time.Time    `db:"updated_at" json:"updated_at"`
+}
+
+// webhookEvents is stored as a comma separated list.
+type webhookEvents []string
+
+func (e webhookEvents) Value() (driver.Value, error) {
+	return strings.Join(e, ","), nil
+}
+
+func (e *webhookEvents) Scan(src interface{}) error {
+	var value string
+	switch src := src.(type) {
+	case string:
+		value = src
+	case []byte:
+		value = string(src)
+	default:
+		return fmt.Errorf("cannot scan %T into webhook events", src)
+	}
+
+	*e = strings.Split(value, ",")
+	return nil
+}
+
+func (e webhookEvents) contains(event string) bool {
+	for _, subscribed := range e {
+		if subscribed == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WebhookDelivery is one event queued for one webhook. URL, Secret and
+// Active are those of the webhook at the time the delivery was claimed.
+type WebhookDelivery struct {
+	ID            int        `db:"id"`
+	WebhookID     int        `db:"webhook_id"`
+	Event         string     `db:"event"`
+	Payload       string     `db:"payload"`
+	Attempts      int        `db:"attempts"`
+	NextAttemptAt *time.Time `db:"next_attempt_at"`
+	ClaimToken    *string    `db:"claim_token"`
+	DeliveredAt   *time.Time `db:"delivered_at"`
+	LastError     *string    `db:"last_error"`
+	CreatedAt     time.Time  `db:"created_at"`
+	URL           string     `db:"url"`
+	Secret        string     `db:"secret"`
+	Active        bool       `db:"active"`
+}
+
+// WebhookPayload is the body of every delivery. ID identifies the event
+// and is shared by its deliveries to different webhooks.
+type WebhookPayload struct {
+	ID        string           `json:"id"`
+	Event     string           `json:"event"`
+	CreatedAt time.Time        `json:"created_at"`
+	Data      WebhookEventData `json:"data"`
+}
+
+// WebhookEventData describes the link an event is about. Referrer is only
+// set for link.clicked, and is empty for direct visits.
+type WebhookEventData struct {
+	Link     WebhookLink `json:"link"`
+	Referrer *string     `json:"referrer,omitempty"`
+	// Status is what a broken destination last responded with.
+	Status *int `json:"status,omitempty"`
+	// Reason names the scanner that had a link quarantined and its
+	// finding.
+	Reason *string `json:"reason,omitempty"`
+}
+
+type WebhookLink struct {
+	Code      string     `json:"code"`
+	URL       string     `json:"url"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	MaxClicks *int       `json:"max_clicks"`
+}
+
+func newWebhookEventData(link Link) WebhookEventData {
+	return WebhookEventData{Link: WebhookLink{
+		Code:      link.Code,
+		URL:       link.URL,
+		CreatedAt: link.CreatedAt,
+		ExpiresAt: link.ExpiresAt,
+		MaxClicks: link.MaxClicks,
+	}}
+}
+
+type webhookEvent struct {
+	OrgID int
+	WebhookPayload
+}
+
+// WebhookDispatcher takes webhooks off the request path. Events are
+// queued on a buffered channel and a worker turns them into a delivery per
+// subscribed webhook in the database, which a second worker sends. Queued
+// deliveries survive restarts and are shared by every instance.
+type WebhookDispatcher struct {
+	store  WebhookStore
+	client *http.Client
+	events chan webhookEvent
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	// mu guards closed so that events emitted by background jobs during
+	// shutdown are dropped instead of sent on the closed channel.
+	mu     sync.RWMutex
+	closed bool
+}
+
+func NewWebhookDispatcher(store WebhookStore) *WebhookDispatcher {
+	dispatcher := &WebhookDispatcher{
+		store: store,
+		// The transport is the one of pageClient, so that webhooks only
+		// reach public addresses and cannot probe the internal network.
+		client: &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: pageClient.Transport,
+			// A redirect would resend the signed payload somewhere the
+			// subscriber did not register.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		events: make(chan webhookEvent, webhookBufferSize),
+		stop:   make(chan struct{}),
+	}
+
+	dispatcher.wg.Add(2)
+	go dispatcher.enqueue()
+	go dispatcher.deliver()
+
+	return dispatcher
+}
+
+// Emit queues event for the webhooks of the organization that subscribe
+// to it. The shared namespace has no webhooks. Emit never blocks: when the
+// buffer is full the event is dropped and logged.
+func (d *WebhookDispatcher) Emit(orgID int, event string, data WebhookEventData) {
+	if orgID == 0 {
+		return
+	}
+
+	id, err := newWebhookID()
+	if err != nil {
+		slog.Error("Error generating webhook event ID", "error", err)
+		return
+	}
+
+	queued := webhookEvent{
+		OrgID: orgID,
+		WebhookPayload: WebhookPayload{
+			ID:        id,
+			Event:     event,
+			CreatedAt: time.Now().UTC(),
+			Data:      data,
+		},
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return
+	}
+
+	select {
+	case d.events <- queued:
+	default:
+		slog.Warn("Webhook buffer is full, dropping event", "event", event, "org_id", orgID)
+	}
+}
+
+// Close stops accepting events and blocks until the queued events are
+// stored and the deliveries in flight are finished.
+func (d *WebhookDispatcher) Close() {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.events)
+		close(d.stop)
+	}
+	d.mu.Unlock()
+
+	d.wg.Wait()
+}
+
+func (d *WebhookDispatcher) enqueue() {
+	defer d.wg.Done()
+
+	for event := range d.events {
+		ctx := context.Background()
+
+		hooks, err := d.store.ListWebhooks(ctx, event.OrgID)
+		if err != nil {
+			slog.Error("Error loading webhooks", "error", err, "org_id", event.OrgID)
+			continue
+		}
+
+		var payload []byte
+		for _, hook := range hooks {
+			if !hook.Active || !hook.Events.contains(event.Event) {
+				continue
+			}
+
+			if payload == nil {
+				payload, err = json.Marshal(event.WebhookPayload)
+				if err != nil {
+					slog.Error("Error marshaling webhook payload", "error", err, "event", event.Event)
+					break
+				}
+			}
+
+			delivery := WebhookDelivery{WebhookID: hook.ID, Event: event.Event, Payload: string(payload)}
+			if err := d.store.EnqueueDelivery(ctx, &delivery); err != nil {
+				slog.Error("Error queueing webhook delivery", "error", err, "webhook_id", hook.ID)
+			}
+		}
+	}
+}
+
+func (d *WebhookDispatcher) deliver() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	lastPurge := time.Now()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.deliverDue()
+
+			if time.Since(lastPurge) >= webhookPurgeInterval {
+				lastPurge = time.Now()
+				d.purge()
+			}
+		}
+	}
+}
+
+// deliverDue sends due deliveries in batches until none are left or the
+// dispatcher is closed.
+func (d *WebhookDispatcher) deliverDue() {
+	for {
+		token, err := newWebhookID()
+		if err != nil {
+			slog.Error("Error generating webhook claim token", "error", err)
+			return
+		}
+
+		deliveries, err := d.store.ClaimDeliveries(context.Background(), token, time.Now().Add(webhookLease), webhookClaimLimit)
+		if err != nil {
+			slog.Error("Error claiming webhook deliveries", "error", err)
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func(delivery WebhookDelivery) {
+				defer wg.Done()
+				d.attempt(delivery)
+			}(delivery)
+		}
+		wg.Wait()
+
+		if len(deliveries) < webhookClaimLimit {
+			return
+		}
+
+		select {
+		case <-d.stop:
+			return
+		default:
+		}
+	}
+}
+
+// attempt sends a claimed delivery once and schedules a retry, with
+// exponential backoff, when it fails.
+func (d *WebhookDispatcher) attempt(delivery WebhookDelivery) {
+	err := errors.New("webhook is disabled")
+	if delivery.Active {
+		delivery.Attempts++
+		err = d.send(delivery)
+	}
+
+	now := time.Now()
+	delivery.NextAttemptAt = nil
+	delivery.LastError = nil
+
+	if err == nil {
+		delivery.DeliveredAt = &now
+	} else {
+		message := err.Error()
+		delivery.LastError = &message
+
+		if delivery.Active && delivery.Attempts < webhookMaxAttempts {
+			next := now.Add(webhookRetryBase << (delivery.Attempts - 1))
+			delivery.NextAttemptAt = &next
+		}
+
+		slog.Warn("Webhook delivery failed", "error", err, "webhook_id", delivery.WebhookID, "delivery_id", delivery.ID, "attempts", delivery.Attempts)
+	}
+
+	if err := d.store.UpdateDelivery(context.Background(), delivery); err != nil {
+		slog.Error("Error updating webhook delivery", "error", err, "delivery_id", delivery.ID)
+	}
+}
+
+// send posts the payload signed with the webhook secret. The signature is
+// the hex HMAC-SHA256 of the timestamp, a dot and the body, so receivers
+// can reject replays of old deliveries.
+func (d *WebhookDispatcher) send(delivery WebhookDelivery) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "wowee-link-webhooks")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(delivery.ID))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, webhookResponseLimit))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (d *WebhookDispatcher) purge() {
+	purged, err := d.store.PurgeDeliveries(context.Background(), time.Now().Add(-webhookRetention))
+	if err != nil {
+		slog.Error("Error purging webhook deliveries", "error", err)
+		return
+	}
+
+	if purged > 0 {
+		slog.Info("Purged webhook deliveries", "count", purged)
+	}
+}
+
+func signWebhook(secret string, timestamp string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookID() (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(random), nil
+}
+
+func newWebhookSecret() (string, error) {
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return webhookSecretPrefix + hex.EncodeToString(random), nil
+}
+
+// ListWebhooksHandler lists the webhooks of the caller's organization.
+func ListWebhooksHandler(hooks WebhookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := requireRole(w, r, roleAdmin)
+		if !ok {
+			return
+		}
+
+		webhooks, err := hooks.ListWebhooks(r.Context(), c.OrgID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		jsonResponse, err := json.Marshal(WebhooksResponse{Webhooks: webhooks})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// CreateWebhookHandler subscribes a URL to link events and returns the
+// secret its deliveries are signed with.
+func CreateWebhookHandler(hooks WebhookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var startTime = time.Now()
+
+		c, ok := requireRole(w, r, roleAdmin)
+		if !ok {
+			return
+		}
+
+		var request CreateWebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeInvalidBody(w, err)
+			return
+		}
+
+		hook := Webhook{
+			OrgID:  c.OrgID,
+			URL:    request.URL,
+			Events: request.Events,
+			Active: true,
+		}
+
+		if message := validateWebhook(hook); message != "" {
+			writeError(w, http.StatusBadRequest, message)
+			return
+		}
+
+		secret, err := newWebhookSecret()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error generating webhook secret", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		hook.Secret = secret
+
+		if err := hooks.CreateWebhook(r.Context(), &hook); err != nil {
+			slog.ErrorContext(r.Context(), "Error creating webhook", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		response := CreateWebhookResponse{
+			Webhook:     hook,
+			Secret:      secret,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(jsonResponse)
+	}
+}
+
+func GetWebhookHandler(hooks WebhookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := requireRole(w, r, roleAdmin)
+		if !ok {
+			return
+		}
+
+		hook, ok := lookupWebhook(w, r, hooks, c.OrgID)
+		if !ok {
+			return
+		}
+
+		jsonResponse, err := json.Marshal(hook)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// UpdateWebhookHandler changes the URL, events or active flag of a
+// webhook. Deliveries already queued for a deactivated webhook are dropped
+// when they come due.
+func UpdateWebhookHandler(hooks WebhookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := requireRole(w, r, roleAdmin)
+		if !ok {
+			return
+		}
+
+		var request UpdateWebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeInvalidBody(w, err)
+			return
+		}
+
+		if request.URL == nil && request.Events == nil && request.Active == nil {
+			writeError(w, http.StatusBadRequest, "Nothing to update")
+			return
+		}
+
+		hook, ok := lookupWebhook(w, r, hooks, c.OrgID)
+		if !ok {
+			return
+		}
+
+		if request.URL != nil {
+			hook.URL = *request.URL
+		}
+		if request.Events != nil {
+			hook.Events = *request.Events
+		}
+		if request.Active != nil {
+			hook.Active = *request.Active
+		}
+
+		if message := validateWebhook(hook); message != "" {
+			writeError(w, http.StatusBadRequest, message)
+			return
+		}
+
+		err := hooks.UpdateWebhook(r.Context(), &hook)
+		if err != nil {
+			if err == ErrWebhookNotFound {
+				writeError(w, http.StatusNotFound, "Webhook not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error updating webhook", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		jsonResponse, err := json.Marshal(hook)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshaling JSON response", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+	}
+}
+
+// DeleteWebhookHandler removes a webhook along with its pending and past
+// deliveries.
+func DeleteWebhookHandler(hooks WebhookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := requireRole(w, r, roleAdmin)
+		if !ok {
+			return
+		}
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Webhook not found")
+			return
+		}
+
+		err = hooks.DeleteWebhook(r.Context(), c.OrgID, id)
+		if err != nil {
+			if err == ErrWebhookNotFound {
+				writeError(w, http.StatusNotFound, "Webhook not found")
+			} else {
+				slog.ErrorContext(r.Context(), "Error deleting webhook", "error", err)
+				writeError(w, http.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func lookupWebhook(w http.ResponseWriter, r *http.Request, hooks WebhookStore, orgID int) (Webhook, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Webhook not found")
+		return Webhook{}, false
+	}
+
+	hook, err := hooks.GetWebhook(r.Context(), orgID, id)
+	if err != nil {
+		if err == ErrWebhookNotFound {
+			writeError(w, http.StatusNotFound, "Webhook not found")
+		} else {
+			slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+			writeError(w, http.StatusInternalServerError, "Internal Server Error")
+		}
+		return hook, false
+	}
+
+	return hook, true
+}
+
+// validateWebhook returns why hook cannot be stored, or an empty string.
+func validateWebhook(hook Webhook) string {
+	if !isValidWebhookURL(hook.URL) {
+		return "url must be an absolute http or https URL"
+	}
+	if !isPublicWebhookHost(hook.URL) {
+		return "url must not point to a private or loopback address"
+	}
+
+	if len(hook.Events) == 0 {
+		return "events must list at least one event"
+	}
+
+	seen := make(map[string]bool)
+	for _, event := range hook.Events {
+		if !webhookEventTypes[event] {
+			return "Unknown event \"" + event + "\""
+		}
+
+		if seen[event] {
+			return "Duplicate event \"" + event + "\""
+		}
+		seen[event] = true
+	}
+
+	return ""
+}
+
+func isValidWebhookURL(value string) bool {
+	if value == "" || len(value) > maxWebhookURLLength {
+		return false
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isPublicWebhookHost rejects URLs whose host is localhost or an internal
+// IP address. Hostnames resolving to internal addresses are refused when
+// the webhook is sent instead.
+func isPublicWebhookHost(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return isPublicIP(ip)
+	}
+
+	return true
+}
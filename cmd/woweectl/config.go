@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+const defaultAPIURL = "http://localhost:3001"
+
+// config is where woweectl finds the API. Flags take precedence over
+// WOWEE_API_URL and WOWEE_API_KEY, which take precedence over the config
+// file.
+type config struct {
+	APIURL string `json:"api_url"`
+	APIKey string `json:"api_key"`
+}
+
+// configPath is woweectl/config.json in the user's config directory, such
+// as ~/.config on Linux.
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "woweectl", "config.json"), nil
+}
+
+func loadConfig(path string) (config, error) {
+	cfg := config{APIURL: defaultAPIURL}
+
+	if path == "" {
+		var err error
+		path, err = configPath()
+		if err != nil {
+			return cfg, err
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return cfg, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, errors.New("invalid config file " + path + ": " + err.Error())
+		}
+	}
+
+	if value := os.Getenv("WOWEE_API_URL"); value != "" {
+		cfg.APIURL = value
+	}
+	if value := os.Getenv("WOWEE_API_KEY"); value != "" {
+		cfg.APIKey = value
+	}
+
+	if cfg.APIURL == "" {
+		cfg.APIURL = defaultAPIURL
+	}
+
+	return cfg, nil
+}
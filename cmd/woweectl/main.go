@@ -0,0 +1,752 @@
+// Command woweectl scripts the wowee-link API from the shell.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/boleknowak/wowee-link-api/client"
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := newRootCommand().ExecuteContext(ctx); err != nil {
+		os.Exit(1)
+	}
+}
+
+// cli holds the flags shared by every command.
+type cli struct {
+	configFile string
+	apiURL     string
+	apiKey     string
+	domain     string
+	json       bool
+}
+
+func (c *cli) client() (*client.Client, error) {
+	cfg, err := loadConfig(c.configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.apiURL != "" {
+		cfg.APIURL = c.apiURL
+	}
+	if c.apiKey != "" {
+		cfg.APIKey = c.apiKey
+	}
+
+	return client.New(cfg.APIURL, client.WithAPIKey(cfg.APIKey), client.WithDomain(c.domain)), nil
+}
+
+func newRootCommand() *cobra.Command {
+	c := &cli{}
+
+	root := &cobra.Command{
+		Use:          "woweectl",
+		Short:        "Manage wowee-link short links",
+		SilenceUsage: true,
+	}
+
+	root.PersistentFlags().StringVar(&c.configFile, "config", "", "config file (default woweectl/config.json in the user config directory)")
+	root.PersistentFlags().StringVar(&c.apiURL, "api-url", "", "API base URL, overrides WOWEE_API_URL")
+	root.PersistentFlags().StringVar(&c.apiKey, "api-key", "", "API key, overrides WOWEE_API_KEY")
+	root.PersistentFlags().StringVar(&c.domain, "domain", "", "verified custom domain whose codes to act on and to issue links under")
+	root.PersistentFlags().BoolVar(&c.json, "json", false, "print JSON instead of text")
+
+	root.AddCommand(
+		newShortenCommand(c),
+		newStatsCommand(c),
+		newListCommand(c),
+		newSearchCommand(c),
+		newTagsCommand(c),
+		newFoldersCommand(c),
+		newMoveCommand(c),
+		newDeleteCommand(c),
+		newHistoryCommand(c),
+		newArchiveCommand(c),
+		newRestoreCommand(c),
+		newAliasesCommand(c),
+		newCheckAliasCommand(c),
+		newDomainsCommand(c),
+		newExportCommand(c),
+	)
+
+	return root
+}
+
+func newShortenCommand(c *cli) *cobra.Command {
+	var request client.ShortenRequest
+	var ttl time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "shorten URL",
+		Short: "Shorten a URL and print the short link",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			request.URL = args[0]
+			request.TTLSeconds = int64(ttl.Seconds())
+
+			response, err := api.Shorten(cmd.Context(), request)
+			if err != nil {
+				return err
+			}
+
+			if c.json {
+				return printJSON(cmd.OutOrStdout(), response)
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), response.ShortURL)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&request.Alias, "alias", "", "code to use instead of a generated one")
+	cmd.Flags().DurationVar(&ttl, "ttl", 0, "how long the link lives, such as 24h")
+	cmd.Flags().IntVar(&request.MaxClicks, "max-clicks", 0, "clicks after which the link stops redirecting")
+	cmd.Flags().IntVar(&request.RedirectStatus, "redirect-status", 0, "301, 302 or 307")
+	cmd.Flags().BoolVar(&request.TrackingDisabled, "no-tracking", false, "do not record visits of the link")
+	cmd.Flags().BoolVar(&request.ForwardQuery, "forward-query", false, "pass the query of the short URL on to the destination")
+	cmd.Flags().StringVar(&request.UTMSource, "utm-source", "", "utm_source appended to the destination on redirect")
+	cmd.Flags().StringVar(&request.UTMMedium, "utm-medium", "", "utm_medium appended to the destination on redirect")
+	cmd.Flags().StringVar(&request.UTMCampaign, "utm-campaign", "", "utm_campaign appended to the destination on redirect")
+	cmd.Flags().StringSliceVar(&request.Tags, "tag", nil, "tag the link, repeatable")
+	cmd.Flags().IntVar(&request.FolderID, "folder", 0, "ID of the folder to file the link in")
+	cmd.Flags().StringVar(&request.Description, "description", "", "what the link is for")
+	cmd.Flags().StringVar(&request.Notes, "notes", "", "internal notes about the link")
+	cmd.Flags().BoolVar(&request.ForceNew, "force-new", false, "create a new link even if the URL was already shortened")
+
+	return cmd
+}
+
+func newStatsCommand(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "stats CODE",
+		Short: "Show a link with its counts",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			link, err := api.Stats(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+
+			if c.json {
+				return printJSON(cmd.OutOrStdout(), link)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintf(w, "code\t%s\n", link.Code)
+			fmt.Fprintf(w, "url\t%s\n", link.URL)
+			if link.Title != nil {
+				fmt.Fprintf(w, "title\t%s\n", *link.Title)
+			}
+			if link.Description != nil {
+				fmt.Fprintf(w, "description\t%s\n", *link.Description)
+			}
+			if len(link.Tags) > 0 {
+				fmt.Fprintf(w, "tags\t%s\n", strings.Join(link.Tags, ", "))
+			}
+			if link.FolderID != nil {
+				fmt.Fprintf(w, "folder\t%d\n", *link.FolderID)
+			}
+			if link.Notes != nil {
+				fmt.Fprintf(w, "notes\t%s\n", *link.Notes)
+			}
+			fmt.Fprintf(w, "created\t%s\n", link.CreatedAt.Format(time.RFC3339))
+			if link.ExpiresAt != nil {
+				fmt.Fprintf(w, "expires\t%s\n", link.ExpiresAt.Format(time.RFC3339))
+			}
+			if link.ActiveFrom != nil {
+				fmt.Fprintf(w, "active from\t%s\n", link.ActiveFrom.Format(time.RFC3339))
+			}
+			if link.ActiveUntil != nil {
+				fmt.Fprintf(w, "active until\t%s\n", link.ActiveUntil.Format(time.RFC3339))
+			}
+			if link.FallbackURL != nil {
+				fmt.Fprintf(w, "fallback url\t%s\n", *link.FallbackURL)
+			}
+			if link.CheckedAt != nil {
+				status := "no response"
+				if link.CheckStatus != nil {
+					status = strconv.Itoa(*link.CheckStatus)
+				}
+				fmt.Fprintf(w, "checked\t%s (%s)\n", link.CheckedAt.Format(time.RFC3339), status)
+			}
+			if link.BrokenSince != nil {
+				fmt.Fprintf(w, "broken since\t%s\n", link.BrokenSince.Format(time.RFC3339))
+			}
+			fmt.Fprintf(w, "clicks\t%d\n", link.ClickCount)
+			fmt.Fprintf(w, "bot clicks\t%d\n", link.BotClicks)
+			if link.MaxClicks != nil {
+				fmt.Fprintf(w, "max clicks\t%d\n", *link.MaxClicks)
+			}
+			fmt.Fprintf(w, "redirect status\t%d\n", link.RedirectStatus)
+			fmt.Fprintf(w, "tracking\t%s\n", strconv.FormatBool(!link.TrackingDisabled))
+			fmt.Fprintf(w, "forward query\t%s\n", strconv.FormatBool(link.ForwardQuery))
+			for _, destination := range link.Destinations {
+				fmt.Fprintf(w, "destination %d\t%s (weight %d)\n", destination.ID, destination.URL, destination.Weight)
+			}
+			for _, target := range link.GeoTargets {
+				fmt.Fprintf(w, "geo target %s%s\t%s\n", target.Country, target.Continent, target.URL)
+			}
+			for _, target := range link.DeviceTargets {
+				fmt.Fprintf(w, "device target %s%s\t%s\n", target.OS, target.Device, target.URL)
+			}
+			for i, rule := range link.RoutingRules {
+				fmt.Fprintf(w, "routing rule %d\t%s\n", i+1, rule.URL)
+			}
+
+			return w.Flush()
+		},
+	}
+}
+
+func newListCommand(c *cli) *cobra.Command {
+	var opts client.ListOptions
+	var ascending bool
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List links, newest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			if ascending {
+				descending := false
+				opts.Descending = &descending
+			}
+
+			page, err := api.List(cmd.Context(), opts)
+			if err != nil {
+				return err
+			}
+
+			if c.json {
+				return printJSON(cmd.OutOrStdout(), page)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "CODE\tCLICKS\tCREATED\tURL")
+			for _, link := range page.Links {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", link.Code, link.ClickCount, link.CreatedAt.Format("2006-01-02"), link.URL)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			if page.Offset+len(page.Links) < page.Total {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%d of %d links, continue with --offset %d\n", len(page.Links), page.Total, page.Offset+len(page.Links))
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Sort, "sort", "", "created_at, click_count, attempt_count or code")
+	cmd.Flags().BoolVar(&ascending, "asc", false, "sort in ascending order")
+	cmd.Flags().IntVar(&opts.Limit, "limit", 0, "links per page")
+	cmd.Flags().IntVar(&opts.Offset, "offset", 0, "links to skip")
+	cmd.Flags().IntVar(&opts.MinClicks, "min-clicks", 0, "only list links with at least this many clicks")
+	cmd.Flags().BoolVar(&opts.Broken, "broken", false, "only list links whose destination is dead")
+	cmd.Flags().StringVar(&opts.Tag, "tag", "", "only list links with this tag")
+	cmd.Flags().IntVar(&opts.FolderID, "folder", 0, "only list links filed in the folder with this ID")
+	cmd.Flags().BoolVar(&opts.Subfolders, "subfolders", false, "with --folder, also list links of the folders nested in it")
+	cmd.Flags().BoolVar(&opts.Archived, "archived", false, "list archived links instead of live ones")
+	cmd.Flags().BoolVar(&opts.Deleted, "deleted", false, "list deleted links instead of live ones")
+
+	return cmd
+}
+
+func newSearchCommand(c *cli) *cobra.Command {
+	var limit, offset int
+
+	cmd := &cobra.Command{
+		Use:   "search QUERY",
+		Short: "Find links by code, URL, title, description, notes or tag, best matches first",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			page, err := api.Search(cmd.Context(), strings.Join(args, " "), limit, offset)
+			if err != nil {
+				return err
+			}
+
+			if c.json {
+				return printJSON(cmd.OutOrStdout(), page)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "CODE\tCLICKS\tCREATED\tURL")
+			for _, link := range page.Links {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", link.Code, link.ClickCount, link.CreatedAt.Format("2006-01-02"), link.URL)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			if page.Offset+len(page.Links) < page.Total {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%d of %d links, continue with --offset %d\n", len(page.Links), page.Total, page.Offset+len(page.Links))
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVar(&limit, "limit", 0, "links per page")
+	cmd.Flags().IntVar(&offset, "offset", 0, "links to skip")
+
+	return cmd
+}
+
+func newTagsCommand(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "tags",
+		Short: "List tags with their link and click counts, most used first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			tags, err := api.Tags(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			if c.json {
+				return printJSON(cmd.OutOrStdout(), tags)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "TAG\tLINKS\tCLICKS")
+			for _, tag := range tags {
+				fmt.Fprintf(w, "%s\t%d\t%d\n", tag.Tag, tag.Links, tag.Clicks)
+			}
+
+			return w.Flush()
+		},
+	}
+}
+
+func newFoldersCommand(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "folders",
+		Short: "List folders with their link counts",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			folders, err := api.Folders(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			if c.json {
+				return printJSON(cmd.OutOrStdout(), folders)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tLINKS\tPATH")
+			for _, folder := range folders {
+				fmt.Fprintf(w, "%d\t%d\t%s\n", folder.ID, folder.LinkCount, folder.Path)
+			}
+
+			return w.Flush()
+		},
+	}
+}
+
+func newMoveCommand(c *cli) *cobra.Command {
+	var folderID int
+
+	cmd := &cobra.Command{
+		Use:   "move CODE...",
+		Short: "Move links to a folder, or out of their folder without --folder",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			moved, err := api.MoveLinks(cmd.Context(), args, folderID)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "moved %d of %d links\n", moved, len(args))
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVar(&folderID, "folder", 0, "ID of the folder to move the links to")
+
+	return cmd
+}
+
+func newDeleteCommand(c *cli) *cobra.Command {
+	var deleteClicks bool
+
+	cmd := &cobra.Command{
+		Use:   "delete CODE...",
+		Short: "Delete links",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			for _, code := range args {
+				if err := api.Delete(cmd.Context(), code, deleteClicks); err != nil {
+					return fmt.Errorf("%s: %w", code, err)
+				}
+				fmt.Fprintln(cmd.ErrOrStderr(), "deleted "+code)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&deleteClicks, "delete-clicks", false, "remove the recorded clicks too")
+
+	return cmd
+}
+
+func newHistoryCommand(c *cli) *cobra.Command {
+	var limit, offset int
+
+	cmd := &cobra.Command{
+		Use:   "history CODE",
+		Short: "List the changes made to a link, newest first",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			page, err := api.History(cmd.Context(), args[0], limit, offset)
+			if err != nil {
+				return err
+			}
+
+			if c.json {
+				return printJSON(cmd.OutOrStdout(), page)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "TIME\tACTION\tMEMBER\tFIELD\tBEFORE\tAFTER")
+			for _, event := range page.Events {
+				member := "-"
+				if event.MemberID != nil {
+					member = strconv.Itoa(*event.MemberID)
+				}
+
+				fields := make([]string, 0, len(event.Changes))
+				for field := range event.Changes {
+					fields = append(fields, field)
+				}
+				sort.Strings(fields)
+
+				for _, field := range fields {
+					change := event.Changes[field]
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", event.CreatedAt.Format(time.RFC3339), event.Action, member, field, change.Before, change.After)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			if page.Offset+len(page.Events) < page.Total {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%d of %d changes, continue with --offset %d\n", len(page.Events), page.Total, page.Offset+len(page.Events))
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVar(&limit, "limit", 0, "changes per page")
+	cmd.Flags().IntVar(&offset, "offset", 0, "changes to skip")
+
+	return cmd
+}
+
+func newArchiveCommand(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "archive CODE...",
+		Short: "Archive links so that they stop redirecting but keep their stats",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			for _, code := range args {
+				if _, err := api.Archive(cmd.Context(), code); err != nil {
+					return fmt.Errorf("%s: %w", code, err)
+				}
+				fmt.Fprintln(cmd.ErrOrStderr(), "archived "+code)
+			}
+
+			return nil
+		},
+	}
+}
+
+func newRestoreCommand(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "restore CODE...",
+		Short: "Restore archived or deleted links",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			for _, code := range args {
+				if _, err := api.Restore(cmd.Context(), code); err != nil {
+					return fmt.Errorf("%s: %w", code, err)
+				}
+				fmt.Fprintln(cmd.ErrOrStderr(), "restored "+code)
+			}
+
+			return nil
+		},
+	}
+}
+
+func newAliasesCommand(c *cli) *cobra.Command {
+	var add, remove []string
+
+	cmd := &cobra.Command{
+		Use:   "aliases CODE",
+		Short: "List the codes of a link with their clicks, adding or removing aliases first",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			for _, alias := range remove {
+				if err := api.RemoveAlias(cmd.Context(), args[0], alias); err != nil {
+					return fmt.Errorf("%s: %w", alias, err)
+				}
+				fmt.Fprintln(cmd.ErrOrStderr(), "removed "+alias)
+			}
+
+			for _, alias := range add {
+				if _, err := api.AddAlias(cmd.Context(), args[0], alias); err != nil {
+					return fmt.Errorf("%s: %w", alias, err)
+				}
+				fmt.Fprintln(cmd.ErrOrStderr(), "added "+alias)
+			}
+
+			codes, err := api.CodeClicks(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+
+			if c.json {
+				return printJSON(cmd.OutOrStdout(), codes)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "CODE\tCLICKS")
+			for _, code := range codes {
+				fmt.Fprintf(w, "%s\t%d\n", code.Code, code.Clicks)
+			}
+
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&add, "add", nil, "aliases to add to the link")
+	cmd.Flags().StringSliceVar(&remove, "remove", nil, "aliases to remove from the link")
+
+	return cmd
+}
+
+func newCheckAliasCommand(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "check-alias ALIAS",
+		Short: "Tell whether an alias is free, suggesting similar free ones when it is not",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			availability, err := api.AliasAvailable(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+
+			if c.json {
+				return printJSON(cmd.OutOrStdout(), availability)
+			}
+
+			out := cmd.OutOrStdout()
+			if availability.Available {
+				fmt.Fprintln(out, availability.Alias+" is available")
+				return nil
+			}
+
+			fmt.Fprintln(out, availability.Message)
+			for _, suggestion := range availability.Suggestions {
+				fmt.Fprintln(out, "  "+suggestion)
+			}
+
+			return nil
+		},
+	}
+}
+
+func newDomainsCommand(c *cli) *cobra.Command {
+	var add []string
+	var verify, remove []int
+
+	cmd := &cobra.Command{
+		Use:   "domains",
+		Short: "List the custom domains of the organization, adding, verifying or removing domains first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			for _, id := range remove {
+				if err := api.RemoveDomain(cmd.Context(), id); err != nil {
+					return fmt.Errorf("%d: %w", id, err)
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "removed %d\n", id)
+			}
+
+			for _, hostname := range add {
+				if _, err := api.AddDomain(cmd.Context(), hostname); err != nil {
+					return fmt.Errorf("%s: %w", hostname, err)
+				}
+				fmt.Fprintln(cmd.ErrOrStderr(), "added "+hostname)
+			}
+
+			for _, id := range verify {
+				domain, err := api.VerifyDomain(cmd.Context(), id)
+				if err != nil {
+					return fmt.Errorf("%d: %w", id, err)
+				}
+				fmt.Fprintln(cmd.ErrOrStderr(), "verified "+domain.Hostname)
+			}
+
+			domains, err := api.Domains(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			if c.json {
+				return printJSON(cmd.OutOrStdout(), domains)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tHOSTNAME\tVERIFIED\tTXT RECORD\tTOKEN")
+			for _, domain := range domains {
+				verified, record, token := "yes", "", ""
+				if domain.Verification != nil {
+					verified, record, token = "no", domain.Verification.DNSName, domain.Verification.Token
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", domain.ID, domain.Hostname, verified, record, token)
+			}
+
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&add, "add", nil, "hostnames to register")
+	cmd.Flags().IntSliceVar(&verify, "verify", nil, "IDs of domains to verify")
+	cmd.Flags().IntSliceVar(&remove, "remove", nil, "IDs of domains to remove")
+
+	return cmd
+}
+
+func newExportCommand(c *cli) *cobra.Command {
+	var opts client.ExportOptions
+	var output string
+
+	cmd := &cobra.Command{
+		Use:       "export links|clicks",
+		Short:     "Export links or daily clicks as CSV or NDJSON",
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{client.ExportLinks, client.ExportClicks},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			api, err := c.client()
+			if err != nil {
+				return err
+			}
+
+			var w io.Writer = cmd.OutOrStdout()
+			if output != "" {
+				file, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				w = file
+			}
+
+			return api.Export(cmd.Context(), args[0], opts, w)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Format, "format", "", "csv or ndjson (default csv)")
+	cmd.Flags().StringVar(&opts.Code, "code", "", "only export the clicks of this link")
+	cmd.Flags().StringVar(&opts.From, "from", "", "first day of clicks, YYYY-MM-DD")
+	cmd.Flags().StringVar(&opts.To, "to", "", "last day of clicks, YYYY-MM-DD")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "write to a file instead of stdout")
+
+	return cmd
+}
+
+func printJSON(w io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
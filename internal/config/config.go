@@ -0,0 +1,225 @@
+// Package config resolves the settings of the server from command-line
+// flags, the environment, a .env file and an optional YAML file.
+//
+// Settings are named like environment variables, such as CACHE_TTL. The
+// same setting is the flag --cache-ttl and the YAML key cache_ttl. Flags
+// take precedence over the environment, which takes precedence over the
+// YAML file, which takes precedence over the defaults. Empty values count
+// as unset.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Kind is the type a setting is validated against.
+type Kind int
+
+const (
+	String Kind = iota
+	Int
+	Bool
+	Duration
+	// List is a comma separated list, or a sequence in the YAML file.
+	List
+)
+
+// Setting declares a setting, with its default in the form of an
+// environment variable.
+type Setting struct {
+	Name    string
+	Kind    Kind
+	Default string
+	Usage   string
+}
+
+// Config holds the resolved settings. Its getters panic for undeclared
+// names, which are programming errors.
+type Config struct {
+	settings map[string]Setting
+	values   map[string]string
+	args     []string
+}
+
+// Load resolves settings from arguments, the environment and the YAML file
+// named by --config or CONFIG_FILE. A .env file in the working directory
+// is read into the environment when there is one, without overriding
+// variables that are already set. It returns flag.ErrHelp after printing
+// the usage for -h.
+func Load(program string, settings []Setting, arguments []string) (*Config, error) {
+	c := &Config{
+		settings: make(map[string]Setting, len(settings)),
+		values:   make(map[string]string, len(settings)),
+	}
+
+	flags := flag.NewFlagSet(program, flag.ContinueOnError)
+	configFile := flags.String("config", "", "YAML file to read settings from, instead of CONFIG_FILE")
+
+	flagValues := make(map[string]*string, len(settings))
+	for _, setting := range settings {
+		c.settings[setting.Name] = setting
+		flagValues[setting.Name] = flags.String(flagName(setting.Name), "", setting.Usage)
+	}
+
+	if err := flags.Parse(arguments); err != nil {
+		return nil, err
+	}
+	c.args = flags.Args()
+
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading .env: %w", err)
+	}
+
+	if *configFile == "" {
+		*configFile = os.Getenv("CONFIG_FILE")
+	}
+
+	fileValues := make(map[string]string)
+	if *configFile != "" {
+		var err error
+		if fileValues, err = c.readFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, setting := range settings {
+		value := *flagValues[setting.Name]
+		if value == "" {
+			value = os.Getenv(setting.Name)
+		}
+		if value == "" {
+			value = fileValues[setting.Name]
+		}
+		if value == "" {
+			value = setting.Default
+		}
+
+		if err := validate(setting, value); err != nil {
+			return nil, err
+		}
+		c.values[setting.Name] = value
+	}
+
+	return c, nil
+}
+
+// readFile reads a YAML mapping of settings. Unknown keys are rejected, so
+// that typos do not go unnoticed.
+func (c *Config) readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(document))
+	for key, value := range document {
+		name := strings.ToUpper(key)
+		if _, ok := c.settings[name]; !ok {
+			return nil, fmt.Errorf("config file %s: unknown setting %q", path, key)
+		}
+
+		switch value := value.(type) {
+		case nil:
+		case []interface{}:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[name] = strings.Join(items, ",")
+		case map[string]interface{}:
+			return nil, fmt.Errorf("config file %s: %s must not be a mapping", path, key)
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+
+	return values, nil
+}
+
+func validate(setting Setting, value string) error {
+	if value == "" {
+		return nil
+	}
+
+	switch setting.Kind {
+	case Int:
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("%s must be an integer", setting.Name)
+		}
+	case Bool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s must be true or false", setting.Name)
+		}
+	case Duration:
+		if _, err := time.ParseDuration(value); err != nil {
+			return fmt.Errorf("%s must be a duration such as 5m", setting.Name)
+		}
+	}
+
+	return nil
+}
+
+// Args returns the arguments left after the flags.
+func (c *Config) Args() []string {
+	return c.args
+}
+
+func (c *Config) String(name string) string {
+	return c.value(name, String)
+}
+
+func (c *Config) Int(name string) int {
+	n, _ := strconv.Atoi(c.value(name, Int))
+	return n
+}
+
+func (c *Config) Bool(name string) bool {
+	b, _ := strconv.ParseBool(c.value(name, Bool))
+	return b
+}
+
+func (c *Config) Duration(name string) time.Duration {
+	d, _ := time.ParseDuration(c.value(name, Duration))
+	return d
+}
+
+// List returns the non-empty items of a list, trimmed.
+func (c *Config) List(name string) []string {
+	var items []string
+	for _, field := range strings.Split(c.value(name, List), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			items = append(items, field)
+		}
+	}
+	return items
+}
+
+func (c *Config) value(name string, kind Kind) string {
+	setting, ok := c.settings[name]
+	if !ok {
+		panic("config: undeclared setting " + name)
+	}
+	if setting.Kind != kind {
+		panic("config: setting " + name + " read as the wrong kind")
+	}
+
+	return c.values[name]
+}
+
+func flagName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+}
@@ -0,0 +1,5 @@
+// Package woweev1 holds the protobuf messages and gRPC stubs of the
+// wowee.v1 API, generated from links.proto.
+package woweev1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative wowee/v1/links.proto